@@ -0,0 +1,411 @@
+// The gRPC API of `go-agent serve --grpc-addr`. It hosts the same sessions as the REST
+// API, and its events carry what the JSON lines events of --log carry.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: agentpb/agent.proto
+
+package agentpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Agent_CreateSession_FullMethodName   = "/goagent.v1.Agent/CreateSession"
+	Agent_ListSessions_FullMethodName    = "/goagent.v1.Agent/ListSessions"
+	Agent_GetSession_FullMethodName      = "/goagent.v1.Agent/GetSession"
+	Agent_SendMessage_FullMethodName     = "/goagent.v1.Agent/SendMessage"
+	Agent_StreamEvents_FullMethodName    = "/goagent.v1.Agent/StreamEvents"
+	Agent_ResolveApproval_FullMethodName = "/goagent.v1.Agent/ResolveApproval"
+)
+
+// AgentClient is the client API for Agent service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Agent hosts conversations with the agent. Every call needs the metadata
+// "authorization: Bearer TOKEN", with SERVER_TOKEN or a token of SERVER_USER_TOKENS.
+type AgentClient interface {
+	// CreateSession starts an empty session.
+	CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*Session, error)
+	// ListSessions streams every session, oldest first.
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (Agent_ListSessionsClient, error)
+	// GetSession returns one session.
+	GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*Session, error)
+	// SendMessage runs a turn and streams its events, ending with the OUTCOME event.
+	// Canceling the call cancels the turn. It fails with FAILED_PRECONDITION while the
+	// session runs the previous message.
+	SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (Agent_SendMessageClient, error)
+	// StreamEvents streams the session's events, skipping the first `after`, and keeps
+	// streaming new ones until the call is canceled.
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Agent_StreamEventsClient, error)
+	// ResolveApproval answers the APPROVAL_REQUIRED event with the token. It fails with
+	// NOT_FOUND once the approval was answered or timed out.
+	ResolveApproval(ctx context.Context, in *ResolveApprovalRequest, opts ...grpc.CallOption) (*ResolveApprovalResponse, error)
+}
+
+type agentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentClient(cc grpc.ClientConnInterface) AgentClient {
+	return &agentClient{cc}
+}
+
+func (c *agentClient) CreateSession(ctx context.Context, in *CreateSessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, Agent_CreateSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (Agent_ListSessionsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Agent_ServiceDesc.Streams[0], Agent_ListSessions_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentListSessionsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_ListSessionsClient interface {
+	Recv() (*Session, error)
+	grpc.ClientStream
+}
+
+type agentListSessionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentListSessionsClient) Recv() (*Session, error) {
+	m := new(Session)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentClient) GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, Agent_GetSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (Agent_SendMessageClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Agent_ServiceDesc.Streams[1], Agent_SendMessage_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentSendMessageClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_SendMessageClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type agentSendMessageClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentSendMessageClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Agent_StreamEventsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Agent_ServiceDesc.Streams[2], Agent_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentStreamEventsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_StreamEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type agentStreamEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentStreamEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentClient) ResolveApproval(ctx context.Context, in *ResolveApprovalRequest, opts ...grpc.CallOption) (*ResolveApprovalResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResolveApprovalResponse)
+	err := c.cc.Invoke(ctx, Agent_ResolveApproval_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AgentServer is the server API for Agent service.
+// All implementations must embed UnimplementedAgentServer
+// for forward compatibility
+//
+// Agent hosts conversations with the agent. Every call needs the metadata
+// "authorization: Bearer TOKEN", with SERVER_TOKEN or a token of SERVER_USER_TOKENS.
+type AgentServer interface {
+	// CreateSession starts an empty session.
+	CreateSession(context.Context, *CreateSessionRequest) (*Session, error)
+	// ListSessions streams every session, oldest first.
+	ListSessions(*ListSessionsRequest, Agent_ListSessionsServer) error
+	// GetSession returns one session.
+	GetSession(context.Context, *GetSessionRequest) (*Session, error)
+	// SendMessage runs a turn and streams its events, ending with the OUTCOME event.
+	// Canceling the call cancels the turn. It fails with FAILED_PRECONDITION while the
+	// session runs the previous message.
+	SendMessage(*SendMessageRequest, Agent_SendMessageServer) error
+	// StreamEvents streams the session's events, skipping the first `after`, and keeps
+	// streaming new ones until the call is canceled.
+	StreamEvents(*StreamEventsRequest, Agent_StreamEventsServer) error
+	// ResolveApproval answers the APPROVAL_REQUIRED event with the token. It fails with
+	// NOT_FOUND once the approval was answered or timed out.
+	ResolveApproval(context.Context, *ResolveApprovalRequest) (*ResolveApprovalResponse, error)
+	mustEmbedUnimplementedAgentServer()
+}
+
+// UnimplementedAgentServer must be embedded to have forward compatible implementations.
+type UnimplementedAgentServer struct {
+}
+
+func (UnimplementedAgentServer) CreateSession(context.Context, *CreateSessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSession not implemented")
+}
+func (UnimplementedAgentServer) ListSessions(*ListSessionsRequest, Agent_ListSessionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedAgentServer) GetSession(context.Context, *GetSessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSession not implemented")
+}
+func (UnimplementedAgentServer) SendMessage(*SendMessageRequest, Agent_SendMessageServer) error {
+	return status.Errorf(codes.Unimplemented, "method SendMessage not implemented")
+}
+func (UnimplementedAgentServer) StreamEvents(*StreamEventsRequest, Agent_StreamEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedAgentServer) ResolveApproval(context.Context, *ResolveApprovalRequest) (*ResolveApprovalResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResolveApproval not implemented")
+}
+func (UnimplementedAgentServer) mustEmbedUnimplementedAgentServer() {}
+
+// UnsafeAgentServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AgentServer will
+// result in compilation errors.
+type UnsafeAgentServer interface {
+	mustEmbedUnimplementedAgentServer()
+}
+
+func RegisterAgentServer(s grpc.ServiceRegistrar, srv AgentServer) {
+	s.RegisterService(&Agent_ServiceDesc, srv)
+}
+
+func _Agent_CreateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).CreateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_CreateSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).CreateSession(ctx, req.(*CreateSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_ListSessions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListSessionsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).ListSessions(m, &agentListSessionsServer{ServerStream: stream})
+}
+
+type Agent_ListSessionsServer interface {
+	Send(*Session) error
+	grpc.ServerStream
+}
+
+type agentListSessionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentListSessionsServer) Send(m *Session) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Agent_GetSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_GetSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetSession(ctx, req.(*GetSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_SendMessage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SendMessageRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).SendMessage(m, &agentSendMessageServer{ServerStream: stream})
+}
+
+type Agent_SendMessageServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type agentSendMessageServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentSendMessageServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Agent_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).StreamEvents(m, &agentStreamEventsServer{ServerStream: stream})
+}
+
+type Agent_StreamEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type agentStreamEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentStreamEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Agent_ResolveApproval_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveApprovalRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).ResolveApproval(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_ResolveApproval_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).ResolveApproval(ctx, req.(*ResolveApprovalRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Agent_ServiceDesc is the grpc.ServiceDesc for Agent service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Agent_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "goagent.v1.Agent",
+	HandlerType: (*AgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateSession",
+			Handler:    _Agent_CreateSession_Handler,
+		},
+		{
+			MethodName: "GetSession",
+			Handler:    _Agent_GetSession_Handler,
+		},
+		{
+			MethodName: "ResolveApproval",
+			Handler:    _Agent_ResolveApproval_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListSessions",
+			Handler:       _Agent_ListSessions_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SendMessage",
+			Handler:       _Agent_SendMessage_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _Agent_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "agentpb/agent.proto",
+}
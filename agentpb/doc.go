@@ -0,0 +1,22 @@
+// Package agentpb is the gRPC API of a go-agent server started with
+// `go-agent serve --grpc-addr`, generated from agent.proto. Services in other languages
+// generate their stubs from the same file.
+//
+//	conn, err := grpc.NewClient("agent.internal:9090", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	...
+//	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+os.Getenv("SERVER_TOKEN"))
+//	agent := agentpb.NewAgentClient(conn)
+//	session, err := agent.CreateSession(ctx, &agentpb.CreateSessionRequest{})
+//	...
+//	events, err := agent.SendMessage(ctx, &agentpb.SendMessageRequest{SessionId: session.Id, Text: "Why does the build fail?"})
+//	...
+//	for {
+//		event, err := events.Recv()
+//		if err != nil {
+//			break // io.EOF after the OUTCOME event
+//		}
+//		fmt.Println(event.Type, event.Text)
+//	}
+package agentpb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative agentpb/agent.proto
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// =============================================================================
+// APPROVAL POLICY
+// =============================================================================
+
+// Approval policies for tools that change the workspace
+const (
+	approvalAuto = "auto" // Run mutating tools without asking
+	approvalAsk  = "ask"  // Ask the user before each mutating tool call
+	approvalDeny = "deny" // Refuse mutating tool calls and flag the run for a human
+)
+
+// stdinReader is shared by every prompt that reads stdin outside the chat loop. When
+// stdin was piped task input, questions are read from the terminal, if there is one.
+var stdinReader = sync.OnceValue(func() *bufio.Reader {
+	if runOptions.piped {
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			return bufio.NewReader(tty)
+		}
+	}
+	return bufio.NewReader(os.Stdin)
+})
+
+// approveTool decides whether a mutating tool call may run under the active policy. When
+// asked in the terminal, the user can answer always, which approves the same call in this
+// project from then on.
+func (a *Agent) approveTool(tool ToolDefinition, input json.RawMessage) bool {
+	switch runOptions.Approval {
+	case approvalDeny:
+		return false
+	case approvalAsk:
+		if a.approver != nil {
+			return a.confirm(fmt.Sprintf("Allow %s? [y/N] ", describeCall(tool, input)))
+		}
+		grant := grantFor(tool, input)
+		if granted(grant) {
+			fmt.Fprintf(os.Stderr, "%s: %s, remembered for this project (/permissions to revoke)\n", paint(roleInfo, "approved"), grant.describe())
+			a.events.Emit(Event{Type: eventStatus, Tool: tool.Name, Text: "approved by a remembered approval: " + grant.describe()})
+			return true
+		}
+		switch a.ask(fmt.Sprintf("Allow %s? [y/N/always] ", describeCall(tool, input))) {
+		case "y", "yes":
+			return true
+		case "a", alwaysChoice:
+			if err := remember(grant); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: failed to remember the approval: %s\n", paint(roleWarning, "warning"), err.Error())
+			} else {
+				a.events.Emit(Event{Type: eventStatus, Tool: tool.Name, Text: "approval remembered for this project: " + grant.describe()})
+			}
+			return true
+		default:
+			return false
+		}
+	default:
+		return true
+	}
+}
+
+// describeCall shows a tool call the way it is approved: the command line it runs, which
+// is also what answering always remembers, or its input for tools that run none
+func describeCall(tool ToolDefinition, input json.RawMessage) string {
+	if tool.Command != nil {
+		if line := tool.Command(input); line != "" {
+			return Grant{Tool: tool.Name, Command: line}.describe()
+		}
+	}
+	return fmt.Sprintf("%s(%s)", tool.Name, input)
+}
+
+// confirm asks the user a yes/no question, using the approver or chat input when there is one
+func (a *Agent) confirm(question string) bool {
+	answer := a.ask(question)
+	return answer == "y" || answer == "yes"
+}
+
+// ask puts a question to the user and returns the answer, trimmed and lowercased. An
+// approver answers "yes" or "no" for the user.
+func (a *Agent) ask(question string) string {
+	runOptions.notifications.notify(Notification{Kind: notifyApprovalNeeded, Title: "go-agent needs approval", Text: strings.TrimSuffix(strings.TrimSuffix(question, " [y/N] "), " [y/N/always] "), Session: a.session})
+	if a.approver != nil {
+		if a.approver(question) {
+			return "yes"
+		}
+		return "no"
+	}
+	prompt(question)
+
+	var answer string
+	if a.getUserMessage != nil {
+		answer, _ = a.getUserMessage()
+	} else {
+		answer, _ = stdinReader().ReadString('\n')
+	}
+	return strings.ToLower(strings.TrimSpace(answer))
+}
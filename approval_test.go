@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestApprovalPromptShowsCommandLine(t *testing.T) {
+	t.Setenv("GO_AGENT_HOME", t.TempDir())
+	defer func(approval string) { runOptions.Approval = approval }(runOptions.Approval)
+	runOptions.Approval = approvalAsk
+
+	tool := ToolDefinition{Name: "run_tests", Command: func(input json.RawMessage) string {
+		var args struct {
+			Args []string `json:"args"`
+		}
+		json.Unmarshal(input, &args)
+		return strings.Join(append([]string{"go test"}, args.Args...), " ") + " ./..."
+	}}
+	input := json.RawMessage(`{"args": ["-run", "Foo"]}`)
+
+	asked := ""
+	approver := &Agent{approver: func(question string) bool {
+		asked = question
+		return false
+	}}
+	approver.approveTool(tool, input)
+	if want := "Allow run_tests `go test -run Foo ./...`? [y/N] "; asked != want {
+		t.Errorf("asked %q, want %q", asked, want)
+	}
+
+	log := bytes.Buffer{}
+	answers := []string{"always", "no"}
+	agent := &Agent{events: NewEventLog(&log), getUserMessage: func() (string, bool) {
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, true
+	}}
+	if !agent.approveTool(tool, input) {
+		t.Fatal("answering always did not approve the call")
+	}
+	if !agent.approveTool(tool, input) {
+		t.Error("the remembered command line was asked about again")
+	}
+	if agent.approveTool(tool, json.RawMessage(`{"args": ["-count", "1"]}`)) {
+		t.Error("another command line of the tool was approved by the remembered one")
+	}
+	if !strings.Contains(log.String(), "approved by a remembered approval: run_tests `go test -run Foo ./...`") {
+		t.Errorf("no status event names the remembered command line:\n%s", log.String())
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code-agent/client"
+)
+
+// =============================================================================
+// WATCHING SESSIONS (ATTACH)
+// =============================================================================
+
+// watcherDrainTimeout bounds how long an exiting session waits for watchers to catch up
+const watcherDrainTimeout = 2 * time.Second
+
+// watchDir holds the sockets of sessions started with --attachable. It is private to the
+// user, so only they can watch their sessions.
+func watchDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "go-agent")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("go-agent-%d", os.Getuid()))
+}
+
+// sessionWatchers publishes this process's events on a Unix socket
+type sessionWatchers struct {
+	path     string
+	listener net.Listener
+	events   *eventRecorder
+	streams  sync.WaitGroup
+}
+
+// listenForWatchers creates the socket `go-agent attach <pid>` connects to
+func listenForWatchers() (*sessionWatchers, error) {
+	if err := os.MkdirAll(watchDir(), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", watchDir(), err)
+	}
+	path := filepath.Join(watchDir(), strconv.Itoa(os.Getpid())+".sock")
+	os.Remove(path) // Left behind by an earlier process with the same pid
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for watchers: %w", err)
+	}
+
+	watchers := &sessionWatchers{path: path, listener: listener, events: newEventRecorder()}
+	go watchers.accept()
+	return watchers, nil
+}
+
+// accept streams every event so far, then new ones, to each watcher that connects
+func (w *sessionWatchers) accept() {
+	for {
+		conn, err := w.listener.Accept()
+		if err != nil {
+			return
+		}
+		w.streams.Add(1)
+		go func() {
+			defer w.streams.Done()
+			defer conn.Close()
+
+			// Watchers are read-only; anything they send only tells us they went away
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				io.Copy(io.Discard, conn)
+				cancel()
+			}()
+			w.events.stream(ctx, conn, 0)
+		}()
+	}
+}
+
+// close lets connected watchers receive the last events, then removes the socket
+func (w *sessionWatchers) close() {
+	if w == nil {
+		return
+	}
+	w.listener.Close()
+	w.events.close()
+
+	drained := make(chan struct{})
+	go func() {
+		w.streams.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(watcherDrainTimeout):
+	}
+	os.Remove(w.path)
+}
+
+// runAttachCommand implements `go-agent attach [session]`
+func runAttachCommand(args []string) error {
+	flags := flag.NewFlagSet("attach", flag.ContinueOnError)
+	server := flags.String("server", configValue("SERVER_URL"), "watch a session of the go-agent server at this URL instead of a local one")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	ctx := context.TODO()
+	if flags.NArg() == 0 {
+		if *server != "" {
+			return listServerSessions(ctx, client.New(*server, configValue("SERVER_TOKEN")))
+		}
+		return listLocalSessions()
+	}
+	if flags.NArg() > 1 {
+		return usageErrorf("usage: go-agent attach [--server URL] [session]")
+	}
+	id := flags.Arg(0)
+
+	var stream *client.EventStream
+	if *server != "" {
+		var err error
+		stream, err = client.New(*server, configValue("SERVER_TOKEN")).Events(ctx, id, 0)
+		if err != nil {
+			return err
+		}
+	} else {
+		conn, err := net.Dial("unix", filepath.Join(watchDir(), id+".sock"))
+		if err != nil {
+			return fmt.Errorf("no attachable session %s; run `go-agent attach` to list them", id)
+		}
+		stream = client.NewEventStream(conn)
+	}
+	defer stream.Close()
+
+	fmt.Printf("Watching session %s read-only (use 'ctrl-c' to stop)\n", id)
+	for {
+		event, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			fmt.Println("The session ended.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		printWatchedEvent(event)
+	}
+}
+
+// listLocalSessions prints the attachable sessions of this user, removing stale sockets
+func listLocalSessions() error {
+	sockets, _ := filepath.Glob(filepath.Join(watchDir(), "*.sock"))
+	sessions := []string{}
+	for _, path := range sockets {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		conn.Close()
+		sessions = append(sessions, strings.TrimSuffix(filepath.Base(path), ".sock"))
+	}
+
+	if len(sessions) == 0 {
+		fmt.Println("No attachable sessions. Start one with `go-agent --attachable`.")
+		return nil
+	}
+	sort.Strings(sessions)
+	for _, session := range sessions {
+		fmt.Println(session)
+	}
+	return nil
+}
+
+// listServerSessions prints the server's sessions
+func listServerSessions(ctx context.Context, c *client.Client) error {
+	sessions, err := c.Sessions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		fmt.Printf("%s  %s  %s\n", session.ID, session.Created.Local().Format(time.DateTime), session.Status)
+	}
+	return nil
+}
+
+// printWatchedEvent prints an event the way the watched session printed it
+func printWatchedEvent(event client.Event) {
+	switch event.Type {
+	case client.EventUserMessage:
+		fmt.Printf("%s: %s\n", paint(roleUser, "You"), event.Text)
+	case client.EventThinking:
+		printThinking(event.Text)
+	case client.EventAssistantText:
+		printAnswer(event.Text)
+		citations := []Citation{}
+		for _, citation := range event.Citations {
+			citations = append(citations, Citation(citation))
+		}
+		printCitations(citations)
+	case client.EventToolUse:
+		fmt.Printf("%s: %s(%s)\n", paint(roleTool, "tool"), event.Tool, compactJSON(event.Input))
+	case client.EventToolResult:
+		if event.IsError {
+			fmt.Printf("%s: %s\n", paint(roleError, event.Tool+" failed"), truncateRunes(event.Text, 500))
+		}
+	case client.EventToolDenied:
+		fmt.Printf("%s: %s denied by approval policy\n", paint(roleTool, "tool"), event.Tool)
+	case client.EventApprovalRequired, client.EventApprovalResolved:
+		fmt.Printf("%s: %s\n", paint(roleNotice, "approval"), event.Text)
+	case client.EventTodo:
+		fmt.Printf("%s:\n%s", paint(roleInfo, "todo"), event.Text)
+	case client.EventProgress:
+		if event.Progress != nil {
+			progress := Progress{Done: event.Progress.Done, Total: event.Progress.Total, Current: event.Progress.Current}
+			fmt.Printf("%s: %s %s\n", paint(roleTool, "tool"), event.Tool, renderProgress(progress))
+		}
+	case client.EventEditProposed:
+		fmt.Printf("%s: proposed edit\n%s", paint(roleTool, "tool"), event.Text)
+	case client.EventStatus:
+		fmt.Printf("%s: %s\n", paint(roleStatus, "status"), event.Text)
+	case client.EventError:
+		fmt.Printf("%s: %s\n", paint(roleError, "error"), event.Text)
+	case client.EventOutcome:
+		fmt.Printf("%s: %s\n", paint(roleStatus, "outcome"), event.Outcome)
+	}
+}
+
+// compactJSON renders raw JSON on one line
+func compactJSON(raw json.RawMessage) string {
+	var buffer bytes.Buffer
+	if err := json.Compact(&buffer, raw); err != nil {
+		return string(raw)
+	}
+	return buffer.String()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// ATTACHMENT BUDGET MANAGER
+// =============================================================================
+
+// contextWindowTokens is the context window of the models we talk to
+const contextWindowTokens = 200_000
+
+// defaultAttachmentBudgetPercent is the share of the context window attachments may use
+const defaultAttachmentBudgetPercent = 25
+
+// minTruncatedTokens is the smallest slice of a file worth keeping after truncation
+const minTruncatedTokens = 500
+
+// Attachment is a file the user pulled into a message with an @mention
+type Attachment struct {
+	Path      string // Path as written after the @
+	Content   string // File contents, possibly truncated to fit the budget
+	PDF       []byte // The file, for PDFs, which are sent as they are rather than as text
+	Tokens    int    // Estimated token count of the original contents
+	Relevance int    // Number of prompt terms found in the path or contents
+	Truncated bool   // Whether Content was cut down to fit the budget
+}
+
+// AttachmentReport describes what the budget manager did with a message's attachments
+type AttachmentReport struct {
+	Included  []*Attachment
+	Truncated []*Attachment
+	Dropped   []*Attachment
+}
+
+// estimateTokens roughly approximates the token count of text (about 4 bytes per token)
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// attachmentBudgetTokens returns the token budget for all attachments in one message
+func attachmentBudgetTokens() int {
+	percent := configInt("ATTACHMENT_BUDGET_PERCENT", defaultAttachmentBudgetPercent)
+	if percent <= 0 || percent > 100 {
+		percent = defaultAttachmentBudgetPercent
+	}
+	return contextWindowTokens * percent / 100
+}
+
+// parseMentions extracts attachments from @path mentions that refer to existing files
+func parseMentions(input string) []*Attachment {
+	attachments := []*Attachment{}
+	seen := map[string]bool{}
+
+	for _, word := range strings.Fields(input) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		path := strings.TrimRight(strings.TrimPrefix(word, "@"), ",.;:!?)\"'")
+		if path == "" || seen[path] || isImagePath(path) {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		attachment, err := readAttachment(path)
+		if err != nil {
+			continue
+		}
+
+		seen[path] = true
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments
+}
+
+// promptTerms returns the distinct lowercase words of the prompt used for ranking
+func promptTerms(input string) []string {
+	terms := []string{}
+	seen := map[string]bool{}
+
+	for _, word := range strings.Fields(strings.ToLower(input)) {
+		if strings.HasPrefix(word, "@") {
+			continue
+		}
+		word = strings.Trim(word, ",.;:!?()\"'`")
+		if len(word) < 3 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+
+	return terms
+}
+
+// budgetAttachments ranks attachments by relevance and size and fits them into budget tokens
+func budgetAttachments(input string, attachments []*Attachment, budget int) AttachmentReport {
+	terms := promptTerms(input)
+	for _, attachment := range attachments {
+		haystack := strings.ToLower(attachment.Path + "\n" + attachment.Content)
+		for _, term := range terms {
+			if strings.Contains(haystack, term) {
+				attachment.Relevance++
+			}
+		}
+	}
+
+	// Most relevant first; among equals, smaller files first so more of them fit
+	ranked := append([]*Attachment{}, attachments...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Relevance != ranked[j].Relevance {
+			return ranked[i].Relevance > ranked[j].Relevance
+		}
+		return ranked[i].Tokens < ranked[j].Tokens
+	})
+
+	report := AttachmentReport{}
+	remaining := budget
+	for _, attachment := range ranked {
+		switch {
+		case attachment.Tokens <= remaining:
+			remaining -= attachment.Tokens
+			report.Included = append(report.Included, attachment)
+		case remaining >= minTruncatedTokens && attachment.PDF == nil:
+			attachment.Content = truncateToTokens(attachment.Content, remaining)
+			attachment.Truncated = true
+			remaining = 0
+			report.Included = append(report.Included, attachment)
+			report.Truncated = append(report.Truncated, attachment)
+		default:
+			report.Dropped = append(report.Dropped, attachment)
+		}
+	}
+
+	return report
+}
+
+// truncateToTokens keeps roughly the first tokens worth of text, cut at a line boundary
+func truncateToTokens(text string, tokens int) string {
+	limit := tokens * 4
+	if limit >= len(text) {
+		return text
+	}
+
+	cut := text[:limit]
+	if i := strings.LastIndexByte(cut, '\n'); i > 0 {
+		cut = cut[:i+1]
+	}
+
+	return cut + fmt.Sprintf("\n... [truncated: kept %d of ~%d tokens]\n", estimateTokens(cut), estimateTokens(text))
+}
+
+// buildUserMessage turns raw user input into content blocks, attaching any @mentioned files
+// and images
+func (a *Agent) buildUserMessage(input string) []anthropic.ContentBlockParamUnion {
+	blocks := []anthropic.ContentBlockParamUnion{anthropic.NewTextBlock(input)}
+	a.events.Emit(Event{Type: eventUserMessage, Text: input})
+
+	for _, image := range parseImageMentions(input) {
+		fmt.Fprintf(os.Stderr, "%s: image %s (%d KB)\n", paint(roleInfo, "attach"), image.Path, (len(image.Data)+1023)/1024)
+		a.events.Emit(Event{Type: eventStatus, Text: "attached image " + image.Path})
+		blocks = append(blocks, imageBlock(image))
+	}
+
+	attachments := parseMentions(input)
+	if len(attachments) == 0 {
+		return blocks
+	}
+
+	report := budgetAttachments(input, attachments, attachmentBudgetTokens())
+	report.Print()
+	for _, attachment := range report.Dropped {
+		a.events.Emit(Event{Type: eventStatus, Text: "dropped attachment " + attachment.Path})
+	}
+
+	for _, attachment := range report.Included {
+		if block, ok := a.uploadedBlock(context.TODO(), attachment); ok {
+			blocks = append(blocks, block)
+			continue
+		}
+		if attachment.PDF != nil {
+			blocks = append(blocks, pdfBlock(attachment))
+			continue
+		}
+		if citeable(attachment.Path) {
+			blocks = append(blocks, documentBlock(attachment))
+			continue
+		}
+		blocks = append(blocks, anthropic.NewTextBlock(
+			fmt.Sprintf("<file path=%q>\n%s\n</file>", attachment.Path, attachment.Content),
+		))
+	}
+
+	// Let Claude know what it is missing so it can fall back to read_file
+	if len(report.Dropped) > 0 {
+		paths := []string{}
+		for _, attachment := range report.Dropped {
+			paths = append(paths, attachment.Path)
+		}
+		blocks = append(blocks, anthropic.NewTextBlock(
+			"These attachments were omitted to stay within the context budget: "+strings.Join(paths, ", "),
+		))
+	}
+
+	return blocks
+}
+
+// Print reports truncated and dropped attachments to the user
+func (r AttachmentReport) Print() {
+	for _, attachment := range r.Truncated {
+		fmt.Fprintf(os.Stderr, "%s: truncated %s (~%d tokens)\n", paint(roleNotice, "attachments"), attachment.Path, attachment.Tokens)
+	}
+	for _, attachment := range r.Dropped {
+		fmt.Fprintf(os.Stderr, "%s: dropped %s (~%d tokens, over budget)\n", paint(roleNotice, "attachments"), attachment.Path, attachment.Tokens)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// =============================================================================
+// AUDIT TRAIL
+// =============================================================================
+
+// AuditEntry is one line of the audit trail: an event chained to the entry before it
+type AuditEntry struct {
+	Seq       int             `json:"seq"`
+	Prev      string          `json:"prev"` // Hash of the previous entry, empty for the first
+	Event     json.RawMessage `json:"event"`
+	Hash      string          `json:"hash"`
+	Signature string          `json:"sig,omitempty"` // Ed25519 signature of the hash, when a key is configured
+}
+
+// auditHash chains an entry to the previous one
+func auditHash(seq int, prev string, event []byte) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\n%s\n%s", seq, prev, event)))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditLog is an io.Writer for an EventLog that writes each event as a chained entry
+type auditLog struct {
+	mu   sync.Mutex
+	w    io.Writer
+	key  ed25519.PrivateKey
+	seq  int
+	prev string
+}
+
+// newAuditLog creates an audit trail in path, signing entries with the key in
+// AUDIT_SIGNING_KEY when it is set
+func newAuditLog(path string) (*auditLog, error) {
+	log := &auditLog{}
+	if keyPath := configValue("AUDIT_SIGNING_KEY"); keyPath != "" {
+		key, err := readAuditKey(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		log.key = key
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit trail (it must not exist yet): %w", err)
+	}
+	log.w = file
+	return log, nil
+}
+
+// Write implements io.Writer for one JSON line written by an EventLog
+func (l *auditLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event := bytes.TrimSpace(p)
+	l.seq++
+	entry := AuditEntry{Seq: l.seq, Prev: l.prev, Event: event, Hash: auditHash(l.seq, l.prev, event)}
+	if l.key != nil {
+		entry.Signature = hex.EncodeToString(ed25519.Sign(l.key, []byte(entry.Hash)))
+	}
+	l.prev = entry.Hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// runAuditCommand implements `go-agent audit keygen|verify`
+func runAuditCommand(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("usage: go-agent audit keygen --out FILE | go-agent audit verify [--key FILE.pub] [--expect-head HASH] [--expect-count N] TRAIL")
+	}
+
+	switch args[0] {
+	case "keygen":
+		flags := flag.NewFlagSet("audit keygen", flag.ContinueOnError)
+		out := flags.String("out", "audit-key", "private key file; the public key is written next to it with a .pub suffix")
+		if err := flags.Parse(args[1:]); err != nil {
+			return usageError{err}
+		}
+		return generateAuditKey(*out)
+	case "verify":
+		flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+		keyPath := flags.String("key", "", "public key the entries must be signed with")
+		expectHead := flags.String("expect-head", "", "hash the last entry must have, as printed by an earlier verify")
+		expectCount := flags.Int("expect-count", 0, "number of entries the trail must have")
+		if err := flags.Parse(args[1:]); err != nil {
+			return usageError{err}
+		}
+		if flags.NArg() != 1 {
+			return usageErrorf("usage: go-agent audit verify [--key FILE.pub] [--expect-head HASH] [--expect-count N] TRAIL")
+		}
+		return verifyAuditTrail(flags.Arg(0), *keyPath, *expectHead, *expectCount)
+	}
+	return fmt.Errorf("unknown audit command %q", args[0])
+}
+
+// verifyAuditTrail checks that every entry is intact, in order and, with a key, signed,
+// and prints the number of entries and the hash of the last one. The chain cannot show
+// entries cut off its end, so a head or count recorded elsewhere is checked as well.
+func verifyAuditTrail(path, keyPath, expectHead string, expectCount int) error {
+	var key ed25519.PublicKey
+	if keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s is not a PEM public key", keyPath)
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		var ok bool
+		if key, ok = parsed.(ed25519.PublicKey); err != nil || !ok {
+			return fmt.Errorf("%s is not an Ed25519 public key", keyPath)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	count, head, err := checkAuditTrail(file, key)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%s has no entries", path)
+	}
+	if expectCount > 0 && count != expectCount {
+		return fmt.Errorf("%s has %d entries, expected %d; entries were cut off or added at the end", path, count, expectCount)
+	}
+	if expectHead != "" && head != expectHead {
+		return fmt.Errorf("%s ends at %s, expected %s; entries were cut off or added at the end", path, head, expectHead)
+	}
+
+	signatures := "signatures not checked"
+	if key != nil {
+		signatures = "all signed by " + keyPath
+	}
+	fmt.Printf("%s: %d entries verified (%s)\nhead %s\n", path, count, signatures, head)
+	return nil
+}
+
+// checkAuditTrail reads a trail, checking each entry against the one before it and, when
+// key is set, its signature. It returns the number of entries and the hash of the last.
+func checkAuditTrail(r io.Reader, key ed25519.PublicKey) (int, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	prev, seq := "", 0
+	for scanner.Scan() {
+		seq++
+		entry := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return 0, "", fmt.Errorf("line %d is not an audit entry: %w", seq, err)
+		}
+		switch {
+		case entry.Seq != seq:
+			return 0, "", fmt.Errorf("line %d: expected entry %d, found %d; entries were removed or reordered", seq, seq, entry.Seq)
+		case entry.Prev != prev:
+			return 0, "", fmt.Errorf("entry %d does not follow entry %d; the trail was altered", seq, seq-1)
+		case entry.Hash != auditHash(entry.Seq, entry.Prev, entry.Event):
+			return 0, "", fmt.Errorf("entry %d does not match its hash; the event was altered", seq)
+		}
+		if key != nil {
+			signature, err := hex.DecodeString(entry.Signature)
+			if err != nil || !ed25519.Verify(key, []byte(entry.Hash), signature) {
+				return 0, "", fmt.Errorf("entry %d is not signed by the key", seq)
+			}
+		}
+		prev = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, "", err
+	}
+	return seq, prev, nil
+}
+
+// generateAuditKey writes a new Ed25519 key pair for signing audit trails
+func generateAuditKey(path string) error {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return err
+	}
+
+	if err := writeNewFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600); err != nil {
+		return err
+	}
+	if err := writeNewFile(path+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s and %s. Set AUDIT_SIGNING_KEY=%s to sign audit trails.\n", path, path+".pub", path)
+	return nil
+}
+
+// readAuditKey loads the private key written by `audit keygen`
+func readAuditKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	key, ok := parsed.(ed25519.PrivateKey)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+	return key, nil
+}
+
+// writeNewFile writes data to path, refusing to overwrite an existing file
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// auditTrail writes events to a new trail, signed when key is set, and returns its lines
+// with their newlines
+func auditTrail(t *testing.T, key ed25519.PrivateKey, events ...string) []string {
+	t.Helper()
+	trail := &bytes.Buffer{}
+	log := &auditLog{w: trail, key: key}
+	for _, event := range events {
+		if _, err := log.Write([]byte(event + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := strings.SplitAfter(trail.String(), "\n")
+	return lines[:len(lines)-1]
+}
+
+func TestCheckAuditTrail(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	lines := auditTrail(t, private, `{"type":"user","text":"a"}`, `{"type":"tool_use","tool":"edit_file"}`, `{"type":"outcome"}`)
+	head := AuditEntry{}
+	json.Unmarshal([]byte(lines[2]), &head)
+
+	// rehashed replaces the event of the second entry and fixes up its hash
+	rehashed := AuditEntry{}
+	json.Unmarshal([]byte(lines[1]), &rehashed)
+	rehashed.Event = json.RawMessage(`{"type":"tool_use","tool":"run_command"}`)
+	rehashed.Hash = auditHash(rehashed.Seq, rehashed.Prev, rehashed.Event)
+	rehashedLine, _ := json.Marshal(rehashed)
+
+	tests := []struct {
+		name  string
+		lines []string
+		key   ed25519.PublicKey
+		count int
+		err   string
+	}{
+		{"intact", lines, public, 3, ""},
+		{"signatures not checked", lines, nil, 3, ""},
+		{"event changed", []string{lines[0], strings.Replace(lines[1], "edit_file", "run_command", 1), lines[2]}, nil, 0, "entry 2 does not match its hash"},
+		{"event changed and rehashed", []string{lines[0], string(rehashedLine) + "\n", lines[2]}, nil, 0, "entry 3 does not follow entry 2"},
+		{"reordered", []string{lines[0], lines[2], lines[1]}, nil, 0, "expected entry 2, found 3"},
+		{"entry removed", []string{lines[0], lines[2]}, nil, 0, "expected entry 2, found 3"},
+		{"first entry removed", lines[1:], nil, 0, "expected entry 1, found 2"},
+		{"last line cut short", []string{lines[0], lines[1], lines[2][:len(lines[2])/2]}, nil, 0, "line 3 is not an audit entry"},
+		{"unsigned", auditTrail(t, nil, `{"type":"user"}`), public, 0, "entry 1 is not signed"},
+		{"signed by another key", auditTrail(t, ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)), `{"type":"user"}`), public, 0, "entry 1 is not signed"},
+		// The chain cannot tell that entries are missing from its end
+		{"last entry removed", lines[:2], public, 2, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count, last, err := checkAuditTrail(strings.NewReader(strings.Join(test.lines, "")), test.key)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("got %v, want an error containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil || count != test.count {
+				t.Fatalf("got %d entries and %v, want %d entries", count, err, test.count)
+			}
+			if count == 3 && last != head.Hash {
+				t.Errorf("head is %s, want %s", last, head.Hash)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditTrailDetectsTruncation(t *testing.T) {
+	lines := auditTrail(t, nil, `{"type":"user","text":"a"}`, `{"type":"outcome"}`)
+	head := AuditEntry{}
+	json.Unmarshal([]byte(lines[1]), &head)
+	full := filepath.Join(t.TempDir(), "full.jsonl")
+	truncated := filepath.Join(t.TempDir(), "truncated.jsonl")
+	os.WriteFile(full, []byte(strings.Join(lines, "")), 0o600)
+	os.WriteFile(truncated, []byte(lines[0]), 0o600)
+
+	if err := verifyAuditTrail(full, "", head.Hash, 2); err != nil {
+		t.Errorf("the full trail with its head and count: %v", err)
+	}
+	if err := verifyAuditTrail(truncated, "", "", 0); err != nil {
+		t.Errorf("the truncated trail without expectations: %v", err)
+	}
+	if err := verifyAuditTrail(truncated, "", head.Hash, 0); err == nil || !strings.Contains(err.Error(), "cut off") {
+		t.Errorf("the truncated trail with the head of the full one: %v", err)
+	}
+	if err := verifyAuditTrail(truncated, "", "", 2); err == nil || !strings.Contains(err.Error(), "has 1 entries, expected 2") {
+		t.Errorf("the truncated trail with the count of the full one: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// =============================================================================
+// BITBUCKET CLIENT
+// =============================================================================
+
+// BitbucketClient calls the Bitbucket Cloud REST API (2.0) for one repository
+type BitbucketClient struct {
+	restClient
+	repo string // workspace/slug
+}
+
+// NewBitbucketClient configures a client from BITBUCKET_TOKEN, preferring the variable
+// Bitbucket Pipelines sets over the repository of the origin remote
+func NewBitbucketClient(repo string) (*BitbucketClient, error) {
+	if env := os.Getenv("BITBUCKET_REPO_FULL_NAME"); env != "" {
+		repo = env
+	}
+	token := configValue("BITBUCKET_TOKEN")
+	if token == "" || repo == "" {
+		return nil, fmt.Errorf("BITBUCKET_TOKEN and BITBUCKET_REPO_FULL_NAME (or a Bitbucket origin remote) are required")
+	}
+
+	baseURL := configValue("BITBUCKET_API_URL")
+	if baseURL == "" {
+		baseURL = "https://api.bitbucket.org/2.0"
+	}
+
+	return &BitbucketClient{
+		restClient: newRESTClient(baseURL+"/repositories/"+repo, map[string]string{
+			"Authorization": "Bearer " + token,
+		}),
+		repo: repo,
+	}, nil
+}
+
+// Name implements Forge
+func (c *BitbucketClient) Name() string { return forgeBitbucket }
+
+// ChangeKind implements Forge
+func (c *BitbucketClient) ChangeKind() string { return "pull request" }
+
+// bitbucketUser identifies the author of an issue, pull request or comment
+type bitbucketUser struct {
+	DisplayName string `json:"display_name"`
+	Nickname    string `json:"nickname"`
+	UUID        string `json:"uuid"`
+}
+
+// bitbucketComment is a comment on an issue or pull request
+type bitbucketComment struct {
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+	User bitbucketUser `json:"user"`
+}
+
+// itemPath is the API path of an issue or pull request
+func (c *BitbucketClient) itemPath(ref ForgeRef) string {
+	if ref.Change {
+		return fmt.Sprintf("/pullrequests/%d", ref.Number)
+	}
+	return fmt.Sprintf("/issues/%d", ref.Number)
+}
+
+// comments fetches the first page of comments on an issue or pull request
+func (c *BitbucketClient) comments(ctx context.Context, ref ForgeRef) ([]forgeComment, error) {
+	var page struct {
+		Values []bitbucketComment `json:"values"`
+	}
+	if err := c.getJSON(ctx, c.itemPath(ref)+"/comments?pagelen=100", &page); err != nil {
+		return nil, err
+	}
+
+	comments := []forgeComment{}
+	for _, comment := range page.Values {
+		comments = append(comments, forgeComment{Author: comment.User.DisplayName, Body: comment.Content.Raw})
+	}
+	return comments, nil
+}
+
+// Issue implements Forge
+func (c *BitbucketClient) Issue(ctx context.Context, number int) (string, error) {
+	ref := ForgeRef{Number: number}
+	var issue struct {
+		ID      int    `json:"id"`
+		Title   string `json:"title"`
+		State   string `json:"state"`
+		Content struct {
+			Raw string `json:"raw"`
+		} `json:"content"`
+		Reporter bitbucketUser `json:"reporter"`
+	}
+	if err := c.getJSON(ctx, c.itemPath(ref), &issue); err != nil {
+		return "", err
+	}
+	comments, err := c.comments(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return formatDiscussion(issue.ID, issue.Title, issue.State, issue.Reporter.DisplayName, issue.Content.Raw, comments), nil
+}
+
+// Change implements Forge
+func (c *BitbucketClient) Change(ctx context.Context, number int) (string, error) {
+	ref := ForgeRef{Number: number, Change: true}
+	var pr struct {
+		ID          int           `json:"id"`
+		Title       string        `json:"title"`
+		State       string        `json:"state"`
+		Description string        `json:"description"`
+		Author      bitbucketUser `json:"author"`
+	}
+	if err := c.getJSON(ctx, c.itemPath(ref), &pr); err != nil {
+		return "", err
+	}
+	comments, err := c.comments(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	diff, err := c.do(ctx, http.MethodGet, c.itemPath(ref)+"/diff", nil, "text/plain")
+	if err != nil {
+		return "", err
+	}
+	return formatDiscussion(pr.ID, pr.Title, pr.State, pr.Author.DisplayName, pr.Description, comments) + "\n\nDiff:\n" + string(diff), nil
+}
+
+// Comment implements Forge
+func (c *BitbucketClient) Comment(ctx context.Context, ref ForgeRef, body string) error {
+	request := map[string]any{"content": map[string]string{"raw": body}}
+	return c.sendJSON(ctx, http.MethodPost, c.itemPath(ref)+"/comments", request, nil)
+}
+
+// CreateChange implements Forge
+func (c *BitbucketClient) CreateChange(ctx context.Context, head, base, title, body string) (string, error) {
+	var created struct {
+		Links struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	}
+	request := map[string]any{
+		"title":       title,
+		"description": body,
+		"source":      map[string]any{"branch": map[string]string{"name": head}},
+		"destination": map[string]any{"branch": map[string]string{"name": base}},
+	}
+	if err := c.sendJSON(ctx, http.MethodPost, "/pullrequests", request, &created); err != nil {
+		return "", err
+	}
+	return created.Links.HTML.Href, nil
+}
+
+// isTrusted reports whether the user is listed in BITBUCKET_TRUSTED_USERS, since the
+// Bitbucket API offers repository permissions only to workspace admins
+func (c *BitbucketClient) isTrusted(user bitbucketUser) bool {
+	trusted := strings.Split(configValue("BITBUCKET_TRUSTED_USERS"), ",")
+	for i := range trusted {
+		trusted[i] = strings.TrimSpace(trusted[i])
+	}
+	return (user.Nickname != "" && slices.Contains(trusted, user.Nickname)) ||
+		(user.UUID != "" && slices.Contains(trusted, user.UUID))
+}
+
+// verifyWebhook implements webhookForge using the X-Hub-Signature header
+func (c *BitbucketClient) verifyWebhook(header http.Header, body []byte, secret string) bool {
+	return validHMAC(header.Get("X-Hub-Signature"), body, secret)
+}
+
+// webhookTask implements webhookForge for comment and pull request created events
+func (c *BitbucketClient) webhookTask(ctx context.Context, header http.Header, body []byte) (*forgeTask, string) {
+	type item struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+	}
+	var event struct {
+		Actor       bitbucketUser     `json:"actor"`
+		PullRequest *item             `json:"pullrequest"`
+		Issue       *item             `json:"issue"`
+		Comment     *bitbucketComment `json:"comment"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, "invalid payload"
+	}
+
+	switch key := header.Get("X-Event-Key"); key {
+	case "pullrequest:comment_created", "issue:comment_created":
+		target, change := event.Issue, false
+		if key == "pullrequest:comment_created" {
+			target, change = event.PullRequest, true
+		}
+		if target == nil || event.Comment == nil {
+			return nil, "invalid payload"
+		}
+		if !strings.HasPrefix(strings.TrimSpace(event.Comment.Content.Raw), agentCommandPrefix) {
+			return nil, "comment does not start with " + agentCommandPrefix
+		}
+		if !c.isTrusted(event.Actor) {
+			return nil, event.Actor.DisplayName + " is not in BITBUCKET_TRUSTED_USERS"
+		}
+		ref := ForgeRef{Number: target.ID, Change: change}
+		return commentTask(c, c.repo, event.Actor.DisplayName, event.Comment.Content.Raw, ref, target.Title), ""
+
+	case "pullrequest:created":
+		if event.PullRequest == nil {
+			return nil, "invalid payload"
+		}
+		return reviewTask(c, c.repo, event.PullRequest.ID, event.PullRequest.Title), ""
+	}
+
+	return nil, "unsupported event"
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// =============================================================================
+// SPEND AND BUDGETS
+// =============================================================================
+
+// spendDays is how long the ledger keeps daily spend
+const spendDays = 400
+
+// Budgets are spend limits per profile, in US dollars
+type Budgets struct {
+	Profiles map[string]ProfileBudget `json:"profiles"`
+}
+
+// ProfileBudget limits what a profile may spend a day and a month
+type ProfileBudget struct {
+	Daily   *BudgetLimit `json:"daily"`
+	Monthly *BudgetLimit `json:"monthly"`
+}
+
+// BudgetLimit warns once spend reaches Soft and refuses new model calls at Hard. Zero
+// means no threshold.
+type BudgetLimit struct {
+	Soft float64 `json:"soft"`
+	Hard float64 `json:"hard"`
+}
+
+// spendLedger is the spend of every profile, kept in the user's state directory so
+// every run on the machine counts against the same budgets
+type spendLedger struct {
+	Profiles map[string]*profileSpend `json:"profiles"`
+}
+
+// profileSpend is what one profile spent
+type profileSpend struct {
+	Days    map[string]float64 `json:"days"`    // US dollars per local day, e.g. 2026-10-14
+	Alerted []string           `json:"alerted"` // Soft thresholds already warned about, e.g. "daily 2026-10-14"
+
+	Failures map[string]map[string]int `json:"failures,omitempty"` // Refusals and API failures by month and category
+}
+
+// budgetTracker records the run's spend against the profile's budget
+type budgetTracker struct {
+	mu      sync.Mutex
+	profile string
+	budget  ProfileBudget
+	ignore  bool // --ignore-budget: run past hard thresholds
+}
+
+// spendPath is where the spend ledger is kept
+func spendPath() string {
+	return filepath.Join(stateDir(), "spend.json")
+}
+
+// loadBudgets reads a budgets file
+func loadBudgets(path string) (*Budgets, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read budgets: %w", err)
+	}
+	budgets := &Budgets{}
+	if err := json.Unmarshal(data, budgets); err != nil {
+		return nil, fmt.Errorf("failed to parse budgets %s: %w", path, err)
+	}
+	for name, budget := range budgets.Profiles {
+		for period, limit := range map[string]*BudgetLimit{"daily": budget.Daily, "monthly": budget.Monthly} {
+			if limit != nil && limit.Soft > 0 && limit.Hard > 0 && limit.Soft > limit.Hard {
+				return nil, fmt.Errorf("budgets %s: %s: the %s soft threshold is above the hard one", path, name, period)
+			}
+		}
+	}
+	return budgets, nil
+}
+
+// loadSpendLedger reads the spend ledger, which is empty at first
+func loadSpendLedger() (*spendLedger, error) {
+	ledger := &spendLedger{Profiles: map[string]*profileSpend{}}
+	data, err := os.ReadFile(spendPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ledger); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", spendPath(), err)
+	}
+	if ledger.Profiles == nil {
+		ledger.Profiles = map[string]*profileSpend{}
+	}
+	return ledger, nil
+}
+
+// save writes the ledger, replacing the file in one step
+func (l *spendLedger) save() error {
+	path := spendPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(l, "", "  ")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// profile returns a profile's spend, creating it when needed
+func (l *spendLedger) profile(name string) *profileSpend {
+	spend := l.Profiles[name]
+	if spend == nil {
+		spend = &profileSpend{}
+		l.Profiles[name] = spend
+	}
+	if spend.Days == nil {
+		spend.Days = map[string]float64{}
+	}
+	return spend
+}
+
+// today is what the profile spent in the current day
+func (s *profileSpend) today(now time.Time) float64 {
+	return s.Days[now.Format(time.DateOnly)]
+}
+
+// month is what the profile spent in the current month
+func (s *profileSpend) month(now time.Time) float64 {
+	total := 0.0
+	prefix := now.Format("2006-01-")
+	for day, spent := range s.Days {
+		if strings.HasPrefix(day, prefix) {
+			total += spent
+		}
+	}
+	return total
+}
+
+// newBudgetTracker tracks spend for a profile under the budgets file, if there is one
+func newBudgetTracker(profile, budgetsFile string, ignore bool) (*budgetTracker, error) {
+	tracker := &budgetTracker{profile: profile, ignore: ignore}
+	if budgetsFile != "" {
+		budgets, err := loadBudgets(budgetsFile)
+		if err != nil {
+			return nil, err
+		}
+		tracker.budget = budgets.Profiles[profile]
+	}
+	return tracker, nil
+}
+
+// check refuses a new model call once a hard threshold is reached
+func (t *budgetTracker) check() error {
+	if t == nil || t.ignore || (t.budget.Daily == nil && t.budget.Monthly == nil) {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ledger, err := loadSpendLedger()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	spend := ledger.profile(t.profile)
+	for _, period := range []struct {
+		name  string
+		limit *BudgetLimit
+		spent float64
+	}{{"daily", t.budget.Daily, spend.today(now)}, {"monthly", t.budget.Monthly, spend.month(now)}} {
+		if period.limit != nil && period.limit.Hard > 0 && period.spent >= period.limit.Hard {
+			return fmt.Errorf("%w: the %s budget of $%.2f for profile %s is spent ($%.2f); raise it in the budgets file or rerun with --ignore-budget",
+				errOverBudget, period.name, period.limit.Hard, t.profile, period.spent)
+		}
+	}
+	return nil
+}
+
+// record adds the cost of a model call to the ledger, warning the first time spend
+// reaches a soft threshold
+func (t *budgetTracker) record(model string, usage anthropic.Usage) {
+	price, ok := modelPrice(model)
+	if t == nil || !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ledger, err := loadSpendLedger()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	spend := ledger.profile(t.profile)
+	spend.Days[now.Format(time.DateOnly)] += price.cost(usage)
+	for day := range spend.Days {
+		if parsed, err := time.Parse(time.DateOnly, day); err == nil && now.Sub(parsed) > spendDays*24*time.Hour {
+			delete(spend.Days, day)
+		}
+	}
+
+	spend.Alerted = slices.DeleteFunc(spend.Alerted, func(key string) bool {
+		return !strings.HasSuffix(key, now.Format(time.DateOnly)) && !strings.HasSuffix(key, now.Format("2006-01"))
+	})
+	for _, period := range []struct {
+		name  string
+		key   string
+		limit *BudgetLimit
+		spent float64
+	}{
+		{"daily", "daily " + now.Format(time.DateOnly), t.budget.Daily, spend.today(now)},
+		{"monthly", "monthly " + now.Format("2006-01"), t.budget.Monthly, spend.month(now)},
+	} {
+		if period.limit == nil || period.limit.Soft <= 0 || period.spent < period.limit.Soft || slices.Contains(spend.Alerted, period.key) {
+			continue
+		}
+		spend.Alerted = append(spend.Alerted, period.key)
+		text := fmt.Sprintf("Profile %s has spent $%.2f of its %s budget", t.profile, period.spent, period.name)
+		if period.limit.Hard > 0 {
+			text += fmt.Sprintf(" (soft $%.2f, hard $%.2f)", period.limit.Soft, period.limit.Hard)
+		} else {
+			text += fmt.Sprintf(" (soft $%.2f)", period.limit.Soft)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleWarning, "budget"), text)
+		runOptions.notifications.notify(Notification{Kind: notifyBudget, Title: "go-agent budget warning", Text: text})
+	}
+	ledger.save()
+}
+
+// failure counts a refusal or API failure for the month's usage report
+func (t *budgetTracker) failure(category string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ledger, err := loadSpendLedger()
+	if err != nil {
+		return
+	}
+	spend := ledger.profile(t.profile)
+	month := time.Now().Format("2006-01")
+	if spend.Failures == nil {
+		spend.Failures = map[string]map[string]int{}
+	}
+	spend.Failures[month] = addCount(spend.Failures[month], category, 1)
+	ledger.save()
+}
+
+// middleware records the spend of every API call, whichever part of the agent makes it
+func (t *budgetTracker) middleware(request *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	response, err := next(request)
+	if err != nil || response.StatusCode != http.StatusOK || !strings.Contains(request.URL.Path, "/messages") ||
+		!strings.HasPrefix(response.Header.Get("Content-Type"), "application/json") {
+		return response, err
+	}
+	data, readErr := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	if readErr != nil {
+		return response, nil
+	}
+
+	var body struct {
+		Model string           `json:"model"`
+		Usage *anthropic.Usage `json:"usage"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Usage != nil {
+		t.record(body.Model, *body.Usage)
+	}
+	return response, nil
+}
+
+// runUsageCommand implements `go-agent usage`: spend and budget state per profile
+func runUsageCommand(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("usage: go-agent [--profile NAME] [--budgets FILE] usage")
+	}
+	ledger, err := loadSpendLedger()
+	if err != nil {
+		return err
+	}
+	budgets := &Budgets{}
+	if runOptions.BudgetsFile != "" {
+		if budgets, err = loadBudgets(runOptions.BudgetsFile); err != nil {
+			return err
+		}
+	}
+
+	names := []string{runOptions.Profile}
+	for name := range ledger.Profiles {
+		names = append(names, name)
+	}
+	for name := range budgets.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		marker := ""
+		if name == runOptions.Profile {
+			marker = " (current)"
+		}
+		spend := ledger.profile(name)
+		budget := budgets.Profiles[name]
+		fmt.Printf("profile %s%s\n", name, marker)
+		fmt.Printf("  today       %s\n", budgetState(spend.today(now), budget.Daily))
+		fmt.Printf("  this month  %s\n", budgetState(spend.month(now), budget.Monthly))
+		if failures := spend.Failures[now.Format("2006-01")]; len(failures) > 0 {
+			fmt.Printf("  failures    %s this month\n", formatCounts(failures))
+		}
+	}
+	return nil
+}
+
+// budgetState describes spend against a limit, e.g. "$4.10 of $5.00 (82%, over the $4.00 soft threshold)"
+func budgetState(spent float64, limit *BudgetLimit) string {
+	state := fmt.Sprintf("$%.2f", spent)
+	if limit == nil || (limit.Soft <= 0 && limit.Hard <= 0) {
+		return state + " (no budget)"
+	}
+	switch {
+	case limit.Hard > 0 && spent >= limit.Hard:
+		return state + fmt.Sprintf(" of $%.2f (hard threshold reached: new model calls are refused)", limit.Hard)
+	case limit.Hard > 0:
+		state += fmt.Sprintf(" of $%.2f (%.0f%%", limit.Hard, 100*spent/limit.Hard)
+	default:
+		state += " (no hard threshold"
+	}
+	if limit.Soft > 0 && spent >= limit.Soft {
+		state += fmt.Sprintf(", over the $%.2f soft threshold", limit.Soft)
+	} else if limit.Soft > 0 {
+		state += fmt.Sprintf(", soft threshold $%.2f", limit.Soft)
+	}
+	return state + ")"
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// cbreak is not available without Unix terminals; typing ahead is left to the terminal
+func cbreak(fd int) (func(), error) {
+	return nil, errors.New("cbreak mode is not supported on this system")
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// cbreak stops the terminal from echoing and from holding input back until Enter, so key
+// presses can be read one at a time while output goes on as usual. Unlike raw mode, Ctrl-C
+// still sends a signal and newlines still return the cursor. The returned function puts
+// the terminal back.
+func cbreak(fd int) (func(), error) {
+	termios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	saved := *termios
+	termios.Lflag &^= unix.ECHO | unix.ICANON
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, termios); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlSetTermios, &saved) }, nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// CHANGELOG WORKFLOW
+// =============================================================================
+
+// Changelog formats understood by --format
+const (
+	changelogFormatKeepAChangelog = "keepachangelog" // Added / Changed / Fixed / Removed ...
+	changelogFormatConventional   = "conventional"   // Features / Bug Fixes / ... by commit type
+	changelogFormatNotes          = "notes"          // Prose release notes written by Claude
+)
+
+// releaseNotesPrompt asks Claude to turn grouped changes into release notes
+const releaseNotesPrompt = "Write release notes in Markdown for version %s from the changes below. " +
+	"Start with a short paragraph summarizing the highlights, then list the notable changes by theme. " +
+	"Mention PR numbers where given. Do not invent changes. Reply with the release notes only.\n\n%s"
+
+// Change is one entry in a changelog, taken from a commit or merged pull request
+type Change struct {
+	Type    string // Conventional commit type such as feat or fix, or one inferred from the leading verb
+	Scope   string
+	Summary string
+	PR      string // Pull request number, if known
+	Hash    string
+}
+
+var (
+	conventionalPattern = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?!?:\s*(.+)$`)
+	mergePRPattern      = regexp.MustCompile(`^Merge pull request #(\d+)`)
+	squashPRPattern     = regexp.MustCompile(`^(.*?)\s*\(#(\d+)\)$`)
+)
+
+// changelogSection is a heading and the change types listed under it
+type changelogSection struct {
+	Title string
+	Types []string
+}
+
+// keepAChangelogSections maps change types onto Keep a Changelog sections, in output order
+var keepAChangelogSections = []changelogSection{
+	{"Added", []string{"feat", "add"}},
+	{"Changed", []string{"perf", "refactor", "change", "docs"}},
+	{"Deprecated", []string{"deprecate"}},
+	{"Removed", []string{"remove", "revert"}},
+	{"Fixed", []string{"fix"}},
+	{"Security", []string{"security"}},
+}
+
+// conventionalSections maps conventional commit types onto headings, in output order
+var conventionalSections = []changelogSection{
+	{"Features", []string{"feat", "add"}},
+	{"Bug Fixes", []string{"fix"}},
+	{"Performance", []string{"perf"}},
+	{"Refactoring", []string{"refactor"}},
+	{"Documentation", []string{"docs"}},
+	{"Other Changes", []string{"change", "remove", "revert", "deprecate", "security", "chore", "build", "ci", "test", "style"}},
+}
+
+// runChangelogCommand implements `go-agent changelog --from v1.2.0`
+func runChangelogCommand(args []string) error {
+	defaultFormat := configValue("CHANGELOG_FORMAT")
+	if defaultFormat == "" {
+		defaultFormat = changelogFormatKeepAChangelog
+	}
+
+	flags := flag.NewFlagSet("changelog", flag.ContinueOnError)
+	from := flags.String("from", "", "git ref to start from (exclusive), e.g. the previous release tag")
+	to := flags.String("to", "HEAD", "git ref to end at (inclusive)")
+	version := flags.String("version", "Unreleased", "version heading for the new entry")
+	format := flags.String("format", defaultFormat, "keepachangelog, conventional or notes")
+	write := flags.String("write", "", "prepend the entry to this file (e.g. CHANGELOG.md) instead of printing it")
+	openPR := flags.Bool("pr", false, "commit the updated file on a new branch and open a pull request (or merge request)")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if *from == "" {
+		return fmt.Errorf("changelog requires --from")
+	}
+	if *openPR && *write == "" {
+		return fmt.Errorf("--pr requires --write")
+	}
+
+	ctx := context.TODO()
+
+	changes, err := collectChanges(ctx, *from, *to)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("no changes between %s and %s", *from, *to)
+	}
+
+	heading := fmt.Sprintf("## [%s] - %s", *version, time.Now().Format("2006-01-02"))
+	var entry string
+	switch *format {
+	case changelogFormatKeepAChangelog:
+		entry = heading + "\n\n" + renderChangeSections(changes, keepAChangelogSections, false)
+	case changelogFormatConventional:
+		entry = heading + "\n\n" + renderChangeSections(changes, conventionalSections, true)
+	case changelogFormatNotes:
+		entry, err = writeReleaseNotes(ctx, *version, changes)
+		if err != nil {
+			return err
+		}
+		entry = heading + "\n\n" + strings.TrimSpace(entry) + "\n"
+	default:
+		return fmt.Errorf("unknown changelog format %q", *format)
+	}
+
+	if *write == "" {
+		fmt.Print(entry)
+		return nil
+	}
+
+	if err := prependChangelog(*write, entry); err != nil {
+		return err
+	}
+	fmt.Printf("changelog: added %d change(s) to %s\n", len(changes), *write)
+
+	if *openPR {
+		return openChangelogPR(ctx, *write, *version)
+	}
+	return nil
+}
+
+// collectChanges reads non-merge commits and merged pull requests between from and to
+func collectChanges(ctx context.Context, from, to string) ([]Change, error) {
+	// Fields are separated by \x1f and commits by \x1e so bodies may contain newlines
+	result, err := runProgram(ctx, "git", "log", "--format=%h%x1f%s%x1f%b%x1e", from+".."+to)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Passed() {
+		return nil, fmt.Errorf("git log failed: %s", strings.TrimSpace(result.Output))
+	}
+
+	changes := []Change{}
+	for _, record := range strings.Split(result.Output, "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x1f", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		hash, subject, body := fields[0], strings.TrimSpace(fields[1]), ""
+		if len(fields) == 3 {
+			body = strings.TrimSpace(fields[2])
+		}
+
+		change := Change{Hash: hash, Summary: subject}
+
+		// GitHub merge commits carry the PR title as the first body line
+		if match := mergePRPattern.FindStringSubmatch(subject); match != nil {
+			change.PR = match[1]
+			change.Summary, _, _ = strings.Cut(body, "\n")
+			if change.Summary == "" {
+				continue
+			}
+		} else if strings.HasPrefix(subject, "Merge ") {
+			continue
+		} else if match := squashPRPattern.FindStringSubmatch(subject); match != nil {
+			change.Summary, change.PR = match[1], match[2]
+		}
+
+		classifyChange(&change)
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// classifyChange derives a change's type from a conventional commit prefix or its leading verb
+func classifyChange(change *Change) {
+	if match := conventionalPattern.FindStringSubmatch(change.Summary); match != nil {
+		change.Type, change.Scope, change.Summary = strings.ToLower(match[1]), match[2], match[3]
+		return
+	}
+
+	verb, _, _ := strings.Cut(strings.ToLower(change.Summary), " ")
+	switch verb {
+	case "add", "adds", "added", "implement", "implements", "introduce", "introduces", "support":
+		change.Type = "add"
+	case "fix", "fixes", "fixed", "correct", "corrects", "resolve", "resolves":
+		change.Type = "fix"
+	case "remove", "removes", "removed", "delete", "deletes", "drop", "drops":
+		change.Type = "remove"
+	case "revert", "reverts":
+		change.Type = "revert"
+	case "deprecate", "deprecates":
+		change.Type = "deprecate"
+	default:
+		change.Type = "change"
+	}
+}
+
+// renderChangeSections groups changes under headings; changes whose type no section
+// lists are left out, so chores don't clutter a Keep a Changelog entry
+func renderChangeSections(changes []Change, sections []changelogSection, showScope bool) string {
+	var out strings.Builder
+
+	for _, section := range sections {
+		lines := []string{}
+		for _, change := range changes {
+			if !slices.Contains(section.Types, change.Type) {
+				continue
+			}
+			line := "- "
+			if showScope && change.Scope != "" {
+				line += "**" + change.Scope + ":** "
+			}
+			line += change.Summary
+			if change.PR != "" {
+				line += fmt.Sprintf(" (#%s)", change.PR)
+			} else {
+				line += fmt.Sprintf(" (%s)", change.Hash)
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "### %s\n\n%s\n\n", section.Title, strings.Join(lines, "\n"))
+	}
+
+	return out.String()
+}
+
+// writeReleaseNotes asks Claude to turn the grouped changes into prose release notes
+func writeReleaseNotes(ctx context.Context, version string, changes []Change) (string, error) {
+	client, err := initializeClient()
+	if err != nil {
+		return "", err
+	}
+
+	prompt := fmt.Sprintf(releaseNotesPrompt, version, renderChangeSections(changes, conventionalSections, true))
+	return askClaude(ctx, client, prompt, 2048)
+}
+
+// prependChangelog inserts entry into path above the newest existing entry, keeping any title
+func prependChangelog(path, entry string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	content := string(existing)
+	if content == "" {
+		content = "# Changelog\n\n"
+	}
+
+	entry = strings.TrimRight(entry, "\n") + "\n"
+	insertAt := strings.Index(content, "\n## ")
+	if insertAt < 0 {
+		content = strings.TrimRight(content, "\n") + "\n\n" + entry
+	} else {
+		content = content[:insertAt+1] + entry + "\n" + content[insertAt+1:]
+	}
+
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// openChangelogPR commits the changelog on a new branch, pushes it and opens a pull request
+// on the detected forge
+func openChangelogPR(ctx context.Context, path, version string) error {
+	forge, err := detectForge(ctx)
+	if err != nil {
+		return err
+	}
+
+	branch := "changelog/" + strings.ToLower(regexp.MustCompile(`[^A-Za-z0-9.]+`).ReplaceAllString(version, "-"))
+	title := fmt.Sprintf("Update changelog for %s", version)
+	base := defaultBranch(ctx)
+
+	steps := [][]string{
+		{"git", "checkout", "-b", branch},
+		{"git", "add", path},
+		{"git", "commit", "-m", title},
+		{"git", "push", "-u", "origin", branch},
+	}
+	for _, step := range steps {
+		result, err := runProgram(ctx, step[0], step[1:]...)
+		if err != nil {
+			return err
+		}
+		if !result.Passed() {
+			return fmt.Errorf("%s failed: %s", result.Command, strings.TrimSpace(result.Output))
+		}
+	}
+
+	url, err := forge.CreateChange(ctx, branch, base, title, "Changelog entry generated from the commit history.")
+	if err != nil {
+		return err
+	}
+	fmt.Println(url)
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// CHAT SESSIONS AND BRIDGES
+// =============================================================================
+
+// chatApprovalTimeout is how long a tool call waits for an answer in a chat
+const chatApprovalTimeout = 10 * time.Minute
+
+// defaultChatPrefix addresses a chat message to the agent
+const defaultChatPrefix = "!agent"
+
+// chatSession is the conversation of one chat room, channel or thread
+type chatSession struct {
+	mu           sync.Mutex // Held while a task runs, so a room does one thing at a time
+	agent        *Agent
+	conversation []anthropic.MessageParam
+	queued       atomic.Bool // Whether the task waits for the scheduler
+}
+
+// run sends user's task as the next message of the conversation, asking approver about file
+// changes, and returns Claude's reply and the files it changed. The task first waits for
+// the scheduler to give it a slot.
+func (s *chatSession) run(ctx context.Context, task, user string, approver func(string) bool) (string, []string, error) {
+	release, err := s.schedule(ctx, user)
+	s.queued.Store(false)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
+	s.agent.approver = approver
+	s.agent.user = user
+	edited := len(s.agent.editedFiles)
+
+	message := anthropic.NewUserMessage(s.agent.buildUserMessage(task)...)
+	conversation, err := s.agent.respond(ctx, append(s.conversation, message), task)
+	if err != nil {
+		// Keep the conversation as it was before the failed turn
+		return "", nil, err
+	}
+	s.conversation = conversation
+
+	return strings.TrimSpace(lastAssistantText(conversation)), s.agent.editedFiles[edited:], nil
+}
+
+// reset forgets the conversation unless a task is running
+func (s *chatSession) reset() bool {
+	if !s.mu.TryLock() {
+		return false
+	}
+	s.conversation = nil
+	s.mu.Unlock()
+	return true
+}
+
+// chatSessions keeps one session per room
+type chatSessions struct {
+	client *anthropic.Client
+
+	mu       sync.Mutex
+	sessions map[string]*chatSession
+}
+
+// newChatSessions creates an empty session registry
+func newChatSessions(client *anthropic.Client) *chatSessions {
+	return &chatSessions{client: client, sessions: map[string]*chatSession{}}
+}
+
+// get returns the room's session, creating it on first use
+func (c *chatSessions) get(room string) *chatSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session, ok := c.sessions[room]
+	if !ok {
+		session = &chatSession{agent: NewAgent(c.client, nil, defaultTools())}
+		session.agent.session = room
+		c.sessions[room] = session
+	}
+	return session
+}
+
+// ChatMessage is a message received by a bridge
+type ChatMessage struct {
+	Room      string // Where to reply: a channel, room or the sender for direct messages
+	Sender    string
+	Text      string // The message with any address to the agent removed
+	Addressed bool   // Whether the message was meant for the agent
+}
+
+// ChatBridge connects the agent to a chat network
+type ChatBridge interface {
+	// Name identifies the bridge in logs
+	Name() string
+	// Run connects and calls handle for each incoming message until the connection fails
+	Run(ctx context.Context, handle func(ChatMessage)) error
+	// Send posts a message to a room, splitting it as the network requires
+	Send(ctx context.Context, room, text string) error
+}
+
+// chatBridges maps `chat --bridge` names to constructors
+var chatBridges = map[string]func(prefix string) (ChatBridge, error){
+	"irc":    NewIRCBridge,
+	"matrix": NewMatrixBridge,
+}
+
+// chatApproval is a tool call waiting for its requester to answer yes or no
+type chatApproval struct {
+	requester string
+	answer    chan bool
+}
+
+// ChatServer runs tasks from a bridge, one session per room
+type ChatServer struct {
+	bridge   ChatBridge
+	sessions *chatSessions
+
+	mu        sync.Mutex
+	approvals map[string]*chatApproval // Pending approvals by room
+}
+
+// runChatCommand implements `go-agent chat --bridge irc|matrix`
+func runChatCommand(args []string) error {
+	flags := flag.NewFlagSet("chat", flag.ContinueOnError)
+	bridgeName := flags.String("bridge", "", "chat network to connect to: irc or matrix")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	newBridge, ok := chatBridges[*bridgeName]
+	if !ok {
+		return fmt.Errorf("chat requires --bridge irc or --bridge matrix")
+	}
+
+	prefix := configValue("CHAT_PREFIX")
+	if prefix == "" {
+		prefix = defaultChatPrefix
+	}
+	bridge, err := newBridge(prefix)
+	if err != nil {
+		return err
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	server := &ChatServer{bridge: bridge, sessions: newChatSessions(client), approvals: map[string]*chatApproval{}}
+	fmt.Fprintf(os.Stderr, "chat: connecting to %s\n", bridge.Name())
+	return bridge.Run(context.TODO(), server.handle)
+}
+
+// handle answers pending approvals and starts tasks for messages addressed to the agent
+func (s *ChatServer) handle(message ChatMessage) {
+	ctx := context.TODO()
+
+	s.mu.Lock()
+	approval := s.approvals[message.Room]
+	s.mu.Unlock()
+	if approval != nil && approval.requester == message.Sender {
+		answer, ok := map[string]bool{"y": true, "yes": true, "n": false, "no": false}[strings.ToLower(strings.Trim(message.Text, " .!"))]
+		if ok {
+			select {
+			case approval.answer <- answer:
+			default:
+			}
+			return
+		}
+	}
+
+	if !message.Addressed {
+		return
+	}
+	session := s.sessions.get(message.Room)
+
+	task := strings.TrimSpace(message.Text)
+	switch task {
+	case "":
+		s.bridge.Send(ctx, message.Room, message.Sender+": tell me what to do, or say reset to start over.")
+		return
+	case "reset":
+		if session.reset() {
+			s.bridge.Send(ctx, message.Room, "Conversation cleared.")
+		} else {
+			s.bridge.Send(ctx, message.Room, "I'm still working on the previous task here.")
+		}
+		return
+	}
+
+	if !session.mu.TryLock() {
+		s.bridge.Send(ctx, message.Room, message.Sender+": I'm still working on the previous task here.")
+		return
+	}
+	go func() {
+		defer session.mu.Unlock()
+
+		reply, changed, err := session.run(ctx, task, message.Sender, func(question string) bool {
+			return s.askApproval(ctx, message.Room, message.Sender, question)
+		})
+		if err != nil {
+			reply = "The task failed: " + err.Error()
+		}
+		if len(changed) > 0 {
+			reply += "\n\nFiles changed: " + strings.Join(changed, ", ")
+		}
+		if err := s.bridge.Send(ctx, message.Room, reply); err != nil {
+			fmt.Fprintf(os.Stderr, "chat: failed to reply in %s: %s\n", message.Room, err.Error())
+		}
+	}()
+}
+
+// askApproval asks the requester in the room and waits for a yes or no
+func (s *ChatServer) askApproval(ctx context.Context, room, requester, question string) bool {
+	approval := &chatApproval{requester: requester, answer: make(chan bool, 1)}
+	s.mu.Lock()
+	s.approvals[room] = approval
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.approvals, room)
+		s.mu.Unlock()
+	}()
+
+	question = strings.TrimSuffix(question, " [y/N] ")
+	if err := s.bridge.Send(ctx, room, fmt.Sprintf("%s: %s Reply yes or no.", requester, question)); err != nil {
+		return false
+	}
+
+	select {
+	case answer := <-approval.answer:
+		return answer
+	case <-time.After(chatApprovalTimeout):
+		s.bridge.Send(ctx, room, "No answer to the approval request; the tool call was denied.")
+		return false
+	}
+}
+
+// addressedText strips a leading prefix or "name:" address from text, reporting whether
+// there was one
+func addressedText(text string, prefixes ...string) (string, bool) {
+	text = strings.TrimSpace(text)
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			continue
+		}
+		for _, address := range []string{prefix + ":", prefix + ",", prefix + " ", prefix} {
+			if rest, ok := strings.CutPrefix(text, address); ok && (address != prefix || rest == "") {
+				return strings.TrimSpace(rest), true
+			}
+		}
+	}
+	return text, false
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// RUN OPTIONS AND CI MODE
+// =============================================================================
+
+// Workspace modes restricting what mutating tools may do
+const (
+	modeNormal   = ""          // Mutating tools change files in place
+	modeReadOnly = "read-only" // Mutating tools are not offered to Claude
+	modePatch    = "patch"     // Changes are collected into a patch file and reverted
+	modeDryRun   = "dry-run"   // Mutating tools report what they would do without doing it
+)
+
+// Formats of standard output
+const (
+	outputText = "text" // What the agent prints for people
+	outputJSON = "json" // The event log as JSON lines
+)
+
+// Exit codes for pipeline control flow. They are a contract with scripts, so codes are
+// only ever added.
+const (
+	exitSuccess       = 0
+	exitFailed        = 1   // Any failure without a code of its own
+	exitNeedsHuman    = 2   // A tool call was denied or needs approval
+	exitOverBudget    = 3   // --max-turns or a hard spend budget was reached
+	exitProviderError = 4   // The Anthropic API failed after retries, or could not be reached
+	exitUsage         = 5   // Invalid flags, arguments or configuration
+	exitSignal        = 128 // Plus the signal number when interrupted or terminated, e.g. 130 for ctrl-c
+)
+
+// Outcomes reported in the final event and mapped to exit codes
+const (
+	outcomeSuccess    = "success"
+	outcomeFailed     = "failed"
+	outcomeNeedsHuman = "needs-human"
+	outcomeOverBudget = "over-budget"
+	outcomeProvider   = "provider-error"
+	outcomeUsage      = "usage-error"
+)
+
+var (
+	// errOverBudget is returned when an agent exceeds its turn or spend budget
+	errOverBudget = errors.New("budget exhausted")
+
+	// errNeedsHuman is returned when a run cannot finish without a person
+	errNeedsHuman = errors.New("the run needs a human to continue")
+
+	// errContextOverflow is returned when a request cannot be made to fit the context window
+	errContextOverflow = errors.New("context window exceeded")
+)
+
+// usageError is a mistake in the command line, which exits with exitUsage
+type usageError struct{ error }
+
+func (e usageError) Unwrap() error { return e.error }
+
+// usageErrorf formats a usageError
+func usageErrorf(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// isProviderError reports whether err comes from the Anthropic API or the connection to
+// it, rather than from the agent or its tools
+func isProviderError(err error) bool {
+	var apiErr *anthropic.Error
+	var urlErr *url.Error
+	return errors.As(err, &apiErr) || strings.Contains(err.Error(), streamErrorPrefix) ||
+		errors.As(err, &urlErr) && strings.HasPrefix(urlErr.URL, apiBaseURL())
+}
+
+// apiBaseURL is where the client sends API requests
+func apiBaseURL() string {
+	return strings.TrimSuffix(cmp.Or(os.Getenv("ANTHROPIC_BASE_URL"), "https://api.anthropic.com"), "/")
+}
+
+// RunOptions are the global command-line options applied to every agent in the process
+type RunOptions struct {
+	CI             bool
+	Prompt         string
+	Approval       string
+	Mode           string
+	PatchOut       string
+	LogFile        string
+	Output         string // Format of standard output: text, or json for JSON lines events
+	MaxTurns       int
+	Attachable     bool
+	PolicyFile     string
+	System         string // Instructions sent as the system prompt of every model call
+	SystemFile     string // File of instructions that come before --system
+	PresetsFile    string
+	Target         string // Directory or package name of the monorepo subproject to work on
+	Snapshot       string // Context snapshot the chat or -p task starts from
+	OpenAPIFile    string // Config of the HTTP APIs whose operations become tools
+	GRPCFile       string // Config of the gRPC servers whose methods become tools
+	NotifyFile     string
+	Profile        string // Whose spend and budgets the run counts against
+	BudgetsFile    string
+	IgnoreBudget   bool
+	DryRun         bool
+	AuditFile      string
+	ContextCmds    []string // Commands whose output is attached to the -p task
+	AttachDiff     string   // Diff attached to the -p task: "true" for the uncommitted changes, or a commit range
+	MaxTokens      int64    // Longest reply of a model call
+	Temperature    *float64 // Sampling temperature; nil leaves the API default
+	TopP           *float64 // Nucleus sampling; nil leaves the API default
+	StopSequences  []string // Text that ends Claude's reply where it appears
+	ThinkingBudget int64    // Tokens Claude may think for before answering; 0 turns extended thinking off
+	Resume         string   // Saved session the chat or -p task continues
+	Continue       bool     // Continue the latest saved session of the current directory
+	Tags           []string // Labels added to the saved session, with --tag
+	Import         string   // JSON transcript whose conversation the chat or -p task continues
+	Pane           bool
+	Plain          bool   // Print Claude's answers as raw markdown, even in a terminal
+	NoColor        bool   // Print without colors, like NO_COLOR
+	TUI            bool   // Chat in a full-screen interface instead of line by line
+	Transcript     string // File the conversation is written to after every turn, as Markdown or JSON
+
+	events        *EventLog
+	policy        *Policy            // Rules checked before every tool call, from --policy
+	preset        *Preset            // Settings for the current directory, from --presets
+	notifications *Notifications     // Where task and approval notifications go, from --notify
+	budget        *budgetTracker     // Spend of the run against the profile's budget
+	watchers      *sessionWatchers   // Local socket for `go-agent attach`, with --attachable
+	pane          *agentPane         // tmux pane showing the transcript, with --pane
+	tui           *agentTUI          // Full-screen chat, with --tui
+	patch         FileSnapshot       // Files changed in patch mode, captured before the first change
+	dryRunChanges atomic.Int64       // Edits previewed in dry-run mode
+	needsHuman    atomic.Bool        // Set when a tool call was denied and a person must follow up
+	usage         sessionUsage       // Tokens used by every model call of the run
+	telemetry     *telemetryRecorder // Metrics of the run, when the user opted in to telemetry
+	toolStats     *toolStatsRecorder // Tool calls of the run, added to go-agent tools stats
+	saved         *savedSession      // Conversation saved after every turn, once the chat or -p task starts
+	cleanup       *cleanupManager    // Temporary files, sockets and child processes removed at exit
+	retention     *Retention         // Limits on the saved sessions kept, from SESSION_* and RETENTION_FILE
+	systemPrompt  string             // --system-file and --system combined
+	apiTools      []ToolDefinition   // Tools calling the operations of the --openapi APIs
+	grpcTools     []ToolDefinition   // Tools calling the methods of the --grpc servers
+	interrupts    interruptHandler   // What Ctrl-C does: cancel the chat's turn, or exit
+	input         *lineEditor        // Line editing of the chat's input, when it is typed in a terminal
+	queue         messageQueue       // Messages typed while a turn runs, sent once it is over
+	project       *Project           // Toolchain of the working directory or --target, when one is recognized
+	target        *Target            // Subproject the agent is scoped to by --target
+	snapshot      *ContextSnapshot   // Cached context sent ahead of the conversation, from --snapshot
+	piped         bool               // Whether stdin was read as task input, so it cannot answer questions
+	pipedInput    string             // Piped input attached to the task
+}
+
+// runOptions holds the options parsed from the command line
+var runOptions = &RunOptions{Approval: approvalAuto}
+
+// parseGlobalFlags reads the options that come before any subcommand
+func parseGlobalFlags() error {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.BoolVar(&runOptions.CI, "ci", false, "non-interactive CI mode: explicit --approval, read-only or patch mode, JSON log, outcome exit codes")
+	flag.StringVar(&runOptions.Prompt, "p", "", "run a single task non-interactively and exit")
+	flag.StringVar(&runOptions.Approval, "approval", "", "approval policy for tools that change files: auto, ask or deny")
+	flag.StringVar(&runOptions.Mode, "mode", modeNormal, "workspace mode: read-only, patch or dry-run (default: edit files in place; read-only in CI)")
+	flag.BoolVar(&runOptions.DryRun, "dry-run", false, "preview the run: edits are shown as diffs and never written (same as --mode dry-run)")
+	flag.StringVar(&runOptions.PatchOut, "patch-out", "go-agent.patch", "file the patch is written to in patch mode")
+	flag.StringVar(&runOptions.LogFile, "log", "", "write machine-readable JSON lines events to this file (default go-agent.jsonl in CI)")
+	flag.StringVar(&runOptions.Output, "output", outputText, "format of standard output: text, or json to write the events as JSON lines and everything else to stderr")
+	flag.IntVar(&runOptions.MaxTurns, "max-turns", 0, "maximum model calls per agent before the run is over budget (0 = unlimited)")
+	flag.StringVar(&runOptions.PolicyFile, "policy", trustedConfigValue("POLICY_FILE"), "JSON file of rules that allow, deny or ask about tool calls")
+	flag.Int64Var(&runOptions.MaxTokens, "max-tokens", int64(configInt("MAX_TOKENS", defaultMaxTokens)), "longest reply of a model call, in tokens")
+	runOptions.Temperature, runOptions.TopP = configFloat("TEMPERATURE"), configFloat("TOP_P")
+	if configValue("THINK") == "true" {
+		runOptions.ThinkingBudget = int64(configInt("THINKING_BUDGET", defaultThinkingBudget))
+	}
+	flag.Var(thinkFlag{&runOptions.ThinkingBudget}, "think", "let Claude think before answering, with a budget of THINKING_BUDGET tokens (default 10000), or N tokens with --think=N")
+	flag.Func("temperature", "sampling temperature from 0 to 1 (default: the API's)", floatFlag(&runOptions.Temperature))
+	flag.Func("top-p", "nucleus sampling probability from 0 to 1 (default: the API's)", floatFlag(&runOptions.TopP))
+	runOptions.StopSequences = configStopSequences()
+	stopFlags := false
+	flag.Func("stop", "end Claude's reply where this text appears; repeatable", func(sequence string) error {
+		if !stopFlags {
+			runOptions.StopSequences, stopFlags = nil, true
+		}
+		runOptions.StopSequences = append(runOptions.StopSequences, sequence)
+		return nil
+	})
+	flag.StringVar(&runOptions.System, "system", configValue("SYSTEM_PROMPT"), "system prompt sent with every model call, e.g. project-specific instructions")
+	flag.StringVar(&runOptions.SystemFile, "system-file", configValue("SYSTEM_PROMPT_FILE"), "file whose contents start the system prompt")
+	flag.StringVar(&runOptions.Target, "target", "", "scope the agent to one subproject of a monorepo, by directory or package name")
+	flag.StringVar(&runOptions.Snapshot, "snapshot", configValue("SNAPSHOT"), "start from this context snapshot of the directory, saved with go-agent snapshot save")
+	flag.StringVar(&runOptions.PresetsFile, "presets", configValue("PRESETS_FILE"), "JSON file of model, mode and tool presets for directories of the repository")
+	flag.StringVar(&runOptions.OpenAPIFile, "openapi", configValue("OPENAPI_TOOLS_FILE"), "JSON file of OpenAPI specs whose operations are offered to Claude as tools")
+	flag.StringVar(&runOptions.GRPCFile, "grpc", configValue("GRPC_TOOLS_FILE"), "JSON file of gRPC servers with reflection whose methods are offered to Claude as tools")
+	flag.StringVar(&runOptions.NotifyFile, "notify", configValue("NOTIFICATIONS_FILE"), "JSON file of desktop, Slack, email and webhook notifiers for finished tasks and approvals")
+	flag.StringVar(&runOptions.Profile, "profile", cmp.Or(configValue("PROFILE"), "default"), "profile whose spend and budgets this run counts against")
+	flag.StringVar(&runOptions.BudgetsFile, "budgets", configValue("BUDGETS_FILE"), "JSON file of daily and monthly spend budgets per profile")
+	flag.BoolVar(&runOptions.IgnoreBudget, "ignore-budget", false, "keep making model calls after a hard budget threshold is reached")
+	flag.StringVar(&runOptions.AuditFile, "audit", "", "write a hash-chained audit trail of every event to this new file, signed with AUDIT_SIGNING_KEY if set")
+	flag.Func("context-cmd", "run this shell command and attach its output to the -p task; repeatable", func(command string) error {
+		runOptions.ContextCmds = append(runOptions.ContextCmds, command)
+		return nil
+	})
+	flag.Var(diffFlag{&runOptions.AttachDiff}, "attach-diff", "attach the uncommitted changes to the -p task, or a commit range with --attach-diff=main..HEAD")
+	flag.StringVar(&runOptions.Resume, "resume", "", "continue the saved session with this ID")
+	flag.StringVar(&runOptions.Import, "import", "", "continue the conversation of a JSON transcript written by /export or --transcript, in a new session")
+	flag.Func("tag", "label the saved session, e.g. --tag release-hotfix; repeatable", func(label string) error {
+		runOptions.Tags = append(runOptions.Tags, label)
+		return nil
+	})
+	flag.BoolVar(&runOptions.Continue, "continue", false, "continue the latest saved session of the current directory")
+	flag.BoolVar(&runOptions.Attachable, "attachable", false, "let go-agent attach <pid> watch this session read-only over a local socket")
+	flag.BoolVar(&runOptions.Pane, "pane", false, "show the chat transcript and status in a new tmux pane, keeping this pane for input")
+	flag.BoolVar(&runOptions.TUI, "tui", configValue("TUI") == "true", "chat in a full-screen interface with a scrollable transcript and a pane of running tools and token usage")
+	flag.StringVar(&runOptions.Transcript, "transcript", "", "write the conversation, with tool calls and results, to this Markdown file (or JSON for a .json name) after every turn")
+	flag.BoolVar(&runOptions.Plain, "plain", configValue("PLAIN") == "true", "print Claude's answers as raw markdown instead of rendering them in the terminal")
+	flag.BoolVar(&runOptions.NoColor, "no-color", false, "print without colors, like setting NO_COLOR")
+	return flag.CommandLine.Parse(os.Args[1:])
+}
+
+// prepare validates the options and opens the event log
+func (o *RunOptions) prepare() error {
+	// With --output json, stdout carries the events, so everything the agent prints goes to stderr
+	var eventsOut io.Writer
+	switch o.Output {
+	case outputText:
+	case outputJSON:
+		if o.Pane {
+			return fmt.Errorf("--pane shows a transcript in tmux and cannot be combined with --output json")
+		}
+		eventsOut = os.Stdout
+		os.Stdout = os.Stderr
+	default:
+		return fmt.Errorf("unknown output format %q (want text or json)", o.Output)
+	}
+	migrateLayout()
+	o.cleanup = newCleanupManager()
+	o.cleanup.cleanupOnSignal(o.interrupts.interrupt)
+	if err := o.validateGeneration(); err != nil {
+		return err
+	}
+	if err := checkCodeTheme(); err != nil {
+		return err
+	}
+	if err := checkTheme(); err != nil {
+		return err
+	}
+	theme() // Picked now, since it may ask the terminal for its background before the chat takes over the input
+	if o.PresetsFile != "" {
+		preset, err := loadPresets(o.PresetsFile, presetDir())
+		if err != nil {
+			return err
+		}
+		o.preset = preset
+	}
+	if o.preset != nil {
+		if o.Mode == modeNormal && !o.DryRun {
+			o.Mode = o.preset.Mode
+		}
+		if len(o.ContextCmds) > 0 && !o.preset.allowsShell() {
+			return fmt.Errorf("--context-cmd is turned off here by preset %s", o.preset.Path)
+		}
+		fmt.Fprintf(os.Stderr, "Preset: %s\n", o.preset)
+	}
+	if err := checkModel(string(o.preset.model())); err != nil {
+		return err
+	}
+	if o.DryRun {
+		if o.Mode != modeNormal && o.Mode != modeDryRun {
+			return fmt.Errorf("--dry-run cannot be combined with --mode %s", o.Mode)
+		}
+		o.Mode = modeDryRun
+	}
+	if o.Pane && (o.Prompt != "" || o.CI) {
+		return fmt.Errorf("--pane is for the interactive chat and cannot be combined with -p or --ci")
+	}
+	// TUI=true in the config only applies where the full-screen chat can run
+	explicitTUI := false
+	flag.Visit(func(f *flag.Flag) { explicitTUI = explicitTUI || f.Name == "tui" })
+	chatOnly := o.Prompt != "" || o.CI || o.Pane || o.Output == outputJSON || flag.NArg() > 0
+	if o.TUI && !explicitTUI && (chatOnly || !isTerminal(os.Stdin) || !isTerminal(os.Stdout)) {
+		o.TUI = false
+	}
+	if o.TUI && chatOnly {
+		return fmt.Errorf("--tui is for the interactive chat and cannot be combined with -p, --ci, --pane, --output json or a subcommand")
+	}
+	if o.Resume != "" || o.Continue {
+		if o.Resume != "" && o.Continue {
+			return fmt.Errorf("--resume and --continue cannot be combined")
+		}
+		if !sessionsEnabled() {
+			return fmt.Errorf("sessions are not saved with SAVE_SESSIONS=false, so there is none to continue")
+		}
+		var err error
+		if o.Resume != "" {
+			o.saved, err = loadSavedSession(o.Resume)
+		} else {
+			workspace, _ := os.Getwd()
+			o.saved, err = latestSavedSession(workspace)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if o.Import != "" {
+		if o.Resume != "" || o.Continue {
+			return fmt.Errorf("--import starts a new session from a transcript and cannot be combined with --resume or --continue")
+		}
+		var err error
+		if o.saved, err = importSession(o.Import); err != nil {
+			return err
+		}
+	}
+	if len(o.ContextCmds) > 0 && o.Prompt == "" {
+		return fmt.Errorf("--context-cmd attaches output to a -p task; in the chat, use /run COMMAND --attach")
+	}
+	if o.AttachDiff != "" && o.Prompt == "" {
+		return fmt.Errorf("--attach-diff attaches a diff to a -p task; in the chat, use /diff [RANGE]")
+	}
+	if o.CI {
+		// Nothing may block on a human in CI, so the policy has to be spelled out
+		switch o.Approval {
+		case "":
+			return fmt.Errorf("--ci requires an explicit --approval policy (auto or deny)")
+		case approvalAsk:
+			return fmt.Errorf("--approval ask is interactive and cannot be used with --ci")
+		}
+		if o.Mode == modeNormal {
+			o.Mode = modeReadOnly
+		}
+		if o.LogFile == "" {
+			o.LogFile = "go-agent.jsonl"
+		}
+	}
+	if o.Approval == "" {
+		o.Approval = approvalAuto
+	}
+
+	switch o.Approval {
+	case approvalAuto, approvalAsk, approvalDeny:
+	default:
+		return fmt.Errorf("unknown approval policy %q", o.Approval)
+	}
+	switch o.Mode {
+	case modeNormal, modeReadOnly, modePatch, modeDryRun:
+	default:
+		return fmt.Errorf("unknown mode %q", o.Mode)
+	}
+
+	if o.Mode == modePatch {
+		o.patch = FileSnapshot{}
+	}
+	o.telemetry = startTelemetry()
+	o.toolStats = startToolStats()
+	budget, err := newBudgetTracker(o.Profile, o.BudgetsFile, o.IgnoreBudget)
+	if err != nil {
+		return err
+	}
+	o.budget = budget
+	if o.PolicyFile != "" {
+		policy, err := loadPolicy(o.PolicyFile)
+		if err != nil {
+			return err
+		}
+		o.policy = policy
+	}
+	if o.OpenAPIFile != "" {
+		if o.apiTools, err = loadOpenAPITools(context.Background(), o.OpenAPIFile); err != nil {
+			return err
+		}
+	}
+	if o.GRPCFile != "" {
+		if o.grpcTools, err = loadGRPCTools(context.Background(), o.GRPCFile, o.cleanup); err != nil {
+			return err
+		}
+	}
+	if o.retention, err = loadRetention(configValue("RETENTION_FILE")); err != nil {
+		return err
+	}
+	if o.systemPrompt, err = loadSystemPrompt(o.System, o.SystemFile); err != nil {
+		return err
+	}
+	o.project = detectProject(".")
+	if o.Target != "" {
+		if o.target, err = resolveTarget(o.Target); err != nil {
+			return err
+		}
+		o.project = o.target.Project
+		fmt.Fprintf(os.Stderr, "Target: %s\n", o.target)
+	}
+	if o.project != nil {
+		o.systemPrompt = strings.TrimSpace(o.project.context() + "\n\n" + o.systemPrompt)
+	}
+	if o.target != nil {
+		o.systemPrompt = strings.TrimSpace(o.target.context() + "\n\n" + o.systemPrompt)
+	}
+	if o.Snapshot != "" {
+		if o.snapshot, err = loadSnapshot(o.Snapshot); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Snapshot: %s (~%d tokens)\n", o.snapshot.Name, estimateTokens(o.snapshot.context))
+	}
+	if o.NotifyFile != "" {
+		notifications, err := loadNotifications(o.NotifyFile)
+		if err != nil {
+			return err
+		}
+		o.notifications = notifications
+	}
+	logs := []io.Writer{}
+	if eventsOut != nil {
+		logs = append(logs, eventsOut)
+	}
+	if o.LogFile != "" {
+		file, err := os.Create(o.LogFile)
+		if err != nil {
+			return fmt.Errorf("failed to open event log: %w", err)
+		}
+		logs = append(logs, file)
+	}
+	if o.AuditFile != "" {
+		audit, err := newAuditLog(o.AuditFile)
+		if err != nil {
+			return err
+		}
+		logs = append(logs, audit)
+	}
+	if o.Attachable {
+		watchers, err := listenForWatchers()
+		if err != nil {
+			return err
+		}
+		o.watchers = watchers
+		o.cleanup.add(watchers.close)
+		logs = append(logs, watchers.events)
+		fmt.Fprintf(os.Stderr, "Watch this session with: go-agent attach %d\n", os.Getpid())
+	}
+	if o.Pane {
+		pane, err := openPane()
+		if err != nil {
+			return err
+		}
+		o.pane = pane
+		o.cleanup.add(pane.close)
+		logs = append(logs, pane)
+		fmt.Fprintf(pane.terminal, "The transcript is in tmux pane %s; pipe text to send-to-agent to attach it to your next message\n", pane.id)
+	}
+	if o.TUI {
+		tui, err := openTUI()
+		if err != nil {
+			return err
+		}
+		o.tui = tui
+		o.cleanup.add(tui.close)
+		logs = append(logs, tui)
+	}
+	if len(logs) > 0 {
+		o.events = NewEventLog(io.MultiWriter(logs...))
+		o.events.Emit(Event{Type: eventSessionStart, Metadata: sessionMetadata()})
+	}
+
+	return nil
+}
+
+// filterTools drops mutating tools in read-only mode, the tools the preset leaves out and
+// the tools managed settings ban
+func (o *RunOptions) filterTools(tools []ToolDefinition) []ToolDefinition {
+	allowed := []ToolDefinition{}
+	for _, tool := range tools {
+		if (o.Mode != modeReadOnly || !tool.Mutating) && o.preset.allowsTool(tool.Name) && !toolBanned(tool.Name) {
+			allowed = append(allowed, tool)
+		}
+	}
+	return allowed
+}
+
+// finish writes the patch in patch mode, logs the outcome and returns the exit code for err
+func (o *RunOptions) finish(err error) int {
+	if err == nil && o.needsHuman.Load() {
+		err = errNeedsHuman
+	}
+
+	if o.Mode == modeDryRun {
+		o.summarizeDryRun()
+	}
+	if o.Mode == modePatch {
+		if patchErr := o.writePatch(); patchErr != nil && err == nil {
+			err = patchErr
+		}
+	}
+
+	outcome, code := outcomeSuccess, exitSuccess
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+		err = nil // A subcommand's -h printed its usage
+	case errors.Is(err, errOverBudget):
+		outcome, code = outcomeOverBudget, exitOverBudget
+	case errors.Is(err, errNeedsHuman):
+		outcome, code = outcomeNeedsHuman, exitNeedsHuman
+	case errors.As(err, &usageError{}):
+		outcome, code = outcomeUsage, exitUsage
+	case isProviderError(err):
+		outcome, code = outcomeProvider, exitProviderError
+	default:
+		outcome, code = outcomeFailed, exitFailed
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		o.events.Emit(Event{Type: eventError, Text: err.Error()})
+	}
+	if usage := o.usage.snapshot(); usage.Calls > 0 {
+		if configValue("TOKEN_EFFICIENT_TOOLS") == "true" || configValue("FINE_GRAINED_TOOL_STREAMING") == "true" || len(usage.Failures) > 0 {
+			fmt.Fprintf(os.Stderr, "usage: %s\n", usage)
+		}
+		o.events.Emit(Event{Type: eventUsage, Usage: &usage})
+	}
+	o.events.Emit(Event{Type: eventOutcome, Outcome: outcome, ExitCode: &code})
+	if o.usage.snapshot().Calls > 0 && (o.Prompt != "" || flag.NArg() > 0) {
+		task := o.Prompt
+		if task == "" {
+			task = "go-agent " + strings.Join(flag.Args(), " ")
+		}
+		o.notifications.notify(Notification{Kind: notifyTaskFinished, Title: "go-agent task finished: " + outcome, Text: truncateRunes(task, 200)})
+	}
+	o.cleanup.run()
+	o.telemetry.flush(err)
+	o.toolStats.flush()
+
+	return code
+}
+
+// writePatch turns the changes made in patch mode into a patch file and reverts them
+func (o *RunOptions) writePatch() error {
+	changed := o.patch.Changed()
+	if len(changed) == 0 {
+		fmt.Fprintln(os.Stderr, "patch: no changes")
+		return nil
+	}
+
+	var patch strings.Builder
+	for _, path := range changed {
+		diff, err := o.patch.Diff(context.Background(), path)
+		if err != nil {
+			return err
+		}
+		patch.WriteString(diff)
+		if err := o.patch.Restore(path); err != nil {
+			return err
+		}
+	}
+
+	if dir := filepath.Dir(o.PatchOut); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(o.PatchOut, []byte(patch.String()), 0644); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "patch: wrote %d file change(s) to %s\n", len(changed), o.PatchOut)
+	o.events.Emit(Event{Type: eventStatus, Text: fmt.Sprintf("wrote %d file change(s) to %s", len(changed), o.PatchOut)})
+	return nil
+}
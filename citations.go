@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// CITATIONS
+// =============================================================================
+
+// documentExtensions are the attachments sent as documents Claude can cite, rather than
+// as source files; office documents are sent as the text extracted from them
+var documentExtensions = []string{".md", ".markdown", ".txt", ".rst", ".adoc", ".org", ".pdf", ".docx", ".pptx", ".xlsx", ".odt", ".odp", ".ods"}
+
+// Citation is a span of an attached document that Claude's answer relies on
+type Citation struct {
+	Document  string `json:"document"`   // Path of the attachment
+	Location  string `json:"location"`   // e.g. "chars 120-340" or "pages 2-3"
+	CitedText string `json:"cited_text"` // The quoted span
+}
+
+// String formats the citation for the terminal
+func (c Citation) String() string {
+	return fmt.Sprintf("%s, %s: %q", c.Document, c.Location, truncateRunes(strings.TrimSpace(c.CitedText), 200))
+}
+
+// citeable reports whether an attachment goes out as a document with citations enabled.
+// CITATIONS=false sends every attachment as plain text.
+func citeable(path string) bool {
+	return configValue("CITATIONS") != "false" && slices.Contains(documentExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// documentBlock sends an attachment as a plain text document Claude can cite
+func documentBlock(attachment *Attachment) anthropic.ContentBlockParamUnion {
+	return anthropic.ContentBlockParamUnion{OfDocument: &anthropic.DocumentBlockParam{
+		Source:    anthropic.DocumentBlockParamSourceUnion{OfText: &anthropic.PlainTextSourceParam{Data: attachment.Content}},
+		Title:     anthropic.String(attachment.Path),
+		Citations: anthropic.CitationsConfigParam{Enabled: anthropic.Bool(true)},
+	}}
+}
+
+// citedText joins a run of text blocks into one answer. With citations, Claude splits its
+// answer into blocks around each cited claim; every such claim is followed by [n] markers
+// numbering the returned citations.
+func citedText(blocks []anthropic.ContentBlockUnion) (string, []Citation) {
+	var text strings.Builder
+	citations := []Citation{}
+	for _, block := range blocks {
+		text.WriteString(block.Text)
+		for _, cited := range block.Citations {
+			citation := Citation{Document: cited.DocumentTitle, CitedText: cited.CitedText}
+			switch cited.Type {
+			case "char_location":
+				citation.Location = fmt.Sprintf("chars %d-%d", cited.StartCharIndex, cited.EndCharIndex)
+			case "page_location":
+				citation.Location = fmt.Sprintf("pages %d-%d", cited.StartPageNumber, cited.EndPageNumber-1)
+			case "content_block_location":
+				citation.Location = fmt.Sprintf("blocks %d-%d", cited.StartBlockIndex, cited.EndBlockIndex-1)
+			default:
+				continue
+			}
+			if citation.Document == "" {
+				citation.Document = fmt.Sprintf("document %d", cited.DocumentIndex+1)
+			}
+			citations = append(citations, citation)
+			fmt.Fprintf(&text, "[%d]", len(citations))
+		}
+	}
+	return text.String(), citations
+}
+
+// printCitations lists the citations below the answer that numbers them
+func printCitations(citations []Citation) {
+	for i, citation := range citations {
+		fmt.Printf("%s: [%d] %s\n", paint(roleInfo, "cited"), i+1, citation)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// =============================================================================
+// RESOURCE CLEANUP
+// =============================================================================
+
+// cleanupManager tracks what a run leaves behind — temporary files, sockets, child
+// processes — and removes it when the process exits, including on a signal or a panic.
+// What a crash leaves is found by the next run: scratch directories are named after the
+// process that owns them, and those of exited processes are removed.
+type cleanupManager struct {
+	mu        sync.Mutex
+	dir       string                 // Scratch directory of this process, created on first use
+	actions   []func()               // Run last-registered first
+	processes map[*os.Process]string // Running child processes and their commands
+	done      bool
+}
+
+// scratchRoot holds the scratch directories of all go-agent processes of the user
+func scratchRoot() string {
+	return filepath.Join(cacheDir(), "tmp")
+}
+
+// newCleanupManager creates the manager of this process and removes what crashed runs
+// left behind
+func newCleanupManager() *cleanupManager {
+	removeStaleScratch(false)
+	return &cleanupManager{
+		dir:       filepath.Join(scratchRoot(), strconv.Itoa(os.Getpid())),
+		processes: map[*os.Process]string{},
+	}
+}
+
+// add registers an action, such as closing a socket, to run at exit
+func (m *cleanupManager) add(action func()) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.actions = append(m.actions, action)
+}
+
+// tempFile creates a temporary file in the run's scratch directory, so it is removed even
+// if whoever created it does not get to
+func (m *cleanupManager) tempFile(pattern string) (*os.File, error) {
+	if m == nil {
+		return os.CreateTemp("", pattern)
+	}
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return os.CreateTemp("", pattern)
+	}
+	return os.CreateTemp(m.dir, pattern)
+}
+
+// runProcess runs cmd to completion, killing it if the run exits first
+func (m *cleanupManager) runProcess(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if m != nil {
+		m.mu.Lock()
+		m.processes[cmd.Process] = strings.Join(cmd.Args, " ")
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.processes, cmd.Process)
+			m.mu.Unlock()
+		}()
+	}
+	return cmd.Wait()
+}
+
+// run kills the child processes still running, runs the registered actions and removes
+// the scratch directory. Only the first call does anything.
+func (m *cleanupManager) run() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if m.done {
+		m.mu.Unlock()
+		return
+	}
+	m.done = true
+	for process, command := range m.processes {
+		fmt.Fprintf(os.Stderr, "%s: stopping %s, still running at exit\n", paint(roleWarning, "warning"), truncateRunes(command, 80))
+		killProcessGroup(process.Pid)
+	}
+	actions := m.actions
+	m.mu.Unlock()
+
+	for i := len(actions) - 1; i >= 0; i-- {
+		actions[i]()
+	}
+	os.RemoveAll(m.dir)
+}
+
+// cleanupOnSignal cleans up and exits when the process is interrupted or terminated,
+// which would otherwise end it without running any cleanup. An interrupt is first given
+// to interrupted, which returns false when it was handled without exiting. The exit code
+// is 128 plus the signal number, as shells report it.
+func (m *cleanupManager) cleanupOnSignal(interrupted func() bool) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if sig == os.Interrupt && !interrupted() {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "\n%s: cleaning up\n", sig)
+			m.run()
+			code := exitFailed
+			if number, ok := sig.(syscall.Signal); ok {
+				code = exitSignal + int(number)
+			}
+			os.Exit(code)
+		}
+	}()
+}
+
+// processAlive reports whether the process with pid is running
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	return err == nil && process.Signal(syscall.Signal(0)) == nil
+}
+
+// removeStaleScratch removes the scratch directories of processes that have exited and
+// returns how many there were and their size. With dryRun nothing is removed.
+func removeStaleScratch(dryRun bool) (int, int64) {
+	dirs, _ := filepath.Glob(filepath.Join(scratchRoot(), "*"))
+	removed, freed := 0, int64(0)
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil || processAlive(pid) {
+			continue
+		}
+		freed += diskUsage(dir)
+		removed++
+		if !dryRun {
+			os.RemoveAll(dir)
+		}
+	}
+	return removed, freed
+}
+
+// diskUsage is the size of the files under path
+func diskUsage(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// formatSize shows a byte count the way du -h would
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// parseAge reads an age such as 30d, 12h or 90m
+func parseAge(text string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(text, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", text)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(text)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", text)
+	}
+	return age, nil
+}
+
+// runGCCommand implements `go-agent gc [--older-than AGE] [--dry-run]`
+func runGCCommand(args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	olderThan := flags.String("older-than", cmp.Or(configValue("GC_OLDER_THAN"), "30d"), "remove saved sessions and cached uploads older than this, e.g. 30d or 12h; pinned sessions are kept")
+	dryRun := flags.Bool("dry-run", false, "list what would be removed without removing it")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() > 0 {
+		return usageErrorf("usage: go-agent gc [--older-than AGE] [--dry-run]")
+	}
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-age)
+
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	report := func(what string, count int, size int64) {
+		switch {
+		case count > 0 && size > 0:
+			fmt.Printf("gc: %s %d %s (%s)\n", verb, count, what, formatSize(size))
+		case count > 0:
+			fmt.Printf("gc: %s %d %s\n", verb, count, what)
+		}
+	}
+
+	// Sessions go when their last turn is older than --older-than or they are past the
+	// retention limits; pinned sessions stay
+	all := listSessionFiles()
+	expired := map[string]bool{}
+	for _, session := range runOptions.retention.expired(all, "") {
+		expired[session.id] = true
+	}
+	sessions, sessionBytes := 0, int64(0)
+	for _, session := range all {
+		if !expired[session.id] && (session.pinned || !session.updated.Before(cutoff)) {
+			continue
+		}
+		if !*dryRun && os.Remove(session.path) != nil {
+			continue
+		}
+		sessions++
+		sessionBytes += session.size
+	}
+	report("saved sessions", sessions, sessionBytes)
+
+	scratch, scratchBytes := removeStaleScratch(*dryRun)
+	report("scratch directories of exited runs", scratch, scratchBytes)
+
+	// Sockets are named after the process that listens on them
+	sockets, _ := filepath.Glob(filepath.Join(watchDir(), "*.sock"))
+	stale := 0
+	for _, path := range sockets {
+		pid, err := strconv.Atoi(strings.SplitN(filepath.Base(path), ".", 2)[0])
+		if err != nil || processAlive(pid) {
+			continue
+		}
+		stale++
+		if !*dryRun {
+			os.Remove(path)
+		}
+	}
+	report("sockets of exited sessions", stale, 0)
+
+	uploads, uploadBytes := pruneUploads(cutoff, *dryRun)
+	report("cached uploads", uploads, uploadBytes)
+
+	if sessions+scratch+stale+uploads == 0 {
+		fmt.Println("gc: nothing to remove")
+	}
+	return nil
+}
+
+// pruneUploads forgets uploads older than cutoff, so their attachments are uploaded again
+// the next time they are used. It returns how many were forgotten and how much the
+// cache shrank.
+func pruneUploads(cutoff time.Time, dryRun bool) (int, int64) {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	data, err := os.ReadFile(uploadsPath())
+	if err != nil {
+		return 0, 0
+	}
+	uploads := map[string]uploadedFile{}
+	if json.Unmarshal(data, &uploads) != nil {
+		return 0, 0
+	}
+	pruned := 0
+	for key, upload := range uploads {
+		if upload.Uploaded.Before(cutoff) {
+			delete(uploads, key)
+			pruned++
+		}
+	}
+	if pruned == 0 {
+		return 0, 0
+	}
+	kept, _ := json.MarshalIndent(uploads, "", "  ")
+	if !dryRun {
+		os.WriteFile(uploadsPath(), kept, 0o600)
+	}
+	return pruned, int64(len(data) - len(kept))
+}
@@ -0,0 +1,326 @@
+// Package client talks to a go-agent server started with `go-agent serve`.
+//
+// A typical bot creates a session, sends a message and follows the session's events until
+// the turn's outcome arrives:
+//
+//	c := client.New("http://agent.internal:8080", os.Getenv("SERVER_TOKEN"))
+//	session, err := c.CreateSession(ctx)
+//	...
+//	stream, err := c.Events(ctx, session.ID, 0)
+//	...
+//	defer stream.Close()
+//	err = c.SendMessage(ctx, session.ID, "Why does the build fail?")
+//	for {
+//		event, err := stream.Next()
+//		if err != nil {
+//			break
+//		}
+//		if event.Type == client.EventAssistantText {
+//			fmt.Println(event.Text)
+//		}
+//		if event.Type == client.EventApprovalRequired {
+//			c.Deny(ctx, session.ID, event.Token)
+//		}
+//		if event.Type == client.EventOutcome {
+//			break
+//		}
+//	}
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event types sent by the server, matching the agent's JSON lines event log
+const (
+	EventUserMessage   = "user_message"
+	EventAssistantText = "assistant_text"
+	EventToolUse       = "tool_use"
+	EventToolResult    = "tool_result"
+	EventToolDenied    = "tool_denied"
+	EventStatus        = "status"
+	EventError         = "error"
+	EventOutcome       = "outcome"
+
+	// EventApprovalRequired asks for Approve or Deny with the event's Token before a tool
+	// call that changes files may run
+	EventApprovalRequired = "approval_required"
+	// EventApprovalResolved reports "approved", "denied" or "timed out" in its Text
+	EventApprovalResolved = "approval_resolved"
+
+	// EventTodo carries the agent's updated task list in Todos, and as a checklist in Text
+	EventTodo = "todo"
+	// EventProgress reports in Progress how far along the running tool call (Tool and
+	// ToolUseID) is
+	EventProgress = "progress"
+	// EventEditProposed carries an editor session's edit as a diff in Text, before it is made
+	EventEditProposed = "edit_proposed"
+	// EventThinking carries Claude's extended thinking in Text, with --think
+	EventThinking = "thinking"
+)
+
+// Session statuses
+const (
+	StatusIdle    = "idle"
+	StatusQueued  = "queued" // Waiting for the server's scheduler to start the message
+	StatusRunning = "running"
+)
+
+// Session describes a session hosted by the server
+type Session struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Status  string    `json:"status"`
+	Todos   []Todo    `json:"todos,omitempty"` // The agent's current task list
+}
+
+// Event is one record of what the agent did in a session
+type Event struct {
+	Type      string          `json:"type"`
+	Time      time.Time       `json:"time"`
+	Text      string          `json:"text,omitempty"`
+	Tool      string          `json:"tool,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+	Outcome   string          `json:"outcome,omitempty"`
+	Token     string          `json:"token,omitempty"`
+	Todos     []Todo          `json:"todos,omitempty"`
+	Progress  *Progress       `json:"progress,omitempty"`
+	Citations []Citation      `json:"citations,omitempty"` // Sources of an assistant_text answer
+	Failure   string          `json:"failure,omitempty"`   // Category of a refusal or API failure, with an error event
+}
+
+// Citation is a span of an attached document that an answer relies on; the answer's text
+// refers to it as [n], counting from 1
+type Citation struct {
+	Document  string `json:"document"`
+	Location  string `json:"location"` // e.g. "chars 120-340" or "pages 2-3"
+	CitedText string `json:"cited_text"`
+}
+
+// Progress is how far along a long-running tool call is
+type Progress struct {
+	Done    int    `json:"done"`
+	Total   int    `json:"total,omitempty"` // Zero when unknown
+	Current string `json:"current,omitempty"`
+}
+
+// Todo is one task of the agent's task list
+type Todo struct {
+	Text   string `json:"text"`
+	Status string `json:"status"` // "pending", "in_progress" or "done"
+}
+
+// Error is an error response from the server
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return fmt.Sprintf("go-agent server: %d: %s", e.StatusCode, e.Message)
+}
+
+// Client calls the server's REST API
+type Client struct {
+	baseURL string
+	token   string
+
+	// HTTP is used for all requests. It has no timeout by default, since event streams
+	// stay open for as long as the session runs; use contexts to bound calls.
+	HTTP *http.Client
+}
+
+// New creates a client for the server at baseURL, authenticating with token
+func New(baseURL, token string) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), token: token, HTTP: &http.Client{}}
+}
+
+// CreateSession starts an empty session
+func (c *Client) CreateSession(ctx context.Context) (*Session, error) {
+	session := &Session{}
+	return session, c.call(ctx, http.MethodPost, "/v1/sessions", struct{}{}, session)
+}
+
+// Sessions lists all sessions, oldest first
+func (c *Client) Sessions(ctx context.Context) ([]Session, error) {
+	sessions := []Session{}
+	return sessions, c.call(ctx, http.MethodGet, "/v1/sessions", nil, &sessions)
+}
+
+// Session returns one session
+func (c *Client) Session(ctx context.Context, id string) (*Session, error) {
+	session := &Session{}
+	return session, c.call(ctx, http.MethodGet, "/v1/sessions/"+id, nil, session)
+}
+
+// SendMessage starts the next turn of a session. It returns once the server has accepted
+// the message; the turn's progress arrives as events, ending with an EventOutcome. The
+// turn lasts only as long as ctx: canceling it cancels the turn on the server.
+func (c *Client) SendMessage(ctx context.Context, id, text string) error {
+	return c.SendMessageAs(ctx, id, "", text)
+}
+
+// SendMessageAs is SendMessage on behalf of user, whom the server's tool policy and
+// scheduler check. Only clients with the server's SERVER_TOKEN may name another user
+// than their token's.
+func (c *Client) SendMessageAs(ctx context.Context, id, user, text string) error {
+	message := map[string]string{"text": text}
+	if user != "" {
+		message["user"] = user
+	}
+	response, err := c.do(ctx, http.MethodPost, "/v1/sessions/"+id+"/messages", message)
+	if err != nil {
+		return err
+	}
+	// The server holds the response open until the turn ends, and closing it early
+	// cancels the turn, so it is read to the end in the background
+	go func() {
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+	}()
+	return nil
+}
+
+// Approve lets the tool call waiting on token run
+func (c *Client) Approve(ctx context.Context, id, token string) error {
+	return c.resolve(ctx, id, token, true)
+}
+
+// Deny refuses the tool call waiting on token
+func (c *Client) Deny(ctx context.Context, id, token string) error {
+	return c.resolve(ctx, id, token, false)
+}
+
+// resolve answers a pending approval
+func (c *Client) resolve(ctx context.Context, id, token string, approve bool) error {
+	return c.call(ctx, http.MethodPost, "/v1/sessions/"+id+"/approvals/"+token, map[string]bool{"approve": approve}, nil)
+}
+
+// Share is a read-only link to a session's transcript
+type Share struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// Share creates a link anyone can use to watch the session until ttl passes. A zero ttl
+// uses the server's default of one day.
+func (c *Client) Share(ctx context.Context, id string, ttl time.Duration) (*Share, error) {
+	request := map[string]string{}
+	if ttl > 0 {
+		request["ttl"] = ttl.String()
+	}
+	share := &Share{}
+	return share, c.call(ctx, http.MethodPost, "/v1/sessions/"+id+"/shares", request, share)
+}
+
+// EventStream reads a session's events as they happen
+type EventStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	count   int
+}
+
+// Events opens a stream of the session's events, skipping the first after events. Pass the
+// stream's Count to a new call to resume after a disconnect.
+func (c *Client) Events(ctx context.Context, id string, after int) (*EventStream, error) {
+	response, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/v1/sessions/%s/events?after=%d", id, after), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := NewEventStream(response.Body)
+	stream.count = after
+	return stream, nil
+}
+
+// NewEventStream reads events from any JSON lines source, such as a --log file
+func NewEventStream(r io.ReadCloser) *EventStream {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &EventStream{body: r, scanner: scanner}
+}
+
+// Next blocks until the next event arrives. It returns io.EOF when the stream ends.
+func (s *EventStream) Next() (Event, error) {
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return Event{}, err
+		}
+		return Event{}, io.EOF
+	}
+	s.count++
+
+	event := Event{}
+	err := json.Unmarshal(s.scanner.Bytes(), &event)
+	return event, err
+}
+
+// Count is the number of events of the session read so far, including skipped ones
+func (s *EventStream) Count() int {
+	return s.count
+}
+
+// Close ends the stream
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
+
+// call sends a JSON request and decodes the JSON response into out, if given
+func (c *Client) call(ctx context.Context, method, path string, in, out any) error {
+	response, err := c.do(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// do sends an authenticated request and turns error responses into *Error
+func (c *Client) do(ctx context.Context, method, path string, in any) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.HTTP.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(response.Body).Decode(&failure)
+		return nil, &Error{StatusCode: response.StatusCode, Message: failure.Error}
+	}
+	return response, nil
+}
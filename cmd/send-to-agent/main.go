@@ -0,0 +1,81 @@
+// Command send-to-agent pipes text to a go-agent chat started with --pane. The text is
+// attached to the next message typed in the chat, e.g. from another tmux pane:
+//
+//	tmux capture-pane -p -t 2 | send-to-agent
+//	git diff | send-to-agent --pid 4242
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	pid := flag.Int("pid", 0, "process id of the go-agent session (default: the only --pane session)")
+	flag.Parse()
+
+	if err := send(*pid, os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "send-to-agent: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// watchDir is where go-agent keeps its session sockets; it matches go-agent's watchDir
+func watchDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "go-agent")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("go-agent-%d", os.Getuid()))
+}
+
+// send delivers everything read from r to the session's input socket
+func send(pid int, r io.Reader) error {
+	path := filepath.Join(watchDir(), fmt.Sprintf("%d.input.sock", pid))
+	if pid == 0 {
+		sockets := liveSockets()
+		switch len(sockets) {
+		case 0:
+			return fmt.Errorf("no go-agent session is running with --pane")
+		case 1:
+			path = sockets[0]
+		default:
+			pids := []string{}
+			for _, socket := range sockets {
+				pids = append(pids, strings.TrimSuffix(filepath.Base(socket), ".input.sock"))
+			}
+			return fmt.Errorf("several --pane sessions are running; choose one with --pid (%s)", strings.Join(pids, ", "))
+		}
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return fmt.Errorf("the session is not accepting text: %w", err)
+	}
+	defer conn.Close()
+	if _, err := io.Copy(conn, r); err != nil {
+		return err
+	}
+	return conn.(*net.UnixConn).CloseWrite()
+}
+
+// liveSockets lists the input sockets of running sessions, removing those left behind by
+// sessions that were killed. A session ignores the empty connection this opens.
+func liveSockets() []string {
+	sockets, _ := filepath.Glob(filepath.Join(watchDir(), "*.input.sock"))
+	live := []string{}
+	for _, socket := range sockets {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			os.Remove(socket)
+			continue
+		}
+		conn.Close()
+		live = append(live, socket)
+	}
+	return live
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// CONTEXT COMPACTION
+// =============================================================================
+
+// Defaults of the compaction settings
+const (
+	defaultCompactPercent   = 80   // COMPACT_AT_PERCENT: compact once a request takes this much of the window
+	defaultCompactKeepTurns = 4    // COMPACT_KEEP_TURNS: the latest turns are kept verbatim
+	compactSummaryTokens    = 2048 // Longest summary
+	compactResultChars      = 1000 // Tool results are cut to this much in the summarizer's transcript
+)
+
+// compactPrompt instructs the model that condenses the older part of a conversation
+const compactPrompt = `You condense the earlier part of a coding agent's conversation so the agent can continue the task without it. Write a summary that keeps:
+- the user's goals, requirements and preferences, and any decisions made
+- files read or changed, with the important facts learned about them
+- commands run and their outcomes, including errors that are still open
+- what was done so far and what remains to do
+Be specific: keep paths, names, values and error messages exactly. Leave out pleasantries and anything superseded. Write the summary only, with no preamble.`
+
+// compactionEnabled reports whether long conversations are compacted; COMPACT=false
+// leaves them to the elision and overflow checks alone
+func compactionEnabled() bool {
+	return configValue("COMPACT") != "false"
+}
+
+// compactThreshold is the request size, in tokens, at which the conversation is compacted
+func compactThreshold() int {
+	percent := configInt("COMPACT_AT_PERCENT", defaultCompactPercent)
+	if percent <= 0 || percent > 100 {
+		percent = defaultCompactPercent
+	}
+	return contextWindow() * percent / 100
+}
+
+// compact replaces all but the latest turns with a summary written by the model. The
+// summary is put in front of the first turn kept, so the conversation still starts with
+// a user message. It returns the conversation unchanged when there is too little to
+// compact.
+func (a *Agent) compact(ctx context.Context, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, int, error) {
+	split := compactSplit(conversation, configInt("COMPACT_KEEP_TURNS", defaultCompactKeepTurns))
+	if split <= 0 {
+		return conversation, 0, nil
+	}
+	if err := runOptions.budget.check(); err != nil {
+		return conversation, 0, err
+	}
+
+	model := anthropic.Model(configValue("COMPACTION_MODEL"))
+	if model == "" {
+		model = a.model
+	}
+	transcript := tailToTokens(renderForSummary(conversation[:split]), contextWindow()*3/4)
+	message, err := a.client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     model,
+		MaxTokens: compactSummaryTokens,
+		System:    []anthropic.TextBlockParam{{Text: compactPrompt}},
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock("The conversation so far:\n\n" + transcript)),
+		},
+	})
+	if err != nil {
+		return conversation, 0, err
+	}
+	runOptions.usage.record(message, nil)
+
+	var summary strings.Builder
+	for _, content := range message.Content {
+		if content.Type == "text" {
+			summary.WriteString(content.Text)
+		}
+	}
+	if strings.TrimSpace(summary.String()) == "" {
+		return conversation, 0, fmt.Errorf("the summary came back empty")
+	}
+
+	kept := append([]anthropic.MessageParam{}, conversation[split:]...)
+	first := kept[0]
+	first.Content = append([]anthropic.ContentBlockParamUnion{anthropic.NewTextBlock(fmt.Sprintf(
+		"<conversation-summary messages=\"%d\">\nThe earlier part of this conversation was compacted to fit the context window. Summary:\n\n%s\n</conversation-summary>",
+		split, strings.TrimSpace(summary.String())))}, first.Content...)
+	kept[0] = first
+	return kept, split, nil
+}
+
+// compactSplit returns the index of the first message kept verbatim: the start of the
+// latest keepTurns turns. A turn starts with a user message that is more than tool
+// results. It returns 0 when the conversation has no more turns than that.
+func compactSplit(conversation []anthropic.MessageParam, keepTurns int) int {
+	keepTurns = max(keepTurns, 1)
+	turns := 0
+	for i := len(conversation) - 1; i > 0; i-- {
+		if !startsTurn(conversation[i]) {
+			continue
+		}
+		if turns++; turns == keepTurns {
+			return i
+		}
+	}
+	return 0
+}
+
+// startsTurn reports whether message is something the user said, not tool results
+func startsTurn(message anthropic.MessageParam) bool {
+	if message.Role != anthropic.MessageParamRoleUser {
+		return false
+	}
+	for _, block := range message.Content {
+		if block.OfToolResult == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// renderForSummary writes messages as a plain transcript for the summarizer, with tool
+// results and attachments shortened
+func renderForSummary(conversation []anthropic.MessageParam) string {
+	var transcript strings.Builder
+	for _, message := range conversation {
+		speaker := "User"
+		if message.Role == anthropic.MessageParamRoleAssistant {
+			speaker = "Agent"
+		}
+		for _, block := range message.Content {
+			switch {
+			case attachmentName(block) != "":
+				fmt.Fprintf(&transcript, "%s attached %s\n\n", speaker, attachmentName(block))
+			case block.OfImage != nil:
+				fmt.Fprintf(&transcript, "%s attached an image\n\n", speaker)
+			case block.OfText != nil:
+				fmt.Fprintf(&transcript, "%s: %s\n\n", speaker, block.OfText.Text)
+			case block.OfToolUse != nil:
+				input, _ := json.Marshal(block.OfToolUse.Input)
+				fmt.Fprintf(&transcript, "Agent called %s(%s)\n\n", block.OfToolUse.Name, truncateRunes(string(input), compactResultChars))
+			case block.OfToolResult != nil:
+				label := "Tool result"
+				if block.OfToolResult.IsError.Value {
+					label = "Tool error"
+				}
+				fmt.Fprintf(&transcript, "%s: %s\n\n", label, truncateRunes(toolResultText(block.OfToolResult), compactResultChars))
+			}
+		}
+	}
+	return transcript.String()
+}
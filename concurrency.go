@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// PARALLEL TOOL USE
+// =============================================================================
+
+// toolConcurrency reads TOOL_CONCURRENCY once: how many calls of each listed tool may run at
+// the same time. Tools that are not listed, and mutating tools, always run alone.
+var toolConcurrency = sync.OnceValue(func() map[string]int {
+	limits := map[string]int{}
+	for _, entry := range splitList(configValue("TOOL_CONCURRENCY")) {
+		name, limit, _ := strings.Cut(entry, "=")
+		if n, err := strconv.Atoi(strings.TrimSpace(limit)); err == nil && n > 1 {
+			limits[strings.TrimSpace(name)] = n
+		}
+	}
+	return limits
+})
+
+// toolChoice asks for at most one tool call per response when DISABLE_PARALLEL_TOOL_USE
+// is set, so tool calls are strictly sequential
+func toolChoice() anthropic.ToolChoiceUnionParam {
+	if configValue("DISABLE_PARALLEL_TOOL_USE") != "true" {
+		return anthropic.ToolChoiceUnionParam{}
+	}
+	return anthropic.ToolChoiceUnionParam{OfAuto: &anthropic.ToolChoiceAutoParam{DisableParallelToolUse: anthropic.Bool(true)}}
+}
+
+// concurrentBatch returns the tool_use blocks at the start of content whose tools may run
+// concurrently
+func (a *Agent) concurrentBatch(content []anthropic.ContentBlockUnion) []anthropic.ContentBlockUnion {
+	limits := toolConcurrency()
+	batch := []anthropic.ContentBlockUnion{}
+	for _, block := range content {
+		if block.Type != "tool_use" {
+			break
+		}
+		// map's in-memory edit_file is not marked mutating, but its edits must stay in order
+		tool, found := a.findTool(block.Name)
+		if !found || tool.Mutating || block.Name == EditFileDefinition.Name || limits[block.Name] < 2 {
+			break
+		}
+		batch = append(batch, block)
+	}
+	return batch
+}
+
+// executeConcurrently runs a batch of tool calls, at most TOOL_CONCURRENCY of each tool at a
+// time. Policy checks, approval prompts and quotas are handled one call at a time, in order,
+// before any of them runs; the results keep the order of the calls.
+func (a *Agent) executeConcurrently(ctx context.Context, batch []anthropic.ContentBlockUnion) []anthropic.ContentBlockParamUnion {
+	results := make([]anthropic.ContentBlockParamUnion, len(batch))
+	admitted := make([]bool, len(batch))
+	for i, block := range batch {
+		tool, _ := a.findTool(block.Name)
+		results[i], admitted[i] = a.admitTool(tool, block.ID, block.Input)
+	}
+
+	slots := map[string]chan struct{}{}
+	for name, limit := range toolConcurrency() {
+		slots[name] = make(chan struct{}, limit)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: running %d tool calls concurrently\n", paint(roleTool, "tool"), len(batch))
+	var wg sync.WaitGroup
+	for i, block := range batch {
+		if !admitted[i] {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots[block.Name] <- struct{}{}
+			defer func() { <-slots[block.Name] }()
+
+			tool, _ := a.findTool(block.Name)
+			results[i], _ = a.runTool(ctx, tool, block.ID, block.Input, false)
+		}()
+	}
+	wg.Wait()
+	return results
+}
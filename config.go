@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// =============================================================================
+// CONFIGURATION
+// =============================================================================
+
+// configFileName is the optional key=value file read alongside environment variables
+const configFileName = "config.env"
+
+// configFileValues parses config.env once and caches the result for the process
+var configFileValues = sync.OnceValue(func() map[string]string {
+	values := map[string]string{}
+
+	data, err := os.ReadFile(configFileName)
+	if err != nil {
+		return values
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return values
+})
+
+// configValue returns a setting from the environment, falling back to config.env. Managed
+// settings override both.
+func configValue(key string) string {
+	if value, ok := managedSetting(key); ok {
+		return value
+	}
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return configFileValues()[key]
+}
+
+// trustedConfigValue returns a setting from the managed settings or the environment only.
+// config.env is read from the working directory, which may be a repository the agent was
+// pointed at, so it must not decide where go-agent keeps its files or how tools are
+// confined.
+func trustedConfigValue(key string) string {
+	if value, ok := managedSetting(key); ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+// configInt returns an integer setting, or def when it is unset or malformed
+func configInt(key string, def int) int {
+	value, err := strconv.Atoi(configValue(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
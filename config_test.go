@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// withConfigFile makes config.env read as values for the rest of the test
+func withConfigFile(t *testing.T, values map[string]string) {
+	t.Helper()
+	original := configFileValues
+	configFileValues = func() map[string]string { return values }
+	t.Cleanup(func() { configFileValues = original })
+}
+
+func TestConfigFileCannotSetSecuritySettings(t *testing.T) {
+	for _, key := range []string{"SANDBOX", "SANDBOX_WRITABLE", "SANDBOX_NETWORK", "TOOL_EGRESS"} {
+		t.Setenv(key, "")
+	}
+	withConfigFile(t, map[string]string{"SANDBOX": "true", "SANDBOX_WRITABLE": "/home", "SANDBOX_NETWORK": "true"})
+
+	if enabled := sandboxEnabled(); enabled != (runtime.GOOS == "darwin") {
+		t.Errorf("config.env turned the sandbox on or off: %v", enabled)
+	}
+	spec := newSandboxSpec(context.Background(), t.TempDir())
+	if slices.Contains(spec.Writable, "/home") || spec.Network {
+		t.Errorf("config.env widened the sandbox to %+v", spec)
+	}
+
+	withConfigFile(t, map[string]string{"TOOL_EGRESS": "example.com"})
+	if _, restricted := toolEgress(); restricted {
+		t.Error("config.env set TOOL_EGRESS")
+	}
+
+	t.Setenv("SANDBOX_WRITABLE", "/home")
+	t.Setenv("SANDBOX_NETWORK", "true")
+	spec = newSandboxSpec(context.Background(), t.TempDir())
+	if !slices.Contains(spec.Writable, "/home") || !spec.Network {
+		t.Errorf("the environment did not widen the sandbox: %+v", spec)
+	}
+}
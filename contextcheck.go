@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// CONTEXT WINDOW PRE-FLIGHT
+// =============================================================================
+
+// minElidedTokens is the smallest old tool result worth taking out of the conversation
+const minElidedTokens = 200
+
+// contextWindow returns the context window of the active model, in tokens
+func contextWindow() int {
+	return configInt("CONTEXT_WINDOW_TOKENS", contextWindowTokens)
+}
+
+// fitContext makes sure the next request fits the context window before it is sent.
+// A conversation nearing the window is compacted first, older turns replaced with a
+// summary. Old tool results are elided next, their outputs kept for get_tool_output;
+// then the user is asked whether to drop attachments. A request that still does not fit
+// fails here with an explanation instead of as an opaque 400 from the API.
+func (a *Agent) fitContext(ctx context.Context, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+	window := contextWindow()
+	needed := a.requestTokens(ctx, conversation)
+	if needed > compactThreshold() && compactionEnabled() && a.client != nil {
+		compacted, messages, err := a.compact(ctx, conversation)
+		switch {
+		case errors.Is(err, errOverBudget):
+			return conversation, err
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "%s: failed to compact the conversation: %s\n", paint(roleWarning, "warning"), err.Error())
+		case messages > 0:
+			before := needed
+			conversation, needed = compacted, a.requestTokens(ctx, compacted)
+			text := fmt.Sprintf("compacted %d earlier messages into a summary (~%d tokens before, ~%d after)", messages, before, needed)
+			fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleInfo, "context"), text)
+			a.events.Emit(Event{Type: eventStatus, Text: text})
+		}
+	}
+	if needed <= window {
+		return conversation, nil
+	}
+
+	conversation, elided, saved := a.elideToolResults(conversation, needed-window)
+	if elided > 0 {
+		text := fmt.Sprintf("elided %d old tool results (~%d tokens) to fit the %d-token context window", elided, saved, window)
+		fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleInfo, "context"), text)
+		a.events.Emit(Event{Type: eventStatus, Text: text})
+		if needed = a.requestTokens(ctx, conversation); needed <= window {
+			return conversation, nil
+		}
+	}
+
+	// Only a person can decide which attachments the task can do without
+	if paths := attachmentPaths(conversation); len(paths) > 0 && (a.getUserMessage != nil || a.approver != nil) {
+		question := fmt.Sprintf("The conversation needs ~%d tokens of a %d-token context window. Drop its attachments (%s)? [y/N] ",
+			needed, window, strings.Join(paths, ", "))
+		if a.confirm(question) {
+			conversation = dropAttachments(conversation)
+			a.events.Emit(Event{Type: eventStatus, Text: "dropped attachments " + strings.Join(paths, ", ")})
+			if needed = a.requestTokens(ctx, conversation); needed <= window {
+				return conversation, nil
+			}
+		}
+	}
+
+	return conversation, fmt.Errorf("%w: the request needs ~%d tokens, including %d for the reply, but %s has a %d-token context window; start a new conversation or attach less",
+		errContextOverflow, needed, runOptions.MaxTokens, a.model, window)
+}
+
+// requestTokens is the size of the next request plus room for the reply. The local
+// estimate overcounts, so only a request it puts over the window is counted exactly
+// with the API; when counting fails, the estimate is used.
+func (a *Agent) requestTokens(ctx context.Context, conversation []anthropic.MessageParam) int {
+	params := runOptions.withGeneration(anthropic.MessageNewParams{
+		Model:    a.model,
+		System:   runOptions.systemBlocks(),
+		Messages: a.withReminder(conversation),
+		Tools:    a.convertToolsToAnthropicFormat(),
+	})
+	data, _ := json.Marshal(params)
+	estimate := estimateTokens(string(data)) - imageOvercount(params.Messages) - pdfOvercount(params.Messages) + uploadedFileTokens(params.Messages) + int(params.MaxTokens)
+	if estimate <= contextWindow() || a.client == nil {
+		return estimate
+	}
+
+	tools := []anthropic.MessageCountTokensToolUnionParam{}
+	for _, tool := range params.Tools {
+		tools = append(tools, anthropic.MessageCountTokensToolUnionParam{OfTool: tool.OfTool})
+	}
+	count, err := a.client.Messages.CountTokens(ctx, anthropic.MessageCountTokensParams{
+		Model:    params.Model,
+		System:   anthropic.MessageCountTokensParamsSystemUnion{OfTextBlockArray: params.System},
+		Messages: params.Messages,
+		Tools:    tools,
+		Thinking: params.Thinking,
+	}, filesOptions(params.Messages)...)
+	if err != nil || count.InputTokens == 0 {
+		return estimate
+	}
+	return int(count.InputTokens) + int(params.MaxTokens)
+}
+
+// elideToolResults replaces the oldest tool results with a pointer to get_tool_output
+// until about excess tokens are freed. The last message, which Claude is about to
+// answer, is left alone. Copies are changed, never the caller's messages.
+func (a *Agent) elideToolResults(conversation []anthropic.MessageParam, excess int) ([]anthropic.MessageParam, int, int) {
+	compacted := append([]anthropic.MessageParam{}, conversation...)
+	elided, saved := 0, 0
+	for i := 0; i < len(compacted)-1 && saved < excess; i++ {
+		if compacted[i].Role != anthropic.MessageParamRoleUser {
+			continue
+		}
+		content := append([]anthropic.ContentBlockParamUnion{}, compacted[i].Content...)
+		changed := false
+		for j, block := range content {
+			if block.OfToolResult == nil || saved >= excess {
+				continue
+			}
+			output := toolResultText(block.OfToolResult)
+			tokens := estimateTokens(output)
+			if tokens < minElidedTokens {
+				continue
+			}
+			handle := a.toolOutputs.Put(output)
+			result := *block.OfToolResult
+			result.Content = []anthropic.ToolResultBlockParamContentUnion{{OfText: &anthropic.TextBlockParam{
+				Text: fmt.Sprintf("[output (~%d tokens) elided to fit the context window; stored as handle %s, call %s to read it]", tokens, handle, getToolOutputName),
+			}}}
+			content[j] = anthropic.ContentBlockParamUnion{OfToolResult: &result}
+			elided++
+			saved += tokens
+			changed = true
+		}
+		if changed {
+			compacted[i].Content = content
+		}
+	}
+	return compacted, elided, saved
+}
+
+// toolResultText joins the text of a tool result
+func toolResultText(result *anthropic.ToolResultBlockParam) string {
+	var text strings.Builder
+	for _, content := range result.Content {
+		if content.OfText != nil {
+			text.WriteString(content.OfText.Text)
+		}
+	}
+	return text.String()
+}
+
+// attachmentTags open the text blocks that carry attached files and command output
+var attachmentTags = []string{"<file path=", "<command-output ", "<selection path="}
+
+// attachmentName names an attachment block, or returns "" for a block that is not one
+func attachmentName(block anthropic.ContentBlockParamUnion) string {
+	switch {
+	case block.OfDocument != nil:
+		return block.OfDocument.Title.Value
+	case block.OfText != nil:
+		for _, tag := range attachmentTags {
+			if strings.HasPrefix(block.OfText.Text, tag) {
+				name, _, _ := strings.Cut(strings.TrimPrefix(block.OfText.Text, tag), ">")
+				return strings.Trim(name, `"`)
+			}
+		}
+	}
+	return ""
+}
+
+// attachmentPaths lists what is attached anywhere in the conversation
+func attachmentPaths(conversation []anthropic.MessageParam) []string {
+	paths := []string{}
+	for _, message := range conversation {
+		for _, block := range message.Content {
+			if name := attachmentName(block); name != "" {
+				paths = append(paths, name)
+			}
+		}
+	}
+	return paths
+}
+
+// dropAttachments takes every attachment out of copies of the messages, leaving a note
+// so Claude knows what it no longer sees and can read the files itself
+func dropAttachments(conversation []anthropic.MessageParam) []anthropic.MessageParam {
+	trimmed := append([]anthropic.MessageParam{}, conversation...)
+	for i, message := range trimmed {
+		content := []anthropic.ContentBlockParamUnion{}
+		dropped := []string{}
+		for _, block := range message.Content {
+			if name := attachmentName(block); name != "" {
+				dropped = append(dropped, name)
+				continue
+			}
+			content = append(content, block)
+		}
+		if len(dropped) == 0 {
+			continue
+		}
+		content = append(content, anthropic.NewTextBlock(
+			"These attachments were dropped to fit the context window: "+strings.Join(dropped, ", "),
+		))
+		trimmed[i].Content = content
+	}
+	return trimmed
+}
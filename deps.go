@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// DEPENDENCY UPGRADE WORKFLOW
+// =============================================================================
+
+// Outcomes of a single dependency upgrade
+const (
+	upgradeStatusUpgraded = "upgraded"
+	upgradeStatusFixed    = "upgraded, fixed breakage"
+	upgradeStatusReverted = "reverted"
+)
+
+// ModuleInfo is the subset of `go list -m -json` output we need
+type ModuleInfo struct {
+	Path     string
+	Version  string
+	Main     bool
+	Indirect bool
+	Update   *struct {
+		Version string
+	}
+}
+
+// DependencyUpgrade describes the attempt to upgrade one module
+type DependencyUpgrade struct {
+	Path   string
+	From   string
+	To     string
+	Vulns  []string // OSV IDs reported by govulncheck
+	Status string
+	Detail string   // Why the upgrade was reverted, if it was
+	Files  []string // Files Claude changed to fix breakage
+}
+
+// runDepsCommand implements `go-agent deps upgrade`
+func runDepsCommand(args []string) error {
+	if len(args) == 0 || args[0] != "upgrade" {
+		return usageErrorf("usage: deps upgrade [--cmd CMD] [--max-iterations N] [--all] [--no-vulncheck]")
+	}
+
+	flags := flag.NewFlagSet("deps upgrade", flag.ContinueOnError)
+	command := flags.String("cmd", "go build ./... && go test ./...", "command that must pass after each upgrade")
+	maxIterations := flags.Int("max-iterations", 3, "maximum fix attempts per dependency")
+	all := flags.Bool("all", false, "also upgrade indirect dependencies")
+	noVulncheck := flags.Bool("no-vulncheck", false, "skip govulncheck even if it is installed")
+	if err := flags.Parse(args[1:]); err != nil {
+		return usageError{err}
+	}
+
+	ctx := context.TODO()
+
+	baseline, err := runShellCommand(ctx, *command)
+	if err != nil {
+		return err
+	}
+	if !baseline.Passed() {
+		return fmt.Errorf("`%s` fails before any upgrade; fix it first (e.g. with `fix --cmd %q`)", *command, *command)
+	}
+
+	vulns := map[string]vulnerableModule{}
+	if !*noVulncheck {
+		vulns, err = findVulnerableModules(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	upgrades, err := planUpgrades(ctx, vulns, *all)
+	if err != nil {
+		return err
+	}
+	if len(upgrades) == 0 {
+		fmt.Println("deps: all dependencies are up to date")
+		return nil
+	}
+
+	var client *anthropic.Client
+	for _, upgrade := range upgrades {
+		fmt.Fprintf(os.Stderr, "%s: %s %s -> %s\n", paint(roleStatus, "deps"), upgrade.Path, upgrade.From, upgrade.To)
+
+		snapshot := snapshotFiles([]string{"go.mod", "go.sum"})
+		revert := func(detail string) error {
+			upgrade.Status, upgrade.Detail = upgradeStatusReverted, detail
+			for _, path := range snapshot.Changed() {
+				if err := snapshot.Restore(path); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		result, err := runProgram(ctx, "go", "get", upgrade.Path+"@"+upgrade.To)
+		if err == nil && result.Passed() {
+			result, err = runProgram(ctx, "go", "mod", "tidy")
+		}
+		if err != nil {
+			return err
+		}
+		if !result.Passed() {
+			if err := revert(firstLine(result.Output)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, err = runShellCommand(ctx, *command)
+		if err != nil {
+			return err
+		}
+		if result.Passed() {
+			upgrade.Status = upgradeStatusUpgraded
+			continue
+		}
+
+		// The upgrade broke something; let Claude adapt the code to the new version
+		if client == nil {
+			if client, err = initializeClient(); err != nil {
+				return err
+			}
+		}
+		agent := NewAgent(client, nil, defaultTools())
+		agent.snapshot = snapshot
+
+		result, _, err = fixUntilPasses(ctx, agent, result, *maxIterations, "deps")
+		if err != nil {
+			return err
+		}
+		if result.Passed() {
+			upgrade.Status, upgrade.Files = upgradeStatusFixed, agent.editedFiles
+			continue
+		}
+		if err := revert(fmt.Sprintf("`%s` still fails after %d fix attempt(s)", *command, *maxIterations)); err != nil {
+			return err
+		}
+	}
+
+	printUpgradeReport(os.Stdout, upgrades)
+	return nil
+}
+
+// planUpgrades lists direct (or, with all, every) dependency that has a newer version or a
+// known vulnerability, vulnerable modules first
+func planUpgrades(ctx context.Context, vulns map[string]vulnerableModule, all bool) ([]*DependencyUpgrade, error) {
+	result, err := runProgram(ctx, "go", "list", "-m", "-u", "-json", "all")
+	if err != nil {
+		return nil, err
+	}
+	if !result.Passed() {
+		return nil, fmt.Errorf("go list failed: %s", strings.TrimSpace(result.Output))
+	}
+
+	upgrades := []*DependencyUpgrade{}
+	decoder := json.NewDecoder(strings.NewReader(result.Output))
+	for {
+		module := ModuleInfo{}
+		if err := decoder.Decode(&module); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse go list output: %w", err)
+		}
+
+		vuln, vulnerable := vulns[module.Path]
+		if module.Main || (module.Indirect && !all && !vulnerable) {
+			continue
+		}
+
+		target := ""
+		if module.Update != nil {
+			target = module.Update.Version
+		} else if vulnerable && vuln.FixedVersion != "" {
+			target = vuln.FixedVersion
+		}
+		if target == "" {
+			continue
+		}
+
+		upgrades = append(upgrades, &DependencyUpgrade{
+			Path:  module.Path,
+			From:  module.Version,
+			To:    target,
+			Vulns: vuln.IDs,
+		})
+	}
+
+	sort.SliceStable(upgrades, func(i, j int) bool {
+		return len(upgrades[i].Vulns) > len(upgrades[j].Vulns)
+	})
+
+	return upgrades, nil
+}
+
+// vulnerableModule collects the govulncheck findings for one module
+type vulnerableModule struct {
+	IDs          []string
+	FixedVersion string
+}
+
+// findVulnerableModules runs govulncheck, if installed, and groups its findings by module
+func findVulnerableModules(ctx context.Context) (map[string]vulnerableModule, error) {
+	vulns := map[string]vulnerableModule{}
+	if _, err := exec.LookPath("govulncheck"); err != nil {
+		fmt.Println("deps: govulncheck not found, checking for outdated modules only")
+		return vulns, nil
+	}
+
+	// govulncheck exits non-zero when it finds something, so only the output matters
+	result, err := runProgram(ctx, "govulncheck", "-json", "./...")
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(result.Output))
+	for {
+		var message struct {
+			Finding *struct {
+				OSV          string `json:"osv"`
+				FixedVersion string `json:"fixed_version"`
+				Trace        []struct {
+					Module string `json:"module"`
+				} `json:"trace"`
+			} `json:"finding"`
+		}
+		if err := decoder.Decode(&message); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse govulncheck output: %w", err)
+		}
+
+		finding := message.Finding
+		if finding == nil || len(finding.Trace) == 0 || finding.Trace[0].Module == "stdlib" {
+			continue
+		}
+
+		module := vulns[finding.Trace[0].Module]
+		if !slices.Contains(module.IDs, finding.OSV) {
+			module.IDs = append(module.IDs, finding.OSV)
+		}
+		if finding.FixedVersion != "" {
+			module.FixedVersion = finding.FixedVersion
+		}
+		vulns[finding.Trace[0].Module] = module
+	}
+
+	return vulns, nil
+}
+
+// printUpgradeReport writes one line per dependency with the outcome of its upgrade
+func printUpgradeReport(w io.Writer, upgrades []*DependencyUpgrade) {
+	fmt.Fprintln(w, "\nDependency upgrade report:")
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "MODULE\tFROM\tTO\tSTATUS\tNOTES")
+	for _, upgrade := range upgrades {
+		notes := []string{}
+		if len(upgrade.Vulns) > 0 {
+			notes = append(notes, "fixes "+strings.Join(upgrade.Vulns, ", "))
+		}
+		if len(upgrade.Files) > 0 {
+			notes = append(notes, "changed "+strings.Join(upgrade.Files, ", "))
+		}
+		if upgrade.Detail != "" {
+			notes = append(notes, upgrade.Detail)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", upgrade.Path, upgrade.From, upgrade.To, upgrade.Status, strings.Join(notes, "; "))
+	}
+	table.Flush()
+}
+
+// firstLine returns the first non-empty line of text
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// COMPARING RUNS
+// =============================================================================
+
+// defaultDiffWidth is the width of the side-by-side report without COLUMNS
+const defaultDiffWidth = 120
+
+// runSummary is what diff-sessions compares of one run of a task
+type runSummary struct {
+	Name         string // Session ID or log file
+	Task         string // The first message
+	Models       []string
+	Outcome      string // From a --log file; sessions have none
+	Answer       string // Claude's last reply
+	Files        []string
+	Turns        int // Messages from the user, not counting tool results
+	Calls        int
+	ToolCalls    int
+	InputTokens  int64
+	OutputTokens int64
+	Cost         float64
+}
+
+// runDiffSessionsCommand implements `go-agent diff-sessions A B`
+func runDiffSessionsCommand(args []string) error {
+	flags := flag.NewFlagSet("diff-sessions", flag.ContinueOnError)
+	width := flags.Int("width", configInt("COLUMNS", defaultDiffWidth), "width of the report in columns")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() != 2 {
+		return usageErrorf("usage: go-agent diff-sessions [--width N] A B (saved session IDs or --log files)")
+	}
+
+	runs := []*runSummary{}
+	for _, arg := range flags.Args() {
+		run, err := summarizeRun(context.TODO(), arg)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+	fmt.Print(renderRunDiff(runs[0], runs[1], max(*width, 60)))
+	return nil
+}
+
+// summarizeRun reads a run from a --log file, or else a saved session
+func summarizeRun(ctx context.Context, name string) (*runSummary, error) {
+	if info, err := os.Stat(name); err == nil && !info.IsDir() {
+		events, err := readEventLog(name)
+		if err != nil {
+			return nil, err
+		}
+		return summarizeLogRun(ctx, name, events), nil
+	}
+	session, err := loadSavedSession(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a --log file nor a saved session: %w", name, err)
+	}
+	return summarizeSessionRun(session), nil
+}
+
+// summarizeLogRun compares a run by its event log, counting what eval-report counts
+func summarizeLogRun(ctx context.Context, log string, events []Event) *runSummary {
+	task := summarizeEvalRun(ctx, log, events)
+	run := &runSummary{
+		Name:         log,
+		Task:         task.Prompt,
+		Outcome:      task.Outcome,
+		Calls:        task.Calls,
+		ToolCalls:    task.ToolCalls,
+		InputTokens:  task.InputTokens,
+		OutputTokens: task.OutputTokens,
+		Cost:         task.Cost,
+	}
+	for _, diff := range task.Diffs {
+		run.Files = appendUnique(run.Files, diff.Path)
+	}
+	for _, event := range events {
+		switch event.Type {
+		case eventUserMessage:
+			run.Turns++
+		case eventAssistantText:
+			run.Answer = event.Text
+		case eventInference:
+			run.Models = appendUnique(run.Models, event.Model)
+		}
+	}
+	slices.Sort(run.Files)
+	return run
+}
+
+// summarizeSessionRun compares a run by its saved conversation. Only the session's usage
+// says which models answered and what the calls cost.
+func summarizeSessionRun(session *savedSession) *runSummary {
+	run := &runSummary{
+		Name:         session.ID,
+		Task:         sessionTitle(session.conversation),
+		Models:       session.Usage.Models,
+		Calls:        session.Usage.Calls,
+		InputTokens:  session.Usage.InputTokens + session.Usage.CacheWriteTokens + session.Usage.CacheReadTokens,
+		OutputTokens: session.Usage.OutputTokens,
+		Cost:         session.Usage.Cost,
+	}
+	edits := map[string]string{} // Paths of edit_file calls by tool use ID
+	for _, message := range session.conversation {
+		text := []string{}
+		asked := false
+		for _, block := range message.Content {
+			switch {
+			case block.OfText != nil:
+				text = append(text, block.OfText.Text)
+				asked = asked || attachmentName(block) == ""
+			case block.OfToolUse != nil:
+				run.ToolCalls++
+				if block.OfToolUse.Name == EditFileDefinition.Name {
+					data, _ := json.Marshal(block.OfToolUse.Input)
+					input := EditFileInput{}
+					json.Unmarshal(data, &input)
+					edits[block.OfToolUse.ID] = input.Path
+				}
+			case block.OfToolResult != nil:
+				// Only edits that were made count as files touched
+				if path, ok := edits[block.OfToolResult.ToolUseID]; ok && !block.OfToolResult.IsError.Value {
+					run.Files = appendUnique(run.Files, path)
+				}
+			}
+		}
+		switch {
+		case message.Role == anthropic.MessageParamRoleUser && asked:
+			run.Turns++
+		case message.Role == anthropic.MessageParamRoleAssistant && len(text) > 0:
+			run.Answer = strings.Join(text, "\n")
+		}
+	}
+	slices.Sort(run.Files)
+	return run
+}
+
+// appendUnique adds value to list unless it is empty or already there
+func appendUnique(list []string, value string) []string {
+	if value == "" || slices.Contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
+// renderRunDiff lays out two runs side by side, one row per compared property. Numbers
+// of the second run show how they differ from the first.
+func renderRunDiff(a, b *runSummary, width int) string {
+	const labelWidth = 13
+	column := (width - labelWidth - 3) / 2
+	var report strings.Builder
+	row := func(label, left, right string) {
+		leftLines, rightLines := wrapColumn(left, column), wrapColumn(right, column)
+		for i := range max(len(leftLines), len(rightLines)) {
+			cell := func(lines []string) string {
+				if i < len(lines) {
+					return lines[i]
+				}
+				return ""
+			}
+			if i > 0 {
+				label = ""
+			}
+			line := fmt.Sprintf("%-*s %s%s %s", labelWidth, label, cell(leftLines), strings.Repeat(" ", column-utf8.RuneCountInString(cell(leftLines))), cell(rightLines))
+			report.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+	}
+	count := func(value, other int64) string {
+		if value == other {
+			return fmt.Sprint(value)
+		}
+		return fmt.Sprintf("%d (%+d)", value, value-other)
+	}
+	orNone := func(text string) string {
+		if text == "" {
+			return "-"
+		}
+		return text
+	}
+
+	row("", "A: "+a.Name, "B: "+b.Name)
+	row("Task", orNone(a.Task), sameOr(a.Task, b.Task, orNone(b.Task)))
+	row("Models", orNone(strings.Join(a.Models, ", ")), orNone(strings.Join(b.Models, ", ")))
+	if a.Outcome != "" || b.Outcome != "" {
+		row("Outcome", orNone(a.Outcome), orNone(b.Outcome))
+	}
+	row("Turns", fmt.Sprint(a.Turns), count(int64(b.Turns), int64(a.Turns)))
+	row("Model calls", fmt.Sprint(a.Calls), count(int64(b.Calls), int64(a.Calls)))
+	row("Tool calls", fmt.Sprint(a.ToolCalls), count(int64(b.ToolCalls), int64(a.ToolCalls)))
+	row("Input tokens", fmt.Sprint(a.InputTokens), count(b.InputTokens, a.InputTokens))
+	row("Output tokens", fmt.Sprint(a.OutputTokens), count(b.OutputTokens, a.OutputTokens))
+	costB := fmt.Sprintf("$%.4f", b.Cost)
+	if a.Cost > 0 && b.Cost != a.Cost {
+		costB += fmt.Sprintf(" (%+.0f%%)", 100*(b.Cost-a.Cost)/a.Cost)
+	}
+	row("Cost", fmt.Sprintf("$%.4f", a.Cost), costB)
+
+	// Files line up across the columns, so those only one run touched stand out
+	files := slices.Sorted(slices.Values(append(slices.Clone(a.Files), b.Files...)))
+	files = slices.Compact(files)
+	if len(files) == 0 {
+		row("Files", "-", "-")
+	}
+	for i, file := range files {
+		label := ""
+		if i == 0 {
+			label = "Files"
+		}
+		left, right := "", ""
+		if slices.Contains(a.Files, file) {
+			left = file
+		}
+		if slices.Contains(b.Files, file) {
+			right = file
+		}
+		row(label, left, right)
+	}
+	row("Answer", orNone(a.Answer), sameOr(a.Answer, b.Answer, orNone(b.Answer)))
+	return report.String()
+}
+
+// sameOr shows "(same)" in place of text the other run has too
+func sameOr(a, b, text string) string {
+	if a == b && a != "" {
+		return "(same)"
+	}
+	return text
+}
+
+// wrapColumn breaks text into lines of at most width runes, at spaces where it can
+func wrapColumn(text string, width int) []string {
+	lines := []string{}
+	for _, paragraph := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for utf8.RuneCountInString(word) > width {
+				if line != "" {
+					lines, line = append(lines, line), ""
+				}
+				runes := []rune(word)
+				lines, word = append(lines, string(runes[:width])), string(runes[width:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+				line += " " + word
+			default:
+				lines, line = append(lines, line), word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
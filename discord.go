@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// DISCORD BOT
+// =============================================================================
+
+// Discord interaction and response types used by the bot
+const (
+	discordPing             = 1
+	discordCommand          = 2
+	discordComponent        = 3
+	discordPong             = 1
+	discordChannelMessage   = 4
+	discordUpdateMessage    = 7
+	discordEphemeral        = 1 << 6
+	discordButtonSuccess    = 3
+	discordButtonDanger     = 4
+	discordMaxMessageLength = 2000
+)
+
+// discordCommands are the slash commands registered by `discord --register`
+var discordCommands = []map[string]any{
+	{
+		"name":        "agent",
+		"description": "Ask the agent to do something in this channel's session",
+		"options": []map[string]any{
+			{"type": 3, "name": "task", "description": "What to do", "required": true},
+		},
+	},
+	{"name": "agent-reset", "description": "Forget this channel's conversation with the agent"},
+}
+
+// discordInteraction is the subset of an interaction payload the bot reads
+type discordInteraction struct {
+	Type      int    `json:"type"`
+	ChannelID string `json:"channel_id"`
+	Data      struct {
+		Name     string `json:"name"`
+		CustomID string `json:"custom_id"`
+		Options  []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+	User    *discordUser `json:"user"`
+	Message *struct {
+		Content string `json:"content"`
+	} `json:"message"`
+}
+
+// discordUser identifies who sent an interaction
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// sender returns the user behind an interaction, in a server channel or a DM
+func (i discordInteraction) sender() discordUser {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	if i.User != nil {
+		return *i.User
+	}
+	return discordUser{}
+}
+
+// discordApproval is a tool call waiting for its requester to click a button
+type discordApproval struct {
+	requester string
+	answer    chan bool
+}
+
+// DiscordBot serves Discord interactions and keeps a session per channel
+type DiscordBot struct {
+	api       restClient
+	publicKey ed25519.PublicKey
+	sessions  *chatSessions
+
+	mu        sync.Mutex
+	approvals map[string]*discordApproval
+	nextID    int
+}
+
+// runDiscordCommand implements `go-agent discord`
+func runDiscordCommand(args []string) error {
+	flags := flag.NewFlagSet("discord", flag.ContinueOnError)
+	addr := flags.String("addr", ":8080", "address to listen on for the interactions endpoint")
+	register := flags.Bool("register", false, "register the /agent slash commands and exit")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	token := configValue("DISCORD_BOT_TOKEN")
+	applicationID := configValue("DISCORD_APPLICATION_ID")
+	if token == "" || applicationID == "" {
+		return fmt.Errorf("DISCORD_BOT_TOKEN and DISCORD_APPLICATION_ID are required")
+	}
+	baseURL := configValue("DISCORD_API_URL")
+	if baseURL == "" {
+		baseURL = "https://discord.com/api/v10"
+	}
+	api := newRESTClient(baseURL, map[string]string{"Authorization": "Bot " + token})
+
+	if *register {
+		path := "/applications/" + applicationID + "/commands"
+		if err := api.sendJSON(context.TODO(), http.MethodPut, path, discordCommands, nil); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "discord: registered /agent and /agent-reset")
+		return nil
+	}
+
+	publicKey, err := hex.DecodeString(configValue("DISCORD_PUBLIC_KEY"))
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("DISCORD_PUBLIC_KEY must be the application's hex-encoded public key")
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	bot := &DiscordBot{
+		api:       api,
+		publicKey: publicKey,
+		sessions:  newChatSessions(client),
+		approvals: map[string]*discordApproval{},
+	}
+
+	http.HandleFunc("/", bot.handleInteraction)
+	fmt.Fprintf(os.Stderr, "discord: interactions endpoint listening on %s\n", *addr)
+	return http.ListenAndServe(*addr, nil)
+}
+
+// handleInteraction verifies and answers one interaction delivered by Discord
+func (b *DiscordBot) handleInteraction(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	message := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if err != nil || !ed25519.Verify(b.publicKey, message, signature) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	interaction := discordInteraction{}
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	var response map[string]any
+	switch interaction.Type {
+	case discordPing:
+		response = map[string]any{"type": discordPong}
+	case discordCommand:
+		response = b.handleCommand(interaction)
+	case discordComponent:
+		response = b.handleButton(interaction)
+	default:
+		http.Error(w, "unsupported interaction", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleCommand starts a task or resets the channel's session
+func (b *DiscordBot) handleCommand(interaction discordInteraction) map[string]any {
+	session := b.sessions.get(interaction.ChannelID)
+
+	if interaction.Data.Name == "agent-reset" {
+		if !session.reset() {
+			return discordReply("The agent is still working in this channel.", true)
+		}
+		return discordReply("Conversation cleared.", false)
+	}
+
+	task := ""
+	for _, option := range interaction.Data.Options {
+		if option.Name == "task" {
+			task = option.Value
+		}
+	}
+	if strings.TrimSpace(task) == "" {
+		return discordReply("Tell me what to do, e.g. `/agent task: explain main.go`.", true)
+	}
+	if !session.mu.TryLock() {
+		return discordReply("The agent is still working on the previous task in this channel.", true)
+	}
+
+	user := interaction.sender()
+	go func() {
+		defer session.mu.Unlock()
+		b.runTask(interaction.ChannelID, session, user, task)
+	}()
+	return discordReply(fmt.Sprintf("<@%s> asked: %s\nWorking on it…", user.ID, task), false)
+}
+
+// runTask runs one turn of the channel's session and posts the reply
+func (b *DiscordBot) runTask(channelID string, session *chatSession, user discordUser, task string) {
+	ctx := context.TODO()
+
+	reply, changed, err := session.run(ctx, task, user.Username, func(question string) bool {
+		return b.askApproval(ctx, channelID, user, question)
+	})
+	if err != nil {
+		b.post(ctx, channelID, "The agent failed: "+err.Error(), nil)
+		return
+	}
+
+	if len(changed) > 0 {
+		reply += "\n\n-# Files changed: " + strings.Join(changed, ", ")
+	}
+	for _, chunk := range splitMessage(reply, discordMaxMessageLength) {
+		b.post(ctx, channelID, chunk, nil)
+	}
+}
+
+// askApproval posts approve/deny buttons and waits for the requester to click one
+func (b *DiscordBot) askApproval(ctx context.Context, channelID string, user discordUser, question string) bool {
+	b.mu.Lock()
+	b.nextID++
+	id := fmt.Sprintf("%d", b.nextID)
+	approval := &discordApproval{requester: user.ID, answer: make(chan bool, 1)}
+	b.approvals[id] = approval
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.approvals, id)
+		b.mu.Unlock()
+	}()
+
+	question = strings.TrimSuffix(question, " [y/N] ")
+	buttons := []map[string]any{{
+		"type": 1,
+		"components": []map[string]any{
+			{"type": 2, "style": discordButtonSuccess, "label": "Approve", "custom_id": "approve:" + id},
+			{"type": 2, "style": discordButtonDanger, "label": "Deny", "custom_id": "deny:" + id},
+		},
+	}}
+	content := fmt.Sprintf("<@%s> %s", user.ID, truncateRunes(question, discordMaxMessageLength-100))
+	if err := b.post(ctx, channelID, content, buttons); err != nil {
+		return false
+	}
+
+	select {
+	case answer := <-approval.answer:
+		return answer
+	case <-time.After(chatApprovalTimeout):
+		b.post(ctx, channelID, "No answer to the approval request; the tool call was denied.", nil)
+		return false
+	}
+}
+
+// handleButton resolves a pending approval when its requester clicks a button
+func (b *DiscordBot) handleButton(interaction discordInteraction) map[string]any {
+	action, id, _ := strings.Cut(interaction.Data.CustomID, ":")
+
+	b.mu.Lock()
+	approval := b.approvals[id]
+	b.mu.Unlock()
+	if approval == nil {
+		return discordReply("This approval request has expired.", true)
+	}
+
+	user := interaction.sender()
+	if user.ID != approval.requester {
+		return discordReply("Only the person who started the task can answer this.", true)
+	}
+
+	approved := action == "approve"
+	select {
+	case approval.answer <- approved:
+	default:
+	}
+
+	verdict := "Denied"
+	if approved {
+		verdict = "Approved"
+	}
+	original := ""
+	if interaction.Message != nil {
+		original = interaction.Message.Content
+	}
+	return map[string]any{
+		"type": discordUpdateMessage,
+		"data": map[string]any{
+			"content":    fmt.Sprintf("%s\n%s by <@%s>", original, verdict, user.ID),
+			"components": []any{},
+		},
+	}
+}
+
+// post sends a message, optionally with components, to a channel
+func (b *DiscordBot) post(ctx context.Context, channelID, content string, components []map[string]any) error {
+	message := map[string]any{"content": content, "allowed_mentions": map[string]any{"parse": []string{"users"}}}
+	if components != nil {
+		message["components"] = components
+	}
+	err := b.api.sendJSON(ctx, http.MethodPost, "/channels/"+channelID+"/messages", message, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "discord: failed to post to %s: %s\n", channelID, err.Error())
+	}
+	return err
+}
+
+// discordReply builds an immediate interaction response with a message
+func discordReply(content string, ephemeral bool) map[string]any {
+	data := map[string]any{"content": content, "allowed_mentions": map[string]any{"parse": []string{}}}
+	if ephemeral {
+		data["flags"] = discordEphemeral
+	}
+	return map[string]any{"type": discordChannelMessage, "data": data}
+}
+
+// splitMessage cuts text into chunks of at most limit runes, preferring line breaks
+func splitMessage(text string, limit int) []string {
+	chunks := []string{}
+	for text != "" {
+		runes := []rune(text)
+		if len(runes) <= limit {
+			chunks = append(chunks, text)
+			break
+		}
+		chunk := string(runes[:limit])
+		if i := strings.LastIndexByte(chunk, '\n'); i > 0 {
+			chunk = chunk[:i+1]
+		}
+		chunks = append(chunks, chunk)
+		text = text[len(chunk):]
+	}
+	return chunks
+}
+
+// truncateRunes shortens text to at most limit runes, marking the cut
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
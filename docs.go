@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// DOCUMENTATION WORKFLOW
+// =============================================================================
+
+// docsPrompt asks Claude to document a package's exported symbols
+const docsPrompt = "Add GoDoc comments to the exported symbols of the Go package in %s that lack one.\n" +
+	"Read the code first so every comment is accurate. Follow Go conventions: a comment starts with the name of the " +
+	"symbol it documents and is a complete sentence. Only add comments; do not change any code.\n\n" +
+	"Missing doc comments:\n%s"
+
+// docsReadmePrompt asks Claude to write or refresh a package README
+const docsReadmePrompt = "Write or refresh %s so it describes the package in %s based on its code: " +
+	"what it is for, its main types and functions, and a short usage example. Keep anything in an existing README that is still accurate."
+
+// MissingDoc is an exported symbol without a doc comment
+type MissingDoc struct {
+	File string
+	Line int
+	Kind string // func, method, type, const, var or package
+	Name string
+}
+
+// runDocsCommand implements `go-agent docs --package ./...`
+func runDocsCommand(args []string) error {
+	flags := flag.NewFlagSet("docs", flag.ContinueOnError)
+	pattern := flags.String("package", "./...", "package directory, or dir/... for all packages below it")
+	readme := flags.Bool("readme", false, "also generate or refresh a README.md for each package")
+	patchFile := flags.String("patch", "", "write changes to this patch file and revert them instead of asking")
+	yes := flags.Bool("yes", false, "keep all changes without asking")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	dirs, err := packageDirs(*pattern)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+
+	work := map[string][]MissingDoc{}
+	for _, dir := range dirs {
+		missing, err := findMissingDocs(dir)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 || *readme {
+			work[dir] = missing
+		}
+	}
+	if len(work) == 0 {
+		fmt.Println("docs: every exported symbol already has a doc comment")
+		return nil
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+	agent := NewAgent(client, nil, defaultTools())
+
+	snapshot := FileSnapshot{}
+	for _, dir := range sortedKeys(work) {
+		missing := work[dir]
+		fmt.Fprintf(os.Stderr, "%s: %s (%d missing)\n", paint(roleStatus, "docs"), dir, len(missing))
+
+		files, err := goSourceFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			snapshot.Add(file)
+		}
+
+		if len(missing) > 0 {
+			prompt := fmt.Sprintf(docsPrompt, dir, formatMissingDocs(missing))
+			conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt))}
+			if _, err := agent.runTurn(ctx, conversation); err != nil {
+				return err
+			}
+		}
+
+		if *readme {
+			readmePath := filepath.Join(dir, "README.md")
+			snapshot.Add(readmePath)
+			prompt := fmt.Sprintf(docsReadmePrompt, readmePath, dir)
+			conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt))}
+			if _, err := agent.runTurn(ctx, conversation); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Claude may have touched files outside the packages it was pointed at
+	for _, path := range agent.editedFiles {
+		snapshot.Add(path)
+	}
+
+	return reviewPatches(ctx, snapshot, *patchFile, *yes)
+}
+
+// reviewPatches shows each changed file as a diff and keeps or reverts it.
+// With patchFile set, all changes are written there and reverted; with yes, all are kept.
+func reviewPatches(ctx context.Context, snapshot FileSnapshot, patchFile string, yes bool) error {
+	changed := snapshot.Changed()
+	if len(changed) == 0 {
+		fmt.Println("No files were changed.")
+		return nil
+	}
+
+	// Nobody is around to answer in CI; --mode decides what happens to the changes
+	yes = yes || runOptions.CI
+
+	var patch strings.Builder
+	for _, path := range changed {
+		diff, err := snapshot.Diff(ctx, path)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".go") && !sameCodeIgnoringComments(snapshot[path], path) {
+			fmt.Fprintf(os.Stderr, "%s: %s has changes beyond comments\n", paint(roleWarning, "warning"), path)
+		}
+
+		if patchFile != "" {
+			patch.WriteString(diff)
+			if err := snapshot.Restore(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Print(colorDiff(diff))
+		if yes {
+			continue
+		}
+
+		fmt.Printf("Keep changes to %s? [y/N] ", path)
+		answer, _ := stdinReader().ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			if err := snapshot.Restore(path); err != nil {
+				return err
+			}
+			fmt.Printf("Reverted %s\n", path)
+		}
+	}
+
+	if patchFile != "" {
+		if err := os.WriteFile(patchFile, []byte(patch.String()), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d file change(s) to %s (apply with `git apply %s`)\n", len(changed), patchFile, patchFile)
+	}
+
+	return nil
+}
+
+// sameCodeIgnoringComments reports whether path still holds the same Go code as before,
+// once comments and formatting are ignored
+func sameCodeIgnoringComments(before *string, path string) bool {
+	if before == nil {
+		return false
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	strip := func(src []byte) (string, bool) {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, path, src, 0)
+		if err != nil {
+			return "", false
+		}
+		var out bytes.Buffer
+		if err := printer.Fprint(&out, fset, file); err != nil {
+			return "", false
+		}
+		return out.String(), true
+	}
+
+	old, ok1 := strip([]byte(*before))
+	new, ok2 := strip(after)
+	return ok1 && ok2 && old == new
+}
+
+// packageDirs expands a package pattern (dir or dir/...) into directories containing Go files
+func packageDirs(pattern string) ([]string, error) {
+	root, recursive := strings.CutSuffix(filepath.ToSlash(pattern), "/...")
+	if root == "" || root == "..." {
+		root, recursive = ".", true
+	}
+
+	if !recursive {
+		return []string{filepath.Clean(root)}, nil
+	}
+
+	dirs := []string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if path != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata") {
+			return filepath.SkipDir
+		}
+		files, err := goSourceFiles(path)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+
+	return dirs, err
+}
+
+// goSourceFiles lists the non-test Go files directly inside dir
+func goSourceFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files, nil
+}
+
+// findMissingDocs parses the package in dir and lists exported symbols without doc comments
+func findMissingDocs(dir string) ([]MissingDoc, error) {
+	files, err := goSourceFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	missing := []MissingDoc{}
+	hasPackageDoc := false
+	packageName := ""
+
+	for _, path := range files {
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		packageName = file.Name.Name
+		hasPackageDoc = hasPackageDoc || file.Doc != nil
+
+		add := func(pos token.Pos, kind, name string) {
+			missing = append(missing, MissingDoc{File: path, Line: fset.Position(pos).Line, Kind: kind, Name: name})
+		}
+
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Doc != nil || !decl.Name.IsExported() {
+					continue
+				}
+				if decl.Recv == nil {
+					add(decl.Pos(), "func", decl.Name.Name)
+				} else if receiver := receiverTypeName(decl.Recv); ast.IsExported(receiver) {
+					add(decl.Pos(), "method", receiver+"."+decl.Name.Name)
+				}
+			case *ast.GenDecl:
+				// A comment on a grouped declaration documents the whole group
+				if decl.Doc != nil || decl.Tok == token.IMPORT {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if spec.Doc == nil && spec.Name.IsExported() {
+							add(spec.Pos(), "type", spec.Name.Name)
+						}
+					case *ast.ValueSpec:
+						if spec.Doc != nil {
+							continue
+						}
+						for _, name := range spec.Names {
+							if name.IsExported() {
+								add(name.Pos(), decl.Tok.String(), name.Name)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Commands document themselves at the top of main.go; library packages need a package comment
+	if len(files) > 0 && !hasPackageDoc && packageName != "main" {
+		missing = append([]MissingDoc{{File: files[0], Line: 1, Kind: "package", Name: packageName}}, missing...)
+	}
+
+	return missing, nil
+}
+
+// receiverTypeName returns the type name of a method receiver, without pointers or type parameters
+func receiverTypeName(recv *ast.FieldList) string {
+	if len(recv.List) == 0 {
+		return ""
+	}
+	expr := recv.List[0].Type
+	for {
+		switch t := expr.(type) {
+		case *ast.StarExpr:
+			expr = t.X
+		case *ast.IndexExpr:
+			expr = t.X
+		case *ast.IndexListExpr:
+			expr = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// formatMissingDocs renders missing doc comments as a bullet list
+func formatMissingDocs(missing []MissingDoc) string {
+	var list strings.Builder
+	for _, m := range missing {
+		fmt.Fprintf(&list, "- %s %s (%s:%d)\n", m.Kind, m.Name, m.File, m.Line)
+	}
+	return list.String()
+}
+
+// sortedKeys returns the keys of a string-keyed map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
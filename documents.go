@@ -0,0 +1,323 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// PDF AND OFFICE DOCUMENTS
+// =============================================================================
+
+// pdfPageTokens is about what a PDF page costs: its text and an image of the page
+const pdfPageTokens = 2000
+
+// pdfBytesPerPage guesses the pages of a PDF whose page objects are compressed
+const pdfBytesPerPage = 50_000
+
+// maxOfficePartBytes caps what is read of each part of an office document
+const maxOfficePartBytes = 50 * 1024 * 1024
+
+// officeExtensions are the office documents whose text is extracted locally
+var officeExtensions = []string{".docx", ".pptx", ".xlsx", ".odt", ".odp", ".ods"}
+
+// pdfPage matches the page objects of a PDF, but not its page tree
+var pdfPage = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// partNumber matches the number of a slide or sheet part, such as ppt/slides/slide12.xml
+var partNumber = regexp.MustCompile(`(\d+)\.xml$`)
+
+// xmlBreaks are what the end of an element adds to extracted text, by local name: line
+// breaks after paragraphs and table rows, tabs after table cells
+var xmlBreaks = map[string]string{
+	"p": "\n", "h": "\n", "tr": "\n", "table-row": "\n",
+	"tc": "\t", "table-cell": "\t",
+}
+
+// xmlMarks are what empty elements stand for in extracted text, by local name
+var xmlMarks = map[string]string{
+	"tab": "\t", "br": "\n", "line-break": "\n", "s": " ",
+}
+
+// isPDF reports whether path names a PDF by its extension
+func isPDF(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".pdf"
+}
+
+// isOfficeDocument reports whether path names an office document by its extension
+func isOfficeDocument(path string) bool {
+	return slices.Contains(officeExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// readAttachment reads an @mentioned file: PDFs as they are, office documents as the text
+// extracted from them, and other files as text
+func readAttachment(path string) (*Attachment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isPDF(path):
+		if !bytes.HasPrefix(data, []byte("%PDF-")) {
+			return nil, fmt.Errorf("%s is not a PDF", path)
+		}
+		return &Attachment{Path: path, PDF: data, Tokens: pdfPages(data) * pdfPageTokens}, nil
+	case isOfficeDocument(path):
+		text, err := officeText(path, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the text of %s: %w", path, err)
+		}
+		return &Attachment{Path: path, Content: text, Tokens: estimateTokens(text)}, nil
+	default:
+		return &Attachment{Path: path, Content: string(data), Tokens: estimateTokens(string(data))}, nil
+	}
+}
+
+// pdfPages counts the pages of a PDF, or guesses them from its size when its page
+// objects are compressed
+func pdfPages(data []byte) int {
+	if pages := len(pdfPage.FindAllIndex(data, -1)); pages > 0 {
+		return pages
+	}
+	return max(1, len(data)/pdfBytesPerPage)
+}
+
+// pdfBlock sends a PDF inline as a document, which Claude reads page by page, text and
+// images both
+func pdfBlock(attachment *Attachment) anthropic.ContentBlockParamUnion {
+	document := &anthropic.DocumentBlockParam{
+		Source: anthropic.DocumentBlockParamSourceUnion{OfBase64: &anthropic.Base64PDFSourceParam{
+			Data: base64.StdEncoding.EncodeToString(attachment.PDF),
+		}},
+		Title: anthropic.String(attachment.Path),
+	}
+	if citeable(attachment.Path) {
+		document.Citations = anthropic.CitationsConfigParam{Enabled: anthropic.Bool(true)}
+	}
+	return anthropic.ContentBlockParamUnion{OfDocument: document}
+}
+
+// pdfOvercount is how much estimateTokens overcounts the inline PDFs of messages, as it
+// sizes them by their base64 data rather than their pages
+func pdfOvercount(messages []anthropic.MessageParam) int {
+	overcount := 0
+	for _, message := range messages {
+		for _, block := range message.Content {
+			if block.OfDocument == nil || block.OfDocument.Source.OfBase64 == nil {
+				continue
+			}
+			encoded := block.OfDocument.Source.OfBase64.Data
+			data, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				continue
+			}
+			overcount += max(0, estimateTokens(encoded)-pdfPages(data)*pdfPageTokens)
+		}
+	}
+	return overcount
+}
+
+// officeText extracts the text of a Word, PowerPoint or Excel document, or of their
+// OpenDocument counterparts, keeping paragraphs, slides and table rows apart
+func officeText(path string, data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".docx":
+		return xmlPartText(archive, "word/document.xml", "t")
+	case ".pptx":
+		return slidesText(archive)
+	case ".xlsx":
+		return workbookText(archive)
+	default:
+		return xmlPartText(archive, "content.xml", "p", "h")
+	}
+}
+
+// xmlPartText extracts the text of one part of an office document: what is inside the
+// elements named textTags, with xmlBreaks and xmlMarks in between
+func xmlPartText(archive *zip.Reader, name string, textTags ...string) (string, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var text bytes.Buffer
+	decoder := xml.NewDecoder(io.LimitReader(file, maxOfficePartBytes))
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if slices.Contains(textTags, token.Name.Local) {
+				depth++
+			}
+			text.WriteString(xmlMarks[token.Name.Local])
+		case xml.EndElement:
+			if slices.Contains(textTags, token.Name.Local) {
+				depth--
+			}
+			// The break after a table cell or row replaces the one of its last paragraph
+			if brk := xmlBreaks[token.Name.Local]; brk != "" {
+				if data := text.Bytes(); len(data) > 0 && (data[len(data)-1] == '\n' || data[len(data)-1] == '\t') {
+					text.Truncate(text.Len() - 1)
+				}
+				text.WriteString(brk)
+			}
+		case xml.CharData:
+			if depth > 0 {
+				text.Write(token)
+			}
+		}
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+// slidesText extracts the text of a presentation's slides, in order
+func slidesText(archive *zip.Reader) (string, error) {
+	slides := numberedParts(archive, "ppt/slides/slide")
+	if len(slides) == 0 {
+		return "", errors.New("no slides found")
+	}
+	parts := []string{}
+	for i, slide := range slides {
+		text, err := xmlPartText(archive, slide, "t")
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("--- Slide %d ---\n%s", i+1, text))
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// workbookText extracts the cells of a workbook's sheets, a row per line and a tab
+// between cells
+func workbookText(archive *zip.Reader) (string, error) {
+	shared := []string{}
+	if file, err := archive.Open("xl/sharedStrings.xml"); err == nil {
+		var table struct {
+			Items []struct {
+				Text string `xml:",innerxml"`
+			} `xml:"si"`
+		}
+		err := xml.NewDecoder(io.LimitReader(file, maxOfficePartBytes)).Decode(&table)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+		for _, item := range table.Items {
+			shared = append(shared, innerText(item.Text))
+		}
+	}
+
+	sheets := numberedParts(archive, "xl/worksheets/sheet")
+	if len(sheets) == 0 {
+		return "", errors.New("no sheets found")
+	}
+	parts := []string{}
+	for i, name := range sheets {
+		file, err := archive.Open(name)
+		if err != nil {
+			return "", err
+		}
+		var sheet struct {
+			Rows []struct {
+				Cells []struct {
+					Type   string `xml:"t,attr"`
+					Value  string `xml:"v"`
+					Inline struct {
+						Text string `xml:",innerxml"`
+					} `xml:"is"`
+				} `xml:"c"`
+			} `xml:"sheetData>row"`
+		}
+		err = xml.NewDecoder(io.LimitReader(file, maxOfficePartBytes)).Decode(&sheet)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+		rows := []string{}
+		for _, row := range sheet.Rows {
+			cells := []string{}
+			for _, cell := range row.Cells {
+				value := cell.Value
+				switch cell.Type {
+				case "s":
+					if index, err := strconv.Atoi(value); err == nil && index >= 0 && index < len(shared) {
+						value = shared[index]
+					}
+				case "inlineStr":
+					value = innerText(cell.Inline.Text)
+				}
+				cells = append(cells, value)
+			}
+			rows = append(rows, strings.Join(cells, "\t"))
+		}
+		parts = append(parts, fmt.Sprintf("--- Sheet %d ---\n%s", i+1, strings.Join(rows, "\n")))
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// numberedParts lists the parts named prefix followed by a number, such as the slides of
+// a presentation, in the order of their numbers
+func numberedParts(archive *zip.Reader, prefix string) []string {
+	parts := []string{}
+	for _, file := range archive.File {
+		if strings.HasPrefix(file.Name, prefix) && partNumber.MatchString(file.Name) {
+			parts = append(parts, file.Name)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		a, _ := strconv.Atoi(partNumber.FindStringSubmatch(parts[i])[1])
+		b, _ := strconv.Atoi(partNumber.FindStringSubmatch(parts[j])[1])
+		return a < b
+	})
+	return parts
+}
+
+// innerText joins the text of the <t> elements in a fragment of spreadsheet XML
+func innerText(fragment string) string {
+	var text strings.Builder
+	decoder := xml.NewDecoder(strings.NewReader(fragment))
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			inText = token.Name.Local == "t"
+		case xml.EndElement:
+			inText = false
+		case xml.CharData:
+			if inText {
+				text.Write(token)
+			}
+		}
+	}
+	return text.String()
+}
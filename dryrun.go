@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// =============================================================================
+// DRY RUN
+// =============================================================================
+
+// simulateTool describes what a mutating tool call would do in dry-run mode, without doing it
+func simulateTool(ctx context.Context, name string, input json.RawMessage) (string, error) {
+	if name != EditFileDefinition.Name {
+		return fmt.Sprintf("Dry run: %s(%s) was not run.", name, input), nil
+	}
+
+	editFileInput := EditFileInput{}
+	if err := json.Unmarshal(input, &editFileInput); err != nil {
+		return "", err
+	}
+	before, after, err := editedContent(editFileInput)
+	if err != nil {
+		return "", err
+	}
+	diff, err := diffContents(ctx, editFileInput.Path, before, after)
+	if err != nil {
+		return "", err
+	}
+
+	runOptions.dryRunChanges.Add(1)
+	fmt.Print(colorDiff(diff))
+	return "Dry run: nothing was written. The file on disk is unchanged, so later reads will not show this edit. It would apply:\n" + diff, nil
+}
+
+// summarizeDryRun tells the user what the dry run would have changed
+func (o *RunOptions) summarizeDryRun() {
+	count := o.dryRunChanges.Load()
+	if count == 0 {
+		fmt.Println("dry run: no changes planned")
+		return
+	}
+	message := fmt.Sprintf("dry run: %d edit(s) previewed above; nothing was written", count)
+	fmt.Println(message)
+	o.events.Emit(Event{Type: eventStatus, Text: strings.TrimPrefix(message, "dry run: ")})
+}
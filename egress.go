@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// =============================================================================
+// TOOL EGRESS POLICY
+// =============================================================================
+
+// egressDenyAll is the TOOL_EGRESS value that lets tools connect nowhere
+const egressDenyAll = "deny"
+
+// toolEgress returns the hosts TOOL_EGRESS lets tools connect to, and whether it
+// restricts them at all. An empty list with restricted set allows no host. Like the
+// sandbox settings, TOOL_EGRESS is not read from config.env.
+func toolEgress() (allowed []string, restricted bool) {
+	value := strings.TrimSpace(trustedConfigValue("TOOL_EGRESS"))
+	if value == "" {
+		return nil, false
+	}
+	if value == egressDenyAll {
+		return []string{}, true
+	}
+	return splitList(value), true
+}
+
+// hostAllowed reports whether host matches one of the allowed hosts, where *.example.com
+// matches the subdomains of example.com
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if host == pattern || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// egressKey is the context key of the current tool call's egress callback
+type egressKey struct{}
+
+// withEgressLog returns a context whose tool reports each connection it attempts to log
+func withEgressLog(ctx context.Context, log func(host string, err error)) context.Context {
+	return context.WithValue(ctx, egressKey{}, log)
+}
+
+// reportEgress tells whoever runs the tool that it connected, or was refused, to host
+func reportEgress(ctx context.Context, host string, err error) {
+	if log, ok := ctx.Value(egressKey{}).(func(string, error)); ok {
+		log(host, err)
+	}
+}
+
+// checkToolEgress fails for connections a tool may not make: to hosts outside the managed
+// settings' allowed hosts or outside TOOL_EGRESS. addr is a host or host:port. Both
+// outcomes are reported, so they land in the event log and the audit trail.
+func checkToolEgress(ctx context.Context, addr string) error {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	err := checkEgress(host)
+	if allowed, restricted := toolEgress(); err == nil && restricted && !hostAllowed(host, allowed) {
+		err = fmt.Errorf("connecting to %s is not allowed by TOOL_EGRESS", host)
+	}
+	reportEgress(ctx, host, err)
+	return err
+}
+
+// toolNetwork reports whether sandboxed commands may use the network. Since the sandbox
+// cannot tell hosts apart, any TOOL_EGRESS restriction cuts them off entirely, and the
+// refusal is reported as a connection to every host, *.
+func toolNetwork(ctx context.Context) bool {
+	if trustedConfigValue("SANDBOX_NETWORK") != "true" {
+		return false
+	}
+	if allowed, restricted := toolEgress(); restricted {
+		err := errors.New("sandboxed commands have no network while TOOL_EGRESS restricts it")
+		if len(allowed) > 0 {
+			err = fmt.Errorf("sandboxed commands have no network while TOOL_EGRESS allows only %s", strings.Join(allowed, ", "))
+		}
+		reportEgress(ctx, "*", err)
+		return false
+	}
+	return true
+}
+
+// toolTransport checks every request of a tool against the egress policy, redirects
+// included
+type toolTransport struct{}
+
+// RoundTrip implements http.RoundTripper
+func (toolTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := checkToolEgress(request.Context(), request.URL.Host); err != nil {
+		return nil, err
+	}
+	return http.DefaultTransport.RoundTrip(request)
+}
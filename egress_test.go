@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckToolEgress(t *testing.T) {
+	tests := []struct {
+		name, egress, addr string
+		allowed            bool
+	}{
+		{"unrestricted", "", "example.com:443", true},
+		{"deny", "deny", "example.com:443", false},
+		{"listed", "api.github.com, example.com", "example.com:443", true},
+		{"listed without port", "example.com", "EXAMPLE.com", true},
+		{"not listed", "api.github.com", "example.com:443", false},
+		{"subdomain", "*.example.com", "api.example.com:443", true},
+		{"wildcard is only subdomains", "*.example.com", "example.com:443", false},
+		{"suffix is not a subdomain", "*.example.com", "api.badexample.com:443", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TOOL_EGRESS", test.egress)
+			reported := []string{}
+			ctx := withEgressLog(context.Background(), func(host string, err error) {
+				reported = append(reported, host)
+				if (err == nil) != test.allowed {
+					t.Errorf("reported %s with error %v", host, err)
+				}
+			})
+			if err := checkToolEgress(ctx, test.addr); (err == nil) != test.allowed {
+				t.Errorf("checkToolEgress(%q) = %v, want allowed %v", test.addr, err, test.allowed)
+			}
+			if len(reported) != 1 || strings.Contains(reported[0], ":") {
+				t.Errorf("reported %q, want the host once", reported)
+			}
+		})
+	}
+}
+
+func TestToolTransportChecksRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusFound))
+	defer redirect.Close()
+	t.Setenv("TOOL_EGRESS", "127.0.0.1")
+
+	reported := []string{}
+	ctx := withEgressLog(context.Background(), func(host string, err error) {
+		reported = append(reported, host)
+	})
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, redirect.URL, nil)
+	_, err := (&http.Client{Transport: toolTransport{}}).Do(request)
+	if err == nil || !strings.Contains(err.Error(), "connecting to localhost is not allowed by TOOL_EGRESS") {
+		t.Errorf("following a redirect to localhost: %v", err)
+	}
+	if strings.Join(reported, " ") != "127.0.0.1 localhost" {
+		t.Errorf("reported %q, want 127.0.0.1 then localhost", reported)
+	}
+}
+
+func TestSandboxNetworkFollowsToolEgress(t *testing.T) {
+	tests := []struct {
+		egress, network string
+		want            bool
+	}{
+		{"", "true", true},
+		{"", "", false},
+		{"deny", "true", false},
+		{"example.com", "true", false},
+	}
+	for _, test := range tests {
+		t.Setenv("TOOL_EGRESS", test.egress)
+		t.Setenv("SANDBOX_NETWORK", test.network)
+		if got := newSandboxSpec(context.Background(), t.TempDir()).Network; got != test.want {
+			t.Errorf("TOOL_EGRESS=%q SANDBOX_NETWORK=%q: network %v, want %v", test.egress, test.network, got, test.want)
+		}
+	}
+}
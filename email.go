@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// EMAIL ADAPTER (IMAP/SMTP)
+// =============================================================================
+
+// emailTaskPrompt frames an email as a task whose answer is mailed back
+const emailTaskPrompt = "You received this task by email from %s. Work on it in the current directory without asking " +
+	"questions, since nobody can answer until you reply. Your final reply is sent back by email as plain text.\n\n" +
+	"Subject: %s\n\n%s"
+
+// EmailTask is a message accepted as a task
+type EmailTask struct {
+	UID       string
+	From      string
+	Subject   string
+	MessageID string
+	Body      string
+	Signed    []string // Domains the receiving server verified a DKIM signature of
+}
+
+// runEmailCommand implements `go-agent email`
+func runEmailCommand(args []string) error {
+	flags := flag.NewFlagSet("email", flag.ContinueOnError)
+	interval := flags.Duration("interval", time.Minute, "how often to poll the mailbox")
+	once := flags.Bool("once", false, "process the current messages and exit instead of polling")
+	transcripts := flags.String("transcripts", "transcripts", "directory for the JSON lines transcript of each task")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	allowed := splitList(configValue("EMAIL_ALLOWED_SENDERS"))
+	if len(allowed) == 0 {
+		return fmt.Errorf("EMAIL_ALLOWED_SENDERS must list the addresses (or @domains) that may send tasks")
+	}
+	authservID := configValue("EMAIL_AUTHSERV_ID")
+	if authservID == "" && runOptions.Approval != approvalDeny {
+		fmt.Fprintf(os.Stderr, "%s: EMAIL_AUTHSERV_ID is not set, so senders cannot be verified; tasks run with --approval deny\n", paint(roleWarning, "warning"))
+		runOptions.Approval = approvalDeny
+	}
+	if err := os.MkdirAll(*transcripts, 0755); err != nil {
+		return err
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	skip := map[string]bool{}
+	for {
+		tasks, err := fetchEmailTasks(allowed, configValue("EMAIL_SUBJECT_PREFIX"), authservID, skip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "email: %s\n", err.Error())
+		}
+		for _, task := range tasks {
+			fmt.Fprintf(os.Stderr, "%s: task from %s: %s\n", paint(roleStatus, "email"), task.From, task.Subject)
+			if err := runEmailTask(ctx, client, task, *transcripts); err != nil {
+				fmt.Fprintf(os.Stderr, "email: %s\n", err.Error())
+			}
+		}
+
+		if *once {
+			return err
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// fetchEmailTasks returns unseen messages that match the rule and marks them as seen, so a
+// task is picked up only once even if it fails. Messages that don't match stay unread; skip
+// remembers them so they are only looked at once. With an authservID, a sender only
+// matches when that server verified the DKIM signature of its domain.
+func fetchEmailTasks(allowed []string, subjectPrefix, authservID string, skip map[string]bool) ([]EmailTask, error) {
+	addr := configValue("IMAP_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("IMAP_ADDR (host:port of an IMAPS server) is required")
+	}
+	mailbox := configValue("IMAP_MAILBOX")
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+
+	imap, err := dialIMAP(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer imap.Close()
+
+	if _, err := imap.Command("LOGIN %s %s", imapQuote(configValue("IMAP_USERNAME")), imapQuote(configValue("IMAP_PASSWORD"))); err != nil {
+		return nil, err
+	}
+	if _, err := imap.Command("SELECT %s", imapQuote(mailbox)); err != nil {
+		return nil, err
+	}
+
+	responses, err := imap.Command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	uids := []string{}
+	for _, response := range responses {
+		if fields, ok := strings.CutPrefix(response.Text, "SEARCH"); ok {
+			uids = append(uids, strings.Fields(fields)...)
+		}
+	}
+
+	tasks := []EmailTask{}
+	for _, uid := range uids {
+		if skip[uid] {
+			continue
+		}
+		skip[uid] = true
+
+		responses, err := imap.Command("UID FETCH %s (BODY.PEEK[])", uid)
+		if err != nil {
+			return tasks, err
+		}
+		for _, response := range responses {
+			if len(response.Literals) == 0 {
+				continue
+			}
+			task, err := parseEmailTask(response.Literals[0], authservID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "email: skipping message %s: %s\n", uid, err.Error())
+				continue
+			}
+			if !senderAllowed(task.From, allowed) {
+				fmt.Fprintf(os.Stderr, "email: ignoring message from %s (not in EMAIL_ALLOWED_SENDERS)\n", task.From)
+				continue
+			}
+			if authservID != "" && !task.signedBySender() {
+				fmt.Fprintf(os.Stderr, "email: ignoring message from %s (%s verified no DKIM signature of its domain)\n", task.From, authservID)
+				continue
+			}
+			if subjectPrefix != "" && !strings.HasPrefix(task.Subject, subjectPrefix) {
+				continue
+			}
+
+			if _, err := imap.Command(`UID STORE %s +FLAGS (\Seen)`, uid); err != nil {
+				return tasks, err
+			}
+			task.UID = uid
+			task.Subject = strings.TrimSpace(strings.TrimPrefix(task.Subject, subjectPrefix))
+			tasks = append(tasks, task)
+		}
+	}
+
+	imap.Command("LOGOUT")
+	return tasks, nil
+}
+
+// runEmailTask runs a task non-interactively and mails back the answer with a transcript link
+func runEmailTask(ctx context.Context, client *anthropic.Client, task EmailTask, transcripts string) error {
+	name := fmt.Sprintf("%s-%s.jsonl", time.Now().UTC().Format("20060102-150405"), task.UID)
+	path := filepath.Join(transcripts, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	agent := NewAgent(client, nil, defaultTools())
+	agent.events = NewEventLog(file)
+	agent.user = task.From
+	agent.session = task.MessageID
+
+	prompt := fmt.Sprintf(emailTaskPrompt, task.From, task.Subject, task.Body)
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(agent.buildUserMessage(prompt)...)}
+	conversation, err = agent.runTurn(ctx, conversation)
+
+	reply := strings.TrimSpace(lastAssistantText(conversation))
+	if err != nil {
+		reply = "The task failed: " + err.Error()
+	}
+	if len(agent.editedFiles) > 0 {
+		reply += "\n\nFiles changed: " + strings.Join(agent.editedFiles, ", ")
+	}
+
+	link := path
+	if base := configValue("EMAIL_TRANSCRIPT_URL"); base != "" {
+		link = strings.TrimSuffix(base, "/") + "/" + name
+	}
+	reply += "\n\nTranscript: " + link + "\n"
+
+	return sendEmailReply(task, reply)
+}
+
+// parseEmailTask reads the sender, subject and plain-text body of a raw message, and the
+// DKIM results of the Authentication-Results headers added by authservID
+func parseEmailTask(raw []byte, authservID string) (EmailTask, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return EmailTask{}, err
+	}
+
+	from, err := mail.ParseAddress(message.Header.Get("From"))
+	if err != nil {
+		return EmailTask{}, fmt.Errorf("invalid From header: %w", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		subject = message.Header.Get("Subject")
+	}
+
+	body, err := plainTextBody(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	if err != nil {
+		return EmailTask{}, err
+	}
+	// Drop the signature and the quoted history of replies; quoted-printable decoding may
+	// already have stripped the space of the "-- " signature separator
+	lines := []string{}
+	for _, line := range strings.Split(body, "\n") {
+		if strings.TrimRight(line, " ") == "--" {
+			break
+		}
+		if !strings.HasPrefix(line, ">") {
+			lines = append(lines, line)
+		}
+	}
+
+	return EmailTask{
+		From:      strings.ToLower(from.Address),
+		Subject:   strings.TrimSpace(subject),
+		MessageID: message.Header.Get("Message-Id"),
+		Body:      strings.TrimSpace(strings.Join(lines, "\n")),
+		Signed:    dkimPassed(message.Header["Authentication-Results"], authservID),
+	}, nil
+}
+
+// authResultComment matches the comments of an Authentication-Results header
+var authResultComment = regexp.MustCompile(`\([^()]*\)`)
+
+// dkimPassed returns the domains of the dkim=pass results in the Authentication-Results
+// headers of authservID. Headers of other servers are ignored, since anyone can add them
+// to the message they send.
+func dkimPassed(headers []string, authservID string) []string {
+	domains := []string{}
+	if authservID == "" {
+		return domains
+	}
+	for _, header := range headers {
+		results := strings.Split(authResultComment.ReplaceAllString(header, ""), ";")
+		if id := strings.Fields(results[0]); len(id) == 0 || !strings.EqualFold(id[0], authservID) {
+			continue
+		}
+		for _, result := range results[1:] {
+			fields := strings.Fields(strings.ToLower(result))
+			if len(fields) == 0 || fields[0] != "dkim=pass" {
+				continue
+			}
+			for _, field := range fields[1:] {
+				if domain, ok := strings.CutPrefix(field, "header.d="); ok {
+					domains = append(domains, domain)
+				} else if identity, ok := strings.CutPrefix(field, "header.i="); ok {
+					domains = append(domains, identity[strings.LastIndex(identity, "@")+1:])
+				}
+			}
+		}
+	}
+	return domains
+}
+
+// signedBySender reports whether a verified DKIM signature is from the sender's domain or
+// one of its parent domains
+func (t EmailTask) signedBySender() bool {
+	domain := t.From[strings.LastIndex(t.From, "@")+1:]
+	for _, signed := range t.Signed {
+		if domain == signed || strings.HasSuffix(domain, "."+signed) {
+			return true
+		}
+	}
+	return false
+}
+
+// plainTextBody decodes the first text/plain part of a (possibly multipart) body
+func plainTextBody(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return "", fmt.Errorf("no text/plain part")
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := plainTextBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err == nil {
+				return text, nil
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("unsupported content type %s", mediaType)
+	}
+
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := io.ReadAll(body)
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), err
+}
+
+// senderAllowed checks an address against a list of addresses and @domains
+func senderAllowed(address string, allowed []string) bool {
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if address == entry || (strings.HasPrefix(entry, "@") && strings.HasSuffix(address, entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendEmailReply mails text back to the sender of a task, threaded under the original message
+func sendEmailReply(task EmailTask, text string) error {
+	return sendEmail(task.From, "Re: "+task.Subject, task.MessageID, text)
+}
+
+// sendEmail mails text through SMTP_ADDR, threaded under inReplyTo when it is set
+func sendEmail(to, subject, inReplyTo, text string) error {
+	addr := configValue("SMTP_ADDR")
+	from := configValue("EMAIL_FROM")
+	if addr == "" || from == "" {
+		return fmt.Errorf("SMTP_ADDR and EMAIL_FROM are required to send email")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\nTo: %s\r\n", from, to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	if inReplyTo != "" {
+		fmt.Fprintf(&message, "In-Reply-To: %s\r\nReferences: %s\r\n", inReplyTo, inReplyTo)
+	}
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+	body := quotedprintable.NewWriter(&message)
+	body.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n")))
+	body.Close()
+
+	var auth smtp.Auth
+	if username := configValue("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, configValue("SMTP_PASSWORD"), host)
+	}
+	if err := checkEgress(addr); err != nil {
+		return err
+	}
+	return smtp.SendMail(addr, auth, from, []string{to}, []byte(message.String()))
+}
+
+// splitList splits a comma-separated setting into trimmed, non-empty entries
+func splitList(value string) []string {
+	entries := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// imapClient speaks just enough IMAP4rev1 over TLS to read and flag messages
+type imapClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+// imapResponse is an untagged server response with any literals it carried
+type imapResponse struct {
+	Text     string
+	Literals [][]byte
+}
+
+// dialIMAP connects to an IMAPS server and reads its greeting
+func dialIMAP(addr string) (*imapClient, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEgress(host); err != nil {
+		return nil, err
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &imapClient{conn: conn, reader: bufio.NewReader(conn)}
+	greeting, err := client.reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting: %s", strings.TrimSpace(greeting))
+	}
+	return client, nil
+}
+
+// Command sends a tagged command and collects the untagged responses until it completes
+func (c *imapClient) Command(format string, args ...any) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+
+	responses := []imapResponse{}
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if status, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(status, "OK") {
+				command, _, _ := strings.Cut(format, " ")
+				return nil, fmt.Errorf("IMAP %s failed: %s", command, status)
+			}
+			return responses, nil
+		}
+
+		response := imapResponse{Text: strings.TrimPrefix(line, "* ")}
+		// A line ending in {n} is followed by n bytes of literal data, then the rest of the response
+		for strings.HasSuffix(line, "}") {
+			start := strings.LastIndexByte(line, '{')
+			if start < 0 {
+				break
+			}
+			size, err := strconv.Atoi(line[start+1 : len(line)-1])
+			if err != nil {
+				break
+			}
+			literal := make([]byte, size)
+			if _, err := io.ReadFull(c.reader, literal); err != nil {
+				return nil, err
+			}
+			response.Literals = append(response.Literals, literal)
+
+			if line, err = c.reader.ReadString('\n'); err != nil {
+				return nil, err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			response.Text += " " + line
+		}
+		responses = append(responses, response)
+	}
+}
+
+// Close ends the connection
+func (c *imapClient) Close() error {
+	return c.conn.Close()
+}
+
+// imapQuote renders s as an IMAP quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestEmailSenderVerification(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		results  []string
+		verified bool
+	}{
+		{"signed by the sender's domain", "ann@example.com", []string{"mx.example.net; dkim=pass header.d=example.com header.s=mail; spf=pass"}, true},
+		{"signed by a parent domain", "ann@dev.example.com", []string{"mx.example.net 1; dkim=pass (2048-bit key) header.d=example.com"}, true},
+		{"signing identity", "ann@example.com", []string{"MX.example.net; dkim=pass header.i=@example.com"}, true},
+		{"failed signature", "ann@example.com", []string{"mx.example.net; dkim=fail header.d=example.com"}, false},
+		{"signed by another domain", "ann@example.com", []string{"mx.example.net; dkim=pass header.d=attacker.com"}, false},
+		{"suffix is not a parent domain", "ann@badexample.com", []string{"mx.example.net; dkim=pass header.d=example.com"}, false},
+		{"added by another server", "ann@example.com", []string{"mx.attacker.com; dkim=pass header.d=example.com"}, false},
+		{"no results", "ann@example.com", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw := "From: Ann <" + test.from + ">\r\nSubject: Fix the build\r\n"
+			for _, result := range test.results {
+				raw += "Authentication-Results: " + result + "\r\n"
+			}
+			raw += "\r\nPlease fix it.\r\n"
+			task, err := parseEmailTask([]byte(raw), "mx.example.net")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verified := task.signedBySender(); verified != test.verified {
+				t.Errorf("signedBySender() = %v with %v, want %v", verified, task.Signed, test.verified)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// EVAL RUN REPORTS
+// =============================================================================
+
+// evalTask is one run of an eval: a task the agent ran with --log
+type evalTask struct {
+	Name         string
+	Log          string
+	Page         string // Path of the task's page, relative to the report directory
+	Prompt       string
+	Model        string
+	Outcome      string
+	Passed       bool
+	Started      time.Time
+	Duration     time.Duration
+	Calls        int
+	InputTokens  int64
+	OutputTokens int64
+	ToolCalls    int
+	Cost         float64
+	Unpriced     bool    // Some calls used a model without a known price
+	BarPercent   float64 // Cost relative to the most expensive task, for the chart
+	Diffs        []evalDiff
+	Events       []Event
+}
+
+// evalDiff is a change one task made, as a unified diff
+type evalDiff struct {
+	Path string
+	Diff string
+}
+
+// evalReport is everything the report pages show
+type evalReport struct {
+	Generated time.Time
+	Tasks     []*evalTask
+	Passed    int
+	Cost      float64
+}
+
+// runEvalReportCommand implements `go-agent eval-report [--out DIR] LOG|DIR...`
+func runEvalReportCommand(args []string) error {
+	flags := flag.NewFlagSet("eval-report", flag.ContinueOnError)
+	out := flags.String("out", "eval-report", "directory the report is written to")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() == 0 {
+		return usageErrorf("usage: go-agent eval-report [--out DIR] LOG|DIR... (--log files of the eval's runs, or directories of them)")
+	}
+
+	logs := []string{}
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			logs = append(logs, arg)
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(arg, "*.jsonl"))
+		logs = append(logs, matches...)
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("no .jsonl logs found in %s", strings.Join(flags.Args(), ", "))
+	}
+	sort.Strings(logs)
+
+	report := &evalReport{Generated: time.Now()}
+	pages := map[string]bool{}
+	for _, log := range logs {
+		events, err := readEventLog(log)
+		if err != nil {
+			return err
+		}
+		task := summarizeEvalRun(context.TODO(), log, events)
+		task.Page = uniqueEvalPage(task.Name, pages)
+		report.Tasks = append(report.Tasks, task)
+		report.Cost += task.Cost
+		if task.Passed {
+			report.Passed++
+		}
+	}
+	maxCost := 0.0
+	for _, task := range report.Tasks {
+		maxCost = max(maxCost, task.Cost)
+	}
+	for _, task := range report.Tasks {
+		if maxCost > 0 {
+			task.BarPercent = 100 * task.Cost / maxCost
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(*out, "tasks"), 0o755); err != nil {
+		return err
+	}
+	if err := writeEvalPage(filepath.Join(*out, "index.html"), "index", report); err != nil {
+		return err
+	}
+	for _, task := range report.Tasks {
+		if err := writeEvalPage(filepath.Join(*out, task.Page), "task", task); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("eval-report: %d of %d task(s) passed, $%.4f; wrote %s\n", report.Passed, len(report.Tasks), report.Cost, filepath.Join(*out, "index.html"))
+	return nil
+}
+
+// summarizeEvalRun collects the outcome, usage, cost and changes of one run
+func summarizeEvalRun(ctx context.Context, log string, events []Event) *evalTask {
+	task := &evalTask{
+		Name:    strings.TrimSuffix(filepath.Base(log), filepath.Ext(log)),
+		Log:     log,
+		Outcome: "incomplete",
+		Events:  events,
+	}
+	if len(events) > 0 {
+		task.Started = events[0].Time
+		task.Duration = events[len(events)-1].Time.Sub(events[0].Time)
+	}
+
+	edits := map[string]EditFileInput{}
+	for _, event := range events {
+		switch event.Type {
+		case eventSessionStart:
+			if event.Metadata != nil {
+				task.Model = event.Metadata.Model
+			}
+		case eventUserMessage:
+			if task.Prompt == "" {
+				task.Prompt = event.Text
+			}
+		case eventInference:
+			task.Calls++
+			if task.Model == "" {
+				task.Model = event.Model
+			}
+			if event.Usage == nil {
+				continue
+			}
+			task.InputTokens += event.Usage.InputTokens
+			task.OutputTokens += event.Usage.OutputTokens
+			if price, ok := modelPrice(event.Model); ok {
+				task.Cost += price.cost(anthropic.Usage{InputTokens: event.Usage.InputTokens, OutputTokens: event.Usage.OutputTokens})
+			} else {
+				task.Unpriced = true
+			}
+		case eventToolUse:
+			task.ToolCalls++
+			if event.Tool == EditFileDefinition.Name {
+				input := EditFileInput{}
+				if json.Unmarshal(event.Input, &input) == nil {
+					edits[event.ToolUseID] = input
+				}
+			}
+		case eventToolResult:
+			// Only edits that were made count as changes of the run
+			input, ok := edits[event.ToolUseID]
+			if !ok || event.IsError {
+				continue
+			}
+			var before *string
+			if input.OldStr != "" {
+				before = &input.OldStr
+			}
+			if diff, err := diffContents(ctx, input.Path, before, input.NewStr); err == nil {
+				task.Diffs = append(task.Diffs, evalDiff{Path: input.Path, Diff: diff})
+			}
+		case eventOutcome:
+			task.Outcome = event.Outcome
+			task.Passed = event.Outcome == outcomeSuccess
+		}
+	}
+	return task
+}
+
+// evalPageName keeps page names to characters that are safe in any file system and URL
+var evalPageName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// uniqueEvalPage names a task's page after the task, numbering names already taken
+func uniqueEvalPage(name string, taken map[string]bool) string {
+	base := strings.Trim(evalPageName.ReplaceAllString(name, "-"), "-")
+	if base == "" {
+		base = "task"
+	}
+	page := base
+	for i := 2; taken[page]; i++ {
+		page = fmt.Sprintf("%s-%d", base, i)
+	}
+	taken[page] = true
+	return "tasks/" + page + ".html"
+}
+
+// writeEvalPage renders one of the report templates to path
+func writeEvalPage(path, name string, data any) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := evalTemplates.ExecuteTemplate(file, name, data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// evalTemplates are the report pages. The templates escape everything taken from the
+// logs, so transcripts cannot inject markup.
+var evalTemplates = template.Must(template.New("eval").Funcs(template.FuncMap{
+	"money":    func(cost float64) string { return fmt.Sprintf("$%.4f", cost) },
+	"duration": func(d time.Duration) string { return d.Round(time.Second).String() },
+	"short":    func(text string) string { return headLines(truncateToTokens(text, 40), 2) },
+	"clip": func(text string) string {
+		return headLines(strings.TrimRight(text, "\n"), exportToolOutputLines)
+	},
+	"json": func(input json.RawMessage) string { return compactJSON(input) },
+	"rate": func(passed, total int) string {
+		if total == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.0f%%", 100*float64(passed)/float64(total))
+	},
+}).Parse(`
+{{define "style"}}<style>
+body { font-family: system-ui, sans-serif; max-width: 64rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9rem; }
+th, td { text-align: left; padding: 0.35rem 0.5rem; border-bottom: 1px solid #eee; vertical-align: top; }
+.pass { color: #15803d; font-weight: 600; }
+.fail { color: #b91c1c; font-weight: 600; }
+.bar { height: 0.9rem; background: #3b82f6; }
+.bar.failed { background: #ef4444; }
+.chart td { border: none; padding: 0.15rem 0.5rem; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; font-size: 0.85rem; }
+.event { margin: 0.75rem 0; padding: 0.5rem 0.75rem; border-left: 3px solid #ccc; white-space: pre-wrap; word-wrap: break-word; }
+.event .meta { font-size: 0.8rem; color: #777; white-space: normal; }
+.user_message { border-color: #3b82f6; }
+.assistant_text { border-color: #eab308; }
+.tool_use, .tool_result { border-color: #22c55e; font-family: ui-monospace, monospace; font-size: 0.85rem; }
+.tool_denied, .error, .is_error { border-color: #ef4444; }
+.status, .outcome { border-color: #a855f7; }
+</style>{{end}}
+
+{{define "index"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-agent eval report</title>
+{{template "style"}}
+</head>
+<body>
+<h1>go-agent eval report</h1>
+<p>{{.Passed}} of {{len .Tasks}} task(s) passed ({{rate .Passed (len .Tasks)}}), {{money .Cost}} in total. Generated {{.Generated.Format "2006-01-02 15:04 MST"}}.</p>
+
+<h2>Cost per task</h2>
+<table class="chart">
+{{range .Tasks}}<tr><td><a href="{{.Page}}">{{.Name}}</a></td><td style="width: 60%"><div class="bar{{if not .Passed}} failed{{end}}" style="width: {{printf "%.1f" .BarPercent}}%"></div></td><td>{{money .Cost}}</td></tr>
+{{end}}</table>
+
+<h2>Tasks</h2>
+<table>
+<tr><th>Task</th><th>Result</th><th>Model</th><th>Model calls</th><th>Tokens in / out</th><th>Files changed</th><th>Time</th><th>Cost</th></tr>
+{{range .Tasks}}<tr>
+<td><a href="{{.Page}}">{{.Name}}</a><br><small>{{short .Prompt}}</small></td>
+<td class="{{if .Passed}}pass{{else}}fail{{end}}">{{if .Passed}}pass{{else}}fail ({{.Outcome}}){{end}}</td>
+<td>{{.Model}}</td><td>{{.Calls}}</td><td>{{.InputTokens}} / {{.OutputTokens}}</td><td>{{len .Diffs}}</td><td>{{duration .Duration}}</td>
+<td>{{money .Cost}}{{if .Unpriced}}*{{end}}</td>
+</tr>
+{{end}}</table>
+<p><small>* Some model calls were made with a model that has no known price and are not counted.</small></p>
+</body>
+</html>
+{{end}}
+
+{{define "task"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} - go-agent eval report</title>
+{{template "style"}}
+</head>
+<body>
+<p><a href="../index.html">&larr; All tasks</a></p>
+<h1>{{.Name}}</h1>
+<p class="{{if .Passed}}pass{{else}}fail{{end}}">{{if .Passed}}Passed{{else}}Failed ({{.Outcome}}){{end}}</p>
+<table>
+<tr><th>Log</th><td>{{.Log}}</td></tr>
+<tr><th>Model</th><td>{{.Model}}</td></tr>
+<tr><th>Started</th><td>{{.Started.Format "2006-01-02 15:04:05 MST"}}, ran {{duration .Duration}}</td></tr>
+<tr><th>Model calls</th><td>{{.Calls}}, {{.ToolCalls}} tool call(s)</td></tr>
+<tr><th>Tokens</th><td>{{.InputTokens}} in, {{.OutputTokens}} out</td></tr>
+<tr><th>Cost</th><td>{{money .Cost}}{{if .Unpriced}} (some calls used a model without a known price){{end}}</td></tr>
+</table>
+
+<h2>Changes</h2>
+{{range .Diffs}}<h3>{{.Path}}</h3>
+<pre>{{.Diff}}</pre>
+{{else}}<p>The task changed no files.</p>
+{{end}}
+
+<h2>Transcript</h2>
+{{range .Events}}{{if eq .Type "user_message"}}<div class="event user_message"><div class="meta">User</div>{{.Text}}</div>
+{{else if eq .Type "assistant_text"}}<div class="event assistant_text"><div class="meta">Claude</div>{{.Text}}</div>
+{{else if eq .Type "tool_use"}}<div class="event tool_use"><div class="meta">Tool call &middot; {{.Tool}}</div>{{json .Input}}</div>
+{{else if eq .Type "tool_result"}}<div class="event tool_result{{if .IsError}} is_error{{end}}"><div class="meta">Tool result &middot; {{.Tool}}</div>{{clip .Text}}</div>
+{{else if eq .Type "tool_denied"}}<div class="event tool_denied"><div class="meta">Tool call denied &middot; {{.Tool}}</div>{{.Text}}</div>
+{{else if eq .Type "error"}}<div class="event error"><div class="meta">Error</div>{{.Text}}</div>
+{{else if eq .Type "outcome"}}<div class="event outcome"><div class="meta">Outcome</div>{{.Outcome}}</div>
+{{end}}{{end}}
+</body>
+</html>
+{{end}}
+`))
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// STRUCTURED EVENT LOG
+// =============================================================================
+
+// Event types written to the event log
+const (
+	eventUserMessage   = "user_message"
+	eventAssistantText = "assistant_text"
+	eventToolUse       = "tool_use"
+	eventToolResult    = "tool_result"
+	eventToolDenied    = "tool_denied"
+	eventStatus        = "status"
+	eventError         = "error"
+	eventOutcome       = "outcome"
+
+	eventApprovalRequired = "approval_required"
+	eventApprovalResolved = "approval_resolved"
+
+	eventSessionStart = "session_start" // Carries the settings needed to reproduce the session
+	eventInference    = "inference"     // One model call, with the model that served it
+
+	eventTodo     = "todo"     // Claude changed its task list
+	eventProgress = "progress" // A long-running tool reported how far along it is
+	eventUsage    = "usage"    // Token totals of the run, just before the outcome
+
+	eventEditProposed = "edit_proposed" // An editor session's edit, as a diff in Text, before it is made
+	eventThinking     = "thinking"      // Claude's extended thinking, in Text, before it answered or called tools
+	eventEgress       = "egress"        // A tool connected to the host in Text, or was refused, with IsError and the reason
+)
+
+// Event is one machine-readable record of what the agent did
+type Event struct {
+	Type      string          `json:"type"`
+	Time      time.Time       `json:"time"`
+	Text      string          `json:"text,omitempty"`
+	Tool      string          `json:"tool,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+	Outcome   string          `json:"outcome,omitempty"`
+	ExitCode  *int            `json:"exit_code,omitempty"`
+	Token     string          `json:"token,omitempty"`
+
+	Model      string           `json:"model,omitempty"`
+	StopReason string           `json:"stop_reason,omitempty"`
+	Metadata   *SessionMetadata `json:"metadata,omitempty"`
+	Todos      []TodoItem       `json:"todos,omitempty"`
+	Progress   *Progress        `json:"progress,omitempty"`
+	Usage      *UsageStats      `json:"usage,omitempty"`
+	Citations  []Citation       `json:"citations,omitempty"`
+	Failure    string           `json:"failure,omitempty"` // Category of a refusal or API failure, with an error event
+}
+
+// EventLog writes events as JSON lines
+type EventLog struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewEventLog creates an event log writing to w
+func NewEventLog(w io.Writer) *EventLog {
+	return &EventLog{encoder: json.NewEncoder(w)}
+}
+
+// Emit writes event, stamping it with the current time. A nil log discards events.
+func (l *EventLog) Emit(event Event) {
+	if l == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.encoder.Encode(event)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// EXPLAIN WORKFLOW
+// =============================================================================
+
+// explainPrompt asks Claude for a layered explanation of some code
+const explainPrompt = "Explain %s to a teammate who is new to this codebase. Structure your answer as:\n\n%s\n" +
+	"Use the read-only tools to look up symbols the code references when that helps the explanation; " +
+	"do not guess what they do. Quote line numbers when pointing at specific code.\n\n```\n%s```"
+
+// explainLayers are the sections of an explanation, added one per depth level
+var explainLayers = []string{
+	"## Summary\nOne paragraph on what this code is for and where it fits.",
+	"## Walkthrough\nA step-by-step tour of how it works, in reading order.",
+	"## Gotchas\nNon-obvious behavior, edge cases, error handling and anything likely to trip someone up when changing it.",
+}
+
+// lineRangePattern matches the optional :start-end suffix of an explain target
+var lineRangePattern = regexp.MustCompile(`^(.+):(\d+)(?:-(\d+))?$`)
+
+// runExplainCommand implements `go-agent explain path/to/file.go[:120-180]`
+func runExplainCommand(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	depth := flags.Int("depth", len(explainLayers), "1 = summary, 2 = + walkthrough, 3 = + gotchas")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("usage: explain [--depth N] <file>[:start-end]")
+	}
+	if *depth < 1 || *depth > len(explainLayers) {
+		return fmt.Errorf("--depth must be between 1 and %d", len(explainLayers))
+	}
+
+	path, start, end, err := parseExplainTarget(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if end == 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return fmt.Errorf("line range %d-%d is outside %s (%d lines)", start, end, path, len(lines))
+	}
+
+	// Number the lines so Claude can refer to them
+	var code strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&code, "%5d  %s\n", i, lines[i-1])
+	}
+
+	target := path
+	if start != 1 || end != len(lines) {
+		target = fmt.Sprintf("lines %d-%d of %s", start, end, path)
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+	agent := NewAgent(client, nil, readOnlyTools())
+
+	prompt := fmt.Sprintf(explainPrompt, target, strings.Join(explainLayers[:*depth], "\n\n"), code.String())
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt))}
+	_, err = agent.runTurn(context.TODO(), conversation)
+	return err
+}
+
+// parseExplainTarget splits file.go:120-180 into a path and a 1-based inclusive line range;
+// end is 0 when the range runs to the end of the file
+func parseExplainTarget(target string) (path string, start, end int, err error) {
+	match := lineRangePattern.FindStringSubmatch(target)
+	if match == nil {
+		return target, 1, 0, nil
+	}
+
+	start, _ = strconv.Atoi(match[2])
+	end = start
+	if match[3] != "" {
+		end, _ = strconv.Atoi(match[3])
+	}
+	if start < 1 || end < start {
+		return "", 0, 0, fmt.Errorf("invalid line range in %s", target)
+	}
+
+	return match[1], start, end, nil
+}
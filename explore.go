@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// DELEGATED EXPLORATION
+// =============================================================================
+
+// exploreName is the name of the tool that delegates exploration to the cheap model
+const exploreName = "explore"
+
+// Exploration modes, from EXPLORE
+const (
+	exploreOff    = "off"    // Claude explores the repository itself
+	exploreAuto   = "auto"   // Delegate once the workspace has at least EXPLORE_MIN_FILES files
+	exploreAlways = "always" // Always delegate
+)
+
+// Defaults for the exploration settings
+const (
+	defaultExploreMinFiles = 300
+	defaultExploreMaxTurns = 8
+)
+
+// explorerPrompt instructs the cheap model how to explore for the main agent
+const explorerPrompt = `You explore a code repository for a coding agent that cannot see your tool calls.
+Use list_files, search_files and read_file to answer the question below. Be economical: search before reading, and read only what you need.
+Then reply with a condensed briefing: the relevant file paths, key identifiers with their line numbers, short verbatim excerpts of the important code, and how the pieces fit together. Say plainly what you could not find. Reply with the briefing only.
+
+<question>
+%s
+</question>`
+
+// explorerStopPrompt ends exploration once the turn limit is reached
+const explorerStopPrompt = "You have used up your exploration budget. Do not call any more tools; write the briefing now from what you have found."
+
+// ExploreInput defines the input structure for the explore tool
+type ExploreInput struct {
+	Question string `json:"question" jsonschema_description:"What you need to know about the repository, e.g. where retries are configured and which functions call them."`
+}
+
+// ExploreInputSchema - Auto-generated JSON schema for ExploreInput
+var ExploreInputSchema = GenerateSchema[ExploreInput]()
+
+// workspaceFileCount counts the files of the working directory once, stopping at limit
+var workspaceFileCount = sync.OnceValues(func() (int, error) {
+	limit := configInt("EXPLORE_MIN_FILES", defaultExploreMinFiles)
+	count := 0
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != "." && (strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if count++; count >= limit {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return count, err
+})
+
+// delegateExploration reports whether list_files and search_files should be replaced by
+// the explore tool under the EXPLORE setting
+func delegateExploration() bool {
+	switch configValue("EXPLORE") {
+	case exploreAlways:
+		return true
+	case exploreAuto:
+		count, err := workspaceFileCount()
+		return err == nil && count >= configInt("EXPLORE_MIN_FILES", defaultExploreMinFiles)
+	default:
+		return false
+	}
+}
+
+// withExploration swaps the exploration tools for the explore tool when exploration is
+// delegated. read_file stays, since Claude must read a file before it edits it.
+func withExploration(client *anthropic.Client, tools []ToolDefinition) []ToolDefinition {
+	if client == nil || !delegateExploration() {
+		return tools
+	}
+
+	delegated := []ToolDefinition{}
+	replaced := false
+	for _, tool := range tools {
+		if tool.Name == ListFilesDefinition.Name || tool.Name == SearchFilesDefinition.Name {
+			replaced = true
+			continue
+		}
+		delegated = append(delegated, tool)
+	}
+	if !replaced {
+		return tools
+	}
+	return append(delegated, exploreDefinition(client))
+}
+
+// exploreDefinition returns the explore tool, which runs a cheap model with the read-only
+// tools and returns its briefing
+func exploreDefinition(client *anthropic.Client) ToolDefinition {
+	return ToolDefinition{
+		Name:        exploreName,
+		Description: "Ask an assistant to explore the repository and return a condensed briefing. It can list, search and read files, and replies with the relevant paths, identifiers, line numbers and excerpts. Use it to find where things are instead of listing and searching yourself; ask one focused question per call.",
+		InputSchema: ExploreInputSchema,
+		Run: func(ctx context.Context, input json.RawMessage) (string, error) {
+			exploreInput := ExploreInput{}
+			if err := json.Unmarshal(input, &exploreInput); err != nil {
+				return "", fmt.Errorf("invalid input format: %w", err)
+			}
+			if strings.TrimSpace(exploreInput.Question) == "" {
+				return "", fmt.Errorf("question is required")
+			}
+			return explore(ctx, client, exploreInput.Question)
+		},
+	}
+}
+
+// explore lets the cheap model answer a question about the repository
+func explore(ctx context.Context, client *anthropic.Client, question string) (string, error) {
+	model := configValue("EXPLORER_MODEL")
+	if model == "" {
+		model = string(anthropic.ModelClaude3_5HaikuLatest)
+	}
+	maxTurns := configInt("EXPLORE_MAX_TURNS", defaultExploreMaxTurns)
+
+	// The explorer has its own conversation and no event log, so only its briefing
+	// reaches the main agent
+	toolOutputs := NewToolOutputStore()
+	explorer := &Agent{
+		client:      client,
+		model:       anthropic.Model(model),
+		tools:       []ToolDefinition{ReadFileDefinition, ListFilesDefinition, SearchFilesDefinition, toolOutputs.Definition()},
+		toolOutputs: toolOutputs,
+	}
+
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(fmt.Sprintf(explorerPrompt, question)))}
+	for turn := 1; ; turn++ {
+		message, err := explorer.runInference(ctx, conversation)
+		if err != nil {
+			return "", fmt.Errorf("exploration failed: %w", err)
+		}
+		conversation = append(conversation, message.ToParam())
+
+		// The briefing is the reply without tool calls, or whatever the explorer says once
+		// it has been told to stop
+		toolResults := []anthropic.ContentBlockParamUnion{}
+		if turn <= maxTurns {
+			toolResults = explorer.processClaudeResponse(ctx, message)
+		}
+		if len(toolResults) == 0 {
+			briefing := strings.TrimSpace(lastAssistantText(conversation))
+			if briefing == "" {
+				return "", fmt.Errorf("the explorer wrote no briefing within %d model calls", maxTurns+1)
+			}
+			fmt.Fprintf(os.Stderr, "%s: %s briefed with %s after %d call(s) (~%d tokens)\n", paint(roleTool, "tool"), exploreName, model, turn, estimateTokens(briefing))
+			return briefing, nil
+		}
+		if turn == maxTurns {
+			toolResults = append(toolResults, anthropic.NewTextBlock(explorerStopPrompt))
+		}
+		conversation = append(conversation, anthropic.NewUserMessage(toolResults...))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// =============================================================================
+// EXPORTING A SESSION
+// =============================================================================
+
+// exportToolOutputLines is how much of each tool result a transcript shows
+const exportToolOutputLines = 20
+
+// translatePrompt asks Claude to turn a transcript into a report in another language
+const translatePrompt = `Below is the transcript of a session with a coding agent. Write a report of the session in %s for stakeholders who did not follow it.
+- Translate the user's requests and Claude's answers faithfully, in order.
+- Replace the raw tool calls and their output with short plain statements of what was done, e.g. which files were read or changed and why. Keep errors and denied tool calls that mattered.
+- Keep code, file paths, identifiers, commands and citation markers such as [1] as they are; do not translate them.
+- End with the outcome of the session.
+Reply with the report only, in Markdown.
+
+<transcript>
+%s
+</transcript>`
+
+// runExportCommand implements `go-agent export LOG`
+func runExportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	translate := flags.String("translate", "", "have Claude write a cleaned-up report of the session in this language, e.g. German")
+	output := flags.String("output", "", "write the transcript to this file instead of printing it")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("usage: go-agent export [--translate LANG] [--output FILE] LOG (a --log or --audit file)")
+	}
+
+	events, err := readEventLog(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	transcript := renderTranscript(events)
+
+	if *translate != "" {
+		client, err := initializeClient()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "export: translating %d event(s) into %s\n", len(events), *translate)
+		// Leave room in the context window for the prompt and the report
+		report, err := askClaude(context.TODO(), client, fmt.Sprintf(translatePrompt, *translate, truncateToTokens(transcript, contextWindowTokens/2)), 4096)
+		if err != nil {
+			return fmt.Errorf("translation failed: %w", err)
+		}
+		transcript = strings.TrimSpace(report) + "\n"
+	}
+
+	if *output == "" {
+		fmt.Print(transcript)
+		return nil
+	}
+	if err := os.WriteFile(*output, []byte(transcript), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("export: wrote %s\n", *output)
+	return nil
+}
+
+// readEventLog reads every event of a --log file or --audit trail
+func readEventLog(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []Event{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record struct {
+			Event
+			AuditEvent *Event `json:"event"` // Set in audit trail entries
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d is not an event: %w", path, line, err)
+		}
+		if record.AuditEvent != nil {
+			record.Event = *record.AuditEvent
+		}
+		events = append(events, record.Event)
+	}
+	return events, scanner.Err()
+}
+
+// renderTranscript writes the events of a session as a Markdown transcript
+func renderTranscript(events []Event) string {
+	var out strings.Builder
+	out.WriteString("# go-agent session\n")
+	for _, event := range events {
+		switch event.Type {
+		case eventSessionStart:
+			fmt.Fprintf(&out, "\nStarted %s", event.Time.Format("2006-01-02 15:04 MST"))
+			if event.Metadata != nil {
+				fmt.Fprintf(&out, " with %s", event.Metadata.Model)
+				if event.Metadata.GitCommit != "" {
+					fmt.Fprintf(&out, " on commit %s", event.Metadata.GitCommit)
+				}
+			}
+			out.WriteString(".\n")
+		case eventUserMessage:
+			fmt.Fprintf(&out, "\n## You\n\n%s\n", event.Text)
+		case eventThinking:
+			fmt.Fprintf(&out, "\n<details><summary>Thinking</summary>\n\n%s\n\n</details>\n", event.Text)
+		case eventAssistantText:
+			fmt.Fprintf(&out, "\n## Claude\n\n%s\n", event.Text)
+			if len(event.Citations) > 0 {
+				out.WriteString("\n")
+				for i, citation := range event.Citations {
+					fmt.Fprintf(&out, "%d. %s\n", i+1, citation)
+				}
+			}
+		case eventToolUse:
+			fmt.Fprintf(&out, "\n**Tool call** `%s(%s)`\n", event.Tool, compactJSON(event.Input))
+		case eventToolResult:
+			label := "Result"
+			if event.IsError {
+				label = "Failed"
+			}
+			fmt.Fprintf(&out, "\n%s:\n\n```\n%s\n```\n", label, headLines(strings.TrimRight(event.Text, "\n"), exportToolOutputLines))
+		case eventToolDenied:
+			fmt.Fprintf(&out, "\n**Denied** `%s`: %s\n", event.Tool, event.Text)
+		case eventError:
+			fmt.Fprintf(&out, "\n**Error**: %s\n", event.Text)
+		case eventOutcome:
+			fmt.Fprintf(&out, "\n**Outcome**: %s\n", event.Outcome)
+		}
+	}
+	return out.String()
+}
+
+// headLines keeps the first n lines of text, noting how many were left out
+func headLines(text string, n int) string {
+	lines := strings.Split(text, "\n")
+	if len(lines) <= n {
+		return text
+	}
+	return strings.Join(lines[:n], "\n") + fmt.Sprintf("\n... (%d more lines)", len(lines)-n)
+}
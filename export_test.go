@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code-agent/golden"
+)
+
+func TestRenderTranscript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := NewEventLog(file)
+	log.Emit(Event{Type: eventSessionStart, Metadata: &SessionMetadata{
+		AgentVersion: "v1.2.3", GoVersion: "go1.23.0", SDKVersion: "v1.6.2", Model: "claude-sonnet-4-20250514",
+		Tools: map[string]string{"read_file": "abc123"}, Approval: approvalAuto, Mode: modeNormal, Workspace: "/home/ann/project",
+	}})
+	log.Emit(Event{Type: eventUserMessage, Text: "What does main.go do?"})
+	log.Emit(Event{Type: eventToolUse, Tool: "read_file", ToolUseID: "toolu_01", Input: json.RawMessage(`{ "path": "main.go" }`)})
+	log.Emit(Event{Type: eventToolResult, Tool: "read_file", ToolUseID: "toolu_01", Text: "package main\n\nfunc main() {}\n"})
+	log.Emit(Event{Type: eventToolUse, Tool: "edit_file", ToolUseID: "toolu_02", Input: json.RawMessage(`{"path":"go.mod"}`)})
+	log.Emit(Event{Type: eventToolDenied, Tool: "edit_file", ToolUseID: "toolu_02", Text: "the approval policy"})
+	log.Emit(Event{Type: eventAssistantText, Text: "It defines an empty `main`."})
+	log.Emit(Event{Type: eventOutcome, Outcome: outcomeSuccess})
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, "event_log", string(data), golden.Transcript...)
+
+	events, err := readEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden.Assert(t, "export", renderTranscript(events), golden.Transcript...)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// REFUSALS AND API FAILURES
+// =============================================================================
+
+// Categories of model calls that did not produce a usable answer
+const (
+	failureRefusal       = "refusal"        // Claude declined to answer
+	failureContentFilter = "content_filter" // A content filter blocked the request or the answer
+	failureOverloaded    = "overloaded"     // The API had no capacity
+	failureRateLimited   = "rate_limited"   // The account went over its rate limit
+)
+
+// failureGuidance tells the user what to do about each category
+var failureGuidance = map[string]string{
+	failureRefusal:       "Claude declined to continue. Rephrase the request, or start a new conversation if earlier messages led here.",
+	failureContentFilter: "A content filter blocked this. Rephrase the request and leave out the material that triggered it.",
+	failureOverloaded:    "The API is overloaded right now. Retry in a few minutes; nothing is wrong with the request.",
+	failureRateLimited:   "The API rate limit was reached. Retry later, or lower TOOL_CONCURRENCY and the number of runs at once.",
+}
+
+// responseFailure categorizes a response that came back but carries no answer
+func responseFailure(message *anthropic.Message) string {
+	switch {
+	case message.StopReason == anthropic.StopReasonRefusal:
+		return failureRefusal
+	case strings.Contains(string(message.StopReason), "filter"):
+		// Gateways in front of the API report their own filters this way
+		return failureContentFilter
+	default:
+		return ""
+	}
+}
+
+// errorFailure categorizes a failed model call, or returns "" for other errors
+func errorFailure(err error) string {
+	var apiErr *anthropic.Error
+	if !errors.As(err, &apiErr) {
+		if strings.HasPrefix(err.Error(), streamErrorPrefix) && strings.Contains(err.Error(), "overloaded_error") {
+			return failureOverloaded
+		}
+		return ""
+	}
+	switch {
+	case apiErr.StatusCode == 529 || strings.Contains(apiErr.RawJSON(), "overloaded_error"):
+		return failureOverloaded
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		return failureRateLimited
+	case apiErr.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(apiErr.RawJSON()), "content filter"):
+		return failureContentFilter
+	default:
+		return ""
+	}
+}
+
+// reportFailure shows the guidance for a failure category, logs it and counts it in the
+// run's usage
+func (a *Agent) reportFailure(category string) {
+	if category == "" {
+		return
+	}
+	guidance := failureGuidance[category]
+	fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleError, strings.ReplaceAll(category, "_", " ")), guidance)
+	a.events.Emit(Event{Type: eventError, Text: guidance, Failure: category})
+	runOptions.usage.failure(category)
+	runOptions.budget.failure(category)
+}
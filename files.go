@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
+)
+
+// =============================================================================
+// FILES API UPLOADS
+// =============================================================================
+
+// betaFilesAPI is the beta that lets messages refer to uploaded files
+const betaFilesAPI = "files-api-2025-04-14"
+
+// defaultFilesAPIMinBytes is the size from which attachments are uploaded instead of inlined
+const defaultFilesAPIMinBytes = 100_000
+
+// uploadedFile is an attachment already uploaded to the Files API
+type uploadedFile struct {
+	ID       string    `json:"id"`
+	Filename string    `json:"filename"`
+	Size     int       `json:"size"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+var (
+	// uploadsMu guards the uploads cache file
+	uploadsMu sync.Mutex
+
+	// uploadedTokens is the estimated size of each uploaded file, for the context pre-flight
+	uploadedTokens sync.Map
+
+	// filesUnsupported is set once the API turns down an upload, so the rest of the run
+	// inlines attachments without trying again
+	filesUnsupported atomic.Bool
+)
+
+// filesAPIEnabled reports whether large attachments are uploaded, with FILES_API=true
+func filesAPIEnabled() bool {
+	return configValue("FILES_API") == "true" && !filesUnsupported.Load()
+}
+
+// filesAPIMinBytes returns the size from which attachments are uploaded
+func filesAPIMinBytes() int {
+	return configInt("FILES_API_MIN_BYTES", defaultFilesAPIMinBytes)
+}
+
+// uploadsPath is where the IDs of uploaded attachments are kept, so an unchanged file is
+// uploaded once rather than in every session
+func uploadsPath() string {
+	return filepath.Join(cacheDir(), "uploads.json")
+}
+
+// uploadedBlock uploads an attachment, or reuses an earlier upload of the same contents,
+// and returns a document block that refers to it. It returns false when the attachment
+// should be inlined instead: it is small or truncated, or the upload failed.
+func (a *Agent) uploadedBlock(ctx context.Context, attachment *Attachment) (anthropic.ContentBlockParamUnion, bool) {
+	contents, contentType := attachment.Content, "text/plain"
+	if attachment.PDF != nil {
+		contents, contentType = string(attachment.PDF), "application/pdf"
+	}
+	if !filesAPIEnabled() || attachment.Truncated || len(contents) < filesAPIMinBytes() {
+		return anthropic.ContentBlockParamUnion{}, false
+	}
+	id, err := a.uploadFile(ctx, attachment.Path, contents, contentType)
+	if err != nil {
+		filesUnsupported.Store(true)
+		fmt.Fprintf(os.Stderr, "%s: failed to upload %s to the Files API (%s); attaching it inline\n", paint(roleWarning, "warning"), attachment.Path, err.Error())
+		return anthropic.ContentBlockParamUnion{}, false
+	}
+	uploadedTokens.Store(id, attachment.Tokens)
+	fmt.Fprintf(os.Stderr, "%s: %s sent as uploaded file %s\n", paint(roleInfo, "attach"), attachment.Path, id)
+	a.events.Emit(Event{Type: eventStatus, Text: fmt.Sprintf("uploaded attachment %s as %s", attachment.Path, id)})
+	return fileDocumentBlock(id, attachment.Path, citeable(attachment.Path)), true
+}
+
+// uploadFile uploads contents under the name of path and returns the file ID. An upload
+// of the same contents is reused while the API still has it.
+func (a *Agent) uploadFile(ctx context.Context, path, contents, contentType string) (string, error) {
+	sum := sha256.Sum256([]byte(contents))
+	key := hex.EncodeToString(sum[:])
+
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	uploads := map[string]uploadedFile{}
+	if data, err := os.ReadFile(uploadsPath()); err == nil {
+		json.Unmarshal(data, &uploads)
+	}
+	if cached, ok := uploads[key]; ok {
+		if _, err := a.client.Beta.Files.GetMetadata(ctx, cached.ID, anthropic.BetaFileGetMetadataParams{}); err == nil {
+			return cached.ID, nil
+		}
+	}
+
+	file, err := a.client.Beta.Files.Upload(ctx, anthropic.BetaFileUploadParams{
+		File: anthropic.File(strings.NewReader(contents), filepath.Base(path), contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	uploads[key] = uploadedFile{ID: file.ID, Filename: file.Filename, Size: len(contents), Uploaded: time.Now().UTC()}
+	if data, err := json.MarshalIndent(uploads, "", "  "); err == nil && os.MkdirAll(filepath.Dir(uploadsPath()), 0o700) == nil {
+		os.WriteFile(uploadsPath(), data, 0o600)
+	}
+	return file.ID, nil
+}
+
+// fileDocumentBlock is a document block that refers to an uploaded file. The SDK has no
+// file source for the stable Messages API yet, so the source is written as extra fields.
+func fileDocumentBlock(id, title string, citations bool) anthropic.ContentBlockParamUnion {
+	source := anthropic.URLPDFSourceParam{}
+	source.SetExtraFields(map[string]any{"type": "file", "file_id": id, "url": param.Omit})
+	document := &anthropic.DocumentBlockParam{
+		Source: anthropic.DocumentBlockParamSourceUnion{OfURL: &source},
+		Title:  anthropic.String(title),
+	}
+	if citations {
+		document.Citations = anthropic.CitationsConfigParam{Enabled: anthropic.Bool(true)}
+	}
+	return anthropic.ContentBlockParamUnion{OfDocument: document}
+}
+
+// fileIDs lists the uploaded files the messages refer to
+func fileIDs(messages []anthropic.MessageParam) []string {
+	ids := []string{}
+	for _, message := range messages {
+		for _, block := range message.Content {
+			if block.OfDocument == nil || block.OfDocument.Source.OfURL == nil {
+				continue
+			}
+			if id, ok := block.OfDocument.Source.OfURL.ExtraFields()["file_id"].(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// filesOptions adds the Files API beta to requests whose messages refer to uploaded files
+func filesOptions(messages []anthropic.MessageParam) []option.RequestOption {
+	if len(fileIDs(messages)) == 0 {
+		return nil
+	}
+	return []option.RequestOption{option.WithHeaderAdd("anthropic-beta", betaFilesAPI)}
+}
+
+// uploadedFileTokens estimates the tokens of the uploaded files the messages refer to,
+// which the request itself does not show
+func uploadedFileTokens(messages []anthropic.MessageParam) int {
+	total := 0
+	for _, id := range fileIDs(messages) {
+		if tokens, ok := uploadedTokens.Load(id); ok {
+			total += tokens.(int)
+		}
+	}
+	return total
+}
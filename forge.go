@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// CODE FORGES (GITHUB, GITLAB, BITBUCKET)
+// =============================================================================
+
+// Forge names accepted by the FORGE setting
+const (
+	forgeGitHub    = "github"
+	forgeGitLab    = "gitlab"
+	forgeBitbucket = "bitbucket"
+)
+
+// Forge is a code hosting service that holds issues and pull (or merge) requests
+type Forge interface {
+	// Name is the forge's setting name, which also prefixes its tool names
+	Name() string
+	// ChangeKind is what the forge calls a pull request, e.g. "merge request"
+	ChangeKind() string
+	// Issue returns an issue with its comments, formatted for Claude
+	Issue(ctx context.Context, number int) (string, error)
+	// Change returns a pull request with its discussion and diff, formatted for Claude
+	Change(ctx context.Context, number int) (string, error)
+	// Comment posts a comment on an issue or pull request
+	Comment(ctx context.Context, ref ForgeRef, body string) error
+	// CreateChange opens a pull request from head into base and returns its URL
+	CreateChange(ctx context.Context, head, base, title, body string) (string, error)
+}
+
+// ForgeRef points at an issue or, if Change is set, a pull request
+type ForgeRef struct {
+	Number int
+	Change bool
+}
+
+// forgeTask is what a forge event asks the agent to do and where to post the answer
+type forgeTask struct {
+	Ref    ForgeRef
+	Prompt string
+}
+
+// forgeCommentPrompt turns an /agent comment into a task
+const forgeCommentPrompt = "You are running as a bot in the %s repository %s, which is checked out in the working directory. " +
+	"%s asked this on %s #%d (%q):\n\n%s\n\n" +
+	"Use the %s tools to read the %s and its discussion, and the file tools to inspect or change the code. " +
+	"Your final reply is posted as a comment, so make it a complete, self-contained answer in Markdown."
+
+// forgeReviewPrompt asks for a review of a newly opened pull request
+const forgeReviewPrompt = "You are running as a bot in the %s repository %s. %s #%d (%q) was just opened. " +
+	"Read it with %s and review the change for bugs, security problems and missing tests; " +
+	"use the file tools for surrounding context. Your final reply is posted as a comment: keep it concise, " +
+	"lead with the most important problems, and say so plainly if the change looks good."
+
+// commentTask builds the task for an /agent comment, or returns nil if the comment is not one
+func commentTask(forge Forge, repo, author, comment string, ref ForgeRef, title string) *forgeTask {
+	request, ok := strings.CutPrefix(strings.TrimSpace(comment), agentCommandPrefix)
+	if !ok {
+		return nil
+	}
+	kind := "issue"
+	if ref.Change {
+		kind = forge.ChangeKind()
+	}
+	prompt := fmt.Sprintf(forgeCommentPrompt, forge.Name(), repo, author, kind, ref.Number, title,
+		strings.TrimSpace(request), forge.Name(), kind)
+	return &forgeTask{Ref: ref, Prompt: prompt}
+}
+
+// reviewTask builds the task for reviewing a newly opened pull request
+func reviewTask(forge Forge, repo string, number int, title string) *forgeTask {
+	kind := forge.ChangeKind()
+	prompt := fmt.Sprintf(forgeReviewPrompt, forge.Name(), repo, strings.ToUpper(kind[:1])+kind[1:], number, title, changeToolName(forge))
+	return &forgeTask{Ref: ForgeRef{Number: number, Change: true}, Prompt: prompt}
+}
+
+// runForgeTask runs a task with the forge tools and posts Claude's reply as a comment
+func runForgeTask(ctx context.Context, client *anthropic.Client, forge Forge, task *forgeTask) error {
+	agent := NewAgent(client, nil, append(defaultTools(), forgeTools(forge)...))
+
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(task.Prompt))}
+	conversation, err := agent.runTurn(ctx, conversation)
+	if err != nil {
+		// Let the requester know instead of failing silently in a CI or server log
+		forge.Comment(ctx, task.Ref, fmt.Sprintf("go-agent could not complete this request: %s", err.Error()))
+		return err
+	}
+
+	body := strings.TrimSpace(lastAssistantText(conversation))
+	if body == "" {
+		body = "go-agent finished without a reply."
+	}
+	if len(agent.editedFiles) > 0 {
+		body += "\n\n<sub>Files changed: " + strings.Join(agent.editedFiles, ", ") + "</sub>"
+	}
+
+	if err := forge.Comment(ctx, task.Ref, body); err != nil {
+		return err
+	}
+	fmt.Printf("%s: posted reply to #%d\n", forge.Name(), task.Ref.Number)
+	return nil
+}
+
+// ForgeNumberInput is the input of the forge tools
+type ForgeNumberInput struct {
+	Number int `json:"number" jsonschema_description:"The issue or pull request number."`
+}
+
+// ForgeNumberInputSchema - Auto-generated JSON schema for ForgeNumberInput
+var ForgeNumberInputSchema = GenerateSchema[ForgeNumberInput]()
+
+// changeToolName is the name of the tool that reads a pull request, e.g. gitlab_get_merge_request
+func changeToolName(forge Forge) string {
+	return forge.Name() + "_get_" + strings.ReplaceAll(forge.ChangeKind(), " ", "_")
+}
+
+// forgeTools returns read-only tools for the forge's issues and pull requests
+func forgeTools(forge Forge) []ToolDefinition {
+	withNumber := func(fetch func(ctx context.Context, number int) (string, error)) func(json.RawMessage) (string, error) {
+		return func(input json.RawMessage) (string, error) {
+			numberInput := ForgeNumberInput{}
+			if err := json.Unmarshal(input, &numberInput); err != nil {
+				return "", fmt.Errorf("invalid input format: %w", err)
+			}
+			return fetch(context.TODO(), numberInput.Number)
+		}
+	}
+
+	return []ToolDefinition{
+		{
+			Name:        forge.Name() + "_get_issue",
+			Description: "Read an issue of this repository: title, description and all comments.",
+			InputSchema: ForgeNumberInputSchema,
+			Function:    withNumber(forge.Issue),
+		},
+		{
+			Name:        changeToolName(forge),
+			Description: fmt.Sprintf("Read a %s of this repository: its description and discussion followed by the full diff.", forge.ChangeKind()),
+			InputSchema: ForgeNumberInputSchema,
+			Function:    withNumber(forge.Change),
+		},
+	}
+}
+
+// detectForge picks the forge from the FORGE setting or the origin remote, defaulting to GitHub
+func detectForge(ctx context.Context) (Forge, error) {
+	host, repo := originRemote(ctx)
+
+	name := strings.ToLower(configValue("FORGE"))
+	if name == "" {
+		switch {
+		case strings.Contains(host, "gitlab"):
+			name = forgeGitLab
+		case strings.Contains(host, "bitbucket"):
+			name = forgeBitbucket
+		default:
+			name = forgeGitHub
+		}
+	}
+
+	switch name {
+	case forgeGitHub:
+		return NewGitHubClient(host, repo)
+	case forgeGitLab:
+		return NewGitLabClient(host, repo)
+	case forgeBitbucket:
+		return NewBitbucketClient(repo)
+	}
+	return nil, fmt.Errorf("unknown FORGE %q (want github, gitlab or bitbucket)", name)
+}
+
+// originRemote returns the host and repository path of the origin remote, if there is one
+func originRemote(ctx context.Context) (host, repo string) {
+	result, err := runProgram(ctx, "git", "remote", "get-url", "origin")
+	if err != nil || !result.Passed() {
+		return "", ""
+	}
+	return parseRemoteURL(strings.TrimSpace(result.Output))
+}
+
+// parseRemoteURL splits an https, ssh or scp-style git remote into host and repository path
+func parseRemoteURL(remote string) (host, repo string) {
+	if !strings.Contains(remote, "://") {
+		// scp-style: git@host:owner/repo.git
+		if at := strings.Index(remote, "@"); at >= 0 {
+			remote = remote[at+1:]
+		}
+		host, repo, _ = strings.Cut(remote, ":")
+	} else if parsed, err := url.Parse(remote); err == nil {
+		host, repo = parsed.Hostname(), parsed.Path
+	}
+	return host, strings.TrimSuffix(strings.Trim(repo, "/"), ".git")
+}
+
+// defaultBranch returns the branch origin/HEAD points at, falling back to main
+func defaultBranch(ctx context.Context) string {
+	result, err := runProgram(ctx, "git", "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+	if err != nil || !result.Passed() {
+		return "main"
+	}
+	return strings.TrimPrefix(strings.TrimSpace(result.Output), "origin/")
+}
+
+// restClient sends authenticated requests to a forge's REST API
+type restClient struct {
+	baseURL string
+	headers map[string]string
+	http    *http.Client
+}
+
+// newRESTClient creates a client for baseURL that adds headers to every request
+func newRESTClient(baseURL string, headers map[string]string) restClient {
+	return restClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		headers: headers,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends a request with an optional JSON body and returns the raw response body
+func (c restClient) do(ctx context.Context, method, path string, body any, accept string) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range c.headers {
+		request.Header.Set(key, value)
+	}
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// getJSON fetches path and decodes the JSON response into v
+func (c restClient) getJSON(ctx context.Context, path string, v any) error {
+	data, err := c.do(ctx, http.MethodGet, path, nil, "")
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// sendJSON sends body with method and decodes the JSON response into v
+func (c restClient) sendJSON(ctx context.Context, method, path string, body, v any) error {
+	data, err := c.do(ctx, method, path, body, "")
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// forgeComment is one comment in a formatted discussion
+type forgeComment struct {
+	Author string
+	Body   string
+}
+
+// formatDiscussion renders an issue or pull request and its comments for Claude
+func formatDiscussion(number int, title, state, author, body string, comments []forgeComment) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "#%d %s (%s, opened by %s)\n\n%s\n", number, title, state, author, body)
+	for _, comment := range comments {
+		fmt.Fprintf(&out, "\n--- %s:\n%s\n", comment.Author, comment.Body)
+	}
+	return out.String()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// GENERATION SETTINGS
+// =============================================================================
+
+// defaultMaxTokens is the longest reply of a model call unless --max-tokens says otherwise
+const defaultMaxTokens = 4096
+
+// Budgets of extended thinking, in tokens: the default of a bare --think, and the least
+// the API accepts
+const (
+	defaultThinkingBudget = 10000
+	minThinkingBudget     = 1024
+)
+
+// thinkFlag is --think, which turns on extended thinking with the default budget, or with
+// the budget given as --think=N
+type thinkFlag struct {
+	budget *int64
+}
+
+// String returns the budget, or "" when thinking is off
+func (f thinkFlag) String() string {
+	if f.budget == nil || *f.budget == 0 {
+		return ""
+	}
+	return strconv.FormatInt(*f.budget, 10)
+}
+
+// Set records the budget; a bare --think sets "true"
+func (f thinkFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*f.budget = int64(configInt("THINKING_BUDGET", defaultThinkingBudget))
+	case "false":
+		*f.budget = 0
+	default:
+		budget, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid thinking budget %q", value)
+		}
+		*f.budget = budget
+	}
+	return nil
+}
+
+// IsBoolFlag lets --think stand without a value
+func (f thinkFlag) IsBoolFlag() bool {
+	return true
+}
+
+// floatFlag parses a --temperature or --top-p value into *target
+func floatFlag(target **float64) func(string) error {
+	return func(text string) error {
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		*target = &value
+		return nil
+	}
+}
+
+// configFloat returns a decimal setting, or nil when it is unset or malformed
+func configFloat(key string) *float64 {
+	value, err := strconv.ParseFloat(configValue(key), 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+// configStopSequences reads STOP_SEQUENCES, a comma-separated list
+func configStopSequences() []string {
+	sequences := []string{}
+	for _, sequence := range strings.Split(configValue("STOP_SEQUENCES"), ",") {
+		if sequence != "" {
+			sequences = append(sequences, sequence)
+		}
+	}
+	return sequences
+}
+
+// validateGeneration checks the generation settings against the ranges the API accepts
+func (o *RunOptions) validateGeneration() error {
+	if o.MaxTokens <= 0 {
+		return fmt.Errorf("--max-tokens must be positive, not %d", o.MaxTokens)
+	}
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 1) {
+		return fmt.Errorf("--temperature must be between 0 and 1, not %g", *o.Temperature)
+	}
+	if o.TopP != nil && (*o.TopP <= 0 || *o.TopP > 1) {
+		return fmt.Errorf("--top-p must be above 0 and at most 1, not %g", *o.TopP)
+	}
+	for _, sequence := range o.StopSequences {
+		if strings.TrimSpace(sequence) == "" {
+			return fmt.Errorf("--stop sequences must not be blank")
+		}
+	}
+	if o.ThinkingBudget != 0 {
+		if o.ThinkingBudget < minThinkingBudget {
+			return fmt.Errorf("--think needs a budget of at least %d tokens, not %d", minThinkingBudget, o.ThinkingBudget)
+		}
+		if o.Temperature != nil {
+			return fmt.Errorf("--think cannot be combined with --temperature; extended thinking samples at the API's temperature")
+		}
+		if o.TopP != nil && *o.TopP < 0.95 {
+			return fmt.Errorf("--think needs a --top-p of at least 0.95, not %g", *o.TopP)
+		}
+	}
+	return nil
+}
+
+// withGeneration adds the generation settings to the params of an agent model call.
+// Temperature and top_p are left out when unset, so the API default applies. With
+// extended thinking, the thinking budget comes on top of --max-tokens, which still
+// limits the answer.
+func (o *RunOptions) withGeneration(params anthropic.MessageNewParams) anthropic.MessageNewParams {
+	params.MaxTokens = o.MaxTokens
+	if o.ThinkingBudget > 0 {
+		params.MaxTokens += o.ThinkingBudget
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(o.ThinkingBudget)
+	}
+	if o.Temperature != nil {
+		params.Temperature = anthropic.Float(*o.Temperature)
+	}
+	if o.TopP != nil {
+		params.TopP = anthropic.Float(*o.TopP)
+	}
+	params.StopSequences = o.StopSequences
+	return params
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// GIT DIFF ATTACHMENTS
+// =============================================================================
+
+// defaultDiffMaxTokens caps an attached diff, unless DIFF_MAX_TOKENS is set
+const defaultDiffMaxTokens = 20000
+
+// workingTreeDiff is the --attach-diff value for the uncommitted changes
+const workingTreeDiff = "true"
+
+// diffFlag is --attach-diff: on its own it attaches the uncommitted changes, and with a
+// value such as --attach-diff=main..HEAD a commit range
+type diffFlag struct {
+	spec *string
+}
+
+// String returns the range, "true" for the uncommitted changes or "" when not set
+func (f diffFlag) String() string {
+	if f.spec == nil {
+		return ""
+	}
+	return *f.spec
+}
+
+// Set records the range; a bare --attach-diff sets "true"
+func (f diffFlag) Set(value string) error {
+	if value == "false" {
+		value = ""
+	}
+	*f.spec = value
+	return nil
+}
+
+// IsBoolFlag lets --attach-diff stand without a value
+func (f diffFlag) IsBoolFlag() bool {
+	return true
+}
+
+// diffFile is one file of a diff with its line counts; binary files count as -1
+type diffFile struct {
+	path       string
+	insertions int
+	deletions  int
+}
+
+// gitDiff returns the diff of spec with its files: the uncommitted changes against HEAD,
+// untracked files included, when spec is empty, or else the diff git diff produces for
+// spec, such as main..HEAD or HEAD~3. With --target only the target's files are included.
+func gitDiff(ctx context.Context, spec string) (string, []diffFile, error) {
+	args := strings.Fields(spec)
+	if len(args) == 0 {
+		args = []string{"HEAD"}
+	}
+	paths := []string{"--", runOptions.target.dir()}
+
+	diff, err := gitOutput(ctx, append(append([]string{"diff", "--no-color", "--no-ext-diff"}, args...), paths...)...)
+	if err != nil {
+		return "", nil, err
+	}
+	numstat, err := gitOutput(ctx, append(append([]string{"diff", "--numstat"}, args...), paths...)...)
+	if err != nil {
+		return "", nil, err
+	}
+	files := parseNumstat(numstat)
+
+	if spec == "" {
+		untracked, err := gitOutput(ctx, append([]string{"ls-files", "--others", "--exclude-standard"}, paths...)...)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, path := range strings.Split(strings.TrimSpace(untracked), "\n") {
+			if path == "" {
+				continue
+			}
+			// --no-index exits with 1 when the files differ, which they always do here
+			result, err := runProgram(ctx, "git", "diff", "--no-color", "--no-index", "--", "/dev/null", path)
+			if err != nil {
+				return "", nil, err
+			}
+			diff += result.Output
+			stat, _ := runProgram(ctx, "git", "diff", "--numstat", "--no-index", "--", "/dev/null", path)
+			for _, file := range parseNumstat(stat.Output) {
+				files = append(files, diffFile{path: path, insertions: file.insertions, deletions: file.deletions})
+			}
+		}
+	}
+	return diff, files, nil
+}
+
+// gitOutput runs git with args and returns its output, or an error when it fails
+func gitOutput(ctx context.Context, args ...string) (string, error) {
+	result, err := runProgram(ctx, "git", args...)
+	if err != nil {
+		return "", err
+	}
+	if !result.Passed() {
+		return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(result.Output))
+	}
+	return result.Output, nil
+}
+
+// parseNumstat reads the output of git diff --numstat
+func parseNumstat(output string) []diffFile {
+	files := []diffFile{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		file := diffFile{path: fields[2], insertions: -1, deletions: -1}
+		if n, err := strconv.Atoi(fields[0]); err == nil {
+			file.insertions = n
+		}
+		if n, err := strconv.Atoi(fields[1]); err == nil {
+			file.deletions = n
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// diffContext returns the diff of spec as context for the next message, labeled with the
+// range and the files it changes. It reports false when there are no changes.
+func diffContext(ctx context.Context, spec string) (anthropic.ContentBlockParamUnion, bool, error) {
+	diff, files, err := gitDiff(ctx, spec)
+	if err != nil || len(files) == 0 {
+		return anthropic.ContentBlockParamUnion{}, false, err
+	}
+
+	label := cmp.Or(spec, "uncommitted changes")
+	var summary strings.Builder
+	insertions, deletions := 0, 0
+	for _, file := range files {
+		if file.insertions < 0 {
+			fmt.Fprintf(&summary, "%s (binary)\n", file.path)
+			continue
+		}
+		fmt.Fprintf(&summary, "%s +%d -%d\n", file.path, file.insertions, file.deletions)
+		insertions += file.insertions
+		deletions += file.deletions
+	}
+
+	limit := configInt("DIFF_MAX_TOKENS", defaultDiffMaxTokens)
+	fmt.Fprintf(os.Stderr, "%s: git diff of %s: %d file(s), +%d -%d (~%d tokens)\n", paint(roleInfo, "context"), label, len(files), insertions, deletions, estimateTokens(diff))
+	if estimateTokens(diff) > limit {
+		fmt.Fprintf(os.Stderr, "%s: the diff is cut to DIFF_MAX_TOKENS=%d tokens; Claude can read the rest of the files with its tools\n", paint(roleWarning, "warning"), limit)
+	}
+	return anthropic.NewTextBlock(fmt.Sprintf("<git-diff range=%q>\n<files>\n%s</files>\n%s\n</git-diff>",
+		label, summary.String(), strings.TrimRight(truncateToTokens(diff, limit), "\n"))), true, nil
+}
+
+// diffCommand implements /diff
+func diffCommand(chat *chatState, args string) error {
+	block, ok, err := diffContext(chat.ctx, args)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println("No changes to attach")
+		return nil
+	}
+	chat.attached = append(chat.attached, block)
+	fmt.Println("The diff is attached to your next message")
+	return nil
+}
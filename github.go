@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// =============================================================================
+// GITHUB CLIENT AND ACTIONS ADAPTER
+// =============================================================================
+
+// agentCommandPrefix starts an issue or PR comment addressed to the agent
+const agentCommandPrefix = "/agent"
+
+// trustedAssociations are the comment authors allowed to trigger the agent
+var trustedAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// GitHubClient calls the GitHub REST API for one repository
+type GitHubClient struct {
+	restClient
+	repo string // owner/name
+}
+
+// NewGitHubClient configures a client from GITHUB_TOKEN, preferring the variables GitHub
+// Actions sets over the host and repository of the origin remote
+func NewGitHubClient(host, repo string) (*GitHubClient, error) {
+	if env := os.Getenv("GITHUB_REPOSITORY"); env != "" {
+		repo = env
+	}
+	token := configValue("GITHUB_TOKEN")
+	if token == "" || repo == "" {
+		return nil, fmt.Errorf("GITHUB_TOKEN and GITHUB_REPOSITORY (or a GitHub origin remote) are required")
+	}
+
+	baseURL := os.Getenv("GITHUB_API_URL")
+	if baseURL == "" {
+		baseURL = "https://api.github.com"
+		if host != "" && host != "github.com" {
+			baseURL = "https://" + host + "/api/v3" // GitHub Enterprise Server
+		}
+	}
+
+	return &GitHubClient{
+		restClient: newRESTClient(baseURL+"/repos/"+repo, map[string]string{
+			"Accept":               "application/vnd.github+json",
+			"Authorization":        "Bearer " + token,
+			"X-GitHub-Api-Version": "2022-11-28",
+		}),
+		repo: repo,
+	}, nil
+}
+
+// Name implements Forge
+func (c *GitHubClient) Name() string { return forgeGitHub }
+
+// ChangeKind implements Forge
+func (c *GitHubClient) ChangeKind() string { return "pull request" }
+
+// GitHubIssue is the subset of an issue or pull request we show Claude
+type GitHubIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	State  string `json:"state"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// GitHubComment is a comment on an issue or pull request
+type GitHubComment struct {
+	Body string `json:"body"`
+	User struct {
+		Login string `json:"login"`
+		Type  string `json:"type"`
+	} `json:"user"`
+	AuthorAssociation string `json:"author_association"`
+}
+
+// Issue implements Forge; on GitHub pull requests are issues too
+func (c *GitHubClient) Issue(ctx context.Context, number int) (string, error) {
+	issue := GitHubIssue{}
+	if err := c.getJSON(ctx, fmt.Sprintf("/issues/%d", number), &issue); err != nil {
+		return "", err
+	}
+	comments := []GitHubComment{}
+	if err := c.getJSON(ctx, fmt.Sprintf("/issues/%d/comments?per_page=100", number), &comments); err != nil {
+		return "", err
+	}
+
+	discussion := []forgeComment{}
+	for _, comment := range comments {
+		discussion = append(discussion, forgeComment{Author: "@" + comment.User.Login, Body: comment.Body})
+	}
+	return formatDiscussion(issue.Number, issue.Title, issue.State, "@"+issue.User.Login, issue.Body, discussion), nil
+}
+
+// Change implements Forge
+func (c *GitHubClient) Change(ctx context.Context, number int) (string, error) {
+	discussion, err := c.Issue(ctx, number)
+	if err != nil {
+		return "", err
+	}
+	diff, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/pulls/%d", number), nil, "application/vnd.github.diff")
+	if err != nil {
+		return "", err
+	}
+	return discussion + "\n\nDiff:\n" + string(diff), nil
+}
+
+// Comment implements Forge
+func (c *GitHubClient) Comment(ctx context.Context, ref ForgeRef, body string) error {
+	return c.sendJSON(ctx, http.MethodPost, fmt.Sprintf("/issues/%d/comments", ref.Number), map[string]string{"body": body}, nil)
+}
+
+// CreateChange implements Forge
+func (c *GitHubClient) CreateChange(ctx context.Context, head, base, title, body string) (string, error) {
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	request := map[string]string{"head": head, "base": base, "title": title, "body": body}
+	if err := c.sendJSON(ctx, http.MethodPost, "/pulls", request, &created); err != nil {
+		return "", err
+	}
+	return created.HTMLURL, nil
+}
+
+// githubEvent is the subset of the issue_comment and pull_request payloads we handle
+type githubEvent struct {
+	Action string `json:"action"`
+	Issue  *struct {
+		GitHubIssue
+		PullRequest *struct{} `json:"pull_request"`
+	} `json:"issue"`
+	Comment     *GitHubComment `json:"comment"`
+	PullRequest *GitHubIssue   `json:"pull_request"`
+}
+
+// runGitHubActionCommand implements `go-agent github-action`
+func runGitHubActionCommand(args []string) error {
+	eventName := os.Getenv("GITHUB_EVENT_NAME")
+	eventPath := os.Getenv("GITHUB_EVENT_PATH")
+	if eventName == "" || eventPath == "" {
+		return fmt.Errorf("github-action must run inside GitHub Actions (GITHUB_EVENT_NAME and GITHUB_EVENT_PATH are unset)")
+	}
+
+	data, err := os.ReadFile(eventPath)
+	if err != nil {
+		return err
+	}
+	event := githubEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return fmt.Errorf("invalid event payload: %w", err)
+	}
+
+	github, err := NewGitHubClient("", "")
+	if err != nil {
+		return err
+	}
+
+	task, reason := github.eventTask(eventName, event)
+	if task == nil {
+		fmt.Fprintf(os.Stderr, "github-action: ignoring %s event: %s\n", eventName, reason)
+		return nil
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+	return runForgeTask(context.TODO(), client, github, task)
+}
+
+// eventTask maps an Actions or webhook event to a task, or explains why it is ignored
+func (c *GitHubClient) eventTask(eventName string, event githubEvent) (*forgeTask, string) {
+	switch eventName {
+	case "issue_comment":
+		if event.Action != "created" || event.Issue == nil || event.Comment == nil {
+			return nil, "not a new comment"
+		}
+		if !strings.HasPrefix(strings.TrimSpace(event.Comment.Body), agentCommandPrefix) {
+			return nil, "comment does not start with " + agentCommandPrefix
+		}
+		if event.Comment.User.Type == "Bot" {
+			return nil, "comment was written by a bot"
+		}
+		if !slices.Contains(trustedAssociations, event.Comment.AuthorAssociation) {
+			return nil, fmt.Sprintf("@%s is not a repository collaborator", event.Comment.User.Login)
+		}
+		ref := ForgeRef{Number: event.Issue.Number, Change: event.Issue.PullRequest != nil}
+		return commentTask(c, c.repo, "@"+event.Comment.User.Login, event.Comment.Body, ref, event.Issue.Title), ""
+
+	case "pull_request", "pull_request_target":
+		if event.PullRequest == nil || (event.Action != "opened" && event.Action != "reopened") {
+			return nil, "only opened pull requests are reviewed"
+		}
+		return reviewTask(c, c.repo, event.PullRequest.Number, event.PullRequest.Title), ""
+	}
+
+	return nil, "unsupported event"
+}
+
+// verifyWebhook implements webhookForge using the X-Hub-Signature-256 header
+func (c *GitHubClient) verifyWebhook(header http.Header, body []byte, secret string) bool {
+	return validHMAC(header.Get("X-Hub-Signature-256"), body, secret)
+}
+
+// webhookTask implements webhookForge; deliveries carry the same payloads as Actions events
+func (c *GitHubClient) webhookTask(ctx context.Context, header http.Header, body []byte) (*forgeTask, string) {
+	event := githubEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, "invalid payload"
+	}
+	return c.eventTask(header.Get("X-GitHub-Event"), event)
+}
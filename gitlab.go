@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// =============================================================================
+// GITLAB CLIENT
+// =============================================================================
+
+// gitlabDeveloperAccess is the lowest project access level allowed to trigger the agent
+const gitlabDeveloperAccess = 30
+
+// GitLabClient calls the GitLab REST API (v4) for one project
+type GitLabClient struct {
+	restClient
+	project string // namespace/name
+}
+
+// NewGitLabClient configures a client from GITLAB_TOKEN, preferring the variables GitLab CI
+// sets over the host and project of the origin remote
+func NewGitLabClient(host, project string) (*GitLabClient, error) {
+	if env := os.Getenv("CI_PROJECT_PATH"); env != "" {
+		project = env
+	}
+	token := configValue("GITLAB_TOKEN")
+	if token == "" || project == "" {
+		return nil, fmt.Errorf("GITLAB_TOKEN and CI_PROJECT_PATH (or a GitLab origin remote) are required")
+	}
+
+	baseURL := configValue("GITLAB_API_URL")
+	if baseURL == "" {
+		baseURL = os.Getenv("CI_API_V4_URL")
+	}
+	if baseURL == "" {
+		if host == "" {
+			host = "gitlab.com"
+		}
+		baseURL = "https://" + host + "/api/v4"
+	}
+
+	return &GitLabClient{
+		restClient: newRESTClient(baseURL+"/projects/"+url.PathEscape(project), map[string]string{
+			"PRIVATE-TOKEN": token,
+		}),
+		project: project,
+	}, nil
+}
+
+// Name implements Forge
+func (c *GitLabClient) Name() string { return forgeGitLab }
+
+// ChangeKind implements Forge
+func (c *GitLabClient) ChangeKind() string { return "merge request" }
+
+// gitlabItem is the subset of an issue or merge request we show Claude
+type gitlabItem struct {
+	IID         int    `json:"iid"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+	Author      struct {
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
+// gitlabNote is a comment on an issue or merge request
+type gitlabNote struct {
+	Body   string `json:"body"`
+	System bool   `json:"system"`
+	Author struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
+// itemPath is the API path of an issue or merge request
+func (c *GitLabClient) itemPath(ref ForgeRef) string {
+	if ref.Change {
+		return fmt.Sprintf("/merge_requests/%d", ref.Number)
+	}
+	return fmt.Sprintf("/issues/%d", ref.Number)
+}
+
+// discussion fetches an issue or merge request with its comments, skipping system notes
+func (c *GitLabClient) discussion(ctx context.Context, ref ForgeRef) (string, error) {
+	item := gitlabItem{}
+	if err := c.getJSON(ctx, c.itemPath(ref), &item); err != nil {
+		return "", err
+	}
+	notes := []gitlabNote{}
+	if err := c.getJSON(ctx, c.itemPath(ref)+"/notes?sort=asc&per_page=100", &notes); err != nil {
+		return "", err
+	}
+
+	comments := []forgeComment{}
+	for _, note := range notes {
+		if !note.System {
+			comments = append(comments, forgeComment{Author: "@" + note.Author.Username, Body: note.Body})
+		}
+	}
+	return formatDiscussion(item.IID, item.Title, item.State, "@"+item.Author.Username, item.Description, comments), nil
+}
+
+// Issue implements Forge
+func (c *GitLabClient) Issue(ctx context.Context, number int) (string, error) {
+	return c.discussion(ctx, ForgeRef{Number: number})
+}
+
+// Change implements Forge
+func (c *GitLabClient) Change(ctx context.Context, number int) (string, error) {
+	ref := ForgeRef{Number: number, Change: true}
+	discussion, err := c.discussion(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	files := []struct {
+		OldPath string `json:"old_path"`
+		NewPath string `json:"new_path"`
+		Diff    string `json:"diff"`
+	}{}
+	if err := c.getJSON(ctx, c.itemPath(ref)+"/diffs?per_page=100", &files); err != nil {
+		return "", err
+	}
+
+	var diff strings.Builder
+	for _, file := range files {
+		fmt.Fprintf(&diff, "--- a/%s\n+++ b/%s\n%s", file.OldPath, file.NewPath, file.Diff)
+	}
+	return discussion + "\n\nDiff:\n" + diff.String(), nil
+}
+
+// Comment implements Forge
+func (c *GitLabClient) Comment(ctx context.Context, ref ForgeRef, body string) error {
+	return c.sendJSON(ctx, http.MethodPost, c.itemPath(ref)+"/notes", map[string]string{"body": body}, nil)
+}
+
+// CreateChange implements Forge
+func (c *GitLabClient) CreateChange(ctx context.Context, head, base, title, body string) (string, error) {
+	var created struct {
+		WebURL string `json:"web_url"`
+	}
+	request := map[string]string{"source_branch": head, "target_branch": base, "title": title, "description": body}
+	if err := c.sendJSON(ctx, http.MethodPost, "/merge_requests", request, &created); err != nil {
+		return "", err
+	}
+	return created.WebURL, nil
+}
+
+// isDeveloper reports whether the user has at least Developer access to the project
+func (c *GitLabClient) isDeveloper(ctx context.Context, userID int) bool {
+	var member struct {
+		AccessLevel int `json:"access_level"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("/members/all/%d", userID), &member); err != nil {
+		return false
+	}
+	return member.AccessLevel >= gitlabDeveloperAccess
+}
+
+// verifyWebhook implements webhookForge; GitLab sends the secret itself as X-Gitlab-Token
+func (c *GitLabClient) verifyWebhook(header http.Header, body []byte, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+}
+
+// webhookTask implements webhookForge for note (comment) and merge request hooks
+func (c *GitLabClient) webhookTask(ctx context.Context, header http.Header, body []byte) (*forgeTask, string) {
+	var event struct {
+		ObjectKind string `json:"object_kind"`
+		User       struct {
+			ID       int    `json:"id"`
+			Username string `json:"username"`
+		} `json:"user"`
+		ObjectAttributes struct {
+			Note         string `json:"note"`
+			NoteableType string `json:"noteable_type"`
+			IID          int    `json:"iid"`
+			Title        string `json:"title"`
+			Action       string `json:"action"`
+		} `json:"object_attributes"`
+		Issue        *gitlabItem `json:"issue"`
+		MergeRequest *gitlabItem `json:"merge_request"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, "invalid payload"
+	}
+
+	switch event.ObjectKind {
+	case "note":
+		attributes := event.ObjectAttributes
+		if !strings.HasPrefix(strings.TrimSpace(attributes.Note), agentCommandPrefix) {
+			return nil, "comment does not start with " + agentCommandPrefix
+		}
+		item, change := event.Issue, false
+		if attributes.NoteableType == "MergeRequest" {
+			item, change = event.MergeRequest, true
+		}
+		if item == nil {
+			return nil, "comment is not on an issue or merge request"
+		}
+		if !c.isDeveloper(ctx, event.User.ID) {
+			return nil, fmt.Sprintf("@%s does not have Developer access", event.User.Username)
+		}
+		ref := ForgeRef{Number: item.IID, Change: change}
+		return commentTask(c, c.project, "@"+event.User.Username, attributes.Note, ref, item.Title), ""
+
+	case "merge_request":
+		if action := event.ObjectAttributes.Action; action != "open" && action != "reopen" {
+			return nil, "only opened merge requests are reviewed"
+		}
+		return reviewTask(c, c.project, event.ObjectAttributes.IID, event.ObjectAttributes.Title), ""
+	}
+
+	return nil, "unsupported event"
+}
@@ -0,0 +1,253 @@
+// Package golden compares tool outputs and exported transcripts with golden files in
+// testdata, after normalizing away what changes from run to run.
+//
+// A test of a new tool runs it and checks its output against testdata/<name>.golden:
+//
+//	func TestListFiles(t *testing.T) {
+//		output, err := ListFiles(json.RawMessage(`{"path": "testdata/tree"}`))
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		golden.Assert(t, "list_files", output, golden.SortJSONList)
+//	}
+//
+// Transcripts and event logs carry timestamps, versions and terminal colors; the
+// Transcript normalizers remove them:
+//
+//	golden.Assert(t, "export", renderTranscript(events), golden.Transcript...)
+//
+// Run the package's tests with -update to write the golden files from the current output, then
+// review the changes with git diff.
+package golden
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// update rewrites golden files instead of comparing with them
+var update = flag.Bool("update", false, "write golden files from the current output instead of comparing")
+
+// A Normalizer rewrites output so it is the same on every run
+type Normalizer func(string) string
+
+// Transcript normalizes exported transcripts and --log event lines
+var Transcript = []Normalizer{
+	StripANSI,
+	StripTimestamps,
+	RedactJSONFields("agent_version", "go_version", "sdk_version", "workspace", "git_commit", "git_dirty"),
+	TrimTrailingSpace,
+}
+
+// Path returns the golden file of name
+func Path(name string) string {
+	return filepath.Join("testdata", name+".golden")
+}
+
+// Assert fails the test unless got, normalized, matches the golden file of name. With
+// -update it writes the golden file instead.
+func Assert(t testing.TB, name, got string, normalizers ...Normalizer) {
+	t.Helper()
+	got = Normalize(got, normalizers...)
+	path := Path(name)
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Fatalf("%s does not exist; run the test with -update to create it", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if difference := firstDifference(string(want), got); difference != "" {
+		t.Errorf("output differs from %s (run with -update to accept it)\n%s", path, difference)
+	}
+}
+
+// AssertJSON is Assert for a value, written as indented JSON
+func AssertJSON(t testing.TB, name string, v any, normalizers ...Normalizer) {
+	t.Helper()
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Assert(t, name, string(data)+"\n", normalizers...)
+}
+
+// Normalize applies the normalizers to text in order
+func Normalize(text string, normalizers ...Normalizer) string {
+	for _, normalize := range normalizers {
+		text = normalize(text)
+	}
+	return text
+}
+
+// firstDifference describes the first line where got differs from want, or returns ""
+func firstDifference(want, got string) string {
+	if want == got {
+		return ""
+	}
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		wantLine, gotLine := "(end of file)", "(end of output)"
+		if i < len(wantLines) {
+			wantLine = fmt.Sprintf("%q", wantLines[i])
+		}
+		if i < len(gotLines) {
+			gotLine = fmt.Sprintf("%q", gotLines[i])
+		}
+		if wantLine != gotLine {
+			return fmt.Sprintf("line %d:\n  want %s\n  got  %s", i+1, wantLine, gotLine)
+		}
+	}
+	return ""
+}
+
+// Patterns the normalizers replace
+var (
+	ansiEscape = regexp.MustCompile("\u001b\\[[0-9;]*[A-Za-z]")
+	timestamp  = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2}| [A-Z]{2,5})?`)
+)
+
+// StripANSI removes terminal colors and cursor movement
+func StripANSI(text string) string {
+	return ansiEscape.ReplaceAllString(text, "")
+}
+
+// StripTimestamps replaces dates with times, such as RFC 3339 event times and the
+// "2006-01-02 15:04 MST" of transcripts, with <TIME>
+func StripTimestamps(text string) string {
+	return timestamp.ReplaceAllString(text, "<TIME>")
+}
+
+// TrimTrailingSpace removes spaces at the end of lines, which editors tend to strip
+// from golden files
+func TrimTrailingSpace(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SortLines sorts the lines of a listing whose order is not defined, such as the
+// output of a directory walk or a map
+func SortLines(text string) string {
+	trailing := strings.HasSuffix(text, "\n")
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	sort.Strings(lines)
+	if trailing {
+		return strings.Join(lines, "\n") + "\n"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SortJSONList sorts a JSON array of strings, such as the list_files output. Other text
+// is left alone.
+func SortJSONList(text string) string {
+	list := []string{}
+	if json.Unmarshal([]byte(text), &list) != nil {
+		return text
+	}
+	sort.Strings(list)
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
+// Replace returns a normalizer that replaces every match of pattern, e.g. a temporary
+// directory, with replacement
+func Replace(pattern, replacement string) Normalizer {
+	re := regexp.MustCompile(pattern)
+	return func(text string) string {
+		return re.ReplaceAllString(text, replacement)
+	}
+}
+
+// ReplacePath returns a normalizer that replaces a path such as t.TempDir() with
+// placeholder, so outputs that name it do not depend on where the test ran
+func ReplacePath(path, placeholder string) Normalizer {
+	return func(text string) string {
+		text = strings.ReplaceAll(text, path, placeholder)
+		return strings.ReplaceAll(text, filepath.ToSlash(path), placeholder)
+	}
+}
+
+// RedactJSONFields returns a normalizer that replaces the values of the named fields,
+// at any depth, with "<REDACTED>". It works line by line, so it handles both JSON
+// documents and JSON lines logs; lines that are not JSON objects are kept.
+func RedactJSONFields(fields ...string) Normalizer {
+	redact := map[string]bool{}
+	for _, field := range fields {
+		redact[field] = true
+	}
+	return func(text string) string {
+		if value, ok := redactDocument(text, redact); ok {
+			return value
+		}
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			if value, ok := redactDocument(line, redact); ok {
+				lines[i] = value
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+}
+
+// redactDocument redacts a JSON object, keeping the indentation of indented documents
+func redactDocument(text string, redact map[string]bool) (string, bool) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "{") {
+		return "", false
+	}
+	var value any
+	if json.Unmarshal([]byte(trimmed), &value) != nil {
+		return "", false
+	}
+	value = redactValue(value, redact)
+
+	var data strings.Builder
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if strings.Contains(trimmed, "\n") {
+		encoder.SetIndent("", "  ")
+	}
+	encoder.Encode(value)
+	return strings.Replace(text, trimmed, strings.TrimSuffix(data.String(), "\n"), 1), true
+}
+
+// redactValue replaces the redacted fields within a decoded JSON value
+func redactValue(value any, redact map[string]bool) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if redact[key] {
+				value[key] = "<REDACTED>"
+			} else {
+				value[key] = redactValue(field, redact)
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactValue(item, redact)
+		}
+	}
+	return value
+}
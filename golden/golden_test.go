@@ -0,0 +1,86 @@
+package golden
+
+import (
+	"testing"
+)
+
+func TestNormalizers(t *testing.T) {
+	tests := []struct {
+		name      string
+		normalize Normalizer
+		in, want  string
+	}{
+		{"StripANSI colors", StripANSI, "\u001b[1;95mheading\u001b[0m plain", "heading plain"},
+		{"StripANSI cursor movement", StripANSI, "line\u001b[2K\u001b[1Aover", "lineover"},
+		{"StripANSI no escapes", StripANSI, "plain text", "plain text"},
+
+		{"StripTimestamps RFC 3339", StripTimestamps, `{"time":"2026-10-15T01:57:03.123456Z"}`, `{"time":"<TIME>"}`},
+		{"StripTimestamps offset", StripTimestamps, "at 2026-10-15T01:57:03+02:00.", "at <TIME>."},
+		{"StripTimestamps transcript", StripTimestamps, "Started 2026-10-15 01:57 UTC with", "Started <TIME> with"},
+		{"StripTimestamps no date", StripTimestamps, "version 1.2.3", "version 1.2.3"},
+
+		{"TrimTrailingSpace", TrimTrailingSpace, "a  \nb\t\r\n  c", "a\nb\n  c"},
+		{"TrimTrailingSpace keeps final newline", TrimTrailingSpace, "a \n", "a\n"},
+
+		{"SortLines", SortLines, "b\nc\na\n", "a\nb\nc\n"},
+		{"SortLines without final newline", SortLines, "b\na", "a\nb"},
+
+		{"SortJSONList", SortJSONList, `["b.go","a.go","dir/"]`, `["a.go","b.go","dir/"]`},
+		{"SortJSONList leaves other text", SortJSONList, `{"b":1}`, `{"b":1}`},
+
+		{"Replace", Replace(`/tmp/Test\w+/\d+`, "<TMP>"), "wrote /tmp/TestExport/001/out.md", "wrote <TMP>/out.md"},
+
+		{"ReplacePath", ReplacePath("/home/ann/work", "<WORKSPACE>"), "cd /home/ann/work/src", "cd <WORKSPACE>/src"},
+
+		{"RedactJSONFields line", RedactJSONFields("go_version"), `{"go_version":"go1.23","model":"m"}`, `{"go_version":"<REDACTED>","model":"m"}`},
+		{"RedactJSONFields nested", RedactJSONFields("sdk_version"), `{"metadata":{"sdk_version":"v1"},"items":[{"sdk_version":"v2"}]}`, `{"items":[{"sdk_version":"<REDACTED>"}],"metadata":{"sdk_version":"<REDACTED>"}}`},
+		{"RedactJSONFields JSON lines", RedactJSONFields("workspace"), "{\"workspace\":\"/a\"}\nnot json\n{\"workspace\":\"/b\"}", "{\"workspace\":\"<REDACTED>\"}\nnot json\n{\"workspace\":\"<REDACTED>\"}"},
+		{"RedactJSONFields indented", RedactJSONFields("workspace"), "{\n  \"workspace\": \"/a\"\n}", "{\n  \"workspace\": \"<REDACTED>\"\n}"},
+		{"RedactJSONFields not JSON", RedactJSONFields("workspace"), "workspace: /a", "workspace: /a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.normalize(test.in); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name        string
+		normalizers []Normalizer
+		in, want    string
+	}{
+		{"none", nil, "a \n", "a \n"},
+		{"in order", []Normalizer{StripANSI, TrimTrailingSpace}, "\u001b[1ma\u001b[0m \n", "a\n"},
+		{"transcript", Transcript, "\u001b[96mStarted 2026-10-15 01:57 UTC\u001b[0m  \n{\"time\":\"2026-10-15T01:57:03Z\",\"agent_version\":\"v1.0.0\"}\n",
+			"Started <TIME>\n{\"agent_version\":\"<REDACTED>\",\"time\":\"<TIME>\"}\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Normalize(test.in, test.normalizers...); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+	tests := []struct {
+		name, want, got, difference string
+	}{
+		{"same", "a\nb\n", "a\nb\n", ""},
+		{"changed line", "a\nb\n", "a\nc\n", "line 2:\n  want \"b\"\n  got  \"c\""},
+		{"longer output", "a", "a\nb", "line 2:\n  want (end of file)\n  got  \"b\""},
+		{"shorter output", "a\nb", "a", "line 2:\n  want \"b\"\n  got  (end of output)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if difference := firstDifference(test.want, test.got); difference != test.difference {
+				t.Errorf("got %q, want %q", difference, test.difference)
+			}
+		})
+	}
+}
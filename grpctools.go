@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// =============================================================================
+// GRPC TOOLS
+// =============================================================================
+
+// GRPCConfig lists the gRPC servers whose methods are offered to Claude as tools. The
+// servers describe their methods through the reflection service, so no .proto files
+// are needed.
+type GRPCConfig struct {
+	Services []GRPCSource `json:"services"`
+}
+
+// GRPCSource is one server and the methods it contributes
+type GRPCSource struct {
+	Name      string            `json:"name"`      // Prefix of the tool names, e.g. inventory for inventory_GetItem
+	Address   string            `json:"address"`   // host:port of the server
+	Plaintext bool              `json:"plaintext"` // Connect without TLS, for local and in-cluster servers
+	Methods   []string          `json:"methods"`   // Globs over package.Service/Method to offer; all but reflection and health when empty
+	Exclude   []string          `json:"exclude"`   // Methods never offered, in the same form
+	ReadOnly  []string          `json:"read_only"` // Methods that change nothing, besides those marked idempotency_level = NO_SIDE_EFFECTS
+	Metadata  map[string]string `json:"metadata"`  // Sent with every call
+	Auth      *APIAuth          `json:"auth"`      // bearer, basic or header
+}
+
+// Reflection services a server may offer, newest first
+var reflectionMethods = []string{
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", // Same messages as v1
+}
+
+// grpcReflectTimeout bounds how long a server may take to describe its services
+const grpcReflectTimeout = 15 * time.Second
+
+// loadGRPCTools connects to the servers of the config at path and returns a tool for
+// every selected unary method. The connections are closed by cleanup.
+func loadGRPCTools(ctx context.Context, path string, cleanup *cleanupManager) ([]ToolDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gRPC tools: %w", err)
+	}
+	config := &GRPCConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse gRPC tools %s: %w", path, err)
+	}
+
+	tools := []ToolDefinition{}
+	for i, source := range config.Services {
+		if source.Name == "" || source.Address == "" {
+			return nil, fmt.Errorf("gRPC tools %s: service %d needs a name and an address", path, i+1)
+		}
+		if source.Auth != nil {
+			switch source.Auth.Type {
+			case "bearer", "basic", "header":
+			default:
+				return nil, fmt.Errorf("gRPC tools %s: %s: unknown auth type %q", path, source.Name, source.Auth.Type)
+			}
+		}
+
+		if err := checkToolEgress(ctx, grpcHost(source.Address)); err != nil {
+			return nil, fmt.Errorf("gRPC tools %s: %s: %w", path, source.Name, err)
+		}
+		transport := credentials.NewTLS(&tls.Config{})
+		if source.Plaintext {
+			transport = insecure.NewCredentials()
+		}
+		conn, err := grpc.NewClient(source.Address, grpc.WithTransportCredentials(transport))
+		if err != nil {
+			return nil, fmt.Errorf("gRPC tools %s: %s: %w", path, source.Name, err)
+		}
+		cleanup.add(func() { conn.Close() })
+
+		files, services, err := reflectServices(ctx, conn)
+		if err != nil {
+			return nil, fmt.Errorf("gRPC tools %s: %s: %w", path, source.Name, err)
+		}
+		sourceTools := source.tools(conn, files, services)
+		if len(sourceTools) == 0 {
+			return nil, fmt.Errorf("gRPC tools %s: %s: no unary method matches", path, source.Name)
+		}
+		tools = append(tools, sourceTools...)
+	}
+	return tools, nil
+}
+
+// grpcHost is the host:port of a gRPC target, without a resolver scheme such as dns:///
+func grpcHost(target string) string {
+	if i := strings.LastIndex(target, "/"); i >= 0 {
+		return target[i+1:]
+	}
+	return target
+}
+
+// reflectionClient asks a server's reflection service about its services
+type reflectionClient struct {
+	stream grpc.ClientStream
+}
+
+// ask sends one reflection request and waits for its answer
+func (r reflectionClient) ask(request *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	if err := r.stream.SendMsg(request); err != nil {
+		return nil, err
+	}
+	response := &reflectionpb.ServerReflectionResponse{}
+	if err := r.stream.RecvMsg(response); err != nil {
+		return nil, err
+	}
+	if failure := response.GetErrorResponse(); failure != nil {
+		return nil, fmt.Errorf("reflection: %s", failure.ErrorMessage)
+	}
+	return response, nil
+}
+
+// reflectServices lists the server's services and loads the descriptors they are
+// defined in, with everything those import
+func reflectServices(ctx context.Context, conn *grpc.ClientConn) (*protoregistry.Files, []protoreflect.ServiceDescriptor, error) {
+	ctx, cancel := context.WithTimeout(ctx, grpcReflectTimeout)
+	defer cancel()
+
+	var client reflectionClient
+	var listed *reflectionpb.ServerReflectionResponse
+	for _, method := range reflectionMethods {
+		stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, method)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to reach the server: %w", err)
+		}
+		client = reflectionClient{stream: stream}
+		listed, err = client.ask(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		})
+		if status.Code(err) == codes.Unimplemented {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list services: %w", err)
+		}
+		break
+	}
+	if listed == nil {
+		return nil, nil, fmt.Errorf("the server does not offer reflection; register it with reflection.Register")
+	}
+	defer client.stream.CloseSend()
+
+	// The server answers with the file defining a symbol and usually what it imports;
+	// missing imports are asked for by name
+	fetched := map[string]*descriptorpb.FileDescriptorProto{}
+	store := func(response *reflectionpb.ServerReflectionResponse) error {
+		for _, data := range response.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(data, file); err != nil {
+				return fmt.Errorf("invalid descriptor: %w", err)
+			}
+			fetched[file.GetName()] = file
+		}
+		return nil
+	}
+	names := []string{}
+	for _, service := range listed.GetListServicesResponse().GetService() {
+		names = append(names, service.Name)
+		response, err := client.ask(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service.Name},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to describe %s: %w", service.Name, err)
+		}
+		if err := store(response); err != nil {
+			return nil, nil, err
+		}
+	}
+	for missing := missingImports(fetched); len(missing) > 0; missing = missingImports(fetched) {
+		for _, name := range missing {
+			response, err := client.ask(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to fetch %s: %w", name, err)
+			}
+			if err := store(response); err != nil {
+				return nil, nil, err
+			}
+			if fetched[name] == nil {
+				return nil, nil, fmt.Errorf("the server did not send %s", name)
+			}
+		}
+	}
+
+	files := &protoregistry.Files{}
+	for name := range fetched {
+		if err := registerFile(files, fetched, name); err != nil {
+			return nil, nil, err
+		}
+	}
+	services := []protoreflect.ServiceDescriptor{}
+	for _, name := range names {
+		descriptor, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			continue // Servers list services they fail to describe, such as the reflection service itself
+		}
+		if service, ok := descriptor.(protoreflect.ServiceDescriptor); ok {
+			services = append(services, service)
+		}
+	}
+	return files, services, nil
+}
+
+// missingImports lists the files imported by fetched that neither the server sent nor
+// this binary knows
+func missingImports(fetched map[string]*descriptorpb.FileDescriptorProto) []string {
+	missing := []string{}
+	for _, file := range fetched {
+		for _, dependency := range file.GetDependency() {
+			if fetched[dependency] != nil {
+				continue
+			}
+			if _, err := protoregistry.GlobalFiles.FindFileByPath(dependency); err == nil {
+				continue
+			}
+			missing = append(missing, dependency)
+		}
+	}
+	return missing
+}
+
+// registerFile adds the named file to files after what it imports
+func registerFile(files *protoregistry.Files, fetched map[string]*descriptorpb.FileDescriptorProto, name string) error {
+	if _, err := files.FindFileByPath(name); err == nil {
+		return nil
+	}
+	file, ok := fetched[name]
+	if !ok {
+		global, err := protoregistry.GlobalFiles.FindFileByPath(name)
+		if err != nil {
+			return fmt.Errorf("missing descriptor %s", name)
+		}
+		return files.RegisterFile(global)
+	}
+	for _, dependency := range file.GetDependency() {
+		if err := registerFile(files, fetched, dependency); err != nil {
+			return err
+		}
+	}
+	descriptor, err := protodesc.NewFile(file, files)
+	if err != nil {
+		return fmt.Errorf("invalid descriptor %s: %w", name, err)
+	}
+	return files.RegisterFile(descriptor)
+}
+
+// tools turns the selected unary methods of services into tools. Tools are named after
+// the method, and after the service too where two services share a method name.
+func (s GRPCSource) tools(conn *grpc.ClientConn, files *protoregistry.Files, services []protoreflect.ServiceDescriptor) []ToolDefinition {
+	selected := []protoreflect.MethodDescriptor{}
+	counts := map[protoreflect.Name]int{}
+	for _, service := range services {
+		for i := 0; i < service.Methods().Len(); i++ {
+			method := service.Methods().Get(i)
+			name := grpcMethodName(method)
+			switch {
+			case method.IsStreamingClient() || method.IsStreamingServer():
+				continue
+			case len(s.Methods) == 0 && (strings.HasPrefix(name, "grpc.reflection.") || strings.HasPrefix(name, "grpc.health.")):
+				continue
+			case len(s.Methods) > 0 && !matchesMethod(s.Methods, name), matchesMethod(s.Exclude, name):
+				continue
+			}
+			selected = append(selected, method)
+			counts[method.Name()]++
+		}
+	}
+
+	types := dynamicpb.NewTypes(files)
+	tools := []ToolDefinition{}
+	for _, method := range selected {
+		id := string(method.Name())
+		if counts[method.Name()] > 1 {
+			id = string(method.Parent().Name()) + "_" + id
+		}
+		name := strings.Trim(toolNameInvalid.ReplaceAllString(s.Name+"_"+id, "_"), "_")
+		if len(name) > 64 {
+			name = name[:64]
+		}
+
+		description := protoComment(method)
+		description = strings.TrimSpace(fmt.Sprintf("%s\n\ngRPC method %s on %s.", truncateRunes(description, 1000), grpcMethodName(method), s.Name))
+
+		readOnly := matchesMethod(s.ReadOnly, grpcMethodName(method))
+		if options, ok := method.Options().(*descriptorpb.MethodOptions); ok && options.GetIdempotencyLevel() == descriptorpb.MethodOptions_NO_SIDE_EFFECTS {
+			readOnly = true
+		}
+
+		input := messageSchema(method.Input(), map[protoreflect.FullName]bool{})
+		properties, _ := input["properties"].(map[string]any)
+		tools = append(tools, ToolDefinition{
+			Name:        name,
+			Description: description,
+			InputSchema: anthropic.ToolInputSchemaParam{Properties: properties},
+			Mutating:    !readOnly,
+			Run: func(ctx context.Context, input json.RawMessage) (string, error) {
+				return s.call(ctx, conn, types, method, input)
+			},
+		})
+	}
+	return tools
+}
+
+// grpcMethodName is the package.Service/Method form that selectors match
+func grpcMethodName(method protoreflect.MethodDescriptor) string {
+	return string(method.Parent().FullName()) + "/" + string(method.Name())
+}
+
+// matchesMethod reports whether one of the globs matches the package.Service/Method name
+func matchesMethod(globs []string, name string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// protoComment returns the comment above a descriptor in its .proto file, when the
+// server kept source info
+func protoComment(descriptor protoreflect.Descriptor) string {
+	location := descriptor.ParentFile().SourceLocations().ByDescriptor(descriptor)
+	return strings.TrimSpace(location.LeadingComments)
+}
+
+// wellKnownSchemas are the JSON forms protojson gives well-known types
+var wellKnownSchemas = map[protoreflect.FullName]map[string]any{
+	"google.protobuf.Timestamp": {"type": "string", "format": "date-time"},
+	"google.protobuf.Duration":  {"type": "string", "description": "Duration in seconds with an s suffix, e.g. 1.5s"},
+	"google.protobuf.FieldMask": {"type": "string", "description": "Comma-separated field paths"},
+	"google.protobuf.Struct":    {"type": "object"},
+	"google.protobuf.Value":     {},
+	"google.protobuf.ListValue": {"type": "array"},
+	"google.protobuf.Any":       {"type": "object", "description": "Message with an @type URL naming its type"},
+	"google.protobuf.Empty":     {"type": "object"},
+}
+
+// messageSchema derives the JSON schema of a message in its protojson form. A message
+// met again inside itself becomes an empty schema that accepts anything.
+func messageSchema(message protoreflect.MessageDescriptor, expanding map[protoreflect.FullName]bool) map[string]any {
+	if schema, ok := wellKnownSchemas[message.FullName()]; ok {
+		return schema
+	}
+	if strings.HasPrefix(string(message.FullName()), "google.protobuf.") && strings.HasSuffix(string(message.Name()), "Value") {
+		// Wrapper types such as StringValue are written as the value they wrap
+		if value := message.Fields().ByName("value"); value != nil {
+			return kindSchema(value, expanding)
+		}
+	}
+	if expanding[message.FullName()] {
+		return map[string]any{}
+	}
+	expanding[message.FullName()] = true
+	defer delete(expanding, message.FullName())
+
+	properties := map[string]any{}
+	for i := 0; i < message.Fields().Len(); i++ {
+		field := message.Fields().Get(i)
+		schema := fieldSchema(field, expanding)
+		if comment := protoComment(field); comment != "" {
+			schema = withDescription(schema, comment)
+		}
+		properties[field.JSONName()] = schema
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// fieldSchema derives the schema of a field, including repeated and map fields
+func fieldSchema(field protoreflect.FieldDescriptor, expanding map[protoreflect.FullName]bool) map[string]any {
+	switch {
+	case field.IsMap():
+		return map[string]any{"type": "object", "additionalProperties": kindSchema(field.MapValue(), expanding)}
+	case field.IsList():
+		return map[string]any{"type": "array", "items": kindSchema(field, expanding)}
+	}
+	return kindSchema(field, expanding)
+}
+
+// kindSchema derives the schema of a single value of the field's type
+func kindSchema(field protoreflect.FieldDescriptor, expanding map[protoreflect.FullName]bool) map[string]any {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]any{"type": "boolean"}
+	case protoreflect.StringKind:
+		return map[string]any{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]any{"type": "string", "description": "Base64-encoded bytes"}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return map[string]any{"type": "number"}
+	case protoreflect.EnumKind:
+		values := []string{}
+		for i := 0; i < field.Enum().Values().Len(); i++ {
+			values = append(values, string(field.Enum().Values().Get(i).Name()))
+		}
+		return map[string]any{"type": "string", "enum": values}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageSchema(field.Message(), expanding)
+	}
+	return map[string]any{"type": "integer"}
+}
+
+// call invokes a unary method with the tool input and returns the response as JSON
+func (s GRPCSource) call(ctx context.Context, conn *grpc.ClientConn, types *dynamicpb.Types, method protoreflect.MethodDescriptor, input json.RawMessage) (string, error) {
+	if err := checkToolEgress(ctx, grpcHost(s.Address)); err != nil {
+		return "", err
+	}
+	request := dynamicpb.NewMessage(method.Input())
+	if err := (protojson.UnmarshalOptions{Resolver: types}).Unmarshal(input, request); err != nil {
+		return "", fmt.Errorf("invalid input format: %w", err)
+	}
+
+	headers := map[string]string{}
+	for key, value := range s.Metadata {
+		headers[key] = value
+	}
+	if err := s.Auth.apply(s.Name, headers, nil); err != nil {
+		return "", err
+	}
+	ctx = metadata.NewOutgoingContext(ctx, metadata.New(headers))
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(configInt("GRPC_TIMEOUT_SECONDS", 30))*time.Second)
+	defer cancel()
+
+	name := grpcMethodName(method)
+	response := dynamicpb.NewMessage(method.Output())
+	if err := conn.Invoke(ctx, "/"+name, request, response); err != nil {
+		failure := status.Convert(err)
+		return "", fmt.Errorf("%s: %s: %s", name, failure.Code(), failure.Message())
+	}
+	data, err := (protojson.MarshalOptions{Resolver: types, Multiline: true}).Marshal(response)
+	if err != nil {
+		return "", err
+	}
+	if text := strings.TrimSpace(string(data)); text != "{}" {
+		return text, nil
+	}
+	return "The call succeeded with an empty response.", nil
+}
+
+// runGRPCCommand implements `go-agent grpc`, listing the tools generated from the
+// --grpc file
+func runGRPCCommand(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("usage: go-agent --grpc FILE grpc")
+	}
+	if runOptions.GRPCFile == "" {
+		return fmt.Errorf("no gRPC tools are configured: pass --grpc FILE or set GRPC_TOOLS_FILE")
+	}
+	printGeneratedTools(runOptions.grpcTools, runOptions.GRPCFile)
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// =============================================================================
+// DESTRUCTIVE COMMAND GUARD
+// =============================================================================
+
+// destructiveCommand is a pattern of shell commands that can do damage no approval
+// setting should wave through, with what the damage is
+type destructiveCommand struct {
+	pattern *regexp.Regexp
+	reason  string
+}
+
+// commandStart matches where a command of a shell line begins, so patterns ignore words
+// in arguments such as `grep rm`
+const commandStart = `(^|[;&|(]\s*|\bsudo\s+|\bxargs\s+)`
+
+// destructiveCommands are checked against every command line a tool is about to run.
+// They catch the obvious cases only; anything subtler is left to the approval policy.
+var destructiveCommands = []destructiveCommand{
+	{regexp.MustCompile(commandStart + `rm\s+(-\S+\s+)*-[a-zA-Z]*[rR][a-zA-Z]*\s+(-\S+\s+)*("?(/|/\*|~|~/|~/\*|\$HOME|\$HOME/|\$HOME/\*|\.|\./|\./\*|\*|\.\.|\.\./)"?)(\s|$|[;&|)])`),
+		"recursively deletes the root, home or working directory"},
+	{regexp.MustCompile(commandStart + `rm\s+(-\S+\s+)*--no-preserve-root\b`), "deletes with --no-preserve-root"},
+	{regexp.MustCompile(`\bgit\s+(\S+\s+)*push\s+(.*\s)?(--force|-f|--mirror|--delete|-d)(\s|$)`), "force-pushes or deletes remote history"},
+	{regexp.MustCompile(`\bgit\s+(\S+\s+)*push\s+(.*\s)?\+\S+`), "force-pushes a ref"},
+	{regexp.MustCompile(`\bgit\s+(\S+\s+)*reset\s+(.*\s)?--hard\b`), "discards uncommitted changes"},
+	{regexp.MustCompile(`\bgit\s+(\S+\s+)*clean\s+(.*\s)?-[a-zA-Z]*f`), "deletes untracked files"},
+	{regexp.MustCompile(`\b(curl|wget|fetch)\b[^|;&]*\|\s*(sudo\s+)?(env\s+)?((ba|da|z|k|fi)?sh|python3?|perl|ruby|node)\b`), "pipes a download into a shell or interpreter"},
+	{regexp.MustCompile(`\b(ba|da|z)?sh\s+(-c\s+)?["']?\$\(\s*(curl|wget)\b`), "runs a downloaded script"},
+	{regexp.MustCompile(commandStart + `(mkfs(\.\w+)?|wipefs|shred)\b`), "erases a file system or disk"},
+	{regexp.MustCompile(`\bdd\s+(.*\s)?of=/dev/`), "writes directly to a device"},
+	{regexp.MustCompile(`>\s*/dev/(sd|nvme|hd|disk|mmcblk)`), "writes directly to a device"},
+	{regexp.MustCompile(commandStart + `(chmod|chown)\s+(-\S+\s+)*-[a-zA-Z]*R[a-zA-Z]*\s+(\S+\s+)?/(\s|$)`), "changes the permissions of the whole file system"},
+	{regexp.MustCompile(`:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`), "is a fork bomb"},
+	{regexp.MustCompile(`(?i)\bdrop\s+(database|schema|table)\b`), "drops a database, schema or table"},
+}
+
+// classifyCommand returns why a shell command line looks destructive, or "" when it
+// does not
+func classifyCommand(line string) string {
+	line = strings.Join(strings.Fields(line), " ")
+	for _, command := range destructiveCommands {
+		if command.pattern.MatchString(line) {
+			return command.reason
+		}
+	}
+	return ""
+}
+
+// commandHazard returns the command line a tool call would run and why it looks
+// destructive; the reason is "" for harmless commands and calls that run none
+func commandHazard(tool ToolDefinition, input json.RawMessage) (string, string) {
+	if tool.Command == nil {
+		return "", ""
+	}
+	line := tool.Command(input)
+	if line == "" {
+		return "", ""
+	}
+	return line, classifyCommand(line)
+}
+
+// guardCommand asks the user to confirm a destructive command by typing "yes", whatever
+// the approval policy says. In CI, or with no one to ask, the command is denied. The
+// decision is logged as a status event.
+func (a *Agent) guardCommand(tool ToolDefinition, line, hazard string) bool {
+	fmt.Fprintf(os.Stderr, "%s: %s wants to run `%s`, which %s\n", paint(roleWarning, "warning"), tool.Name, line, hazard)
+	allowed := false
+	decision := "denied in CI"
+	if !runOptions.CI {
+		allowed = a.ask(paint(roleWarning, "This command "+hazard+".")+" Type yes to run it anyway: ") == "yes"
+		decision = "denied by the user"
+		if allowed {
+			decision = "allowed by the user"
+		}
+	}
+	a.events.Emit(Event{Type: eventStatus, Tool: tool.Name, Text: fmt.Sprintf("destructive command %q (%s) %s", line, hazard, decision)})
+	return allowed
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// =============================================================================
+// SYNTAX HIGHLIGHTING
+// =============================================================================
+
+// codeTheme is the color of each kind of token in a code block
+type codeTheme struct {
+	keyword  string
+	str      string
+	comment  string
+	number   string
+	function string
+}
+
+// codeThemes are the CODE_THEME choices; "none" shows code in one color
+var codeThemes = map[string]*codeTheme{
+	"dark": {
+		keyword:  "\u001b[95m",
+		str:      "\u001b[92m",
+		comment:  "\u001b[90m",
+		number:   "\u001b[93m",
+		function: "\u001b[96m",
+	},
+	"light": {
+		keyword:  "\u001b[35m",
+		str:      "\u001b[32m",
+		comment:  "\u001b[2;3m",
+		number:   "\u001b[34m",
+		function: "\u001b[36m",
+	},
+	"none": nil,
+}
+
+// codeThemeNames lists the themes for messages
+func codeThemeNames() string {
+	names := []string{}
+	for name := range codeThemes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// selectedCodeTheme is the theme CODE_THEME names, or the one for the background of the
+// color theme. It is nil when code is not highlighted.
+func selectedCodeTheme() *codeTheme {
+	if !colorsEnabled() {
+		return nil
+	}
+	if theme, ok := codeThemes[configValue("CODE_THEME")]; ok {
+		return theme
+	}
+	theme()
+	return codeThemes[themeBackground]
+}
+
+// checkCodeTheme reports a CODE_THEME that names no theme
+func checkCodeTheme() error {
+	if _, ok := codeThemes[configValue("CODE_THEME")]; !ok && configValue("CODE_THEME") != "" {
+		return fmt.Errorf("unknown CODE_THEME %q (choose from %s)", configValue("CODE_THEME"), codeThemeNames())
+	}
+	return nil
+}
+
+// codeLanguage is what the highlighter knows of a language
+type codeLanguage struct {
+	keywords     []string
+	lineComments []string  // Starts of comments that run to the end of the line
+	blockComment [2]string // Start and end of comments that can span lines
+	quotes       string    // Characters that quote strings
+}
+
+// codeLanguages are the languages highlighted, by the names fenced code blocks use
+var codeLanguages = map[string]*codeLanguage{}
+
+func init() {
+	define := func(language *codeLanguage, names ...string) {
+		for _, name := range names {
+			codeLanguages[name] = language
+		}
+	}
+	define(&codeLanguage{
+		keywords: strings.Fields(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var nil true false iota`),
+		lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'`",
+	}, "go", "golang")
+	define(&codeLanguage{
+		keywords: strings.Fields(`and as assert async await break class continue def del elif else except finally
+			for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False self`),
+		lineComments: []string{"#"}, quotes: "\"'",
+	}, "python", "py")
+	define(&codeLanguage{
+		keywords: strings.Fields(`async await break case catch class const continue debugger default delete do else
+			export extends finally for function if import in instanceof let new of return static super switch this
+			throw try typeof var void while yield null undefined true false interface type enum implements readonly`),
+		lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'`",
+	}, "javascript", "js", "jsx", "typescript", "ts", "tsx")
+	define(&codeLanguage{
+		keywords: strings.Fields(`as async await break const continue crate dyn else enum extern false fn for if impl
+			in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while`),
+		lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"",
+	}, "rust", "rs")
+	define(&codeLanguage{
+		keywords: strings.Fields(`abstract boolean break byte case catch char class const continue default do double
+			else enum extends final finally float for if implements import instanceof int interface long new null
+			package private protected public return short static super switch this throw throws try void while true false
+			fun val var when object override`),
+		lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'",
+	}, "java", "kotlin", "kt")
+	define(&codeLanguage{
+		keywords: strings.Fields(`auto break case char class const continue default delete do double else enum extern
+			float for goto if inline int long namespace new nullptr private protected public return short signed sizeof
+			static struct switch template this typedef typename union unsigned using virtual void volatile while true false
+			#include #define #ifdef #ifndef #endif #if #else`),
+		lineComments: []string{"//"}, blockComment: [2]string{"/*", "*/"}, quotes: "\"'",
+	}, "c", "h", "cpp", "c++", "cc", "hpp")
+	define(&codeLanguage{
+		keywords: strings.Fields(`if then else elif fi for while until do done case esac in function return local
+			export readonly set unset shift exit echo cd`),
+		lineComments: []string{"#"}, quotes: "\"'",
+	}, "sh", "bash", "shell", "zsh", "console")
+	define(&codeLanguage{keywords: strings.Fields(`true false null`), quotes: "\""}, "json", "jsonc")
+	define(&codeLanguage{keywords: strings.Fields(`true false null yes no on off`), lineComments: []string{"#"}, quotes: "\"'"}, "yaml", "yml", "toml")
+	define(&codeLanguage{
+		keywords: strings.Fields(`select from where and or not insert into values update set delete create table drop
+			alter index join left right inner outer on group by order having limit as distinct null is in like primary key
+			SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER INDEX JOIN LEFT
+			RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT AS DISTINCT NULL IS IN LIKE PRIMARY KEY`),
+		lineComments: []string{"--"}, blockComment: [2]string{"/*", "*/"}, quotes: "'\"",
+	}, "sql")
+	define(&codeLanguage{
+		keywords: strings.Fields(`alias and begin break case class def defined do else elsif end ensure false for if
+			in module next nil not or redo rescue retry return self super then true undef unless until when while yield require`),
+		lineComments: []string{"#"}, quotes: "\"'",
+	}, "ruby", "rb")
+}
+
+// codeHighlighter colors the lines of one code block. It remembers block comments that
+// continue on the next line.
+type codeHighlighter struct {
+	theme     *codeTheme
+	language  *codeLanguage
+	inComment bool
+}
+
+// newCodeHighlighter returns a highlighter for the language a fence names, or nil when
+// the language is unknown or highlighting is off
+func newCodeHighlighter(name string) *codeHighlighter {
+	theme, language := selectedCodeTheme(), codeLanguages[strings.ToLower(name)]
+	if theme == nil || language == nil {
+		return nil
+	}
+	return &codeHighlighter{theme: theme, language: language}
+}
+
+// line colors one line of code
+func (h *codeHighlighter) line(line string) string {
+	var out strings.Builder
+	color := func(style, text string) {
+		out.WriteString(style + text + styleReset)
+	}
+	language := h.language
+	for i := 0; i < len(line); {
+		rest := line[i:]
+		if h.inComment {
+			end := strings.Index(rest, language.blockComment[1])
+			if end < 0 {
+				color(h.theme.comment, rest)
+				break
+			}
+			end += len(language.blockComment[1])
+			color(h.theme.comment, rest[:end])
+			h.inComment = false
+			i += end
+			continue
+		}
+		if slices.ContainsFunc(language.lineComments, func(prefix string) bool { return strings.HasPrefix(rest, prefix) }) {
+			color(h.theme.comment, rest)
+			break
+		}
+		if language.blockComment[0] != "" && strings.HasPrefix(rest, language.blockComment[0]) {
+			h.inComment = true
+			color(h.theme.comment, language.blockComment[0])
+			i += len(language.blockComment[0])
+			continue
+		}
+
+		c := line[i]
+		switch {
+		case strings.IndexByte(language.quotes, c) >= 0:
+			end := i + 1
+			for end < len(line) && line[end] != c {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(line))
+			color(h.theme.str, line[i:end])
+			i = end
+		case c >= '0' && c <= '9' && (i == 0 || !isWordByte(line[i-1])):
+			end := i
+			for end < len(line) && (isWordByte(line[end]) || line[end] == '.') {
+				end++
+			}
+			color(h.theme.number, line[i:end])
+			i = end
+		case isWordByte(c) || c == '#':
+			end := i + 1
+			for end < len(line) && isWordByte(line[end]) {
+				end++
+			}
+			word := line[i:end]
+			switch {
+			case slices.Contains(language.keywords, word):
+				color(h.theme.keyword, word)
+			case end < len(line) && line[end] == '(' && c != '#':
+				color(h.theme.function, word)
+			default:
+				out.WriteString(word)
+			}
+			i = end
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// isWordByte reports whether c can be part of an identifier
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
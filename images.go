@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// IMAGE ATTACHMENTS
+// =============================================================================
+
+// maxImageBytes is the largest image the API accepts
+const maxImageBytes = 5 * 1024 * 1024
+
+// imageExtensions are the files attached as images rather than as text
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// imageMediaTypes are the formats the API reads, as sniffed from the file's contents
+var imageMediaTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+var (
+	// imageMention matches @image:PATH, where PATH may be quoted or have escaped spaces
+	imageMention = regexp.MustCompile(`@image:("[^"]+"|'[^']+'|(?:\\.|[^\s"'])+)`)
+
+	// imagePath matches a path to an image file in the prompt, the way a terminal pastes a
+	// dragged-and-dropped file: bare, quoted, with escaped spaces or as a file:// URL
+	imagePath = regexp.MustCompile(`(?i)("[^"]+\.(?:png|jpe?g|gif|webp)"|'[^']+\.(?:png|jpe?g|gif|webp)'|(?:\\.|[^\s"'])+\.(?:png|jpe?g|gif|webp))`)
+
+	// escapedChar matches a backslash-escaped character of a pasted path
+	escapedChar = regexp.MustCompile(`\\(.)`)
+)
+
+// ImageAttachment is an image the user attached to a message
+type ImageAttachment struct {
+	Path      string // Path as written in the prompt, without quotes or escapes
+	MediaType string // One of imageMediaTypes
+	Data      []byte // The image file
+}
+
+// isImagePath reports whether path names an image by its extension
+func isImagePath(path string) bool {
+	return slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// unquotePath undoes the quoting and escaping a terminal adds to a pasted path
+func unquotePath(path string) string {
+	if len(path) >= 2 && (path[0] == '"' || path[0] == '\'') && path[len(path)-1] == path[0] {
+		path = path[1 : len(path)-1]
+	} else {
+		path = escapedChar.ReplaceAllString(strings.TrimRight(path, ",.;:!?)"), "$1")
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(path, "@"), "file://")
+}
+
+// parseImageMentions finds the images of a prompt: every @image:PATH, and every path to an
+// existing image file, such as one dragged into the terminal. Images named with @image:
+// that cannot be attached are reported; other paths that do not work out are left as text.
+func parseImageMentions(input string) []*ImageAttachment {
+	images := []*ImageAttachment{}
+	seen := map[string]bool{}
+
+	add := func(path string, explicit bool) {
+		if path == "" || seen[path] {
+			return
+		}
+		seen[path] = true
+		image, err := readImage(path)
+		if err != nil {
+			if explicit || !errors.Is(err, fs.ErrNotExist) {
+				var pathErr *fs.PathError
+				if errors.As(err, &pathErr) {
+					err = pathErr.Err
+				}
+				fmt.Fprintf(os.Stderr, "%s: failed to attach image %s (%s)\n", paint(roleNotice, "attachments"), path, err.Error())
+			}
+			return
+		}
+		images = append(images, image)
+	}
+
+	for _, match := range imageMention.FindAllStringSubmatch(input, -1) {
+		add(unquotePath(match[1]), true)
+	}
+	for _, match := range imagePath.FindAllString(input, -1) {
+		if !strings.Contains(match, "@image:") {
+			add(unquotePath(match), false)
+		}
+	}
+
+	return images
+}
+
+// readImage reads an image file, checking that the API can take it
+func readImage(path string) (*ImageAttachment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a file")
+	}
+	if info.Size() > maxImageBytes {
+		return nil, fmt.Errorf("%d KB, over the %d MB limit for images", info.Size()/1024, maxImageBytes/1024/1024)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mediaType := http.DetectContentType(data)
+	if !slices.Contains(imageMediaTypes, mediaType) {
+		return nil, errors.New("not a PNG, JPEG, GIF or WebP image")
+	}
+	return &ImageAttachment{Path: path, MediaType: mediaType, Data: data}, nil
+}
+
+// imageBlock sends an image inline, base64 encoded
+func imageBlock(image *ImageAttachment) anthropic.ContentBlockParamUnion {
+	return anthropic.NewImageBlockBase64(image.MediaType, base64.StdEncoding.EncodeToString(image.Data))
+}
+
+// imageTokens is about what an image costs once the API has scaled it to fit
+const imageTokens = 1600
+
+// imageOvercount is how much estimateTokens overcounts the inline images of messages, as
+// it sizes them by their base64 data rather than their pixels
+func imageOvercount(messages []anthropic.MessageParam) int {
+	overcount := 0
+	count := func(image *anthropic.ImageBlockParam) {
+		if image != nil && image.Source.OfBase64 != nil {
+			overcount += max(0, estimateTokens(image.Source.OfBase64.Data)-imageTokens)
+		}
+	}
+	for _, message := range messages {
+		for _, block := range message.Content {
+			count(block.OfImage)
+			if block.OfToolResult != nil {
+				for _, content := range block.OfToolResult.Content {
+					count(content.OfImage)
+				}
+			}
+		}
+	}
+	return overcount
+}
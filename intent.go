@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// INTENT ROUTING
+// =============================================================================
+
+// Intents the classifier chooses between
+const (
+	intentTrivial  = "trivial"  // Answerable without the repository; the cheap model replies
+	intentQuestion = "question" // About the code; Claude answers without the mutating tools
+	intentEdit     = "edit"     // A task that changes files; Claude gets every tool
+	intentCommand  = "command"  // A shell command to run, which the model cannot do
+)
+
+// intentPrompt asks the cheap model to classify the user's input
+const intentPrompt = `Classify the message a user sent to a coding agent that works in their repository. Reply with a JSON object only:
+{"intent": "trivial" | "question" | "edit" | "command", "answer": "..."}
+
+- trivial: greetings, thanks, or general questions that need no knowledge of the repository, such as what a Go keyword does. Put a short, complete answer in "answer".
+- question: asks about the repository's code, behavior or structure without asking for changes.
+- edit: asks for changes to files, such as fixing, adding, refactoring or writing code. When unsure, choose edit.
+- command: just a shell command line to run, such as "git status" or "go test ./...". Put the command in "answer".
+
+<message>
+%s
+</message>`
+
+// intentCommandReply explains that shell commands are not run by the model
+const intentCommandReply = "That looks like a shell command. Claude does not run shell commands; run it in your terminal (in the chat, type `!%s`), or rephrase it as a task."
+
+// Intent is the classifier's verdict on one message
+type Intent struct {
+	Intent string `json:"intent"`
+	Answer string `json:"answer"`
+}
+
+// classifyIntent asks the cheap model what kind of message input is
+func (a *Agent) classifyIntent(ctx context.Context, input string) (Intent, error) {
+	model := configValue("INTENT_MODEL")
+	if model == "" {
+		model = string(anthropic.ModelClaude3_5HaikuLatest)
+	}
+
+	message, err := a.client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     anthropic.Model(model),
+		MaxTokens: int64(512),
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(fmt.Sprintf(intentPrompt, input))),
+		},
+	})
+	if err != nil {
+		return Intent{}, err
+	}
+
+	var reply strings.Builder
+	for _, content := range message.Content {
+		if content.Type == "text" {
+			reply.WriteString(content.Text)
+		}
+	}
+
+	intent := Intent{}
+	text := strings.TrimSpace(reply.String())
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+	if err := json.Unmarshal([]byte(text), &intent); err != nil {
+		return Intent{}, fmt.Errorf("unreadable classification %q: %w", truncateRunes(reply.String(), 200), err)
+	}
+	return intent, nil
+}
+
+// respond answers the user message that ends the conversation. With INTENT_ROUTING=true,
+// a cheap model first classifies input so trivial messages skip the main model and
+// questions are answered without the tools that change files. Without it, or when
+// classification fails, Claude handles the message as usual.
+func (a *Agent) respond(ctx context.Context, conversation []anthropic.MessageParam, input string) ([]anthropic.MessageParam, error) {
+	if configValue("INTENT_ROUTING") != "true" {
+		return a.runTurn(ctx, conversation)
+	}
+
+	intent, err := a.classifyIntent(ctx, input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: classification failed, asking Claude: %s\n", paint(roleWarning, "intent"), err.Error())
+		return a.runTurn(ctx, conversation)
+	}
+	a.events.Emit(Event{Type: eventStatus, Text: "intent: " + intent.Intent})
+
+	reply := ""
+	switch intent.Intent {
+	case intentTrivial:
+		reply = strings.TrimSpace(intent.Answer)
+	case intentCommand:
+		if command := strings.TrimSpace(intent.Answer); command != "" {
+			reply = fmt.Sprintf(intentCommandReply, command)
+		}
+	case intentQuestion:
+		// Questions get the read-only tools for this one turn
+		tools := a.tools
+		a.tools = []ToolDefinition{}
+		for _, tool := range tools {
+			if !tool.Mutating {
+				a.tools = append(a.tools, tool)
+			}
+		}
+		defer func() { a.tools = tools }()
+	}
+	if reply == "" {
+		return a.runTurn(ctx, conversation)
+	}
+
+	// Shortcut replies join the conversation so Claude sees them on later turns
+	printAnswer(reply)
+	a.events.Emit(Event{Type: eventAssistantText, Text: reply})
+	return append(conversation, anthropic.NewAssistantMessage(anthropic.NewTextBlock(reply))), nil
+}
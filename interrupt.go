@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// INTERRUPTS
+// =============================================================================
+
+// interruptGrace is how soon a second Ctrl-C must follow the first to exit the chat
+const interruptGrace = 2 * time.Second
+
+// interruptedNote tells Claude why the turn before the next message has no answer
+const interruptedNote = "[The user pressed Ctrl-C and interrupted this turn before it finished.]"
+
+// interruptHandler decides what Ctrl-C does. In the chat, the first press cancels the
+// turn in progress and returns to the prompt, keeping the conversation; a second press
+// within interruptGrace exits. Everywhere else Ctrl-C exits at once.
+type interruptHandler struct {
+	mu     sync.Mutex
+	chat   bool
+	cancel context.CancelFunc // Cancels the turn in progress; nil at the prompt
+	last   time.Time          // When Ctrl-C was last pressed
+}
+
+// enableChat makes Ctrl-C cancel turns instead of exiting
+func (h *interruptHandler) enableChat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.chat = true
+}
+
+// startTurn returns the context of a turn, canceled by Ctrl-C. done ends the turn and
+// reports whether it was interrupted.
+func (h *interruptHandler) startTurn(ctx context.Context) (context.Context, func() bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	h.mu.Lock()
+	h.cancel = cancel
+	h.mu.Unlock()
+	return ctx, func() bool {
+		interrupted := ctx.Err() != nil
+		h.mu.Lock()
+		h.cancel = nil
+		h.mu.Unlock()
+		cancel()
+		return interrupted
+	}
+}
+
+// inTurn reports whether a turn is in progress, so Ctrl-C would cancel it
+func (h *interruptHandler) inTurn() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cancel != nil
+}
+
+// interrupt handles a Ctrl-C and reports whether the process should exit
+func (h *interruptHandler) interrupt() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.chat {
+		return true
+	}
+	now := time.Now()
+	repeated := now.Sub(h.last) < interruptGrace
+	h.last = now
+	switch {
+	case repeated:
+		return true
+	case h.cancel != nil:
+		h.cancel()
+		h.cancel = nil
+		fmt.Fprintf(os.Stderr, "\n%s: stopping this turn (press Ctrl-C again to exit)\n", paint(roleNotice, "interrupted"))
+	default:
+		fmt.Fprintf(os.Stderr, "\n(press Ctrl-C again to exit, or Ctrl-D)\n")
+		prompt(paint(roleUser, "You") + ": ")
+	}
+	return false
+}
+
+// markInterrupted notes in the conversation that its last turn was cut short. What the
+// turn got done, such as tool results, is kept.
+func markInterrupted(conversation []anthropic.MessageParam) []anthropic.MessageParam {
+	if len(conversation) == 0 || conversation[len(conversation)-1].Role != anthropic.MessageParamRoleUser {
+		return conversation
+	}
+	last := &conversation[len(conversation)-1]
+	last.Content = append(last.Content, anthropic.NewTextBlock(interruptedNote))
+	return conversation
+}
+
+// appendUserMessage adds a user message to the conversation, merging it into the last
+// one when an interrupted turn left a user message without an answer
+func appendUserMessage(conversation []anthropic.MessageParam, message anthropic.MessageParam) []anthropic.MessageParam {
+	if len(conversation) == 0 || conversation[len(conversation)-1].Role != anthropic.MessageParamRoleUser {
+		return append(conversation, message)
+	}
+	last := &conversation[len(conversation)-1]
+	last.Content = append(last.Content, message.Content...)
+	return conversation
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// =============================================================================
+// IRC BRIDGE
+// =============================================================================
+
+// ircMaxLineBytes keeps PRIVMSG lines under the 512-byte IRC limit, leaving room for the prefix
+const ircMaxLineBytes = 400
+
+// ircLineDelay spaces out multi-line replies to stay under server flood limits
+const ircLineDelay = 500 * time.Millisecond
+
+// IRCBridge joins channels on an IRC server and answers messages addressed to its nick
+type IRCBridge struct {
+	addr     string
+	tls      bool
+	nick     string
+	password string
+	channels []string
+	prefix   string
+
+	mu   sync.Mutex // Serializes writes to conn
+	conn net.Conn
+}
+
+// NewIRCBridge configures the bridge from IRC_ADDR, IRC_NICK, IRC_CHANNELS and IRC_PASSWORD
+func NewIRCBridge(prefix string) (ChatBridge, error) {
+	bridge := &IRCBridge{
+		addr:     configValue("IRC_ADDR"),
+		tls:      configValue("IRC_TLS") != "false",
+		nick:     configValue("IRC_NICK"),
+		password: configValue("IRC_PASSWORD"),
+		channels: splitList(configValue("IRC_CHANNELS")),
+		prefix:   prefix,
+	}
+	if bridge.addr == "" || bridge.nick == "" {
+		return nil, fmt.Errorf("IRC_ADDR (host:port) and IRC_NICK are required")
+	}
+	return bridge, nil
+}
+
+// Name implements ChatBridge
+func (b *IRCBridge) Name() string { return "irc " + b.addr }
+
+// Run implements ChatBridge
+func (b *IRCBridge) Run(ctx context.Context, handle func(ChatMessage)) error {
+	var conn net.Conn
+	var err error
+	if err := checkEgress(b.addr); err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if b.tls {
+		host, _, _ := net.SplitHostPort(b.addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", b.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	b.conn = conn
+
+	if b.password != "" {
+		b.write("PASS " + b.password)
+	}
+	b.write("NICK " + b.nick)
+	b.write("USER " + b.nick + " 0 * :go-agent")
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		prefix, command, params := parseIRCLine(scanner.Text())
+		switch command {
+		case "PING":
+			b.write("PONG :" + strings.Join(params, " "))
+		case "001": // Registered
+			for _, channel := range b.channels {
+				b.write("JOIN " + channel)
+			}
+			fmt.Fprintf(os.Stderr, "irc: connected as %s\n", b.nick)
+		case "433": // Nick in use
+			return fmt.Errorf("nick %s is already in use", b.nick)
+		case "PRIVMSG":
+			if len(params) < 2 {
+				continue
+			}
+			sender, _, _ := strings.Cut(prefix, "!")
+			target, text := params[0], params[1]
+
+			message := ChatMessage{Room: target, Sender: sender}
+			if strings.EqualFold(target, b.nick) {
+				// Direct messages are always for the agent; reply to the sender
+				message.Room = sender
+				message.Text, _ = addressedText(text, b.prefix, b.nick)
+				message.Addressed = true
+			} else {
+				message.Text, message.Addressed = addressedText(text, b.prefix, b.nick)
+			}
+			handle(message)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("connection to %s closed", b.addr)
+}
+
+// Send implements ChatBridge, sending one PRIVMSG per line
+func (b *IRCBridge) Send(ctx context.Context, room, text string) error {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		for _, chunk := range splitBytes(line, ircMaxLineBytes) {
+			if err := b.write("PRIVMSG " + room + " :" + chunk); err != nil {
+				return err
+			}
+			time.Sleep(ircLineDelay)
+		}
+	}
+	return nil
+}
+
+// write sends one raw protocol line
+func (b *IRCBridge) write(line string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	_, err := fmt.Fprintf(b.conn, "%s\r\n", strings.NewReplacer("\r", "", "\n", " ").Replace(line))
+	return err
+}
+
+// parseIRCLine splits a protocol line into its prefix, command and parameters
+func parseIRCLine(line string) (prefix, command string, params []string) {
+	if rest, ok := strings.CutPrefix(line, ":"); ok {
+		prefix, line, _ = strings.Cut(rest, " ")
+	}
+	line, trailing, hasTrailing := strings.Cut(line, " :")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return prefix, "", nil
+	}
+	params = fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return prefix, strings.ToUpper(fields[0]), params
+}
+
+// splitBytes cuts text into pieces of at most limit bytes without splitting UTF-8 sequences
+func splitBytes(text string, limit int) []string {
+	pieces := []string{}
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		pieces = append(pieces, text[:cut])
+		text = text[cut:]
+	}
+	return append(pieces, text)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// HOME DIRECTORY LAYOUT
+// =============================================================================
+
+// go-agent keeps per-user files in three directories:
+//
+//	config  settings the user chose, such as telemetry consent
+//	state   what runs accumulate and must not lose: saved sessions, the spend ledger, input history, tool stats, remembered approvals
+//	cache   what can be rebuilt: fetched prices, upload IDs, scratch files of runs
+//
+// They are ~/.go-agent/{config,state,cache}, or $GO_AGENT_HOME/{config,state,cache}.
+// XDG_CONFIG_HOME, XDG_STATE_HOME and XDG_CACHE_HOME move each one to a go-agent
+// directory under them.
+
+// layoutVersion is the version of the layout this build reads and writes. A change to
+// where or how files are stored increments it and adds a migration.
+const layoutVersion = 1
+
+// layoutMigrations upgrade the layout one version at a time: the migration at index i
+// turns version i into version i+1. Version 0 is the single directory of releases
+// before the layout, os.UserConfigDir()/go-agent.
+var layoutMigrations = []struct {
+	description string
+	migrate     func() error
+}{
+	{"move state and caches out of the config directory", migrateToSplitDirs},
+}
+
+// layoutLockTimeout is how long a run waits for another run's migration, and when a
+// lock is considered left behind by a crash
+const layoutLockTimeout = 30 * time.Second
+
+// configDir holds the user's settings
+func configDir() string {
+	return layoutDir("XDG_CONFIG_HOME", "config")
+}
+
+// stateDir holds data that runs accumulate
+func stateDir() string {
+	return layoutDir("XDG_STATE_HOME", "state")
+}
+
+// cacheDir holds data that can be fetched or rebuilt again
+func cacheDir() string {
+	return layoutDir("XDG_CACHE_HOME", "cache")
+}
+
+// layoutDir resolves one of the three directories. GO_AGENT_HOME is not read from
+// config.env, so a repository cannot point go-agent at files it planted.
+func layoutDir(xdg, name string) string {
+	if home := trustedConfigValue("GO_AGENT_HOME"); home != "" {
+		return filepath.Join(home, name)
+	}
+	if dir := os.Getenv(xdg); dir != "" {
+		return filepath.Join(dir, "go-agent")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "go-agent", name)
+	}
+	return filepath.Join(home, ".go-agent", name)
+}
+
+// layoutVersionPath records the version of the layout on disk
+func layoutVersionPath() string {
+	return filepath.Join(stateDir(), "layout.json")
+}
+
+// migrateLayout brings the files on disk up to layoutVersion. It runs before anything
+// reads them; a failed migration is a warning and is tried again on the next run.
+func migrateLayout() {
+	version := readLayoutVersion()
+	if version == layoutVersion {
+		return
+	}
+	if version > layoutVersion {
+		fmt.Fprintf(os.Stderr, "%s: %s was written by a newer go-agent (layout version %d, this one reads %d); some state may not be read\n",
+			paint(roleWarning, "warning"), stateDir(), version, layoutVersion)
+		return
+	}
+
+	unlock, err := lockLayout()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to migrate %s: %s\n", paint(roleWarning, "warning"), stateDir(), err.Error())
+		return
+	}
+	defer unlock()
+
+	// Another run may have migrated while this one waited for the lock
+	for version = readLayoutVersion(); version < layoutVersion; version++ {
+		migration := layoutMigrations[version]
+		if err := migration.migrate(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to %s: %s\n", paint(roleWarning, "warning"), migration.description, err.Error())
+			return
+		}
+		if err := writeLayoutVersion(version + 1); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to record the layout version: %s\n", paint(roleWarning, "warning"), err.Error())
+			return
+		}
+	}
+}
+
+// readLayoutVersion returns the version of the layout on disk, 0 before there was one
+func readLayoutVersion() int {
+	data, err := os.ReadFile(layoutVersionPath())
+	if err != nil {
+		return 0
+	}
+	var layout struct {
+		Version int `json:"version"`
+	}
+	json.Unmarshal(data, &layout)
+	return layout.Version
+}
+
+// writeLayoutVersion records version, replacing the file in one step
+func writeLayoutVersion(version int) error {
+	path := layoutVersionPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, _ := json.Marshal(map[string]int{"version": version})
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// lockLayout keeps concurrent runs from migrating at the same time. It waits for a
+// migration in progress and breaks locks older than layoutLockTimeout.
+func lockLayout() (func(), error) {
+	path := filepath.Join(stateDir(), "layout.lock")
+	if err := os.MkdirAll(stateDir(), 0o700); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(layoutLockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > layoutLockTimeout {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("another go-agent holds %s", path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// migrateToSplitDirs moves the files of the single directory of earlier releases to
+// the config, state and cache directories
+func migrateToSplitDirs() error {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil
+	}
+	legacy := filepath.Join(dir, "go-agent")
+	moves := map[string]string{
+		"telemetry.json": configDir(),
+		"spend.json":     stateDir(),
+		"sessions":       stateDir(),
+		"uploads.json":   cacheDir(),
+		"prices.json":    cacheDir(),
+		"tmp":            cacheDir(),
+	}
+	for name, target := range moves {
+		if err := moveState(filepath.Join(legacy, name), filepath.Join(target, name)); err != nil {
+			return err
+		}
+	}
+	os.Remove(legacy) // Only once it is empty
+	return nil
+}
+
+// moveState moves a file or directory, copying it when from and to are on different
+// file systems. What is already at to is kept, and from is then left alone.
+func moveState(from, to string) error {
+	if from == to {
+		return nil
+	}
+	if _, err := os.Lstat(from); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o700); err != nil {
+		return err
+	}
+	if os.Rename(from, to) == nil {
+		return nil
+	}
+
+	// Rename fails across file systems, so copy and remove the original
+	err := filepath.WalkDir(from, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, strings.TrimPrefix(path, from))
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0o700)
+		}
+		return copyFile(path, target)
+	})
+	if err != nil {
+		os.RemoveAll(to)
+		return err
+	}
+	return os.RemoveAll(from)
+}
+
+// copyFile copies the file at from to to, which must not exist yet
+func copyFile(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// legacyLayout creates the single directory of releases before the layout, with files,
+// and points GO_AGENT_HOME at an empty directory. It returns the legacy directory.
+func legacyLayout(t *testing.T, files map[string]string) string {
+	t.Helper()
+	t.Setenv("GO_AGENT_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	legacy := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "go-agent")
+	for name, content := range files {
+		path := filepath.Join(legacy, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return legacy
+}
+
+// readFile returns the content of path, or "" when it does not exist
+func readFile(path string) string {
+	data, _ := os.ReadFile(path)
+	return string(data)
+}
+
+func TestMigrateLayoutFromVersion0(t *testing.T) {
+	legacy := legacyLayout(t, map[string]string{
+		"telemetry.json":        "consent",
+		"spend.json":            "ledger",
+		"sessions/abc.json":     "session",
+		"prices.json":           "prices",
+		"tmp/run-1/scratch.txt": "scratch",
+	})
+	migrateLayout()
+
+	moved := map[string]string{
+		filepath.Join(configDir(), "telemetry.json"):       "consent",
+		filepath.Join(stateDir(), "spend.json"):            "ledger",
+		filepath.Join(stateDir(), "sessions", "abc.json"):  "session",
+		filepath.Join(cacheDir(), "prices.json"):           "prices",
+		filepath.Join(cacheDir(), "tmp/run-1/scratch.txt"): "scratch",
+	}
+	for path, content := range moved {
+		if got := readFile(path); got != content {
+			t.Errorf("%s has %q, want %q", path, got, content)
+		}
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("the emptied legacy directory is still there: %v", err)
+	}
+	if version := readLayoutVersion(); version != layoutVersion {
+		t.Errorf("layout version %d after the migration, want %d", version, layoutVersion)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir(), "layout.lock")); !os.IsNotExist(err) {
+		t.Errorf("the migration left its lock behind: %v", err)
+	}
+}
+
+func TestMigrateLayoutKeepsNewerFiles(t *testing.T) {
+	legacy := legacyLayout(t, map[string]string{"spend.json": "old ledger", "prices.json": "old prices"})
+	os.MkdirAll(stateDir(), 0o700)
+	os.WriteFile(filepath.Join(stateDir(), "spend.json"), []byte("new ledger"), 0o600)
+	migrateLayout()
+
+	if got := readFile(filepath.Join(stateDir(), "spend.json")); got != "new ledger" {
+		t.Errorf("the migration replaced the ledger with %q", got)
+	}
+	if got := readFile(filepath.Join(legacy, "spend.json")); got != "old ledger" {
+		t.Errorf("the legacy ledger that was not moved has %q", got)
+	}
+	if got := readFile(filepath.Join(cacheDir(), "prices.json")); got != "old prices" {
+		t.Errorf("prices.json has %q after the migration", got)
+	}
+}
+
+func TestMigrateLayoutLeavesCurrentAndNewerVersions(t *testing.T) {
+	for _, version := range []int{layoutVersion, layoutVersion + 1} {
+		legacy := legacyLayout(t, map[string]string{"spend.json": "ledger"})
+		if err := writeLayoutVersion(version); err != nil {
+			t.Fatal(err)
+		}
+		migrateLayout()
+
+		if got := readFile(filepath.Join(legacy, "spend.json")); got != "ledger" {
+			t.Errorf("layout version %d was migrated: the legacy ledger has %q", version, got)
+		}
+		if got := readLayoutVersion(); got != version {
+			t.Errorf("layout version %d after migrating version %d", got, version)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// =============================================================================
+// LINE EDITING
+// =============================================================================
+
+// defaultHistorySize is how many lines HISTORY_SIZE keeps in the history file
+const defaultHistorySize = 1000
+
+// Keys the line editor handles, as the bytes a terminal sends for them
+const (
+	keyCtrlA     = "\x01"
+	keyCtrlB     = "\x02"
+	keyCtrlC     = "\x03"
+	keyCtrlD     = "\x04"
+	keyCtrlE     = "\x05"
+	keyCtrlF     = "\x06"
+	keyCtrlG     = "\x07"
+	keyCtrlH     = "\x08"
+	keyCtrlK     = "\x0b"
+	keyCtrlL     = "\x0c"
+	keyEnter     = "\r"
+	keyNewline   = "\n"
+	keyCtrlN     = "\x0e"
+	keyCtrlP     = "\x10"
+	keyCtrlR     = "\x12"
+	keyCtrlU     = "\x15"
+	keyCtrlW     = "\x17"
+	keyBackspace = "\x7f"
+	keyEsc       = "\x1b"
+	keyAltB      = "\x1bb"
+	keyAltF      = "\x1bf"
+	keyDelete    = "\x1b[3~"
+)
+
+// Keys that terminals send in more than one way
+var (
+	keysUp    = []string{"\x1b[A", "\x1bOA", keyCtrlP}
+	keysDown  = []string{"\x1b[B", "\x1bOB", keyCtrlN}
+	keysRight = []string{"\x1b[C", "\x1bOC", keyCtrlF}
+	keysLeft  = []string{"\x1b[D", "\x1bOD", keyCtrlB}
+	keysHome  = []string{"\x1b[H", "\x1bOH", "\x1b[1~", "\x1b[7~", keyCtrlA}
+	keysEnd   = []string{"\x1b[F", "\x1bOF", "\x1b[4~", "\x1b[8~", keyCtrlE}
+)
+
+// lineEditor reads the chat's input from a terminal with line editing: the arrow keys
+// move and recall earlier lines, Ctrl-A and Ctrl-E jump to the start and end, and Ctrl-R
+// searches the history backwards. Lines are kept in a history file in the state
+// directory, so the history carries over to later sessions.
+type lineEditor struct {
+	in      *os.File
+	out     *os.File
+	reader  *bufio.Reader
+	saved   *term.State // The terminal's settings while a line is read in raw mode
+	prompt  string      // Printed before the line, set by prompt()
+	history []string    // Oldest first
+	path    string      // History file; "" keeps the history for this session only
+	closed  bool        // Set once Ctrl-C asked to exit; later reads return nothing
+
+	keys     chan string // Key presses, read from in by one goroutine for every reader
+	keysOnce sync.Once
+	typing   *typeahead // Reads what is typed while a turn runs; nil at the prompt
+	draft    []rune     // A line typed ahead but not sent, which the next prompt starts with
+}
+
+// historyPath is where the chat's input history is kept
+func historyPath() string {
+	return filepath.Join(stateDir(), "history")
+}
+
+// newLineEditor creates a line editor reading in and echoing to out, or returns nil when
+// either is not a terminal
+func newLineEditor(in *os.File, out io.Writer) *lineEditor {
+	file, ok := out.(*os.File)
+	if !ok || !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(file.Fd())) {
+		return nil
+	}
+	editor := &lineEditor{in: in, out: file, reader: bufio.NewReader(in)}
+	if size := configInt("HISTORY_SIZE", defaultHistorySize); size > 0 {
+		editor.path = historyPath()
+		editor.history = loadHistory(editor.path, size)
+	}
+	return editor
+}
+
+// loadHistory reads the last size lines of a history file, trimming the file to them
+func loadHistory(path string, size int) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) > size {
+		lines = lines[len(lines)-size:]
+		os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	}
+	return lines
+}
+
+// setPrompt notes the prompt the next line is read after
+func (e *lineEditor) setPrompt(text string) {
+	if e == nil {
+		return
+	}
+	e.prompt = text
+}
+
+// restoreTerminal leaves raw mode, if a line is being read
+func (e *lineEditor) restoreTerminal() {
+	if e == nil || e.saved == nil {
+		return
+	}
+	term.Restore(int(e.in.Fd()), e.saved)
+	e.saved = nil
+}
+
+// remember adds a line to the history. Blank lines, lines starting with a space and
+// repeats of the previous line are left out.
+func (e *lineEditor) remember(line string) {
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(line, " ") || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.path == "" {
+		return
+	}
+	// Appending keeps the lines of chats running at the same time
+	if err := os.MkdirAll(filepath.Dir(e.path), 0o700); err != nil {
+		return
+	}
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+// editState is the line being edited
+type editState struct {
+	line   []rune
+	pos    int    // Cursor position in line
+	offset int    // First rune shown when the line is wider than the terminal
+	index  int    // Position in the history; len(history) is the line being written
+	draft  []rune // The line being written, kept while browsing the history
+}
+
+// readLine reads one line, returning false at Ctrl-D on an empty line, at the end of
+// input, or when Ctrl-C asks to exit
+func (e *lineEditor) readLine() (string, bool) {
+	if e.closed {
+		return "", false
+	}
+	saved, err := term.MakeRaw(int(e.in.Fd()))
+	if err != nil {
+		line, err := e.reader.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err == nil || line != ""
+	}
+	e.saved = saved
+	defer e.restoreTerminal()
+
+	// A question asked during a turn, such as an approval, takes the keys from typing ahead
+	if e.typing != nil {
+		e.typing.pause()
+		defer e.typing.resume()
+	}
+
+	s := &editState{index: len(e.history)}
+	if len(e.draft) > 0 {
+		s.line, s.pos, e.draft = e.draft, len(e.draft), nil
+		e.refresh(s)
+	}
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			e.write("\r\n")
+			return "", false
+		}
+		if key == keyCtrlR {
+			key = e.search(s)
+		}
+
+		switch {
+		case key == keyEnter || key == keyNewline:
+			e.refresh(s)
+			e.write("\r\n")
+			line := string(s.line)
+			e.remember(line)
+			return line, true
+		case key == keyCtrlD && len(s.line) == 0:
+			e.write("\r\n")
+			return "", false
+		case key == keyCtrlC:
+			// Ctrl-C reaches the editor as a key rather than a signal, so it is handed to
+			// the interrupt handler as if it were one
+			e.write("^C")
+			e.restoreTerminal()
+			inTurn := runOptions.interrupts.inTurn()
+			if runOptions.interrupts.interrupt() {
+				e.closed = true
+				return "", false
+			}
+			if inTurn {
+				return "", true
+			}
+			e.saved, _ = term.MakeRaw(int(e.in.Fd()))
+			s = &editState{index: len(e.history)}
+			continue
+		case key == keyCtrlL:
+			e.write("\x1b[H\x1b[2J" + strings.ReplaceAll(e.prompt, "\n", "\r\n"))
+		default:
+			e.edit(s, key)
+		}
+		e.refresh(s)
+	}
+}
+
+// readKey returns the next key press
+func (e *lineEditor) readKey() (string, error) {
+	key, ok := <-e.keyPresses()
+	if !ok {
+		return "", io.EOF
+	}
+	return key, nil
+}
+
+// keyPresses is the channel of key presses, which the line and typing ahead both read.
+// One goroutine reads the terminal, so no key is lost between them.
+func (e *lineEditor) keyPresses() chan string {
+	e.keysOnce.Do(func() {
+		e.keys = make(chan string)
+		go func() {
+			defer close(e.keys)
+			for {
+				key, err := e.scanKey()
+				if err != nil {
+					return
+				}
+				e.keys <- key
+			}
+		}()
+	})
+	return e.keys
+}
+
+// scanKey reads one key press: a character, or an escape sequence such as "\x1b[A". An
+// escape with nothing after it in the same read is the Esc key.
+func (e *lineEditor) scanKey() (string, error) {
+	r, _, err := e.reader.ReadRune()
+	if err != nil || r != '\x1b' {
+		return string(r), err
+	}
+	if e.reader.Buffered() == 0 {
+		return keyEsc, nil
+	}
+	next, _, err := e.reader.ReadRune()
+	if err != nil {
+		return "\x1b", err
+	}
+	if next != '[' && next != 'O' {
+		return "\x1b" + string(next), nil
+	}
+	sequence := "\x1b" + string(next)
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return sequence, err
+		}
+		sequence += string(r)
+		if r >= 0x40 && r <= 0x7e {
+			return sequence, nil
+		}
+	}
+}
+
+// edit applies a key to the line
+func (e *lineEditor) edit(s *editState, key string) {
+	switch {
+	case slices.Contains(keysHome, key):
+		s.pos = 0
+	case slices.Contains(keysEnd, key):
+		s.pos = len(s.line)
+	case slices.Contains(keysLeft, key):
+		s.pos = max(s.pos-1, 0)
+	case slices.Contains(keysRight, key):
+		s.pos = min(s.pos+1, len(s.line))
+	case slices.Contains(keysUp, key):
+		if s.index > 0 {
+			if s.index == len(e.history) {
+				s.draft = s.line
+			}
+			s.index--
+			s.set(e.history[s.index])
+		}
+	case slices.Contains(keysDown, key):
+		if s.index < len(e.history) {
+			s.index++
+			if s.index == len(e.history) {
+				s.line, s.pos = s.draft, len(s.draft)
+			} else {
+				s.set(e.history[s.index])
+			}
+		}
+	case key == keyBackspace || key == keyCtrlH:
+		if s.pos > 0 {
+			s.line = slices.Delete(s.line, s.pos-1, s.pos)
+			s.pos--
+		}
+	case key == keyDelete || key == keyCtrlD:
+		if s.pos < len(s.line) {
+			s.line = slices.Delete(s.line, s.pos, s.pos+1)
+		}
+	case key == keyCtrlK:
+		s.line = s.line[:s.pos]
+	case key == keyCtrlU:
+		s.line, s.pos = slices.Clone(s.line[s.pos:]), 0
+	case key == keyCtrlW:
+		start := s.wordStart()
+		s.line = slices.Delete(s.line, start, s.pos)
+		s.pos = start
+	case key == keyAltB:
+		s.pos = s.wordStart()
+	case key == keyAltF:
+		for s.pos < len(s.line) && unicode.IsSpace(s.line[s.pos]) {
+			s.pos++
+		}
+		for s.pos < len(s.line) && !unicode.IsSpace(s.line[s.pos]) {
+			s.pos++
+		}
+	default:
+		if r, _ := utf8.DecodeRuneInString(key); len(key) == utf8.RuneLen(r) && unicode.IsPrint(r) {
+			s.line = slices.Insert(s.line, s.pos, r)
+			s.pos++
+		}
+	}
+}
+
+// set replaces the line with text, with the cursor at its end
+func (s *editState) set(text string) {
+	s.line = []rune(text)
+	s.pos = len(s.line)
+}
+
+// wordStart is where the word before the cursor starts
+func (s *editState) wordStart() int {
+	start := s.pos
+	for start > 0 && unicode.IsSpace(s.line[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(s.line[start-1]) {
+		start--
+	}
+	return start
+}
+
+// search runs the reverse incremental search Ctrl-R starts. Typing narrows the search,
+// Ctrl-R finds the next older match and Ctrl-G gives up, restoring the line. Any other key
+// puts the match in the line and is returned to be handled as usual, so Enter sends it.
+func (e *lineEditor) search(s *editState) string {
+	query := []rune{}
+	match, failed := len(e.history), false
+	find := func(from int) {
+		for i := min(from, len(e.history)-1); i >= 0; i-- {
+			if strings.Contains(e.history[i], string(query)) {
+				match, failed = i, false
+				return
+			}
+		}
+		failed = true
+	}
+
+	for {
+		found := ""
+		if match < len(e.history) {
+			found = e.history[match]
+		}
+		label := "reverse-i-search"
+		if failed {
+			label = "failed " + label
+		}
+		e.show(fmt.Sprintf("(%s)`%s': %s", label, string(query), found))
+
+		key, err := e.readKey()
+		if err != nil {
+			return ""
+		}
+		switch {
+		case key == keyCtrlR:
+			find(match - 1)
+		case key == keyCtrlG:
+			return ""
+		case key == keyBackspace || key == keyCtrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case len(key) == utf8.RuneLen([]rune(key)[0]) && unicode.IsPrint([]rune(key)[0]):
+			query = append(query, []rune(key)...)
+			find(match)
+		default:
+			if match < len(e.history) {
+				s.index = match
+				s.set(e.history[match])
+			}
+			return key
+		}
+	}
+}
+
+// promptColumn is the column where the line starts: the width of the prompt's last row
+func (e *lineEditor) promptColumn(columns int) int {
+	last := e.prompt[strings.LastIndex(e.prompt, "\n")+1:]
+	return utf8.RuneCountInString(ansiEscape.ReplaceAllString(last, "")) % columns
+}
+
+// columns is the width of the terminal
+func (e *lineEditor) columns() int {
+	if width, _, err := term.GetSize(int(e.out.Fd())); err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
+
+// refresh redraws the line after the prompt. A line wider than the terminal scrolls
+// sideways to keep the cursor in view.
+func (e *lineEditor) refresh(s *editState) {
+	columns := e.columns()
+	start := e.promptColumn(columns)
+	width := max(columns-start-1, 1)
+	if s.pos < s.offset {
+		s.offset = s.pos
+	}
+	if s.pos-s.offset > width {
+		s.offset = s.pos - width
+	}
+	s.offset = min(s.offset, max(len(s.line)-width, 0))
+	visible := s.line[s.offset:min(len(s.line), s.offset+width)]
+	e.write("\r" + cursorForward(start) + string(visible) + "\x1b[0K\r" + cursorForward(start+s.pos-s.offset))
+}
+
+// show replaces the line with text, such as the state of a search
+func (e *lineEditor) show(text string) {
+	columns := e.columns()
+	start := e.promptColumn(columns)
+	runes := []rune(text)
+	runes = runes[:min(len(runes), max(columns-start-1, 1))]
+	e.write("\r" + cursorForward(start) + string(runes) + "\x1b[0K")
+}
+
+// write sends text to the terminal
+func (e *lineEditor) write(text string) {
+	io.WriteString(e.out, text)
+}
+
+// cursorForward moves the cursor n columns to the right
+func cursorForward(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("\x1b[%dC", n)
+}
@@ -23,16 +23,25 @@ Usage:
 package main
 
 import (
-	"bufio"   // For reading input line by line
+	"bufio" // For reading input line by line
+	"bytes"
+	"cmp"
 	"context" // For context management and cancellation
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt" // For formatted output
-	"os"  // For accessing stdin and environment variables
+	"io/fs"
+	"os" // For accessing stdin and environment variables
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go" // Anthropic's official Go SDK for Claude API
+	"github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/invopop/jsonschema"
 )
 
@@ -41,32 +50,206 @@ import (
 // =============================================================================
 
 func main() {
+	// A copy of go-agent started to sandbox a command becomes that command here
+	enterSandbox()
+
+	// Managed settings come first, since they override the rest of the configuration
+	if err := enforceManagedSettings(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(exitUsage)
+	}
+
+	// Read global options such as --ci before any subcommand. The flag package has printed
+	// what was wrong with them.
+	if err := parseGlobalFlags(); errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitSuccess)
+	} else if err != nil {
+		os.Exit(exitUsage)
+	}
+	if err := runOptions.prepare(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(exitUsage)
+	}
+
+	// A panic skips finish, so it cleans up before the process dies
+	defer func() {
+		if r := recover(); r != nil {
+			runOptions.cleanup.run()
+			panic(r)
+		}
+	}()
+	err := run(flag.Args())
+	os.Exit(runOptions.finish(err))
+}
+
+// run dispatches to a subcommand, a single -p task, or the interactive chat
+func run(args []string) error {
+	// Dispatch subcommands such as `fix` before starting the chat
+	if len(args) > 0 {
+		if command, ok := subcommands[args[0]]; ok {
+			if runOptions.saved != nil {
+				return usageErrorf("--resume, --continue and --import are for the chat and -p tasks, not %s", args[0])
+			}
+			return command(args[1:])
+		}
+	}
+
+	// Piped input is the context of the task given as -p or as the remaining arguments,
+	// or else the task itself
+	input, piped, err := readPipedInput()
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		if !piped {
+			return usageErrorf("unknown command %q (pass a task with -p \"...\")", args[0])
+		}
+		if runOptions.Prompt != "" {
+			return usageErrorf("give the task either with -p or as arguments, not both")
+		}
+		runOptions.Prompt = strings.Join(args, " ")
+	}
+	if piped {
+		runOptions.piped = true
+		if runOptions.Prompt == "" {
+			if strings.TrimSpace(input) == "" {
+				return fmt.Errorf("stdin is empty; pipe in a task, or its context with a task as arguments")
+			}
+			return runPrompt(input)
+		}
+		runOptions.pipedInput = input
+	}
+
+	if runOptions.Prompt != "" {
+		return runPrompt(runOptions.Prompt)
+	}
+	if runOptions.CI {
+		return fmt.Errorf("--ci needs a task: pass -p \"...\" or a subcommand")
+	}
+
 	// Initialize API client with credentials
 	client, err := initializeClient()
 	if err != nil {
-		fmt.Printf("Error: %s\n", err.Error())
-		os.Exit(1)
+		return err
+	}
+
+	// Create and run the agent with the default tools
+	agent := NewAgent(client, chatInput(), defaultTools())
+	return agent.Run(context.TODO())
+}
+
+// runPrompt runs a single task to completion without reading from stdin
+func runPrompt(prompt string) error {
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	// Output of --context-cmd commands and the --attach-diff diff are attached to the task
+	ctx := context.TODO()
+	attached, err := contextCommands(ctx, runOptions.ContextCmds)
+	if err != nil {
+		return err
+	}
+	if runOptions.AttachDiff != "" {
+		spec := runOptions.AttachDiff
+		if spec == workingTreeDiff {
+			spec = ""
+		}
+		diff, ok, err := diffContext(ctx, spec)
+		if err != nil {
+			return err
+		}
+		if ok {
+			attached = append(attached, diff)
+		}
+	}
+	if runOptions.pipedInput != "" {
+		attached = append(attached, pipedContext(runOptions.pipedInput))
 	}
 
-	// Set up user input handling
+	agent := NewAgent(client, nil, defaultTools())
+	session := runOptions.openSession()
+	conversation := append(session.history(), anthropic.NewUserMessage(append(agent.buildUserMessage(prompt), attached...)...))
+	before := runOptions.usage.snapshot()
+	conversation, err = agent.respond(ctx, conversation, prompt)
+	session.save(conversation)
+	saveTranscript(agent, session, conversation)
+	printTurnUsage(session, before)
+	return err
+}
+
+// chatInput returns the reader of the chat's messages: a line editor with history when
+// they are typed in a terminal, or else the lines of stdin
+func chatInput() func() (string, bool) {
+	if runOptions.tui != nil {
+		return runOptions.tui.readLine
+	}
+	if editor := newLineEditor(os.Stdin, promptOutput()); editor != nil {
+		runOptions.input = editor
+		runOptions.cleanup.add(editor.restoreTerminal)
+		runOptions.cleanup.add(editor.restoreTyping)
+		return editor.readLine
+	}
 	scanner := bufio.NewScanner(os.Stdin)
-	getUserMessage := func() (string, bool) {
+	return func() (string, bool) {
 		if !scanner.Scan() {
 			return "", false
 		}
 		return scanner.Text(), true
 	}
+}
 
-	// Define available tools
-	tools := []ToolDefinition{ReadFileDefinition, ListFilesDefinition, EditFileDefinition}
+// subcommands maps the first command-line argument to a workflow entry point
+var subcommands = map[string]func(args []string) error{
+	"fix":           runFixCommand,
+	"test-gen":      runTestGenCommand,
+	"docs":          runDocsCommand,
+	"changelog":     runChangelogCommand,
+	"deps":          runDepsCommand,
+	"migrate":       runMigrateCommand,
+	"map":           runMapCommand,
+	"explain":       runExplainCommand,
+	"gc":            runGCCommand,
+	"pin":           runPinCommand,
+	"sessions":      runSessionsCommand,
+	"openapi":       runOpenAPICommand,
+	"grpc":          runGRPCCommand,
+	"project":       runProjectCommand,
+	"targets":       runTargetsCommand,
+	"snapshot":      runSnapshotCommand,
+	"diff-sessions": runDiffSessionsCommand,
+	"review":        runReviewCommand,
+	"github-action": runGitHubActionCommand,
+	"webhook":       runWebhookCommand,
+	"discord":       runDiscordCommand,
+	"email":         runEmailCommand,
+	"chat":          runChatCommand,
+	"serve":         runServeCommand,
+	"attach":        runAttachCommand,
+	"audit":         runAuditCommand,
+	"reapply":       runReapplyCommand,
+	"reproduce":     runReproduceCommand,
+	"telemetry":     runTelemetryCommand,
+	"tools":         runToolsCommand,
+	"usage":         runUsageCommand,
+	"prices":        runPricesCommand,
+	"export":        runExportCommand,
+	"eval-report":   runEvalReportCommand,
+	"rpc":           runRPCCommand,
+}
 
-	// Create and run the agent
-	agent := NewAgent(client, getUserMessage, tools)
-	err = agent.Run(context.TODO())
-	if err != nil {
-		fmt.Printf("Error: %s\n", err.Error())
-		os.Exit(1)
-	}
+// defaultTools returns the tools available to the agent, including the project's
+// commands and those of --openapi and --grpc
+func defaultTools() []ToolDefinition {
+	tools := []ToolDefinition{ReadFileDefinition, ListFilesDefinition, EditFileDefinition, SearchFilesDefinition}
+	tools = append(tools, runOptions.project.tools()...)
+	return append(append(tools, runOptions.apiTools...), runOptions.grpcTools...)
+}
+
+// readOnlyTools returns the tools that cannot change the working directory
+func readOnlyTools() []ToolDefinition {
+	return []ToolDefinition{ReadFileDefinition, ListFilesDefinition, SearchFilesDefinition}
 }
 
 // =============================================================================
@@ -78,43 +261,30 @@ func initializeClient() (*anthropic.Client, error) {
 	// Load API key from environment or config file
 	apiKey := loadAPIKey()
 	if apiKey == "" {
-		return nil, fmt.Errorf("ANTHROPIC_API_KEY is required")
+		return nil, usageErrorf("ANTHROPIC_API_KEY is required")
 	}
 
-	// Debug: Show partial key for verification
-	fmt.Printf("API Key loaded: %s...\n", apiKey[:20])
-
 	// Set environment variable for the client
 	os.Setenv("ANTHROPIC_API_KEY", apiKey)
 
 	// Create and return the client
-	client := anthropic.NewClient()
+	client := anthropic.NewClient(option.WithMiddleware(modelMiddleware, apiRateLimiter().middleware, runOptions.telemetry.middleware, runOptions.budget.middleware))
 	return &client, nil
 }
 
 // loadAPIKey attempts to load the API key from environment or config file
 func loadAPIKey() string {
-	// Try environment variable first
-	apiKey := os.Getenv("ANTHROPIC_API_KEY")
+	// Try environment variable first, then config file as fallback
+	apiKey := configValue("ANTHROPIC_API_KEY")
 	if apiKey != "" {
 		return apiKey
 	}
 
-	// Try config file as fallback
-	if data, err := os.ReadFile("config.env"); err == nil {
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "ANTHROPIC_API_KEY=") {
-				return strings.TrimPrefix(line, "ANTHROPIC_API_KEY=")
-			}
-		}
-	}
-
 	// No key found
-	fmt.Println("Error: ANTHROPIC_API_KEY is required")
-	fmt.Println("Please either:")
-	fmt.Println("1. Set environment variable: export ANTHROPIC_API_KEY=your_api_key_here")
-	fmt.Println("2. Add your key to config.env file")
+	fmt.Fprintln(os.Stderr, "Error: ANTHROPIC_API_KEY is required")
+	fmt.Fprintln(os.Stderr, "Please either:")
+	fmt.Fprintln(os.Stderr, "1. Set environment variable: export ANTHROPIC_API_KEY=your_api_key_here")
+	fmt.Fprintln(os.Stderr, "2. Add your key to config.env file")
 	return ""
 }
 
@@ -125,8 +295,22 @@ func loadAPIKey() string {
 // Agent represents the main conversation handler with tool execution capabilities
 type Agent struct {
 	client         *anthropic.Client     // Client for making API calls to Claude
+	model          anthropic.Model       // Model answering this agent's calls
 	getUserMessage func() (string, bool) // Function to get user input
 	tools          []ToolDefinition      // List of available tools
+	toolOutputs    *ToolOutputStore      // Raw outputs of oversized tool results
+	todos          *TodoList             // Claude's task list for the current job
+	editedFiles    []string              // Files successfully changed by edit_file, in order
+	snapshot       FileSnapshot          // When set, captures files before edit_file changes them
+	events         *EventLog             // Machine-readable log of what the agent does, if enabled
+	turns          int                   // Number of model calls made, checked against --max-turns
+	approver       func(string) bool     // Answers approval questions instead of the terminal, for chat bots
+	user           string                // Who the agent works for, for tool policies; defaults to the local user
+	session        string                // Which conversation the agent belongs to, for tool policies
+	usage          quotaUsage            // Tool usage counted against the configured quotas
+	reminders      []func() string       // Extra lines for the periodic system reminder, such as task state
+	streamed       bool                  // Whether the last response's text was printed as it arrived
+	partial        bool                  // Whether the last call failed after part of its response was printed
 }
 
 // NewAgent creates a new agent instance with the specified client and tools
@@ -135,11 +319,24 @@ func NewAgent(
 	getUserMessage func() (string, bool),
 	tools []ToolDefinition,
 ) *Agent {
-	return &Agent{
+	toolOutputs := NewToolOutputStore()
+	todos := NewTodoList()
+
+	agent := &Agent{
 		client:         client,
+		model:          runOptions.preset.model(),
 		getUserMessage: getUserMessage,
-		tools:          tools,
+		tools:          append(withExploration(client, runOptions.filterTools(tools)), toolOutputs.Definition(), todos.Definition()),
+		toolOutputs:    toolOutputs,
+		todos:          todos,
+		events:         runOptions.events,
+		reminders:      []func() string{todos.reminder},
+	}
+	todos.onChange = func(rendered string, items []TodoItem) {
+		fmt.Fprintf(os.Stderr, "%s:\n%s", paint(roleInfo, "todo"), rendered)
+		agent.events.Emit(Event{Type: eventTodo, Text: rendered, Todos: items})
 	}
+	return agent
 }
 
 // =============================================================================
@@ -148,63 +345,165 @@ func NewAgent(
 
 // Run starts the main conversation loop and handles the chat flow
 func (a *Agent) Run(ctx context.Context) error {
-	conversation := []anthropic.MessageParam{}
-	fmt.Println("Chat with Claude (use 'ctrl-c' to quit)")
-
-	readUserInput := true
+	chat := &chatState{ctx: ctx, agent: a, session: runOptions.openSession()}
+	chat.conversation = chat.session.history()
+	commands := slashCommands()
+	runOptions.interrupts.enableChat()
+	fmt.Fprintln(os.Stderr, "Chat with Claude (/help lists commands; ctrl-c stops a response; press it twice or ctrl-d to quit)")
+	if runOptions.Import != "" {
+		replayConversation(chat.conversation, importReplayMessages)
+		if sessionsEnabled() {
+			fmt.Fprintf(os.Stderr, "Imported %d messages from %s into session %s (continue it later with --resume %s)\n", len(chat.conversation), runOptions.Import, chat.session.ID, chat.session.ID)
+		} else {
+			fmt.Fprintf(os.Stderr, "Imported %d messages from %s\n", len(chat.conversation), runOptions.Import)
+		}
+	} else if chat.session.resumed() {
+		fmt.Fprintf(os.Stderr, "Resumed session %s\n", chat.session.describe())
+	} else if chat.session != nil {
+		fmt.Fprintf(os.Stderr, "Session %s (continue it later with --resume %s)\n", chat.session.ID, chat.session.ID)
+	}
 
 	// Main conversation loop
-	for {
-		if readUserInput {
-			// Get user input and add to conversation
-			fmt.Print("\u001b[94mYou\u001b[0m: ")
-			readUserInput = false
-
-			userInput, ok := a.getUserMessage()
-			if !ok {
-				break
+	for !chat.exit {
+		// Get user input and add to conversation
+		prompt(paint(roleUser, "You") + ": ")
+
+		// Messages typed while the last turn ran go first
+		userInput, ok := runOptions.queue.pop()
+		if ok {
+			echoQueued(userInput)
+		} else if userInput, ok = a.getUserMessage(); !ok {
+			break
+		}
+		runOptions.pane.echo(userInput)
+
+		// Shell commands after ! and slash commands such as /help run without the model
+		if isPassthrough(userInput) {
+			chat.runShell(userInput)
+			continue
+		}
+		if runSlashCommand(chat, commands, userInput) {
+			continue
+		}
+		if strings.HasPrefix(userInput, "//") {
+			userInput = userInput[1:] // //TEXT sends /TEXT
+		}
+		userInput = a.offerSuggestions(ctx, userInput, chat.conversation)
+
+		chat.attached = append(chat.attached, runOptions.pane.take()...)
+		userMessage := anthropic.NewUserMessage(append(a.buildUserMessage(userInput), chat.attached...)...)
+		chat.conversation = appendUserMessage(chat.conversation, userMessage)
+		chat.attached = nil
+
+		// Let Claude respond, using tools as needed, until the response ends or Ctrl-C
+		// interrupts it
+		var err error
+		before := runOptions.usage.snapshot()
+		turnCtx, done := runOptions.interrupts.startTurn(ctx)
+		stopTyping := runOptions.input.typeAhead()
+		chat.conversation, err = a.respond(turnCtx, chat.conversation, userInput)
+		stopTyping()
+		if done() {
+			chat.conversation, err = markInterrupted(chat.conversation), nil
+			a.events.Emit(Event{Type: eventStatus, Text: "interrupted"})
+			if dropped := runOptions.queue.clear(); dropped > 0 {
+				fmt.Fprintf(os.Stderr, "%s: %d queued message(s) not sent; up recalls them\n", paint(roleNotice, "interrupted"), dropped)
 			}
+		}
+		runOptions.queue.requeue()
+		chat.session.save(chat.conversation)
+		saveTranscript(a, chat.session, chat.conversation)
+		printTurnUsage(chat.session, before)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-			userMessage := anthropic.NewUserMessage(anthropic.NewTextBlock(userInput))
-			conversation = append(conversation, userMessage)
+// runTurn gets Claude's response to the conversation, executing requested tools
+// until Claude replies without asking for any, and returns the extended conversation
+func (a *Agent) runTurn(ctx context.Context, conversation []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+	for {
+		// Make sure the request fits before it is sent
+		var err error
+		if conversation, err = a.fitContext(ctx, conversation); err != nil {
+			return conversation, err
 		}
 
-		// Get Claude's response
-		message, err := a.runInference(ctx, conversation)
+		// Get Claude's response. A correction sent meanwhile stops it, and Claude starts
+		// over with the correction.
+		callCtx, endCall := runOptions.queue.startCall(ctx)
+		message, err := a.runInference(callCtx, conversation)
+		if endCall() {
+			conversation = appendUserMessage(conversation, anthropic.NewUserMessage(a.corrections()...))
+			continue
+		}
 		if err != nil {
-			return err
+			return conversation, err
 		}
 
 		// Add Claude's response to conversation history
 		conversation = append(conversation, message.ToParam())
 
 		// Process Claude's response for tool usage
-		toolResults := a.processClaudeResponse(message)
-
-		// Handle tool results if any
-		if len(toolResults) > 0 {
-			// Send tool results back to Claude as a user message
-			toolResultMessage := anthropic.NewUserMessage(toolResults...)
-			conversation = append(conversation, toolResultMessage)
-			readUserInput = false
-		} else {
-			readUserInput = true
+		toolResults := a.processClaudeResponse(ctx, message)
+		if len(toolResults) == 0 {
+			return conversation, nil
 		}
-	}
 
-	return nil
+		// Send tool results back to Claude as a user message, with any corrections
+		toolResultMessage := anthropic.NewUserMessage(append(toolResults, a.corrections()...)...)
+		conversation = append(conversation, toolResultMessage)
+	}
 }
 
 // processClaudeResponse handles Claude's response and executes any requested tools
-func (a *Agent) processClaudeResponse(message *anthropic.Message) []anthropic.ContentBlockParamUnion {
+func (a *Agent) processClaudeResponse(ctx context.Context, message *anthropic.Message) []anthropic.ContentBlockParamUnion {
 	toolResults := []anthropic.ContentBlockParamUnion{}
 
-	for _, content := range message.Content {
+	for i := 0; i < len(message.Content); i++ {
+		content := message.Content[i]
 		switch content.Type {
 		case "text":
-			fmt.Printf("\u001b[93mClaude\u001b[0m: %s\n", content.Text)
+			// Cited answers arrive as many text blocks; show them as one
+			end := i + 1
+			for end < len(message.Content) && message.Content[end].Type == "text" {
+				end++
+			}
+			text, citations := citedText(message.Content[i:end])
+			i = end - 1
+			if !a.streamed {
+				printAnswer(text)
+			}
+			printCitations(citations)
+			a.events.Emit(Event{Type: eventAssistantText, Text: text, Citations: citations})
+		case "thinking", "redacted_thinking":
+			// Thinking stays in the conversation as it is, signature and all, since the
+			// API checks it when the tool loop continues
+			text := content.Thinking
+			if content.Type == "redacted_thinking" {
+				text = "(redacted)"
+			}
+			if !a.streamed {
+				printThinking(text)
+			}
+			a.events.Emit(Event{Type: eventThinking, Text: text})
 		case "tool_use":
-			result := a.executeTool(content.ID, content.Name, content.Input)
+			// After a correction, the rest of the calls wait for Claude to take it in
+			if runOptions.queue.steering() {
+				fmt.Fprintf(os.Stderr, "%s: skipped %s for your correction\n", paint(roleNotice, "steering"), content.Name)
+				toolResults = append(toolResults, anthropic.NewToolResultBlock(content.ID, skippedForCorrection, true))
+				continue
+			}
+			// Consecutive calls of tools with a concurrency hint run together
+			if batch := a.concurrentBatch(message.Content[i:]); len(batch) > 1 {
+				toolResults = append(toolResults, a.executeConcurrently(ctx, batch)...)
+				i += len(batch) - 1
+				continue
+			}
+			result := a.executeTool(ctx, content.ID, content.Name, content.Input)
 			toolResults = append(toolResults, result)
 		}
 	}
@@ -216,20 +515,52 @@ func (a *Agent) processClaudeResponse(message *anthropic.Message) []anthropic.Co
 // API COMMUNICATION
 // =============================================================================
 
+// agentModel is the model of the agent's calls unless a preset picks another
+const agentModel = anthropic.ModelClaude3_7SonnetLatest
+
 // runInference sends the conversation to Claude and returns the response
 func (a *Agent) runInference(ctx context.Context, conversation []anthropic.MessageParam) (*anthropic.Message, error) {
+	// Stop runaway loops once the turn budget is spent
+	a.turns++
+	if runOptions.MaxTurns > 0 && a.turns > runOptions.MaxTurns {
+		return nil, fmt.Errorf("%w: %d model calls allowed", errOverBudget, runOptions.MaxTurns)
+	}
+	if err := runOptions.budget.check(); err != nil {
+		return nil, err
+	}
+
 	// Convert tool definitions to Anthropic's format
 	anthropicTools := a.convertToolsToAnthropicFormat()
 
-	// Make API call to Claude
-	message, err := a.client.Messages.New(ctx, anthropic.MessageNewParams{
-		Model:     anthropic.ModelClaude3_7SonnetLatest,
-		MaxTokens: int64(1024),
-		Messages:  conversation,
-		Tools:     anthropicTools,
+	// Make API call to Claude. The reminder is added after the cache breakpoints, so the
+	// cached conversation is the same whether or not it is there.
+	params := withPromptCache(runOptions.withGeneration(anthropic.MessageNewParams{
+		Model:      a.model,
+		System:     runOptions.systemBlocks(),
+		Messages:   conversation,
+		Tools:      anthropicTools,
+		ToolChoice: toolChoice(),
+	}))
+	params.Messages = a.withReminder(params.Messages)
+	message, err := a.newMessage(ctx, params)
+	if err != nil {
+		a.reportFailure(errorFailure(err))
+		return nil, err
+	}
+	if message.StopReason == anthropic.StopReasonMaxTokens {
+		fmt.Fprintf(os.Stderr, "%s: Claude's reply was cut off at %d tokens; raise --max-tokens or MAX_TOKENS for longer replies\n", paint(roleWarning, "warning"), runOptions.MaxTokens)
+	}
+	a.events.Emit(Event{
+		Type:       eventInference,
+		Model:      string(message.Model),
+		StopReason: string(message.StopReason),
+		Usage: &UsageStats{Calls: 1, InputTokens: message.Usage.InputTokens, OutputTokens: message.Usage.OutputTokens,
+			CacheWriteTokens: message.Usage.CacheCreationInputTokens, CacheReadTokens: message.Usage.CacheReadInputTokens},
 	})
+	a.reportFailure(responseFailure(message))
+	runOptions.toolStats.response(a, message)
 
-	return message, err
+	return message, nil
 }
 
 // convertToolsToAnthropicFormat converts our tool definitions to Anthropic's format
@@ -254,30 +585,106 @@ func (a *Agent) convertToolsToAnthropicFormat() []anthropic.ToolUnionParam {
 // =============================================================================
 
 // executeTool finds and executes the requested tool
-func (a *Agent) executeTool(id, name string, input json.RawMessage) anthropic.ContentBlockParamUnion {
-	// Find the tool definition
-	var toolDef ToolDefinition
-	var found bool
+func (a *Agent) executeTool(ctx context.Context, id, name string, input json.RawMessage) anthropic.ContentBlockParamUnion {
+	toolDef, found := a.findTool(name)
+	if !found {
+		return anthropic.NewToolResultBlock(id, "tool not found", true)
+	}
+	if denied, ok := a.admitTool(toolDef, id, input); !ok {
+		return denied
+	}
+
+	// Dry runs describe mutating tool calls instead of making them
+	if toolDef.Mutating && runOptions.Mode == modeDryRun {
+		fmt.Fprintf(os.Stderr, "%s: %s(%s) [dry run]\n", paint(roleTool, "tool"), name, input)
+		response, err := simulateTool(ctx, name, input)
+		if err != nil {
+			a.events.Emit(Event{Type: eventToolResult, Tool: name, ToolUseID: id, Text: err.Error(), IsError: true})
+			return anthropic.NewToolResultBlock(id, err.Error(), true)
+		}
+		a.events.Emit(Event{Type: eventToolResult, Tool: name, ToolUseID: id, Text: response})
+		return anthropic.NewToolResultBlock(id, response, false)
+	}
+
+	// Remember what edit_file is about to change so workflows can report or revert it
+	editedPath := ""
+	if toolDef.Mutating && name == EditFileDefinition.Name {
+		editedPath = a.prepareEdit(input)
+	}
+
+	result, ok := a.runTool(ctx, toolDef, id, input, true)
+	if ok && editedPath != "" && !slices.Contains(a.editedFiles, editedPath) {
+		a.editedFiles = append(a.editedFiles, editedPath)
+	}
+	return result
+}
+
+// findTool looks up one of the agent's tools by name
+func (a *Agent) findTool(name string) (ToolDefinition, bool) {
 	for _, tool := range a.tools {
 		if tool.Name == name {
-			toolDef = tool
-			found = true
-			break
+			return tool, true
 		}
 	}
+	return ToolDefinition{}, false
+}
 
-	if !found {
-		return anthropic.NewToolResultBlock(id, "tool not found", true)
+// admitTool logs a tool call and checks it against the policies and quotas, returning the
+// result to send back when the call may not run
+func (a *Agent) admitTool(toolDef ToolDefinition, id string, input json.RawMessage) (anthropic.ContentBlockParamUnion, bool) {
+	name := toolDef.Name
+	a.events.Emit(Event{Type: eventToolUse, Tool: name, ToolUseID: id, Input: input})
+
+	// Tool calls must pass the tool policy, and mutating ones the approval policy
+	if allowed, reason := a.authorizeTool(toolDef, input); !allowed {
+		fmt.Fprintf(os.Stderr, "%s: %s denied by %s\n", paint(roleTool, "tool"), name, reason)
+		a.events.Emit(Event{Type: eventToolDenied, Tool: name, ToolUseID: id, Text: reason})
+		runOptions.needsHuman.Store(true)
+		return anthropic.NewToolResultBlock(id, "This tool call was denied by "+reason+". Do not retry it; explain what you wanted to do instead.", true), false
+	}
+
+	// Quotas stop runaway sessions; the model is told which limit it hit
+	if err := a.chargeQuota(name, input); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", paint(roleTool, "tool"), name, err.Error())
+		a.events.Emit(Event{Type: eventToolResult, Tool: name, ToolUseID: id, Text: err.Error(), IsError: true})
+		return anthropic.NewToolResultBlock(id, err.Error()+". Do not retry; finish with what you have.", true), false
 	}
+	return anthropic.ContentBlockParamUnion{}, true
+}
 
-	// Execute the tool
-	fmt.Printf("\u001b[92mtool\u001b[0m: %s(%s)\n", name, input)
-	response, err := toolDef.Function(input)
+// runTool executes an admitted tool call and reports whether it succeeded. Progress is
+// drawn in the terminal only when showProgress is set, since bars of concurrent calls
+// would overwrite each other.
+func (a *Agent) runTool(ctx context.Context, toolDef ToolDefinition, id string, input json.RawMessage, showProgress bool) (anthropic.ContentBlockParamUnion, bool) {
+	name := toolDef.Name
+
+	// Execute the tool, showing any progress it reports
+	fmt.Fprintf(os.Stderr, "%s: %s(%s)\n", paint(roleTool, "tool"), name, input)
+	progress := newProgressReporter(a.events, name, id)
+	progress.terminal = progress.terminal && showProgress
+	start := time.Now()
+	ctx = withEgressLog(ctx, func(host string, err error) {
+		event := Event{Type: eventEgress, Tool: name, ToolUseID: id, Text: host}
+		if err != nil {
+			event.Text, event.IsError = err.Error(), true
+		}
+		a.events.Emit(event)
+	})
+	response, err := toolDef.call(withProgress(ctx, progress.report), input)
+	progress.finish()
+	runOptions.telemetry.toolCall(name, time.Since(start), err != nil)
 	if err != nil {
-		return anthropic.NewToolResultBlock(id, err.Error(), true)
+		runOptions.toolStats.toolCall(a, name, "", true)
+		a.events.Emit(Event{Type: eventToolResult, Tool: name, ToolUseID: id, Text: err.Error(), IsError: true})
+		return anthropic.NewToolResultBlock(id, err.Error(), true), false
 	}
 
-	return anthropic.NewToolResultBlock(id, response, false)
+	// Keep huge outputs from flooding the context window
+	response = a.shrinkToolResult(ctx, name, response)
+	runOptions.toolStats.toolCall(a, name, response, false)
+	a.events.Emit(Event{Type: eventToolResult, Tool: name, ToolUseID: id, Text: response})
+
+	return anthropic.NewToolResultBlock(id, response, false), true
 }
 
 // =============================================================================
@@ -290,6 +697,22 @@ type ToolDefinition struct {
 	Description string                                      `json:"description"`
 	InputSchema anthropic.ToolInputSchemaParam              `json:"input_schema"`
 	Function    func(input json.RawMessage) (string, error) `json:"-"`
+	Mutating    bool                                        `json:"-"` // Changes the workspace; subject to approval and read-only mode
+
+	// Run replaces Function for tools that report progress or stop when the call is canceled
+	Run func(ctx context.Context, input json.RawMessage) (string, error) `json:"-"`
+
+	// Command returns the shell command line a call runs, for tools that run one, so
+	// destructive commands can be caught before they do
+	Command func(input json.RawMessage) string `json:"-"`
+}
+
+// call runs the tool with Run when it has one, or Function
+func (t ToolDefinition) call(ctx context.Context, input json.RawMessage) (string, error) {
+	if t.Run != nil {
+		return t.Run(ctx, input)
+	}
+	return t.Function(input)
 }
 
 // =============================================================================
@@ -355,9 +778,11 @@ func ListFiles(input json.RawMessage) (string, error) {
 		panic(err)
 	}
 
-	dir := "."
+	// A listing of the --target directory keeps its paths relative to the working
+	// directory, which read_file and edit_file resolve them against
+	dir, base := runOptions.target.dir(), "."
 	if listFilesInput.Path != "" {
-		dir = listFilesInput.Path
+		dir, base = listFilesInput.Path, listFilesInput.Path
 	}
 
 	var files []string
@@ -366,12 +791,12 @@ func ListFiles(input json.RawMessage) (string, error) {
 			return err
 		}
 
-		relPath, err := filepath.Rel(dir, path)
+		relPath, err := filepath.Rel(base, path)
 		if err != nil {
 			return err
 		}
 
-		if relPath != "." {
+		if path != dir {
 			if info.IsDir() {
 				files = append(files, relPath+"/")
 			} else {
@@ -406,6 +831,7 @@ If the file specified with path doesn't exist, it will be created.
 `,
 	InputSchema: EditFileInputSchema,
 	Function:    EditFile,
+	Mutating:    true,
 }
 
 type EditFileInput struct {
@@ -423,31 +849,53 @@ func EditFile(input json.RawMessage) (string, error) {
 		return "", err
 	}
 
+	oldContent, newContent, err := editedContent(editFileInput)
+	if err != nil {
+		return "", err
+	}
+	if oldContent == nil {
+		return createNewFile(editFileInput.Path, newContent)
+	}
+
+	err = os.WriteFile(editFileInput.Path, []byte(newContent), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return "OK", nil
+}
+
+// editedContent returns a file's contents before and after an edit, without writing it.
+// A nil old content means the edit creates the file.
+func editedContent(editFileInput EditFileInput) (*string, string, error) {
 	if editFileInput.Path == "" || editFileInput.OldStr == editFileInput.NewStr {
-		return "", fmt.Errorf("invalid input parameters")
+		return nil, "", fmt.Errorf("invalid input parameters")
 	}
 
 	content, err := os.ReadFile(editFileInput.Path)
 	if err != nil {
 		if os.IsNotExist(err) && editFileInput.OldStr == "" {
-			return createNewFile(editFileInput.Path, editFileInput.NewStr)
+			return nil, editFileInput.NewStr, nil
 		}
-		return "", err
+		return nil, "", err
 	}
 
 	oldContent := string(content)
+	newContent, err := replaceEdit(oldContent, editFileInput)
+	if err != nil {
+		return nil, "", err
+	}
+	return &oldContent, newContent, nil
+}
+
+// replaceEdit applies an edit to a file's existing contents
+func replaceEdit(oldContent string, editFileInput EditFileInput) (string, error) {
 	newContent := strings.Replace(oldContent, editFileInput.OldStr, editFileInput.NewStr, -1)
 
 	if oldContent == newContent && editFileInput.OldStr != "" {
 		return "", fmt.Errorf("old_str not found in file")
 	}
-
-	err = os.WriteFile(editFileInput.Path, []byte(newContent), 0644)
-	if err != nil {
-		return "", err
-	}
-
-	return "OK", nil
+	return newContent, nil
 }
 
 func createNewFile(filePath, content string) (string, error) {
@@ -467,6 +915,128 @@ func createNewFile(filePath, content string) (string, error) {
 	return fmt.Sprintf("Successfully created file %s", filePath), nil
 }
 
+// =============================================================================
+// SEARCH FILES TOOL IMPLEMENTATION
+// =============================================================================
+
+// maxSearchMatches caps how many matching lines search_files returns
+const maxSearchMatches = 200
+
+// SearchFilesDefinition - Tool that allows Claude to search file contents with a regular expression
+var SearchFilesDefinition = ToolDefinition{
+	Name:        "search_files",
+	Description: "Search the contents of files under a directory for a regular expression (RE2 syntax). Returns matching lines as path:line: text. Use this to find call sites, definitions or usages instead of reading every file.",
+	InputSchema: SearchFilesInputSchema,
+	Run:         SearchFiles,
+}
+
+// SearchFilesInput defines the input structure for the search_files tool
+type SearchFilesInput struct {
+	Pattern string `json:"pattern" jsonschema_description:"Regular expression to search for, in RE2 syntax."`
+	Path    string `json:"path,omitempty" jsonschema_description:"Optional relative directory to search in. Defaults to the current directory."`
+	Glob    string `json:"glob,omitempty" jsonschema_description:"Optional file name pattern such as *.go to limit the search."`
+}
+
+// SearchFilesInputSchema - Auto-generated JSON schema for SearchFilesInput
+var SearchFilesInputSchema = GenerateSchema[SearchFilesInput]()
+
+// SearchMatch is one line matched by searchFiles
+type SearchMatch struct {
+	Path string
+	Line int
+	Text string
+}
+
+// SearchFiles executes the file search functionality
+func SearchFiles(ctx context.Context, input json.RawMessage) (string, error) {
+	searchFilesInput := SearchFilesInput{}
+	err := json.Unmarshal(input, &searchFilesInput)
+	if err != nil {
+		return "", fmt.Errorf("invalid input format: %w", err)
+	}
+
+	pattern, err := regexp.Compile(searchFilesInput.Pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	matches, err := searchFiles(ctx, pattern, cmp.Or(searchFilesInput.Path, runOptions.target.dir()), searchFilesInput.Glob, maxSearchMatches+1)
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "No matches found.", nil
+	}
+
+	var result strings.Builder
+	for i, match := range matches {
+		if i == maxSearchMatches {
+			fmt.Fprintf(&result, "... more matches omitted; narrow the pattern or path\n")
+			break
+		}
+		fmt.Fprintf(&result, "%s:%d: %s\n", match.Path, match.Line, match.Text)
+	}
+
+	return result.String(), nil
+}
+
+// searchFiles walks dir and returns up to limit lines matching pattern, skipping hidden
+// directories, vendored code and binary files. It reports progress per file searched.
+func searchFiles(ctx context.Context, pattern *regexp.Regexp, dir, glob string, limit int) ([]SearchMatch, error) {
+	if dir == "" {
+		dir = "."
+	}
+
+	// Find the candidate files first so progress can be shown against a total
+	paths := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != dir && (strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, d.Name()); !ok {
+				return nil
+			}
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []SearchMatch{}
+	for done, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return matches, err
+		}
+		reportProgress(ctx, Progress{Done: done, Total: len(paths), Current: path})
+
+		content, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(content, 0) >= 0 {
+			continue
+		}
+
+		for i, line := range strings.Split(string(content), "\n") {
+			if pattern.MatchString(line) {
+				matches = append(matches, SearchMatch{Path: path, Line: i + 1, Text: strings.TrimSpace(line)})
+				if len(matches) >= limit {
+					return matches, nil
+				}
+			}
+		}
+	}
+
+	return matches, nil
+}
+
 // =============================================================================
 // UTILITY FUNCTIONS
 // =============================================================================
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// =============================================================================
+// MANAGED SETTINGS
+// =============================================================================
+
+// ManagedSettings are installed by an organization at a system path. They override user
+// and project configuration, and nothing in the command line or config.env turns them off.
+type ManagedSettings struct {
+	Settings      map[string]string `json:"settings"`       // Override environment variables and config.env
+	AllowedModels []string          `json:"allowed_models"` // Globs over model names, e.g. claude-3-5-haiku-*
+	DeniedTools   []string          `json:"denied_tools"`   // Tools never offered to Claude
+	AllowedHosts  []string          `json:"allowed_hosts"`  // Hosts network connections may go to, e.g. *.example.com
+	Rules         []PolicyRule      `json:"rules"`          // Checked before --policy; allow defers to it
+
+	path   string
+	policy *Policy
+}
+
+// managedSettingsPath is where IT installs the managed settings file
+func managedSettingsPath() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "/Library/Application Support/go-agent/managed-settings.json"
+	case "windows":
+		return `C:\ProgramData\go-agent\managed-settings.json`
+	default:
+		return "/etc/go-agent/managed-settings.json"
+	}
+}
+
+// managedSettings reads the managed settings file once. It returns nil when none is
+// installed, and an error when one is installed but cannot be read, so a broken rollout
+// stops the agent instead of running it unmanaged.
+var managedSettings = sync.OnceValues(func() (*ManagedSettings, error) {
+	settingsPath := managedSettingsPath()
+	data, err := os.ReadFile(settingsPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read managed settings: %w", err)
+	}
+	managed := &ManagedSettings{path: settingsPath}
+	if err := json.Unmarshal(data, managed); err != nil {
+		return nil, fmt.Errorf("failed to parse managed settings %s: %w", settingsPath, err)
+	}
+
+	if len(managed.Rules) > 0 {
+		managed.policy = &Policy{Rules: managed.Rules}
+		if err := managed.policy.compile(); err != nil {
+			return nil, fmt.Errorf("managed settings %s: %w", settingsPath, err)
+		}
+	}
+	return managed, nil
+})
+
+// enforceManagedSettings loads the managed settings and restricts network access to the
+// allowed hosts. It runs before anything else reads configuration.
+func enforceManagedSettings() error {
+	managed, err := managedSettings()
+	if err != nil || managed == nil {
+		return err
+	}
+	if managed.AllowedHosts != nil {
+		http.DefaultTransport = egressTransport{next: http.DefaultTransport}
+	}
+	return nil
+}
+
+// managedSetting returns a setting the managed settings force, if they do
+func managedSetting(key string) (string, bool) {
+	managed, _ := managedSettings()
+	if managed == nil {
+		return "", false
+	}
+	value, ok := managed.Settings[key]
+	return value, ok
+}
+
+// checkModel fails for models the managed settings do not allow
+func checkModel(model string) error {
+	managed, _ := managedSettings()
+	if managed == nil || managed.AllowedModels == nil {
+		return nil
+	}
+	for _, pattern := range managed.AllowedModels {
+		if ok, _ := path.Match(pattern, model); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("model %s is not allowed by the managed settings in %s (allowed: %s)", model, managed.path, strings.Join(managed.AllowedModels, ", "))
+}
+
+// checkEgress fails for connections to hosts the managed settings do not allow. addr is a
+// host or host:port.
+func checkEgress(addr string) error {
+	managed, _ := managedSettings()
+	if managed == nil || managed.AllowedHosts == nil {
+		return nil
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	if hostAllowed(host, managed.AllowedHosts) {
+		return nil
+	}
+	return fmt.Errorf("connecting to %s is not allowed by the managed settings in %s", strings.ToLower(host), managed.path)
+}
+
+// toolBanned reports whether the managed settings ban a tool
+func toolBanned(name string) bool {
+	managed, _ := managedSettings()
+	return managed != nil && slices.Contains(managed.DeniedTools, name)
+}
+
+// egressTransport refuses HTTP requests to hosts outside the allowed list
+type egressTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t egressTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := checkEgress(request.URL.Host); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(request)
+}
+
+// modelMiddleware refuses API calls to models outside the allowed list, whichever part of
+// the agent makes them
+func modelMiddleware(request *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if request.Body == nil || !strings.Contains(request.URL.Path, "/messages") {
+		return next(request)
+	}
+	data, err := io.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(data))
+	var body struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Model != "" {
+		if err := checkModel(body.Model); err != nil {
+			return nil, err
+		}
+	}
+	return next(request)
+}
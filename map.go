@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// MAP WORKFLOW
+// =============================================================================
+
+// mapShardPrompt asks a subagent to apply the instruction to its shard of files
+const mapShardPrompt = "Apply the following instruction to each of these files, and only to them:\n%s\n" +
+	"<instruction>\n%s\n</instruction>\n\n" +
+	"Read each file first, then edit it. Leave files that need no change as they are. If some change can't be made " +
+	"safely, leave that code alone and end your reply with one line per item starting with `MANUAL:`."
+
+// Per-file outcomes of a map run
+const (
+	mapChanged   = "changed"
+	mapUnchanged = "unchanged"
+	mapConflict  = "conflict"
+	mapFailed    = "failed"
+)
+
+// mapResult is the outcome of one file of a map run
+type mapResult struct {
+	Path   string
+	Status string
+	Detail string
+}
+
+// mapShard is a group of files handled by one subagent
+type mapShard struct {
+	Index   int
+	Files   []string
+	Changes *overlayWorkspace
+	Notes   []string
+	Err     error
+}
+
+// runMapCommand implements `go-agent map --files GLOB INSTRUCTION`
+func runMapCommand(args []string) error {
+	flags := flag.NewFlagSet("map", flag.ContinueOnError)
+	files := flags.String("files", "", "comma-separated file patterns; ** matches any number of directories, e.g. pkg/**/*.go")
+	jobs := flags.Int("jobs", 4, "number of subagents running at once")
+	batch := flags.Int("batch", 1, "files given to each subagent")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if *files == "" || flags.NArg() == 0 {
+		return usageErrorf("usage: go-agent map --files GLOB [--jobs N] [--batch N] INSTRUCTION")
+	}
+	if runOptions.Mode == modeReadOnly {
+		return fmt.Errorf("map edits files and cannot run in read-only mode; use --dry-run to preview its changes")
+	}
+	instruction := strings.Join(flags.Args(), " ")
+
+	paths, err := expandFilePatterns(splitList(*files))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no files match %s", *files)
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	shards := []*mapShard{}
+	for start := 0; start < len(paths); start += max(1, *batch) {
+		end := min(start+max(1, *batch), len(paths))
+		shards = append(shards, &mapShard{Index: len(shards) + 1, Files: paths[start:end]})
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d file(s) in %d shard(s), %d at a time\n", paint(roleStatus, "map"), len(paths), len(shards), max(1, *jobs))
+
+	// Each subagent has its own conversation and stages its edits in its own overlay, so
+	// the shards cannot see or disturb each other's work until the results are merged
+	ctx := context.TODO()
+	queue := make(chan *mapShard)
+	var wg sync.WaitGroup
+	for range max(1, *jobs) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range queue {
+				runMapShard(ctx, client, shard, len(shards), instruction)
+			}
+		}()
+	}
+	for _, shard := range shards {
+		queue <- shard
+	}
+	close(queue)
+	wg.Wait()
+
+	results, err := mergeMapShards(ctx, shards)
+	if err != nil {
+		return err
+	}
+	return printMapSummary(results, shards)
+}
+
+// runMapShard has a subagent apply the instruction to one shard
+func runMapShard(ctx context.Context, client *anthropic.Client, shard *mapShard, total int, instruction string) {
+	fmt.Fprintf(os.Stderr, "%s: shard %d/%d: %s\n", paint(roleStatus, "map"), shard.Index, total, strings.Join(shard.Files, ", "))
+	shard.Changes = newOverlayWorkspace()
+
+	agent := NewAgent(client, nil, shard.Changes.tools())
+	// Subagents run unattended; policy rules that would ask a human deny instead
+	agent.approver = func(string) bool { return false }
+	agent.session = fmt.Sprintf("map-%d", shard.Index)
+
+	list := ""
+	for _, path := range shard.Files {
+		list += "- " + path + "\n"
+	}
+	prompt := fmt.Sprintf(mapShardPrompt, list, instruction)
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt))}
+	conversation, shard.Err = agent.runTurn(ctx, conversation)
+	shard.Notes = manualNotes(lastAssistantText(conversation))
+
+	status := "done"
+	if shard.Err != nil {
+		status = "failed: " + shard.Err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "%s: shard %d/%d %s\n", paint(roleStatus, "map"), shard.Index, total, status)
+	runOptions.events.Emit(Event{Type: eventStatus, Text: fmt.Sprintf("map shard %d/%d %s", shard.Index, total, status)})
+}
+
+// mergeMapShards applies the shards' staged changes to the workspace. A file changed
+// differently by two shards, or changed on disk while the subagents worked, is a conflict
+// and is left alone.
+func mergeMapShards(ctx context.Context, shards []*mapShard) ([]mapResult, error) {
+	results := map[string]*mapResult{}
+	owners := map[string][]*mapShard{}
+	for _, shard := range shards {
+		for _, path := range shard.Files {
+			results[path] = &mapResult{Path: path, Status: mapUnchanged}
+			if shard.Err != nil {
+				results[path].Status, results[path].Detail = mapFailed, shard.Err.Error()
+			}
+		}
+		// A failed subagent may have stopped halfway, so none of its changes are kept
+		if shard.Err != nil {
+			continue
+		}
+		for _, path := range shard.Changes.changed() {
+			owners[path] = append(owners[path], shard)
+		}
+	}
+
+	ready := []string{}
+	for _, path := range sortedKeys(owners) {
+		result := results[path]
+		if result == nil {
+			// Subagents may change files outside their shard, such as a shared helper
+			result = &mapResult{Path: path}
+			results[path] = result
+		}
+
+		first := owners[path][0]
+		before, after, _ := first.Changes.file(path)
+		result.Status, result.Detail = mapChanged, fmt.Sprintf("shard %d", first.Index)
+		for _, other := range owners[path][1:] {
+			if _, otherAfter, _ := other.Changes.file(path); otherAfter != after {
+				result.Status = mapConflict
+				result.Detail = fmt.Sprintf("shards %d and %d changed it differently", first.Index, other.Index)
+				break
+			}
+		}
+		if result.Status == mapChanged && !unchangedOnDisk(path, before) {
+			result.Status, result.Detail = mapConflict, "it changed on disk while the subagents worked"
+		}
+		if result.Status == mapChanged {
+			ready = append(ready, path)
+		}
+	}
+
+	if err := applyMapChanges(ctx, owners, ready); err != nil {
+		return nil, err
+	}
+
+	merged := []mapResult{}
+	for _, path := range sortedKeys(results) {
+		merged = append(merged, *results[path])
+	}
+	return merged, nil
+}
+
+// applyMapChanges writes the merged changes under the current approval policy and mode
+func applyMapChanges(ctx context.Context, owners map[string][]*mapShard, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	if runOptions.Mode == modeDryRun {
+		for _, path := range paths {
+			before, after, _ := owners[path][0].Changes.file(path)
+			diff, err := diffContents(ctx, path, before, after)
+			if err != nil {
+				return err
+			}
+			fmt.Print(colorDiff(diff))
+			runOptions.dryRunChanges.Add(1)
+		}
+		return nil
+	}
+
+	switch runOptions.Approval {
+	case approvalDeny:
+		runOptions.needsHuman.Store(true)
+		return fmt.Errorf("the approval policy denies writing the %d changed file(s)", len(paths))
+	case approvalAsk:
+		agent := &Agent{}
+		if !agent.confirm(fmt.Sprintf("Write the changes to %d file(s): %s? [y/N] ", len(paths), strings.Join(paths, ", "))) {
+			runOptions.needsHuman.Store(true)
+			return fmt.Errorf("the changes were not approved")
+		}
+	}
+
+	for _, path := range paths {
+		_, after, _ := owners[path][0].Changes.file(path)
+		if runOptions.patch != nil {
+			runOptions.patch.Add(path)
+		}
+		if _, err := createNewFile(path, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unchangedOnDisk reports whether path still has the contents a subagent started from
+func unchangedOnDisk(path string, before *string) bool {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return before == nil && os.IsNotExist(err)
+	}
+	return before != nil && string(content) == *before
+}
+
+// printMapSummary reports the outcome of every file and returns an error if any failed
+func printMapSummary(results []mapResult, shards []*mapShard) error {
+	fmt.Println("\nMap summary:")
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+		line := fmt.Sprintf("  %s: %s", result.Path, result.Status)
+		if result.Detail != "" {
+			line += " (" + result.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+	for _, shard := range shards {
+		for _, note := range shard.Notes {
+			fmt.Printf("  - shard %d: %s\n", shard.Index, note)
+		}
+	}
+	fmt.Printf("%d changed, %d unchanged, %d conflict(s), %d failed\n", counts[mapChanged], counts[mapUnchanged], counts[mapConflict], counts[mapFailed])
+
+	if counts[mapConflict]+counts[mapFailed] > 0 {
+		return fmt.Errorf("%d file(s) need attention", counts[mapConflict]+counts[mapFailed])
+	}
+	return nil
+}
+
+// expandFilePatterns returns the files under the working directory, or the --target
+// directory the patterns are relative to, matching any of the patterns, sorted, skipping
+// hidden directories and vendored code
+func expandFilePatterns(patterns []string) ([]string, error) {
+	paths := []string{}
+	root := runOptions.target.dir()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != root && (strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		for _, pattern := range patterns {
+			if matchPathPattern(filepath.ToSlash(pattern), filepath.ToSlash(rel)) {
+				paths = append(paths, path)
+				break
+			}
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// matchPathPattern matches a slash-separated path against a glob where ** stands for
+// any number of directories
+func matchPathPattern(pattern, path string) bool {
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "./"), "/"), strings.Split(path, "/"))
+}
+
+// matchSegments matches path segments against pattern segments
+func matchSegments(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchSegments(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], path[1:])
+}
+
+// =============================================================================
+// OVERLAY WORKSPACE
+// =============================================================================
+
+// overlayWorkspace stages a subagent's edits in memory on top of the working directory
+type overlayWorkspace struct {
+	mu     sync.Mutex
+	before FileSnapshot      // Contents on disk when first edited
+	after  map[string]string // Staged contents
+}
+
+// newOverlayWorkspace creates an overlay with no staged edits
+func newOverlayWorkspace() *overlayWorkspace {
+	return &overlayWorkspace{before: FileSnapshot{}, after: map[string]string{}}
+}
+
+// tools returns the subagent's tools: reads and edits go through the overlay, listing and
+// searching see the working directory
+func (o *overlayWorkspace) tools() []ToolDefinition {
+	readFile := ReadFileDefinition
+	readFile.Function = o.readFile
+	editFile := EditFileDefinition
+	editFile.Function = o.editFile
+	editFile.Mutating = false // Nothing is written until the shards are merged
+	return []ToolDefinition{readFile, ListFilesDefinition, editFile, SearchFilesDefinition}
+}
+
+// readFile implements read_file, returning staged contents when there are any
+func (o *overlayWorkspace) readFile(input json.RawMessage) (string, error) {
+	readFileInput := ReadFileInput{}
+	if err := json.Unmarshal(input, &readFileInput); err != nil {
+		return "", fmt.Errorf("invalid input format: %w", err)
+	}
+
+	o.mu.Lock()
+	staged, ok := o.after[filepath.Clean(readFileInput.Path)]
+	o.mu.Unlock()
+	if ok {
+		return staged, nil
+	}
+	return ReadFile(input)
+}
+
+// editFile implements edit_file by staging the edited contents
+func (o *overlayWorkspace) editFile(input json.RawMessage) (string, error) {
+	editFileInput := EditFileInput{}
+	if err := json.Unmarshal(input, &editFileInput); err != nil {
+		return "", err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	path := filepath.Clean(editFileInput.Path)
+	if staged, ok := o.after[path]; ok {
+		if editFileInput.Path == "" || editFileInput.OldStr == editFileInput.NewStr {
+			return "", fmt.Errorf("invalid input parameters")
+		}
+		newContent, err := replaceEdit(staged, editFileInput)
+		if err != nil {
+			return "", err
+		}
+		o.after[path] = newContent
+		return "OK", nil
+	}
+
+	before, after, err := editedContent(editFileInput)
+	if err != nil {
+		return "", err
+	}
+	o.before[path] = before
+	o.after[path] = after
+	return "OK", nil
+}
+
+// changed returns the files whose staged contents differ from the disk contents, sorted
+func (o *overlayWorkspace) changed() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	changed := []string{}
+	for path, after := range o.after {
+		if before := o.before[path]; before == nil || *before != after {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// file returns a file's original and staged contents
+func (o *overlayWorkspace) file(path string) (*string, string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	after, ok := o.after[path]
+	return o.before[path], after, ok
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// =============================================================================
+// TERMINAL MARKDOWN
+// =============================================================================
+
+// Styles of rendered markdown; the colors of headings and code come from the theme
+const (
+	styleReset  = "\u001b[0m"
+	styleBold   = "\u001b[1m"
+	styleDim    = "\u001b[2m"
+	styleItalic = "\u001b[3m"
+)
+
+var (
+	markdownHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownBullet   = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	markdownNumbered = regexp.MustCompile(`^(\s*)(\d+[.)])\s+(.*)$`)
+	markdownRule     = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	markdownFence    = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([^`\\s]*)")
+	markdownTableSep = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+
+	markdownBold   = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	markdownItalic = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*]*?)\*`)
+	markdownLink   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// renderMarkdown reports whether Claude's answers are rendered rather than printed as
+// raw markdown: in a terminal or the --tui transcript, unless --plain is given
+func renderMarkdown() bool {
+	return !runOptions.Plain && (isTerminal(os.Stdout) || runOptions.tui != nil)
+}
+
+// claudeLabel comes before each of Claude's answers
+func claudeLabel() string {
+	return paint(roleAssistant, "Claude") + ": "
+}
+
+// printAnswer prints a whole answer of Claude's, rendered when renderMarkdown allows
+func printAnswer(text string) {
+	if renderMarkdown() {
+		text = renderMarkdownText(text)
+	}
+	fmt.Printf("%s%s\n", claudeLabel(), text)
+}
+
+// printThinking prints Claude's extended thinking, dimmed and on stderr since it is not
+// part of the answer
+func printThinking(text string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleThinking, "thinking"), paintLines(roleThinking, strings.TrimSpace(text)))
+}
+
+// terminalColumns is the width of the terminal on stdout
+func terminalColumns() int {
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
+
+// visibleWidth is how many columns text takes, leaving out terminal escapes
+func visibleWidth(text string) int {
+	return utf8.RuneCountInString(ansiEscape.ReplaceAllString(text, ""))
+}
+
+// markdownRenderer turns markdown into styled terminal lines one line at a time, so
+// streamed answers can be rendered as they arrive. It handles headings, lists, block
+// quotes, rules, fenced code, tables and inline bold, italics, code and links. Table rows
+// are held until the table ends, since every row is needed to line up the columns.
+type markdownRenderer struct {
+	fence string           // The fence of the code block being rendered, or ""
+	code  *codeHighlighter // Colors the code block, unless its language is unknown
+	table [][]string       // Rows of the table being collected
+	align []string         // Alignment of the table's columns: "left", "right" or "center"
+}
+
+// renderMarkdownText renders a whole answer
+func renderMarkdownText(text string) string {
+	renderer := &markdownRenderer{}
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		lines = append(lines, renderer.line(line)...)
+	}
+	return strings.Join(append(lines, renderer.finish()...), "\n")
+}
+
+// line renders one line of markdown and returns the lines ready to print: none while a
+// table is being collected, and the whole table once a line after it arrives
+func (r *markdownRenderer) line(line string) []string {
+	if r.fence != "" {
+		if strings.HasPrefix(strings.TrimSpace(line), r.fence) {
+			r.fence, r.code = "", nil
+			return []string{styleDim + "╰─" + styleReset}
+		}
+		if r.code != nil {
+			return []string{styleDim + "│ " + styleReset + r.code.line(line)}
+		}
+		return []string{styleDim + "│ " + styleReset + style(roleCode) + line + styleReset}
+	}
+
+	if isTableRow(line) {
+		if len(r.table) == 1 && r.align == nil && markdownTableSep.MatchString(line) {
+			r.align = tableAlignment(line)
+			return nil
+		}
+		if len(r.table) == 0 || r.align != nil {
+			r.table = append(r.table, tableCells(line))
+			return nil
+		}
+	}
+	rendered := r.finish()
+
+	switch {
+	case markdownFence.MatchString(line):
+		match := markdownFence.FindStringSubmatch(line)
+		r.fence, r.code = match[1], newCodeHighlighter(match[2])
+		label := "╭─"
+		if match[2] != "" {
+			label += " " + match[2]
+		}
+		return append(rendered, styleDim+label+styleReset)
+	case markdownHeading.MatchString(line):
+		match := markdownHeading.FindStringSubmatch(line)
+		text := match[2]
+		if len(match[1]) <= 2 {
+			text = strings.ToUpper(text)
+		}
+		return append(rendered, style(roleHeading)+renderInline(text)+styleReset)
+	case markdownRule.MatchString(line):
+		return append(rendered, styleDim+strings.Repeat("─", min(terminalColumns(), 60))+styleReset)
+	case markdownBullet.MatchString(line):
+		match := markdownBullet.FindStringSubmatch(line)
+		return append(rendered, match[1]+"• "+renderInline(match[2]))
+	case markdownNumbered.MatchString(line):
+		match := markdownNumbered.FindStringSubmatch(line)
+		return append(rendered, match[1]+match[2]+" "+renderInline(match[3]))
+	case strings.HasPrefix(strings.TrimSpace(line), ">"):
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ">"))
+		return append(rendered, styleDim+"│ "+styleReset+styleItalic+renderInline(text)+styleReset)
+	}
+	return append(rendered, renderInline(line))
+}
+
+// finish returns what is still held, such as a table at the end of the answer, and resets
+// the renderer for the next one
+func (r *markdownRenderer) finish() []string {
+	rows, align := r.table, r.align
+	r.table, r.align, r.fence, r.code = nil, nil, "", nil
+	switch {
+	case len(rows) == 0:
+		return nil
+	case align == nil:
+		// A single row of pipes without a separator is not a table
+		return []string{renderInline(strings.Join(rows[0], " | "))}
+	}
+
+	widths := []int{}
+	for _, row := range rows {
+		for i, cell := range row {
+			row[i] = renderInline(cell)
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], visibleWidth(row[i]))
+		}
+	}
+	lines := []string{}
+	for n, row := range rows {
+		cells := []string{}
+		for i, width := range widths {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			cellAlign := "left"
+			if i < len(align) {
+				cellAlign = align[i]
+			}
+			cell = padCell(cell, width, cellAlign)
+			if n == 0 {
+				cell = styleBold + cell + styleReset
+			}
+			cells = append(cells, cell)
+		}
+		lines = append(lines, strings.Join(cells, styleDim+" │ "+styleReset))
+		if n == 0 {
+			separators := []string{}
+			for _, width := range widths {
+				separators = append(separators, strings.Repeat("─", width))
+			}
+			lines = append(lines, styleDim+strings.Join(separators, "─┼─")+styleReset)
+		}
+	}
+	return lines
+}
+
+// isTableRow reports whether a line looks like a row of a pipe table
+func isTableRow(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "|") && strings.Count(trimmed, "|") >= 2
+}
+
+// tableCells splits a table row into its cells
+func tableCells(line string) []string {
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(line), "|"), "|")
+	cells := strings.Split(trimmed, "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(cell)
+	}
+	return cells
+}
+
+// tableAlignment reads the alignment of each column from a table's separator row
+func tableAlignment(line string) []string {
+	align := []string{}
+	for _, cell := range tableCells(line) {
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			align = append(align, "center")
+		case strings.HasSuffix(cell, ":"):
+			align = append(align, "right")
+		default:
+			align = append(align, "left")
+		}
+	}
+	return align
+}
+
+// padCell pads a rendered cell to width columns
+func padCell(cell string, width int, align string) string {
+	gap := width - visibleWidth(cell)
+	switch align {
+	case "right":
+		return strings.Repeat(" ", gap) + cell
+	case "center":
+		return strings.Repeat(" ", gap/2) + cell + strings.Repeat(" ", gap-gap/2)
+	}
+	return cell + strings.Repeat(" ", gap)
+}
+
+// renderInline styles bold, italics, code spans and links. Code spans are left as they are
+// otherwise.
+func renderInline(text string) string {
+	var out strings.Builder
+	for i, part := range strings.Split(text, "`") {
+		// Odd parts are inside backticks, unless the last backtick is unmatched
+		if i%2 == 1 && i < strings.Count(text, "`") {
+			out.WriteString(style(roleCode) + part + styleReset)
+			continue
+		}
+		if i%2 == 1 {
+			out.WriteString("`")
+		}
+		part = markdownLink.ReplaceAllString(part, "$1 "+styleDim+"($2)"+styleReset)
+		part = markdownBold.ReplaceAllStringFunc(part, func(match string) string {
+			return styleBold + match[2:len(match)-2] + styleReset
+		})
+		part = markdownItalic.ReplaceAllString(part, "$1"+styleItalic+"$2"+styleReset)
+		out.WriteString(part)
+	}
+	return out.String()
+}
+
+// markdownStream renders a streamed answer line by line. The line being written appears
+// as raw text while it arrives and is redrawn rendered once it is complete, so the answer
+// still shows word by word.
+type markdownStream struct {
+	out      io.Writer
+	renderer markdownRenderer
+	prefix   string          // Label of the answer, written before its first line
+	labeled  bool            // Whether the label was written with rendered output
+	line     strings.Builder // The line being written
+	shown    int             // Bytes of line on screen
+	rows     int             // Rows of raw text above the current one, still to be redrawn
+	logical  bool            // Count lines as written, for the --tui transcript, which wraps them itself
+}
+
+// start begins an answer labeled with prefix
+func (m *markdownStream) start(prefix string) {
+	m.prefix, m.labeled = prefix, false
+}
+
+// write takes the next part of the answer
+func (m *markdownStream) write(text string) {
+	for {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			break
+		}
+		m.line.WriteString(text[:i])
+		text = text[i+1:]
+		m.complete()
+	}
+	m.line.WriteString(text)
+
+	line := m.line.String()
+	if m.shown < len(line) {
+		if m.shown == 0 && m.rows == 0 && !m.labeled {
+			io.WriteString(m.out, m.prefix)
+		}
+		io.WriteString(m.out, line[m.shown:])
+		m.shown = len(line)
+	}
+}
+
+// complete renders the line that just ended, or keeps it on screen as it is while the
+// renderer holds it
+func (m *markdownStream) complete() {
+	raw := m.line.String()
+	m.line.Reset()
+	rendered := m.renderer.line(raw)
+	if len(rendered) == 0 {
+		if m.shown == 0 && m.rows == 0 && !m.labeled {
+			io.WriteString(m.out, m.prefix)
+		}
+		io.WriteString(m.out, raw[m.shown:]+"\n")
+		m.rows += max((m.rawWidth(raw)-1)/m.columns()+1, 1)
+		m.shown = 0
+		return
+	}
+	m.redraw(raw[:m.shown], rendered)
+}
+
+// finish renders what is left at the end of the answer
+func (m *markdownStream) finish() {
+	if m.line.Len() > 0 {
+		m.complete()
+	}
+	if rest := m.renderer.finish(); len(rest) > 0 || m.rows > 0 {
+		m.redraw("", rest)
+	}
+	m.prefix, m.labeled = "", false
+}
+
+// redraw replaces the raw text on screen, the held lines and the shown part of the
+// current line, with rendered lines
+func (m *markdownStream) redraw(partial string, rendered []string) {
+	rows := m.rows
+	if partial != "" {
+		rows += (m.rawWidth(partial) - 1) / m.columns()
+	}
+	erase := "\r"
+	if rows > 0 {
+		erase += fmt.Sprintf("\u001b[%dA", rows)
+	}
+	io.WriteString(m.out, erase+"\u001b[J")
+	if !m.labeled && len(rendered) > 0 {
+		io.WriteString(m.out, m.prefix)
+		m.labeled = true
+	}
+	for _, line := range rendered {
+		io.WriteString(m.out, line+"\n")
+	}
+	m.rows, m.shown = 0, 0
+}
+
+// columns is the width lines wrap at
+func (m *markdownStream) columns() int {
+	if m.logical {
+		return math.MaxInt32
+	}
+	return terminalColumns()
+}
+
+// rawWidth is how many columns a raw line takes, with the label when it comes first
+func (m *markdownStream) rawWidth(raw string) int {
+	width := visibleWidth(raw)
+	if m.rows == 0 && !m.labeled {
+		width += visibleWidth(m.prefix)
+	}
+	return width
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// =============================================================================
+// MATRIX BRIDGE
+// =============================================================================
+
+// matrixSyncTimeout is how long the homeserver may hold a /sync request open
+const matrixSyncTimeout = 25 * time.Second
+
+// matrixMaxMessageLength keeps messages well under the 64 KiB event size limit
+const matrixMaxMessageLength = 30_000
+
+// MatrixBridge answers messages in the rooms its account has joined
+type MatrixBridge struct {
+	api    restClient
+	userID string
+	prefix string
+	txn    atomic.Int64 // Transaction IDs make message sends idempotent
+}
+
+// NewMatrixBridge configures the bridge from MATRIX_HOMESERVER and MATRIX_ACCESS_TOKEN
+func NewMatrixBridge(prefix string) (ChatBridge, error) {
+	homeserver := configValue("MATRIX_HOMESERVER")
+	token := configValue("MATRIX_ACCESS_TOKEN")
+	if homeserver == "" || token == "" {
+		return nil, fmt.Errorf("MATRIX_HOMESERVER and MATRIX_ACCESS_TOKEN are required")
+	}
+
+	bridge := &MatrixBridge{
+		api:    newRESTClient(strings.TrimSuffix(homeserver, "/")+"/_matrix/client/v3", map[string]string{"Authorization": "Bearer " + token}),
+		prefix: prefix,
+	}
+	bridge.txn.Store(time.Now().UnixNano())
+	return bridge, nil
+}
+
+// Name implements ChatBridge
+func (b *MatrixBridge) Name() string { return "matrix " + b.userID }
+
+// matrixSync is the subset of a /sync response the bridge reads
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []struct {
+					Type    string `json:"type"`
+					Sender  string `json:"sender"`
+					Content struct {
+						MsgType string `json:"msgtype"`
+						Body    string `json:"body"`
+					} `json:"content"`
+				} `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct{} `json:"invite"`
+	} `json:"rooms"`
+}
+
+// Run implements ChatBridge with a /sync long-polling loop
+func (b *MatrixBridge) Run(ctx context.Context, handle func(ChatMessage)) error {
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := b.api.getJSON(ctx, "/account/whoami", &whoami); err != nil {
+		return err
+	}
+	b.userID = whoami.UserID
+	localpart, _, _ := strings.Cut(strings.TrimPrefix(b.userID, "@"), ":")
+	fmt.Fprintf(os.Stderr, "matrix: connected as %s\n", b.userID)
+
+	// The first sync only finds where "now" is, so old messages are not replayed as tasks
+	since := ""
+	for first := true; ; first = false {
+		path := fmt.Sprintf("/sync?timeout=%d", matrixSyncTimeout.Milliseconds())
+		if since != "" {
+			path += "&since=" + url.QueryEscape(since)
+		}
+		response := matrixSync{}
+		if err := b.api.getJSON(ctx, path, &response); err != nil {
+			return err
+		}
+		since = response.NextBatch
+
+		for roomID := range response.Rooms.Invite {
+			if err := b.api.sendJSON(ctx, http.MethodPost, "/join/"+url.PathEscape(roomID), map[string]any{}, nil); err != nil {
+				fmt.Fprintf(os.Stderr, "matrix: failed to join %s: %s\n", roomID, err.Error())
+			}
+		}
+		if first {
+			continue
+		}
+
+		for roomID, room := range response.Rooms.Join {
+			for _, event := range room.Timeline.Events {
+				if event.Type != "m.room.message" || event.Content.MsgType != "m.text" || event.Sender == b.userID {
+					continue
+				}
+				text, addressed := addressedText(event.Content.Body, b.prefix, b.userID, localpart)
+				handle(ChatMessage{Room: roomID, Sender: event.Sender, Text: text, Addressed: addressed})
+			}
+		}
+	}
+}
+
+// Send implements ChatBridge
+func (b *MatrixBridge) Send(ctx context.Context, room, text string) error {
+	for _, chunk := range splitMessage(text, matrixMaxMessageLength) {
+		path := fmt.Sprintf("/rooms/%s/send/m.room.message/%d", url.PathEscape(room), b.txn.Add(1))
+		message := map[string]string{"msgtype": "m.text", "body": chunk}
+		if err := b.api.sendJSON(ctx, http.MethodPut, path, message, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// MIGRATION WORKFLOW
+// =============================================================================
+
+// maxGuideTokens caps how much of a migration guide is sent to Claude
+const maxGuideTokens = 50_000
+
+// migrationRulesPrompt asks Claude to index a migration guide into searchable rules
+const migrationRulesPrompt = "Below is a migration guide. Extract every code change it requires as a JSON array of objects with the fields " +
+	"\"id\" (short kebab-case name), \"description\" (what to change and how, including before/after examples), and " +
+	"\"pattern\" (an RE2 regular expression that matches a single line of code affected by the change; keep it specific). " +
+	"Reply with the JSON array only.\n\n<guide>\n%s\n</guide>"
+
+// migrateFilePrompt asks Claude to migrate a single file
+const migrateFilePrompt = "Apply the following migration rules to %s, and only to that file.\n\n%s\n" +
+	"Affected lines found by search:\n%s\n" +
+	"Read the file first, then edit it. If some change can't be made safely, leave that code as it is and end your reply " +
+	"with one line per remaining item starting with `MANUAL:`."
+
+// MigrationRule is one change required by a migration guide
+type MigrationRule struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Pattern     string `json:"pattern"`
+
+	regexp *regexp.Regexp
+}
+
+// FileMigration is the outcome of migrating one file
+type FileMigration struct {
+	Path      string
+	Verified  bool
+	Remaining []string // MANUAL: notes and lines that still match a rule
+}
+
+// runMigrateCommand implements `go-agent migrate --guide <url|file.md>`
+func runMigrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	guide := flags.String("guide", "", "migration guide as a URL or a local markdown/text file")
+	verify := flags.String("verify", cmp.Or(runOptions.project.buildCommand(), runOptions.project.testCommand(), "go build ./..."), "command run after each file to verify the migration")
+	maxIterations := flags.Int("max-iterations", 2, "maximum attempts to make verification pass per file")
+	glob := flags.String("glob", "", "only migrate files matching this name pattern, e.g. *.go")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if *guide == "" {
+		return fmt.Errorf("migrate requires --guide")
+	}
+
+	ctx := context.TODO()
+
+	text, err := loadGuide(ctx, *guide)
+	if err != nil {
+		return err
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	rules, err := extractMigrationRules(ctx, client, text)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: indexed %d rule(s) from %s\n", paint(roleStatus, "migrate"), len(rules), *guide)
+	for _, rule := range rules {
+		fmt.Printf("  - %s: /%s/\n", rule.ID, rule.Pattern)
+	}
+
+	sites, err := findCallSites(ctx, rules, *glob)
+	if err != nil {
+		return err
+	}
+	if len(sites) == 0 {
+		fmt.Println("migrate: no affected code found")
+		return nil
+	}
+
+	results := []FileMigration{}
+	for _, path := range sortedKeys(sites) {
+		fmt.Fprintf(os.Stderr, "%s: %s (%d affected line(s))\n", paint(roleStatus, "migrate"), path, len(sites[path]))
+		result, err := migrateFile(ctx, client, path, rules, sites[path], *verify, *maxIterations)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+
+	printMigrationSummary(results)
+	return nil
+}
+
+// loadGuide reads a migration guide from a URL or file, reducing HTML to text
+func loadGuide(ctx context.Context, source string) (string, error) {
+	var data []byte
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return "", err
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch guide: %w", err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to fetch guide: %s", response.Status)
+		}
+		if data, err = io.ReadAll(response.Body); err != nil {
+			return "", err
+		}
+		if strings.Contains(response.Header.Get("Content-Type"), "html") {
+			data = []byte(htmlToText(string(data)))
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return "", err
+		}
+	}
+
+	return truncateToTokens(string(data), maxGuideTokens), nil
+}
+
+var (
+	htmlNoisePattern = regexp.MustCompile(`(?is)<(script|style|nav|header|footer)\b.*?</(script|style|nav|header|footer)>`)
+	htmlBlockPattern = regexp.MustCompile(`(?i)</?(p|div|br|li|h[1-6]|pre|tr)\b[^>]*>`)
+	htmlTagPattern   = regexp.MustCompile(`<[^>]+>`)
+	blankRunPattern  = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText crudely strips markup from a web page, keeping line structure
+func htmlToText(page string) string {
+	page = htmlNoisePattern.ReplaceAllString(page, "")
+	page = htmlBlockPattern.ReplaceAllString(page, "\n")
+	page = htmlTagPattern.ReplaceAllString(page, "")
+	page = html.UnescapeString(page)
+	return blankRunPattern.ReplaceAllString(page, "\n\n")
+}
+
+// extractMigrationRules asks Claude to turn the guide into rules with search patterns
+func extractMigrationRules(ctx context.Context, client *anthropic.Client, guide string) ([]MigrationRule, error) {
+	reply, err := askClaude(ctx, client, fmt.Sprintf(migrationRulesPrompt, guide), 4096)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("could not find migration rules in Claude's reply")
+	}
+
+	rules := []MigrationRule{}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &rules); err != nil {
+		return nil, fmt.Errorf("invalid migration rules: %w", err)
+	}
+
+	valid := []MigrationRule{}
+	for _, rule := range rules {
+		compiled, err := regexp.Compile(rule.Pattern)
+		if err != nil || rule.Pattern == "" {
+			fmt.Printf("migrate: skipping rule %s with invalid pattern %q\n", rule.ID, rule.Pattern)
+			continue
+		}
+		rule.regexp = compiled
+		valid = append(valid, rule)
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("the guide did not yield any usable migration rules")
+	}
+
+	return valid, nil
+}
+
+// callSite is a line matched by a migration rule
+type callSite struct {
+	Rule *MigrationRule
+	SearchMatch
+}
+
+// findCallSites searches the working directory for lines matching any rule, grouped by file
+func findCallSites(ctx context.Context, rules []MigrationRule, glob string) (map[string][]callSite, error) {
+	sites := map[string][]callSite{}
+	for i := range rules {
+		rule := &rules[i]
+		matches, err := searchFiles(ctx, rule.regexp, ".", glob, 10_000)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			sites[match.Path] = append(sites[match.Path], callSite{Rule: rule, SearchMatch: match})
+		}
+	}
+
+	for _, fileSites := range sites {
+		sort.SliceStable(fileSites, func(i, j int) bool { return fileSites[i].Line < fileSites[j].Line })
+	}
+
+	return sites, nil
+}
+
+// migrateFile has Claude apply the relevant rules to one file, then verifies the result
+func migrateFile(ctx context.Context, client *anthropic.Client, path string, rules []MigrationRule, sites []callSite, verify string, maxIterations int) (FileMigration, error) {
+	result := FileMigration{Path: path}
+
+	relevant := map[string]bool{}
+	var ruleText, siteText strings.Builder
+	for _, site := range sites {
+		fmt.Fprintf(&siteText, "- line %d [%s]: %s\n", site.Line, site.Rule.ID, site.Text)
+		if !relevant[site.Rule.ID] {
+			relevant[site.Rule.ID] = true
+			fmt.Fprintf(&ruleText, "Rule %s: %s\n", site.Rule.ID, site.Rule.Description)
+		}
+	}
+
+	agent := NewAgent(client, nil, defaultTools())
+	prompt := fmt.Sprintf(migrateFilePrompt, path, ruleText.String(), siteText.String())
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt))}
+	conversation, err := agent.runTurn(ctx, conversation)
+	if err != nil {
+		return result, err
+	}
+	result.Remaining = manualNotes(lastAssistantText(conversation))
+
+	check, err := runShellCommand(ctx, verify)
+	if err != nil {
+		return result, err
+	}
+	if !check.Passed() {
+		check, _, err = fixUntilPasses(ctx, agent, check, maxIterations, "migrate")
+		if err != nil {
+			return result, err
+		}
+	}
+	result.Verified = check.Passed()
+
+	// Anything the rules still match was left alone
+	for _, rule := range rules {
+		if !relevant[rule.ID] {
+			continue
+		}
+		matches, err := searchFiles(ctx, rule.regexp, path, "", 10_000)
+		if err != nil {
+			return result, err
+		}
+		for _, match := range matches {
+			result.Remaining = append(result.Remaining, fmt.Sprintf("line %d still matches %s: %s", match.Line, rule.ID, match.Text))
+		}
+	}
+
+	return result, nil
+}
+
+// manualNotes collects the MANUAL: lines from Claude's reply
+func manualNotes(reply string) []string {
+	notes := []string{}
+	for _, line := range strings.Split(reply, "\n") {
+		if note, ok := strings.CutPrefix(strings.TrimSpace(line), "MANUAL:"); ok {
+			notes = append(notes, strings.TrimSpace(note))
+		}
+	}
+	return notes
+}
+
+// printMigrationSummary reports per-file results and the remaining manual work
+func printMigrationSummary(results []FileMigration) {
+	fmt.Println("\nMigration summary:")
+	remaining := 0
+	for _, result := range results {
+		status := "verified"
+		if !result.Verified {
+			status = "verification failing"
+		}
+		fmt.Printf("  %s: %s\n", result.Path, status)
+		for _, note := range result.Remaining {
+			fmt.Printf("    - %s\n", note)
+			remaining++
+		}
+	}
+
+	if remaining == 0 {
+		fmt.Println("No manual work remaining.")
+	} else {
+		fmt.Printf("%d item(s) need manual attention.\n", remaining)
+	}
+}
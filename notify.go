@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// NOTIFICATIONS
+// =============================================================================
+
+// Kinds of notifications, which sinks can subscribe to
+const (
+	notifyTaskFinished   = "task_finished"   // A -p task, subcommand or server message is done
+	notifyApprovalNeeded = "approval_needed" // A question is waiting for a person
+	notifyBudget         = "budget"          // Spend reached a soft budget threshold
+)
+
+// notifyTimeout bounds how long a sink may take, so a dead webhook never stalls the agent
+const notifyTimeout = 10 * time.Second
+
+// Notification is one message for the people following the agent
+type Notification struct {
+	Kind    string    `json:"kind"`
+	Title   string    `json:"title"`
+	Text    string    `json:"text"`
+	Session string    `json:"session,omitempty"` // Server session the notification is about
+	Time    time.Time `json:"time"`
+}
+
+// Notifier delivers notifications to one place
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// NotifierConfig declares one sink in the notifications file
+type NotifierConfig struct {
+	Type string   `json:"type"` // desktop, slack, email or webhook
+	URL  string   `json:"url"`  // Slack incoming webhook or webhook endpoint
+	To   string   `json:"to"`   // Email recipient
+	On   []string `json:"on"`   // Kinds to deliver; all kinds when empty
+}
+
+// Notifications routes notifications to the sinks that subscribe to their kind
+type Notifications struct {
+	sinks []notificationSink
+}
+
+// notificationSink is a notifier with the kinds it receives
+type notificationSink struct {
+	name     string
+	notifier Notifier
+	kinds    []string
+}
+
+// loadNotifications reads a notifications file of {"notifiers": [...]}
+func loadNotifications(path string) (*Notifications, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notifications: %w", err)
+	}
+	var file struct {
+		Notifiers []NotifierConfig `json:"notifiers"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse notifications %s: %w", path, err)
+	}
+
+	notifications := &Notifications{}
+	for i, config := range file.Notifiers {
+		notifier, err := newNotifier(config)
+		if err != nil {
+			return nil, fmt.Errorf("notifications %s: notifier %d: %w", path, i+1, err)
+		}
+		for _, kind := range config.On {
+			if !slices.Contains(notificationKinds, kind) {
+				return nil, fmt.Errorf("notifications %s: notifier %d: unknown kind %q (use %s)", path, i+1, kind, strings.Join(notificationKinds, ", "))
+			}
+		}
+		notifications.sinks = append(notifications.sinks, notificationSink{name: config.Type, notifier: notifier, kinds: config.On})
+	}
+	return notifications, nil
+}
+
+// notificationKinds are the kinds a notifier can subscribe to
+var notificationKinds = []string{notifyTaskFinished, notifyApprovalNeeded, notifyBudget}
+
+// newNotifier creates the notifier a config entry declares
+func newNotifier(config NotifierConfig) (Notifier, error) {
+	switch config.Type {
+	case "desktop":
+		return desktopNotifier{}, nil
+	case "slack", "webhook":
+		if config.URL == "" {
+			return nil, fmt.Errorf("%s needs a url", config.Type)
+		}
+		if config.Type == "slack" {
+			return slackNotifier{url: config.URL}, nil
+		}
+		return webhookNotifier{url: config.URL}, nil
+	case "email":
+		if config.To == "" {
+			return nil, fmt.Errorf("email needs a to address")
+		}
+		return emailNotifier{to: config.To}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q (use desktop, slack, email or webhook)", config.Type)
+	}
+}
+
+// notify delivers a notification to every sink that wants it, all at once, and waits for
+// them. Failures are warnings; a notification never fails the run.
+func (n *Notifications) notify(notification Notification) {
+	if n == nil {
+		return
+	}
+	notification.Time = time.Now().UTC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, sink := range n.sinks {
+		if len(sink.kinds) > 0 && !slices.Contains(sink.kinds, notification.Kind) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sink.notifier.Notify(ctx, notification); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s notification failed: %s\n", paint(roleWarning, "warning"), sink.name, err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// desktopNotifier shows notifications with notify-send on Linux and osascript on macOS
+type desktopNotifier struct{}
+
+// Notify implements Notifier
+func (desktopNotifier) Notify(ctx context.Context, notification Notification) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+		cmd = exec.CommandContext(ctx, "osascript", "-e",
+			fmt.Sprintf(`display notification "%s" with title "%s"`, quote(notification.Text), quote(notification.Title)))
+	case "windows":
+		return fmt.Errorf("desktop notifications are not supported on Windows")
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=go-agent", notification.Title, notification.Text)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if output := strings.TrimSpace(string(output)); output != "" {
+			return fmt.Errorf("%w: %s", err, output)
+		}
+		return err
+	}
+	return nil
+}
+
+// slackNotifier posts to a Slack incoming webhook
+type slackNotifier struct {
+	url string
+}
+
+// Notify implements Notifier
+func (s slackNotifier) Notify(ctx context.Context, notification Notification) error {
+	return postJSON(ctx, s.url, map[string]string{"text": "*" + notification.Title + "*\n" + notification.Text})
+}
+
+// webhookNotifier posts the notification as JSON to any endpoint
+type webhookNotifier struct {
+	url string
+}
+
+// Notify implements Notifier
+func (w webhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	return postJSON(ctx, w.url, notification)
+}
+
+// emailNotifier mails notifications through the SMTP settings of the email adapter
+type emailNotifier struct {
+	to string
+}
+
+// Notify implements Notifier
+func (e emailNotifier) Notify(ctx context.Context, notification Notification) error {
+	return sendEmail(e.to, notification.Title, "", notification.Text)
+}
+
+// postJSON posts v as JSON and fails unless the endpoint answers with a 2xx status
+func postJSON(ctx context.Context, url string, v any) error {
+	body, _ := json.Marshal(v)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"gopkg.in/yaml.v3"
+)
+
+// =============================================================================
+// OPENAPI TOOLS
+// =============================================================================
+
+// OpenAPIConfig lists the HTTP APIs whose operations are offered to Claude as tools.
+// Secrets are never written here: auth names the config keys that hold them.
+type OpenAPIConfig struct {
+	APIs []OpenAPISource `json:"apis"`
+}
+
+// OpenAPISource is one API and the operations it contributes
+type OpenAPISource struct {
+	Name       string            `json:"name"`       // Prefix of the tool names, e.g. billing for billing_getInvoice
+	Spec       string            `json:"spec"`       // OpenAPI 3 spec in JSON or YAML: a path relative to this file, or a URL
+	BaseURL    string            `json:"base_url"`   // Where requests go; defaults to the spec's first server
+	Operations []string          `json:"operations"` // operationId globs or "METHOD /path" patterns to offer; all when empty
+	Exclude    []string          `json:"exclude"`    // Operations never offered, in the same form
+	Headers    map[string]string `json:"headers"`    // Sent with every request
+	Auth       *APIAuth          `json:"auth"`
+}
+
+// APIAuth says how the requests of generated tools authenticate. Token, Username and
+// Password are config keys, read from the environment or config.env when a tool is called.
+type APIAuth struct {
+	Type     string `json:"type"` // bearer, basic, header or query
+	Name     string `json:"name"` // Header or query parameter carrying the token, for header and query auth
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// openAPIOperation is an operation of a spec, with its references resolved
+type openAPIOperation struct {
+	method      string
+	path        string
+	OperationID string             `json:"operationId"`
+	Summary     string             `json:"summary"`
+	Description string             `json:"description"`
+	Parameters  []openAPIParameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema map[string]any `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+// openAPIParameter is a path, query or header parameter of an operation
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description"`
+	Required    bool           `json:"required"`
+	Schema      map[string]any `json:"schema"`
+}
+
+// openAPIMethods are the operations a path item can have, in the order they are listed
+var openAPIMethods = []string{"get", "put", "post", "delete", "patch", "head", "options"}
+
+// openAPIBodyProperty is the tool input property holding the JSON request body
+const openAPIBodyProperty = "body"
+
+// toolNameInvalid matches the characters a tool name cannot have
+var toolNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// loadOpenAPITools reads the config at path and returns a tool for every selected
+// operation of its APIs
+func loadOpenAPITools(ctx context.Context, path string) ([]ToolDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI tools: %w", err)
+	}
+	config := &OpenAPIConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI tools %s: %w", path, err)
+	}
+
+	tools := []ToolDefinition{}
+	names := map[string]string{}
+	for i, source := range config.APIs {
+		if source.Name == "" || source.Spec == "" {
+			return nil, fmt.Errorf("OpenAPI tools %s: API %d needs a name and a spec", path, i+1)
+		}
+		if source.Auth != nil {
+			switch source.Auth.Type {
+			case "bearer", "basic", "header", "query":
+			default:
+				return nil, fmt.Errorf("OpenAPI tools %s: %s: unknown auth type %q", path, source.Name, source.Auth.Type)
+			}
+		}
+		spec := source.Spec
+		if !strings.Contains(spec, "://") && !filepath.IsAbs(spec) {
+			spec = filepath.Join(filepath.Dir(path), spec)
+		}
+		doc, err := readOpenAPISpec(ctx, spec)
+		if err != nil {
+			return nil, fmt.Errorf("OpenAPI tools %s: %s: %w", path, source.Name, err)
+		}
+		operations, err := openAPIOperations(doc)
+		if err != nil {
+			return nil, fmt.Errorf("OpenAPI tools %s: %s: %w", path, source.Name, err)
+		}
+
+		baseURL := source.BaseURL
+		if baseURL == "" {
+			baseURL = specServer(doc, spec)
+		}
+		if baseURL == "" {
+			return nil, fmt.Errorf("OpenAPI tools %s: %s: the spec lists no server, so set base_url", path, source.Name)
+		}
+
+		selected := 0
+		for _, operation := range operations {
+			if (len(source.Operations) > 0 && !matchesOperation(source.Operations, operation)) || matchesOperation(source.Exclude, operation) {
+				continue
+			}
+			tool := source.tool(baseURL, operation)
+			if other, ok := names[tool.Name]; ok {
+				return nil, fmt.Errorf("OpenAPI tools %s: %s %s and %s are both named %s", path, strings.ToUpper(operation.method), operation.path, other, tool.Name)
+			}
+			names[tool.Name] = strings.ToUpper(operation.method) + " " + operation.path
+			tools = append(tools, tool)
+			selected++
+		}
+		if selected == 0 {
+			return nil, fmt.Errorf("OpenAPI tools %s: %s: no operation matches", path, source.Name)
+		}
+	}
+	return tools, nil
+}
+
+// readOpenAPISpec reads a JSON or YAML spec from a file or URL
+func readOpenAPISpec(ctx context.Context, location string) (map[string]any, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		data, err = newRESTClient(location, nil).do(ctx, http.MethodGet, "", nil, "application/json, application/yaml")
+	} else {
+		data, err = os.ReadFile(location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spec: %w", err)
+	}
+
+	// YAML is a superset of JSON, so one decoder reads both
+	var decoded any
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to parse spec %s: %w", location, err)
+	}
+	doc, ok := stringKeys(decoded).(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("spec %s is not an OpenAPI document", location)
+	}
+	if version, _ := doc["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("spec %s is not OpenAPI 3; convert Swagger 2 specs first", location)
+	}
+	return doc, nil
+}
+
+// stringKeys turns the maps YAML decodes with non-string keys, such as response codes,
+// into maps JSON can encode
+func stringKeys(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, item := range value {
+			value[key] = stringKeys(item)
+		}
+		return value
+	case map[any]any:
+		converted := map[string]any{}
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return converted
+	case []any:
+		for i, item := range value {
+			value[i] = stringKeys(item)
+		}
+		return value
+	}
+	return value
+}
+
+// specServer returns the spec's first server URL; a relative one is resolved against
+// the URL the spec came from
+func specServer(doc map[string]any, location string) string {
+	servers, _ := doc["servers"].([]any)
+	if len(servers) == 0 {
+		return ""
+	}
+	server, _ := servers[0].(map[string]any)
+	address, _ := server["url"].(string)
+	if variables, ok := server["variables"].(map[string]any); ok {
+		for name, variable := range variables {
+			value, _ := variable.(map[string]any)["default"].(string)
+			address = strings.ReplaceAll(address, "{"+name+"}", value)
+		}
+	}
+	if strings.Contains(address, "://") {
+		return address
+	}
+	base, err := url.Parse(location)
+	if err != nil || base.Scheme == "" {
+		return ""
+	}
+	resolved, err := base.Parse(address)
+	if err != nil {
+		return ""
+	}
+	return resolved.String()
+}
+
+// openAPIOperations lists the operations of doc, sorted by path and method
+func openAPIOperations(doc map[string]any) ([]openAPIOperation, error) {
+	paths, _ := doc["paths"].(map[string]any)
+	keys := make([]string, 0, len(paths))
+	for key := range paths {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	operations := []openAPIOperation{}
+	for _, key := range keys {
+		item, _ := resolveRefs(doc, paths[key], map[string]bool{}).(map[string]any)
+		shared, _ := item["parameters"].([]any)
+		for _, method := range openAPIMethods {
+			if item[method] == nil {
+				continue
+			}
+			data, _ := json.Marshal(resolveRefs(doc, item[method], map[string]bool{}))
+			operation := openAPIOperation{method: method, path: key}
+			if err := json.Unmarshal(data, &operation); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), key, err)
+			}
+
+			// Parameters of the path apply unless the operation redefines them
+			data, _ = json.Marshal(resolveRefs(doc, shared, map[string]bool{}))
+			inherited := []openAPIParameter{}
+			json.Unmarshal(data, &inherited)
+			for _, parameter := range inherited {
+				if !operation.hasParameter(parameter.Name, parameter.In) {
+					operation.Parameters = append(operation.Parameters, parameter)
+				}
+			}
+			operations = append(operations, operation)
+		}
+	}
+	return operations, nil
+}
+
+// resolveRefs replaces the local references under node with what they point to. A
+// reference met again inside itself, as in a recursive schema, becomes an empty schema
+// that accepts anything.
+func resolveRefs(doc map[string]any, node any, expanding map[string]bool) any {
+	switch node := node.(type) {
+	case map[string]any:
+		if ref, ok := node["$ref"].(string); ok {
+			if expanding[ref] || !strings.HasPrefix(ref, "#/") {
+				return map[string]any{}
+			}
+			var target any = doc
+			for _, segment := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				segment = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+				object, _ := target.(map[string]any)
+				target = object[segment]
+			}
+			expanding[ref] = true
+			defer delete(expanding, ref)
+			return resolveRefs(doc, target, expanding)
+		}
+		resolved := map[string]any{}
+		for key, value := range node {
+			resolved[key] = resolveRefs(doc, value, expanding)
+		}
+		return resolved
+	case []any:
+		resolved := make([]any, len(node))
+		for i, value := range node {
+			resolved[i] = resolveRefs(doc, value, expanding)
+		}
+		return resolved
+	}
+	return node
+}
+
+// hasParameter reports whether the operation defines the parameter
+func (o openAPIOperation) hasParameter(name, in string) bool {
+	for _, parameter := range o.Parameters {
+		if parameter.Name == name && parameter.In == in {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonBody returns the schema of the operation's JSON request body, if it takes one
+func (o openAPIOperation) jsonBody() (map[string]any, bool) {
+	if o.RequestBody == nil {
+		return nil, false
+	}
+	for mediaType, content := range o.RequestBody.Content {
+		if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+			return content.Schema, true
+		}
+	}
+	return nil, false
+}
+
+// matchesOperation reports whether one of selectors picks the operation
+func matchesOperation(selectors []string, operation openAPIOperation) bool {
+	for _, selector := range selectors {
+		if method, pattern, ok := strings.Cut(selector, " "); ok {
+			if strings.EqualFold(method, operation.method) && matchPathPattern(strings.TrimSpace(pattern), operation.path) {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(selector, operation.OperationID); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// tool turns an operation into a tool calling baseURL. Operations other than GET and
+// HEAD change something on the server, so they are mutating and need approval.
+func (s OpenAPISource) tool(baseURL string, operation openAPIOperation) ToolDefinition {
+	id := operation.OperationID
+	if id == "" {
+		id = operation.method + "_" + operation.path
+	}
+	name := strings.Trim(toolNameInvalid.ReplaceAllString(s.Name+"_"+id, "_"), "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	description := strings.TrimSpace(operation.Summary + "\n\n" + operation.Description)
+	description = strings.TrimSpace(fmt.Sprintf("%s\n\n%s %s on the %s API.", truncateRunes(description, 1000), strings.ToUpper(operation.method), operation.path, s.Name))
+
+	properties := map[string]any{}
+	required := []string{}
+	for _, parameter := range operation.Parameters {
+		if parameter.In == "cookie" || (parameter.In == "header" && isReservedHeader(parameter.Name)) {
+			continue
+		}
+		schema := parameter.Schema
+		if schema == nil {
+			schema = map[string]any{"type": "string"}
+		}
+		if parameter.Description != "" {
+			schema = withDescription(schema, parameter.Description)
+		}
+		properties[parameter.Name] = schema
+		if parameter.Required || parameter.In == "path" {
+			required = append(required, parameter.Name)
+		}
+	}
+	if schema, ok := operation.jsonBody(); ok {
+		properties[openAPIBodyProperty] = withDescription(schema, "JSON request body")
+		if operation.RequestBody.Required {
+			required = append(required, openAPIBodyProperty)
+		}
+	}
+
+	return ToolDefinition{
+		Name:        name,
+		Description: description,
+		InputSchema: anthropic.ToolInputSchemaParam{Properties: properties, Required: required},
+		Mutating:    operation.method != "get" && operation.method != "head",
+		Run: func(ctx context.Context, input json.RawMessage) (string, error) {
+			return s.call(ctx, baseURL, operation, input)
+		},
+	}
+}
+
+// withDescription returns a copy of schema with description set, keeping one it has
+func withDescription(schema map[string]any, description string) map[string]any {
+	copied := map[string]any{"description": description}
+	for key, value := range schema {
+		copied[key] = value
+	}
+	return copied
+}
+
+// isReservedHeader reports whether the agent sets the header itself
+func isReservedHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case "authorization", "content-type", "accept":
+		return true
+	}
+	return false
+}
+
+// call sends the operation's request with the tool input and returns the response body
+func (s OpenAPISource) call(ctx context.Context, baseURL string, operation openAPIOperation, input json.RawMessage) (string, error) {
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(input, &values); err != nil {
+		return "", fmt.Errorf("invalid input format: %w", err)
+	}
+
+	headers := map[string]string{"Accept": "application/json"}
+	for key, value := range s.Headers {
+		headers[key] = value
+	}
+	query := url.Values{}
+	requestPath := operation.path
+	for _, parameter := range operation.Parameters {
+		raw, ok := values[parameter.Name]
+		if !ok {
+			if parameter.In == "path" {
+				return "", fmt.Errorf("missing path parameter %s", parameter.Name)
+			}
+			continue
+		}
+		switch parameter.In {
+		case "path":
+			requestPath = strings.ReplaceAll(requestPath, "{"+parameter.Name+"}", url.PathEscape(parameterValue(raw)))
+		case "query":
+			var list []json.RawMessage
+			if json.Unmarshal(raw, &list) == nil {
+				for _, item := range list {
+					query.Add(parameter.Name, parameterValue(item))
+				}
+			} else {
+				query.Set(parameter.Name, parameterValue(raw))
+			}
+		case "header":
+			if !isReservedHeader(parameter.Name) {
+				headers[parameter.Name] = parameterValue(raw)
+			}
+		}
+	}
+	if err := s.Auth.apply(s.Name, headers, query); err != nil {
+		return "", err
+	}
+	if len(query) > 0 {
+		requestPath += "?" + query.Encode()
+	}
+
+	var body any
+	if raw, ok := values[openAPIBodyProperty]; ok {
+		if _, takesBody := operation.jsonBody(); takesBody {
+			body = raw
+		}
+	}
+
+	client := newRESTClient(baseURL, headers)
+	client.http.Timeout = time.Duration(configInt("OPENAPI_TIMEOUT_SECONDS", 30)) * time.Second
+	client.http.Transport = toolTransport{}
+	data, err := client.do(ctx, strings.ToUpper(operation.method), requestPath, body, "")
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 {
+		return "The request succeeded with an empty response.", nil
+	}
+	return string(data), nil
+}
+
+// parameterValue formats a JSON input value the way it appears in a URL or header
+func parameterValue(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	return string(raw)
+}
+
+// apply adds the credentials to the headers or query of a request to api
+func (a *APIAuth) apply(api string, headers map[string]string, query url.Values) error {
+	if a == nil {
+		return nil
+	}
+	secret := func(key string) (string, error) {
+		if key == "" {
+			return "", fmt.Errorf("the %s API's %s auth names no config key", api, a.Type)
+		}
+		value := configValue(key)
+		if value == "" {
+			return "", fmt.Errorf("%s is not set, so the %s API cannot be called", key, api)
+		}
+		return value, nil
+	}
+
+	switch a.Type {
+	case "bearer":
+		token, err := secret(a.Token)
+		if err != nil {
+			return err
+		}
+		headers["Authorization"] = "Bearer " + token
+	case "basic":
+		username, err := secret(a.Username)
+		if err != nil {
+			return err
+		}
+		password, err := secret(a.Password)
+		if err != nil {
+			return err
+		}
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	case "header", "query":
+		token, err := secret(a.Token)
+		if err != nil {
+			return err
+		}
+		if a.Name == "" {
+			return fmt.Errorf("the %s API's %s auth names no parameter", api, a.Type)
+		}
+		if a.Type == "header" {
+			headers[a.Name] = token
+		} else {
+			query.Set(a.Name, token)
+		}
+	}
+	return nil
+}
+
+// runOpenAPICommand implements `go-agent openapi`, listing the tools generated from the
+// --openapi file
+func runOpenAPICommand(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("usage: go-agent --openapi FILE openapi")
+	}
+	if runOptions.OpenAPIFile == "" {
+		return fmt.Errorf("no OpenAPI tools are configured: pass --openapi FILE or set OPENAPI_TOOLS_FILE")
+	}
+
+	printGeneratedTools(runOptions.apiTools, runOptions.OpenAPIFile)
+	return nil
+}
+
+// printGeneratedTools lists tools generated from the config file with their summaries
+func printGeneratedTools(tools []ToolDefinition, file string) {
+	for _, tool := range tools {
+		approval := ""
+		if tool.Mutating {
+			approval = " (needs approval)"
+		}
+		summary, _, _ := strings.Cut(tool.Description, "\n")
+		fmt.Printf("%s%s\n    %s\n", tool.Name, approval, summary)
+	}
+	fmt.Printf("%d tools from %s\n", len(tools), file)
+}
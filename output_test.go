@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the agent itself when GO_AGENT_TEST_ARGS is set, so tests can run it as a
+// separate process and look at what it writes. A sandboxed test command enters the
+// sandbox first, as the agent does.
+func TestMain(m *testing.M) {
+	enterSandbox()
+	if address := os.Getenv("GO_AGENT_TEST_DIAL"); address != "" {
+		connection, err := net.Dial("tcp", address)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		connection.Close()
+		os.Exit(0)
+	}
+	if args := os.Getenv("GO_AGENT_TEST_ARGS"); args != "" {
+		os.Args = []string{"go-agent"}
+		if err := json.Unmarshal([]byte(args), &os.Args); err != nil {
+			panic(err)
+		}
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runAgent runs the agent with args against a fake API that answers every call with
+// text, and returns its stdout and stderr
+func runAgent(t *testing.T, text string, args ...string) (stdout, stderr string) {
+	t.Helper()
+	api := fakeAPI(text)
+	defer api.Close()
+
+	cmd := agentCommand(t, api.URL, args...)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("go-agent %s: %s\n%s", strings.Join(args, " "), err, errOut.String())
+	}
+	return out.String(), errOut.String()
+}
+
+// fakeAPI answers every call of the Messages API with text
+func fakeAPI(text string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "msg_01", "type": "message", "role": "assistant", "model": "claude-sonnet-4-20250514",
+			"content":     []map[string]any{{"type": "text", "text": text}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]any{"input_tokens": 10, "output_tokens": 5},
+		})
+	}))
+}
+
+// agentCommand is the agent with args, using the API at apiURL and a state directory of
+// its own
+func agentCommand(t *testing.T, apiURL string, args ...string) *exec.Cmd {
+	argv, _ := json.Marshal(append([]string{"go-agent"}, args...))
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(),
+		"GO_AGENT_TEST_ARGS="+string(argv),
+		"GO_AGENT_HOME="+t.TempDir(),
+		"ANTHROPIC_API_KEY=sk-ant-REDACTED",
+		"ANTHROPIC_BASE_URL="+apiURL,
+		"STREAM=false",
+		"NO_COLOR=1",
+		"TERM=dumb",
+	)
+	return cmd
+}
+
+func TestOutputJSONKeepsStdoutToEvents(t *testing.T) {
+	stdout, stderr := runAgent(t, "The build passes.", "--output", "json", "--target", "golden", "-p", "Does the build pass?")
+
+	types := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		event := Event{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil || event.Type == "" {
+			t.Fatalf("stdout has a line that is not an event: %q", line)
+		}
+		types = append(types, event.Type)
+	}
+	if !strings.Contains(strings.Join(types, " "), eventAssistantText) {
+		t.Errorf("stdout has no %s event, only %v", eventAssistantText, types)
+	}
+	if !strings.Contains(stderr, "Target: golden") {
+		t.Errorf("stderr does not report the target:\n%s", stderr)
+	}
+}
+
+func TestTextOutputKeepsDiagnosticsOffStdout(t *testing.T) {
+	stdout, stderr := runAgent(t, "The build passes.", "--target", "golden", "-p", "Does the build pass?")
+	if !strings.Contains(stdout, "The build passes.") {
+		t.Errorf("stdout does not have the answer:\n%s", stdout)
+	}
+	if strings.Contains(stdout, "Target:") || !strings.Contains(stderr, "Target: golden") {
+		t.Errorf("the target is reported on stdout rather than stderr:\nstdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// TMUX PANE
+// =============================================================================
+
+// paneCommand keeps the transcript pane open after the session exits, until the user
+// presses enter in it
+const paneCommand = `trap '' INT; while kill -0 %d 2>/dev/null; do sleep 1; done; printf '\n[go-agent exited; press enter to close this pane]'; read _`
+
+// agentPane is the tmux pane that shows a --pane session's transcript and status, and the
+// socket send-to-agent delivers text to
+type agentPane struct {
+	id       string    // tmux pane id such as %3
+	tty      *os.File  // The pane's terminal, which becomes stdout
+	terminal io.Writer // The original terminal, where the user types
+	listener net.Listener
+
+	mu       sync.Mutex
+	received []string // Text from send-to-agent waiting for the next message
+}
+
+// inputSocketPath is where a session started with --pane accepts text from send-to-agent
+func inputSocketPath(pid int) string {
+	return filepath.Join(watchDir(), strconv.Itoa(pid)+".input.sock")
+}
+
+// openPane splits the current tmux window and moves the agent's output into the new pane.
+// Prompts stay in the original pane.
+func openPane() (*agentPane, error) {
+	if os.Getenv("TMUX") == "" {
+		return nil, fmt.Errorf("--pane needs to run inside tmux")
+	}
+
+	result, err := runProgram(context.Background(), "tmux", "split-window", "-h", "-d", "-P", "-F", "#{pane_id} #{pane_tty}",
+		fmt.Sprintf(paneCommand, os.Getpid()))
+	if err != nil {
+		return nil, err
+	}
+	id, ttyPath, ok := strings.Cut(strings.TrimSpace(result.Output), " ")
+	if !result.Passed() || !ok {
+		return nil, fmt.Errorf("tmux split-window failed: %s", strings.TrimSpace(result.Output))
+	}
+	tty, err := os.OpenFile(ttyPath, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the pane's terminal: %w", err)
+	}
+
+	if err := os.MkdirAll(watchDir(), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", watchDir(), err)
+	}
+	path := inputSocketPath(os.Getpid())
+	os.Remove(path) // Left behind by an earlier process with the same pid
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for send-to-agent: %w", err)
+	}
+
+	pane := &agentPane{id: id, tty: tty, terminal: os.Stdout, listener: listener}
+	runProgram(context.Background(), "tmux", "set-option", "-w", "-t", id, "pane-border-status", "top")
+	pane.setStatus("waiting for you")
+	go pane.accept()
+
+	os.Stdout = tty
+	return pane, nil
+}
+
+// accept collects text sent by send-to-agent
+func (p *agentPane) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		text, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil || strings.TrimSpace(string(text)) == "" {
+			continue
+		}
+
+		p.mu.Lock()
+		p.received = append(p.received, string(text))
+		p.mu.Unlock()
+		fmt.Fprintf(p.terminal, "\r\u001b[2K%s: received %d line(s) from send-to-agent; attached to your next message\n%s: ",
+			paint(roleNotice, "pane"), strings.Count(strings.TrimRight(string(text), "\n"), "\n")+1, paint(roleUser, "You"))
+	}
+}
+
+// take returns the text received since the last message as content blocks
+func (p *agentPane) take() []anthropic.ContentBlockParamUnion {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	blocks := []anthropic.ContentBlockParamUnion{}
+	for _, text := range p.received {
+		blocks = append(blocks, anthropic.NewTextBlock("<selection source=\"send-to-agent\">\n"+strings.TrimRight(text, "\n")+"\n</selection>"))
+	}
+	p.received = nil
+	return blocks
+}
+
+// echo copies what the user typed into the transcript
+func (p *agentPane) echo(input string) {
+	if p != nil {
+		fmt.Printf("%s: %s\n", paint(roleUser, "You"), input)
+	}
+}
+
+// setStatus shows the session's state in the pane's border
+func (p *agentPane) setStatus(status string) {
+	runProgram(context.Background(), "tmux", "select-pane", "-t", p.id, "-T", "go-agent: "+status)
+}
+
+// Write follows the session's events to keep the pane status current
+func (p *agentPane) Write(line []byte) (int, error) {
+	var event Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		return len(line), nil
+	}
+	switch event.Type {
+	case eventUserMessage:
+		p.setStatus("thinking")
+	case eventToolUse:
+		p.setStatus("running " + event.Tool)
+	case eventInference:
+		if event.StopReason != string(anthropic.StopReasonToolUse) {
+			p.setStatus("waiting for you")
+		}
+	case eventOutcome:
+		p.setStatus(event.Outcome)
+	}
+	return len(line), nil
+}
+
+// prompt prints a prompt where the user types: the original pane with --pane, the input
+// line with --tui, otherwise stdout
+func prompt(text string) {
+	runOptions.input.setPrompt(text)
+	runOptions.tui.setPrompt(text)
+	fmt.Fprint(promptOutput(), text)
+}
+
+// promptOutput is where prompts and the user's typing are shown
+func promptOutput() io.Writer {
+	if runOptions.tui != nil {
+		return io.Discard // The input line shows them
+	}
+	if runOptions.pane != nil {
+		return runOptions.pane.terminal
+	}
+	return os.Stderr
+}
+
+// close stops accepting text; the pane itself stays open until the user closes it
+func (p *agentPane) close() {
+	if p == nil {
+		return
+	}
+	p.listener.Close()
+	os.Remove(inputSocketPath(os.Getpid()))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// =============================================================================
+// FILE SNAPSHOTS AND PATCHES
+// =============================================================================
+
+// FileSnapshot records file contents before the agent runs so its changes can be
+// reviewed as patches and reverted. A nil entry means the file did not exist.
+type FileSnapshot map[string]*string
+
+// snapshotFiles captures the current contents of paths
+func snapshotFiles(paths []string) FileSnapshot {
+	snapshot := FileSnapshot{}
+	for _, path := range paths {
+		snapshot.Add(path)
+	}
+	return snapshot
+}
+
+// Add captures path if it is not already part of the snapshot
+func (s FileSnapshot) Add(path string) {
+	path = filepath.Clean(path)
+	if _, ok := s[path]; ok {
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		s[path] = nil
+		return
+	}
+	text := string(content)
+	s[path] = &text
+}
+
+// Changed returns the snapshotted files whose contents differ from the snapshot, sorted
+func (s FileSnapshot) Changed() []string {
+	changed := []string{}
+	for path, before := range s {
+		content, err := os.ReadFile(path)
+		switch {
+		case err != nil && before == nil:
+			continue
+		case err != nil || before == nil || string(content) != *before:
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Diff returns a unified diff of path against its snapshot
+func (s FileSnapshot) Diff(ctx context.Context, path string) (string, error) {
+	before, err := runOptions.cleanup.tempFile("go-agent-before-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(before.Name())
+	if original := s[path]; original != nil {
+		before.WriteString(*original)
+	}
+	before.Close()
+
+	after := path
+	if _, err := os.Stat(path); err != nil {
+		after = os.DevNull
+	}
+	return diffFiles(ctx, path, before.Name(), after)
+}
+
+// diffContents returns a unified diff for path from before to after. A nil before means
+// the file is new.
+func diffContents(ctx context.Context, path string, before *string, after string) (string, error) {
+	beforeFile := os.DevNull
+	if before != nil {
+		file, err := runOptions.cleanup.tempFile("go-agent-before-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(file.Name())
+		file.WriteString(*before)
+		file.Close()
+		beforeFile = file.Name()
+	}
+
+	file, err := runOptions.cleanup.tempFile("go-agent-after-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(after)
+	file.Close()
+
+	return diffFiles(ctx, path, beforeFile, file.Name())
+}
+
+// diffFiles runs diff -u on two files, labelling them as path
+func diffFiles(ctx context.Context, path, before, after string) (string, error) {
+	label := filepath.ToSlash(path)
+	result, err := runProgram(ctx, "diff", "-u", "--label", "a/"+label, "--label", "b/"+label, before, after)
+	if err != nil {
+		return "", err
+	}
+
+	// diff exits 1 when the files differ and 2 on trouble
+	if result.ExitCode > 1 {
+		return "", fmt.Errorf("diff failed for %s: %s", path, strings.TrimSpace(result.Output))
+	}
+	return result.Output, nil
+}
+
+// Restore puts path back to its snapshotted contents, removing it if it did not exist
+func (s FileSnapshot) Restore(path string) error {
+	original, ok := s[path]
+	if !ok {
+		return fmt.Errorf("%s is not part of the snapshot", path)
+	}
+	if original == nil {
+		return os.Remove(path)
+	}
+	return os.WriteFile(path, []byte(*original), 0644)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// =============================================================================
+// REMEMBERED APPROVALS
+// =============================================================================
+
+// alwaysChoice is the answer to an approval prompt that remembers the approval
+const alwaysChoice = "always"
+
+// Grant is an approval remembered for a project: a command line a tool may always run, or,
+// for tools that run no command, every call of the tool
+type Grant struct {
+	Tool    string    `json:"tool"`
+	Command string    `json:"command,omitempty"` // Empty for a grant of the whole tool
+	Granted time.Time `json:"granted"`
+}
+
+// describe names what the grant allows
+func (g Grant) describe() string {
+	if g.Command == "" {
+		return g.Tool + " (any call)"
+	}
+	return fmt.Sprintf("%s `%s`", g.Tool, g.Command)
+}
+
+// grantsFile is the remembered approvals of every project, by project root
+type grantsFile struct {
+	Projects map[string][]Grant `json:"projects"`
+}
+
+// grantsMu keeps the sessions of a server from losing each other's grants
+var grantsMu sync.Mutex
+
+// grantsPath is where the remembered approvals are kept
+func grantsPath() string {
+	return filepath.Join(stateDir(), "approvals.json")
+}
+
+// grantsProject is the project approvals are remembered for: the root of the git
+// repository, or the working directory outside one
+var grantsProject = sync.OnceValue(func() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if root, err := gitOutput(ctx, "rev-parse", "--show-toplevel"); err == nil && strings.TrimSpace(root) != "" {
+		return strings.TrimSpace(root)
+	}
+	workspace, _ := os.Getwd()
+	return workspace
+})
+
+// loadGrants reads the remembered approvals, which are empty at first
+func loadGrants() (*grantsFile, error) {
+	grants := &grantsFile{Projects: map[string][]Grant{}}
+	data, err := os.ReadFile(grantsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return grants, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, grants); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", grantsPath(), err)
+	}
+	if grants.Projects == nil {
+		grants.Projects = map[string][]Grant{}
+	}
+	return grants, nil
+}
+
+// save writes the remembered approvals, replacing the file in one step
+func (f *grantsFile) save() error {
+	path := grantsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(f, "", "  ")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// grantFor is the grant that would cover a tool call: its command line when the tool runs
+// one, or the whole tool otherwise
+func grantFor(tool ToolDefinition, input json.RawMessage) Grant {
+	grant := Grant{Tool: tool.Name}
+	if tool.Command != nil {
+		grant.Command = tool.Command(input)
+	}
+	return grant
+}
+
+// granted reports whether the project has a remembered approval for the call
+func granted(grant Grant) bool {
+	grantsMu.Lock()
+	defer grantsMu.Unlock()
+	grants, err := loadGrants()
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(grants.Projects[grantsProject()], func(g Grant) bool {
+		return g.Tool == grant.Tool && g.Command == grant.Command
+	})
+}
+
+// remember saves an approval for the project, so later sessions do not ask again
+func remember(grant Grant) error {
+	grantsMu.Lock()
+	defer grantsMu.Unlock()
+	grants, err := loadGrants()
+	if err != nil {
+		return err
+	}
+	project := grantsProject()
+	if slices.ContainsFunc(grants.Projects[project], func(g Grant) bool { return g.Tool == grant.Tool && g.Command == grant.Command }) {
+		return nil
+	}
+	grant.Granted = time.Now().UTC()
+	grants.Projects[project] = append(grants.Projects[project], grant)
+	return grants.save()
+}
+
+// permissionsCommand implements /permissions: it lists the project's remembered approvals,
+// and revokes them with /permissions revoke N... or /permissions revoke all
+func permissionsCommand(chat *chatState, args string) error {
+	grantsMu.Lock()
+	defer grantsMu.Unlock()
+	grants, err := loadGrants()
+	if err != nil {
+		return err
+	}
+	project := grantsProject()
+	current := grants.Projects[project]
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		if len(current) == 0 {
+			fmt.Printf("No approvals are remembered for %s; answer always when asked to approve a tool call\n", project)
+			return nil
+		}
+		fmt.Printf("Approvals remembered for %s:\n", project)
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for i, grant := range current {
+			fmt.Fprintf(table, "  %d\t%s\t%s\n", i+1, grant.describe(), grant.Granted.Local().Format("2006-01-02 15:04"))
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+		fmt.Println("Revoke them with /permissions revoke N... or /permissions revoke all")
+		return nil
+	}
+	if fields[0] != "revoke" || len(fields) == 1 {
+		return fmt.Errorf("usage: /permissions [revoke N...|revoke all]")
+	}
+	if len(current) == 0 {
+		fmt.Printf("No approvals are remembered for %s\n", project)
+		return nil
+	}
+
+	revoked := []Grant{}
+	if len(fields) == 2 && fields[1] == "all" {
+		revoked, current = current, nil
+	} else {
+		drop := map[int]bool{}
+		for _, field := range fields[1:] {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 1 || n > len(current) {
+				return fmt.Errorf("no remembered approval %s; /permissions lists them", field)
+			}
+			drop[n-1] = true
+		}
+		kept := []Grant{}
+		for i, grant := range current {
+			if drop[i] {
+				revoked = append(revoked, grant)
+			} else {
+				kept = append(kept, grant)
+			}
+		}
+		current = kept
+	}
+	if len(current) == 0 {
+		delete(grants.Projects, project)
+	} else {
+		grants.Projects[project] = current
+	}
+	if err := grants.save(); err != nil {
+		return fmt.Errorf("failed to save the approvals: %w", err)
+	}
+	for _, grant := range revoked {
+		fmt.Printf("Revoked %s\n", grant.describe())
+		chat.agent.events.Emit(Event{Type: eventStatus, Tool: grant.Tool, Text: "remembered approval revoked: " + grant.describe()})
+	}
+	return nil
+}
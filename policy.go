@@ -0,0 +1,853 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/user"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// =============================================================================
+// TOOL POLICY
+// =============================================================================
+
+// Policy decisions
+const (
+	policyAllow = "allow"
+	policyDeny  = "deny"
+	policyAsk   = "ask"
+)
+
+// Policy is an ordered list of rules checked before every tool call. The first rule whose
+// condition holds decides; when none does, the approval policy applies as usual.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule allows, denies or asks about the tool calls its condition matches
+type PolicyRule struct {
+	Name     string `json:"name"`
+	When     string `json:"when"` // Condition over tool, args, mutating, user, session, workspace, mode and ci
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"` // Told to Claude when the call is denied
+
+	condition policyExpr
+}
+
+// loadPolicy reads and compiles a policy file
+func loadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", path, err)
+	}
+
+	if err := policy.compile(); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// compile checks the rules and compiles their conditions
+func (p *Policy) compile() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		switch rule.Decision {
+		case policyAllow, policyDeny, policyAsk:
+		default:
+			return fmt.Errorf("%s: decision must be allow, deny or ask", rule.Name)
+		}
+		if rule.When == "" {
+			rule.When = "true"
+		}
+		var err error
+		if rule.condition, err = compilePolicyExpr(rule.When); err != nil {
+			return fmt.Errorf("%s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// decide returns the first matching rule, or nil when no rule matches. compile has found
+// every mistake that does not depend on args; a condition that still fails to evaluate
+// denies the call, so mistakes in a policy never let calls through.
+func (p *Policy) decide(vars map[string]any) (*PolicyRule, error) {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		value, err := rule.condition(vars)
+		if err != nil {
+			return rule, fmt.Errorf("%s: %w", rule.Name, err)
+		}
+		if matched, ok := value.(bool); !ok {
+			return rule, fmt.Errorf("%s: condition is %s, not a bool", rule.Name, policyTypeName(value))
+		} else if matched {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// authorizeTool decides whether a tool call may run, consulting the managed settings and the
+// policy file before the approval policy. It returns why the call was denied, if it was.
+func (a *Agent) authorizeTool(tool ToolDefinition, input json.RawMessage) (bool, string) {
+	// Managed rules can only deny or ask; an allow leaves the call to the user's policy
+	if managed, _ := managedSettings(); managed != nil {
+		if toolBanned(tool.Name) {
+			return false, "the managed settings"
+		}
+		if managed.policy != nil {
+			if decided, allowed, reason := a.applyPolicy(managed.policy, "Managed policy", tool, input); decided && !allowed {
+				return false, "managed " + reason
+			}
+		}
+	}
+	if reason := runOptions.target.deniesWrite(tool, input); reason != "" {
+		return false, reason
+	}
+	line, hazard := commandHazard(tool, input)
+	if runOptions.policy != nil {
+		if decided, allowed, reason := a.applyPolicy(runOptions.policy, "Policy", tool, input); decided && (!allowed || hazard == "") {
+			return allowed, reason
+		}
+	}
+
+	// Destructive commands always need a typed confirmation, which stands in for approval.
+	// A dry run never runs them, and --approval deny refuses them below.
+	if hazard != "" && runOptions.Mode != modeDryRun && runOptions.Approval != approvalDeny {
+		if !a.guardCommand(tool, line, hazard) {
+			return false, "the destructive command guard, since the command " + hazard
+		}
+		return true, ""
+	}
+
+	// Mutating tools need approval under the active policy, unless a dry run only previews them
+	if tool.Mutating && runOptions.Mode != modeDryRun && !a.approveTool(tool, input) {
+		return false, "the approval policy"
+	}
+	return true, ""
+}
+
+// applyPolicy checks a tool call against a policy. decided is false when no rule matches.
+func (a *Agent) applyPolicy(policy *Policy, label string, tool ToolDefinition, input json.RawMessage) (decided, allowed bool, reason string) {
+	rule, err := policy.decide(a.policyVars(tool, input))
+	if err != nil {
+		return true, false, "the tool policy could not be evaluated (" + err.Error() + ")"
+	}
+	if rule == nil {
+		return false, false, ""
+	}
+	switch rule.Decision {
+	case policyAllow:
+		return true, true, ""
+	case policyAsk:
+		if !runOptions.CI && a.confirm(fmt.Sprintf("%s %q: allow %s(%s)? [y/N] ", label, rule.Name, tool.Name, input)) {
+			return true, true, ""
+		}
+	}
+	reason = "policy rule " + strconv.Quote(rule.Name)
+	if rule.Reason != "" {
+		reason += ": " + rule.Reason
+	}
+	return true, false, reason
+}
+
+// policyVars is what policy conditions can refer to
+func (a *Agent) policyVars(tool ToolDefinition, input json.RawMessage) map[string]any {
+	var args any
+	if err := json.Unmarshal(input, &args); err != nil {
+		args = map[string]any{}
+	}
+
+	user := a.user
+	if user == "" {
+		user = localUsername()
+	}
+	workspace, _ := os.Getwd()
+
+	return map[string]any{
+		"tool":      tool.Name,
+		"args":      args,
+		"mutating":  tool.Mutating,
+		"user":      user,
+		"session":   a.session,
+		"workspace": workspace,
+		"mode":      runOptions.Mode,
+		"ci":        runOptions.CI,
+	}
+}
+
+// policyVarTypes are the variables of policyVars and their types, which conditions are
+// checked against when they are compiled. args is whatever the call's input holds.
+var policyVarTypes = map[string]policyType{
+	"tool":      policyTypeString,
+	"args":      policyTypeAny,
+	"mutating":  policyTypeBool,
+	"user":      policyTypeString,
+	"session":   policyTypeString,
+	"workspace": policyTypeString,
+	"mode":      policyTypeString,
+	"ci":        policyTypeBool,
+}
+
+// localUsername names the user running the agent
+func localUsername() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// =============================================================================
+// POLICY EXPRESSIONS
+// =============================================================================
+
+// policyExpr is a compiled condition. Conditions use a small subset of CEL: literals
+// (strings, numbers, true, false, null and [lists]), variables, field access with . and [],
+// ! && || == != < <= > >= in, and the methods startsWith, endsWith, contains, matches
+// (regular expressions), lower and size. Missing fields are null.
+type policyExpr func(vars map[string]any) (any, error)
+
+// policyType is what an expression is known to produce before it runs, named as
+// policyTypeName names values. Unknown variables and type errors are found when a
+// condition is compiled, except where they depend on args, whose fields have
+// policyTypeAny and are only checked when the condition runs.
+type policyType string
+
+// Expression types
+const (
+	policyTypeAny    policyType = ""
+	policyTypeNull   policyType = "null"
+	policyTypeBool   policyType = "a bool"
+	policyTypeNumber policyType = "a number"
+	policyTypeString policyType = "a string"
+	policyTypeList   policyType = "a list"
+	policyTypeMap    policyType = "a map"
+)
+
+// is reports whether an expression of type t may produce one of types
+func (t policyType) is(types ...policyType) bool {
+	return t == policyTypeAny || slices.Contains(types, t)
+}
+
+// String implements fmt.Stringer for error messages
+func (t policyType) String() string {
+	if t == policyTypeAny {
+		return "any value"
+	}
+	return string(t)
+}
+
+// compilePolicyExpr parses and type-checks a condition
+func compilePolicyExpr(source string) (policyExpr, error) {
+	tokens, err := lexPolicyExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &policyParser{tokens: tokens}
+	expr, typ, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+	if next := parser.peek(); next.kind != policyEOF {
+		return nil, fmt.Errorf("unexpected %q in condition", next.text)
+	}
+	if !typ.is(policyTypeBool) {
+		return nil, fmt.Errorf("condition is %s, not a bool", typ)
+	}
+	return expr, nil
+}
+
+// Token kinds
+const (
+	policyEOF = iota
+	policyIdent
+	policyString
+	policyNumber
+	policyOperator
+)
+
+// policyToken is one lexical token of a condition
+type policyToken struct {
+	kind int
+	text string // The operator or identifier, or the decoded string literal
+	num  float64
+}
+
+// lexPolicyExpr splits a condition into tokens
+func lexPolicyExpr(source string) ([]policyToken, error) {
+	tokens := []policyToken{}
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, policyToken{kind: policyIdent, text: string(runes[start:i])})
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			num, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", string(runes[start:i]))
+			}
+			tokens = append(tokens, policyToken{kind: policyNumber, text: string(runes[start:i]), num: num})
+		case r == '\'' || r == '"':
+			var text strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string in condition")
+				}
+				if runes[i] == r {
+					i++
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case 'n':
+						text.WriteRune('\n')
+					case 't':
+						text.WriteRune('\t')
+					case '\\', '\'', '"':
+						text.WriteRune(runes[i])
+					default:
+						// Keep other escapes, such as \. in regular expressions
+						text.WriteRune('\\')
+						text.WriteRune(runes[i])
+					}
+					continue
+				}
+				text.WriteRune(runes[i])
+			}
+			tokens = append(tokens, policyToken{kind: policyString, text: text.String()})
+		default:
+			operator := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "(", ")", "[", "]", ".", ","} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected %q in condition", string(r))
+			}
+			tokens = append(tokens, policyToken{kind: policyOperator, text: operator})
+			i += len(operator)
+		}
+	}
+	return append(tokens, policyToken{kind: policyEOF}), nil
+}
+
+// policyParser is a recursive descent parser producing closures
+type policyParser struct {
+	tokens []policyToken
+	pos    int
+}
+
+// peek returns the next token without consuming it
+func (p *policyParser) peek() policyToken {
+	return p.tokens[p.pos]
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *policyParser) accept(text string) bool {
+	if next := p.peek(); (next.kind == policyOperator || next.kind == policyIdent) && next.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the given operator or fails
+func (p *policyParser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %q in condition", text)
+	}
+	return nil
+}
+
+// or parses a || b
+func (p *policyParser) or() (policyExpr, policyType, error) {
+	left, leftType, err := p.and()
+	if err != nil {
+		return nil, "", err
+	}
+	for p.accept("||") {
+		right, rightType, err := p.and()
+		if err != nil {
+			return nil, "", err
+		}
+		if err := checkLogical(leftType, rightType); err != nil {
+			return nil, "", err
+		}
+		left, leftType = policyLogic(left, right, true), policyTypeBool
+	}
+	return left, leftType, nil
+}
+
+// and parses a && b
+func (p *policyParser) and() (policyExpr, policyType, error) {
+	left, leftType, err := p.relation()
+	if err != nil {
+		return nil, "", err
+	}
+	for p.accept("&&") {
+		right, rightType, err := p.relation()
+		if err != nil {
+			return nil, "", err
+		}
+		if err := checkLogical(leftType, rightType); err != nil {
+			return nil, "", err
+		}
+		left, leftType = policyLogic(left, right, false), policyTypeBool
+	}
+	return left, leftType, nil
+}
+
+// checkLogical fails for operands of && and || that cannot be bools
+func checkLogical(operands ...policyType) error {
+	for _, operand := range operands {
+		if !operand.is(policyTypeBool) {
+			return fmt.Errorf("logical operand is %s, not a bool", operand)
+		}
+	}
+	return nil
+}
+
+// policyLogic short-circuits || (when or is set) and &&
+func policyLogic(left, right policyExpr, or bool) policyExpr {
+	return func(vars map[string]any) (any, error) {
+		for _, operand := range []policyExpr{left, right} {
+			value, err := operand(vars)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("logical operand is %s, not a bool", policyTypeName(value))
+			}
+			if b == or {
+				return or, nil
+			}
+		}
+		return !or, nil
+	}
+}
+
+// relation parses comparisons and membership
+func (p *policyParser) relation() (policyExpr, policyType, error) {
+	left, leftType, err := p.unary()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, operator := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if !p.accept(operator) {
+			continue
+		}
+		right, rightType, err := p.unary()
+		if err != nil {
+			return nil, "", err
+		}
+		if err := checkCompare(operator, leftType, rightType); err != nil {
+			return nil, "", err
+		}
+		return func(vars map[string]any) (any, error) {
+			a, err := left(vars)
+			if err != nil {
+				return nil, err
+			}
+			b, err := right(vars)
+			if err != nil {
+				return nil, err
+			}
+			return policyCompare(operator, a, b)
+		}, policyTypeBool, nil
+	}
+	return left, leftType, nil
+}
+
+// checkCompare fails for operands policyCompare would always reject
+func checkCompare(operator string, a, b policyType) error {
+	switch operator {
+	case "==", "!=":
+		return nil
+	case "in":
+		if !b.is(policyTypeList, policyTypeMap) {
+			return fmt.Errorf("in needs a list or map, not %s", b)
+		}
+		return nil
+	}
+	if !a.is(policyTypeNumber, policyTypeString) || !b.is(policyTypeNumber, policyTypeString) ||
+		(a != policyTypeAny && b != policyTypeAny && a != b) {
+		return fmt.Errorf("cannot compare %s %s %s", a, operator, b)
+	}
+	return nil
+}
+
+// policyCompare applies a comparison operator
+func policyCompare(operator string, a, b any) (any, error) {
+	switch operator {
+	case "==":
+		return policyEqual(a, b), nil
+	case "!=":
+		return !policyEqual(a, b), nil
+	case "in":
+		switch container := b.(type) {
+		case []any:
+			for _, item := range container {
+				if policyEqual(a, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			key, ok := a.(string)
+			_, found := container[key]
+			return ok && found, nil
+		}
+		return nil, fmt.Errorf("in needs a list or map, not %s", policyTypeName(b))
+	}
+
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return map[string]bool{"<": x < y, "<=": x <= y, ">": x > y, ">=": x >= y}[operator], nil
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return map[string]bool{"<": x < y, "<=": x <= y, ">": x > y, ">=": x >= y}[operator], nil
+		}
+	}
+	return nil, fmt.Errorf("cannot compare %s %s %s", policyTypeName(a), operator, policyTypeName(b))
+}
+
+// policyEqual compares values decoded from JSON
+func policyEqual(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+// unary parses !a
+func (p *policyParser) unary() (policyExpr, policyType, error) {
+	if p.accept("!") {
+		operand, operandType, err := p.unary()
+		if err != nil {
+			return nil, "", err
+		}
+		if !operandType.is(policyTypeBool) {
+			return nil, "", fmt.Errorf("! needs a bool, not %s", operandType)
+		}
+		return func(vars map[string]any) (any, error) {
+			value, err := operand(vars)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("! needs a bool, not %s", policyTypeName(value))
+			}
+			return !b, nil
+		}, policyTypeBool, nil
+	}
+	return p.postfix()
+}
+
+// postfix parses field access, indexing and method calls
+func (p *policyParser) postfix() (policyExpr, policyType, error) {
+	expr, typ, err := p.primary()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			name := p.peek()
+			if name.kind != policyIdent {
+				return nil, "", fmt.Errorf("expected a field or method name after '.'")
+			}
+			p.pos++
+			if p.accept("(") {
+				args, argTypes, err := p.list(")")
+				if err != nil {
+					return nil, "", err
+				}
+				if expr, typ, err = policyMethod(expr, typ, name.text, args, argTypes); err != nil {
+					return nil, "", err
+				}
+				continue
+			}
+			if !typ.is(policyTypeMap, policyTypeNull) {
+				return nil, "", fmt.Errorf("%s has no field %s", typ, name.text)
+			}
+			expr, typ = policyIndex(expr, policyConstant(name.text)), policyTypeAny
+		case p.accept("["):
+			index, indexType, err := p.or()
+			if err != nil {
+				return nil, "", err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, "", err
+			}
+			switch {
+			case !typ.is(policyTypeMap, policyTypeList, policyTypeNull):
+				return nil, "", fmt.Errorf("cannot index %s", typ)
+			case typ == policyTypeMap && !indexType.is(policyTypeString):
+				return nil, "", fmt.Errorf("map keys are strings, not %s", indexType)
+			}
+			expr, typ = policyIndex(expr, index), policyTypeAny
+		default:
+			return expr, typ, nil
+		}
+	}
+}
+
+// policyConstant is an expression with a fixed value
+func policyConstant(value any) policyExpr {
+	return func(map[string]any) (any, error) { return value, nil }
+}
+
+// policyIndex looks up a map key or list element; missing keys are null
+func policyIndex(container, index policyExpr) policyExpr {
+	return func(vars map[string]any) (any, error) {
+		value, err := container(vars)
+		if err != nil {
+			return nil, err
+		}
+		key, err := index(vars)
+		if err != nil {
+			return nil, err
+		}
+		switch value := value.(type) {
+		case map[string]any:
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("map keys are strings, not %s", policyTypeName(key))
+			}
+			return value[name], nil
+		case []any:
+			n, ok := key.(float64)
+			if !ok || n < 0 || int(n) >= len(value) {
+				return nil, nil
+			}
+			return value[int(n)], nil
+		case nil:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot index %s", policyTypeName(value))
+	}
+}
+
+// policyMethods are the methods conditions can call, with their result types
+var policyMethods = map[string]policyType{
+	"startsWith": policyTypeBool,
+	"endsWith":   policyTypeBool,
+	"contains":   policyTypeBool,
+	"matches":    policyTypeBool,
+	"lower":      policyTypeString,
+	"size":       policyTypeNumber,
+}
+
+// policyMethod calls a string or collection method
+func policyMethod(receiver policyExpr, receiverType policyType, name string, args []policyExpr, argTypes []policyType) (policyExpr, policyType, error) {
+	result, ok := policyMethods[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown method %s", name)
+	}
+	want := 1
+	if name == "lower" || name == "size" {
+		want = 0
+	}
+	if len(args) != want {
+		return nil, "", fmt.Errorf("%s takes %d argument(s)", name, want)
+	}
+	switch {
+	case name == "size" && !receiverType.is(policyTypeString, policyTypeList, policyTypeMap):
+		return nil, "", fmt.Errorf("size of %s", receiverType)
+	case name != "size" && !receiverType.is(policyTypeString):
+		return nil, "", fmt.Errorf("%s needs a string, not %s", name, receiverType)
+	case want == 1 && !argTypes[0].is(policyTypeString):
+		return nil, "", fmt.Errorf("%s needs a string argument, not %s", name, argTypes[0])
+	}
+	// A pattern that does not depend on the call is compiled now, so a typo in it is found
+	// before any call; evaluating it with no variables fails otherwise
+	if name == "matches" {
+		if pattern, err := args[0](nil); err == nil {
+			if pattern, ok := pattern.(string); ok {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return nil, "", err
+				}
+			}
+		}
+	}
+
+	return func(vars map[string]any) (any, error) {
+		value, err := receiver(vars)
+		if err != nil {
+			return nil, err
+		}
+		if name == "size" {
+			switch value := value.(type) {
+			case string:
+				return float64(len([]rune(value))), nil
+			case []any:
+				return float64(len(value)), nil
+			case map[string]any:
+				return float64(len(value)), nil
+			}
+			return nil, fmt.Errorf("size of %s", policyTypeName(value))
+		}
+
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a string, not %s", name, policyTypeName(value))
+		}
+		if name == "lower" {
+			return strings.ToLower(text), nil
+		}
+		argument, err := args[0](vars)
+		if err != nil {
+			return nil, err
+		}
+		pattern, ok := argument.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a string argument, not %s", name, policyTypeName(argument))
+		}
+
+		switch name {
+		case "startsWith":
+			return strings.HasPrefix(text, pattern), nil
+		case "endsWith":
+			return strings.HasSuffix(text, pattern), nil
+		case "contains":
+			return strings.Contains(text, pattern), nil
+		default:
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(text), nil
+		}
+	}, result, nil
+}
+
+// primary parses literals, variables, parentheses and lists
+func (p *policyParser) primary() (policyExpr, policyType, error) {
+	token := p.peek()
+	p.pos++
+	switch token.kind {
+	case policyString:
+		return policyConstant(token.text), policyTypeString, nil
+	case policyNumber:
+		return policyConstant(token.num), policyTypeNumber, nil
+	case policyIdent:
+		switch token.text {
+		case "true":
+			return policyConstant(true), policyTypeBool, nil
+		case "false":
+			return policyConstant(false), policyTypeBool, nil
+		case "null":
+			return policyConstant(nil), policyTypeNull, nil
+		}
+		name := token.text
+		typ, ok := policyVarTypes[name]
+		if !ok {
+			names := slices.Sorted(maps.Keys(policyVarTypes))
+			return nil, "", fmt.Errorf("unknown variable %s; conditions can use %s", name, strings.Join(names, ", "))
+		}
+		return func(vars map[string]any) (any, error) {
+			value, ok := vars[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown variable %s", name)
+			}
+			return value, nil
+		}, typ, nil
+	case policyOperator:
+		switch token.text {
+		case "(":
+			expr, typ, err := p.or()
+			if err != nil {
+				return nil, "", err
+			}
+			return expr, typ, p.expect(")")
+		case "[":
+			items, _, err := p.list("]")
+			if err != nil {
+				return nil, "", err
+			}
+			return func(vars map[string]any) (any, error) {
+				values := []any{}
+				for _, item := range items {
+					value, err := item(vars)
+					if err != nil {
+						return nil, err
+					}
+					values = append(values, value)
+				}
+				return values, nil
+			}, policyTypeList, nil
+		}
+	case policyEOF:
+		return nil, "", fmt.Errorf("condition ends unexpectedly")
+	}
+	return nil, "", fmt.Errorf("unexpected %q in condition", token.text)
+}
+
+// list parses comma-separated expressions up to the closing operator
+func (p *policyParser) list(closing string) ([]policyExpr, []policyType, error) {
+	items, types := []policyExpr{}, []policyType{}
+	if p.accept(closing) {
+		return items, types, nil
+	}
+	for {
+		item, typ, err := p.or()
+		if err != nil {
+			return nil, nil, err
+		}
+		items, types = append(items, item), append(types, typ)
+		if p.accept(closing) {
+			return items, types, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// policyTypeName describes a value's type in error messages
+func policyTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a bool"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "a map"
+	}
+	return fmt.Sprintf("%T", value)
+}
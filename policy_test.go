@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testPolicyVars are the variables of a call of edit_file by ann
+var testPolicyVars = map[string]any{
+	"tool":      "edit_file",
+	"args":      map[string]any{"path": "cmd/main.go", "size": 120.0, "tags": []any{"a", "b"}},
+	"mutating":  true,
+	"user":      "ann",
+	"session":   "s1",
+	"workspace": "/src/main",
+	"mode":      "",
+	"ci":        false,
+}
+
+func TestPolicyExpr(t *testing.T) {
+	tests := []struct {
+		when string
+		want bool
+	}{
+		// Precedence: ! binds tightest, then comparisons, &&, ||
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"!(tool == 'read_file') && mutating", true},
+		{"tool == 'read_file' || user == 'ann' && !ci", true},
+
+		{"user in ['ann', 'bob']", true},
+		{"user in ['bob']", false},
+		{"'path' in args", true},
+		{"'missing' in args", false},
+		{"'a' in args.tags", true},
+
+		{"args.path.startsWith('cmd/')", true},
+		{"args.path.endsWith('.go')", true},
+		{"args.path.contains('main')", true},
+		{`args.path.matches('^cmd/[a-z]+\\.go$')`, true},
+		{"args.path.matches('^internal/')", false},
+		{"'EDIT_FILE'.lower() == tool", true},
+		{"tool.size() == 9 && args.tags.size() == 2 && args.size() == 3", true},
+
+		{"args.size > 100", true},
+		{"args['path'] == 'cmd/main.go'", true},
+		{"args.tags[1] == 'b'", true},
+		{"args.missing == null && args.missing.deeper == null", true},
+		{"args.tags[5] == null", true},
+		{"workspace >= '/src' && mode == ''", true},
+	}
+	for _, test := range tests {
+		t.Run(test.when, func(t *testing.T) {
+			expr, err := compilePolicyExpr(test.when)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := expr(testPolicyVars)
+			if err != nil || got != test.want {
+				t.Errorf("= %v (%v), want %v", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCompileErrors(t *testing.T) {
+	tests := []struct {
+		when, err string
+	}{
+		{"tol == 'edit_file'", "unknown variable tol; conditions can use args, ci, mode"},
+		{"args.path.startswith('cmd/')", "unknown method startswith"},
+		{"tool.contains()", "contains takes 1 argument(s)"},
+		{"tool", "condition is a string, not a bool"},
+		{"args.size + 1", `unexpected "+" in condition`},
+		{"tool == ", "condition ends unexpectedly"},
+		{"tool == 'edit_file", "unterminated string"},
+		{"tool && ci", "logical operand is a string, not a bool"},
+		{"ci || user.size()", "logical operand is a number, not a bool"},
+		{"!user", "! needs a bool, not a string"},
+		{"tool < 3", "cannot compare a string < a number"},
+		{"mutating > false", "cannot compare a bool > a bool"},
+		{"user in 'ann'", "in needs a list or map, not a string"},
+		{"mutating.startsWith('t')", "startsWith needs a string, not a bool"},
+		{"tool.startsWith(1)", "startsWith needs a string argument, not a number"},
+		{"ci.size() > 0", "size of a bool"},
+		{"tool.name == 'x'", "a string has no field name"},
+		{"user[0] == 'a'", "cannot index a string"},
+		{"tool.matches('(')", "missing closing )"},
+	}
+	for _, test := range tests {
+		t.Run(test.when, func(t *testing.T) {
+			_, err := compilePolicyExpr(test.when)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("compiling gives %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestPolicyEvaluationErrorsDeny(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Name: "small edits", When: "args.size.startsWith('1')", Decision: policyAllow},
+	}}
+	if err := policy.compile(); err != nil {
+		t.Fatal(err)
+	}
+	rule, err := policy.decide(testPolicyVars)
+	if err == nil || rule == nil || !strings.Contains(err.Error(), "startsWith needs a string, not a number") {
+		t.Errorf("decide() = %v, %v; want the rule and a type error", rule, err)
+	}
+	agent := &Agent{}
+	decided, allowed, reason := agent.applyPolicy(policy, "Policy", ToolDefinition{Name: "edit_file"}, json.RawMessage(`{"size": 120}`))
+	if !decided || allowed || !strings.Contains(reason, "could not be evaluated") {
+		t.Errorf("applyPolicy() = %v, %v, %q; want the call denied", decided, allowed, reason)
+	}
+}
+
+func TestPolicyFirstMatchDecides(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Name: "no secrets", When: "tool == 'edit_file' && args.path.contains('secret')", Decision: policyDeny},
+		{When: "tool == 'edit_file'", Decision: policyAllow},
+	}}
+	if err := policy.compile(); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{`{"path": "secret.txt"}`: "no secrets", `{"path": "main.go"}`: "rule 2"}
+	for input, want := range tests {
+		vars := (&Agent{}).policyVars(ToolDefinition{Name: "edit_file"}, json.RawMessage(input))
+		rule, err := policy.decide(vars)
+		if err != nil || rule == nil || rule.Name != want {
+			t.Errorf("%s matches %v (%v), want %s", input, rule, err, want)
+		}
+	}
+	if rule, err := policy.decide((&Agent{}).policyVars(ToolDefinition{Name: "read_file"}, json.RawMessage(`{"path": "secret.txt"}`))); rule != nil || err != nil {
+		t.Errorf("read_file matches %v (%v), want no rule", rule, err)
+	}
+}
+
+func TestManagedDenyOverridesUserAllow(t *testing.T) {
+	managed := &ManagedSettings{path: "managed-settings.json", Rules: []PolicyRule{
+		{Name: "no go.mod", When: "args.path == 'go.mod'", Decision: policyDeny, Reason: "dependencies are reviewed"},
+		{Name: "managed allow", When: "true", Decision: policyAllow},
+	}}
+	managed.policy = &Policy{Rules: managed.Rules}
+	user := &Policy{Rules: []PolicyRule{
+		{Name: "user allow", When: "true", Decision: policyAllow},
+	}}
+	for _, policy := range []*Policy{managed.policy, user} {
+		if err := policy.compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original := managedSettings
+	managedSettings = func() (*ManagedSettings, error) { return managed, nil }
+	defer func() { managedSettings = original }()
+	defer func(policy *Policy, approval string) { runOptions.policy, runOptions.Approval = policy, approval }(runOptions.policy, runOptions.Approval)
+	runOptions.policy, runOptions.Approval = user, approvalDeny
+
+	agent := &Agent{}
+	tool := ToolDefinition{Name: "edit_file", Mutating: true}
+	if allowed, reason := agent.authorizeTool(tool, json.RawMessage(`{"path": "go.mod"}`)); allowed || !strings.Contains(reason, "dependencies are reviewed") {
+		t.Errorf("the managed deny gave %v, %q", allowed, reason)
+	}
+	// A managed allow leaves the call to the user's policy, which allows it under --approval deny
+	if allowed, reason := agent.authorizeTool(tool, json.RawMessage(`{"path": "main.go"}`)); !allowed {
+		t.Errorf("the user's allow was overridden: %q", reason)
+	}
+}
+
+func TestPolicyVarTypesMatchPolicyVars(t *testing.T) {
+	vars := (&Agent{}).policyVars(ToolDefinition{Name: "edit_file"}, json.RawMessage(`{}`))
+	if got, want := slices.Sorted(maps.Keys(vars)), slices.Sorted(maps.Keys(policyVarTypes)); !slices.Equal(got, want) {
+		t.Fatalf("policyVars has %v, policyVarTypes %v", got, want)
+	}
+	for name, value := range vars {
+		if typ := policyVarTypes[name]; !typ.is(policyType(policyTypeName(value))) {
+			t.Errorf("%s is %s, declared as %s", name, policyTypeName(value), typ)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// DIRECTORY PRESETS
+// =============================================================================
+
+// Presets adapt the agent to the part of the repository it is started in. Every preset
+// whose path matches applies, in order, so later presets override earlier ones.
+type Presets struct {
+	Presets []Preset `json:"presets"`
+}
+
+// Preset sets the model, mode and tools for the directories its path matches
+type Preset struct {
+	Path         string   `json:"path"`          // Glob over the directory relative to the repository root, e.g. infra/**
+	Model        string   `json:"model"`         // Model of the agent
+	Mode         string   `json:"mode"`          // Workspace mode when --mode is not given
+	Tools        []string `json:"tools"`         // Only these tools are offered, when set
+	ExcludeTools []string `json:"exclude_tools"` // Tools never offered
+	Shell        *bool    `json:"shell"`         // false turns off ! and /run in the chat and --context-cmd
+}
+
+// loadPresets reads a presets file and merges the presets matching dir, a slash-separated
+// path relative to the repository root. It returns nil when none matches.
+func loadPresets(path, dir string) (*Preset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presets: %w", err)
+	}
+	presets := &Presets{}
+	if err := json.Unmarshal(data, presets); err != nil {
+		return nil, fmt.Errorf("failed to parse presets %s: %w", path, err)
+	}
+
+	var merged *Preset
+	for i, preset := range presets.Presets {
+		if preset.Path == "" {
+			return nil, fmt.Errorf("presets %s: preset %d has no path", path, i+1)
+		}
+		switch preset.Mode {
+		case modeNormal, modeReadOnly, modePatch, modeDryRun:
+		default:
+			return nil, fmt.Errorf("presets %s: %s: unknown mode %q", path, preset.Path, preset.Mode)
+		}
+		if !matchPathPattern(strings.TrimSuffix(preset.Path, "/"), dir) {
+			continue
+		}
+		if merged == nil {
+			merged = &Preset{Path: preset.Path}
+		} else {
+			merged.Path += ", " + preset.Path
+		}
+		if preset.Model != "" {
+			merged.Model = preset.Model
+		}
+		if preset.Mode != "" {
+			merged.Mode = preset.Mode
+		}
+		if preset.Tools != nil {
+			merged.Tools = preset.Tools
+		}
+		merged.ExcludeTools = append(merged.ExcludeTools, preset.ExcludeTools...)
+		if preset.Shell != nil {
+			merged.Shell = preset.Shell
+		}
+	}
+	return merged, nil
+}
+
+// presetDir is the working directory relative to the repository root, or "." outside git
+func presetDir() string {
+	result, err := runProgram(context.Background(), "git", "rev-parse", "--show-prefix")
+	if err != nil || !result.Passed() {
+		return "."
+	}
+	if dir := strings.TrimSuffix(strings.TrimSpace(result.Output), "/"); dir != "" {
+		return filepath.ToSlash(dir)
+	}
+	return "."
+}
+
+// allowsTool reports whether the preset offers a tool to Claude
+func (p *Preset) allowsTool(name string) bool {
+	if p == nil {
+		return true
+	}
+	if p.Tools != nil && !slices.Contains(p.Tools, name) {
+		return false
+	}
+	return !slices.Contains(p.ExcludeTools, name)
+}
+
+// allowsShell reports whether shell commands may run outside of tool calls
+func (p *Preset) allowsShell() bool {
+	return p == nil || p.Shell == nil || *p.Shell
+}
+
+// model is the agent's model under the preset
+func (p *Preset) model() anthropic.Model {
+	if p == nil || p.Model == "" {
+		return agentModel
+	}
+	return anthropic.Model(p.Model)
+}
+
+// String summarizes what the preset changes for the startup line
+func (p *Preset) String() string {
+	changes := []string{}
+	if p.Model != "" {
+		changes = append(changes, "model "+p.Model)
+	}
+	if p.Mode != "" {
+		changes = append(changes, "mode "+p.Mode)
+	}
+	if p.Tools != nil {
+		changes = append(changes, "tools "+strings.Join(p.Tools, ", "))
+	}
+	if len(p.ExcludeTools) > 0 {
+		changes = append(changes, "no "+strings.Join(p.ExcludeTools, ", "))
+	}
+	if !p.allowsShell() {
+		changes = append(changes, "no shell")
+	}
+	if len(changes) == 0 {
+		return p.Path
+	}
+	return fmt.Sprintf("%s (%s)", p.Path, strings.Join(changes, "; "))
+}
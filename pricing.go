@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// MODEL PRICING
+// =============================================================================
+
+// pricesRefreshInterval is how long prices fetched from PRICES_URL are used before they
+// are fetched again
+const pricesRefreshInterval = 24 * time.Hour
+
+// ModelPrice is what a model costs, in US dollars per million tokens
+type ModelPrice struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write"` // Writing the prompt cache
+	CacheRead  float64 `json:"cache_read"`  // Reading from the prompt cache
+}
+
+// PriceList is the format of PRICES_URL and PRICES_FILE: prices by model name prefix
+type PriceList struct {
+	Models map[string]ModelPrice `json:"models"`
+}
+
+// priceEntry is a price and where it came from
+type priceEntry struct {
+	price  ModelPrice
+	source string
+	rank   int // Custom prices beat fetched ones, which beat vendored ones
+}
+
+// vendoredPrices are the list prices built into go-agent, used for any model the fetched
+// and custom prices leave out
+var vendoredPrices = map[string]ModelPrice{
+	"claude-opus-4":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-5-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4, CacheWrite: 1, CacheRead: 0.08},
+	"claude-3-opus":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheWrite: 0.30, CacheRead: 0.03},
+}
+
+// modelPrice looks up the price of a model. Custom prices come first, then fetched and
+// vendored ones; within each, the longest prefix that matches wins.
+func modelPrice(model string) (ModelPrice, bool) {
+	table := priceTable()
+	prefixes := []string{}
+	for prefix := range table {
+		if strings.HasPrefix(model, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		return ModelPrice{}, false
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if table[prefixes[i]].rank != table[prefixes[j]].rank {
+			return table[prefixes[i]].rank > table[prefixes[j]].rank
+		}
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+	return table[prefixes[0]].price, true
+}
+
+// priceTable merges the vendored prices, the prices fetched from PRICES_URL and the custom
+// prices of PRICES_FILE, in increasing precedence
+var priceTable = sync.OnceValue(func() map[string]priceEntry {
+	table := map[string]priceEntry{}
+	for prefix, price := range vendoredPrices {
+		table[prefix] = priceEntry{price, "vendored", 0}
+	}
+	if url := configValue("PRICES_URL"); url != "" {
+		list, err := fetchedPrices(url, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleWarning, "warning"), err.Error())
+		}
+		for prefix, price := range list.Models {
+			table[prefix] = priceEntry{price, url, 1}
+		}
+	}
+	if path := configValue("PRICES_FILE"); path != "" {
+		list, err := readPriceList(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleWarning, "warning"), err.Error())
+		}
+		for prefix, price := range list.Models {
+			table[prefix] = priceEntry{price, path, 2}
+		}
+	}
+	return table
+})
+
+// pricesCache keeps the last prices fetched from PRICES_URL
+type pricesCache struct {
+	URL     string    `json:"url"`
+	Fetched time.Time `json:"fetched"`
+	PriceList
+}
+
+// pricesCachePath is where fetched prices are kept between runs
+func pricesCachePath() string {
+	return filepath.Join(cacheDir(), "prices.json")
+}
+
+// fetchedPrices returns the prices from url, fetching them when the cached copy is stale
+// or refresh is set. When the fetch fails, the stale copy is used if there is one.
+func fetchedPrices(url string, refresh bool) (PriceList, error) {
+	cache := pricesCache{}
+	if data, err := os.ReadFile(pricesCachePath()); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	if cache.URL == url && !refresh && time.Since(cache.Fetched) < pricesRefreshInterval {
+		return cache.PriceList, nil
+	}
+
+	list, err := downloadPriceList(url)
+	if err != nil {
+		if cache.URL == url {
+			return cache.PriceList, fmt.Errorf("failed to refresh prices from %s (%s); using the copy from %s", url, err.Error(), cache.Fetched.Format(time.DateOnly))
+		}
+		return PriceList{}, fmt.Errorf("failed to fetch prices from %s (%s); using the vendored prices", url, err.Error())
+	}
+	cache = pricesCache{URL: url, Fetched: time.Now().UTC(), PriceList: list}
+	if data, err := json.MarshalIndent(cache, "", "  "); err == nil && os.MkdirAll(filepath.Dir(pricesCachePath()), 0o700) == nil {
+		os.WriteFile(pricesCachePath(), data, 0o600)
+	}
+	return list, nil
+}
+
+// downloadPriceList fetches a price list
+func downloadPriceList(url string) (PriceList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return PriceList{}, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return PriceList{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return PriceList{}, fmt.Errorf("%s", response.Status)
+	}
+	list := PriceList{}
+	if err := json.NewDecoder(response.Body).Decode(&list); err != nil {
+		return PriceList{}, fmt.Errorf("not a price list: %w", err)
+	}
+	if len(list.Models) == 0 {
+		return PriceList{}, fmt.Errorf("the price list has no models")
+	}
+	return list, nil
+}
+
+// readPriceList reads custom prices from a file
+func readPriceList(path string) (PriceList, error) {
+	list := PriceList{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return list, fmt.Errorf("failed to read prices: %w", err)
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return PriceList{}, fmt.Errorf("failed to parse prices %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// runPricesCommand implements `go-agent prices [--refresh]`, listing the prices costs are
+// based on
+func runPricesCommand(args []string) error {
+	flags := flag.NewFlagSet("prices", flag.ContinueOnError)
+	refresh := flags.Bool("refresh", false, "fetch PRICES_URL now instead of using the cached copy")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if *refresh {
+		url := configValue("PRICES_URL")
+		if url == "" {
+			return fmt.Errorf("--refresh needs PRICES_URL to be set")
+		}
+		if _, err := fetchedPrices(url, true); err != nil {
+			return err
+		}
+	}
+
+	table := priceTable()
+	prefixes := []string{}
+	for prefix := range table {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	fmt.Println("US dollars per million tokens")
+	fmt.Printf("%-28s %8s %8s %11s %10s  %s\n", "MODEL", "INPUT", "OUTPUT", "CACHE WRITE", "CACHE READ", "SOURCE")
+	for _, prefix := range prefixes {
+		entry := table[prefix]
+		fmt.Printf("%-28s %8.2f %8.2f %11.2f %10.2f  %s\n", prefix+"*", entry.price.Input, entry.price.Output,
+			entry.price.CacheWrite, entry.price.CacheRead, entry.source)
+	}
+	return nil
+}
+
+// cost is what a model call with this usage costs, in US dollars
+func (p ModelPrice) cost(usage anthropic.Usage) float64 {
+	return (float64(usage.InputTokens)*p.Input +
+		float64(usage.OutputTokens)*p.Output +
+		float64(usage.CacheCreationInputTokens)*p.CacheWrite +
+		float64(usage.CacheReadInputTokens)*p.CacheRead) / 1e6
+}
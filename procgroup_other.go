@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ownProcessGroup leaves cmd as it is; process groups are a Unix feature
+func ownProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup stops the process with pid
+func killProcessGroup(pid int) {
+	if process, err := os.FindProcess(pid); err == nil {
+		process.Kill()
+	}
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// ownProcessGroup starts cmd in a process group of its own, so stopping it also stops
+// the programs it started. Canceling the context of cmd, which must come from
+// exec.CommandContext, stops the whole group too.
+func ownProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		killProcessGroup(cmd.Process.Pid)
+		return nil
+	}
+	cmd.WaitDelay = time.Second
+}
+
+// killProcessGroup stops the process with pid and, when it leads a group, the rest of it
+func killProcessGroup(pid int) {
+	if syscall.Kill(-pid, syscall.SIGKILL) != nil {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// TOOL PROGRESS
+// =============================================================================
+
+// Progress is an incremental report from a long-running tool
+type Progress struct {
+	Done    int    `json:"done"`              // Items finished so far
+	Total   int    `json:"total,omitempty"`   // Items in all, or zero when unknown
+	Current string `json:"current,omitempty"` // The item being worked on
+}
+
+// Percent returns how far along the tool is, or -1 when the total is unknown
+func (p Progress) Percent() int {
+	if p.Total <= 0 {
+		return -1
+	}
+	return min(100, p.Done*100/p.Total)
+}
+
+// Throttles that keep progress from flooding the terminal and the event log
+const (
+	progressRedrawInterval = 100 * time.Millisecond
+	progressEventInterval  = time.Second
+)
+
+// progressKey is the context key of the current tool call's progress callback
+type progressKey struct{}
+
+// withProgress returns a context whose tool reports progress to report
+func withProgress(ctx context.Context, report func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// reportProgress tells whoever runs the tool how far along it is. Tools call it freely;
+// it does nothing outside a tool call.
+func reportProgress(ctx context.Context, progress Progress) {
+	if report, ok := ctx.Value(progressKey{}).(func(Progress)); ok {
+		report(progress)
+	}
+}
+
+// progressReporter shows one tool call's progress as a bar in the terminal and as
+// progress events
+type progressReporter struct {
+	mu        sync.Mutex
+	events    *EventLog
+	tool, id  string
+	terminal  bool
+	drawn     bool
+	lastDraw  time.Time
+	lastEvent time.Time
+}
+
+// newProgressReporter creates the reporter for one tool call
+func newProgressReporter(events *EventLog, tool, id string) *progressReporter {
+	return &progressReporter{
+		events:   events,
+		tool:     tool,
+		id:       id,
+		terminal: isTerminal(os.Stderr),
+	}
+}
+
+// report handles one progress update
+func (r *progressReporter) report(progress Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.terminal && now.Sub(r.lastDraw) >= progressRedrawInterval {
+		r.lastDraw = now
+		r.drawn = true
+		fmt.Fprintf(os.Stderr, "\r\u001b[2K%s: %s %s", paint(roleTool, "tool"), r.tool, renderProgress(progress))
+	}
+	if now.Sub(r.lastEvent) >= progressEventInterval {
+		r.lastEvent = now
+		r.events.Emit(Event{Type: eventProgress, Tool: r.tool, ToolUseID: r.id, Progress: &progress})
+	}
+}
+
+// finish clears the progress bar once the tool returns
+func (r *progressReporter) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.drawn {
+		fmt.Fprint(os.Stderr, "\r\u001b[2K")
+	}
+}
+
+// renderProgress shows progress as a bar with a count, or just a count when the total is unknown
+func renderProgress(progress Progress) string {
+	const width = 20
+	current := truncateRunes(progress.Current, 60)
+	percent := progress.Percent()
+	if percent < 0 {
+		return fmt.Sprintf("%d done %s", progress.Done, current)
+	}
+	filled := percent * width / 100
+	bar := strings.Repeat("#", filled) + strings.Repeat(" ", width-filled)
+	return fmt.Sprintf("[%s] %3d%% (%d/%d) %s", bar, percent, progress.Done, progress.Total, current)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// =============================================================================
+// PROJECT DETECTION
+// =============================================================================
+
+// Kinds of project the detector knows
+const (
+	projectGo     = "go"
+	projectNode   = "node"
+	projectPython = "python"
+	projectRust   = "rust"
+)
+
+// Project is the toolchain of the directory the agent works in, found from the nearest
+// manifest. Its commands default the workflows' --cmd and --verify flags and back the
+// run_build, run_tests and run_lint tools. BUILD_CMD, TEST_CMD and LINT_CMD override them.
+type Project struct {
+	Kind        string
+	Root        string // Directory holding the manifest
+	Manifest    string // File the project was recognized by, e.g. package.json
+	Tool        string // Package manager or runner, e.g. pnpm or uv
+	Build       string
+	Test        string
+	Lint        string
+	Conventions string // What Claude should know when changing code of this kind
+}
+
+// projectMarkers are the manifests each kind is recognized by, most specific first
+var projectMarkers = []struct {
+	kind  string
+	files []string
+}{
+	{projectGo, []string{"go.mod"}},
+	{projectRust, []string{"Cargo.toml"}},
+	{projectNode, []string{"package.json"}},
+	{projectPython, []string{"pyproject.toml", "setup.py", "setup.cfg", "requirements.txt"}},
+}
+
+// projectConventions are added to the system prompt for each kind
+var projectConventions = map[string]string{
+	projectGo:     "Format changed files with gofmt. Tests live in _test.go files next to the code they test.",
+	projectNode:   "Follow the package's module system (ESM or CommonJS) and language (TypeScript or JavaScript). Add dependencies with the package manager, never by editing the lockfile.",
+	projectPython: "Follow PEP 8 and the project's type hints. Tests follow the layout and naming of the existing ones.",
+	projectRust:   "Keep the code clippy-clean and rustfmt-formatted. Unit tests live in #[cfg(test)] modules, integration tests in tests/.",
+}
+
+// detectProject finds the project dir belongs to, looking in dir and then its parents
+// up to the repository root. Without a manifest, BUILD_CMD, TEST_CMD and LINT_CMD alone
+// make a project of dir. It returns nil when there is neither, or PROJECT_DETECTION is
+// false.
+func detectProject(dir string) *Project {
+	if configValue("PROJECT_DETECTION") == "false" {
+		return nil
+	}
+	start, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	for dir := start; ; {
+		for _, marker := range projectMarkers {
+			for _, file := range marker.files {
+				if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+					return newProject(marker.kind, dir, file)
+				}
+			}
+		}
+		parent := filepath.Dir(dir)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil || parent == dir {
+			break
+		}
+		dir = parent
+	}
+	if configValue("BUILD_CMD") == "" && configValue("TEST_CMD") == "" && configValue("LINT_CMD") == "" {
+		return nil
+	}
+	return newProject("", start, "")
+}
+
+// newProject works out the commands of a project of kind at root
+func newProject(kind, root, manifest string) *Project {
+	project := &Project{Kind: kind, Root: root, Manifest: manifest, Conventions: projectConventions[kind]}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(root, name))
+		return err == nil
+	}
+	mentions := func(name, text string) bool {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		return err == nil && strings.Contains(string(data), text)
+	}
+
+	switch kind {
+	case projectGo:
+		project.Tool = "go"
+		project.Build, project.Test, project.Lint = "go build ./...", "go test ./...", "go vet ./..."
+		if exists(".golangci.yml") || exists(".golangci.yaml") || exists(".golangci.toml") {
+			project.Lint = "golangci-lint run"
+		}
+	case projectRust:
+		project.Tool = "cargo"
+		project.Build, project.Test, project.Lint = "cargo build", "cargo test", "cargo clippy"
+	case projectNode:
+		project.Tool = nodePackageManager(root)
+		var manifest struct {
+			Scripts map[string]string `json:"scripts"`
+		}
+		data, _ := os.ReadFile(filepath.Join(root, "package.json"))
+		json.Unmarshal(data, &manifest)
+		if script := manifest.Scripts["test"]; script != "" && !strings.Contains(script, "no test specified") {
+			project.Test = project.Tool + " test"
+		}
+		if manifest.Scripts["build"] != "" {
+			project.Build = project.Tool + " run build"
+		}
+		if manifest.Scripts["lint"] != "" {
+			project.Lint = project.Tool + " run lint"
+		}
+	case projectPython:
+		runner := ""
+		switch {
+		case exists("uv.lock"):
+			project.Tool, runner = "uv", "uv run "
+		case exists("poetry.lock"):
+			project.Tool, runner = "poetry", "poetry run "
+		case exists("Pipfile"):
+			project.Tool, runner = "pipenv", "pipenv run "
+		default:
+			project.Tool = "pip"
+		}
+		project.Test = runner + "python -m unittest"
+		if exists("pytest.ini") || exists("conftest.py") || mentions("pyproject.toml", "pytest") || mentions("setup.cfg", "pytest") || mentions("requirements.txt", "pytest") {
+			project.Test = runner + "pytest"
+		}
+		switch {
+		case exists("ruff.toml") || exists(".ruff.toml") || mentions("pyproject.toml", "[tool.ruff"):
+			project.Lint = runner + "ruff check ."
+		case exists(".flake8") || mentions("setup.cfg", "[flake8]") || mentions("tox.ini", "[flake8]"):
+			project.Lint = runner + "flake8"
+		}
+		if mentions("pyproject.toml", "[tool.mypy") || exists("mypy.ini") {
+			project.Build = runner + "mypy ."
+		}
+	}
+
+	project.Build = cmp.Or(configValue("BUILD_CMD"), project.Build)
+	project.Test = cmp.Or(configValue("TEST_CMD"), project.Test)
+	project.Lint = cmp.Or(configValue("LINT_CMD"), project.Lint)
+	return project
+}
+
+// nodePackageManager picks the package manager from the packageManager field or the lockfile
+func nodePackageManager(root string) string {
+	var manifest struct {
+		PackageManager string `json:"packageManager"`
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "package.json"))
+	json.Unmarshal(data, &manifest)
+	if name, _, _ := strings.Cut(manifest.PackageManager, "@"); name != "" {
+		return name
+	}
+	for lockfile, manager := range map[string]string{"pnpm-lock.yaml": "pnpm", "yarn.lock": "yarn", "bun.lockb": "bun", "bun.lock": "bun"} {
+		if _, err := os.Stat(filepath.Join(root, lockfile)); err == nil {
+			return manager
+		}
+	}
+	return "npm"
+}
+
+// String names the project for the system prompt and `go-agent project`
+func (p *Project) String() string {
+	if p.Kind == "" {
+		return "project at " + p.Root + " with commands from BUILD_CMD, TEST_CMD and LINT_CMD"
+	}
+	name := map[string]string{projectGo: "Go", projectNode: "Node", projectPython: "Python", projectRust: "Rust"}[p.Kind]
+	return fmt.Sprintf("%s project (%s, %s) at %s", name, p.Manifest, p.Tool, p.Root)
+}
+
+// command returns a shell command line that runs command in the project's root; it is
+// command itself when the agent already runs there, and empty without a project or
+// command
+func (p *Project) command(command string) string {
+	if p == nil || command == "" {
+		return ""
+	}
+	if wd, err := os.Getwd(); err == nil && wd == p.Root {
+		return command
+	}
+	return "cd " + shellQuote(p.Root) + " && " + command
+}
+
+// buildCommand is the build command as a shell command line, or "" without one
+func (p *Project) buildCommand() string {
+	if p == nil {
+		return ""
+	}
+	return p.command(p.Build)
+}
+
+// testCommand is the test command as a shell command line, or "" without one
+func (p *Project) testCommand() string {
+	if p == nil {
+		return ""
+	}
+	return p.command(p.Test)
+}
+
+// shellQuote quotes text as a single sh word
+func shellQuote(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
+
+// context describes the project for the system prompt
+func (p *Project) context() string {
+	if p == nil {
+		return ""
+	}
+	lines := []string{"This is a " + p.String() + "."}
+	for _, command := range []struct{ name, line, tool string }{
+		{"Build", p.Build, "run_build"},
+		{"Test", p.Test, "run_tests"},
+		{"Lint", p.Lint, "run_lint"},
+	} {
+		if command.line != "" {
+			lines = append(lines, fmt.Sprintf("%s with `%s` (the %s tool).", command.name, command.line, command.tool))
+		}
+	}
+	if p.Conventions != "" {
+		lines = append(lines, p.Conventions)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RunProjectCommandInput defines the input structure for the run_build, run_tests and
+// run_lint tools
+type RunProjectCommandInput struct {
+	Args []string `json:"args,omitempty" jsonschema_description:"Optional extra arguments, such as a package, a file or a test name filter. Each one is passed as a single word."`
+}
+
+var RunProjectCommandInputSchema = GenerateSchema[RunProjectCommandInput]()
+
+// tools returns the tools running the project's commands, for those it has
+func (p *Project) tools() []ToolDefinition {
+	if p == nil {
+		return nil
+	}
+	tools := []ToolDefinition{}
+	for _, command := range []struct{ name, line, what string }{
+		{"run_build", p.Build, "Build or type-check the project"},
+		{"run_tests", p.Test, "Run the project's tests"},
+		{"run_lint", p.Lint, "Run the project's linter"},
+	} {
+		if command.line == "" {
+			continue
+		}
+		line := command.line
+		tools = append(tools, ToolDefinition{
+			Name:        command.name,
+			Description: fmt.Sprintf("%s with `%s` in %s and return the exit code and output. Use it to check changes before you finish.", command.what, line, p.Root),
+			InputSchema: RunProjectCommandInputSchema,
+			Mutating:    true, // Runs the project's own code, which may do anything
+			Run: func(ctx context.Context, input json.RawMessage) (string, error) {
+				return p.run(ctx, line, input)
+			},
+			Command: func(input json.RawMessage) string {
+				line, _ := p.commandLine(line, input)
+				return line
+			},
+		})
+	}
+	return tools
+}
+
+// commandLine adds the extra arguments of a tool call to a project command, quoted, as
+// the guard and approval prompts show it
+func (p *Project) commandLine(line string, input json.RawMessage) (string, error) {
+	line, args, err := p.commandArgs(line, input)
+	if err != nil {
+		return "", err
+	}
+	for _, arg := range args {
+		line += " " + shellQuote(arg)
+	}
+	return line, nil
+}
+
+// commandArgs splits a tool call into the project command, which is a shell command line,
+// and the extra arguments, which are passed to it as words that the shell never parses
+func (p *Project) commandArgs(line string, input json.RawMessage) (string, []string, error) {
+	runInput := RunProjectCommandInput{}
+	if err := json.Unmarshal(input, &runInput); err != nil {
+		return "", nil, fmt.Errorf("invalid input format: %w", err)
+	}
+	if len(runInput.Args) > 0 && p.Kind == projectNode && !strings.Contains(line, " -- ") {
+		line += " --" // Arguments after -- go to the script
+	}
+	return line, runInput.Args, nil
+}
+
+// run runs a project command with the extra arguments of a tool call. The arguments are
+// handed to sh as positional parameters and appended with "$@", so quotes, semicolons or
+// substitutions in them stay literal.
+func (p *Project) run(ctx context.Context, line string, input json.RawMessage) (string, error) {
+	display, err := p.commandLine(line, input)
+	if err != nil {
+		return "", err
+	}
+	script, args, err := p.commandArgs(line, input)
+	if err != nil {
+		return "", err
+	}
+	if len(args) > 0 {
+		script += ` "$@"`
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", script, "sh"}, args...)...)
+	cmd.Dir = p.Root
+	if err := sandbox(ctx, cmd, p.Root); err != nil {
+		return "", err
+	}
+	result, err := runCommand(cmd, display)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$ %s\nexit code %d\n\n%s", display, result.ExitCode, tailToTokens(result.Output, toolResultMaxTokens())), nil
+}
+
+// runProjectCommand implements `go-agent project`, showing what was detected
+func runProjectCommand(args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("usage: go-agent project")
+	}
+	project := runOptions.project
+	if project == nil {
+		fmt.Println("No Go, Node, Python or Rust project found here. Set BUILD_CMD, TEST_CMD and LINT_CMD to give the agent this directory's commands.")
+		return nil
+	}
+	fmt.Println(project)
+	for _, command := range []struct{ name, line string }{{"build", project.Build}, {"test", project.Test}, {"lint", project.Lint}} {
+		if command.line == "" {
+			command.line = "(none)"
+		}
+		fmt.Printf("  %-6s %s\n", command.name, command.line)
+	}
+	return nil
+}
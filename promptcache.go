@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// PROMPT CACHING
+// =============================================================================
+
+// cacheAttachmentTokens is the size from which an attached file, diff or command output is
+// worth a cache breakpoint of its own
+const cacheAttachmentTokens = 2048
+
+// promptCaching reports whether model calls mark their stable prefix for the prompt
+// cache; PROMPT_CACHING=false turns it off
+func promptCaching() bool {
+	return configValue("PROMPT_CACHING") != "false"
+}
+
+// withPromptCache marks the parts of a model call that stay the same from one call to the
+// next, so the API reads them from its cache instead of processing them again. It uses
+// the four breakpoints the API allows: the last tool definition, the end of the system
+// prompt, the last large attachment and the end of the conversation. The conversation is
+// copied, not changed.
+func withPromptCache(params anthropic.MessageNewParams) anthropic.MessageNewParams {
+	if !promptCaching() {
+		return params
+	}
+	if len(params.Tools) > 0 {
+		params.Tools = append([]anthropic.ToolUnionParam{}, params.Tools...)
+		if last := params.Tools[len(params.Tools)-1].OfTool; last != nil {
+			tool := *last
+			tool.CacheControl = anthropic.NewCacheControlEphemeralParam()
+			params.Tools[len(params.Tools)-1].OfTool = &tool
+		}
+	}
+	if len(params.System) > 0 {
+		params.System = append([]anthropic.TextBlockParam{}, params.System...)
+		params.System[len(params.System)-1].CacheControl = anthropic.NewCacheControlEphemeralParam()
+	}
+
+	messages := append([]anthropic.MessageParam{}, params.Messages...)
+	if i, j, ok := lastLargeAttachment(messages); ok {
+		messages[i] = withCacheBreakpoint(messages[i], j)
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if j := lastCacheableBlock(messages[i]); j >= 0 {
+			messages[i] = withCacheBreakpoint(messages[i], j)
+			break
+		}
+	}
+	params.Messages = messages
+	return params
+}
+
+// lastLargeAttachment finds the last attachment of at least cacheAttachmentTokens before
+// the last message, which gets the conversation's own breakpoint
+func lastLargeAttachment(messages []anthropic.MessageParam) (int, int, bool) {
+	for i := len(messages) - 2; i >= 0; i-- {
+		for j := len(messages[i].Content) - 1; j >= 0; j-- {
+			block := messages[i].Content[j]
+			if block.OfText != nil && attachmentName(block) != "" && estimateTokens(block.OfText.Text) >= cacheAttachmentTokens {
+				return i, j, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// lastCacheableBlock is the index of the message's last block that can carry a cache
+// breakpoint, or -1; thinking blocks cannot
+func lastCacheableBlock(message anthropic.MessageParam) int {
+	for j := len(message.Content) - 1; j >= 0; j-- {
+		block := message.Content[j]
+		if block.OfText != nil || block.OfImage != nil || block.OfDocument != nil || block.OfToolUse != nil || block.OfToolResult != nil {
+			return j
+		}
+	}
+	return -1
+}
+
+// withCacheBreakpoint returns a copy of the message whose block j ends a cached prefix
+func withCacheBreakpoint(message anthropic.MessageParam, j int) anthropic.MessageParam {
+	message.Content = append([]anthropic.ContentBlockParamUnion{}, message.Content...)
+	block := message.Content[j]
+	cache := anthropic.NewCacheControlEphemeralParam()
+	switch {
+	case block.OfText != nil:
+		text := *block.OfText
+		text.CacheControl = cache
+		block.OfText = &text
+	case block.OfImage != nil:
+		image := *block.OfImage
+		image.CacheControl = cache
+		block.OfImage = &image
+	case block.OfDocument != nil:
+		document := *block.OfDocument
+		document.CacheControl = cache
+		block.OfDocument = &document
+	case block.OfToolUse != nil:
+		toolUse := *block.OfToolUse
+		toolUse.CacheControl = cache
+		block.OfToolUse = &toolUse
+	case block.OfToolResult != nil:
+		result := *block.OfToolResult
+		result.CacheControl = cache
+		block.OfToolResult = &result
+	}
+	message.Content[j] = block
+	return message
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// =============================================================================
+// TOOL QUOTAS
+// =============================================================================
+
+// ToolQuotas cap what one agent session may do with its tools. Zero means unlimited.
+type ToolQuotas struct {
+	Calls      map[string]int // Maximum calls per tool name, from QUOTA_TOOL_CALLS
+	WriteBytes int            // Maximum bytes of new text written by file tools, from QUOTA_WRITE_BYTES
+}
+
+// toolQuotas reads the quota settings once
+var toolQuotas = sync.OnceValue(func() ToolQuotas {
+	quotas := ToolQuotas{Calls: map[string]int{}, WriteBytes: configInt("QUOTA_WRITE_BYTES", 0)}
+	for _, entry := range splitList(configValue("QUOTA_TOOL_CALLS")) {
+		name, limit, _ := strings.Cut(entry, "=")
+		if n, err := strconv.Atoi(strings.TrimSpace(limit)); err == nil && n > 0 {
+			quotas.Calls[strings.TrimSpace(name)] = n
+		}
+	}
+	return quotas
+})
+
+// quotaUsage is what an agent has used so far
+type quotaUsage struct {
+	calls   map[string]int
+	written int
+}
+
+// chargeQuota records a tool call against the session's quotas, or explains which quota the
+// call would exceed
+func (a *Agent) chargeQuota(name string, input json.RawMessage) error {
+	quotas := toolQuotas()
+	if a.usage.calls == nil {
+		a.usage.calls = map[string]int{}
+	}
+
+	if limit := quotas.Calls[name]; limit > 0 && a.usage.calls[name] >= limit {
+		return fmt.Errorf("quota exceeded: %s may be called at most %d times per session", name, limit)
+	}
+
+	written := 0
+	if name == EditFileDefinition.Name {
+		editFileInput := EditFileInput{}
+		json.Unmarshal(input, &editFileInput)
+		written = len(editFileInput.NewStr)
+	}
+	if quotas.WriteBytes > 0 && a.usage.written+written > quotas.WriteBytes {
+		return fmt.Errorf("quota exceeded: this edit writes %d bytes, but only %d of the session's %d bytes are left", written, quotas.WriteBytes-a.usage.written, quotas.WriteBytes)
+	}
+
+	a.usage.calls[name]++
+	a.usage.written += written
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// =============================================================================
+// API RATE LIMITING
+// =============================================================================
+
+// rateLimitNotice is how long a model call has to wait before the wait is announced
+const rateLimitNotice = time.Second
+
+// rateLimitNow is the clock of the rate limits, which tests replace
+var rateLimitNow = time.Now
+
+// rateLimiter spaces out the model calls of the whole process to the organization's rate
+// limits, API_REQUESTS_PER_MINUTE and API_TOKENS_PER_MINUTE, so parallel subagents and
+// server sessions queue up instead of running into 429s. Each limit is a token bucket
+// that holds a minute's worth and refills continuously.
+type rateLimiter struct {
+	mu       sync.Mutex
+	requests *tokenBucket // nil when requests are not limited
+	tokens   *tokenBucket // nil when tokens are not limited
+}
+
+// tokenBucket is one limit. Calls take from it even when it runs dry, and wait until
+// the refill covers the debt, so they go out in the order they arrived.
+type tokenBucket struct {
+	rate      float64 // Refill per second
+	capacity  float64
+	available float64
+	updated   time.Time
+}
+
+// apiRateLimiter reads the rate limits once; every client of the process shares the result
+var apiRateLimiter = sync.OnceValue(func() *rateLimiter {
+	return newRateLimiter(configInt("API_REQUESTS_PER_MINUTE", 0), configInt("API_TOKENS_PER_MINUTE", 0))
+})
+
+// newRateLimiter creates a limiter for the given limits per minute, or returns nil when
+// both are zero
+func newRateLimiter(requestsPerMinute, tokensPerMinute int) *rateLimiter {
+	if requestsPerMinute <= 0 && tokensPerMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{requests: newTokenBucket(requestsPerMinute), tokens: newTokenBucket(tokensPerMinute)}
+}
+
+// newTokenBucket creates a full bucket for a limit per minute, or returns nil when it is zero
+func newTokenBucket(perMinute int) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: float64(perMinute) / 60, capacity: float64(perMinute), available: float64(perMinute), updated: rateLimitNow()}
+}
+
+// take removes n from the bucket and returns how long until the bucket is out of debt.
+// A call larger than the whole bucket takes all of it rather than waiting forever.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.available = min(b.capacity, b.available+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+	b.available -= min(n, b.capacity)
+	if b.available >= 0 {
+		return 0
+	}
+	return time.Duration(-b.available / b.rate * float64(time.Second))
+}
+
+// giveBack returns n to the bucket, for a call that gave up waiting
+func (b *tokenBucket) giveBack(n float64) {
+	if b == nil {
+		return
+	}
+	b.available = min(b.capacity, b.available+min(n, b.capacity))
+}
+
+// wait blocks until a model call of about the given input tokens fits the limits. A call
+// whose ctx is canceled while it waits gives its share back.
+func (l *rateLimiter) wait(ctx context.Context, tokens int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := rateLimitNow()
+	delay := max(l.requests.take(1, now), l.tokens.take(float64(tokens), now))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	if delay >= rateLimitNotice {
+		text := fmt.Sprintf("waiting %s for the API rate limit (API_REQUESTS_PER_MINUTE, API_TOKENS_PER_MINUTE)", delay.Round(100*time.Millisecond))
+		fmt.Fprintf(os.Stderr, "rate limit: %s\n", text)
+		runOptions.events.Emit(Event{Type: eventStatus, Text: text})
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.requests.giveBack(1)
+		l.tokens.giveBack(float64(tokens))
+		return ctx.Err()
+	}
+}
+
+// charge counts output tokens against the token limit once a call reports them
+func (l *rateLimiter) charge(tokens int64) {
+	if l == nil || tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.take(float64(tokens), rateLimitNow())
+}
+
+// middleware holds every model call until it fits the rate limits, whichever part of the
+// agent makes it. Input tokens are estimated from the request; the output tokens of JSON
+// responses are charged here, and those of streams by streamMessage.
+func (l *rateLimiter) middleware(request *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if l == nil || request.Method != http.MethodPost || request.Body == nil || !strings.HasSuffix(request.URL.Path, "/messages") {
+		return next(request)
+	}
+	data, err := io.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(data))
+	if err := l.wait(request.Context(), estimateTokens(string(data))); err != nil {
+		return nil, err
+	}
+
+	response, err := next(request)
+	if err != nil || response.StatusCode != http.StatusOK || !strings.HasPrefix(response.Header.Get("Content-Type"), "application/json") {
+		return response, err
+	}
+	body, readErr := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return response, nil
+	}
+	var message struct {
+		Usage *anthropic.Usage `json:"usage"`
+	}
+	if json.Unmarshal(body, &message) == nil && message.Usage != nil {
+		l.charge(message.Usage.OutputTokens)
+	}
+	return response, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fixRateLimitClock stands the clock of the rate limits at the time *now holds
+func fixRateLimitClock(t *testing.T, now *time.Time) {
+	t.Helper()
+	clock := rateLimitNow
+	rateLimitNow = func() time.Time { return *now }
+	t.Cleanup(func() { rateLimitNow = clock })
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fixRateLimitClock(t, &now)
+	bucket := newTokenBucket(60) // One a second
+
+	if delay := bucket.take(60, now); delay != 0 {
+		t.Errorf("taking the full bucket waits %s", delay)
+	}
+	if delay := bucket.take(3, now); delay != 3*time.Second {
+		t.Errorf("taking 3 from an empty bucket waits %s, want 3s", delay)
+	}
+	// The next call queues behind the debt of the one before
+	if delay := bucket.take(1, now.Add(time.Second)); delay != 3*time.Second {
+		t.Errorf("taking 1 a second later waits %s, want 3s", delay)
+	}
+	bucket.giveBack(1)
+	if delay := bucket.take(0, now.Add(time.Second)); delay != 2*time.Second {
+		t.Errorf("after giving 1 back the debt is %s, want 2s", delay)
+	}
+	// The refill stops at the capacity, however long the bucket sat unused
+	if delay := bucket.take(59, now.Add(time.Hour)); delay != 0 {
+		t.Errorf("taking 59 after an hour waits %s", delay)
+	}
+	if delay := bucket.take(2, now.Add(time.Hour)); delay != time.Second {
+		t.Errorf("taking 2 more waits %s, want 1s", delay)
+	}
+	// A call larger than the bucket takes all of it instead of waiting forever
+	full := newTokenBucket(60)
+	if delay := full.take(1000, now); delay != 0 {
+		t.Errorf("taking more than the capacity from a full bucket waits %s", delay)
+	}
+	if delay := full.take(1000, now); delay != time.Minute {
+		t.Errorf("taking more than the capacity from an empty bucket waits %s, want 1m", delay)
+	}
+	// Giving it back returns only what it took
+	full.giveBack(1000)
+	if full.available != 0 {
+		t.Errorf("giving back the second call leaves %v, want the empty bucket the first left", full.available)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fixRateLimitClock(t, &now)
+	limiter := newRateLimiter(2, 1000)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for range 2 {
+		if err := limiter.wait(canceled, 100); err != nil {
+			t.Fatalf("a call within the limits waited: %v", err)
+		}
+	}
+	// The third request in the minute has to wait, and gives its share back when canceled
+	if err := limiter.wait(canceled, 100); err != context.Canceled {
+		t.Fatalf("a call over the request limit got %v, want it canceled while waiting", err)
+	}
+	if limiter.requests.available != 0 || limiter.tokens.available != 800 {
+		t.Errorf("after the canceled call %v requests and %v tokens are left, want 0 and 800", limiter.requests.available, limiter.tokens.available)
+	}
+
+	// Output tokens count against the token limit
+	limiter.charge(800)
+	now = now.Add(30 * time.Second)
+	if delay := limiter.tokens.take(0, now); delay != 0 {
+		t.Errorf("the token limit is still %s in debt after half a minute", delay)
+	}
+	if err := limiter.wait(canceled, 600); err != context.Canceled {
+		t.Errorf("a call over the token limit got %v, want it canceled while waiting", err)
+	}
+	if err := limiter.wait(canceled, 400); err != nil {
+		t.Errorf("a call within both limits half a minute later waited: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// =============================================================================
+// RE-APPLYING A RECORDED SESSION
+// =============================================================================
+
+// recordedEdit is a successful mutating tool call found in an event log
+type recordedEdit struct {
+	Tool  string
+	Input json.RawMessage
+}
+
+// runReapplyCommand implements `go-agent reapply LOG`
+func runReapplyCommand(args []string) error {
+	flags := flag.NewFlagSet("reapply", flag.ContinueOnError)
+	keepGoing := flags.Bool("keep-going", false, "apply the remaining edits after one fails instead of stopping")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("usage: go-agent reapply [--keep-going] LOG (a --log or --audit file)")
+	}
+
+	edits, err := readRecordedEdits(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(edits) == 0 {
+		fmt.Println("reapply: the session made no changes")
+		return nil
+	}
+
+	ctx := context.TODO()
+	failed := 0
+	for i, edit := range edits {
+		fmt.Fprintf(os.Stderr, "%s: %d/%d %s(%s)\n", paint(roleStatus, "reapply"), i+1, len(edits), edit.Tool, edit.Input)
+		if err := reapplyEdit(ctx, edit); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: edit %d failed: %s\n", paint(roleError, "reapply"), i+1, err.Error())
+			if !*keepGoing {
+				return fmt.Errorf("edit %d of %d does not apply to this workspace: %w", i+1, len(edits), err)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d edits did not apply", failed, len(edits))
+	}
+	if runOptions.Mode == modeDryRun {
+		fmt.Printf("reapply: all %d edit(s) apply cleanly\n", len(edits))
+	} else {
+		fmt.Printf("reapply: applied %d edit(s)\n", len(edits))
+	}
+	return nil
+}
+
+// reapplyEdit makes one recorded change under the current workspace mode
+func reapplyEdit(ctx context.Context, edit recordedEdit) error {
+	if edit.Tool != EditFileDefinition.Name {
+		return fmt.Errorf("%s cannot be re-applied", edit.Tool)
+	}
+	if runOptions.Mode == modeReadOnly {
+		return fmt.Errorf("edits cannot be applied in read-only mode")
+	}
+	if runOptions.Mode == modeDryRun {
+		_, err := simulateTool(ctx, edit.Tool, edit.Input)
+		return err
+	}
+
+	editFileInput := EditFileInput{}
+	if err := json.Unmarshal(edit.Input, &editFileInput); err != nil {
+		return err
+	}
+	if runOptions.patch != nil {
+		runOptions.patch.Add(editFileInput.Path)
+	}
+	_, err := EditFile(edit.Input)
+	return err
+}
+
+// readRecordedEdits collects, in order, the mutating tool calls that succeeded (or were
+// previewed by a dry run) from an event log or audit trail
+func readRecordedEdits(path string) ([]recordedEdit, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mutating := map[string]bool{}
+	for _, tool := range defaultTools() {
+		mutating[tool.Name] = tool.Mutating
+	}
+
+	pending := map[string]recordedEdit{}
+	edits := []recordedEdit{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record struct {
+			Event
+			AuditEvent *Event `json:"event"` // Set in audit trail entries
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d is not an event: %w", path, line, err)
+		}
+		event := record.Event
+		if record.AuditEvent != nil {
+			event = *record.AuditEvent
+		}
+
+		switch event.Type {
+		case eventToolUse:
+			if mutating[event.Tool] {
+				pending[event.ToolUseID] = recordedEdit{Tool: event.Tool, Input: event.Input}
+			}
+		case eventToolResult:
+			if edit, ok := pending[event.ToolUseID]; ok && !event.IsError {
+				edits = append(edits, edit)
+			}
+			delete(pending, event.ToolUseID)
+		case eventToolDenied:
+			delete(pending, event.ToolUseID)
+		}
+	}
+	return edits, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// SYSTEM REMINDERS
+// =============================================================================
+
+// reminderSettings controls how often Claude is reminded of the ground rules on long runs
+type reminderSettings struct {
+	Interval int    // Model calls between reminders, from REMINDER_INTERVAL; zero disables them
+	Text     string // Extra reminder text, from SYSTEM_REMINDER
+}
+
+// reminders reads the reminder settings once
+var reminders = sync.OnceValue(func() reminderSettings {
+	return reminderSettings{
+		Interval: configInt("REMINDER_INTERVAL", 5),
+		Text:     configValue("SYSTEM_REMINDER"),
+	}
+})
+
+// withReminder returns the conversation to send for this model call. Every Interval calls
+// the last user message gets a reminder appended to a copy of it, so the reminder steers
+// this call without becoming part of the conversation history.
+func (a *Agent) withReminder(conversation []anthropic.MessageParam) []anthropic.MessageParam {
+	settings := reminders()
+	if settings.Interval <= 0 || a.turns <= 1 || a.turns%settings.Interval != 0 || len(conversation) == 0 {
+		return conversation
+	}
+	last := conversation[len(conversation)-1]
+	if last.Role != anthropic.MessageParamRoleUser {
+		return conversation
+	}
+
+	lines := []string{}
+	if workspace, err := os.Getwd(); err == nil {
+		lines = append(lines, fmt.Sprintf("Stay within the workspace %s; do not read or change files outside it.", workspace))
+	}
+	switch runOptions.Mode {
+	case modeReadOnly:
+		lines = append(lines, "This is a read-only session; do not try to change files.")
+	case modeDryRun:
+		lines = append(lines, "This is a dry run; edits are previewed, not written.")
+	}
+	if settings.Text != "" {
+		lines = append(lines, settings.Text)
+	}
+	for _, reminder := range a.reminders {
+		if line := reminder(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	text := "<system-reminder>\n" + strings.Join(lines, "\n") + "\n</system-reminder>"
+	content := append(append([]anthropic.ContentBlockParamUnion{}, last.Content...), anthropic.NewTextBlock(text))
+	steered := append([]anthropic.MessageParam{}, conversation[:len(conversation)-1]...)
+	return append(steered, anthropic.MessageParam{Role: last.Role, Content: content})
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// REPRODUCIBILITY
+// =============================================================================
+
+// SessionMetadata records what a session's behavior depends on. The Messages API has no
+// sampling seed, so reproducing a session means repeating these settings exactly.
+type SessionMetadata struct {
+	AgentVersion string            `json:"agent_version"`
+	GoVersion    string            `json:"go_version"`
+	SDKVersion   string            `json:"sdk_version"`
+	Model        string            `json:"model"`
+	MaxTokens    int64             `json:"max_tokens"`
+	Temperature  *float64          `json:"temperature"` // Null means the API default
+	TopP         *float64          `json:"top_p,omitempty"`
+	Stop         []string          `json:"stop_sequences,omitempty"`
+	Thinking     int64             `json:"thinking_budget,omitempty"` // Budget of extended thinking; 0 when off
+	Tools        map[string]string `json:"tools"`                     // Tool name to a hash of its description and schema
+	Args         []string          `json:"args"`
+	Approval     string            `json:"approval"`
+	Mode         string            `json:"mode"`
+	MaxTurns     int               `json:"max_turns"`
+	PolicyFile   string            `json:"policy_file,omitempty"`
+	PolicyHash   string            `json:"policy_hash,omitempty"`
+	SystemHash   string            `json:"system_prompt_hash,omitempty"`
+	SnapshotHash string            `json:"snapshot_hash,omitempty"` // Hash of the --snapshot context, which holds the system prompt
+	Preset       string            `json:"preset,omitempty"`        // Paths of the directory presets that applied
+	Workspace    string            `json:"workspace"`
+	GitCommit    string            `json:"git_commit,omitempty"`
+	GitDirty     bool              `json:"git_dirty,omitempty"`
+}
+
+// sessionMetadata describes the current process
+func sessionMetadata() *SessionMetadata {
+	metadata := &SessionMetadata{
+		AgentVersion: "unknown",
+		GoVersion:    runtime.Version(),
+		Model:        string(runOptions.preset.model()),
+		MaxTokens:    runOptions.MaxTokens,
+		Temperature:  runOptions.Temperature,
+		TopP:         runOptions.TopP,
+		Stop:         runOptions.StopSequences,
+		Thinking:     runOptions.ThinkingBudget,
+		Tools:        map[string]string{},
+		Args:         os.Args[1:],
+		Approval:     runOptions.Approval,
+		Mode:         runOptions.Mode,
+		MaxTurns:     runOptions.MaxTurns,
+		PolicyFile:   runOptions.PolicyFile,
+	}
+	if runOptions.preset != nil {
+		metadata.Preset = runOptions.preset.Path
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		metadata.AgentVersion = info.Main.Version
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				metadata.AgentVersion += " (" + setting.Value + ")"
+			}
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/anthropics/anthropic-sdk-go" {
+				metadata.SDKVersion = dep.Version
+			}
+		}
+	}
+
+	for _, tool := range NewAgent(nil, nil, defaultTools()).tools {
+		data, _ := json.Marshal(tool)
+		metadata.Tools[tool.Name] = shortHash(data)
+	}
+	if runOptions.PolicyFile != "" {
+		if data, err := os.ReadFile(runOptions.PolicyFile); err == nil {
+			metadata.PolicyHash = shortHash(data)
+		}
+	}
+
+	if runOptions.systemPrompt != "" {
+		metadata.SystemHash = shortHash([]byte(runOptions.systemPrompt))
+	}
+	if runOptions.snapshot != nil {
+		metadata.SnapshotHash = shortHash([]byte(runOptions.snapshot.context))
+	}
+
+	metadata.Workspace, _ = os.Getwd()
+	ctx := context.Background()
+	if result, err := runProgram(ctx, "git", "rev-parse", "HEAD"); err == nil && result.ExitCode == 0 {
+		metadata.GitCommit = strings.TrimSpace(result.Output)
+		status, err := runProgram(ctx, "git", "status", "--porcelain")
+		metadata.GitDirty = err == nil && strings.TrimSpace(status.Output) != ""
+	}
+	return metadata
+}
+
+// shortHash identifies content in metadata
+func shortHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// recordedSession is what reproduce needs from an event log
+type recordedSession struct {
+	metadata  *SessionMetadata
+	messages  []string // User messages in order
+	toolCalls []string // Tool calls as name(compact input), in order
+	approvals []bool   // Answers to approval questions, in order
+	reply     string   // The last assistant text
+}
+
+// runReproduceCommand implements `go-agent reproduce LOG`
+func runReproduceCommand(args []string) error {
+	flags := flag.NewFlagSet("reproduce", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("usage: go-agent [--log NEW.jsonl] reproduce LOG (a --log or --audit file)")
+	}
+
+	recorded, err := readRecordedSession(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	original := recorded.metadata
+	if original == nil {
+		return fmt.Errorf("%s has no session_start event; it was recorded before reproducibility metadata existed", flags.Arg(0))
+	}
+	if len(original.Args) > 0 {
+		if command := subcommandOf(original.Args); command != "" {
+			return fmt.Errorf("reproduce replays chat and -p sessions; re-run this %s session with: go-agent %s", command, strings.Join(original.Args, " "))
+		}
+	}
+	if len(recorded.messages) == 0 {
+		return fmt.Errorf("%s has no user messages to replay", flags.Arg(0))
+	}
+
+	// Use the recorded settings, and say what could still make the run differ
+	runOptions.Approval, runOptions.MaxTurns = original.Approval, original.MaxTurns
+	runOptions.Temperature, runOptions.TopP, runOptions.StopSequences = original.Temperature, original.TopP, original.Stop
+	runOptions.ThinkingBudget = original.Thinking
+	if original.MaxTokens > 0 {
+		runOptions.MaxTokens = original.MaxTokens
+	}
+	if original.Mode != runOptions.Mode {
+		return fmt.Errorf("the session ran with --mode %q and this run has --mode %q; pass the same --mode to reproduce it", original.Mode, runOptions.Mode)
+	}
+	if original.PolicyFile != runOptions.PolicyFile {
+		return fmt.Errorf("the session ran with --policy %q and this run has --policy %q; pass the same --policy to reproduce it", original.PolicyFile, runOptions.PolicyFile)
+	}
+	for _, difference := range compareMetadata(original, sessionMetadata()) {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleWarning, "warning"), difference)
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+	agent := NewAgent(client, nil, defaultTools())
+	approvals := recorded.approvals
+	agent.approver = func(question string) bool {
+		if len(approvals) == 0 {
+			fmt.Fprintf(os.Stderr, "%s: the original session answered no more approvals; denying %s\n", paint(roleWarning, "reproduce"), strings.TrimSuffix(question, " [y/N] "))
+			return false
+		}
+		answer := approvals[0]
+		approvals = approvals[1:]
+		return answer
+	}
+
+	ctx := context.TODO()
+	conversation := []anthropic.MessageParam{}
+	for i, message := range recorded.messages {
+		fmt.Fprintf(os.Stderr, "%s: message %d/%d\n", paint(roleStatus, "reproduce"), i+1, len(recorded.messages))
+		conversation = append(conversation, anthropic.NewUserMessage(agent.buildUserMessage(message)...))
+		if conversation, err = agent.runTurn(ctx, conversation); err != nil {
+			return err
+		}
+	}
+
+	reportReproduction(recorded, conversation)
+	return nil
+}
+
+// subcommandOf returns the subcommand in recorded command-line arguments, if any
+func subcommandOf(args []string) string {
+	// Parse into copies of the global flags so the live options are untouched
+	copies := flag.NewFlagSet("recorded", flag.ContinueOnError)
+	copies.SetOutput(&bytes.Buffer{})
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if _, isBool := getter.Get().(bool); isBool {
+				copies.Bool(f.Name, false, "")
+				return
+			}
+		}
+		copies.String(f.Name, "", "")
+	})
+	if err := copies.Parse(args); err != nil || copies.NArg() == 0 {
+		return ""
+	}
+	return copies.Arg(0)
+}
+
+// compareMetadata lists the differences that can change the model's behavior
+func compareMetadata(original, current *SessionMetadata) []string {
+	differences := []string{}
+	check := func(what, was, now string) {
+		if was != now {
+			differences = append(differences, fmt.Sprintf("%s was %s and is now %s", what, orNone(was), orNone(now)))
+		}
+	}
+	check("go-agent", original.AgentVersion, current.AgentVersion)
+	check("the SDK", original.SDKVersion, current.SDKVersion)
+	check("the model", original.Model, current.Model)
+	check("max tokens", fmt.Sprint(original.MaxTokens), fmt.Sprint(current.MaxTokens))
+	check("the policy", original.PolicyHash, current.PolicyHash)
+	check("the system prompt", original.SystemHash, current.SystemHash)
+	check("the snapshot", original.SnapshotHash, current.SnapshotHash)
+	check("the git commit", original.GitCommit, current.GitCommit)
+	for name, hash := range original.Tools {
+		check("tool "+name, hash, current.Tools[name])
+	}
+	for name := range current.Tools {
+		if _, ok := original.Tools[name]; !ok {
+			differences = append(differences, "tool "+name+" is new")
+		}
+	}
+	if current.GitDirty {
+		differences = append(differences, "the workspace has uncommitted changes")
+	}
+	return differences
+}
+
+// orNone shows an empty setting as none
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+// reportReproduction compares the reproduced tool calls and reply with the recording
+func reportReproduction(recorded *recordedSession, conversation []anthropic.MessageParam) {
+	calls := []string{}
+	for _, message := range conversation {
+		for _, block := range message.Content {
+			if block.OfToolUse != nil {
+				input, _ := json.Marshal(block.OfToolUse.Input)
+				calls = append(calls, block.OfToolUse.Name+"("+compactJSON(input)+")")
+			}
+		}
+	}
+
+	fmt.Printf("reproduce: %d tool call(s) originally, %d now\n", len(recorded.toolCalls), len(calls))
+	for i := 0; i < len(calls) || i < len(recorded.toolCalls); i++ {
+		was, now := "(none)", "(none)"
+		if i < len(recorded.toolCalls) {
+			was = recorded.toolCalls[i]
+		}
+		if i < len(calls) {
+			now = calls[i]
+		}
+		if was != now {
+			fmt.Printf("reproduce: diverged at tool call %d\n  originally: %s\n  now:        %s\n", i+1, was, now)
+			return
+		}
+	}
+	if strings.TrimSpace(lastAssistantText(conversation)) != strings.TrimSpace(recorded.reply) {
+		fmt.Println("reproduce: same tool calls, different final reply")
+		return
+	}
+	fmt.Println("reproduce: identical tool calls and final reply")
+}
+
+// readRecordedSession reads the first session of an event log or audit trail
+func readRecordedSession(path string) (*recordedSession, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	recorded := &recordedSession{}
+	mutating := map[string]bool{}
+	pending := map[string]bool{} // Mutating tool calls waiting for their result
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record struct {
+			Event
+			AuditEvent *Event `json:"event"` // Set in audit trail entries
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d is not an event: %w", path, line, err)
+		}
+		event := record.Event
+		if record.AuditEvent != nil {
+			event = *record.AuditEvent
+		}
+
+		switch event.Type {
+		case eventSessionStart:
+			if recorded.metadata != nil {
+				return recorded, nil // Only the first session of a shared log
+			}
+			recorded.metadata = event.Metadata
+			for _, tool := range defaultTools() {
+				mutating[tool.Name] = tool.Mutating
+			}
+		case eventUserMessage:
+			recorded.messages = append(recorded.messages, event.Text)
+		case eventAssistantText:
+			recorded.reply = event.Text
+		case eventToolUse:
+			recorded.toolCalls = append(recorded.toolCalls, event.Tool+"("+compactJSON(event.Input)+")")
+			if mutating[event.Tool] {
+				pending[event.ToolUseID] = true
+			}
+		case eventToolResult, eventToolDenied:
+			if pending[event.ToolUseID] && recorded.metadata != nil && recorded.metadata.Approval == approvalAsk {
+				recorded.approvals = append(recorded.approvals, event.Type == eventToolResult)
+			}
+			delete(pending, event.ToolUseID)
+		}
+	}
+	return recorded, scanner.Err()
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// SESSION RETENTION
+// =============================================================================
+
+// Retention limits how many saved sessions are kept for each directory they ran in.
+// Sessions past a limit are removed when the next chat or -p task starts, and by
+// `go-agent gc`. Pinned sessions are always kept.
+type Retention struct {
+	RetentionLimits
+	Projects []ProjectRetention `json:"projects"` // Overrides for some directories; the last match applies
+}
+
+// RetentionLimits are the limits of one directory's sessions. Zero means no limit.
+type RetentionLimits struct {
+	KeepDays     int    `json:"keep_days"`     // Remove sessions whose last turn is older
+	KeepSessions int    `json:"keep_sessions"` // Keep only the latest sessions
+	MaxSize      string `json:"max_size"`      // Remove the oldest sessions beyond a total size such as 200MB
+
+	maxBytes int64
+}
+
+// ProjectRetention overrides the limits for the directories its path matches
+type ProjectRetention struct {
+	Path         string  `json:"path"` // Directory, or a glob where ** stands for any number of directories; ~ is the home directory
+	KeepDays     *int    `json:"keep_days"`
+	KeepSessions *int    `json:"keep_sessions"`
+	MaxSize      *string `json:"max_size"`
+}
+
+// sessionFile is a saved session as retention sees it
+type sessionFile struct {
+	path      string
+	id        string
+	workspace string
+	updated   time.Time
+	size      int64
+	pinned    bool
+	title     string
+	labels    []string
+	usage     UsageStats
+}
+
+// loadRetention reads the default limits from SESSION_KEEP_DAYS, SESSION_KEEP_SESSIONS
+// and SESSION_MAX_SIZE, and the project overrides from the retention file, if any
+func loadRetention(path string) (*Retention, error) {
+	retention := &Retention{}
+	retention.KeepDays = configInt("SESSION_KEEP_DAYS", 0)
+	retention.KeepSessions = configInt("SESSION_KEEP_SESSIONS", 0)
+	retention.MaxSize = configValue("SESSION_MAX_SIZE")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read retention policy: %w", err)
+		}
+		overrides := &Retention{}
+		if err := json.Unmarshal(data, overrides); err != nil {
+			return nil, fmt.Errorf("failed to parse retention policy %s: %w", path, err)
+		}
+		if overrides.KeepDays != 0 || overrides.KeepSessions != 0 || overrides.MaxSize != "" {
+			retention.RetentionLimits = overrides.RetentionLimits
+		}
+		retention.Projects = overrides.Projects
+	}
+
+	var err error
+	if retention.maxBytes, err = parseSize(retention.MaxSize); err != nil {
+		return nil, err
+	}
+	for i, project := range retention.Projects {
+		if project.Path == "" {
+			return nil, fmt.Errorf("retention policy %s: project %d has no path", path, i+1)
+		}
+		if project.MaxSize != nil {
+			if _, err := parseSize(*project.MaxSize); err != nil {
+				return nil, fmt.Errorf("retention policy %s: %s: %w", path, project.Path, err)
+			}
+		}
+	}
+	return retention, nil
+}
+
+// parseSize reads a size such as 500MB, 2GB or 4096; empty means no limit
+func parseSize(text string) (int64, error) {
+	if text == "" {
+		return 0, nil
+	}
+	number, unit := strings.ToUpper(strings.TrimSpace(text)), int64(1)
+	for _, suffix := range []struct {
+		name string
+		size int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if rest, ok := strings.CutSuffix(number, suffix.name); ok {
+			number, unit = strings.TrimSpace(rest), suffix.size
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	return int64(size * float64(unit)), nil
+}
+
+// limits returns the limits for the sessions of workspace
+func (r *Retention) limits(workspace string) RetentionLimits {
+	if r == nil {
+		return RetentionLimits{}
+	}
+	limits := r.RetentionLimits
+	for _, project := range r.Projects {
+		pattern := project.Path
+		if rest, ok := strings.CutPrefix(pattern, "~/"); ok {
+			home, _ := os.UserHomeDir()
+			pattern = filepath.Join(home, rest)
+		}
+		pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		if !matchPathPattern(pattern, filepath.ToSlash(workspace)) {
+			continue
+		}
+		if project.KeepDays != nil {
+			limits.KeepDays = *project.KeepDays
+		}
+		if project.KeepSessions != nil {
+			limits.KeepSessions = *project.KeepSessions
+		}
+		if project.MaxSize != nil {
+			limits.MaxSize = *project.MaxSize
+			limits.maxBytes, _ = parseSize(*project.MaxSize)
+		}
+	}
+	return limits
+}
+
+// listSessionFiles reads the header of every saved session
+func listSessionFiles() []sessionFile {
+	paths, _ := filepath.Glob(filepath.Join(sessionsDir(), "*.json"))
+	sessions := []sessionFile{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var header struct {
+			ID        string     `json:"id"`
+			Updated   time.Time  `json:"updated"`
+			Workspace string     `json:"workspace"`
+			Pinned    bool       `json:"pinned"`
+			Title     string     `json:"title"`
+			Labels    []string   `json:"labels"`
+			Usage     UsageStats `json:"usage"`
+		}
+		if json.Unmarshal(data, &header) != nil {
+			continue
+		}
+		if header.Updated.IsZero() {
+			header.Updated = info.ModTime()
+		}
+		sessions = append(sessions, sessionFile{
+			path:      path,
+			id:        strings.TrimSuffix(filepath.Base(path), ".json"),
+			workspace: header.Workspace,
+			updated:   header.Updated,
+			size:      info.Size(),
+			pinned:    header.Pinned,
+			title:     header.Title,
+			labels:    header.Labels,
+			usage:     header.Usage,
+		})
+	}
+	return sessions
+}
+
+// expired returns the sessions past the limits of their directory. Pinned sessions and
+// the session with the ID current are kept, but count toward the limits.
+func (r *Retention) expired(sessions []sessionFile, current string) []sessionFile {
+	byWorkspace := map[string][]sessionFile{}
+	for _, session := range sessions {
+		byWorkspace[session.workspace] = append(byWorkspace[session.workspace], session)
+	}
+
+	expired := []sessionFile{}
+	for workspace, sessions := range byWorkspace {
+		limits := r.limits(workspace)
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].updated.After(sessions[j].updated) })
+		kept, size := 0, int64(0)
+		for _, session := range sessions {
+			keep := session.pinned || session.id == current
+			tooOld := limits.KeepDays > 0 && time.Since(session.updated) > time.Duration(limits.KeepDays)*24*time.Hour
+			tooMany := limits.KeepSessions > 0 && kept >= limits.KeepSessions
+			tooBig := limits.maxBytes > 0 && size+session.size > limits.maxBytes
+			if !keep && (tooOld || tooMany || tooBig) {
+				expired = append(expired, session)
+				continue
+			}
+			kept++
+			size += session.size
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].updated.Before(expired[j].updated) })
+	return expired
+}
+
+// apply removes the saved sessions past the retention limits, except current,
+// and returns how many there were and their size. With dryRun nothing is removed.
+func (r *Retention) apply(current string, dryRun bool) (int, int64) {
+	removed, freed := 0, int64(0)
+	for _, session := range r.expired(listSessionFiles(), current) {
+		if !dryRun && os.Remove(session.path) != nil {
+			continue
+		}
+		removed++
+		freed += session.size
+	}
+	return removed, freed
+}
+
+// setPinned pins or unpins the saved session with the given ID
+func setPinned(id string, pinned bool) error {
+	session, err := loadSavedSession(id)
+	if err != nil {
+		return err
+	}
+	session.Pinned = pinned
+	return session.write()
+}
+
+// runPinCommand implements `go-agent pin [--unpin] [session...]`
+func runPinCommand(args []string) error {
+	flags := flag.NewFlagSet("pin", flag.ContinueOnError)
+	unpin := flags.Bool("unpin", false, "let retention remove the sessions again")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	if flags.NArg() == 0 {
+		if *unpin {
+			return usageErrorf("usage: go-agent pin [--unpin] [session...]")
+		}
+		pinned := 0
+		for _, session := range listSessionFiles() {
+			if session.pinned {
+				fmt.Printf("%s  %s\n", session.id, session.workspace)
+				pinned++
+			}
+		}
+		if pinned == 0 {
+			fmt.Println("No pinned sessions. Pin one with `go-agent pin ID`.")
+		}
+		return nil
+	}
+
+	for _, id := range flags.Args() {
+		if err := setPinned(id, !*unpin); err != nil {
+			return err
+		}
+		if *unpin {
+			fmt.Printf("Unpinned %s\n", id)
+		} else {
+			fmt.Printf("Pinned %s; retention and go-agent gc keep it\n", id)
+		}
+	}
+	return nil
+}
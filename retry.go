@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// =============================================================================
+// API RETRIES
+// =============================================================================
+
+// Defaults of the retry settings
+const (
+	defaultAPIRetries      = 4  // API_RETRIES: attempts after the first
+	defaultRetryMaxSeconds = 60 // API_RETRY_MAX_SECONDS: longest wait between attempts
+	retryBaseDelay         = time.Second
+)
+
+// retryJitter and retryNow are the randomness and the clock of the retry delays, which
+// tests replace
+var (
+	retryJitter = rand.N[time.Duration]
+	retryNow    = time.Now
+)
+
+// streamErrorPrefix starts the error the SDK returns for an error event in a stream
+const streamErrorPrefix = "received error while streaming"
+
+// sendWithRetry makes a model call, trying again with jittered exponential backoff when
+// the API is overloaded, rate limits the call or fails on its side. A Retry-After from
+// the API is waited out instead. The SDK's own retries are turned off for the call, so
+// API_RETRIES is the whole budget. A stream that fails after part of the answer was
+// printed is not retried, as the answer would be printed twice.
+func (a *Agent) sendWithRetry(ctx context.Context, params anthropic.MessageNewParams, opts ...option.RequestOption) (*anthropic.Message, error) {
+	retries := max(configInt("API_RETRIES", defaultAPIRetries), 0)
+	maxDelay := time.Duration(max(configInt("API_RETRY_MAX_SECONDS", defaultRetryMaxSeconds), 1)) * time.Second
+	opts = append(opts, option.WithMaxRetries(0))
+
+	for attempt := 1; ; attempt++ {
+		message, err := a.sendMessage(ctx, params, opts...)
+		reason := retryReason(err)
+		if err == nil || reason == "" || attempt > retries || ctx.Err() != nil || a.partial {
+			return message, err
+		}
+
+		delay := retryDelay(err, attempt, maxDelay)
+		text := fmt.Sprintf("%s; retrying in %s (%d of %d)", reason, delay.Round(100*time.Millisecond), attempt, retries)
+		fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleWarning, "warning"), text)
+		a.events.Emit(Event{Type: eventStatus, Text: text})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns how long to wait after the given failed attempt: the Retry-After of
+// the API when it sent one, or else jittered exponential backoff, and never more than
+// maxDelay
+func retryDelay(err error, attempt int, maxDelay time.Duration) time.Duration {
+	delay, fromAPI := retryAfter(err)
+	if !fromAPI {
+		// The backoff stops doubling at maxDelay, so many retries cannot overflow it
+		backoff := retryBaseDelay
+		for i := 1; i < attempt && backoff < maxDelay; i++ {
+			backoff *= 2
+		}
+		backoff = min(backoff, maxDelay)
+		delay = backoff/2 + retryJitter(backoff/2+1)
+	}
+	return min(delay, maxDelay)
+}
+
+// retryReason describes why a failed call is worth trying again, or returns "" when it
+// would fail the same way
+func retryReason(err error) string {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ""
+	}
+	var apiErr *anthropic.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == 529 || strings.Contains(apiErr.RawJSON(), "overloaded_error"):
+			return "the API is overloaded"
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return "the API rate limit was reached"
+		case apiErr.StatusCode == http.StatusRequestTimeout || apiErr.StatusCode == http.StatusConflict:
+			return fmt.Sprintf("the API returned %d", apiErr.StatusCode)
+		case apiErr.StatusCode >= 500:
+			return fmt.Sprintf("the API failed with %d", apiErr.StatusCode)
+		}
+		return ""
+	}
+
+	// Errors in the middle of a stream arrive as an event rather than a status code
+	if message := err.Error(); strings.HasPrefix(message, streamErrorPrefix) {
+		if strings.Contains(message, "overloaded_error") {
+			return "the API is overloaded"
+		}
+		if strings.Contains(message, "api_error") {
+			return "the API failed while streaming"
+		}
+		return ""
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, net.ErrClosed) || strings.Contains(err.Error(), "connection reset") || strings.Contains(err.Error(), "unexpected EOF") {
+		return "the connection to the API failed"
+	}
+	return ""
+}
+
+// retryAfter returns how long the API asked to wait, from Retry-After-Ms or Retry-After
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *anthropic.Error
+	if !errors.As(err, &apiErr) || apiErr.Response == nil {
+		return 0, false
+	}
+	header := apiErr.Response.Header
+	if value := header.Get("Retry-After-Ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(retryNow()), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// fixRetryClock makes the retry delays deterministic: the clock stands at now and the
+// jitter is always its largest
+func fixRetryClock(t *testing.T, now time.Time) {
+	t.Helper()
+	jitter, clock := retryJitter, retryNow
+	retryJitter = func(n time.Duration) time.Duration { return n - 1 }
+	retryNow = func() time.Time { return now }
+	t.Cleanup(func() { retryJitter, retryNow = jitter, clock })
+}
+
+// apiError is an error of the API with the given status code and response headers
+func apiError(status int, headers ...string) *anthropic.Error {
+	header := http.Header{}
+	for i := 0; i+1 < len(headers); i += 2 {
+		header.Set(headers[i], headers[i+1])
+	}
+	return &anthropic.Error{StatusCode: status, Response: &http.Response{StatusCode: status, Header: header}}
+}
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fixRetryClock(t, now)
+	tests := []struct {
+		name     string
+		err      error
+		attempt  int
+		maxDelay time.Duration
+		want     time.Duration
+	}{
+		{"first backoff", apiError(529), 1, time.Minute, time.Second},
+		{"backoff doubles", apiError(529), 3, time.Minute, 4 * time.Second},
+		{"backoff is capped", apiError(529), 10, 5 * time.Second, 5 * time.Second},
+		{"many attempts do not overflow", apiError(529), 200, time.Minute, time.Minute},
+		{"retry-after seconds", apiError(429, "Retry-After", "7"), 1, time.Minute, 7 * time.Second},
+		{"retry-after date", apiError(429, "Retry-After", now.Add(12*time.Second).Format(http.TimeFormat)), 1, time.Minute, 12 * time.Second},
+		{"retry-after date passed", apiError(429, "Retry-After", now.Add(-time.Minute).Format(http.TimeFormat)), 1, time.Minute, 0},
+		{"retry-after-ms wins", apiError(429, "Retry-After-Ms", "250", "Retry-After", "30"), 1, time.Minute, 250 * time.Millisecond},
+		{"invalid retry-after-ms falls back", apiError(429, "Retry-After-Ms", "soon", "Retry-After", "3"), 1, time.Minute, 3 * time.Second},
+		{"retry-after replaces backoff", apiError(429, "Retry-After", "0"), 4, time.Minute, 0},
+		{"retry-after is capped", apiError(429, "Retry-After", "3600"), 1, 10 * time.Second, 10 * time.Second},
+		{"invalid retry-after backs off", apiError(503, "Retry-After", "later"), 2, time.Minute, 2 * time.Second},
+		{"network error backs off", fmt.Errorf("read: connection reset by peer"), 2, time.Minute, 2 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := retryDelay(test.err, test.attempt, test.maxDelay); got != test.want {
+				t.Errorf("retryDelay(attempt %d) = %s, want %s", test.attempt, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	fixRetryClock(t, time.Now())
+	retryJitter = func(n time.Duration) time.Duration { return 0 }
+	// With no jitter the wait is half the backoff, and never less
+	if got := retryDelay(apiError(529), 3, time.Minute); got != 2*time.Second {
+		t.Errorf("retryDelay without jitter = %s, want 2s", got)
+	}
+}
+
+// streamingAPI answers each model call with the events of respond, as a stream, or with
+// a 529 that asks to retry at once when respond returns "". It counts the calls.
+func streamingAPI(t *testing.T, respond func(call int32) string) (*anthropic.Client, *atomic.Int32) {
+	t.Helper()
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events := respond(calls.Add(1))
+		if events == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After-Ms", "0")
+			w.WriteHeader(529)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, events)
+	}))
+	t.Cleanup(server.Close)
+	client := anthropic.NewClient(option.WithBaseURL(server.URL), option.WithAPIKey("sk-ant-test"))
+	return &client, calls
+}
+
+// Events of a streamed response
+const (
+	streamStart = "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_01\",\"type\":\"message\",\"role\":\"assistant\",\"model\":\"claude-sonnet-4-20250514\",\"content\":[],\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n"
+	streamText  = "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hello\"}}\n\n"
+	streamEnd = "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":5}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	streamOverloaded = "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"
+)
+
+func TestSendWithRetry(t *testing.T) {
+	t.Setenv("STREAM", "true")
+	t.Setenv("API_RETRIES", "2")
+	params := anthropic.MessageNewParams{Model: "claude-sonnet-4-20250514", MaxTokens: 10, Messages: []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("hi"))}}
+
+	t.Run("overloaded", func(t *testing.T) {
+		client, calls := streamingAPI(t, func(call int32) string {
+			if call == 1 {
+				return ""
+			}
+			return streamStart + streamText + streamEnd
+		})
+		agent := &Agent{client: client}
+		message, err := agent.sendWithRetry(context.Background(), params)
+		if err != nil || len(message.Content) != 1 || calls.Load() != 2 {
+			t.Errorf("got %v, %v after %d calls, want the answer of the second call", message, err, calls.Load())
+		}
+	})
+
+	t.Run("no retry after partial text", func(t *testing.T) {
+		client, calls := streamingAPI(t, func(call int32) string {
+			return streamStart + streamText + streamOverloaded
+		})
+		agent := &Agent{client: client}
+		_, err := agent.sendWithRetry(context.Background(), params)
+		if retryReason(err) == "" || !agent.partial || calls.Load() != 1 {
+			t.Errorf("got %v with partial %v after %d calls, want the retryable error of the only call", err, agent.partial, calls.Load())
+		}
+	})
+
+	t.Run("retries run out", func(t *testing.T) {
+		client, calls := streamingAPI(t, func(call int32) string {
+			return ""
+		})
+		agent := &Agent{client: client}
+		if _, err := agent.sendWithRetry(context.Background(), params); err == nil || calls.Load() != 3 {
+			t.Errorf("got %v after %d calls, want an error after the first call and 2 retries", err, calls.Load())
+		}
+	})
+}
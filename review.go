@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// REVIEW WORKFLOW AND FINDING FORMATS
+// =============================================================================
+
+// Output formats understood by `review --format`
+const (
+	findingFormatText   = "text"
+	findingFormatSARIF  = "sarif"
+	findingFormatGitHub = "github"
+)
+
+// Finding severities, matching SARIF result levels
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityNote    = "note"
+)
+
+// reviewPrompt asks Claude to review code and report findings through the tool
+const reviewPrompt = "Review %s for bugs, security problems, and maintainability issues. " +
+	"Read the code with the available tools. Report every concrete problem with the %s tool, " +
+	"one call per problem, pointing at the exact lines. Do not report style nits or speculation. " +
+	"When you are done, reply with a one-paragraph overall assessment.%s"
+
+// Finding is a single problem reported during a review
+type Finding struct {
+	Path     string `json:"path" jsonschema_description:"Relative path of the file containing the problem."`
+	Line     int    `json:"line" jsonschema_description:"1-based line where the problem starts."`
+	EndLine  int    `json:"end_line,omitempty" jsonschema_description:"Optional 1-based line where the problem ends."`
+	Severity string `json:"severity" jsonschema:"enum=error,enum=warning,enum=note" jsonschema_description:"error for bugs and security problems, warning for likely problems, note for suggestions."`
+	Rule     string `json:"rule" jsonschema_description:"Short kebab-case identifier for the kind of problem, e.g. nil-dereference."`
+	Message  string `json:"message" jsonschema_description:"What is wrong and how to fix it."`
+}
+
+// FindingInputSchema - Auto-generated JSON schema for Finding
+var FindingInputSchema = GenerateSchema[Finding]()
+
+// reportFindingName is the name of the tool Claude reports findings with
+const reportFindingName = "report_finding"
+
+// FindingCollector gathers findings reported by Claude during a review
+type FindingCollector struct {
+	mu       sync.Mutex
+	findings []Finding
+}
+
+// Definition returns the report_finding tool bound to this collector
+func (c *FindingCollector) Definition() ToolDefinition {
+	return ToolDefinition{
+		Name:        reportFindingName,
+		Description: "Report one problem found during the review, with its location, severity and an explanation.",
+		InputSchema: FindingInputSchema,
+		Function: func(input json.RawMessage) (string, error) {
+			finding := Finding{}
+			if err := json.Unmarshal(input, &finding); err != nil {
+				return "", fmt.Errorf("invalid input format: %w", err)
+			}
+			if finding.Path == "" || finding.Line < 1 || finding.Message == "" {
+				return "", fmt.Errorf("path, line and message are required")
+			}
+			switch finding.Severity {
+			case severityError, severityWarning, severityNote:
+			default:
+				finding.Severity = severityWarning
+			}
+			if finding.Rule == "" {
+				finding.Rule = "general"
+			}
+			finding.Path = filepath.ToSlash(filepath.Clean(finding.Path))
+
+			c.mu.Lock()
+			c.findings = append(c.findings, finding)
+			c.mu.Unlock()
+			return "Recorded.", nil
+		},
+	}
+}
+
+// Findings returns the collected findings ordered by file and line
+func (c *FindingCollector) Findings() []Finding {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	findings := append([]Finding{}, c.findings...)
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings
+}
+
+// runReviewCommand implements `go-agent review [paths...]`
+func runReviewCommand(args []string) error {
+	flags := flag.NewFlagSet("review", flag.ContinueOnError)
+	format := flags.String("format", findingFormatText, "text, sarif or github (Actions annotations)")
+	output := flags.String("output", "", "write findings to this file instead of stdout (required for sarif)")
+	diff := flags.Bool("diff", false, "review the uncommitted changes from git diff instead of whole files")
+	failOn := flags.String("fail-on", "", "exit non-zero if a finding of this severity or worse is reported (error, warning or note)")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	switch *format {
+	case findingFormatText, findingFormatSARIF, findingFormatGitHub:
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	switch *failOn {
+	case "", severityError, severityWarning, severityNote:
+	default:
+		return fmt.Errorf("unknown --fail-on severity %q", *failOn)
+	}
+	if *format == findingFormatSARIF && *output == "" {
+		return fmt.Errorf("--format sarif requires --output, since the review transcript is printed to stdout")
+	}
+
+	ctx := context.TODO()
+
+	targets := flags.Args()
+	if len(targets) == 0 {
+		targets = []string{"."}
+	}
+	subject := "the code in " + strings.Join(targets, ", ")
+	extra := ""
+	if *diff {
+		result, err := runProgram(ctx, "git", append([]string{"diff", "HEAD", "--"}, targets...)...)
+		if err != nil {
+			return err
+		}
+		if !result.Passed() {
+			return fmt.Errorf("git diff failed: %s", strings.TrimSpace(result.Output))
+		}
+		if strings.TrimSpace(result.Output) == "" {
+			fmt.Println("review: no uncommitted changes to review")
+			return nil
+		}
+		subject = "the uncommitted changes below"
+		extra = "\n\nOnly report problems in changed lines.\n\n```diff\n" + truncateToTokens(result.Output, maxGuideTokens) + "\n```"
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	collector := &FindingCollector{}
+	agent := NewAgent(client, nil, append(readOnlyTools(), collector.Definition()))
+
+	prompt := fmt.Sprintf(reviewPrompt, subject, reportFindingName, extra)
+	conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt))}
+	if _, err := agent.runTurn(ctx, conversation); err != nil {
+		return err
+	}
+
+	findings := collector.Findings()
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	switch *format {
+	case findingFormatText:
+		writeFindingsText(out, findings)
+	case findingFormatSARIF:
+		if err := writeFindingsSARIF(out, findings); err != nil {
+			return err
+		}
+	case findingFormatGitHub:
+		writeFindingsGitHub(out, findings)
+	}
+	if *output != "" {
+		fmt.Printf("review: wrote %d finding(s) to %s\n", len(findings), *output)
+	}
+
+	if *failOn != "" {
+		if count := countAtLeast(findings, *failOn); count > 0 {
+			return fmt.Errorf("%d finding(s) at severity %s or worse", count, *failOn)
+		}
+	}
+	return nil
+}
+
+// writeFindingsText prints findings in a compiler-like path:line format
+func writeFindingsText(w io.Writer, findings []Finding) {
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "No findings.")
+		return
+	}
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s:%d: %s [%s] %s\n", f.Path, f.Line, f.Severity, f.Rule, f.Message)
+	}
+}
+
+// writeFindingsSARIF writes findings as a SARIF 2.1.0 log for code-scanning dashboards
+func writeFindingsSARIF(w io.Writer, findings []Finding) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type region struct {
+		StartLine int `json:"startLine"`
+		EndLine   int `json:"endLine,omitempty"`
+	}
+	type location struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region region `json:"region"`
+		} `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId"`
+		Level     string     `json:"level"`
+		Message   message    `json:"message"`
+		Locations []location `json:"locations"`
+	}
+	type rule struct {
+		ID               string  `json:"id"`
+		ShortDescription message `json:"shortDescription"`
+	}
+
+	rules := []rule{}
+	seenRules := map[string]bool{}
+	results := []result{}
+	for _, f := range findings {
+		if !seenRules[f.Rule] {
+			seenRules[f.Rule] = true
+			rules = append(rules, rule{ID: f.Rule, ShortDescription: message{Text: f.Rule}})
+		}
+
+		loc := location{}
+		loc.PhysicalLocation.ArtifactLocation.URI = f.Path
+		loc.PhysicalLocation.Region = region{StartLine: f.Line}
+		if f.EndLine > f.Line {
+			loc.PhysicalLocation.Region.EndLine = f.EndLine
+		}
+		results = append(results, result{RuleID: f.Rule, Level: f.Severity, Message: message{Text: f.Message}, Locations: []location{loc}})
+	}
+
+	log := map[string]any{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []any{map[string]any{
+			"tool": map[string]any{
+				"driver": map[string]any{
+					"name":           "go-agent",
+					"informationUri": "https://github.com/timobuilds/go-agent",
+					"rules":          rules,
+				},
+			},
+			"results": results,
+		}},
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+// writeFindingsGitHub prints findings as GitHub Actions workflow commands, which show up
+// as inline annotations on the pull request
+func writeFindingsGitHub(w io.Writer, findings []Finding) {
+	for _, f := range findings {
+		level := f.Severity
+		if level == severityNote {
+			level = "notice"
+		}
+
+		properties := fmt.Sprintf("file=%s,line=%d", escapeAnnotationProperty(f.Path), f.Line)
+		if f.EndLine > f.Line {
+			properties += fmt.Sprintf(",endLine=%d", f.EndLine)
+		}
+		properties += ",title=" + escapeAnnotationProperty(f.Rule)
+
+		fmt.Fprintf(w, "::%s %s::%s\n", level, properties, escapeAnnotationData(f.Message))
+	}
+}
+
+// escapeAnnotationData escapes a workflow command message
+func escapeAnnotationData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeAnnotationProperty escapes a workflow command property value
+func escapeAnnotationProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// countAtLeast counts findings at the given severity or worse
+func countAtLeast(findings []Finding, severity string) int {
+	rank := map[string]int{severityNote: 1, severityWarning: 2, severityError: 3}
+	count := 0
+	for _, f := range findings {
+		if rank[f.Severity] >= rank[severity] {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// EDITOR PROTOCOL (JSON-RPC)
+// =============================================================================
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcSessionBusy    = -32001 // session/send while the session is already working
+	rpcCancelled      = -32800 // The client cancelled the request with $/cancelRequest
+)
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error of a JSON-RPC response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements error
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// RPCOpenParams are the parameters of session/open: what the editor can do for the agent
+type RPCOpenParams struct {
+	ApplyEdit   bool `json:"applyEdit"`   // Send edits as workspace/applyEdit instead of writing files
+	PreviewDiff bool `json:"previewDiff"` // Ask editor/previewDiff before each edit
+}
+
+// RPCSelection is editor content sent along with a message
+type RPCSelection struct {
+	Path      string `json:"path"`
+	StartLine int    `json:"startLine"` // 1-based, inclusive
+	EndLine   int    `json:"endLine"`
+	Text      string `json:"text"`
+}
+
+// RPCSendParams are the parameters of session/send
+type RPCSendParams struct {
+	SessionID  string         `json:"sessionId"`
+	Text       string         `json:"text"`
+	Selections []RPCSelection `json:"selections,omitempty"`
+}
+
+// RPCSendResult is the result of session/send
+type RPCSendResult struct {
+	Reply       string   `json:"reply"`
+	EditedFiles []string `json:"editedFiles"`
+}
+
+// rpcConn speaks JSON-RPC with LSP-style Content-Length framing, in both directions
+type rpcConn struct {
+	reader *bufio.Reader
+
+	writeMu sync.Mutex
+	writer  io.Writer
+
+	mu       sync.Mutex
+	nextID   int
+	pending  map[string]chan rpcMessage    // Our requests waiting for the editor's response
+	cancels  map[string]context.CancelFunc // The editor's requests that are running
+	sessions map[string]*rpcSession
+	buffers  *editorBuffers // Files open in the editor, shared by the connection's sessions
+}
+
+// rpcSession is one conversation opened by session/open
+type rpcSession struct {
+	id           string
+	conn         *rpcConn
+	options      RPCOpenParams
+	mu           sync.Mutex // Held while a message is being answered
+	agent        *Agent
+	conversation []anthropic.MessageParam
+}
+
+// runRPCCommand implements `go-agent rpc`
+func runRPCCommand(args []string) error {
+	flags := flag.NewFlagSet("rpc", flag.ContinueOnError)
+	listen := flags.String("listen", "", "serve editors on this socket instead of stdio: unix:PATH or HOST:PORT")
+	nvim := flags.Bool("nvim", false, "print a reference Neovim client and exit")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if *nvim {
+		fmt.Print(nvimPlugin)
+		return nil
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	if *listen == "" {
+		// stdout carries the protocol, so everything the agent prints goes to stderr
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		return newRPCConn(os.Stdin, stdout).serve(client)
+	}
+
+	network, address := "tcp", *listen
+	if path, ok := strings.CutPrefix(*listen, "unix:"); ok {
+		network, address = "unix", path
+		os.Remove(path)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rpc: listening on %s\n", *listen)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := newRPCConn(conn, conn).serve(client); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleError, "rpc"), err.Error())
+			}
+		}()
+	}
+}
+
+// newRPCConn creates a connection reading requests from r and writing to w
+func newRPCConn(r io.Reader, w io.Writer) *rpcConn {
+	return &rpcConn{
+		reader:   bufio.NewReader(r),
+		writer:   w,
+		pending:  map[string]chan rpcMessage{},
+		cancels:  map[string]context.CancelFunc{},
+		sessions: map[string]*rpcSession{},
+		buffers:  newEditorBuffers(),
+	}
+}
+
+// serve handles messages until the editor disconnects. Requests run concurrently, since the
+// agent calls back into the editor while it answers session/send.
+func (c *rpcConn) serve(client *anthropic.Client) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for {
+		body, err := c.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var message rpcMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			c.respond(nil, nil, &rpcError{Code: rpcParseError, Message: err.Error()})
+			continue
+		}
+
+		switch {
+		case message.Method == "":
+			// A response to one of our requests
+			c.mu.Lock()
+			answer, ok := c.pending[string(message.ID)]
+			delete(c.pending, string(message.ID))
+			c.mu.Unlock()
+			if ok {
+				answer <- message
+			}
+		case message.Method == "exit":
+			return nil
+		case message.ID == nil && strings.HasPrefix(message.Method, "textDocument/"):
+			// Buffer changes are applied in order, before any later request runs
+			c.buffers.sync(message.Method, message.Params)
+		case message.Method == "$/cancelRequest":
+			var params struct {
+				ID json.RawMessage `json:"id"`
+			}
+			json.Unmarshal(message.Params, &params)
+			c.mu.Lock()
+			if cancelRequest, ok := c.cancels[string(params.ID)]; ok {
+				cancelRequest()
+			}
+			c.mu.Unlock()
+		default:
+			requestCtx, cancelRequest := context.WithCancel(ctx)
+			if message.ID != nil {
+				c.mu.Lock()
+				c.cancels[string(message.ID)] = cancelRequest
+				c.mu.Unlock()
+			}
+			go func() {
+				defer cancelRequest()
+				result, err := c.handle(requestCtx, client, message)
+				if message.ID == nil {
+					return // Notifications get no response
+				}
+				c.mu.Lock()
+				delete(c.cancels, string(message.ID))
+				c.mu.Unlock()
+				if requestCtx.Err() != nil && ctx.Err() == nil {
+					err = &rpcError{Code: rpcCancelled, Message: "request cancelled"}
+				}
+				c.respond(message.ID, result, err)
+			}()
+		}
+	}
+}
+
+// handle runs one request from the editor
+func (c *rpcConn) handle(ctx context.Context, client *anthropic.Client, message rpcMessage) (any, error) {
+	switch message.Method {
+	case "initialize":
+		return map[string]any{
+			"serverInfo": map[string]string{"name": "go-agent", "version": sessionMetadata().AgentVersion},
+			"methods":    []string{"session/open", "session/send", "session/close"},
+			// Editors send the full text of their open buffers, as with LSP full sync
+			"capabilities": map[string]any{"textDocumentSync": map[string]any{"openClose": true, "change": 1}},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "session/open":
+		params := RPCOpenParams{}
+		if len(message.Params) > 0 {
+			if err := json.Unmarshal(message.Params, &params); err != nil {
+				return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+			}
+		}
+		return map[string]string{"sessionId": c.open(client, params).id}, nil
+	case "session/send":
+		params := RPCSendParams{}
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		session, err := c.session(params.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		return session.send(ctx, params)
+	case "session/close":
+		var params struct {
+			SessionID string `json:"sessionId"`
+		}
+		json.Unmarshal(message.Params, &params)
+		if _, err := c.session(params.SessionID); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		delete(c.sessions, params.SessionID)
+		c.mu.Unlock()
+		return nil, nil
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + message.Method}
+	}
+}
+
+// open starts a session whose events are sent to the editor as session/event notifications
+func (c *rpcConn) open(client *anthropic.Client, options RPCOpenParams) *rpcSession {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	session := &rpcSession{id: hex.EncodeToString(id), conn: c, options: options}
+	session.agent = NewAgent(client, nil, session.tools())
+	session.agent.session = session.id
+	session.agent.events = NewEventLog(rpcEventWriter{session})
+	session.agent.approver = session.confirm
+
+	c.mu.Lock()
+	c.sessions[session.id] = session
+	c.mu.Unlock()
+	return session
+}
+
+// session looks up an open session
+func (c *rpcConn) session(id string) (*rpcSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session, ok := c.sessions[id]
+	if !ok {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "unknown session " + strconv.Quote(id)}
+	}
+	return session, nil
+}
+
+// read reads the body of the next message
+func (c *rpcConn) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length header")
+	}
+
+	body := make([]byte, length)
+	_, err := io.ReadFull(c.reader, body)
+	return body, err
+}
+
+// write sends one message
+func (c *rpcConn) write(message rpcMessage) error {
+	message.JSONRPC = "2.0"
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = fmt.Fprintf(c.writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// respond answers one of the editor's requests
+func (c *rpcConn) respond(id json.RawMessage, result any, err error) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		c.write(rpcMessage{ID: id, Error: rpcErr})
+		return
+	}
+
+	body, _ := json.Marshal(result)
+	c.write(rpcMessage{ID: id, Result: body})
+}
+
+// notify sends a notification to the editor
+func (c *rpcConn) notify(method string, params any) {
+	body, _ := json.Marshal(params)
+	c.write(rpcMessage{Method: method, Params: body})
+}
+
+// call sends a request to the editor and decodes its result into result
+func (c *rpcConn) call(ctx context.Context, method string, params, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	answer := make(chan rpcMessage, 1)
+	c.pending[string(id)] = answer
+	c.mu.Unlock()
+
+	if err := c.write(rpcMessage{ID: id, Method: method, Params: body}); err != nil {
+		return err
+	}
+
+	select {
+	case response := <-answer:
+		if response.Error != nil {
+			return fmt.Errorf("%s: %w", method, response.Error)
+		}
+		return json.Unmarshal(response.Result, result)
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, string(id))
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rpcEventWriter turns the JSON lines of a session's event log into notifications
+type rpcEventWriter struct {
+	session *rpcSession
+}
+
+// Write sends one event as a session/event notification
+func (w rpcEventWriter) Write(p []byte) (int, error) {
+	w.session.conn.notify("session/event", map[string]any{
+		"sessionId": w.session.id,
+		"event":     json.RawMessage(append([]byte{}, p...)),
+	})
+	return len(p), nil
+}
+
+// send answers one message from the editor, with its selections as context
+func (s *rpcSession) send(ctx context.Context, params RPCSendParams) (*RPCSendResult, error) {
+	if !s.mu.TryLock() {
+		return nil, &rpcError{Code: rpcSessionBusy, Message: "the session is still answering the previous message"}
+	}
+	defer s.mu.Unlock()
+
+	blocks := s.agent.buildUserMessage(params.Text)
+	for _, selection := range params.Selections {
+		blocks = append(blocks, anthropic.NewTextBlock(fmt.Sprintf("<selection path=%q lines=\"%d-%d\">\n%s\n</selection>",
+			selection.Path, selection.StartLine, selection.EndLine, selection.Text)))
+	}
+
+	edited := len(s.agent.editedFiles)
+	conversation, err := s.agent.respond(ctx, append(s.conversation, anthropic.NewUserMessage(blocks...)), params.Text)
+	if err != nil {
+		// Keep the conversation as it was before the failed turn
+		return nil, err
+	}
+	s.conversation = conversation
+
+	return &RPCSendResult{
+		Reply:       strings.TrimSpace(lastAssistantText(conversation)),
+		EditedFiles: append([]string{}, s.agent.editedFiles[edited:]...),
+	}, nil
+}
+
+// confirm asks the editor a yes/no question with editor/confirm
+func (s *rpcSession) confirm(question string) bool {
+	var result struct {
+		Approved bool `json:"approved"`
+	}
+	err := s.conn.call(context.TODO(), "editor/confirm", map[string]string{
+		"sessionId": s.id,
+		"question":  strings.TrimSuffix(question, " [y/N] "),
+	}, &result)
+	return err == nil && result.Approved
+}
+
+// tools returns the default tools, with read_file and edit_file seeing the editor's open
+// buffers and edit_file going through the editor
+func (s *rpcSession) tools() []ToolDefinition {
+	tools := defaultTools()
+	for i, tool := range tools {
+		switch tool.Name {
+		case ReadFileDefinition.Name:
+			tools[i].Function = s.conn.buffers.readFile
+		case EditFileDefinition.Name:
+			tools[i].Function = nil
+			tools[i].Run = s.editFile
+		}
+	}
+	return tools
+}
+
+// editFile reports an edit as an edit_proposed event and, when asked to, previews it in the
+// editor. Edits of files open in the editor, or every edit with applyEdit, are then sent as
+// workspace edits so the editor changes its buffers; others are written to disk.
+func (s *rpcSession) editFile(ctx context.Context, input json.RawMessage) (string, error) {
+	editFileInput := EditFileInput{}
+	if err := json.Unmarshal(input, &editFileInput); err != nil {
+		return "", err
+	}
+	before, after, open, err := s.conn.buffers.editedContent(editFileInput)
+	if err != nil {
+		return "", err
+	}
+
+	diff, err := diffContents(ctx, editFileInput.Path, before, after)
+	if err != nil {
+		return "", err
+	}
+	s.agent.events.Emit(Event{Type: eventEditProposed, Tool: EditFileDefinition.Name, Input: input, Text: diff})
+
+	if s.options.PreviewDiff {
+		var preview struct {
+			Approved bool `json:"approved"`
+		}
+		if err := s.conn.call(ctx, "editor/previewDiff", map[string]string{
+			"sessionId": s.id,
+			"uri":       fileURI(editFileInput.Path),
+			"diff":      diff,
+		}, &preview); err != nil {
+			return "", err
+		}
+		if !preview.Approved {
+			return "", fmt.Errorf("the user rejected this edit in the editor")
+		}
+	}
+
+	if !s.options.ApplyEdit && !open {
+		return EditFile(input)
+	}
+
+	var applied struct {
+		Applied       bool   `json:"applied"`
+		FailureReason string `json:"failureReason"`
+	}
+	label := "go-agent: edit " + editFileInput.Path
+	if err := s.conn.call(ctx, "workspace/applyEdit", map[string]any{
+		"label": label,
+		"edit":  workspaceEdit(editFileInput.Path, before, after),
+	}, &applied); err != nil {
+		return "", err
+	}
+	if !applied.Applied {
+		return "", fmt.Errorf("the editor did not apply the edit: %s", orNone(applied.FailureReason))
+	}
+	// Later reads see the edit before the editor reports the change
+	s.conn.buffers.update(editFileInput.Path, after)
+	return "OK (applied in the editor; the buffer may not be saved yet)", nil
+}
+
+// workspaceEdit is an LSP WorkspaceEdit that replaces the whole file with after, creating it
+// first when before is nil
+func workspaceEdit(path string, before *string, after string) map[string]any {
+	uri := fileURI(path)
+	changes := []any{}
+	end := map[string]int{"line": 0, "character": 0}
+	if before == nil {
+		changes = append(changes, map[string]any{"kind": "create", "uri": uri})
+	} else {
+		// LSP positions count UTF-16 code units
+		lines := strings.Split(*before, "\n")
+		end = map[string]int{"line": len(lines) - 1, "character": len(utf16.Encode([]rune(lines[len(lines)-1])))}
+	}
+	changes = append(changes, map[string]any{
+		"textDocument": map[string]any{"uri": uri, "version": nil},
+		"edits": []any{map[string]any{
+			"range":   map[string]any{"start": map[string]int{"line": 0, "character": 0}, "end": end},
+			"newText": after,
+		}},
+	})
+	return map[string]any{"documentChanges": changes}
+}
+
+// fileURI turns a workspace path into a file:// URI
+func fileURI(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
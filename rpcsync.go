@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+)
+
+// =============================================================================
+// EDITOR BUFFER SYNC
+// =============================================================================
+
+// editorBuffers holds the text of the files open in the editor, which may not be saved.
+// read_file and edit_file use it instead of the disk, so edits never clash with a buffer.
+type editorBuffers struct {
+	mu    sync.Mutex
+	texts map[string]string // By absolute path
+}
+
+// newEditorBuffers creates an empty buffer store
+func newEditorBuffers() *editorBuffers {
+	return &editorBuffers{texts: map[string]string{}}
+}
+
+// sync applies a textDocument/didOpen, didChange or didClose notification. Changes carry
+// the full text, as the editor was told in initialize.
+func (b *editorBuffers) sync(method string, raw json.RawMessage) {
+	var params struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return
+	}
+	parsed, err := url.Parse(params.TextDocument.URI)
+	if err != nil || parsed.Scheme != "file" {
+		return
+	}
+	path := filepath.FromSlash(parsed.Path)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch method {
+	case "textDocument/didOpen":
+		b.texts[path] = params.TextDocument.Text
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			b.texts[path] = params.ContentChanges[n-1].Text
+		}
+	case "textDocument/didClose":
+		delete(b.texts, path)
+	}
+}
+
+// text returns the buffer of a workspace path, if the file is open in the editor
+func (b *editorBuffers) text(path string) (string, bool) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	text, open := b.texts[absolute]
+	return text, open
+}
+
+// update records an edit the editor is applying to an open buffer
+func (b *editorBuffers) update(path, text string) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.texts[absolute] = text
+}
+
+// readFile is read_file, reading open buffers instead of the disk
+func (b *editorBuffers) readFile(input json.RawMessage) (string, error) {
+	readFileInput := ReadFileInput{}
+	if err := json.Unmarshal(input, &readFileInput); err != nil {
+		return "", fmt.Errorf("invalid input format: %w", err)
+	}
+	if text, open := b.text(readFileInput.Path); open {
+		return text, nil
+	}
+	return ReadFile(input)
+}
+
+// editedContent is editedContent for an editor session, starting from the open buffer when
+// there is one. open reports whether the file is open in the editor.
+func (b *editorBuffers) editedContent(editFileInput EditFileInput) (before *string, after string, open bool, err error) {
+	text, open := b.text(editFileInput.Path)
+	if !open {
+		before, after, err = editedContent(editFileInput)
+		return before, after, false, err
+	}
+	if editFileInput.Path == "" || editFileInput.OldStr == editFileInput.NewStr {
+		return nil, "", true, fmt.Errorf("invalid input parameters")
+	}
+	after, err = replaceEdit(text, editFileInput)
+	return &text, after, true, err
+}
+
+// nvimPlugin is a reference Neovim client for `go-agent rpc`, printed by `rpc --nvim`. It
+// uses the built-in LSP client, which already syncs buffers and applies workspace edits.
+const nvimPlugin = `-- go-agent for Neovim 0.11+. Save as ~/.config/nvim/lua/go_agent.lua and call
+-- require("go_agent").setup() from init.lua.
+--
+--   :GoAgent <message>        ask the agent
+--   :'<,'>GoAgent <message>   ask with the selected lines as context
+--
+-- Edits are previewed as a diff and, once accepted, applied to your buffers.
+local M = {}
+
+local session
+
+local function agent()
+  return vim.lsp.get_clients({ name = "go-agent" })[1]
+end
+
+local function preview(params)
+  local lines = vim.split(params.diff, "\n", { trimempty = true })
+  vim.cmd("tabnew")
+  local buf = vim.api.nvim_get_current_buf()
+  vim.api.nvim_buf_set_lines(buf, 0, -1, false, lines)
+  vim.bo[buf].buftype = "nofile"
+  vim.bo[buf].filetype = "diff"
+  vim.cmd("redraw")
+  local approved = vim.fn.confirm("Apply this edit?", "&Yes\n&No", 2) == 1
+  vim.cmd("tabclose")
+  return { approved = approved }
+end
+
+local handlers = {
+  ["session/event"] = function(_, params)
+    local event = params.event
+    if event.type == "assistant_text" then
+      vim.notify("Claude: " .. event.text)
+    elseif event.type == "tool_use" then
+      vim.notify("go-agent: " .. event.tool, vim.log.levels.DEBUG)
+    elseif event.type == "error" then
+      vim.notify("go-agent: " .. event.text, vim.log.levels.ERROR)
+    end
+  end,
+  ["editor/confirm"] = function(_, params)
+    return { approved = vim.fn.confirm(params.question, "&Yes\n&No", 2) == 1 }
+  end,
+  ["editor/previewDiff"] = function(_, params)
+    return preview(params)
+  end,
+}
+
+local function send(client, text, selections)
+  client:request("session/send", { sessionId = session, text = text, selections = selections }, function(err, result)
+    if err then
+      vim.notify("go-agent: " .. err.message, vim.log.levels.ERROR)
+    elseif #result.editedFiles > 0 then
+      vim.notify("go-agent edited " .. table.concat(result.editedFiles, ", "))
+    end
+  end)
+end
+
+function M.setup(opts)
+  opts = opts or {}
+  vim.api.nvim_create_autocmd("FileType", {
+    callback = function(args)
+      if vim.bo[args.buf].buftype ~= "" then
+        return
+      end
+      vim.lsp.start({
+        name = "go-agent",
+        cmd = opts.cmd or { "go-agent", "rpc" },
+        root_dir = vim.fs.root(args.buf, { ".git", "go.mod" }) or vim.fn.getcwd(),
+        handlers = handlers,
+      })
+    end,
+  })
+
+  vim.api.nvim_create_user_command("GoAgent", function(cmd)
+    local client = agent()
+    if not client then
+      vim.notify("go-agent is not running in this buffer", vim.log.levels.ERROR)
+      return
+    end
+    local selections
+    if cmd.range > 0 then
+      selections = { {
+        path = vim.fn.expand("%:."),
+        startLine = cmd.line1,
+        endLine = cmd.line2,
+        text = table.concat(vim.api.nvim_buf_get_lines(0, cmd.line1 - 1, cmd.line2, false), "\n"),
+      } }
+    end
+    if session then
+      return send(client, cmd.args, selections)
+    end
+    client:request("session/open", { previewDiff = true }, function(err, result)
+      if err then
+        vim.notify("go-agent: " .. err.message, vim.log.levels.ERROR)
+        return
+      end
+      session = result.sessionId
+      send(client, cmd.args, selections)
+    end)
+  end, { nargs = "+", range = true })
+end
+
+return M
+`
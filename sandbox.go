@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// =============================================================================
+// COMMAND SANDBOX
+// =============================================================================
+
+// SandboxSpec is what a sandboxed command may do besides reading files and running
+// programs, which it may do anywhere
+type SandboxSpec struct {
+	Writable []string `json:"writable"` // Files and directories it may write under
+	Network  bool     `json:"network"`  // Whether it may open network connections
+}
+
+// sandboxEnabled reports whether the commands of the run_* tools run in a sandbox. It is
+// on by default on macOS, where sandbox-exec is always there, and wherever TOOL_EGRESS
+// restricts the network, which only the sandbox can hold commands to. SANDBOX=false turns
+// it off. The sandbox settings come from the environment or the managed settings only, so
+// the config.env of a repository cannot loosen them.
+func sandboxEnabled() bool {
+	if value := trustedConfigValue("SANDBOX"); value != "" {
+		return value == "true"
+	}
+	_, restricted := toolEgress()
+	return runtime.GOOS == "darwin" || restricted
+}
+
+// newSandboxSpec lets a command write to its workspace, the temp and cache directories
+// and SANDBOX_WRITABLE, and connect to the network only when toolNetwork allows it. Only
+// writes and the network are confined: the command can read every file the user can,
+// secrets such as ~/.ssh included.
+func newSandboxSpec(ctx context.Context, workspace string) SandboxSpec {
+	writable := []string{workspace, os.TempDir(), os.DevNull}
+	if cache, err := os.UserCacheDir(); err == nil {
+		writable = append(writable, cache)
+	}
+	writable = append(writable, splitList(trustedConfigValue("SANDBOX_WRITABLE"))...)
+	return SandboxSpec{Writable: writable, Network: toolNetwork(ctx)}
+}
+
+// sandbox changes cmd so that it runs confined to workspace, when SANDBOX is on. It fails
+// when this system cannot confine it, rather than running it unconfined, and when
+// TOOL_EGRESS restricts the network but SANDBOX=false leaves commands unconfined.
+func sandbox(ctx context.Context, cmd *exec.Cmd, workspace string) error {
+	if !sandboxEnabled() {
+		if _, restricted := toolEgress(); restricted {
+			err := errors.New("TOOL_EGRESS restricts the network, which commands only keep to in the sandbox; remove SANDBOX=false")
+			reportEgress(ctx, "*", err)
+			return err
+		}
+		return nil
+	}
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	return sandboxCommand(cmd, newSandboxSpec(ctx, workspace))
+}
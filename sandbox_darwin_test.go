@@ -0,0 +1,77 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSandboxProfile(t *testing.T) {
+	workspace := t.TempDir()
+	resolved, err := filepath.EvalSymlinks(workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		spec     SandboxSpec
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "offline",
+			spec:     SandboxSpec{Writable: []string{workspace}},
+			contains: []string{"(deny file-write*)", `(subpath "` + resolved + `")`, `(subpath "/private/tmp")`, `(subpath "/dev/fd")`, "(deny network*)"},
+		},
+		{
+			name:     "network",
+			spec:     SandboxSpec{Writable: []string{workspace}, Network: true},
+			contains: []string{`(subpath "` + resolved + `")`},
+			excludes: []string{"(deny network*)"},
+		},
+		{
+			name:     "quoted",
+			spec:     SandboxSpec{Writable: []string{`/no/such/"dir"\`}},
+			contains: []string{`(subpath "/no/such/\"dir\"\\")`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profile := sandboxProfile(test.spec)
+			if !strings.HasPrefix(profile, "(version 1)\n(allow default)\n") {
+				t.Errorf("profile does not start by allowing everything:\n%s", profile)
+			}
+			for _, want := range test.contains {
+				if !strings.Contains(profile, want) {
+					t.Errorf("profile lacks %s:\n%s", want, profile)
+				}
+			}
+			for _, unwanted := range test.excludes {
+				if strings.Contains(profile, unwanted) {
+					t.Errorf("profile has %s:\n%s", unwanted, profile)
+				}
+			}
+		})
+	}
+}
+
+func TestSandboxExecConfinesWrites(t *testing.T) {
+	if _, err := os.Stat(sandboxExec); err != nil {
+		t.Skipf("sandbox-exec is not available: %s", err)
+	}
+	workspace, outside := t.TempDir(), t.TempDir()
+	for path, allowed := range map[string]bool{filepath.Join(workspace, "out.txt"): true, filepath.Join(outside, "out.txt"): false} {
+		cmd := exec.Command("sh", "-c", `echo sandboxed > "$1"`, "sh", path)
+		if err := sandboxCommand(cmd, SandboxSpec{Writable: []string{workspace}}); err != nil {
+			t.Fatal(err)
+		}
+		output, err := cmd.CombinedOutput()
+		if (err == nil) != allowed {
+			t.Errorf("writing %s: %v %s, want allowed %v", path, err, output, allowed)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxEnv carries the SandboxSpec to the copy of go-agent that confines a command
+const sandboxEnv = "GO_AGENT_SANDBOX"
+
+// landlockWriteAccess is every kind of write Landlock ABI 1 can restrict. Reading and
+// executing stay allowed everywhere.
+const landlockWriteAccess = unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+	unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+	unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+	unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK | unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+
+// landlockFileAccess is the part of an access set that applies to a single file
+const landlockFileAccess = unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+
+// sandboxCommand runs cmd through go-agent itself, which confines its own thread with
+// Landlock and seccomp and then executes the command in its place, since Go cannot run
+// code between fork and exec
+func sandboxCommand(cmd *exec.Cmd, spec SandboxSpec) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find go-agent to sandbox the command: %w", err)
+	}
+	data, _ := json.Marshal(spec)
+	cmd.Env = append(cmd.Environ(), sandboxEnv+"="+string(data))
+	cmd.Args = append([]string{executable, cmd.Path}, cmd.Args...)
+	cmd.Path = executable
+	return nil
+}
+
+// enterSandbox confines this process and replaces it with the command sandboxCommand
+// passed, when go-agent was started to do that. It does not return then.
+func enterSandbox() {
+	data, ok := os.LookupEnv(sandboxEnv)
+	if !ok {
+		return
+	}
+	// Landlock and seccomp confine the calling thread, which must be the one that executes
+	runtime.LockOSThread()
+	spec := SandboxSpec{}
+	err := json.Unmarshal([]byte(data), &spec)
+	if err == nil && len(os.Args) < 3 {
+		err = fmt.Errorf("no command to run")
+	}
+	if err == nil {
+		err = confine(spec)
+	}
+	if err == nil {
+		env := []string{}
+		for _, variable := range os.Environ() {
+			if !strings.HasPrefix(variable, sandboxEnv+"=") {
+				env = append(env, variable)
+			}
+		}
+		err = unix.Exec(os.Args[1], os.Args[2:], env)
+	}
+	fmt.Fprintf(os.Stderr, "go-agent sandbox: %s\n", err.Error())
+	os.Exit(126)
+}
+
+// confine restricts writes to spec.Writable with Landlock and, unless spec.Network is
+// set, network sockets with seccomp
+func confine(spec SandboxSpec) error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	if err := landlock(spec.Writable); err != nil {
+		return err
+	}
+	if !spec.Network {
+		return denyNetwork()
+	}
+	return nil
+}
+
+// landlock lets the thread write under the writable paths only
+func landlock(writable []string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("Landlock is not available on this kernel (%w); set SANDBOX=false to run commands unconfined", errno)
+	}
+	access := uint64(landlockWriteAccess)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	ruleset := unix.LandlockRulesetAttr{Access_fs: access}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&ruleset)), unsafe.Sizeof(ruleset), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create the Landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, path := range writable {
+		if err := landlockAllow(int(fd), path, access); err != nil {
+			return err
+		}
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to apply the Landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// landlockAllow adds a rule letting the ruleset write under path, if it exists
+func landlockAllow(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil // Nothing to write to yet, and nothing to allow
+	}
+	defer unix.Close(fd)
+	info := unix.Stat_t{}
+	if err := unix.Fstat(fd, &info); err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to let the sandbox write to %s: %w", path, errno)
+	}
+	return nil
+}
+
+// seccompArch is the audit architecture of the system calls the filter expects
+var seccompArch = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+}
+
+// denyNetwork installs a seccomp filter that fails every socket other than a Unix domain
+// socket with EACCES. io_uring, which can open sockets without the socket system call,
+// and system calls of other architectures fail too.
+func denyNetwork() error {
+	arch, ok := seccompArch[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("the sandbox cannot deny network access on %s; set SANDBOX_NETWORK=true to run commands without that", runtime.GOARCH)
+	}
+	const (
+		load    = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		equal   = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		atLeast = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		ret     = unix.BPF_RET | unix.BPF_K
+		x32     = 0x40000000 // Added to the numbers of x32 system calls on amd64
+	)
+	// The offsets are into struct seccomp_data: the system call number, the architecture
+	// and the low half of the first argument
+	filter := []unix.SockFilter{
+		{Code: load, K: 4},
+		{Code: equal, K: arch, Jt: 0, Jf: 7},
+		{Code: load, K: 0},
+		{Code: atLeast, K: x32, Jt: 5, Jf: 0},
+		{Code: equal, K: unix.SYS_IO_URING_SETUP, Jt: 4, Jf: 0},
+		{Code: equal, K: unix.SYS_SOCKET, Jt: 0, Jf: 2},
+		{Code: load, K: 16},
+		{Code: equal, K: unix.AF_UNIX, Jt: 0, Jf: 1},
+		{Code: ret, K: unix.SECCOMP_RET_ALLOW},
+		{Code: ret, K: unix.SECCOMP_RET_ERRNO | uint32(unix.EACCES)},
+	}
+	program := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&program)), 0, 0); err != nil {
+		return fmt.Errorf("failed to install the seccomp filter: %w", err)
+	}
+	return nil
+}
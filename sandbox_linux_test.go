@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// runSandboxed runs cmd confined by spec and returns its combined output
+func runSandboxed(t *testing.T, cmd *exec.Cmd, spec SandboxSpec) (string, error) {
+	t.Helper()
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION); errno != 0 {
+		t.Skipf("Landlock is not available: %s", errno)
+	}
+	if err := sandboxCommand(cmd, spec); err != nil {
+		t.Fatal(err)
+	}
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func TestSandboxConfinesWrites(t *testing.T) {
+	workspace, outside := t.TempDir(), t.TempDir()
+	tests := []struct {
+		name, path string
+		allowed    bool
+	}{
+		{"workspace", filepath.Join(workspace, "out.txt"), true},
+		{"subdirectory", filepath.Join(workspace, "sub"), true},
+		{"outside", filepath.Join(outside, "out.txt"), false},
+		{"dev null", os.DevNull, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := `echo sandboxed > "$1"`
+			if strings.HasSuffix(test.path, "sub") {
+				script = `mkdir "$1" && echo sandboxed > "$1/out.txt"`
+			}
+			cmd := exec.Command("sh", "-c", script, "sh", test.path)
+			output, err := runSandboxed(t, cmd, SandboxSpec{Writable: []string{workspace, os.DevNull}})
+			if allowed := err == nil; allowed != test.allowed {
+				t.Errorf("writing %s: allowed %v, want %v (%v: %s)", test.path, allowed, test.allowed, err, output)
+			}
+		})
+	}
+}
+
+func TestSandboxDeniesNetwork(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connection.Close()
+		}
+	}()
+
+	for _, network := range []bool{false, true} {
+		cmd := exec.Command(os.Args[0])
+		cmd.Env = append(os.Environ(), "GO_AGENT_TEST_DIAL="+listener.Addr().String())
+		output, err := runSandboxed(t, cmd, SandboxSpec{Network: network})
+		if connected := err == nil; connected != network {
+			t.Errorf("with network %v: connected %v (%v: %s)", network, connected, err, output)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// sandboxCommand fails, since this system has no sandbox for commands
+func sandboxCommand(cmd *exec.Cmd, spec SandboxSpec) error {
+	return fmt.Errorf("commands cannot be sandboxed on %s; set SANDBOX=false to run them unconfined", runtime.GOOS)
+}
+
+// enterSandbox does nothing; no command is ever started to confine itself here
+func enterSandbox() {}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// =============================================================================
+// TASK SCHEDULING
+// =============================================================================
+
+// Scheduler admits the tasks of the server modes (serve, discord and chat), which share one
+// API key and its rate limit. At most SCHEDULER_MAX_RUNNING tasks run at a time, and at
+// most SCHEDULER_MAX_PER_USER of them for one user; zero means unlimited. Tasks over the
+// limits wait. A free slot goes to the waiting task with the highest priority, and among
+// equals to the user who was served longest ago, so one heavy user can't starve the rest.
+type Scheduler struct {
+	maxRunning int
+	maxPerUser int
+	priorities map[string]int // Priority by user, from SCHEDULER_PRIORITIES; others have 0
+
+	mu       sync.Mutex
+	running  map[string]int // Running tasks by owner
+	total    int            // Running tasks of all owners
+	served   map[string]int // When each owner's latest task was admitted, as a ticket number
+	waiting  []*schedulerTicket
+	admitted int // Tickets admitted so far
+}
+
+// schedulerTicket is a task waiting for a slot
+type schedulerTicket struct {
+	owner    string
+	priority int
+	seq      int
+	ready    chan struct{} // Closed once the task is admitted
+}
+
+// taskScheduler reads the scheduler settings once; all sessions share the result
+var taskScheduler = sync.OnceValue(func() *Scheduler {
+	scheduler := newScheduler(configInt("SCHEDULER_MAX_RUNNING", 0), configInt("SCHEDULER_MAX_PER_USER", 0))
+	for _, entry := range splitList(configValue("SCHEDULER_PRIORITIES")) {
+		user, priority, _ := strings.Cut(entry, "=")
+		if n, err := strconv.Atoi(strings.TrimSpace(priority)); err == nil {
+			scheduler.priorities[strings.TrimSpace(user)] = n
+		}
+	}
+	return scheduler
+})
+
+// newScheduler creates a scheduler with the given limits
+func newScheduler(maxRunning, maxPerUser int) *Scheduler {
+	return &Scheduler{
+		maxRunning: maxRunning,
+		maxPerUser: maxPerUser,
+		priorities: map[string]int{},
+		running:    map[string]int{},
+		served:     map[string]int{},
+	}
+}
+
+// priority returns user's configured priority
+func (s *Scheduler) priority(user string) int {
+	return s.priorities[user]
+}
+
+// acquire waits until owner may run a task of the given priority, calling queued first
+// when the task has to wait. The returned function frees the slot once the task ends.
+// A task whose ctx is canceled while it waits gives up its place.
+func (s *Scheduler) acquire(ctx context.Context, owner string, priority int, queued func(running, ahead int)) (func(), error) {
+	s.mu.Lock()
+	s.admitted++
+	ticket := &schedulerTicket{owner: owner, priority: priority, seq: s.admitted, ready: make(chan struct{})}
+	s.waiting = append(s.waiting, ticket)
+	s.dispatch()
+
+	select {
+	case <-ticket.ready:
+		s.mu.Unlock()
+		return s.releaser(owner), nil
+	default:
+	}
+	ahead := 0
+	for _, other := range s.waiting {
+		if other != ticket && s.before(other, ticket) {
+			ahead++
+		}
+	}
+	running := s.total
+	s.mu.Unlock()
+	if queued != nil {
+		queued(running, ahead)
+	}
+
+	select {
+	case <-ticket.ready:
+		return s.releaser(owner), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-ticket.ready:
+			// Admitted just as it was canceled, so the slot goes to the next task
+			s.release(owner)
+		default:
+			for i, other := range s.waiting {
+				if other == ticket {
+					s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+					break
+				}
+			}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// releaser returns the function freeing owner's slot, which does nothing after the first call
+func (s *Scheduler) releaser(owner string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.release(owner)
+		})
+	}
+}
+
+// release frees a slot of owner and admits whoever may run now; s.mu must be held
+func (s *Scheduler) release(owner string) {
+	s.total--
+	if s.running[owner]--; s.running[owner] <= 0 {
+		delete(s.running, owner)
+	}
+	s.dispatch()
+}
+
+// dispatch admits waiting tasks while there are free slots; s.mu must be held
+func (s *Scheduler) dispatch() {
+	for s.maxRunning <= 0 || s.total < s.maxRunning {
+		next := -1
+		for i, ticket := range s.waiting {
+			if s.maxPerUser > 0 && s.running[ticket.owner] >= s.maxPerUser {
+				continue
+			}
+			if next < 0 || s.before(ticket, s.waiting[next]) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return
+		}
+		ticket := s.waiting[next]
+		s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+		s.running[ticket.owner]++
+		s.total++
+		s.served[ticket.owner] = ticket.seq
+		close(ticket.ready)
+	}
+}
+
+// before reports whether ticket a gets a free slot ahead of b: by priority, then the owner
+// served longest ago, then the order they arrived in
+func (s *Scheduler) before(a, b *schedulerTicket) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if a.owner != b.owner {
+		if servedA, servedB := s.served[a.owner], s.served[b.owner]; servedA != servedB {
+			return servedA < servedB
+		}
+	}
+	return a.seq < b.seq
+}
+
+// schedule waits for a slot for the next task of the session, on behalf of user. Without a
+// user the task counts as the session's own.
+func (s *chatSession) schedule(ctx context.Context, user string) (func(), error) {
+	scheduler := taskScheduler()
+	priority := scheduler.priority(user)
+	owner := cmp.Or(user, s.agent.session)
+	return scheduler.acquire(ctx, owner, priority, func(running, ahead int) {
+		s.queued.Store(true)
+		text := fmt.Sprintf("queued: %d tasks running, %d waiting ahead of this one", running, ahead)
+		fmt.Fprintf(os.Stderr, "scheduler: %s (%s)\n", text, owner)
+		s.agent.events.Emit(Event{Type: eventStatus, Text: text})
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// enqueue starts acquiring a slot for owner and returns once the task waits in the queue.
+// The returned channel gets the release function when the task is admitted.
+func enqueue(t *testing.T, ctx context.Context, s *Scheduler, owner string, priority int) <-chan func() {
+	t.Helper()
+	queued := make(chan struct{})
+	admitted := make(chan func(), 1)
+	go func() {
+		release, err := s.acquire(ctx, owner, priority, func(running, ahead int) { close(queued) })
+		if err == nil {
+			admitted <- release
+		}
+	}()
+	select {
+	case <-queued:
+	case release := <-admitted:
+		t.Fatalf("%s was admitted at once", owner)
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s neither waits nor runs", owner)
+	}
+	return admitted
+}
+
+// admit expects the task of admitted to start and returns its release function
+func admit(t *testing.T, admitted <-chan func(), name string) func() {
+	t.Helper()
+	select {
+	case release := <-admitted:
+		return release
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s was not admitted", name)
+		return nil
+	}
+}
+
+// waiting expects the task of admitted to still wait
+func waiting(t *testing.T, admitted <-chan func(), name string) {
+	t.Helper()
+	select {
+	case <-admitted:
+		t.Fatalf("%s was admitted over the limit", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	ctx := context.Background()
+	s := newScheduler(2, 1)
+	releaseAnn, _ := s.acquire(ctx, "ann", 0, nil)
+	annAgain := enqueue(t, ctx, s, "ann", 0)
+	releaseBob, err := s.acquire(ctx, "bob", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	carol := enqueue(t, ctx, s, "carol", 0)
+
+	waiting(t, annAgain, "ann's second task")
+	waiting(t, carol, "carol")
+	releaseBob()
+	releaseCarol := admit(t, carol, "carol")
+	waiting(t, annAgain, "ann's second task")
+	releaseBob() // Releasing twice frees one slot only
+	waiting(t, annAgain, "ann's second task")
+	releaseAnn()
+	admit(t, annAgain, "ann's second task")()
+	releaseCarol()
+
+	if s.total != 0 || len(s.running) != 0 || len(s.waiting) != 0 {
+		t.Errorf("after every task ended: %d running (%v), %d waiting", s.total, s.running, len(s.waiting))
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		queue  []string // owner:priority in arrival order
+		served string   // An owner served before the queue
+		want   []string
+	}{
+		{"priority", []string{"ann:0", "bob:5", "carol:1"}, "", []string{"bob", "carol", "ann"}},
+		{"arrival among equals", []string{"ann:0", "bob:0", "carol:0"}, "", []string{"ann", "bob", "carol"}},
+		{"round robin", []string{"ann:0", "ann:0", "ann:0", "bob:0"}, "", []string{"ann", "bob", "ann", "ann"}},
+		{"served longest ago first", []string{"ann:0", "bob:0"}, "ann", []string{"bob", "ann"}},
+		{"priority beats fairness", []string{"bob:0", "ann:1"}, "ann", []string{"ann", "bob"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newScheduler(1, 0)
+			if test.served != "" {
+				release, _ := s.acquire(ctx, test.served, 0, nil)
+				release()
+			}
+			hold, _ := s.acquire(ctx, "holder", 0, nil)
+
+			admitted := make(chan string, len(test.queue))
+			releases := make(chan func(), len(test.queue))
+			for _, entry := range test.queue {
+				owner, priority, _ := strings.Cut(entry, ":")
+				n, _ := strconv.Atoi(priority)
+				task := enqueue(t, ctx, s, owner, n)
+				go func() {
+					release := <-task
+					admitted <- owner
+					releases <- release
+				}()
+			}
+
+			hold()
+			order := []string{}
+			for range test.queue {
+				order = append(order, <-admitted)
+				(<-releases)()
+			}
+			if strings.Join(order, " ") != strings.Join(test.want, " ") {
+				t.Errorf("admitted %v, want %v", order, test.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := newScheduler(1, 0)
+	hold, _ := s.acquire(context.Background(), "ann", 0, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := enqueue(t, ctx, s, "bob", 0)
+	next := enqueue(t, context.Background(), s, "carol", 0)
+
+	cancel()
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		n := len(s.waiting)
+		s.mu.Unlock()
+		if n == 1 || time.Since(start) > 5*time.Second {
+			break
+		}
+	}
+	hold()
+	admit(t, next, "carol")()
+	waiting(t, canceled, "the canceled task")
+}
+
+func TestSchedulerConcurrentCallers(t *testing.T) {
+	const maxRunning, maxPerUser = 3, 1
+	s := newScheduler(maxRunning, maxPerUser)
+	owners := []string{"ann", "bob", "carol", "dave", "erin"}
+
+	var mu sync.Mutex
+	running, perUser := 0, map[string]int{}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		owner := owners[i%len(owners)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := s.acquire(context.Background(), owner, i%3, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			running++
+			perUser[owner]++
+			if running > maxRunning || perUser[owner] > maxPerUser {
+				t.Errorf("%d tasks running, %d of %s", running, perUser[owner], owner)
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			perUser[owner]--
+			mu.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestChatSessionScheduleOwner(t *testing.T) {
+	original := taskScheduler
+	scheduler := newScheduler(0, 1)
+	taskScheduler = func() *Scheduler { return scheduler }
+	defer func() { taskScheduler = original }()
+	ctx := context.Background()
+
+	log := bytes.Buffer{}
+	room := &chatSession{agent: &Agent{session: "room-1", events: NewEventLog(&log)}}
+	release, err := room.schedule(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a user the task is the session's own, so the session's next task waits
+	admitted := make(chan func(), 1)
+	go func() {
+		release, _ := room.schedule(ctx, "")
+		admitted <- release
+	}()
+	for start := time.Now(); !room.queued.Load(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the session's second task did not wait")
+		}
+	}
+	// Another session, or a user of this one, has a slot of its own
+	other := &chatSession{agent: &Agent{session: "room-2"}}
+	for _, call := range []func() (func(), error){
+		func() (func(), error) { return other.schedule(ctx, "") },
+		func() (func(), error) { return room.schedule(ctx, "ann") },
+	} {
+		done := make(chan func(), 1)
+		go func() {
+			release, _ := call()
+			done <- release
+		}()
+		admit(t, done, "a task of another owner")()
+	}
+
+	release()
+	admit(t, admitted, "the session's second task")()
+	if !strings.Contains(log.String(), "queued: 1 tasks running, 0 waiting ahead of this one") {
+		t.Errorf("no status event for the queued task:\n%s", log.String())
+	}
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// SERVER MODE (REST API)
+// =============================================================================
+
+// Session statuses reported by the API
+const (
+	sessionIdle    = "idle"
+	sessionQueued  = "queued"
+	sessionRunning = "running"
+)
+
+// SessionInfo describes a session in API responses
+type SessionInfo struct {
+	ID      string     `json:"id"`
+	Created time.Time  `json:"created"`
+	Status  string     `json:"status"`
+	Todos   []TodoItem `json:"todos,omitempty"` // The agent's current task list
+}
+
+// ServerSession is a conversation hosted by the server
+type ServerSession struct {
+	ID      string
+	Created time.Time
+	owner   string // User of the token that created it, or "" when a SERVER_TOKEN client did
+	chat    *chatSession
+	events  *eventRecorder
+	running atomic.Bool
+
+	mu        sync.Mutex
+	approvals map[string]chan bool // Pending approvals by token
+}
+
+// Server hosts sessions over HTTP for remote clients
+type Server struct {
+	client          *anthropic.Client
+	token           string            // SERVER_TOKEN, whose clients may send messages on behalf of any user
+	userTokens      map[string]string // Tokens of SERVER_USER_TOKENS, to the user each one stands for
+	approvalTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*ServerSession
+	shares   map[string]*sessionShare // Read-only share links by token
+}
+
+// runServeCommand implements `go-agent serve`
+func runServeCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	grpcAddr := flags.String("grpc-addr", "", "address to serve the gRPC API on as well (default: REST API only)")
+	approvalTimeout := flags.Duration("approval-timeout", chatApprovalTimeout, "how long a tool call waits for a client to approve it before it is denied")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	token := configValue("SERVER_TOKEN")
+	userTokens := map[string]string{}
+	for _, entry := range splitList(configValue("SERVER_USER_TOKENS")) {
+		user, userToken, ok := strings.Cut(entry, "=")
+		user, userToken = strings.TrimSpace(user), strings.TrimSpace(userToken)
+		if !ok || user == "" || userToken == "" {
+			return fmt.Errorf("SERVER_USER_TOKENS: %q is not USER=TOKEN", entry)
+		}
+		userTokens[userToken] = user
+	}
+	if token == "" && len(userTokens) == 0 {
+		return fmt.Errorf("SERVER_TOKEN or SERVER_USER_TOKENS must be set; clients send a token as a bearer token")
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	server := &Server{client: client, token: token, userTokens: userTokens, approvalTimeout: *approvalTimeout, sessions: map[string]*ServerSession{}, shares: map[string]*sessionShare{}}
+	errs := make(chan error, 2)
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "serve: gRPC API on %s\n", *grpcAddr)
+		go func() { errs <- server.grpc().Serve(listener) }()
+	}
+	fmt.Fprintf(os.Stderr, "serve: listening on %s\n", *addr)
+	go func() { errs <- http.ListenAndServe(*addr, server.routes()) }()
+	return <-errs
+}
+
+// clientKey is the context key of the authenticated client
+type clientKey struct{}
+
+// apiClient is who sent a request: the user of a SERVER_USER_TOKENS token, or, for
+// SERVER_TOKEN, a trusted client that names the users it acts for
+type apiClient struct {
+	user    string
+	trusted bool
+}
+
+// authenticate finds the client a bearer token belongs to
+func (s *Server) authenticate(header string) (apiClient, bool) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return apiClient{}, false
+	}
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+		return apiClient{trusted: true}, true
+	}
+	for userToken, user := range s.userTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(userToken)) == 1 {
+			return apiClient{user: user}, true
+		}
+	}
+	return apiClient{}, false
+}
+
+// routes registers the API endpoints behind bearer token authentication, and the share
+// pages, which their token authorizes instead
+func (s *Server) routes() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("POST /v1/sessions", s.createSession)
+	api.HandleFunc("GET /v1/sessions", s.listSessions)
+	api.HandleFunc("GET /v1/sessions/{id}", s.getSession)
+	api.HandleFunc("POST /v1/sessions/{id}/messages", s.sendMessage)
+	api.HandleFunc("GET /v1/sessions/{id}/events", s.streamEvents)
+	api.HandleFunc("POST /v1/sessions/{id}/approvals/{token}", s.resolveApproval)
+	api.HandleFunc("POST /v1/sessions/{id}/shares", s.createShare)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /share/{token}", s.sharePage)
+	mux.HandleFunc("GET /share/{token}/events", s.shareEvents)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		client, ok := s.authenticate(r.Header.Get("Authorization"))
+		if !ok {
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		api.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+	})
+	return mux
+}
+
+// createSession starts an empty session
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	client, _ := r.Context().Value(clientKey{}).(apiClient)
+	writeJSON(w, http.StatusCreated, s.newSession(client).snapshot())
+}
+
+// newSession starts and registers an empty session owned by client
+func (s *Server) newSession(client apiClient) *ServerSession {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	events := newEventRecorder()
+	session := &ServerSession{
+		ID:        hex.EncodeToString(id),
+		Created:   time.Now().UTC(),
+		owner:     client.user,
+		chat:      &chatSession{agent: NewAgent(s.client, nil, defaultTools())},
+		events:    events,
+		approvals: map[string]chan bool{},
+	}
+	session.chat.agent.events = NewEventLog(events)
+	session.chat.agent.session = session.ID
+
+	s.mu.Lock()
+	s.sessions[session.ID] = session
+	s.mu.Unlock()
+	return session
+}
+
+// listSessions returns the client's sessions, oldest first
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request) {
+	client, _ := r.Context().Value(clientKey{}).(apiClient)
+	writeJSON(w, http.StatusOK, s.sessionList(client))
+}
+
+// sessionList describes the sessions client may see, oldest first
+func (s *Server) sessionList(client apiClient) []SessionInfo {
+	s.mu.Lock()
+	sessions := []SessionInfo{}
+	for _, session := range s.sessions {
+		if client.owns(session) {
+			sessions = append(sessions, session.snapshot())
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Created.Before(sessions[j].Created) })
+	return sessions
+}
+
+// getSession returns one session
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	if session := s.lookup(w, r); session != nil {
+		writeJSON(w, http.StatusOK, session.snapshot())
+	}
+}
+
+// sendMessage runs a turn; progress is reported as events. The 202 and the session are
+// written at once, but the response stays open until the turn ends, and a client that
+// disconnects first cancels the turn.
+func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) {
+	session := s.lookup(w, r)
+	if session == nil {
+		return
+	}
+
+	var request struct {
+		Text string `json:"text"`
+		User string `json:"user"` // Who the message is from; only SERVER_TOKEN clients may say
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Text == "" {
+		writeJSONError(w, http.StatusBadRequest, `body must be {"text": "..."}`)
+		return
+	}
+	client, _ := r.Context().Value(clientKey{}).(apiClient)
+	user, err := client.messageUser(request.User)
+	if err != nil {
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if !session.chat.mu.TryLock() {
+		writeJSONError(w, http.StatusConflict, "session is busy with the previous message")
+		return
+	}
+	defer session.chat.mu.Unlock()
+
+	session.running.Store(true)
+	writeJSON(w, http.StatusAccepted, session.snapshot())
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	s.runTurn(r.Context(), session, request.Text, user)
+}
+
+// messageUser is who a message the client sends is from. The user decides tool policies
+// and scheduling, so it comes from the token unless the client is trusted to act for
+// others.
+func (c apiClient) messageUser(requested string) (string, error) {
+	if c.trusted {
+		return requested, nil
+	}
+	if requested != "" && requested != c.user {
+		return "", fmt.Errorf("this token sends messages as %s only", c.user)
+	}
+	return c.user, nil
+}
+
+// runTurn answers a message in a session whose chat the caller has locked and marked
+// running, ending with an outcome event. Canceling ctx cancels the turn.
+func (s *Server) runTurn(ctx context.Context, session *ServerSession, text, user string) {
+	events := session.chat.agent.events
+	outcome := outcomeSuccess
+	approver := func(question string) bool {
+		return session.askApproval(ctx, question, s.approvalTimeout)
+	}
+	if _, _, err := session.chat.run(ctx, text, user, approver); err != nil {
+		events.Emit(Event{Type: eventError, Text: err.Error()})
+		outcome = outcomeFailed
+	}
+	// Clients treat the outcome as the end of the turn, so the session is idle by then
+	session.running.Store(false)
+	events.Emit(Event{Type: eventOutcome, Outcome: outcome})
+	runOptions.notifications.notify(Notification{Kind: notifyTaskFinished, Title: "go-agent task finished: " + outcome, Text: truncateRunes(text, 200), Session: session.ID})
+}
+
+// streamEvents writes the session's events as JSON lines, starting after the given count,
+// and keeps streaming new ones until the client disconnects
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	session := s.lookup(w, r)
+	if session == nil {
+		return
+	}
+	after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	session.events.stream(r.Context(), w, after)
+}
+
+// resolveApproval answers a pending approval with {"approve": true} or {"approve": false}
+func (s *Server) resolveApproval(w http.ResponseWriter, r *http.Request) {
+	session := s.lookup(w, r)
+	if session == nil {
+		return
+	}
+
+	var request struct {
+		Approve *bool `json:"approve"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Approve == nil {
+		writeJSONError(w, http.StatusBadRequest, `body must be {"approve": true} or {"approve": false}`)
+		return
+	}
+
+	if !session.resolveApproval(r.PathValue("token"), *request.Approve) {
+		writeJSONError(w, http.StatusNotFound, "no pending approval with that token; it may have been answered or timed out")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup finds the session named in the path, writing a 404 if there is none or the
+// client may not see it
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *ServerSession {
+	client, _ := r.Context().Value(clientKey{}).(apiClient)
+	session := s.session(client, r.PathValue("id"))
+	if session == nil {
+		writeJSONError(w, http.StatusNotFound, "no such session")
+	}
+	return session
+}
+
+// session finds a session of client by ID, or returns nil. Other users' sessions are
+// not found, so their IDs are not confirmed to exist.
+func (s *Server) session(client apiClient, id string) *ServerSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session := s.sessions[id]; session != nil && client.owns(session) {
+		return session
+	}
+	return nil
+}
+
+// owns reports whether the client may see and use session: the user who created it may,
+// and a SERVER_TOKEN client may use any session
+func (c apiClient) owns(session *ServerSession) bool {
+	return c.trusted || session.owner == c.user
+}
+
+// resolveApproval answers the pending approval with token, reporting false when there is
+// none
+func (s *ServerSession) resolveApproval(token string, approve bool) bool {
+	s.mu.Lock()
+	answer, ok := s.approvals[token]
+	delete(s.approvals, token)
+	s.mu.Unlock()
+	if ok {
+		answer <- approve
+	}
+	return ok
+}
+
+// snapshot describes the session with its current status
+func (s *ServerSession) snapshot() SessionInfo {
+	status := sessionIdle
+	switch {
+	case s.chat.queued.Load():
+		status = sessionQueued
+	case s.running.Load():
+		status = sessionRunning
+	}
+	return SessionInfo{ID: s.ID, Created: s.Created, Status: status, Todos: s.chat.agent.todos.Items()}
+}
+
+// askApproval emits an approval_required event and waits for a client to answer it,
+// denying the tool call when nobody does within timeout or the turn is canceled
+func (s *ServerSession) askApproval(ctx context.Context, question string, timeout time.Duration) bool {
+	id := make([]byte, 16)
+	rand.Read(id)
+	token := hex.EncodeToString(id)
+
+	answer := make(chan bool, 1)
+	s.mu.Lock()
+	s.approvals[token] = answer
+	s.mu.Unlock()
+
+	events := s.chat.agent.events
+	events.Emit(Event{Type: eventApprovalRequired, Token: token, Text: strings.TrimSuffix(question, " [y/N] ")})
+
+	resolution := "timed out"
+	approved := false
+	select {
+	case approved = <-answer:
+		resolution = map[bool]string{true: "approved", false: "denied"}[approved]
+	case <-time.After(timeout):
+		s.mu.Lock()
+		delete(s.approvals, token)
+		s.mu.Unlock()
+	case <-ctx.Done():
+		resolution = "canceled"
+		s.mu.Lock()
+		delete(s.approvals, token)
+		s.mu.Unlock()
+	}
+	events.Emit(Event{Type: eventApprovalResolved, Token: token, Text: resolution})
+	return approved
+}
+
+// eventRecorder keeps a session's JSON lines events and wakes up streams when one is added
+type eventRecorder struct {
+	mu      sync.Mutex
+	lines   [][]byte
+	changed chan struct{}
+	closed  bool // Set once no more events will arrive
+}
+
+// newEventRecorder creates an empty recorder
+func newEventRecorder() *eventRecorder {
+	return &eventRecorder{changed: make(chan struct{})}
+}
+
+// Write records one JSON line written by an EventLog
+func (e *eventRecorder) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lines = append(e.lines, append([]byte{}, p...))
+	if !e.closed {
+		close(e.changed)
+		e.changed = make(chan struct{})
+	}
+	return len(p), nil
+}
+
+// close ends streams once they have written every line
+func (e *eventRecorder) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.closed {
+		e.closed = true
+		close(e.changed)
+	}
+}
+
+// stream writes the lines after the first n as they arrive, until the recorder is closed,
+// ctx is done or a write fails
+func (e *eventRecorder) stream(ctx context.Context, w io.Writer, n int) {
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		lines, changed, closed := e.since(n)
+		for _, line := range lines {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+		n += len(lines)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if closed {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// count returns how many lines were recorded so far
+func (e *eventRecorder) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.lines)
+}
+
+// since returns the lines after the first n, a channel closed when more arrive and whether
+// the recorder is closed
+func (e *eventRecorder) since(n int) ([][]byte, <-chan struct{}, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if n < 0 || n > len(e.lines) {
+		n = len(e.lines)
+	}
+	return e.lines[n:], e.changed, e.closed
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes an {"error": message} response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
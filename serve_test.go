@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer serves the REST API in-process with a SERVER_TOKEN of "admin-token" and user
+// tokens for ann and bob
+func testServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	server := &Server{
+		token:           "admin-token",
+		userTokens:      map[string]string{"ann-token": "ann", "bob-token": "bob"},
+		approvalTimeout: time.Minute,
+		sessions:        map[string]*ServerSession{},
+		shares:          map[string]*sessionShare{},
+	}
+	api := httptest.NewServer(server.routes())
+	t.Cleanup(api.Close)
+	return server, api
+}
+
+// call sends a request with token and decodes a JSON response into v, if given
+func call(t *testing.T, method, url, token, body string, v any) int {
+	t.Helper()
+	request, _ := http.NewRequest(method, url, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if v != nil {
+		json.NewDecoder(response.Body).Decode(v)
+	}
+	return response.StatusCode
+}
+
+func TestServerSessionOwnership(t *testing.T) {
+	_, api := testServer(t)
+	session := SessionInfo{}
+	if code := call(t, http.MethodPost, api.URL+"/v1/sessions", "ann-token", "", &session); code != http.StatusCreated {
+		t.Fatalf("creating a session: %d", code)
+	}
+	url := api.URL + "/v1/sessions/" + session.ID
+
+	tests := []struct {
+		name, method, path, body string
+	}{
+		{"get", http.MethodGet, "", ""},
+		{"message", http.MethodPost, "/messages", `{"text": "Hi"}`},
+		{"events", http.MethodGet, "/events", ""},
+		{"approval", http.MethodPost, "/approvals/0123", `{"approve": true}`},
+		{"share", http.MethodPost, "/shares", `{}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := call(t, test.method, url+test.path, "bob-token", test.body, nil); code != http.StatusNotFound {
+				t.Errorf("another user's token gets %d, want 404", code)
+			}
+		})
+	}
+
+	for token, want := range map[string]int{"ann-token": 1, "admin-token": 1, "bob-token": 0} {
+		sessions := []SessionInfo{}
+		call(t, http.MethodGet, api.URL+"/v1/sessions", token, "", &sessions)
+		if len(sessions) != want {
+			t.Errorf("%s lists %d sessions, want %d", token, len(sessions), want)
+		}
+	}
+	for _, token := range []string{"ann-token", "admin-token"} {
+		if code := call(t, http.MethodGet, url, token, "", nil); code != http.StatusOK {
+			t.Errorf("%s gets %d for the session", token, code)
+		}
+	}
+}
+
+func TestShareEventsWithoutApprovalTokens(t *testing.T) {
+	server, api := testServer(t)
+	session := SessionInfo{}
+	call(t, http.MethodPost, api.URL+"/v1/sessions", "ann-token", "", &session)
+	share := ShareInfo{}
+	if code := call(t, http.MethodPost, api.URL+"/v1/sessions/"+session.ID+"/shares", "ann-token", `{}`, &share); code != http.StatusCreated {
+		t.Fatalf("creating a share: %d", code)
+	}
+
+	events := server.session(apiClient{user: "ann"}, session.ID).chat.agent.events
+	events.Emit(Event{Type: eventApprovalRequired, Token: "secret-token", Text: "Allow edit_file?"})
+	events.Emit(Event{Type: eventApprovalResolved, Token: "secret-token", Text: "approved"})
+
+	response, err := http.Get(share.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	lines := bufio.NewScanner(response.Body)
+	for range 2 {
+		if !lines.Scan() {
+			t.Fatal("the share stream ended early")
+		}
+		event := Event{}
+		if err := json.Unmarshal(lines.Bytes(), &event); err != nil || event.Token != "" || event.Text == "" {
+			t.Errorf("the share streams %s (%v)", lines.Text(), err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"code-agent/agentpb"
+)
+
+// =============================================================================
+// SERVER MODE (gRPC API)
+// =============================================================================
+
+// grpcServer serves the sessions of a Server over the gRPC API of agentpb
+type grpcServer struct {
+	agentpb.UnimplementedAgentServer
+	server *Server
+}
+
+// grpc returns a gRPC server for the API, behind the same bearer tokens as the REST API
+func (s *Server) grpc() *grpc.Server {
+	server := grpc.NewServer(grpc.UnaryInterceptor(s.authenticateUnary), grpc.StreamInterceptor(s.authenticateStream))
+	agentpb.RegisterAgentServer(server, &grpcServer{server: s})
+	return server
+}
+
+// authenticateRPC puts the client of the call's bearer token in its context
+func (s *Server) authenticateRPC(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	client, ok := s.authenticate(header)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+	return context.WithValue(ctx, clientKey{}, client), nil
+}
+
+// rpcClient returns the client authenticateRPC put in the context of a call
+func rpcClient(ctx context.Context) apiClient {
+	client, _ := ctx.Value(clientKey{}).(apiClient)
+	return client
+}
+
+// authenticateUnary authenticates unary calls
+func (s *Server) authenticateUnary(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authenticateRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, request)
+}
+
+// authenticateStream authenticates streaming calls
+func (s *Server) authenticateStream(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticateRPC(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(server, authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticatedStream is a stream whose context carries its client
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context implements grpc.ServerStream
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// CreateSession implements agentpb.AgentServer
+func (g *grpcServer) CreateSession(ctx context.Context, request *agentpb.CreateSessionRequest) (*agentpb.Session, error) {
+	return protoSession(g.server.newSession(rpcClient(ctx)).snapshot()), nil
+}
+
+// ListSessions implements agentpb.AgentServer
+func (g *grpcServer) ListSessions(request *agentpb.ListSessionsRequest, stream agentpb.Agent_ListSessionsServer) error {
+	for _, session := range g.server.sessionList(rpcClient(stream.Context())) {
+		if err := stream.Send(protoSession(session)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSession implements agentpb.AgentServer
+func (g *grpcServer) GetSession(ctx context.Context, request *agentpb.GetSessionRequest) (*agentpb.Session, error) {
+	session, err := g.session(ctx, request.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	return protoSession(session.snapshot()), nil
+}
+
+// SendMessage implements agentpb.AgentServer. It streams the session's events from the
+// start of the turn until its outcome, and canceling the call cancels the turn.
+func (g *grpcServer) SendMessage(request *agentpb.SendMessageRequest, stream agentpb.Agent_SendMessageServer) error {
+	ctx := stream.Context()
+	session, err := g.session(ctx, request.SessionId)
+	if err != nil {
+		return err
+	}
+	if request.Text == "" {
+		return status.Error(codes.InvalidArgument, "text must be set")
+	}
+	user, err := rpcClient(ctx).messageUser(request.User)
+	if err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if !session.chat.mu.TryLock() {
+		return status.Error(codes.FailedPrecondition, "session is busy with the previous message")
+	}
+	defer session.chat.mu.Unlock()
+
+	session.running.Store(true)
+	start := session.events.count()
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		session.events.stream(ctx, eventSender{send: stream.Send, untilOutcome: true}, start)
+	}()
+	g.server.runTurn(ctx, session, request.Text, user)
+	<-streamed
+	return ctx.Err()
+}
+
+// StreamEvents implements agentpb.AgentServer
+func (g *grpcServer) StreamEvents(request *agentpb.StreamEventsRequest, stream agentpb.Agent_StreamEventsServer) error {
+	session, err := g.session(stream.Context(), request.SessionId)
+	if err != nil {
+		return err
+	}
+	session.events.stream(stream.Context(), eventSender{send: stream.Send}, int(request.After))
+	return nil
+}
+
+// ResolveApproval implements agentpb.AgentServer
+func (g *grpcServer) ResolveApproval(ctx context.Context, request *agentpb.ResolveApprovalRequest) (*agentpb.ResolveApprovalResponse, error) {
+	session, err := g.session(ctx, request.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if !session.resolveApproval(request.Token, request.Approve) {
+		return nil, status.Error(codes.NotFound, "no pending approval with that token; it may have been answered or timed out")
+	}
+	return &agentpb.ResolveApprovalResponse{}, nil
+}
+
+// session finds a session of the call's client by ID, failing with NotFound when there is
+// none or it belongs to another user
+func (g *grpcServer) session(ctx context.Context, id string) (*ServerSession, error) {
+	session := g.server.session(rpcClient(ctx), id)
+	if session == nil {
+		return nil, status.Error(codes.NotFound, "no such session")
+	}
+	return session, nil
+}
+
+// errTurnEnded stops a stream of events after the outcome of the turn
+var errTurnEnded = errors.New("the turn ended")
+
+// eventSender sends the JSON lines of an eventRecorder as agentpb events
+type eventSender struct {
+	send         func(*agentpb.Event) error
+	untilOutcome bool // Stop after the outcome event
+}
+
+// Write implements io.Writer for eventRecorder.stream, which writes one line at a time
+func (s eventSender) Write(p []byte) (int, error) {
+	event := Event{}
+	if err := json.Unmarshal(p, &event); err != nil {
+		return 0, err
+	}
+	if err := s.send(protoEvent(event)); err != nil {
+		return 0, err
+	}
+	if s.untilOutcome && event.Type == eventOutcome {
+		return 0, errTurnEnded
+	}
+	return len(p), nil
+}
+
+// protoSession converts a session for the gRPC API
+func protoSession(info SessionInfo) *agentpb.Session {
+	return &agentpb.Session{
+		Id:      info.ID,
+		Created: timestamppb.New(info.Created),
+		Status:  agentpb.SessionStatus(agentpb.SessionStatus_value["SESSION_STATUS_"+strings.ToUpper(info.Status)]),
+		Todos:   protoTodos(info.Todos),
+	}
+}
+
+// protoEvent converts an event for the gRPC API. Event types are named alike, so
+// tool_use becomes EVENT_TYPE_TOOL_USE.
+func protoEvent(event Event) *agentpb.Event {
+	converted := &agentpb.Event{
+		Type:       agentpb.EventType(agentpb.EventType_value["EVENT_TYPE_"+strings.ToUpper(event.Type)]),
+		Time:       timestamppb.New(event.Time),
+		Text:       event.Text,
+		Tool:       event.Tool,
+		ToolUseId:  event.ToolUseID,
+		Input:      string(event.Input),
+		IsError:    event.IsError,
+		Outcome:    event.Outcome,
+		Token:      event.Token,
+		Model:      event.Model,
+		StopReason: event.StopReason,
+		Todos:      protoTodos(event.Todos),
+		Failure:    event.Failure,
+	}
+	if event.ExitCode != nil {
+		exitCode := int32(*event.ExitCode)
+		converted.ExitCode = &exitCode
+	}
+	if event.Progress != nil {
+		converted.Progress = &agentpb.Progress{Done: int32(event.Progress.Done), Total: int32(event.Progress.Total), Current: event.Progress.Current}
+	}
+	if event.Usage != nil {
+		converted.Usage = &agentpb.Usage{
+			Calls:                    int32(event.Usage.Calls),
+			InputTokens:              event.Usage.InputTokens,
+			OutputTokens:             event.Usage.OutputTokens,
+			CacheCreationInputTokens: event.Usage.CacheWriteTokens,
+			CacheReadInputTokens:     event.Usage.CacheReadTokens,
+			CostUsd:                  event.Usage.Cost,
+		}
+	}
+	for _, citation := range event.Citations {
+		converted.Citations = append(converted.Citations, &agentpb.Citation{Document: citation.Document, Location: citation.Location, CitedText: citation.CitedText})
+	}
+	return converted
+}
+
+// protoTodos converts a task list for the gRPC API
+func protoTodos(todos []TodoItem) []*agentpb.TodoItem {
+	converted := []*agentpb.TodoItem{}
+	for _, todo := range todos {
+		converted = append(converted, &agentpb.TodoItem{Text: todo.Text, Status: todo.Status})
+	}
+	return converted
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"code-agent/agentpb"
+)
+
+// serveGRPC starts `go-agent serve` with the gRPC API against a fake API that answers
+// with text, and returns a client of it
+func serveGRPC(t *testing.T, text string) agentpb.AgentClient {
+	t.Helper()
+	api := fakeAPI(text)
+	t.Cleanup(api.Close)
+
+	addrs := []string{}
+	for range 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, listener.Addr().String())
+		listener.Close()
+	}
+	cmd := agentCommand(t, api.URL, "serve", "--addr", addrs[0], "--grpc-addr", addrs[1])
+	cmd.Env = append(cmd.Env, "SERVER_TOKEN=", "SERVER_USER_TOKENS=ann=ann-token,bob=bob-token")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	conn, err := grpc.NewClient(addrs[1], grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := agentpb.NewAgentClient(conn)
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		_, err := client.ListSessions(context.Background(), &agentpb.ListSessionsRequest{})
+		if err == nil || time.Since(start) > 10*time.Second {
+			break
+		}
+	}
+	return client
+}
+
+// as returns a context whose calls carry token
+func as(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestGRPCSendMessage(t *testing.T) {
+	client := serveGRPC(t, "The build passes.")
+	ctx := as("ann-token")
+
+	session, err := client.CreateSession(ctx, &agentpb.CreateSessionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Status != agentpb.SessionStatus_SESSION_STATUS_IDLE {
+		t.Errorf("new session is %s", session.Status)
+	}
+
+	stream, err := client.SendMessage(ctx, &agentpb.SendMessageRequest{SessionId: session.Id, Text: "Does the build pass?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []*agentpb.Event{}
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	types := map[agentpb.EventType]*agentpb.Event{}
+	for _, event := range events {
+		types[event.Type] = event
+	}
+	if text := types[agentpb.EventType_EVENT_TYPE_ASSISTANT_TEXT]; text == nil || text.Text != "The build passes." {
+		t.Errorf("no assistant text in %v", events)
+	}
+	if last := events[len(events)-1]; last.Type != agentpb.EventType_EVENT_TYPE_OUTCOME || last.Outcome != outcomeSuccess {
+		t.Errorf("the stream ends with %v, not a successful outcome", last)
+	}
+
+	// The events stay in the session for StreamEvents
+	replay, err := client.StreamEvents(ctx, &agentpb.StreamEventsRequest{SessionId: session.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := replay.Recv()
+	if err != nil || first.Type != events[0].Type || first.Text != events[0].Text {
+		t.Errorf("StreamEvents starts with %v (%v), want %v", first, err, events[0])
+	}
+
+	got, err := client.GetSession(ctx, &agentpb.GetSessionRequest{SessionId: session.Id})
+	if err != nil || got.Status != agentpb.SessionStatus_SESSION_STATUS_IDLE {
+		t.Errorf("after the turn the session is %v (%v)", got, err)
+	}
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	client := serveGRPC(t, "Hello.")
+
+	_, err := client.CreateSession(as("wrong"), &agentpb.CreateSessionRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("a wrong token gets %v", err)
+	}
+
+	session, err := client.CreateSession(as("ann-token"), &agentpb.CreateSessionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.SendMessage(as("ann-token"), &agentpb.SendMessageRequest{SessionId: session.Id, Text: "Hi", User: "bob"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("sending as another user gets %v", err)
+	}
+
+	_, err = client.GetSession(as("ann-token"), &agentpb.GetSessionRequest{SessionId: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("a missing session gets %v", err)
+	}
+}
+
+func TestGRPCSessionOwnership(t *testing.T) {
+	client := serveGRPC(t, "Hello.")
+	session, err := client.CreateSession(as("ann-token"), &agentpb.CreateSessionRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string]func(ctx context.Context) error{
+		"GetSession": func(ctx context.Context) error {
+			_, err := client.GetSession(ctx, &agentpb.GetSessionRequest{SessionId: session.Id})
+			return err
+		},
+		"SendMessage": func(ctx context.Context) error {
+			stream, err := client.SendMessage(ctx, &agentpb.SendMessageRequest{SessionId: session.Id, Text: "Hi"})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			return err
+		},
+		"StreamEvents": func(ctx context.Context) error {
+			stream, err := client.StreamEvents(ctx, &agentpb.StreamEventsRequest{SessionId: session.Id})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			return err
+		},
+		"ResolveApproval": func(ctx context.Context) error {
+			_, err := client.ResolveApproval(ctx, &agentpb.ResolveApprovalRequest{SessionId: session.Id, Token: "0123", Approve: true})
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(as("bob-token")); status.Code(err) != codes.NotFound {
+			t.Errorf("%s with another user's token gets %v, want NotFound", name, err)
+		}
+	}
+
+	stream, err := client.ListSessions(as("bob-token"), &agentpb.ListSessionsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listed, err := stream.Recv(); err != io.EOF {
+		t.Errorf("another user's token lists %v (%v)", listed, err)
+	}
+	if _, err := client.GetSession(as("ann-token"), &agentpb.GetSessionRequest{SessionId: session.Id}); err != nil {
+		t.Errorf("the owner gets %v", err)
+	}
+}
+
+func TestProtoEventTypes(t *testing.T) {
+	types := []string{
+		eventUserMessage, eventAssistantText, eventToolUse, eventToolResult, eventToolDenied, eventStatus, eventError, eventOutcome,
+		eventApprovalRequired, eventApprovalResolved, eventSessionStart, eventInference, eventTodo, eventProgress, eventUsage,
+		eventEditProposed, eventThinking, eventEgress,
+	}
+	for _, eventType := range types {
+		if converted := protoEvent(Event{Type: eventType}).Type; converted == agentpb.EventType_EVENT_TYPE_UNSPECIFIED {
+			t.Errorf("%s has no EventType in agent.proto", eventType)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// =============================================================================
+// SESSION LABELS
+// =============================================================================
+
+// Prefixes of the labels every session gets from where it ran
+const (
+	labelRepo   = "repo:"
+	labelBranch = "branch:"
+)
+
+// defaultSessionListLimit is how many sessions `go-agent sessions list` shows by default
+const defaultSessionListLimit = 50
+
+// gitLabels labels a session with the repository and branch of the working directory,
+// or returns none outside a repository
+func gitLabels() []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	root, err := gitOutput(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil
+	}
+	labels := []string{labelRepo + filepath.Base(strings.TrimSpace(root))}
+	if branch, err := gitOutput(ctx, "rev-parse", "--abbrev-ref", "HEAD"); err == nil && strings.TrimSpace(branch) != "HEAD" {
+		labels = append(labels, labelBranch+strings.TrimSpace(branch))
+	}
+	return labels
+}
+
+// addLabels adds labels the session does not have yet, keeping them sorted
+func (s *savedSession) addLabels(labels ...string) {
+	if s == nil {
+		return
+	}
+	for _, label := range labels {
+		if label = strings.TrimSpace(label); label != "" && !slices.Contains(s.Labels, label) {
+			s.Labels = append(s.Labels, label)
+		}
+	}
+	slices.Sort(s.Labels)
+}
+
+// removeLabels removes labels from the session
+func (s *savedSession) removeLabels(labels ...string) {
+	if s == nil {
+		return
+	}
+	s.Labels = slices.DeleteFunc(s.Labels, func(label string) bool { return slices.Contains(labels, label) })
+}
+
+// matchesLabels reports whether labels match every filter. A filter is a label or a glob
+// such as branch:release-*.
+func matchesLabels(labels, filters []string) bool {
+	for _, filter := range filters {
+		if !slices.ContainsFunc(labels, func(label string) bool {
+			matched, _ := path.Match(filter, label)
+			return matched || label == filter
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagCommand implements /tag
+func tagCommand(chat *chatState, args string) error {
+	if chat.session == nil {
+		return fmt.Errorf("sessions are not saved with SAVE_SESSIONS=false, so they have no labels")
+	}
+	add, remove := []string{}, []string{}
+	for _, label := range strings.Fields(args) {
+		if name, ok := strings.CutPrefix(label, "-"); ok {
+			remove = append(remove, name)
+		} else {
+			add = append(add, label)
+		}
+	}
+	chat.session.addLabels(add...)
+	chat.session.removeLabels(remove...)
+	if len(chat.conversation) > 0 && (len(add) > 0 || len(remove) > 0) {
+		chat.session.save(chat.conversation)
+	}
+	if len(chat.session.Labels) == 0 {
+		fmt.Println("This session has no labels; add one with /tag LABEL")
+		return nil
+	}
+	fmt.Printf("Labels: %s\n", strings.Join(chat.session.Labels, " "))
+	return nil
+}
+
+// labelFilters is a repeatable --label flag
+type labelFilters []string
+
+func (f *labelFilters) String() string { return strings.Join(*f, ",") }
+
+func (f *labelFilters) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runSessionsCommand implements `go-agent sessions list|usage|show|rm|rename`
+func runSessionsCommand(args []string) error {
+	usage := usageErrorf("usage: go-agent sessions list [--label LABEL]... [--limit N] | usage [--label LABEL]... [--by PREFIX] | show ID | rm ID... | rename ID TITLE")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "show":
+		return runSessionShow(args[1:])
+	case "rm":
+		return runSessionRemove(args[1:])
+	case "rename":
+		return runSessionRename(args[1:])
+	}
+	var filters labelFilters
+	flags := flag.NewFlagSet("sessions "+args[0], flag.ContinueOnError)
+	flags.Var(&filters, "label", "only sessions with this label, or a label matching this glob; repeat to require several")
+	limit := flags.Int("limit", defaultSessionListLimit, "show at most this many sessions, newest first; 0 shows all")
+	by := flags.String("by", "", "group only by the labels with this prefix, such as repo or branch")
+	if err := flags.Parse(args[1:]); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() > 0 {
+		return usage
+	}
+
+	sessions := []sessionFile{}
+	for _, session := range listSessionFiles() {
+		if matchesLabels(session.labels, filters) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].updated.After(sessions[j].updated) })
+
+	switch args[0] {
+	case "list":
+		if len(sessions) == 0 {
+			fmt.Println("No saved sessions match.")
+			return nil
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "ID\tLAST ACTIVE\tTOKENS\tCOST\tLABELS\tTITLE")
+		for i, session := range sessions {
+			if *limit > 0 && i == *limit {
+				break
+			}
+			tokens := session.usage.InputTokens + session.usage.CacheWriteTokens + session.usage.CacheReadTokens + session.usage.OutputTokens
+			fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\t%s\n", session.id, session.updated.Local().Format("2006-01-02 15:04"),
+				tokens, session.usage.costString(), strings.Join(session.labels, " "), truncateRunes(session.title, 60))
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+		if *limit > 0 && len(sessions) > *limit {
+			fmt.Printf("... and %d older session(s); use --limit 0 to show all\n", len(sessions)-*limit)
+		}
+		return nil
+	case "usage":
+		prefix := ""
+		if *by != "" {
+			prefix = strings.TrimSuffix(*by, ":") + ":"
+		}
+		type labelUsage struct {
+			sessions int
+			usage    UsageStats
+		}
+		groups := map[string]*labelUsage{}
+		for _, session := range sessions {
+			labels := []string{}
+			for _, label := range session.labels {
+				if strings.HasPrefix(label, prefix) {
+					labels = append(labels, label)
+				}
+			}
+			if len(labels) == 0 {
+				labels = []string{"(none)"}
+			}
+			for _, label := range labels {
+				if groups[label] == nil {
+					groups[label] = &labelUsage{}
+				}
+				groups[label].sessions++
+				groups[label].usage = groups[label].usage.plus(session.usage)
+			}
+		}
+		if len(groups) == 0 {
+			fmt.Println("No saved sessions match.")
+			return nil
+		}
+		// Most expensive first
+		labels := slices.Collect(maps.Keys(groups))
+		sort.Slice(labels, func(i, j int) bool {
+			if groups[labels[i]].usage.Cost != groups[labels[j]].usage.Cost {
+				return groups[labels[i]].usage.Cost > groups[labels[j]].usage.Cost
+			}
+			return labels[i] < labels[j]
+		})
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "LABEL\tSESSIONS\tCALLS\tINPUT TOKENS\tOUTPUT TOKENS\tCOST")
+		for _, label := range labels {
+			group := groups[label]
+			fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%s\n", label, group.sessions, group.usage.Calls,
+				group.usage.InputTokens+group.usage.CacheWriteTokens+group.usage.CacheReadTokens, group.usage.OutputTokens, group.usage.costString())
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+		if prefix == "" {
+			fmt.Println("A session counts toward each of its labels.")
+		}
+		return nil
+	}
+	return usage
+}
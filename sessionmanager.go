@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// =============================================================================
+// SESSION MANAGER
+// =============================================================================
+
+// runSessionShow implements `go-agent sessions show ID`: the session's details, then
+// its conversation the way the chat showed it
+func runSessionShow(args []string) error {
+	flags := flag.NewFlagSet("sessions show", flag.ContinueOnError)
+	last := flags.Int("last", 0, "show only the last N messages; 0 shows all")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("usage: go-agent sessions show [--last N] ID")
+	}
+	session, err := loadSavedSession(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Session:     %s\n", session.ID)
+	fmt.Printf("Title:       %s\n", session.Title)
+	fmt.Printf("Created:     %s\n", session.Created.Local().Format("2006-01-02 15:04"))
+	fmt.Printf("Last active: %s\n", session.Updated.Local().Format("2006-01-02 15:04"))
+	fmt.Printf("Workspace:   %s\n", session.Workspace)
+	if len(session.Labels) > 0 {
+		fmt.Printf("Labels:      %s\n", strings.Join(session.Labels, " "))
+	}
+	if session.Pinned {
+		fmt.Println("Pinned:      yes")
+	}
+	fmt.Printf("Messages:    %d\n", len(session.conversation))
+	fmt.Printf("Usage:       %d model call(s), %d input and %d output tokens, %s\n", session.Usage.Calls,
+		session.Usage.InputTokens+session.Usage.CacheWriteTokens+session.Usage.CacheReadTokens, session.Usage.OutputTokens, session.Usage.costString())
+	fmt.Println()
+	replayConversation(session.conversation, *last)
+	return nil
+}
+
+// runSessionRemove implements `go-agent sessions rm ID...`. Pinned sessions are kept
+// unless --force is given.
+func runSessionRemove(args []string) error {
+	flags := flag.NewFlagSet("sessions rm", flag.ContinueOnError)
+	force := flags.Bool("force", false, "remove pinned sessions too")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() == 0 {
+		return usageErrorf("usage: go-agent sessions rm [--force] ID...")
+	}
+
+	for _, id := range flags.Args() {
+		session, err := loadSavedSession(id)
+		if err != nil {
+			return err
+		}
+		if session.Pinned && !*force {
+			return fmt.Errorf("session %s is pinned; unpin it with `go-agent pin --unpin %s` or use --force", id, id)
+		}
+		if err := os.Remove(filepath.Join(sessionsDir(), id+".json")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove session %s: %w", id, err)
+		}
+		fmt.Printf("Removed %s: %s\n", id, session.Title)
+	}
+	return nil
+}
+
+// runSessionRename implements `go-agent sessions rename ID TITLE`, replacing the title
+// taken from the first prompt
+func runSessionRename(args []string) error {
+	if len(args) < 2 || strings.TrimSpace(strings.Join(args[1:], " ")) == "" {
+		return usageErrorf("usage: go-agent sessions rename ID TITLE")
+	}
+	session, err := loadSavedSession(args[0])
+	if err != nil {
+		return err
+	}
+	session.Title = strings.TrimSpace(strings.Join(args[1:], " "))
+	if err := session.write(); err != nil {
+		return fmt.Errorf("failed to rename session %s: %w", session.ID, err)
+	}
+	fmt.Printf("Renamed %s to %q\n", session.ID, session.Title)
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// SAVED SESSIONS
+// =============================================================================
+
+// savedSession is a conversation kept on disk after every turn, so a later run can pick
+// it up with --resume or --continue
+type savedSession struct {
+	ID        string          `json:"id"`
+	Created   time.Time       `json:"created"`
+	Updated   time.Time       `json:"updated"`
+	Workspace string          `json:"workspace"`        // Directory the session ran in, for --continue
+	Title     string          `json:"title"`            // First line of the first message
+	Pinned    bool            `json:"pinned,omitempty"` // Kept whatever the retention limits
+	Labels    []string        `json:"labels,omitempty"` // repo: and branch: of where it ran, and those added with /tag or --tag
+	Messages  json.RawMessage `json:"messages"`         // The conversation as sent to the API
+	Usage     UsageStats      `json:"usage"`            // Tokens and cost of every run of the session
+
+	conversation []anthropic.MessageParam
+	prior        UsageStats // Usage of the runs before this one
+}
+
+// sessionsDir is where sessions are saved
+func sessionsDir() string {
+	return filepath.Join(stateDir(), "sessions")
+}
+
+// sessionsEnabled reports whether conversations are saved; SAVE_SESSIONS=false turns it off
+func sessionsEnabled() bool {
+	return configValue("SAVE_SESSIONS") != "false"
+}
+
+// newSavedSession starts a session named after the time, e.g. 20261014-185211-3fa2
+func newSavedSession() *savedSession {
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+	now := time.Now()
+	workspace, _ := os.Getwd()
+	return &savedSession{
+		ID:        now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		Created:   now.UTC(),
+		Workspace: workspace,
+	}
+}
+
+// loadSavedSession reads the session with the given ID
+func loadSavedSession(id string) (*savedSession, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, fmt.Errorf("invalid session ID %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(sessionsDir(), id+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no saved session %s in %s", id, sessionsDir())
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := &savedSession{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", id, err)
+	}
+	if session.conversation, err = decodeMessages(session.Messages); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", id, err)
+	}
+	session.prior = session.Usage
+	return session, nil
+}
+
+// latestSavedSession finds the session that ran in workspace most recently
+func latestSavedSession(workspace string) (*savedSession, error) {
+	paths, _ := filepath.Glob(filepath.Join(sessionsDir(), "*.json"))
+	var latest *savedSession
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var header struct {
+			ID        string    `json:"id"`
+			Updated   time.Time `json:"updated"`
+			Workspace string    `json:"workspace"`
+		}
+		if json.Unmarshal(data, &header) != nil || header.Workspace != workspace {
+			continue
+		}
+		if latest == nil || header.Updated.After(latest.Updated) {
+			latest = &savedSession{ID: header.ID, Updated: header.Updated}
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no saved session for %s; start one without --continue", workspace)
+	}
+	return loadSavedSession(latest.ID)
+}
+
+// openSession returns the session the run continues, or starts one, and removes the
+// sessions past the retention limits. It returns nil when sessions are not saved.
+func (o *RunOptions) openSession() *savedSession {
+	if o.saved == nil && sessionsEnabled() {
+		o.saved = newSavedSession()
+	}
+	if o.saved != nil {
+		o.saved.addLabels(append(gitLabels(), o.Tags...)...)
+		o.retention.apply(o.saved.ID, false)
+	}
+	return o.saved
+}
+
+// history returns the conversation so far, which is empty for a new session
+func (s *savedSession) history() []anthropic.MessageParam {
+	if s == nil {
+		return []anthropic.MessageParam{}
+	}
+	return append([]anthropic.MessageParam{}, s.conversation...)
+}
+
+// priorUsage is the usage of the session's earlier runs
+func (s *savedSession) priorUsage() UsageStats {
+	if s == nil {
+		return UsageStats{}
+	}
+	return s.prior
+}
+
+// resumed reports whether the session continues an earlier run
+func (s *savedSession) resumed() bool {
+	return s != nil && len(s.conversation) > 0
+}
+
+// save writes the conversation, replacing the session file in one step. Failures are
+// warnings; a session that cannot be saved still runs. An imported conversation is not
+// saved with SAVE_SESSIONS=false.
+func (s *savedSession) save(conversation []anthropic.MessageParam) {
+	if s == nil || len(conversation) == 0 || !sessionsEnabled() {
+		return
+	}
+	messages, err := json.Marshal(conversation)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to save session %s: %s\n", paint(roleWarning, "warning"), s.ID, err.Error())
+		return
+	}
+	s.conversation = conversation
+	s.Messages = messages
+	s.Usage = s.prior.plus(runOptions.usage.snapshot())
+	s.Updated = time.Now().UTC()
+	if s.Title == "" {
+		s.Title = sessionTitle(conversation)
+	}
+
+	// The session may have been pinned or unpinned by `go-agent pin` since it was read
+	if data, err := os.ReadFile(filepath.Join(sessionsDir(), s.ID+".json")); err == nil {
+		var header struct {
+			Pinned bool `json:"pinned"`
+		}
+		if json.Unmarshal(data, &header) == nil {
+			s.Pinned = header.Pinned
+		}
+	}
+	if err := s.write(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to save session %s: %s\n", paint(roleWarning, "warning"), s.ID, err.Error())
+	}
+}
+
+// write replaces the session file in one step
+func (s *savedSession) write() error {
+	path := filepath.Join(sessionsDir(), s.ID+".json")
+	data, _ := json.Marshal(s)
+	if err := os.MkdirAll(sessionsDir(), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// sessionTitle is the first line of the conversation's first text, to recognize it by
+func sessionTitle(conversation []anthropic.MessageParam) string {
+	for _, message := range conversation {
+		for _, block := range message.Content {
+			if block.OfText != nil && attachmentName(block) == "" {
+				title, _, _ := strings.Cut(strings.TrimSpace(block.OfText.Text), "\n")
+				return title
+			}
+		}
+	}
+	return ""
+}
+
+// describe summarizes the session for the user when it is resumed
+func (s *savedSession) describe() string {
+	description := fmt.Sprintf("%s (%d messages, last active %s", s.ID, len(s.conversation), s.Updated.Local().Format("2006-01-02 15:04"))
+	if s.Usage.Calls > 0 {
+		description += ", " + s.Usage.costString() + " so far"
+	}
+	return description + "): " + s.Title
+}
+
+// decodeMessages reads a saved conversation. The SDK cannot decode references to
+// uploaded files, so those documents are rebuilt from the raw JSON.
+func decodeMessages(data json.RawMessage) ([]anthropic.MessageParam, error) {
+	conversation := []anthropic.MessageParam{}
+	if err := json.Unmarshal(data, &conversation); err != nil {
+		return nil, err
+	}
+
+	var raw []struct {
+		Content []struct {
+			Type      string `json:"type"`
+			Title     string `json:"title"`
+			Citations *struct {
+				Enabled bool `json:"enabled"`
+			} `json:"citations"`
+			Source struct {
+				Type   string `json:"type"`
+				FileID string `json:"file_id"`
+			} `json:"source"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for i, message := range raw {
+		for j, block := range message.Content {
+			if block.Type == "document" && block.Source.Type == "file" && i < len(conversation) && j < len(conversation[i].Content) {
+				conversation[i].Content[j] = fileDocumentBlock(block.Source.FileID, block.Title, block.Citations != nil && block.Citations.Enabled)
+			}
+		}
+	}
+	return conversation, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// READ-ONLY SESSION SHARING
+// =============================================================================
+
+// Share link lifetimes
+const (
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+// sessionShare grants read-only access to a session's transcript until it expires
+type sessionShare struct {
+	session *ServerSession
+	expires time.Time
+}
+
+// ShareInfo describes a share link in API responses
+type ShareInfo struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// createShare creates a share link for the session, valid for {"ttl": "<duration>"}. Like
+// every session endpoint, it finds only the client's own sessions.
+func (s *Server) createShare(w http.ResponseWriter, r *http.Request) {
+	session := s.lookup(w, r)
+	if session == nil {
+		return
+	}
+
+	var request struct {
+		TTL string `json:"ttl"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+	ttl := defaultShareTTL
+	if request.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(request.TTL); err != nil || ttl <= 0 || ttl > maxShareTTL {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("ttl must be a duration such as 2h, at most %s", maxShareTTL))
+			return
+		}
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	token := hex.EncodeToString(id)
+	share := &sessionShare{session: session, expires: time.Now().Add(ttl).UTC()}
+
+	s.mu.Lock()
+	for key, existing := range s.shares {
+		if time.Now().After(existing.expires) {
+			delete(s.shares, key)
+		}
+	}
+	s.shares[token] = share
+	s.mu.Unlock()
+
+	base := strings.TrimSuffix(configValue("SERVER_PUBLIC_URL"), "/")
+	if base == "" {
+		base = "http://" + r.Host
+	}
+	writeJSON(w, http.StatusCreated, ShareInfo{URL: base + "/share/" + token, Expires: share.expires})
+}
+
+// lookupShare finds the unexpired share named in the path, writing a 404 if there is none
+func (s *Server) lookupShare(w http.ResponseWriter, r *http.Request) *sessionShare {
+	s.mu.Lock()
+	share := s.shares[r.PathValue("token")]
+	s.mu.Unlock()
+
+	if share == nil || time.Now().After(share.expires) {
+		http.Error(w, "This share link does not exist or has expired.", http.StatusNotFound)
+		return nil
+	}
+	return share
+}
+
+// sharePage serves the transcript viewer, which follows the share's event stream
+func (s *Server) sharePage(w http.ResponseWriter, r *http.Request) {
+	if s.lookupShare(w, r) == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	fmt.Fprint(w, shareViewer)
+}
+
+// shareEvents streams the shared session's events until the share expires, without their
+// approval tokens
+func (s *Server) shareEvents(w http.ResponseWriter, r *http.Request) {
+	share := s.lookupShare(w, r)
+	if share == nil {
+		return
+	}
+	ctx, cancel := context.WithDeadline(r.Context(), share.expires)
+	defer cancel()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	share.session.events.stream(ctx, shareWriter{w}, 0)
+}
+
+// shareWriter writes the event lines of a share without approval tokens, which would let
+// anyone with the link answer the session's approvals
+type shareWriter struct {
+	w http.ResponseWriter
+}
+
+// Write implements io.Writer for eventRecorder.stream, which writes one line at a time
+func (s shareWriter) Write(p []byte) (int, error) {
+	event := Event{}
+	if err := json.Unmarshal(p, &event); err != nil {
+		return 0, err
+	}
+	event.Token = ""
+	line, _ := json.Marshal(event)
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush implements http.Flusher, so the events reach the viewer as they happen
+func (s shareWriter) Flush() {
+	http.NewResponseController(s.w).Flush()
+}
+
+// shareViewer renders a session's events as a transcript, live while the session runs.
+// Event text is inserted as text nodes, never as HTML.
+const shareViewer = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>go-agent session</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 56rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+.event { margin: 0.75rem 0; padding: 0.5rem 0.75rem; border-left: 3px solid #ccc; white-space: pre-wrap; word-wrap: break-word; }
+.event .meta { font-size: 0.8rem; color: #777; white-space: normal; }
+.user_message { border-color: #3b82f6; }
+.assistant_text { border-color: #eab308; }
+.citations { margin: 0.5rem 0 0; padding-left: 1.5rem; font-size: 0.85rem; color: #555; white-space: normal; }
+.tool_use, .tool_result { border-color: #22c55e; font-family: ui-monospace, monospace; font-size: 0.85rem; }
+.tool_denied, .error, .is_error { border-color: #ef4444; }
+.approval_required, .approval_resolved, .status, .outcome { border-color: #a855f7; }
+#state { color: #777; }
+</style>
+</head>
+<body>
+<h1>go-agent session</h1>
+<p id="state">Connecting&hellip;</p>
+<div id="events"></div>
+<script>
+const labels = {
+  user_message: "User", assistant_text: "Claude", tool_use: "Tool call", tool_result: "Tool result",
+  tool_denied: "Tool call denied", status: "Status", error: "Error", outcome: "Outcome",
+  approval_required: "Approval requested", approval_resolved: "Approval",
+};
+
+function render(event) {
+  const div = document.createElement("div");
+  div.className = "event " + event.type + (event.is_error ? " is_error" : "");
+  const meta = document.createElement("div");
+  meta.className = "meta";
+  meta.textContent = (labels[event.type] || event.type) + (event.tool ? " · " + event.tool : "") +
+    " · " + new Date(event.time).toLocaleTimeString();
+  div.appendChild(meta);
+
+  let text = event.text || event.outcome || "";
+  if (event.type === "tool_use" && event.input) text = JSON.stringify(event.input, null, 2);
+  if (text.length > 4000) text = text.slice(0, 4000) + "\n… (truncated)";
+  div.appendChild(document.createTextNode(text));
+  if (event.citations) {
+    const list = document.createElement("ol");
+    list.className = "citations";
+    for (const citation of event.citations) {
+      const item = document.createElement("li");
+      item.textContent = citation.document + ", " + citation.location + ": \u201c" + citation.cited_text.trim() + "\u201d";
+      list.appendChild(item);
+    }
+    div.appendChild(list);
+  }
+  document.getElementById("events").appendChild(div);
+}
+
+async function follow() {
+  const state = document.getElementById("state");
+  const response = await fetch(location.pathname.replace(/\/$/, "") + "/events");
+  if (!response.ok) {
+    state.textContent = "This share link does not exist or has expired.";
+    return;
+  }
+  state.textContent = "Read-only view. New events appear as the session runs.";
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) break;
+    buffer += value;
+    const lines = buffer.split("\n");
+    buffer = lines.pop();
+    lines.filter(line => line.trim()).forEach(line => render(JSON.parse(line)));
+  }
+  state.textContent = "The share link has expired or the server closed the stream.";
+}
+
+follow().catch(err => { document.getElementById("state").textContent = "Connection lost: " + err; });
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// SHELL PASSTHROUGH
+// =============================================================================
+
+// runPassthrough runs a command the user typed after ! in the chat, streaming its output
+// to the terminal as well as capturing it. The model is not involved.
+func runPassthrough(ctx context.Context, command string) (CommandResult, error) {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Stderr = cmd.Stdout
+
+	err := runOptions.cleanup.runProcess(cmd)
+	result := CommandResult{Command: command, Output: output.String()}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to run %q: %w", command, err)
+	}
+	return result, nil
+}
+
+// commandContext labels a command's output for Claude, keeping the end of long output
+func commandContext(result CommandResult) anthropic.ContentBlockParamUnion {
+	return anthropic.NewTextBlock(fmt.Sprintf("<command-output command=%q exit_code=\"%d\">\n%s\n</command-output>",
+		result.Command, result.ExitCode, strings.TrimRight(tailToTokens(result.Output, toolResultMaxTokens()), "\n")))
+}
+
+// handlePassthrough runs a !command, !!command or /run command chat line. With !! or
+// /run ... --attach the output is returned as context for the next message; otherwise it
+// is only shown.
+func (a *Agent) handlePassthrough(ctx context.Context, line string) []anthropic.ContentBlockParamUnion {
+	var command string
+	var attach bool
+	if rest, ok := strings.CutPrefix(line, "/run"); ok {
+		command, attach = strings.CutSuffix(strings.TrimSpace(rest), "--attach")
+	} else {
+		command, attach = strings.CutPrefix(strings.TrimPrefix(line, "!"), "!")
+	}
+	command = strings.TrimSpace(command)
+	if command == "" {
+		fmt.Println("Usage: !command or /run command runs a shell command; !!command or /run command --attach also attaches its output to your next message")
+		return nil
+	}
+
+	result, err := runPassthrough(ctx, command)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleError, "error"), err.Error())
+		return nil
+	}
+	if result.Output != "" && !strings.HasSuffix(result.Output, "\n") {
+		fmt.Println()
+	}
+	if !result.Passed() {
+		fmt.Fprintf(os.Stderr, "%s: exit status %d\n", paint(roleStatus, "shell"), result.ExitCode)
+	}
+	a.events.Emit(Event{Type: eventStatus, Text: fmt.Sprintf("ran %q (exit status %d)", command, result.ExitCode)})
+
+	if !attach {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "%s: output attached to your next message (~%d tokens)\n", paint(roleStatus, "shell"), estimateTokens(result.Output))
+	return []anthropic.ContentBlockParamUnion{commandContext(result)}
+}
+
+// isPassthrough reports whether a chat line is a !command for handlePassthrough; /run
+// lines arrive through the slash commands
+func isPassthrough(line string) bool {
+	return strings.HasPrefix(line, "!")
+}
+
+// defaultStdinMaxTokens caps the piped input attached to a task, unless STDIN_MAX_TOKENS is set
+const defaultStdinMaxTokens = 50000
+
+// readPipedInput reads all of stdin when it is a pipe or a file rather than a terminal, as
+// in `git diff | go-agent "review this diff"`. It reports false for a terminal, or when
+// READ_STDIN=false leaves stdin alone.
+func readPipedInput() (string, bool, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || configValue("READ_STDIN") == "false" || info.Mode()&os.ModeNamedPipe == 0 && !info.Mode().IsRegular() {
+		return "", false, nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read stdin: %w", err)
+	}
+	return string(data), true, nil
+}
+
+// pipedContext labels piped input for Claude, keeping at most STDIN_MAX_TOKENS of it
+func pipedContext(input string) anthropic.ContentBlockParamUnion {
+	limit := configInt("STDIN_MAX_TOKENS", defaultStdinMaxTokens)
+	fmt.Fprintf(os.Stderr, "%s: stdin (~%d tokens)\n", paint(roleInfo, "context"), estimateTokens(input))
+	if estimateTokens(input) > limit {
+		fmt.Fprintf(os.Stderr, "%s: stdin is cut to STDIN_MAX_TOKENS=%d tokens\n", paint(roleWarning, "warning"), limit)
+	}
+	return anthropic.NewTextBlock(fmt.Sprintf("<stdin>\n%s\n</stdin>", strings.TrimRight(truncateToTokens(input, limit), "\n")))
+}
+
+// contextCommands runs the --context-cmd commands and returns their labeled output
+func contextCommands(ctx context.Context, commands []string) ([]anthropic.ContentBlockParamUnion, error) {
+	blocks := []anthropic.ContentBlockParamUnion{}
+	for _, command := range commands {
+		result, err := runShellCommand(ctx, command)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s (exit status %d, ~%d tokens)\n", paint(roleInfo, "context"), command, result.ExitCode, estimateTokens(result.Output))
+		blocks = append(blocks, commandContext(result))
+	}
+	return blocks, nil
+}
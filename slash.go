@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// SLASH COMMANDS
+// =============================================================================
+
+// chatState is the part of the chat that slash commands read and change
+type chatState struct {
+	ctx          context.Context
+	agent        *Agent
+	session      *savedSession // Where the conversation is saved; nil with SAVE_SESSIONS=false
+	conversation []anthropic.MessageParam
+	attached     []anthropic.ContentBlockParamUnion // Command output waiting for the next message
+	exit         bool                               // Set to end the chat after the command
+}
+
+// slashCommand is a chat command typed after /, handled without the model
+type slashCommand struct {
+	name    string
+	args    string // Arguments as /help shows them, e.g. [NAME]
+	summary string
+	run     func(chat *chatState, args string) error
+}
+
+// slashCommands returns the chat's commands in the order /help lists them. A new command
+// only needs an entry here.
+func slashCommands() []slashCommand {
+	var commands []slashCommand
+	help := slashCommand{"help", "", "list these commands", func(chat *chatState, args string) error {
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, command := range commands {
+			if command.summary != "" {
+				fmt.Fprintf(table, "  /%s\t%s\n", strings.TrimSpace(command.name+" "+command.args), command.summary)
+			}
+		}
+		fmt.Fprintf(table, "  //TEXT\tsend a message that starts with /\n")
+		return table.Flush()
+	}}
+	commands = []slashCommand{
+		help,
+		{"clear", "", "start over with an empty conversation in a new session", clearCommand},
+		{"model", "[NAME]", "show the model, or switch to another for the rest of the chat", modelCommand},
+		{"save", "[FILE]", "save the session now, or write the conversation to a JSON file", saveCommand},
+		{"export", "[FILE]", "write the conversation with its tool calls to a Markdown file, or JSON for a .json name", exportCommand},
+		{"tag", "[LABEL|-LABEL]...", "show the session's labels, or add and remove labels", tagCommand},
+		{"tools", "", "list the tools Claude can use", toolsCommand},
+		{"permissions", "[revoke N...|revoke all]", "list the approvals remembered for this project, or revoke them", permissionsCommand},
+		{"usage", "", "show the tokens and cost of this run and the session", usageCommand},
+		{"diff", "[RANGE]", "attach the uncommitted changes, or a commit range such as main..HEAD, to your next message", diffCommand},
+		{"run", "COMMAND [--attach]", "run a shell command; --attach adds its output to your next message (also !COMMAND and !!COMMAND)", runCommandLine},
+		{"exit", "", "end the chat (also /quit and ctrl-d)", exitCommand},
+		{"quit", "", "", exitCommand},
+	}
+	return commands
+}
+
+// runSlashCommand handles a chat line that is a slash command and reports whether it was
+// one. A line whose first word has more slashes, such as a path, goes to Claude.
+func runSlashCommand(chat *chatState, commands []slashCommand, line string) bool {
+	rest, ok := strings.CutPrefix(line, "/")
+	if !ok || strings.HasPrefix(rest, "/") {
+		return false
+	}
+	name, args, _ := strings.Cut(rest, " ")
+	if name == "" || strings.Contains(name, "/") {
+		return false
+	}
+	for _, command := range commands {
+		if command.name == name {
+			if err := command.run(chat, strings.TrimSpace(args)); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleError, "error"), err.Error())
+			}
+			return true
+		}
+	}
+	fmt.Printf("Unknown command /%s; /help lists the commands, and //%s sends the line to Claude\n", name, name)
+	return true
+}
+
+// runShell runs a !command or /run line between turns; presets can turn it off
+func (c *chatState) runShell(line string) {
+	if !runOptions.preset.allowsShell() {
+		fmt.Fprintf(os.Stderr, "%s: shell commands are turned off here by preset %s\n", paint(roleStatus, "shell"), runOptions.preset.Path)
+		return
+	}
+	commandCtx, done := runOptions.interrupts.startTurn(c.ctx)
+	c.attached = append(c.attached, c.agent.handlePassthrough(commandCtx, line)...)
+	done()
+}
+
+// runCommandLine implements /run
+func runCommandLine(chat *chatState, args string) error {
+	chat.runShell("/run " + args)
+	return nil
+}
+
+// clearCommand implements /clear. The old session stays saved, so it can be resumed.
+func clearCommand(chat *chatState, args string) error {
+	chat.conversation, chat.attached = []anthropic.MessageParam{}, nil
+	chat.agent.todos.clear()
+	if chat.session != nil {
+		runOptions.saved = nil
+		chat.session = runOptions.openSession()
+		fmt.Printf("Cleared the conversation; this is session %s (continue it later with --resume %s)\n", chat.session.ID, chat.session.ID)
+	} else {
+		fmt.Println("Cleared the conversation")
+	}
+	chat.agent.events.Emit(Event{Type: eventStatus, Text: "conversation cleared"})
+	return nil
+}
+
+// modelCommand implements /model
+func modelCommand(chat *chatState, args string) error {
+	if args == "" {
+		fmt.Printf("Model: %s\n", chat.agent.model)
+		return nil
+	}
+	if err := checkModel(args); err != nil {
+		return err
+	}
+	chat.agent.model = anthropic.Model(args)
+	fmt.Printf("Model: %s\n", args)
+	chat.agent.events.Emit(Event{Type: eventStatus, Text: "model switched to " + args})
+	return nil
+}
+
+// saveCommand implements /save
+func saveCommand(chat *chatState, args string) error {
+	if len(chat.conversation) == 0 {
+		return fmt.Errorf("there is no conversation to save yet")
+	}
+	if args == "" {
+		if chat.session == nil {
+			return fmt.Errorf("sessions are not saved with SAVE_SESSIONS=false; use /save FILE to write the conversation to a file")
+		}
+		chat.session.save(chat.conversation)
+		fmt.Printf("Saved session %s (continue it later with --resume %s)\n", chat.session.ID, chat.session.ID)
+		return nil
+	}
+	data, err := json.MarshalIndent(chat.conversation, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(args, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save the conversation: %w", err)
+	}
+	fmt.Printf("Saved %d messages to %s\n", len(chat.conversation), args)
+	return nil
+}
+
+// toolsCommand implements /tools
+func toolsCommand(chat *chatState, args string) error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, tool := range chat.agent.tools {
+		summary, _, _ := strings.Cut(tool.Description, "\n")
+		if tool.Mutating {
+			summary = "(changes files) " + summary
+		}
+		fmt.Fprintf(table, "  %s\t%s\n", tool.Name, truncateRunes(summary, 100))
+	}
+	return table.Flush()
+}
+
+// usageCommand implements /usage
+func usageCommand(chat *chatState, args string) error {
+	run := runOptions.usage.snapshot()
+	fmt.Printf("%s: this run: %s\n", paint(roleInfo, "usage"), run)
+	if chat.session != nil && chat.session.priorUsage().Calls > 0 {
+		fmt.Printf("%s: session %s: %s\n", paint(roleInfo, "usage"), chat.session.ID, chat.session.priorUsage().plus(run))
+	}
+	return nil
+}
+
+// exitCommand implements /exit and /quit
+func exitCommand(chat *chatState, args string) error {
+	chat.exit = true
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// CONTEXT SNAPSHOTS
+// =============================================================================
+
+// Defaults of the snapshot settings
+const (
+	defaultSnapshotMaxTokens = 50000 // SNAPSHOT_MAX_TOKENS: largest context a snapshot may hold
+	defaultSnapshotMapFiles  = 2000  // SNAPSHOT_MAP_FILES: paths listed in the repository map
+	snapshotMinCacheTokens   = 1024  // Shortest prefix the API caches
+)
+
+// ContextSnapshot is context that sessions of a project can start from: the system
+// prompt, a map of the repository and the contents of pinned files. It is sent as the
+// first block of the system prompt, marked for prompt caching, so sessions started
+// within a few minutes of each other, or of `snapshot warm`, read it from the cache
+// instead of paying for it in full. The snapshot keeps what to include; the context is
+// built from the current files whenever it is used.
+type ContextSnapshot struct {
+	Name      string    `json:"name"`
+	Workspace string    `json:"workspace"`
+	Created   time.Time `json:"created"`
+	Map       bool      `json:"map"`             // Whether the repository map is included
+	Files     []string  `json:"files,omitempty"` // Pinned files, relative to the workspace
+	Tokens    int       `json:"tokens"`          // Estimated size of the context when saved
+	Hash      string    `json:"hash"`            // Hash of the context when saved
+
+	context string // The context built for this run
+}
+
+// snapshotsDir is where the snapshots of the current workspace are kept
+func snapshotsDir() string {
+	workspace, _ := os.Getwd()
+	return filepath.Join(stateDir(), "snapshots", shortHash([]byte(workspace)))
+}
+
+// snapshotPath is where the snapshot with the given name is kept
+func snapshotPath(name string) string {
+	return filepath.Join(snapshotsDir(), name+".json")
+}
+
+// validSnapshotName reports whether name can be used as a file name
+func validSnapshotName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".")
+}
+
+// loadSnapshot reads the snapshot of the current workspace with the given name and builds
+// its context
+func loadSnapshot(name string) (*ContextSnapshot, error) {
+	data, err := os.ReadFile(snapshotPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no snapshot %q for this directory (save one with go-agent snapshot save %s)", name, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	snapshot := &ContextSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", name, err)
+	}
+	if err := snapshot.build(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// save writes the snapshot, recording the size and hash of its current context
+func (s *ContextSnapshot) save() error {
+	s.Tokens = estimateTokens(s.context)
+	s.Hash = shortHash([]byte(s.context))
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(snapshotsDir(), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", snapshotsDir(), err)
+	}
+	return os.WriteFile(snapshotPath(s.Name), data, 0o600)
+}
+
+// build assembles the snapshot's context from the system prompt, the repository and the
+// pinned files as they are now
+func (s *ContextSnapshot) build() error {
+	parts := []string{}
+	if runOptions.systemPrompt != "" {
+		parts = append(parts, runOptions.systemPrompt)
+	}
+	if s.Map {
+		paths, err := repositoryFiles(runOptions.target.dir())
+		if err != nil {
+			return err
+		}
+		limit := configInt("SNAPSHOT_MAP_FILES", defaultSnapshotMapFiles)
+		listed := paths[:min(len(paths), limit)]
+		repoMap := "<repository_map>\n" + strings.Join(listed, "\n")
+		if len(paths) > len(listed) {
+			repoMap += fmt.Sprintf("\n[%d more files not listed]", len(paths)-len(listed))
+		}
+		parts = append(parts, repoMap+"\n</repository_map>")
+	}
+	for _, path := range s.Files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("snapshot %s: failed to read pinned file: %w", s.Name, err)
+		}
+		parts = append(parts, fmt.Sprintf("<file path=%q>\n%s\n</file>", path, strings.TrimRight(string(data), "\n")))
+	}
+	s.context = strings.Join(parts, "\n\n")
+
+	if limit := configInt("SNAPSHOT_MAX_TOKENS", defaultSnapshotMaxTokens); estimateTokens(s.context) > limit {
+		return fmt.Errorf("snapshot %s is ~%d tokens, over SNAPSHOT_MAX_TOKENS (%d); pin fewer files", s.Name, estimateTokens(s.context), limit)
+	}
+	return nil
+}
+
+// changed reports whether the context differs from the one the snapshot was saved with
+func (s *ContextSnapshot) changed() bool {
+	return shortHash([]byte(s.context)) != s.Hash
+}
+
+// block is the snapshot as the cached first block of the system prompt
+func (s *ContextSnapshot) block() anthropic.TextBlockParam {
+	return anthropic.TextBlockParam{Text: s.context, CacheControl: anthropic.NewCacheControlEphemeralParam()}
+}
+
+// repositoryFiles lists the files under dir that git tracks or would track, or every
+// file outside hidden directories when dir is not in a git repository
+func repositoryFiles(dir string) ([]string, error) {
+	result, err := runProgram(context.Background(), "git", "ls-files", "--cached", "--others", "--exclude-standard", "--", dir)
+	if err == nil && result.Passed() {
+		paths := slices.DeleteFunc(strings.Split(result.Output, "\n"), func(path string) bool { return path == "" })
+		slices.Sort(paths)
+		return slices.Compact(paths), nil
+	}
+
+	paths := []string{}
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && path != dir && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// warm sends the snapshot to the API once, so the sessions that follow within the cache's
+// lifetime read it from the cache. It returns the usage of the call.
+func (s *ContextSnapshot) warm(ctx context.Context) (anthropic.Usage, error) {
+	client, err := initializeClient()
+	if err != nil {
+		return anthropic.Usage{}, err
+	}
+	// The cache matches on the tools that come before the system prompt, so they have to
+	// be the agent's own
+	agent := NewAgent(client, nil, defaultTools())
+	message, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:      agent.model,
+		MaxTokens:  1,
+		System:     []anthropic.TextBlockParam{s.block()},
+		Tools:      agent.convertToolsToAnthropicFormat(),
+		ToolChoice: toolChoice(),
+		Messages:   []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("Reply with OK."))},
+	})
+	if err != nil {
+		return anthropic.Usage{}, err
+	}
+	return message.Usage, nil
+}
+
+// runSnapshotCommand implements `go-agent snapshot save|warm|list|delete`
+func runSnapshotCommand(args []string) error {
+	usage := usageErrorf("usage: go-agent snapshot save NAME [--no-map] [--no-warm] [--file PATH]... | warm NAME | list | delete NAME")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "save":
+		flags := flag.NewFlagSet("snapshot save", flag.ContinueOnError)
+		noMap := flags.Bool("no-map", false, "leave the repository map out")
+		noWarm := flags.Bool("no-warm", false, "save without warming the cache")
+		files := []string{}
+		flags.Func("file", "pin this file's contents in the snapshot; repeatable", func(path string) error {
+			files = append(files, filepath.ToSlash(filepath.Clean(path)))
+			return nil
+		})
+		if len(args) < 2 || !validSnapshotName(args[1]) {
+			return usage
+		}
+		if err := flags.Parse(args[2:]); err != nil {
+			return usageError{err}
+		}
+		workspace, _ := os.Getwd()
+		snapshot := &ContextSnapshot{Name: args[1], Workspace: workspace, Created: time.Now().UTC(), Map: !*noMap, Files: files}
+		if err := snapshot.build(); err != nil {
+			return err
+		}
+		if snapshot.context == "" {
+			return fmt.Errorf("the snapshot would be empty: set a system prompt, keep the map or pin files with --file")
+		}
+		if err := snapshot.save(); err != nil {
+			return err
+		}
+		fmt.Printf("Saved snapshot %s: ~%d tokens, %d pinned file(s); start sessions from it with --snapshot %s\n", snapshot.Name, snapshot.Tokens, len(files), snapshot.Name)
+		if snapshot.Tokens < snapshotMinCacheTokens {
+			fmt.Printf("note: the API only caches prompts of at least %d tokens, so this snapshot saves no cost\n", snapshotMinCacheTokens)
+		}
+		if *noWarm {
+			return nil
+		}
+		return reportWarm(snapshot)
+	case "warm":
+		if len(args) != 2 {
+			return usage
+		}
+		snapshot, err := loadSnapshot(args[1])
+		if err != nil {
+			return err
+		}
+		return reportWarm(snapshot)
+	case "list":
+		if len(args) != 1 {
+			return usage
+		}
+		entries, _ := filepath.Glob(filepath.Join(snapshotsDir(), "*.json"))
+		if len(entries) == 0 {
+			fmt.Println("No snapshots for this directory. Save one with go-agent snapshot save NAME.")
+			return nil
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "NAME\tSAVED\tTOKENS\tMAP\tFILES")
+		for _, entry := range entries {
+			snapshot, err := loadSnapshot(strings.TrimSuffix(filepath.Base(entry), ".json"))
+			if err != nil {
+				fmt.Fprintf(table, "%s\t(%s)\n", strings.TrimSuffix(filepath.Base(entry), ".json"), err)
+				continue
+			}
+			tokens := fmt.Sprintf("~%d", snapshot.Tokens)
+			if snapshot.changed() {
+				tokens += fmt.Sprintf(" (now ~%d, changed)", estimateTokens(snapshot.context))
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%t\t%s\n", snapshot.Name, snapshot.Created.Local().Format(time.DateTime), tokens, snapshot.Map, strings.Join(snapshot.Files, ", "))
+		}
+		return table.Flush()
+	case "delete":
+		if len(args) != 2 || !validSnapshotName(args[1]) {
+			return usage
+		}
+		if err := os.Remove(snapshotPath(args[1])); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("no snapshot %q for this directory", args[1])
+			}
+			return err
+		}
+		fmt.Printf("Deleted snapshot %s\n", args[1])
+		return nil
+	}
+	return usage
+}
+
+// reportWarm warms the cache with the snapshot and says how much of it was cached
+func reportWarm(snapshot *ContextSnapshot) error {
+	usage, err := snapshot.warm(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to warm the cache: %w", err)
+	}
+	switch {
+	case usage.CacheReadInputTokens > 0:
+		fmt.Printf("Cache already warm: %d tokens read from it\n", usage.CacheReadInputTokens)
+	case usage.CacheCreationInputTokens > 0:
+		fmt.Printf("Cache warmed: %d tokens written; sessions in the next few minutes read them from the cache\n", usage.CacheCreationInputTokens)
+	default:
+		fmt.Println("Nothing was cached; the snapshot may be too short for the model's cache")
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// =============================================================================
+// STREAMED RESPONSES
+// =============================================================================
+
+// streamingEnabled reports whether model calls stream Claude's answer to the terminal.
+// STREAM=false waits for whole responses, e.g. behind gateways that buffer server-sent events.
+func streamingEnabled() bool {
+	return configValue("STREAM") != "false"
+}
+
+// sendMessage makes one model call, streamed unless streaming is turned off, and notes
+// whether Claude's text was already printed, in whole or, when the stream failed, in part
+func (a *Agent) sendMessage(ctx context.Context, params anthropic.MessageNewParams, opts ...option.RequestOption) (*anthropic.Message, error) {
+	a.streamed, a.partial = false, false
+	if !streamingEnabled() {
+		return a.client.Messages.New(ctx, params, opts...)
+	}
+	message, err := a.streamMessage(ctx, params, opts...)
+	if err != nil {
+		a.partial = message != nil && len(message.Content) > 0
+		return nil, err
+	}
+	a.streamed = true
+	return message, nil
+}
+
+// streamMessage makes a model call with the streaming API, printing text as it arrives,
+// and returns the complete message. When the stream fails, what arrived before is
+// returned with the error.
+func (a *Agent) streamMessage(ctx context.Context, params anthropic.MessageNewParams, opts ...option.RequestOption) (*anthropic.Message, error) {
+	stream := a.client.Messages.NewStreaming(ctx, params, opts...)
+	defer stream.Close()
+
+	message := &anthropic.Message{}
+	printer := newStreamPrinter(os.Stdout)
+	for stream.Next() {
+		event := stream.Current()
+		if err := message.Accumulate(event); err != nil {
+			printer.end()
+			return nil, err
+		}
+		printer.print(event, message)
+	}
+	printer.end()
+	if err := stream.Err(); err != nil {
+		return message, err
+	}
+
+	// The budget and rate limit middleware only see JSON responses, so streamed spend and
+	// output tokens are recorded here
+	runOptions.budget.record(string(message.Model), message.Usage)
+	apiRateLimiter().charge(message.Usage.OutputTokens)
+	return message, nil
+}
+
+// Limits on how often streamed text is written out. A terminal is redrawn at most every
+// streamFlushInterval; piped output is written a line at a time, or once this much of a
+// line is pending.
+const (
+	streamFlushInterval = 50 * time.Millisecond
+	streamMaxPending    = 4096
+)
+
+// ansiEscape matches terminal colors and cursor movement
+var ansiEscape = regexp.MustCompile("\u001b\\[[0-9;]*[A-Za-z]")
+
+// isTerminal reports whether f is a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// streamPrinter renders the text of a streamed response the way processClaudeResponse
+// prints whole ones: consecutive text blocks as one answer, cited claims marked [n].
+// Deltas are batched rather than written one by one, so a slow terminal or a CI console
+// is not flooded with tiny writes, and logs get whole lines without colors.
+type streamPrinter struct {
+	out       io.Writer
+	terminal  bool            // Whether out is a terminal; otherwise colors are stripped
+	pending   strings.Builder // Text not written yet
+	lastFlush time.Time
+	inText    bool            // Whether the current line is Claude's answer
+	thinking  bool            // Whether Claude's thinking is being shown on stderr
+	citations int             // Citations numbered so far
+	markdown  *markdownStream // Renders the answer, unless it is printed raw
+}
+
+// newStreamPrinter creates a printer that writes to out
+func newStreamPrinter(out *os.File) *streamPrinter {
+	// The --tui transcript takes colors and redraws like a terminal
+	printer := &streamPrinter{out: out, terminal: isTerminal(out) || runOptions.tui != nil, lastFlush: time.Now()}
+	if printer.terminal && renderMarkdown() {
+		printer.markdown = &markdownStream{out: out, logical: runOptions.tui != nil}
+	}
+	return printer
+}
+
+// print shows what an event adds to the answer
+func (p *streamPrinter) print(event anthropic.MessageStreamEventUnion, message *anthropic.Message) {
+	switch event := event.AsAny().(type) {
+	case anthropic.ContentBlockStartEvent:
+		if event.ContentBlock.Type != "text" {
+			p.end()
+		}
+		switch event.ContentBlock.Type {
+		case "thinking":
+			fmt.Fprintf(os.Stderr, "%s: ", paint(roleThinking, "thinking"))
+			p.thinking = true
+		case "redacted_thinking":
+			printThinking("(redacted)")
+		}
+	case anthropic.ContentBlockDeltaEvent:
+		if delta, ok := event.Delta.AsAny().(anthropic.ThinkingDelta); ok && p.thinking {
+			fmt.Fprint(os.Stderr, paintLines(roleThinking, delta.Thinking))
+			return
+		}
+		delta, ok := event.Delta.AsAny().(anthropic.TextDelta)
+		if !ok {
+			return
+		}
+		if !p.inText {
+			if p.markdown != nil {
+				p.markdown.start(claudeLabel())
+			} else {
+				p.write(claudeLabel())
+			}
+			p.inText = true
+		}
+		p.write(delta.Text)
+	case anthropic.ContentBlockStopEvent:
+		if p.thinking {
+			fmt.Fprintln(os.Stderr)
+			p.thinking = false
+		}
+		if !p.inText || len(message.Content) == 0 {
+			return
+		}
+		_, citations := citedText(message.Content[len(message.Content)-1:])
+		for range citations {
+			p.citations++
+			p.write(fmt.Sprintf("[%d]", p.citations))
+		}
+	}
+}
+
+// write queues text and writes out what the flush limits allow
+func (p *streamPrinter) write(text string) {
+	p.pending.WriteString(text)
+	if p.terminal {
+		if time.Since(p.lastFlush) >= streamFlushInterval {
+			p.flush(p.pending.Len())
+		}
+		return
+	}
+	if p.pending.Len() >= streamMaxPending {
+		p.flush(p.pending.Len())
+	} else if end := strings.LastIndexByte(p.pending.String(), '\n'); end >= 0 {
+		p.flush(end + 1)
+	}
+}
+
+// flush writes the first n bytes of the pending text
+func (p *streamPrinter) flush(n int) {
+	if n == 0 {
+		return
+	}
+	pending := p.pending.String()
+	text := pending[:n]
+	switch {
+	case !p.terminal:
+		io.WriteString(p.out, ansiEscape.ReplaceAllString(text, ""))
+	case p.markdown != nil && p.inText:
+		p.markdown.write(text)
+	default:
+		io.WriteString(p.out, text)
+	}
+	p.pending.Reset()
+	p.pending.WriteString(pending[n:])
+	p.lastFlush = time.Now()
+}
+
+// end finishes the answer's or the thinking's line and writes out everything pending
+func (p *streamPrinter) end() {
+	if p.thinking {
+		fmt.Fprintln(os.Stderr)
+		p.thinking = false
+	}
+	if p.inText && p.markdown != nil {
+		p.flush(p.pending.Len())
+		p.markdown.finish()
+		p.inText = false
+	}
+	if p.inText {
+		p.pending.WriteString("\n")
+		p.inText = false
+	}
+	p.flush(p.pending.Len())
+}
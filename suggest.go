@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// FILE SUGGESTIONS
+// =============================================================================
+
+// Defaults and limits of file suggestions
+const (
+	defaultSuggestFiles = 3                      // SUGGEST_FILES: most files suggested for a message
+	suggestMinScore     = 3                      // Lowest score worth asking about
+	suggestTimeout      = 500 * time.Millisecond // How long the heuristic may take
+	suggestRecentDays   = 14                     // How far back recent commits count
+)
+
+// identifierTerm matches prompt words that look like code symbols: CamelCase, snake_case
+// or calls such as parseConfig()
+var identifierTerm = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*[A-Z_][A-Za-z0-9_]*$|^[A-Za-z_][A-Za-z0-9_]*\(\)$`)
+
+// suggestStopWords are words of requests that say what to do rather than where, and
+// would otherwise match files such as fix.go
+var suggestStopWords = map[string]bool{
+	"add": true, "fix": true, "make": true, "change": true, "update": true, "remove": true, "delete": true,
+	"explain": true, "review": true, "test": true, "tests": true, "file": true, "files": true, "code": true,
+	"the": true, "and": true, "for": true, "why": true, "how": true, "what": true, "does": true, "with": true,
+}
+
+// fileSuggestion is a file that may help with a message, with how well it matched
+type fileSuggestion struct {
+	path   string
+	score  int
+	tokens int
+}
+
+// suggestFiles finds files that a message seems to be about, without the model: files
+// whose names match words of the message, files that define or use the symbols it names,
+// and, among those, files changed recently. Messages that @mention files get no
+// suggestions, and neither do files the conversation already has attached.
+func suggestFiles(ctx context.Context, input string, conversation []anthropic.MessageParam) []fileSuggestion {
+	limit := configInt("SUGGEST_FILES", defaultSuggestFiles)
+	if limit <= 0 || len(parseMentions(input)) > 0 {
+		return nil
+	}
+	terms := promptTerms(input)
+	if len(terms) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, suggestTimeout)
+	defer cancel()
+
+	paths, err := repositoryFiles(runOptions.target.dir())
+	if err != nil {
+		return nil
+	}
+	scores := map[string]int{}
+	for _, path := range paths {
+		if score := nameScore(path, terms); score > 0 {
+			scores[path] = score
+		}
+	}
+	for _, symbol := range symbolTerms(input) {
+		output, err := gitOutput(ctx, "grep", "-l", "-w", "-F", "-e", symbol, "--", runOptions.target.dir())
+		if err != nil {
+			continue
+		}
+		for _, path := range strings.Split(strings.TrimSpace(output), "\n") {
+			if path != "" {
+				scores[path] += 3
+			}
+		}
+	}
+	for path := range recentlyChanged(ctx) {
+		if scores[path] > 0 {
+			scores[path]++
+		}
+	}
+
+	attached := map[string]bool{}
+	for _, path := range attachmentPaths(conversation) {
+		attached[filepath.Clean(path)] = true
+	}
+	maxBytes := attachmentBudgetTokens() * 4
+	suggestions := []fileSuggestion{}
+	for path, score := range scores {
+		if score < suggestMinScore || attached[filepath.Clean(path)] {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > int64(maxBytes) {
+			continue
+		}
+		suggestions = append(suggestions, fileSuggestion{path: path, score: score, tokens: int(info.Size()+3) / 4})
+	}
+	// Best matches first; among equals, smaller files first
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].score != suggestions[j].score {
+			return suggestions[i].score > suggestions[j].score
+		}
+		if suggestions[i].tokens != suggestions[j].tokens {
+			return suggestions[i].tokens < suggestions[j].tokens
+		}
+		return suggestions[i].path < suggestions[j].path
+	})
+	return suggestions[:min(len(suggestions), limit)]
+}
+
+// nameScore scores how well a path matches the prompt terms: a file named after a term
+// counts most, a directory named after one or a name starting with one less. Names are
+// compared without separators, so "token store" finds token_store.go.
+func nameScore(path string, terms []string) int {
+	base := strings.ToLower(filepath.Base(path))
+	stem := squash(strings.TrimSuffix(base, filepath.Ext(base)))
+	if stem == "" {
+		stem = squash(base) // Dotfiles such as .gitignore
+	}
+	dirs := strings.Split(strings.ToLower(filepath.ToSlash(filepath.Dir(path))), "/")
+
+	score := 0
+	for i, term := range terms {
+		word := squash(term)
+		if suggestStopWords[word] {
+			continue
+		}
+		switch {
+		case word == "":
+		case word == stem || term == base || term == strings.ToLower(filepath.ToSlash(path)):
+			score += 4
+		case i+1 < len(terms) && (strings.HasPrefix(squash(term+terms[i+1]), stem) || strings.HasPrefix(stem, squash(term+terms[i+1]))):
+			score += 4
+		case len(word) >= 4 && strings.HasPrefix(stem, word):
+			score += 2
+		}
+		for _, dir := range dirs {
+			if squash(dir) == word {
+				score++
+				break
+			}
+		}
+	}
+	return score
+}
+
+// squash lowercases s and keeps only its letters and digits
+func squash(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, s)
+}
+
+// symbolTerms returns the words of the message that look like code symbols
+func symbolTerms(input string) []string {
+	symbols := []string{}
+	seen := map[string]bool{}
+	for _, word := range strings.Fields(input) {
+		word = strings.Trim(word, ",.;:!?\"'`")
+		if !identifierTerm.MatchString(word) {
+			continue
+		}
+		word = strings.TrimSuffix(word, "()")
+		if len(word) < 4 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		symbols = append(symbols, word)
+	}
+	return symbols
+}
+
+// recentlyChanged returns the files with uncommitted changes or changed by recent commits
+func recentlyChanged(ctx context.Context) map[string]bool {
+	changed := map[string]bool{}
+	since := fmt.Sprintf("--since=%d.days", suggestRecentDays)
+	for _, args := range [][]string{
+		{"diff", "--name-only", "HEAD", "--relative"},
+		{"log", "--name-only", "--relative", "--format=", "-n", "50", since},
+	} {
+		output, err := gitOutput(ctx, args...)
+		if err != nil {
+			continue
+		}
+		for _, path := range strings.Split(output, "\n") {
+			if path != "" {
+				changed[path] = true
+			}
+		}
+	}
+	return changed
+}
+
+// offerSuggestions asks about each suggested file and returns the message with the ones
+// the user accepted @mentioned, so they are attached like any other mention
+func (a *Agent) offerSuggestions(ctx context.Context, input string, conversation []anthropic.MessageParam) string {
+	if a.getUserMessage == nil {
+		return input
+	}
+	mentions := []string{}
+	for _, suggestion := range suggestFiles(ctx, input, conversation) {
+		prompt(fmt.Sprintf("%s: attach %s (~%d tokens)? [y/N] ", paint(roleNotice, "suggest"), suggestion.path, suggestion.tokens))
+		answer, ok := a.getUserMessage()
+		if !ok {
+			break
+		}
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer == "y" || answer == "yes" {
+			mentions = append(mentions, "@"+suggestion.path)
+		}
+	}
+	if len(mentions) == 0 {
+		return input
+	}
+	return input + "\n\n" + strings.Join(mentions, " ")
+}
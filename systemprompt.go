@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// SYSTEM PROMPT
+// =============================================================================
+
+// loadSystemPrompt combines the system prompt file, if any, with the --system text. The
+// file comes first, so a project's instructions can be extended for one run.
+func loadSystemPrompt(text, path string) (string, error) {
+	parts := []string{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read system prompt: %w", err)
+		}
+		parts = append(parts, strings.TrimSpace(string(data)))
+	}
+	parts = append(parts, strings.TrimSpace(text))
+	return strings.TrimSpace(strings.Join(parts, "\n\n")), nil
+}
+
+// systemBlocks is the system prompt of the agent's model calls, empty when none is set.
+// A --snapshot already holds the system prompt, and is sent in its place to be cached.
+func (o *RunOptions) systemBlocks() []anthropic.TextBlockParam {
+	if o.snapshot != nil {
+		return []anthropic.TextBlockParam{o.snapshot.block()}
+	}
+	if o.systemPrompt == "" {
+		return nil
+	}
+	return []anthropic.TextBlockParam{{Text: o.systemPrompt}}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// =============================================================================
+// MONOREPO TARGETS
+// =============================================================================
+
+// Target is the subproject of a monorepo that --target scopes the agent to. list_files
+// and search_files default to its directory, map's --files patterns are relative to it,
+// its project's commands back the run_* tools, and edit_file may only write inside it.
+type Target struct {
+	Name    string   // Package name from the manifest, or the directory
+	Dir     string   // Slash-separated directory relative to the working directory, e.g. services/api
+	Project *Project // Toolchain of the target, when one is recognized
+}
+
+// manifestName is the package name in a Cargo.toml [package] or pyproject.toml [project] table
+var manifestName = regexp.MustCompile(`(?m)^\[(?:package|project)\]\s*$(?:\n[^\[].*$)*?\n\s*name\s*=\s*["']([^"']+)["']`)
+
+// findTargets returns the subprojects under the working directory, one per directory
+// holding a manifest the project detector knows, sorted by directory
+func findTargets() ([]*Target, error) {
+	targets := []*Target{}
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if path != "." && (strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules" || name == "target") {
+			return filepath.SkipDir
+		}
+		for _, marker := range projectMarkers {
+			for _, file := range marker.files {
+				if data, err := os.ReadFile(filepath.Join(path, file)); err == nil {
+					dir := filepath.ToSlash(path)
+					name := cmp.Or(packageName(marker.kind, data), dir)
+					targets = append(targets, &Target{Name: name, Dir: dir, Project: detectProject(path)})
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	return targets, err
+}
+
+// packageName reads the package name from a manifest of kind, or returns ""
+func packageName(kind string, data []byte) string {
+	switch kind {
+	case projectGo:
+		for _, line := range strings.Split(string(data), "\n") {
+			if module, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+				return strings.Trim(strings.TrimSpace(module), `"`)
+			}
+		}
+	case projectNode:
+		var manifest struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal(data, &manifest)
+		return manifest.Name
+	case projectRust, projectPython:
+		if match := manifestName.FindSubmatch(data); match != nil {
+			return string(match[1])
+		}
+	}
+	return ""
+}
+
+// resolveTarget finds the target named by spec: a directory, a package name from one of
+// the manifests, or a Go package path inside one of the modules
+func resolveTarget(spec string) (*Target, error) {
+	if info, err := os.Stat(spec); err == nil && info.IsDir() {
+		dir, err := relativeDir(spec)
+		if err != nil {
+			return nil, err
+		}
+		targets, _ := findTargets()
+		for _, target := range targets {
+			if target.Dir == dir {
+				return target, nil
+			}
+		}
+		return &Target{Name: dir, Dir: dir, Project: targetProject(dir)}, nil
+	}
+
+	targets, err := findTargets()
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		if target.Name == spec {
+			return target, nil
+		}
+	}
+	for _, target := range targets {
+		if rest, ok := strings.CutPrefix(spec, target.Name+"/"); ok && target.Project != nil && target.Project.Kind == projectGo {
+			if info, err := os.Stat(filepath.Join(target.Dir, rest)); err == nil && info.IsDir() {
+				dir := filepath.ToSlash(filepath.Join(target.Dir, rest))
+				return &Target{Name: spec, Dir: dir, Project: targetProject(dir)}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("--target %s is neither a directory nor a package of this repository (see go-agent targets)", spec)
+}
+
+// targetProject detects the project of a target directory. In a package directory below
+// a Go module's root, the module's ./... commands are narrowed to the package's tree.
+func targetProject(dir string) *Project {
+	project := detectProject(dir)
+	if project == nil || project.Kind != projectGo {
+		return project
+	}
+	abs, _ := filepath.Abs(dir)
+	rel, err := filepath.Rel(project.Root, abs)
+	if err != nil || rel == "." {
+		return project
+	}
+	packages := "./" + filepath.ToSlash(rel) + "/..."
+	for _, command := range []*string{&project.Build, &project.Test, &project.Lint} {
+		*command = strings.ReplaceAll(*command, "./...", packages)
+	}
+	return project
+}
+
+// relativeDir returns dir relative to the working directory, refusing directories outside it
+func relativeDir(dir string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("--target %s is outside the working directory", dir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// dir is the directory the file tools default to: the target's, or the working directory
+func (t *Target) dir() string {
+	if t == nil {
+		return "."
+	}
+	return t.Dir
+}
+
+// contains reports whether a path relative to the working directory lies in the target
+func (t *Target) contains(path string) bool {
+	if t == nil || t.Dir == "." {
+		return true
+	}
+	rel, err := relativeDir(path)
+	return err == nil && (rel == t.Dir || strings.HasPrefix(rel, t.Dir+"/"))
+}
+
+// deniesWrite explains why a tool call writes outside the target, or returns "" when it
+// stays inside
+func (t *Target) deniesWrite(tool ToolDefinition, input json.RawMessage) string {
+	if t == nil || tool.Name != EditFileDefinition.Name {
+		return ""
+	}
+	editFileInput := EditFileInput{}
+	json.Unmarshal(input, &editFileInput)
+	if t.contains(editFileInput.Path) {
+		return ""
+	}
+	return fmt.Sprintf("--target %s, since %s is outside %s/", t.Name, editFileInput.Path, t.Dir)
+}
+
+// context tells Claude about the target for the system prompt
+func (t *Target) context() string {
+	return fmt.Sprintf("You are working on %s in %s/ of a monorepo. Keep your changes inside %s/: edits of files elsewhere are denied. "+
+		"list_files and search_files default to %s/, and paths stay relative to the repository's working directory.", t.Name, t.Dir, t.Dir, t.Dir)
+}
+
+// String names the target for the startup line
+func (t *Target) String() string {
+	if t.Name == t.Dir {
+		return t.Dir
+	}
+	return fmt.Sprintf("%s (%s)", t.Name, t.Dir)
+}
+
+// affectedTargets returns the targets holding files changed since base: its merge base
+// with HEAD for committed changes, plus the staged, unstaged and untracked files. A file
+// belongs to the deepest target containing it.
+func affectedTargets(ctx context.Context, targets []*Target, base string) ([]*Target, error) {
+	from := "HEAD"
+	if base != "HEAD" {
+		result, err := runProgram(ctx, "git", "merge-base", base, "HEAD")
+		if err != nil {
+			return nil, err
+		}
+		if !result.Passed() {
+			return nil, fmt.Errorf("no merge base of %s and HEAD: %s", base, strings.TrimSpace(result.Output))
+		}
+		from = strings.TrimSpace(result.Output)
+	}
+	changed := []string{}
+	for _, args := range [][]string{
+		{"diff", "--name-only", "--relative", from},
+		{"ls-files", "--others", "--exclude-standard"},
+	} {
+		result, err := runProgram(ctx, "git", args...)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Passed() {
+			return nil, fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(result.Output))
+		}
+		changed = append(changed, strings.Fields(result.Output)...)
+	}
+
+	// Deeper directories sort after their parents, so the last match is the deepest
+	affected := []*Target{}
+	for _, path := range changed {
+		var owner *Target
+		for _, target := range targets {
+			if target.Dir == "." || path == target.Dir || strings.HasPrefix(path, target.Dir+"/") {
+				owner = target
+			}
+		}
+		if owner != nil && !slices.Contains(affected, owner) {
+			affected = append(affected, owner)
+		}
+	}
+	sort.Slice(affected, func(i, j int) bool { return affected[i].Dir < affected[j].Dir })
+	return affected, nil
+}
+
+// runTargetsCommand implements `go-agent targets [--affected] [--base REF]`
+func runTargetsCommand(args []string) error {
+	flags := flag.NewFlagSet("targets", flag.ContinueOnError)
+	affected := flags.Bool("affected", false, "only list the targets with changes since --base")
+	base := flags.String("base", "HEAD", "branch or commit the changes are measured from, e.g. origin/main")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() > 0 {
+		return usageErrorf("usage: go-agent targets [--affected] [--base REF]")
+	}
+
+	targets, err := findTargets()
+	if err != nil {
+		return err
+	}
+	if *affected {
+		if targets, err = affectedTargets(context.Background(), targets, *base); err != nil {
+			return err
+		}
+	}
+	if len(targets) == 0 {
+		if *affected {
+			fmt.Fprintf(os.Stderr, "No target has changes since %s.\n", *base)
+		} else {
+			fmt.Fprintln(os.Stderr, "No Go, Node, Python or Rust project found under this directory.")
+		}
+		return nil
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, target := range targets {
+		kind := "-"
+		if target.Project != nil {
+			kind = target.Project.Kind
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\n", target.Dir, target.Name, kind)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// =============================================================================
+// USAGE TELEMETRY
+// =============================================================================
+
+const (
+	telemetryInterval = 24 * time.Hour // How often a report is sent
+	telemetrySamples  = 1000           // Latency samples kept per series for the percentiles
+)
+
+// TelemetryReport is everything telemetry sends: counts and latency percentiles, never
+// prompts, file names, paths or output
+type TelemetryReport struct {
+	Agent     string                          `json:"agent"` // go-agent version
+	OS        string                          `json:"os"`
+	Arch      string                          `json:"arch"`
+	Since     string                          `json:"since"` // Day the first run in the report started
+	Runs      int                             `json:"runs"`
+	Features  map[string]int                  `json:"features"`   // Runs per command and flag
+	ToolCalls map[string]int                  `json:"tool_calls"` // Calls per built-in tool; others count as custom
+	Errors    map[string]int                  `json:"errors"`     // Failures per class, like api_429 or tool_error
+	LatencyMS map[string]TelemetryPercentiles `json:"latency_ms"` // Model and tool call latency
+}
+
+// TelemetryPercentiles summarize the latency samples of one series
+type TelemetryPercentiles struct {
+	Count int   `json:"count"`
+	P50   int64 `json:"p50"`
+	P90   int64 `json:"p90"`
+	P99   int64 `json:"p99"`
+}
+
+// telemetryState is kept in the user's config directory between runs
+type telemetryState struct {
+	Enabled  bool      `json:"enabled"`
+	LastSent time.Time `json:"last_sent"`
+
+	// Metrics collected since the last report
+	Since     time.Time          `json:"since"`
+	Runs      int                `json:"runs"`
+	Features  map[string]int     `json:"features"`
+	ToolCalls map[string]int     `json:"tool_calls"`
+	Errors    map[string]int     `json:"errors"`
+	Latencies map[string][]int64 `json:"latencies"` // Milliseconds, at most telemetrySamples each
+}
+
+// telemetryRecorder collects the metrics of one run. A nil recorder, which is what runs
+// without telemetry get, records nothing.
+type telemetryRecorder struct {
+	mu        sync.Mutex
+	toolCalls map[string]int
+	errors    map[string]int
+	latencies map[string][]int64
+}
+
+// telemetryPath is where the telemetry state is kept
+func telemetryPath() string {
+	return filepath.Join(configDir(), "telemetry.json")
+}
+
+// loadTelemetryState reads the telemetry state, which is empty and disabled by default
+func loadTelemetryState() (*telemetryState, error) {
+	state := &telemetryState{}
+	data, err := os.ReadFile(telemetryPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", telemetryPath(), err)
+	}
+	return state, nil
+}
+
+// save writes the state, replacing the file in one step
+func (s *telemetryState) save() error {
+	path := telemetryPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(s, "", "  ")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// reset drops the metrics collected so far
+func (s *telemetryState) reset() {
+	s.Since, s.Runs = time.Time{}, 0
+	s.Features, s.ToolCalls, s.Errors, s.Latencies = nil, nil, nil, nil
+}
+
+// telemetryBlocked returns why telemetry cannot be turned on, if something forbids it.
+// TELEMETRY=false and DO_NOT_TRACK win over `telemetry enable`.
+func telemetryBlocked() string {
+	if value, ok := managedSetting("TELEMETRY"); ok && value != "true" {
+		return "the managed settings turn it off"
+	}
+	if configValue("TELEMETRY") == "false" {
+		return "TELEMETRY=false"
+	}
+	if value := os.Getenv("DO_NOT_TRACK"); value != "" && value != "0" {
+		return "DO_NOT_TRACK is set"
+	}
+	return ""
+}
+
+// startTelemetry returns a recorder when the user opted in to telemetry, and nil otherwise
+func startTelemetry() *telemetryRecorder {
+	if telemetryBlocked() != "" {
+		return nil
+	}
+	if state, err := loadTelemetryState(); err != nil || !state.Enabled {
+		return nil
+	}
+	return &telemetryRecorder{toolCalls: map[string]int{}, errors: map[string]int{}, latencies: map[string][]int64{}}
+}
+
+// toolCall records a tool call, naming only built-in tools
+func (t *telemetryRecorder) toolCall(name string, elapsed time.Duration, failed bool) {
+	if t == nil {
+		return
+	}
+	if !builtinToolNames()[name] {
+		name = "custom"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.toolCalls[name]++
+	if failed {
+		t.errors["tool_error"]++
+	}
+	t.latencies["tool_call"] = append(t.latencies["tool_call"], elapsed.Milliseconds())
+}
+
+// middleware times every API call and counts the ones that fail
+func (t *telemetryRecorder) middleware(request *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	start := time.Now()
+	response, err := next(request)
+	if t == nil {
+		return response, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case err != nil:
+		t.errors[errorClass(err)]++
+	case response.StatusCode >= 400:
+		t.errors[fmt.Sprintf("api_%d", response.StatusCode)]++
+	default:
+		t.latencies["model_call"] = append(t.latencies["model_call"], time.Since(start).Milliseconds())
+	}
+	return response, err
+}
+
+// builtinToolNames are the tools whose names telemetry reports
+var builtinToolNames = sync.OnceValue(func() map[string]bool {
+	names := map[string]bool{"get_tool_output": true, "todo": true, "explore": true}
+	for _, tool := range defaultTools() {
+		names[tool.Name] = true
+	}
+	return names
+})
+
+// errorClass names the kind of an error without any of its text
+func errorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errOverBudget):
+		return "over_budget"
+	case errors.Is(err, errNeedsHuman):
+		return "needs_human"
+	case errors.Is(err, errContextOverflow):
+		return "context_overflow"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	default:
+		return "failed"
+	}
+}
+
+// runFeatures names what a run used: its command and the global flags that were set
+func runFeatures() []string {
+	features := []string{"chat"}
+	switch {
+	case flag.NArg() > 0:
+		features[0] = flag.Arg(0)
+	case runOptions.Prompt != "":
+		features[0] = "prompt"
+	}
+	if _, ok := subcommands[features[0]]; !ok && flag.NArg() > 0 {
+		features[0] = "unknown"
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "p" {
+			features = append(features, "--"+f.Name)
+		}
+	})
+	if runOptions.Mode != modeNormal {
+		features = append(features, "mode:"+runOptions.Mode)
+	}
+	return features
+}
+
+// flush adds the run's metrics to the saved ones, and sends a report when one is due
+func (t *telemetryRecorder) flush(err error) {
+	if t == nil || flag.Arg(0) == "telemetry" {
+		return
+	}
+	state, loadErr := loadTelemetryState()
+	if loadErr != nil || !state.Enabled {
+		return
+	}
+
+	t.mu.Lock()
+	if state.Runs == 0 {
+		state.Since = time.Now().UTC()
+	}
+	state.Runs++
+	for _, feature := range runFeatures() {
+		state.Features = addCount(state.Features, feature, 1)
+	}
+	for name, n := range t.toolCalls {
+		state.ToolCalls = addCount(state.ToolCalls, name, n)
+	}
+	if err != nil {
+		t.errors[errorClass(err)]++
+	}
+	for class, n := range t.errors {
+		state.Errors = addCount(state.Errors, class, n)
+	}
+	for series, samples := range t.latencies {
+		if state.Latencies == nil {
+			state.Latencies = map[string][]int64{}
+		}
+		kept := append(state.Latencies[series], samples...)
+		state.Latencies[series] = kept[max(0, len(kept)-telemetrySamples):]
+	}
+	t.mu.Unlock()
+
+	if endpoint := configValue("TELEMETRY_ENDPOINT"); endpoint != "" && time.Since(state.LastSent) >= telemetryInterval {
+		if sendTelemetry(endpoint, state.report()) == nil {
+			state.reset()
+			state.LastSent = time.Now().UTC()
+		}
+	}
+	state.save()
+}
+
+// addCount adds n to a count, creating the map when needed
+func addCount(counts map[string]int, key string, n int) map[string]int {
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	counts[key] += n
+	return counts
+}
+
+// report is what the next report will send
+func (s *telemetryState) report() TelemetryReport {
+	report := TelemetryReport{
+		Agent:     "unknown",
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Runs:      s.Runs,
+		Features:  s.Features,
+		ToolCalls: s.ToolCalls,
+		Errors:    s.Errors,
+		LatencyMS: map[string]TelemetryPercentiles{},
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		report.Agent = info.Main.Version
+	}
+	if !s.Since.IsZero() {
+		report.Since = s.Since.Format(time.DateOnly)
+	}
+	for series, samples := range s.Latencies {
+		sorted := slices.Sorted(slices.Values(samples))
+		report.LatencyMS[series] = TelemetryPercentiles{
+			Count: len(sorted),
+			P50:   percentile(sorted, 0.50),
+			P90:   percentile(sorted, 0.90),
+			P99:   percentile(sorted, 0.99),
+		}
+	}
+	return report
+}
+
+// percentile picks the nearest-rank percentile of sorted samples
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[max(0, int(math.Ceil(p*float64(len(sorted))))-1)]
+}
+
+// sendTelemetry posts a report to the telemetry endpoint
+func sendTelemetry(endpoint string, report TelemetryReport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return postJSON(ctx, endpoint, report)
+}
+
+// runTelemetryCommand implements `go-agent telemetry status|enable|disable|preview`
+func runTelemetryCommand(args []string) error {
+	if len(args) != 1 {
+		return usageErrorf("usage: go-agent telemetry status|enable|disable|preview")
+	}
+	state, err := loadTelemetryState()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		switch blocked := telemetryBlocked(); {
+		case blocked != "":
+			fmt.Printf("telemetry: off (%s)\n", blocked)
+		case state.Enabled:
+			fmt.Println("telemetry: on")
+		default:
+			fmt.Println("telemetry: off (enable it with go-agent telemetry enable)")
+		}
+		endpoint := configValue("TELEMETRY_ENDPOINT")
+		if endpoint == "" {
+			endpoint = "none; reports stay on this machine until TELEMETRY_ENDPOINT is set"
+		}
+		fmt.Printf("endpoint: %s\nstate: %s\n", endpoint, telemetryPath())
+		if state.Runs > 0 {
+			fmt.Printf("pending: %d run(s) since %s\n", state.Runs, state.Since.Format(time.DateOnly))
+		}
+		if !state.LastSent.IsZero() {
+			fmt.Printf("last sent: %s\n", state.LastSent.Format(time.DateTime))
+		}
+		return nil
+	case "enable":
+		if blocked := telemetryBlocked(); blocked != "" {
+			return fmt.Errorf("telemetry cannot be turned on: %s", blocked)
+		}
+		state.Enabled = true
+		if err := state.save(); err != nil {
+			return err
+		}
+		fmt.Println("telemetry: on. go-agent counts the commands, flags and built-in tools you use, classes of errors and")
+		fmt.Println("the latency of model and tool calls. It never records prompts, code, file names or output.")
+		fmt.Println("Run go-agent telemetry preview to see exactly what the next report will send.")
+		return nil
+	case "disable":
+		state.Enabled = false
+		state.reset()
+		if err := state.save(); err != nil {
+			return err
+		}
+		fmt.Println("telemetry: off; metrics not yet sent were deleted")
+		return nil
+	case "preview":
+		data, _ := json.MarshalIndent(state.report(), "", "  ")
+		fmt.Println(string(data))
+		return nil
+	default:
+		return fmt.Errorf("unknown telemetry command %q: use status, enable, disable or preview", args[0])
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// TEST GENERATION WORKFLOW
+// =============================================================================
+
+// maxListedFunctions caps how many under-covered functions are listed in a prompt
+const maxListedFunctions = 25
+
+// testGenPrompt asks Claude to raise coverage of a target
+const testGenPrompt = "Coverage of %s is %.1f%%. Write table-driven Go tests in the `_test.go` files of package %s to cover more of it.\n" +
+	"Read the source with the available tools first. Only add or edit `_test.go` files, keep the tests deterministic, and make sure they pass.\n\n" +
+	"Functions that are not fully covered:\n%s"
+
+// testGenFailedPrompt reports failing tests back to Claude
+const testGenFailedPrompt = "The tests in %s fail after your changes. Fix the tests (not the code under test) so they pass.\n\n" +
+	"Output:\n```\n%s\n```"
+
+// testGenNoProgressPrompt reports that coverage did not move
+const testGenNoProgressPrompt = "The tests pass but coverage of %s is still %.1f%%. Add tests for the remaining functions:\n%s"
+
+// FunctionCoverage is one line of `go tool cover -func` output
+type FunctionCoverage struct {
+	File    string
+	Line    int
+	Name    string
+	Percent float64
+}
+
+// CoverageReport is the coverage of a test-gen target after one `go test` run
+type CoverageReport struct {
+	Percent   float64
+	Functions []FunctionCoverage // Not fully covered functions, least covered first
+	Test      CommandResult
+}
+
+// runTestGenCommand implements `go-agent test-gen <file.go|package dir>`
+func runTestGenCommand(args []string) error {
+	flags := flag.NewFlagSet("test-gen", flag.ContinueOnError)
+	maxIterations := flags.Int("max-iterations", 3, "maximum number of test-writing attempts")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("usage: test-gen [--max-iterations N] <file.go|package dir>")
+	}
+
+	target := flags.Arg(0)
+	pkg, file, err := testGenTarget(target)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+
+	before, err := measureCoverage(ctx, pkg, file)
+	if err != nil {
+		return err
+	}
+	if !before.Test.Passed() {
+		return fmt.Errorf("existing tests in %s fail; fix them first (e.g. with `fix --cmd \"go test %s\"`)", pkg, pkg)
+	}
+	if len(before.Functions) == 0 {
+		fmt.Printf("test-gen: %s is already fully covered (%.1f%%)\n", target, before.Percent)
+		return nil
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+	agent := NewAgent(client, nil, defaultTools())
+
+	conversation := []anthropic.MessageParam{}
+	prompt := fmt.Sprintf(testGenPrompt, target, before.Percent, pkg, formatFunctionCoverage(before.Functions))
+	after := before
+
+	for iteration := 1; iteration <= *maxIterations; iteration++ {
+		fmt.Fprintf(os.Stderr, "%s: iteration %d/%d\n", paint(roleStatus, "test-gen"), iteration, *maxIterations)
+
+		conversation = append(conversation, anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)))
+		conversation, err = agent.runTurn(ctx, conversation)
+		if err != nil {
+			return err
+		}
+
+		after, err = measureCoverage(ctx, pkg, file)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !after.Test.Passed():
+			prompt = fmt.Sprintf(testGenFailedPrompt, pkg, tailToTokens(after.Test.Output, toolResultMaxTokens()))
+		case after.Percent <= before.Percent:
+			prompt = fmt.Sprintf(testGenNoProgressPrompt, target, after.Percent, formatFunctionCoverage(after.Functions))
+		default:
+			fmt.Printf("\ntest-gen: coverage of %s went from %.1f%% to %.1f%%\n", target, before.Percent, after.Percent)
+			agent.printChangedFiles()
+			return nil
+		}
+	}
+
+	fmt.Printf("\ntest-gen: coverage of %s did not improve (%.1f%% -> %.1f%%)\n", target, before.Percent, after.Percent)
+	agent.printChangedFiles()
+	if !after.Test.Passed() {
+		return fmt.Errorf("tests in %s fail after %d iteration(s)", pkg, *maxIterations)
+	}
+	return fmt.Errorf("coverage of %s did not improve after %d iteration(s)", target, *maxIterations)
+}
+
+// testGenTarget resolves a file or directory into the package to test and an optional file filter
+func testGenTarget(target string) (pkg, file string, err error) {
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", "", err
+	}
+
+	dir := target
+	if !info.IsDir() {
+		if !strings.HasSuffix(target, ".go") || strings.HasSuffix(target, "_test.go") {
+			return "", "", fmt.Errorf("%s is not a non-test Go source file", target)
+		}
+		dir, file = filepath.Dir(target), filepath.Base(target)
+	}
+
+	// go test treats bare relative names as import paths, so anchor them to the working directory
+	if !filepath.IsAbs(dir) {
+		dir = "./" + filepath.ToSlash(filepath.Clean(dir))
+	}
+
+	return dir, file, nil
+}
+
+// measureCoverage runs the package tests with a coverage profile and summarizes it for file
+// (or for the whole package when file is empty)
+func measureCoverage(ctx context.Context, pkg, file string) (CoverageReport, error) {
+	profile, err := runOptions.cleanup.tempFile("go-agent-cover-*.out")
+	if err != nil {
+		return CoverageReport{}, err
+	}
+	profile.Close()
+	defer os.Remove(profile.Name())
+
+	report := CoverageReport{}
+	report.Test, err = runProgram(ctx, "go", "test", "-coverprofile="+profile.Name(), pkg)
+	if err != nil {
+		return report, err
+	}
+	if !report.Test.Passed() {
+		return report, nil
+	}
+
+	report.Percent, err = profileCoverage(profile.Name(), file)
+	if err != nil {
+		return report, err
+	}
+
+	funcs, err := runProgram(ctx, "go", "tool", "cover", "-func="+profile.Name())
+	if err != nil {
+		return report, err
+	}
+	if !funcs.Passed() {
+		return report, fmt.Errorf("go tool cover failed: %s", funcs.Output)
+	}
+	report.Functions = parseFunctionCoverage(funcs.Output, file)
+
+	return report, nil
+}
+
+// profileCoverage computes the statement coverage of a profile, limited to file if set
+func profileCoverage(profile, file string) (float64, error) {
+	f, err := os.Open(profile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Blocks can repeat in a profile; a block counts as covered if any entry hit it
+	type block struct {
+		statements int
+		covered    bool
+	}
+	blocks := map[string]*block{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines look like: code-agent/main.go:12.2,14.3 2 1
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "mode:") {
+			continue
+		}
+		if file != "" && filepath.Base(strings.SplitN(fields[0], ":", 2)[0]) != file {
+			continue
+		}
+		statements, err1 := strconv.Atoi(fields[1])
+		count, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		b, ok := blocks[fields[0]]
+		if !ok {
+			b = &block{statements: statements}
+			blocks[fields[0]] = b
+		}
+		b.covered = b.covered || count > 0
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	total, covered := 0, 0
+	for _, b := range blocks {
+		total += b.statements
+		if b.covered {
+			covered += b.statements
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+
+	return 100 * float64(covered) / float64(total), nil
+}
+
+// parseFunctionCoverage extracts not fully covered functions from `go tool cover -func` output
+func parseFunctionCoverage(output, file string) []FunctionCoverage {
+	functions := []FunctionCoverage{}
+
+	for _, line := range strings.Split(output, "\n") {
+		// Lines look like: code-agent/main.go:42:	estimateTokens		0.0%
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] == "total:" {
+			continue
+		}
+		location := strings.Split(strings.TrimSuffix(fields[0], ":"), ":")
+		if len(location) != 2 {
+			continue
+		}
+		if file != "" && filepath.Base(location[0]) != file {
+			continue
+		}
+		lineNumber, err1 := strconv.Atoi(location[1])
+		percent, err2 := strconv.ParseFloat(strings.TrimSuffix(fields[2], "%"), 64)
+		if err1 != nil || err2 != nil || percent >= 100 {
+			continue
+		}
+
+		functions = append(functions, FunctionCoverage{
+			File:    location[0],
+			Line:    lineNumber,
+			Name:    fields[1],
+			Percent: percent,
+		})
+	}
+
+	sort.SliceStable(functions, func(i, j int) bool {
+		return functions[i].Percent < functions[j].Percent
+	})
+
+	return functions
+}
+
+// formatFunctionCoverage renders the least covered functions as a bullet list
+func formatFunctionCoverage(functions []FunctionCoverage) string {
+	var list strings.Builder
+	for i, function := range functions {
+		if i == maxListedFunctions {
+			fmt.Fprintf(&list, "- ... and %d more\n", len(functions)-i)
+			break
+		}
+		fmt.Fprintf(&list, "- %s (%s:%d): %.1f%%\n", function.Name, function.File, function.Line, function.Percent)
+	}
+	return list.String()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// =============================================================================
+// COLOR THEMES
+// =============================================================================
+
+// Roles of colored text. A theme gives each role the parameters of an SGR escape, such
+// as "92" or "1;95".
+const (
+	roleUser      = "user"      // The You: label
+	roleAssistant = "assistant" // The Claude: label
+	roleTool      = "tool"      // Tool calls and their progress
+	roleInfo      = "info"      // Context, attachments and usage
+	roleStatus    = "status"    // Progress of subcommands such as map and test-gen
+	roleNotice    = "notice"    // Things that want the user's attention, such as suggestions
+	roleWarning   = "warning"   // Warnings
+	roleError     = "error"     // Errors and failed tool calls
+	roleSuccess   = "success"   // Tool calls that succeeded
+	roleThinking  = "thinking"  // Claude's extended thinking
+	roleHeading   = "heading"   // Markdown headings
+	roleCode      = "code"      // Inline code and code blocks in no highlighted language
+	roleAdded     = "added"     // Added lines of diffs
+	roleRemoved   = "removed"   // Removed lines of diffs
+	roleHunk      = "hunk"      // Hunk headers of diffs
+)
+
+// colorTheme maps roles to SGR parameters; a role that is missing or "" is not colored
+type colorTheme map[string]string
+
+// defaultTheme is picked from the terminal's background, unless THEME is set
+const defaultTheme = "auto"
+
+// colorThemes are the THEME choices
+var colorThemes = map[string]colorTheme{
+	"dark": {
+		roleUser: "94", roleAssistant: "93", roleTool: "92", roleInfo: "96", roleStatus: "94", roleNotice: "95",
+		roleWarning: "91", roleError: "91", roleSuccess: "92", roleThinking: "2", roleHeading: "1;95", roleCode: "36",
+		roleAdded: "92", roleRemoved: "91", roleHunk: "96",
+	},
+	"light": {
+		roleUser: "34", roleAssistant: "35", roleTool: "32", roleInfo: "36", roleStatus: "34", roleNotice: "35",
+		roleWarning: "31", roleError: "31", roleSuccess: "32", roleThinking: "2", roleHeading: "1;35", roleCode: "34",
+		roleAdded: "32", roleRemoved: "31", roleHunk: "36",
+	},
+	"high-contrast": {
+		roleUser: "1;94", roleAssistant: "1;93", roleTool: "1;92", roleInfo: "1;96", roleStatus: "1;94", roleNotice: "1;95",
+		roleWarning: "1;91", roleError: "1;97;41", roleSuccess: "1;92", roleThinking: "3", roleHeading: "1;4", roleCode: "1;96",
+		roleAdded: "1;92", roleRemoved: "1;91", roleHunk: "1;96",
+	},
+}
+
+// noColorTheme styles headings in bold only, for NO_COLOR and --no-color
+var noColorTheme = colorTheme{roleHeading: "1"}
+
+// sgrParameters matches what THEME_COLORS may give a role
+var sgrParameters = regexp.MustCompile(`^[0-9]+(;[0-9]+)*$`)
+
+var (
+	themeOnce       sync.Once
+	resolvedTheme   colorTheme
+	themeBackground string // dark or light: the background the theme suits, for CODE_THEME
+)
+
+// colorsEnabled reports whether output is colored: not with --no-color, with NO_COLOR set
+// to anything, or on a dumb terminal
+func colorsEnabled() bool {
+	return !runOptions.NoColor && configValue("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// theme is the colors of this run: the THEME named, or the dark or light one that suits
+// the terminal, with the roles THEME_COLORS overrides
+func theme() colorTheme {
+	themeOnce.Do(func() {
+		if !colorsEnabled() {
+			resolvedTheme = noColorTheme
+			return
+		}
+		name := configValue("THEME")
+		if name == "" || name == defaultTheme {
+			name = "dark"
+			if !terminalIsDark() {
+				name = "light"
+			}
+		}
+		themeBackground = "dark"
+		if name == "light" {
+			themeBackground = "light"
+		}
+		resolvedTheme = maps.Clone(colorThemes[name])
+		overrides, _ := parseThemeColors(configValue("THEME_COLORS"))
+		maps.Copy(resolvedTheme, overrides)
+	})
+	return resolvedTheme
+}
+
+// terminalIsDark guesses whether the terminal has a dark background, from COLORFGBG or by
+// asking the terminal. Dark is assumed when neither tells.
+func terminalIsDark() bool {
+	if fields := strings.Split(os.Getenv("COLORFGBG"), ";"); len(fields) > 1 {
+		if background, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+			return background < 7 || background == 8
+		}
+	}
+	if isTerminal(os.Stdout) {
+		return lipgloss.HasDarkBackground()
+	}
+	return true
+}
+
+// parseThemeColors reads THEME_COLORS, such as "user=1;34,tool=32", into SGR parameters
+// by role
+func parseThemeColors(text string) (colorTheme, error) {
+	colors := colorTheme{}
+	for _, pair := range strings.Split(text, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		role, sgr, ok := strings.Cut(pair, "=")
+		role, sgr = strings.TrimSpace(role), strings.TrimSpace(sgr)
+		if !ok || !slices.Contains(themeRoles(), role) {
+			return nil, fmt.Errorf("THEME_COLORS: %q is not ROLE=COLOR with a role of %s", pair, strings.Join(themeRoles(), ", "))
+		}
+		if sgr != "" && !sgrParameters.MatchString(sgr) {
+			return nil, fmt.Errorf("THEME_COLORS: color %q of %s is not SGR parameters such as 92 or 1;34", sgr, role)
+		}
+		colors[role] = sgr
+	}
+	return colors, nil
+}
+
+// themeRoles lists the roles a theme colors
+func themeRoles() []string {
+	return slices.Sorted(maps.Keys(colorThemes["dark"]))
+}
+
+// themeNames lists the themes for messages
+func themeNames() string {
+	return strings.Join(append(slices.Sorted(maps.Keys(colorThemes)), defaultTheme), ", ")
+}
+
+// checkTheme reports a THEME that names no theme and THEME_COLORS that cannot be read
+func checkTheme() error {
+	if name := configValue("THEME"); name != "" && name != defaultTheme && colorThemes[name] == nil {
+		return fmt.Errorf("unknown THEME %q (choose from %s)", name, themeNames())
+	}
+	_, err := parseThemeColors(configValue("THEME_COLORS"))
+	return err
+}
+
+// style is the escape that starts text of role, or "" when the role is not colored
+func style(role string) string {
+	if sgr := theme()[role]; sgr != "" {
+		return "\u001b[" + sgr + "m"
+	}
+	return ""
+}
+
+// paint colors text for role
+func paint(role, text string) string {
+	if start := style(role); start != "" {
+		return start + text + styleReset
+	}
+	return text
+}
+
+// paintLines colors every line of text on its own, so each line can be shown apart
+func paintLines(role, text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = paint(role, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// colorDiff colors the lines of a unified diff printed to a terminal; diffs written to
+// a file or a pipe are left alone so they still apply
+func colorDiff(diff string) string {
+	if !isTerminal(os.Stdout) {
+		return diff
+	}
+	lines := strings.SplitAfter(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "):
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = paintLine(roleHunk, line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = paintLine(roleAdded, line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = paintLine(roleRemoved, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// paintLine colors a line, keeping its newline after the reset
+func paintLine(role, line string) string {
+	text, found := strings.CutSuffix(line, "\n")
+	if found {
+		return paint(role, text) + "\n"
+	}
+	return paint(role, text)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// =============================================================================
+// TODO TOOL
+// =============================================================================
+
+// todoName is the name of the tool Claude plans its work with
+const todoName = "todo"
+
+// Todo statuses
+const (
+	todoPending    = "pending"
+	todoInProgress = "in_progress"
+	todoDone       = "done"
+)
+
+// TodoItem is one task of the current job
+type TodoItem struct {
+	Text   string `json:"text" jsonschema_description:"What the task is, in a short sentence."`
+	Status string `json:"status" jsonschema:"enum=pending,enum=in_progress,enum=done" jsonschema_description:"pending, in_progress (one task at a time) or done."`
+}
+
+// TodoInput defines the input structure for the todo tool
+type TodoInput struct {
+	Todos []TodoItem `json:"todos" jsonschema_description:"The whole task list, in order. Send every task each time, with its current status."`
+}
+
+// TodoInputSchema - Auto-generated JSON schema for TodoInput
+var TodoInputSchema = GenerateSchema[TodoInput]()
+
+// TodoList holds the task list Claude keeps for the current job, so users can follow its plan
+type TodoList struct {
+	mu       sync.Mutex
+	items    []TodoItem
+	onChange func(rendered string, items []TodoItem) // Reports each new version of the list
+}
+
+// NewTodoList creates an empty task list
+func NewTodoList() *TodoList {
+	return &TodoList{}
+}
+
+// clear empties the list when the conversation starts over
+func (l *TodoList) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items = nil
+}
+
+// Definition returns the todo tool bound to this list
+func (l *TodoList) Definition() ToolDefinition {
+	return ToolDefinition{
+		Name:        todoName,
+		Description: "Keep a task list for multi-step jobs. Write the plan before starting, mark a task in_progress when you start it and done as soon as it is finished, and add tasks you discover. Each call replaces the whole list. Skip it for trivial one-step requests.",
+		InputSchema: TodoInputSchema,
+		Function: func(input json.RawMessage) (string, error) {
+			todoInput := TodoInput{}
+			err := json.Unmarshal(input, &todoInput)
+			if err != nil {
+				return "", fmt.Errorf("invalid input format: %w", err)
+			}
+			for i, item := range todoInput.Todos {
+				if strings.TrimSpace(item.Text) == "" {
+					return "", fmt.Errorf("task %d has no text", i+1)
+				}
+				if item.Status != todoPending && item.Status != todoInProgress && item.Status != todoDone {
+					return "", fmt.Errorf("task %d has status %q; use pending, in_progress or done", i+1, item.Status)
+				}
+			}
+
+			l.mu.Lock()
+			l.items = todoInput.Todos
+			l.mu.Unlock()
+
+			rendered := l.Render()
+			if l.onChange != nil {
+				l.onChange(rendered, todoInput.Todos)
+			}
+			return "Task list updated:\n" + rendered, nil
+		},
+	}
+}
+
+// Items returns a copy of the current list
+func (l *TodoList) Items() []TodoItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]TodoItem(nil), l.items...)
+}
+
+// Render shows the list as a checklist, one task per line
+func (l *TodoList) Render() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var list strings.Builder
+	for _, item := range l.items {
+		box := "[ ]"
+		switch item.Status {
+		case todoInProgress:
+			box = "[~]"
+		case todoDone:
+			box = "[x]"
+		}
+		fmt.Fprintf(&list, "%s %s\n", box, item.Text)
+	}
+	return list.String()
+}
+
+// reminder reminds Claude of unfinished tasks in the periodic system reminder
+func (l *TodoList) reminder() string {
+	l.mu.Lock()
+	open := 0
+	for _, item := range l.items {
+		if item.Status != todoDone {
+			open++
+		}
+	}
+	l.mu.Unlock()
+
+	if open == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Your task list has %d unfinished task(s); keep it up to date with the %s tool:\n%s", open, todoName, strings.TrimSuffix(l.Render(), "\n"))
+}
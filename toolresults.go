@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// OVERSIZED TOOL RESULTS
+// =============================================================================
+
+// defaultToolResultMaxTokens is the largest tool result inserted into the conversation as-is
+const defaultToolResultMaxTokens = 8000
+
+// getToolOutputName is the name of the tool that reads back stored raw outputs
+const getToolOutputName = "get_tool_output"
+
+// summarizerPrompt instructs the cheap model how to condense a tool result
+const summarizerPrompt = `You condense tool output for a coding agent that cannot see the original.
+Keep error messages, failing test names, file paths, line numbers and identifiers verbatim.
+Drop repetitive or boilerplate lines. Reply with the summary only.`
+
+// ToolOutputStore keeps the raw output of oversized tool results so Claude can retrieve them later
+type ToolOutputStore struct {
+	mu      sync.Mutex
+	outputs map[string]string
+	next    int
+}
+
+// NewToolOutputStore creates an empty store
+func NewToolOutputStore() *ToolOutputStore {
+	return &ToolOutputStore{outputs: map[string]string{}}
+}
+
+// Put stores a raw output and returns its handle
+func (s *ToolOutputStore) Put(output string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next++
+	handle := fmt.Sprintf("out-%d", s.next)
+	s.outputs[handle] = output
+	return handle
+}
+
+// Get returns the raw output stored under handle
+func (s *ToolOutputStore) Get(handle string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	output, ok := s.outputs[handle]
+	return output, ok
+}
+
+// GetToolOutputInput defines the input structure for the get_tool_output tool
+type GetToolOutputInput struct {
+	Handle string `json:"handle" jsonschema_description:"The handle of a stored tool output, e.g. out-1."`
+	Offset int    `json:"offset,omitempty" jsonschema_description:"Optional byte offset to start reading from. Defaults to 0."`
+	Limit  int    `json:"limit,omitempty" jsonschema_description:"Optional maximum number of bytes to return. Defaults to the size limit for tool results."`
+}
+
+// GetToolOutputInputSchema - Auto-generated JSON schema for GetToolOutputInput
+var GetToolOutputInputSchema = GenerateSchema[GetToolOutputInput]()
+
+// Definition returns the get_tool_output tool bound to this store
+func (s *ToolOutputStore) Definition() ToolDefinition {
+	return ToolDefinition{
+		Name:        getToolOutputName,
+		Description: "Read the raw output of an earlier tool call that was too large to include in full. Use the handle mentioned in the shortened result, and page through it with offset and limit.",
+		InputSchema: GetToolOutputInputSchema,
+		Function: func(input json.RawMessage) (string, error) {
+			getInput := GetToolOutputInput{}
+			err := json.Unmarshal(input, &getInput)
+			if err != nil {
+				return "", fmt.Errorf("invalid input format: %w", err)
+			}
+
+			output, ok := s.Get(getInput.Handle)
+			if !ok {
+				return "", fmt.Errorf("no stored output with handle %s", getInput.Handle)
+			}
+			if getInput.Offset < 0 || getInput.Offset > len(output) {
+				return "", fmt.Errorf("offset %d is out of range (output is %d bytes)", getInput.Offset, len(output))
+			}
+
+			limit := getInput.Limit
+			if limit <= 0 {
+				limit = toolResultMaxTokens() * 4
+			}
+			end := min(getInput.Offset+limit, len(output))
+
+			return fmt.Sprintf("[bytes %d-%d of %d]\n%s", getInput.Offset, end, len(output), output[getInput.Offset:end]), nil
+		},
+	}
+}
+
+// toolResultMaxTokens returns the configured size limit for tool results
+func toolResultMaxTokens() int {
+	return configInt("TOOL_RESULT_MAX_TOKENS", defaultToolResultMaxTokens)
+}
+
+// shrinkToolResult summarizes or truncates a tool result that exceeds the size limit,
+// storing the raw output under a handle for later retrieval
+func (a *Agent) shrinkToolResult(ctx context.Context, name, output string) string {
+	maxTokens := toolResultMaxTokens()
+	tokens := estimateTokens(output)
+	if name == getToolOutputName || tokens <= maxTokens {
+		return output
+	}
+
+	handle := a.toolOutputs.Put(output)
+	footer := fmt.Sprintf("Full output (~%d tokens) stored as handle %s; call %s to read it.", tokens, handle, getToolOutputName)
+
+	if configValue("SUMMARIZE_TOOL_RESULTS") == "true" {
+		summary, err := a.summarizeToolResult(ctx, name, output)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "%s: summarized %s output (~%d tokens, handle %s)\n", paint(roleTool, "tool"), name, tokens, handle)
+			return fmt.Sprintf("[summary of oversized %s output]\n%s\n\n%s", name, summary, footer)
+		}
+		fmt.Fprintf(os.Stderr, "%s: summarizing %s output failed, truncating instead: %s\n", paint(roleTool, "tool"), name, err.Error())
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: truncated %s output (~%d tokens, handle %s)\n", paint(roleTool, "tool"), name, tokens, handle)
+	return truncateToTokens(output, maxTokens) + "\n" + footer
+}
+
+// summarizeToolResult condenses a tool output with the cheap summarizer model
+func (a *Agent) summarizeToolResult(ctx context.Context, name, output string) (string, error) {
+	model := configValue("SUMMARIZER_MODEL")
+	if model == "" {
+		model = string(anthropic.ModelClaude3_5HaikuLatest)
+	}
+
+	// Even the summarizer has a context window; give it as much as safely fits
+	input := truncateToTokens(output, contextWindowTokens/2)
+
+	message, err := a.client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     anthropic.Model(model),
+		MaxTokens: int64(1024),
+		System:    []anthropic.TextBlockParam{{Text: summarizerPrompt}},
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(fmt.Sprintf("Output of the %s tool:\n\n%s", name, input))),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var summary strings.Builder
+	for _, content := range message.Content {
+		if content.Type == "text" {
+			summary.WriteString(content.Text)
+		}
+	}
+	if summary.Len() == 0 {
+		return "", fmt.Errorf("summarizer returned no text")
+	}
+
+	return summary.String(), nil
+}
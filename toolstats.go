@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// TOOL USAGE ANALYTICS
+// =============================================================================
+
+// Thresholds of the tool recommendations
+const (
+	toolStatsMinCalls      = 5    // Calls of a tool before its failure rate is judged
+	toolStatsMinChecked    = 10   // Checked results before the reference rate is judged
+	toolStatsFailingRate   = 0.5  // Share of failed calls that suggests a misconfigured tool
+	toolStatsUnusedRate    = 0.2  // Share of referenced results below which a tool is noise
+	toolStatsLargeResult   = 4000 // Average result in tokens that crowds the context window
+	toolStatsMinLineLength = 20   // Shortest result line that counts as quoted
+)
+
+// distinctiveTerm matches words of a tool result that Claude would only repeat after
+// reading it: identifiers with digits, underscores, dots or inner capitals, and paths
+var distinctiveTerm = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9]*[0-9_./A-Z][A-Za-z0-9_./-]*`)
+
+// ToolStats are the counts of one tool across runs
+type ToolStats struct {
+	Calls       int       `json:"calls"`
+	Failures    int       `json:"failures"`
+	ResultBytes int64     `json:"result_bytes"` // Size of the successful results, after shortening
+	Checked     int       `json:"checked"`      // Results followed by a response that could refer to them
+	Referenced  int       `json:"referenced"`   // Results the next response quoted or used
+	LastUsed    time.Time `json:"last_used"`
+}
+
+// add adds other's counts
+func (s *ToolStats) add(other *ToolStats) {
+	s.Calls += other.Calls
+	s.Failures += other.Failures
+	s.ResultBytes += other.ResultBytes
+	s.Checked += other.Checked
+	s.Referenced += other.Referenced
+	if other.LastUsed.After(s.LastUsed) {
+		s.LastUsed = other.LastUsed
+	}
+}
+
+// averageTokens is the estimated size of a successful result
+func (s *ToolStats) averageTokens() int {
+	if succeeded := s.Calls - s.Failures; succeeded > 0 {
+		return int(s.ResultBytes/int64(succeeded)+3) / 4
+	}
+	return 0
+}
+
+// advice names what looks wrong with the tool, or returns "" when nothing does
+func (s *ToolStats) advice() string {
+	switch {
+	case s.Calls >= toolStatsMinCalls && float64(s.Failures)/float64(s.Calls) >= toolStatsFailingRate:
+		return fmt.Sprintf("fails %d of %d calls; check its configuration", s.Failures, s.Calls)
+	case s.Checked >= toolStatsMinChecked && float64(s.Referenced)/float64(s.Checked) < toolStatsUnusedRate && s.averageTokens() >= toolStatsLargeResult:
+		return fmt.Sprintf("results average ~%d tokens and are rarely used; narrow its output or remove it", s.averageTokens())
+	case s.Checked >= toolStatsMinChecked && float64(s.Referenced)/float64(s.Checked) < toolStatsUnusedRate:
+		return "results are rarely used; its description may invite calls that do not help"
+	case s.Calls >= toolStatsMinCalls && s.averageTokens() >= toolStatsLargeResult:
+		return fmt.Sprintf("results average ~%d tokens; narrow its output to save context", s.averageTokens())
+	}
+	return ""
+}
+
+// toolStatsFile is the saved counts of every tool
+type toolStatsFile struct {
+	Since time.Time             `json:"since"`
+	Tools map[string]*ToolStats `json:"tools"`
+}
+
+// toolStatsPath is where the counts are kept
+func toolStatsPath() string {
+	return filepath.Join(stateDir(), "tool-stats.json")
+}
+
+// loadToolStats reads the saved counts, which are empty at first
+func loadToolStats() (*toolStatsFile, error) {
+	stats := &toolStatsFile{Tools: map[string]*ToolStats{}}
+	data, err := os.ReadFile(toolStatsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", toolStatsPath(), err)
+	}
+	if stats.Tools == nil {
+		stats.Tools = map[string]*ToolStats{}
+	}
+	return stats, nil
+}
+
+// save writes the counts, replacing the file in one step
+func (f *toolStatsFile) save() error {
+	path := toolStatsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(f, "", "  ")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// toolResultTrace is what is needed of a result to tell whether Claude used it
+type toolResultTrace struct {
+	tool  string
+	terms []string // Distinctive words of the result
+	lines []string // Long lines of the result
+}
+
+// toolStatsRecorder counts the run's tool calls and adds them to the saved counts when
+// the run ends. Whether a result was used is judged from the response that follows it:
+// it counts as referenced when the response's text or tool input repeats a distinctive
+// word or a whole line of it.
+type toolStatsRecorder struct {
+	mu      sync.Mutex
+	saved   map[string]*ToolStats // Counts of earlier runs, for warnings
+	run     map[string]*ToolStats // Counts of this run
+	pending map[*Agent][]toolResultTrace
+	warned  map[string]bool
+}
+
+// startToolStats loads the saved counts and starts counting the run
+func startToolStats() *toolStatsRecorder {
+	saved := map[string]*ToolStats{}
+	if stats, err := loadToolStats(); err == nil {
+		saved = stats.Tools
+	}
+	return &toolStatsRecorder{saved: saved, run: map[string]*ToolStats{}, pending: map[*Agent][]toolResultTrace{}, warned: map[string]bool{}}
+}
+
+// toolCall counts a call and its result, and warns once per run about a tool that earlier
+// runs found failing or noisy
+func (r *toolStatsRecorder) toolCall(agent *Agent, name, result string, failed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if saved := r.saved[name]; saved != nil && !r.warned[name] {
+		r.warned[name] = true
+		if advice := saved.advice(); advice != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s %s (see go-agent tools stats)\n", paint(roleWarning, "warning"), name, advice)
+		}
+	}
+
+	stats := r.run[name]
+	if stats == nil {
+		stats = &ToolStats{}
+		r.run[name] = stats
+	}
+	stats.Calls++
+	stats.LastUsed = time.Now().UTC()
+	if failed {
+		stats.Failures++
+		return
+	}
+	stats.ResultBytes += int64(len(result))
+	if trace := traceResult(name, result); len(trace.terms) > 0 || len(trace.lines) > 0 {
+		r.pending[agent] = append(r.pending[agent], trace)
+	}
+}
+
+// traceResult picks out what would show that a result was used
+func traceResult(name, result string) toolResultTrace {
+	trace := toolResultTrace{tool: name}
+	seen := map[string]bool{}
+	for _, term := range distinctiveTerm.FindAllString(result, -1) {
+		term = strings.TrimRight(term, "./-")
+		if len(term) < 6 || seen[term] {
+			continue
+		}
+		seen[term] = true
+		trace.terms = append(trace.terms, term)
+	}
+	for _, line := range strings.Split(result, "\n") {
+		if line = strings.TrimSpace(line); len(line) >= toolStatsMinLineLength {
+			trace.lines = append(trace.lines, line)
+		}
+	}
+	return trace
+}
+
+// response checks which of the agent's pending results the response refers to
+func (r *toolStatsRecorder) response(agent *Agent, message *anthropic.Message) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	traces := r.pending[agent]
+	if len(traces) == 0 {
+		return
+	}
+	delete(r.pending, agent)
+
+	var said strings.Builder
+	for _, block := range message.Content {
+		switch block.Type {
+		case "text":
+			said.WriteString(block.Text + "\n")
+		case "tool_use":
+			// Inputs are compared unescaped, so quoted lines match
+			var input any
+			if json.Unmarshal(block.Input, &input) == nil {
+				said.WriteString(strings.Join(jsonStrings(input), "\n") + "\n")
+			}
+		}
+	}
+	text := said.String()
+	for _, trace := range traces {
+		stats := r.run[trace.tool]
+		if stats == nil {
+			continue // Counted by an earlier flush
+		}
+		stats.Checked++
+		if slices.ContainsFunc(trace.terms, func(term string) bool { return strings.Contains(text, term) }) ||
+			slices.ContainsFunc(trace.lines, func(line string) bool { return strings.Contains(text, line) }) {
+			stats.Referenced++
+		}
+	}
+}
+
+// jsonStrings collects the strings of a decoded JSON value
+func jsonStrings(value any) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		strs := []string{}
+		for _, item := range value {
+			strs = append(strs, jsonStrings(item)...)
+		}
+		return strs
+	case map[string]any:
+		strs := []string{}
+		for _, item := range value {
+			strs = append(strs, jsonStrings(item)...)
+		}
+		return strs
+	}
+	return nil
+}
+
+// flush adds the run's counts to the saved ones
+func (r *toolStatsRecorder) flush() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.run) == 0 {
+		return
+	}
+	stats, err := loadToolStats()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to save tool stats: %s\n", paint(roleWarning, "warning"), err)
+		return
+	}
+	if stats.Since.IsZero() {
+		stats.Since = time.Now().UTC()
+	}
+	for name, run := range r.run {
+		if stats.Tools[name] == nil {
+			stats.Tools[name] = &ToolStats{}
+		}
+		stats.Tools[name].add(run)
+	}
+	if err := stats.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to save tool stats: %s\n", paint(roleWarning, "warning"), err)
+		return
+	}
+	r.run = map[string]*ToolStats{}
+}
+
+// runToolsCommand implements `go-agent tools stats|reset`
+func runToolsCommand(args []string) error {
+	usage := usageErrorf("usage: go-agent tools stats | reset")
+	if len(args) != 1 {
+		return usage
+	}
+
+	switch args[0] {
+	case "stats":
+		stats, err := loadToolStats()
+		if err != nil {
+			return err
+		}
+		if len(stats.Tools) == 0 {
+			fmt.Println("No tool calls recorded yet.")
+			return nil
+		}
+		names := []string{}
+		for name := range stats.Tools {
+			names = append(names, name)
+		}
+		// Most used first
+		slices.SortFunc(names, func(a, b string) int {
+			if diff := stats.Tools[b].Calls - stats.Tools[a].Calls; diff != 0 {
+				return diff
+			}
+			return strings.Compare(a, b)
+		})
+
+		fmt.Printf("Tool calls since %s:\n", stats.Since.Local().Format(time.DateOnly))
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "TOOL\tCALLS\tFAILED\tAVG RESULT\tREFERENCED\tLAST USED")
+		advice := []string{}
+		for _, name := range names {
+			tool := stats.Tools[name]
+			referenced := "-"
+			if tool.Checked > 0 {
+				referenced = fmt.Sprintf("%d%%", tool.Referenced*100/tool.Checked)
+			}
+			fmt.Fprintf(table, "%s\t%d\t%d%%\t~%d tokens\t%s\t%s\n", name, tool.Calls, tool.Failures*100/max(tool.Calls, 1),
+				tool.averageTokens(), referenced, tool.LastUsed.Local().Format(time.DateOnly))
+			if text := tool.advice(); text != "" {
+				advice = append(advice, fmt.Sprintf("  %s: %s", name, text))
+			}
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+		if len(advice) > 0 {
+			fmt.Printf("\nRecommendations:\n%s\n", strings.Join(advice, "\n"))
+		}
+		return nil
+	case "reset":
+		if err := os.Remove(toolStatsPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		fmt.Println("Tool stats cleared")
+		return nil
+	}
+	return usage
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// CONVERSATION TRANSCRIPTS
+// =============================================================================
+
+// transcriptVersion is the format of JSON transcripts, raised when it changes
+const transcriptVersion = 1
+
+// conversationTranscript is a JSON transcript: the conversation as sent to the API, with
+// what is needed to tell where it came from
+type conversationTranscript struct {
+	Version   int                      `json:"version"`
+	Exported  time.Time                `json:"exported"`
+	Title     string                   `json:"title"`
+	Session   string                   `json:"session,omitempty"` // Saved session ID, for --resume
+	Model     string                   `json:"model"`
+	Workspace string                   `json:"workspace"`
+	Usage     UsageStats               `json:"usage"`
+	Messages  []anthropic.MessageParam `json:"messages"`
+}
+
+// newTranscript describes the conversation of an agent and its saved session, if any
+func newTranscript(agent *Agent, session *savedSession, conversation []anthropic.MessageParam) conversationTranscript {
+	workspace, _ := os.Getwd()
+	transcript := conversationTranscript{
+		Version:   transcriptVersion,
+		Exported:  time.Now().UTC(),
+		Title:     sessionTitle(conversation),
+		Model:     string(agent.model),
+		Workspace: workspace,
+		Usage:     runOptions.usage.snapshot(),
+		Messages:  conversation,
+	}
+	if session != nil {
+		transcript.Session = session.ID
+		transcript.Usage = session.priorUsage().plus(transcript.Usage)
+	}
+	return transcript
+}
+
+// writeTranscript writes the transcript to path, as JSON when the file name ends in .json
+// and as Markdown otherwise
+func writeTranscript(path string, transcript conversationTranscript) error {
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, _ = json.MarshalIndent(transcript, "", "  ")
+	} else {
+		data = []byte(transcript.markdown())
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// saveTranscript rewrites the --transcript file after a turn. Failures are warnings, like
+// those of saving the session.
+func saveTranscript(agent *Agent, session *savedSession, conversation []anthropic.MessageParam) {
+	if runOptions.Transcript == "" || len(conversation) == 0 {
+		return
+	}
+	if err := writeTranscript(runOptions.Transcript, newTranscript(agent, session, conversation)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to write the transcript: %s\n", paint(roleWarning, "warning"), err)
+	}
+}
+
+// markdown renders the transcript for reading. Tool results and attached files are
+// folded into <details> blocks, so the exchange stays readable where Markdown is rendered.
+func (t conversationTranscript) markdown() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s\n\n", cmp.Or(t.Title, "go-agent conversation"))
+	fmt.Fprintf(&out, "- Exported: %s\n- Model: %s\n- Workspace: %s\n", t.Exported.Format("2006-01-02 15:04 MST"), t.Model, t.Workspace)
+	if t.Session != "" {
+		fmt.Fprintf(&out, "- Session: %s\n", t.Session)
+	}
+	if t.Usage.Calls > 0 {
+		fmt.Fprintf(&out, "- Usage: %s\n", t.Usage)
+	}
+
+	tools := map[string]string{} // Tool names by tool_use ID, to label results
+	speaker := ""
+	heading := func(name string) {
+		if speaker != name {
+			speaker = name
+			fmt.Fprintf(&out, "\n## %s\n", name)
+		}
+	}
+	for _, message := range t.Messages {
+		for _, block := range message.Content {
+			switch {
+			case block.OfToolResult != nil:
+				result := block.OfToolResult
+				label := "Result of " + cmp.Or(tools[result.ToolUseID], "a tool call")
+				if result.IsError.Value {
+					label = "Failed: " + cmp.Or(tools[result.ToolUseID], "a tool call")
+				}
+				texts := []string{}
+				for _, content := range result.Content {
+					switch {
+					case content.OfText != nil:
+						texts = append(texts, content.OfText.Text)
+					case content.OfImage != nil:
+						texts = append(texts, "[image]")
+					}
+				}
+				foldedBlock(&out, label, strings.Join(texts, "\n"))
+			case message.Role == anthropic.MessageParamRoleUser:
+				heading("You")
+				switch name := attachmentName(block); {
+				case block.OfDocument != nil:
+					fmt.Fprintf(&out, "\n*Attached document `%s`*\n", cmp.Or(name, "untitled"))
+				case name != "":
+					foldedBlock(&out, "Attached "+name, block.OfText.Text)
+				case block.OfText != nil:
+					fmt.Fprintf(&out, "\n%s\n", strings.TrimSpace(block.OfText.Text))
+				case block.OfImage != nil:
+					out.WriteString("\n*Attached image*\n")
+				}
+			default:
+				heading("Claude")
+				switch {
+				case block.OfText != nil:
+					fmt.Fprintf(&out, "\n%s\n", strings.TrimSpace(block.OfText.Text))
+				case block.OfThinking != nil:
+					foldedBlock(&out, "Thinking", block.OfThinking.Thinking)
+				case block.OfToolUse != nil:
+					tools[block.OfToolUse.ID] = block.OfToolUse.Name
+					input, _ := json.MarshalIndent(block.OfToolUse.Input, "", "  ")
+					fmt.Fprintf(&out, "\n**Tool call** `%s`\n\n%s\n", block.OfToolUse.Name, fenced(string(input), "json"))
+				}
+			}
+		}
+	}
+	return out.String()
+}
+
+// foldedBlock writes text in a collapsed <details> block, as code
+func foldedBlock(out *strings.Builder, summary, text string) {
+	fmt.Fprintf(out, "\n<details><summary>%s</summary>\n\n%s\n\n</details>\n", html.EscapeString(summary), fenced(text, ""))
+}
+
+// fenced puts text in a fenced code block whose fence is longer than any run of
+// backticks inside it
+func fenced(text, language string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	return fence + language + "\n" + strings.TrimRight(text, "\n") + "\n" + fence
+}
+
+// exportCommand implements /export
+func exportCommand(chat *chatState, args string) error {
+	if len(chat.conversation) == 0 {
+		return fmt.Errorf("there is no conversation to export yet")
+	}
+	path := args
+	if path == "" {
+		path = "go-agent-" + time.Now().Format("20060102-150405") + ".md"
+	}
+	if err := writeTranscript(path, newTranscript(chat.agent, chat.session, chat.conversation)); err != nil {
+		return fmt.Errorf("failed to export the conversation: %w", err)
+	}
+	fmt.Printf("Exported %d messages to %s\n", len(chat.conversation), path)
+	return nil
+}
+
+// importReplayMessages is how many messages of an imported conversation the chat replays
+const importReplayMessages = 6
+
+// loadTranscript reads a JSON transcript written by /export or --transcript, or a
+// conversation written by /save FILE
+func loadTranscript(path string) (conversationTranscript, error) {
+	transcript := conversationTranscript{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return transcript, fmt.Errorf("failed to read the transcript: %w", err)
+	}
+	messages := json.RawMessage(data)
+	if trimmed := strings.TrimSpace(string(data)); !strings.HasPrefix(trimmed, "[") {
+		var header struct {
+			conversationTranscript
+			Messages json.RawMessage `json:"messages"`
+		}
+		if err := json.Unmarshal(data, &header); err != nil {
+			return transcript, fmt.Errorf("%s is not a JSON transcript (Markdown transcripts cannot be imported): %w", path, err)
+		}
+		if header.Version > transcriptVersion {
+			return transcript, fmt.Errorf("%s was written by a newer go-agent (transcript version %d)", path, header.Version)
+		}
+		transcript, messages = header.conversationTranscript, header.Messages
+	}
+	if transcript.Messages, err = decodeMessages(messages); err != nil {
+		return transcript, fmt.Errorf("failed to parse the messages of %s: %w", path, err)
+	}
+	if len(transcript.Messages) == 0 {
+		return transcript, fmt.Errorf("%s has no messages", path)
+	}
+	return transcript, nil
+}
+
+// importSession starts a new session whose conversation is the transcript's, so the
+// chat or -p task continues it
+func importSession(path string) (*savedSession, error) {
+	transcript, err := loadTranscript(path)
+	if err != nil {
+		return nil, err
+	}
+	session := newSavedSession()
+	session.conversation = transcript.Messages
+	session.Title = cmp.Or(transcript.Title, sessionTitle(transcript.Messages))
+	session.addLabels("imported")
+	return session, nil
+}
+
+// replayConversation prints the last messages of a conversation the way the chat showed
+// them, so the user knows where it left off. A last of 0 prints them all.
+func replayConversation(conversation []anthropic.MessageParam, last int) {
+	start := 0
+	if last > 0 {
+		start = max(0, len(conversation)-last)
+	}
+	if start > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d earlier message(s) not shown\n", paint(roleStatus, "import"), start)
+	}
+	for _, message := range conversation[start:] {
+		for _, block := range message.Content {
+			switch {
+			case block.OfText != nil && attachmentName(block) == "" && message.Role == anthropic.MessageParamRoleUser:
+				fmt.Printf("%s: %s\n", paint(roleUser, "You"), strings.TrimSpace(block.OfText.Text))
+			case block.OfText != nil && message.Role == anthropic.MessageParamRoleAssistant:
+				printAnswer(strings.TrimSpace(block.OfText.Text))
+			case block.OfToolUse != nil:
+				input, _ := json.Marshal(block.OfToolUse.Input)
+				fmt.Fprintf(os.Stderr, "%s: %s(%s)\n", paint(roleTool, "tool"), block.OfToolUse.Name, input)
+			case attachmentName(block) != "":
+				fmt.Fprintf(os.Stderr, "%s: %s\n", paint(roleInfo, "context"), attachmentName(block))
+			case block.OfImage != nil:
+				fmt.Fprintf(os.Stderr, "%s: image\n", paint(roleInfo, "context"))
+			}
+		}
+	}
+}
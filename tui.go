@@ -0,0 +1,509 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/term"
+)
+
+// =============================================================================
+// FULL-SCREEN CHAT
+// =============================================================================
+
+// Layout and limits of the full-screen chat
+const (
+	defaultTUIScrollback = 10000                  // TUI_SCROLLBACK: transcript lines kept
+	tuiSideWidth         = 32                     // Width of the activity pane
+	tuiRecentTools       = 8                      // Finished tool calls listed in the activity pane
+	tuiTick              = 250 * time.Millisecond // How often timers and usage are redrawn
+)
+
+// agentTUI is the full-screen chat of --tui: a scrollable transcript, an input line and a
+// pane with the running tools and the session's token usage. Everything the agent prints
+// goes through a pipe into the transcript, so the rest of go-agent writes to stdout as it
+// always does. The transcript understands the cursor movement that streamed markdown
+// uses to redraw a line, counted in lines as written rather than as wrapped.
+type agentTUI struct {
+	program        *tea.Program
+	stdout, stderr *os.File // The terminal, from before the chat took over the output
+	pipe           *os.File // Write end of the transcript pipe, which is stdout meanwhile
+	inputs         chan string
+	reading        atomic.Bool   // Whether the agent is waiting for a line, rather than running a turn
+	done           chan struct{} // Closed when the program has exited
+
+	mu        sync.Mutex
+	lines     []string // The transcript
+	row       int      // Line being written
+	carriage  bool     // Whether a carriage return restarts the current line
+	escape    string   // Start of an escape sequence split across writes
+	label     string   // Prompt of the input line
+	status    string
+	tools     []*tuiTool
+	history   *lineEditor // Keeps the input history, shared with the plain chat
+	closeOnce sync.Once
+}
+
+// tuiTool is a tool call shown in the activity pane
+type tuiTool struct {
+	id      string
+	name    string
+	started time.Time
+	elapsed time.Duration // Set once the call is over
+	failed  bool
+}
+
+// tuiRefresh asks the program to redraw
+type tuiRefresh struct{}
+
+// tuiTickMsg redraws timers and usage
+type tuiTickMsg struct{}
+
+// openTUI takes over the terminal and the agent's output
+func openTUI() (*agentTUI, error) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return nil, fmt.Errorf("--tui needs a terminal")
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	t := &agentTUI{
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+		pipe:    writer,
+		inputs:  make(chan string),
+		done:    make(chan struct{}),
+		lines:   []string{""},
+		label:   paint(roleUser, "You") + ": ",
+		status:  "waiting for you",
+		history: &lineEditor{},
+	}
+	if size := configInt("HISTORY_SIZE", defaultHistorySize); size > 0 {
+		t.history.path = historyPath()
+		t.history.history = loadHistory(t.history.path, size)
+	}
+	// Ctrl-C is handed to the interrupt handler as a signal, so Bubble Tea must not take it
+	t.program = tea.NewProgram(newTUIModel(t), tea.WithAltScreen(), tea.WithMouseCellMotion(),
+		tea.WithOutput(t.stdout), tea.WithInput(os.Stdin), tea.WithoutSignalHandler())
+
+	go func() {
+		defer close(t.done)
+		t.program.Run()
+		t.closeInput()
+	}()
+	go t.follow(reader)
+	os.Stdout, os.Stderr = writer, writer
+	return t, nil
+}
+
+// follow copies the agent's output into the transcript
+func (t *agentTUI) follow(reader *os.File) {
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			t.write(string(buffer[:n]))
+			go t.program.Send(tuiRefresh{})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write adds output to the transcript. Colors are kept; carriage returns, cursor-up and
+// erase sequences move within and clear the transcript's lines; other escapes are dropped.
+func (t *agentTUI) write(text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	text = t.escape + text
+	t.escape = ""
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '\r':
+			t.carriage = true
+		case '\n':
+			t.carriage = false
+			t.row++
+			if t.row == len(t.lines) {
+				t.lines = append(t.lines, "")
+			}
+		case '\u001b':
+			end := i + 1
+			if end < len(text) && text[end] == '[' {
+				end++
+				for end < len(text) && (text[end] < '@' || text[end] > '~') {
+					end++
+				}
+			}
+			if end >= len(text) {
+				t.escape = text[i:] // The rest arrives with the next write
+				return
+			}
+			t.control(text[i : end+1])
+			i = end
+		default:
+			if t.carriage {
+				t.lines[t.row], t.carriage = "", false
+			}
+			end := i
+			for end < len(text) && text[end] != '\r' && text[end] != '\n' && text[end] != '\u001b' {
+				end++
+			}
+			t.lines[t.row] += text[i:end]
+			i = end - 1
+		}
+	}
+	if limit := configInt("TUI_SCROLLBACK", defaultTUIScrollback); len(t.lines) > limit {
+		drop := len(t.lines) - limit
+		t.lines = t.lines[drop:]
+		t.row = max(t.row-drop, 0)
+	}
+}
+
+// control applies an escape sequence to the transcript
+func (t *agentTUI) control(sequence string) {
+	if len(sequence) < 3 || sequence[1] != '[' {
+		return
+	}
+	count, _ := strconv.Atoi(sequence[2 : len(sequence)-1])
+	switch sequence[len(sequence)-1] {
+	case 'm':
+		if t.carriage {
+			t.lines[t.row], t.carriage = "", false
+		}
+		t.lines[t.row] += sequence
+	case 'A':
+		t.row = max(t.row-max(count, 1), 0)
+	case 'J':
+		t.lines = t.lines[:t.row+1]
+		if t.carriage {
+			t.lines[t.row] = ""
+		}
+	case 'K':
+		if t.carriage || count == 2 {
+			t.lines[t.row] = ""
+		}
+	}
+}
+
+// Write follows the session's events to show the tools and status
+func (t *agentTUI) Write(line []byte) (int, error) {
+	var event Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		return len(line), nil
+	}
+	t.mu.Lock()
+	switch event.Type {
+	case eventUserMessage:
+		t.status = "thinking"
+	case eventToolUse:
+		t.status = "running " + event.Tool
+		t.tools = append(t.tools, &tuiTool{id: event.ToolUseID, name: event.Tool, started: time.Now()})
+	case eventToolResult:
+		for _, tool := range t.tools {
+			if tool.id == event.ToolUseID && tool.elapsed == 0 {
+				tool.elapsed, tool.failed = max(time.Since(tool.started), time.Millisecond), event.IsError
+			}
+		}
+		t.status = "thinking"
+	case eventInference:
+		if event.StopReason != string(anthropic.StopReasonToolUse) {
+			t.status = "waiting for you"
+		}
+	case eventStatus:
+		t.status = event.Text
+	case eventOutcome:
+		t.status = event.Outcome
+	}
+	t.mu.Unlock()
+	go t.program.Send(tuiRefresh{})
+	return len(line), nil
+}
+
+// setPrompt shows text as the prompt of the input line
+func (t *agentTUI) setPrompt(text string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.label = strings.TrimLeft(text, "\n")
+	t.mu.Unlock()
+	go t.program.Send(tuiRefresh{})
+}
+
+// readLine returns the next line entered, or false once the chat is over
+func (t *agentTUI) readLine() (string, bool) {
+	t.reading.Store(true)
+	defer t.reading.Store(false)
+	line, ok := <-t.inputs
+	return line, ok
+}
+
+// echo copies a line into the transcript after the prompt
+func (t *agentTUI) echo(line string) {
+	t.mu.Lock()
+	label := t.label
+	t.mu.Unlock()
+	fmt.Fprintf(t.pipe, "%s%s\n", label, line)
+}
+
+// submit hands an entered line to the agent, copying it into the transcript. A line
+// entered while a turn runs, rather than to answer a question, is queued for after it.
+func (t *agentTUI) submit(line string) {
+	if runOptions.interrupts.inTurn() && !t.reading.Load() {
+		queueMessage(line)
+		return
+	}
+	t.echo(line)
+	select {
+	case t.inputs <- line:
+	case <-t.done:
+	}
+}
+
+// interrupt treats Ctrl-C as the interrupt it is at the plain prompt
+func (t *agentTUI) interrupt() {
+	process, err := os.FindProcess(os.Getpid())
+	if err == nil && process.Signal(os.Interrupt) == nil {
+		return
+	}
+	// Without signals, as on Windows, the handler is called directly
+	if runOptions.interrupts.interrupt() {
+		t.closeInput()
+	}
+}
+
+// closeInput ends the chat's input
+func (t *agentTUI) closeInput() {
+	t.closeOnce.Do(func() { close(t.inputs) })
+}
+
+// close gives the terminal back and prints the end of the transcript, so the last answer
+// stays visible after the chat
+func (t *agentTUI) close() {
+	if t == nil {
+		return
+	}
+	t.program.Quit()
+	<-t.done
+	os.Stdout, os.Stderr = t.stdout, t.stderr
+	t.pipe.Close()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, height, err := term.GetSize(int(t.stdout.Fd()))
+	if err != nil {
+		height = 24
+	}
+	tail := t.lines[max(len(t.lines)-height+1, 0):]
+	fmt.Fprintln(t.stdout, strings.TrimRight(strings.Join(tail, "\n"), "\n"))
+}
+
+// tuiModel draws the chat
+type tuiModel struct {
+	tui      *agentTUI
+	viewport viewport.Model
+	input    textinput.Model
+	width    int
+	height   int
+	index    int    // Position in the input history; len(history) is the line being written
+	draft    string // The line being written, kept while browsing the history
+}
+
+// newTUIModel creates the model of the chat
+func newTUIModel(t *agentTUI) *tuiModel {
+	input := textinput.New()
+	input.Prompt = ""
+	input.Focus()
+	return &tuiModel{tui: t, viewport: viewport.New(80, 20), input: input, index: len(t.history.history)}
+}
+
+// Init starts the redraw timer
+func (m *tuiModel) Init() tea.Cmd {
+	return tea.Batch(textinput.Blink, tuiTickCmd())
+}
+
+// tuiTickCmd schedules the next timer redraw
+func tuiTickCmd() tea.Cmd {
+	return tea.Tick(tuiTick, func(time.Time) tea.Msg { return tuiTickMsg{} })
+}
+
+// Update handles keys, the mouse, resizes and redraws
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.viewport.Width = max(m.width-tuiSideWidth-1, 20)
+		m.viewport.Height = max(m.height-3, 3)
+		m.input.Width = m.width
+	case tuiTickMsg:
+		m.refresh()
+		return m, tuiTickCmd()
+	case tuiRefresh:
+		m.refresh()
+		return m, nil
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return m, cmd
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			go m.tui.interrupt()
+			return m, nil
+		case "ctrl+d":
+			if m.input.Value() == "" {
+				m.tui.closeInput()
+				return m, nil
+			}
+		case "esc":
+			if runOptions.interrupts.inTurn() {
+				line := m.input.Value()
+				m.input.Reset()
+				m.tui.history.remember(line)
+				m.index, m.draft = len(m.tui.history.history), ""
+				go steerTurn(strings.TrimSpace(line))
+				return m, nil
+			}
+		case "enter":
+			line := m.input.Value()
+			m.input.Reset()
+			m.tui.history.remember(line)
+			m.index, m.draft = len(m.tui.history.history), ""
+			go m.tui.submit(line)
+			m.viewport.GotoBottom()
+			return m, nil
+		case "up", "down":
+			m.browse(msg.String() == "up")
+			return m, nil
+		case "pgup", "pgdown", "ctrl+u", "ctrl+home", "ctrl+end":
+			switch msg.String() {
+			case "ctrl+home":
+				m.viewport.GotoTop()
+			case "ctrl+end":
+				m.viewport.GotoBottom()
+			default:
+				m.viewport, _ = m.viewport.Update(msg)
+			}
+			return m, nil
+		}
+	}
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+// browse moves through the input history
+func (m *tuiModel) browse(older bool) {
+	history := m.tui.history.history
+	if m.index == len(history) {
+		m.draft = m.input.Value()
+	}
+	switch {
+	case older && m.index > 0:
+		m.index--
+	case !older && m.index < len(history):
+		m.index++
+	default:
+		return
+	}
+	if m.index == len(history) {
+		m.input.SetValue(m.draft)
+	} else {
+		m.input.SetValue(history[m.index])
+	}
+	m.input.CursorEnd()
+}
+
+// refresh copies the transcript into the viewport, following the end unless the user
+// scrolled up
+func (m *tuiModel) refresh() {
+	following := m.viewport.AtBottom()
+	m.tui.mu.Lock()
+	content := lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.tui.lines, "\n"))
+	m.tui.mu.Unlock()
+	m.viewport.SetContent(content)
+	if following {
+		m.viewport.GotoBottom()
+	}
+}
+
+// View draws the transcript and activity pane above the input line
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+	side := lipgloss.NewStyle().
+		Width(tuiSideWidth - 1).
+		Height(m.viewport.Height).
+		MaxHeight(m.viewport.Height).
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderLeft(true).
+		PaddingLeft(1).
+		Render(m.activity())
+
+	m.tui.mu.Lock()
+	label := m.tui.label
+	m.tui.mu.Unlock()
+	m.input.Width = max(m.width-lipgloss.Width(label)-1, 10)
+	help := lipgloss.NewStyle().Faint(true).Render("enter send · esc steer · ↑/↓ history · pgup/pgdn scroll · ctrl-c stop · ctrl-d quit")
+	return lipgloss.JoinVertical(lipgloss.Left,
+		lipgloss.JoinHorizontal(lipgloss.Top, m.viewport.View(), side),
+		strings.Repeat("─", m.width),
+		label+m.input.View(),
+		help,
+	)
+}
+
+// activity lists the status, the running and recent tool calls, and the token usage
+func (m *tuiModel) activity() string {
+	bold := lipgloss.NewStyle().Bold(true)
+	var out strings.Builder
+
+	m.tui.mu.Lock()
+	fmt.Fprintf(&out, "%s\n%s\n\n%s\n", bold.Render("Status"), truncateRunes(m.tui.status, tuiSideWidth-3), bold.Render("Tools"))
+	running, finished := []*tuiTool{}, []*tuiTool{}
+	for _, tool := range m.tui.tools {
+		if tool.elapsed == 0 {
+			running = append(running, tool)
+		} else {
+			finished = append(finished, tool)
+		}
+	}
+	finished = finished[max(len(finished)-tuiRecentTools, 0):]
+	for _, tool := range running {
+		fmt.Fprintf(&out, "%s %s %s\n", paint(roleNotice, "▸"), truncateRunes(tool.name, tuiSideWidth-12), time.Since(tool.started).Round(100*time.Millisecond))
+	}
+	for i := len(finished) - 1; i >= 0; i-- {
+		mark := paint(roleSuccess, "✓")
+		if finished[i].failed {
+			mark = paint(roleError, "✗")
+		}
+		fmt.Fprintf(&out, "%s %s %s\n", mark, truncateRunes(finished[i].name, tuiSideWidth-12), finished[i].elapsed.Round(100*time.Millisecond))
+	}
+	if len(m.tui.tools) == 0 {
+		out.WriteString("none yet\n")
+	}
+	m.tui.mu.Unlock()
+
+	usage := runOptions.usage.snapshot()
+	fmt.Fprintf(&out, "\n%s\ncalls   %d\ninput   %d\noutput  %d\n", bold.Render("Usage"), usage.Calls, usage.InputTokens, usage.OutputTokens)
+	if usage.CacheReadTokens > 0 {
+		fmt.Fprintf(&out, "cached  %d\n", usage.CacheReadTokens)
+	}
+	fmt.Fprintf(&out, "cost    %s\n", usage.costString())
+	return out.String()
+}
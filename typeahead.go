@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// TYPING AHEAD
+// =============================================================================
+
+// steeringNote tells Claude that what follows was sent while it worked
+const steeringNote = "[The user sent this correction while you were working. Take it into account before going on.]"
+
+// skippedForCorrection is the result of tool calls not run because a correction came first
+const skippedForCorrection = "Not run: the user sent a correction before this call started. Read it, then decide whether to call the tool again."
+
+// messageQueue holds what is typed while a turn runs: messages, sent one after the other
+// once it is over, and corrections, sent into the turn at its next step
+type messageQueue struct {
+	mu          sync.Mutex
+	lines       []string
+	corrections []string
+	paused      bool               // Whether Esc asked to pause the turn for a correction
+	cancelCall  context.CancelFunc // Stops the model call in progress; nil between calls
+}
+
+// push queues a message and returns how many are waiting
+func (q *messageQueue) push(line string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lines = append(q.lines, line)
+	return len(q.lines)
+}
+
+// pop takes the next queued message
+func (q *messageQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.lines) == 0 {
+		return "", false
+	}
+	line := q.lines[0]
+	q.lines = q.lines[1:]
+	return line, true
+}
+
+// clear drops the queued messages and corrections and returns how many there were
+func (q *messageQueue) clear() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dropped := len(q.lines) + len(q.corrections)
+	q.lines, q.corrections, q.paused = nil, nil, false
+	return dropped
+}
+
+// steer sends a correction into the turn, or asks to pause it for one when line is
+// empty. A model call in progress is stopped, so Claude takes the correction in at once.
+func (q *messageQueue) steer(line string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if line == "" {
+		q.paused = true
+	} else {
+		q.corrections = append(q.corrections, line)
+	}
+	if q.cancelCall != nil {
+		q.cancelCall()
+	}
+}
+
+// steering reports whether a correction, or a pause for one, is waiting for the turn
+func (q *messageQueue) steering() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.corrections) > 0 || q.paused
+}
+
+// takeCorrections returns the waiting corrections and whether the turn was paused for one
+func (q *messageQueue) takeCorrections() ([]string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	corrections, paused := q.corrections, q.paused
+	q.corrections, q.paused = nil, false
+	return corrections, paused
+}
+
+// requeue makes corrections that came too late for the turn the next messages
+func (q *messageQueue) requeue() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lines = append(q.corrections, q.lines...)
+	q.corrections, q.paused = nil, false
+}
+
+// startCall returns the context of a model call, which a correction cancels. endCall
+// reports whether it did, rather than Ctrl-C or an error ending the call.
+func (q *messageQueue) startCall(ctx context.Context) (context.Context, func() bool) {
+	callCtx, cancel := context.WithCancel(ctx)
+	q.mu.Lock()
+	q.cancelCall = cancel
+	q.mu.Unlock()
+	return callCtx, func() bool {
+		q.mu.Lock()
+		q.cancelCall = nil
+		q.mu.Unlock()
+		steered := callCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		return steered
+	}
+}
+
+// queueMessage queues a message typed during a turn and says so
+func queueMessage(line string) {
+	waiting := runOptions.queue.push(line)
+	note := ""
+	if waiting > 1 {
+		note = fmt.Sprintf(" (%d waiting)", waiting)
+	}
+	fmt.Fprintf(os.Stderr, "\n%s: %s%s\n", paint(roleNotice, "queued"), line, note)
+}
+
+// steerTurn sends line into the turn in progress as a correction, or pauses the turn to
+// ask for one when line is empty
+func steerTurn(line string) {
+	if !runOptions.interrupts.inTurn() {
+		return
+	}
+	runOptions.queue.steer(line)
+	if line == "" {
+		fmt.Fprintf(os.Stderr, "\n%s: pausing after the current step\n", paint(roleNotice, "steering"))
+	} else {
+		fmt.Fprintf(os.Stderr, "\n%s: %q goes to Claude at the next step\n", paint(roleNotice, "steering"), line)
+	}
+}
+
+// corrections brings the user's corrections into the turn, first asking for one if the
+// turn was paused. Each is a user message of its own, after a note saying when it came.
+func (a *Agent) corrections() []anthropic.ContentBlockParamUnion {
+	lines, paused := runOptions.queue.takeCorrections()
+	if paused && a.getUserMessage != nil {
+		fmt.Fprintf(os.Stderr, "%s: paused; type a correction, or press Enter to go on\n", paint(roleNotice, "steering"))
+		prompt(paint(roleUser, "Correction") + ": ")
+		if line, ok := a.getUserMessage(); ok && strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	blocks := []anthropic.ContentBlockParamUnion{}
+	for _, line := range lines {
+		blocks = append(blocks, anthropic.NewTextBlock(steeringNote))
+		blocks = append(blocks, a.buildUserMessage(line)...)
+	}
+	return blocks
+}
+
+// echoQueued shows a queued message after the prompt, as if it had been typed there
+func echoQueued(line string) {
+	if runOptions.tui != nil {
+		runOptions.tui.echo(line)
+		return
+	}
+	fmt.Fprintln(promptOutput(), line)
+}
+
+// typeahead reads what is typed into the line editor while a turn runs. Keys are not
+// echoed, as Claude's output is being written; Enter queues the line, Esc sends it into
+// the turn as a correction.
+type typeahead struct {
+	editor  *lineEditor
+	line    *editState
+	restore func() // Takes the terminal out of cbreak mode
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// typeAhead starts reading keys during a turn and returns the function that stops it. A
+// line typed but not sent is kept for the next prompt.
+func (e *lineEditor) typeAhead() func() {
+	if e == nil {
+		return func() {}
+	}
+	restore, err := cbreak(int(e.in.Fd()))
+	if err != nil {
+		return func() {}
+	}
+	e.typing = &typeahead{editor: e, line: &editState{index: len(e.history)}, restore: restore}
+	e.typing.resume()
+	return func() {
+		e.typing.pause()
+		e.draft = e.typing.line.line
+		e.typing = nil
+		restore()
+	}
+}
+
+// restoreTyping takes the terminal out of cbreak mode when the chat exits during a turn
+func (e *lineEditor) restoreTyping() {
+	if e == nil || e.typing == nil {
+		return
+	}
+	e.typing.restore()
+}
+
+// resume starts reading keys
+func (t *typeahead) resume() {
+	t.stop, t.done = make(chan struct{}), make(chan struct{})
+	go t.read(t.stop, t.done)
+}
+
+// pause stops reading keys, so someone else can
+func (t *typeahead) pause() {
+	close(t.stop)
+	<-t.done
+}
+
+// read handles key presses until stop is closed
+func (t *typeahead) read(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		case key, ok := <-t.editor.keyPresses():
+			if !ok {
+				return
+			}
+			t.handle(key)
+		}
+	}
+}
+
+// handle applies a key to the line typed ahead
+func (t *typeahead) handle(key string) {
+	s := t.line
+	switch key {
+	case keyEnter, keyNewline:
+		line := string(s.line)
+		t.line = &editState{index: len(t.editor.history)}
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+		t.editor.remember(line)
+		queueMessage(line)
+	case keyEsc:
+		line := string(s.line)
+		t.line = &editState{index: len(t.editor.history)}
+		if strings.TrimSpace(line) != "" {
+			t.editor.remember(line)
+		}
+		steerTurn(strings.TrimSpace(line))
+	default:
+		t.editor.edit(s, key)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// =============================================================================
+// TOKEN USAGE AND API BETAS
+// =============================================================================
+
+// API betas that can be turned on in the configuration
+const (
+	betaTokenEfficientTools      = "token-efficient-tools-2025-02-19"       // TOKEN_EFFICIENT_TOOLS; Claude 3.7 Sonnet only
+	betaFineGrainedToolStreaming = "fine-grained-tool-streaming-2025-05-14" // FINE_GRAINED_TOOL_STREAMING; applies to streamed responses
+)
+
+// UsageStats totals the tokens of a run's model calls
+type UsageStats struct {
+	Calls            int      `json:"calls"`
+	InputTokens      int64    `json:"input_tokens"`
+	OutputTokens     int64    `json:"output_tokens"`
+	CacheWriteTokens int64    `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadTokens  int64    `json:"cache_read_input_tokens,omitempty"`
+	ToolCalls        int      `json:"tool_calls"`               // Calls answered with tool use
+	ToolTokens       int64    `json:"tool_output_tokens"`       // Output tokens of those calls
+	Cost             float64  `json:"cost_usd"`                 // Estimated with the price table of go-agent prices
+	Unpriced         int      `json:"unpriced_calls,omitempty"` // Calls to models without a price, left out of Cost
+	Betas            []string `json:"betas,omitempty"`          // Betas used by at least one call
+	Models           []string `json:"models,omitempty"`         // Models that answered at least one call
+
+	Failures map[string]int `json:"failures,omitempty"` // Refusals and API failures by category
+}
+
+// sessionUsage collects usage across every agent in the process
+type sessionUsage struct {
+	mu    sync.Mutex
+	stats UsageStats
+}
+
+// record adds one model call
+func (u *sessionUsage) record(message *anthropic.Message, betas []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.stats.Calls++
+	u.stats.InputTokens += message.Usage.InputTokens
+	u.stats.OutputTokens += message.Usage.OutputTokens
+	u.stats.CacheWriteTokens += message.Usage.CacheCreationInputTokens
+	u.stats.CacheReadTokens += message.Usage.CacheReadInputTokens
+	if price, ok := modelPrice(string(message.Model)); ok {
+		u.stats.Cost += price.cost(message.Usage)
+	} else {
+		u.stats.Unpriced++
+	}
+	if message.StopReason == anthropic.StopReasonToolUse {
+		u.stats.ToolCalls++
+		u.stats.ToolTokens += message.Usage.OutputTokens
+	}
+	for _, beta := range betas {
+		if !slices.Contains(u.stats.Betas, beta) {
+			u.stats.Betas = append(u.stats.Betas, beta)
+		}
+	}
+	if !slices.Contains(u.stats.Models, string(message.Model)) {
+		u.stats.Models = append(u.stats.Models, string(message.Model))
+	}
+}
+
+// failure counts a refusal or API failure
+func (u *sessionUsage) failure(category string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stats.Failures = addCount(u.stats.Failures, category, 1)
+}
+
+// snapshot returns a copy of the totals
+func (u *sessionUsage) snapshot() UsageStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	stats := u.stats
+	stats.Betas = slices.Clone(u.stats.Betas)
+	stats.Models = slices.Clone(u.stats.Models)
+	stats.Failures = maps.Clone(u.stats.Failures)
+	return stats
+}
+
+// plus adds the counts of other, such as a run's usage to that of earlier runs of the
+// session. The models of both are kept; betas and failures are left as they are.
+func (s UsageStats) plus(other UsageStats) UsageStats {
+	for _, model := range other.Models {
+		if !slices.Contains(s.Models, model) {
+			s.Models = append(slices.Clone(s.Models), model)
+		}
+	}
+	s.Calls += other.Calls
+	s.InputTokens += other.InputTokens
+	s.OutputTokens += other.OutputTokens
+	s.CacheWriteTokens += other.CacheWriteTokens
+	s.CacheReadTokens += other.CacheReadTokens
+	s.ToolCalls += other.ToolCalls
+	s.ToolTokens += other.ToolTokens
+	s.Cost += other.Cost
+	s.Unpriced += other.Unpriced
+	return s
+}
+
+// since is the usage added after earlier, a snapshot of the same totals
+func (s UsageStats) since(earlier UsageStats) UsageStats {
+	return UsageStats{
+		Calls:            s.Calls - earlier.Calls,
+		InputTokens:      s.InputTokens - earlier.InputTokens,
+		OutputTokens:     s.OutputTokens - earlier.OutputTokens,
+		CacheWriteTokens: s.CacheWriteTokens - earlier.CacheWriteTokens,
+		CacheReadTokens:  s.CacheReadTokens - earlier.CacheReadTokens,
+		ToolCalls:        s.ToolCalls - earlier.ToolCalls,
+		ToolTokens:       s.ToolTokens - earlier.ToolTokens,
+		Cost:             s.Cost - earlier.Cost,
+		Unpriced:         s.Unpriced - earlier.Unpriced,
+	}
+}
+
+// costString shows the estimated cost, noting calls it could not price
+func (s UsageStats) costString() string {
+	cost := fmt.Sprintf("$%.2f", s.Cost)
+	if s.Cost > 0 && s.Cost < 0.01 {
+		cost = fmt.Sprintf("$%.4f", s.Cost)
+	}
+	if s.Unpriced > 0 {
+		cost += fmt.Sprintf(" (%d call(s) to unpriced models not included)", s.Unpriced)
+	}
+	return cost
+}
+
+// showUsage reports whether the chat and -p tasks print usage after every turn;
+// SHOW_USAGE=false turns it off
+func showUsage() bool {
+	return configValue("SHOW_USAGE") != "false"
+}
+
+// printTurnUsage shows the tokens and cost of the turn that started at before, and the
+// running totals of the session, including earlier runs of a resumed one
+func printTurnUsage(session *savedSession, before UsageStats) {
+	run := runOptions.usage.snapshot()
+	turn := run.since(before)
+	if !showUsage() || turn.Calls == 0 {
+		return
+	}
+	total := session.priorUsage().plus(run)
+	fmt.Fprintf(os.Stderr, "%s: %d input and %d output tokens, %s; session: %d input and %d output tokens, %s\n", paint(roleInfo, "usage"),
+		turn.InputTokens+turn.CacheWriteTokens+turn.CacheReadTokens, turn.OutputTokens, turn.costString(),
+		total.InputTokens+total.CacheWriteTokens+total.CacheReadTokens, total.OutputTokens, total.costString())
+}
+
+// String summarizes the totals, with the average cost of a tool-use response so runs with
+// and without token-efficient tool use can be compared
+func (s UsageStats) String() string {
+	summary := fmt.Sprintf("%d model call(s), %d input and %d output tokens, %s", s.Calls, s.InputTokens, s.OutputTokens, s.costString())
+	if s.CacheReadTokens > 0 || s.CacheWriteTokens > 0 {
+		summary += fmt.Sprintf("; prompt cache: %d input tokens read, %d written", s.CacheReadTokens, s.CacheWriteTokens)
+	}
+	if s.ToolCalls > 0 {
+		summary += fmt.Sprintf("; %.0f output tokens per tool-use response", float64(s.ToolTokens)/float64(s.ToolCalls))
+	}
+	if len(s.Betas) > 0 {
+		summary += " (betas: " + strings.Join(s.Betas, ", ") + ")"
+	}
+	if len(s.Failures) > 0 {
+		summary += "; failures: " + formatCounts(s.Failures)
+	}
+	return summary
+}
+
+// formatCounts lists counts by key, e.g. "overloaded 2, refusal 1"
+func formatCounts(counts map[string]int) string {
+	entries := []string{}
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		entries = append(entries, fmt.Sprintf("%s %d", key, counts[key]))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// unsupportedBetas remembers the models that rejected the configured betas
+var unsupportedBetas sync.Map
+
+// requestBetas returns the configured betas that model supports
+func requestBetas(model anthropic.Model) []string {
+	if _, rejected := unsupportedBetas.Load(model); rejected {
+		return nil
+	}
+
+	betas := []string{}
+	// Claude 4 models use token-efficient tool calls without the beta
+	if configValue("TOKEN_EFFICIENT_TOOLS") == "true" && strings.HasPrefix(string(model), "claude-3-7-sonnet") {
+		betas = append(betas, betaTokenEfficientTools)
+	}
+	if configValue("FINE_GRAINED_TOOL_STREAMING") == "true" {
+		betas = append(betas, betaFineGrainedToolStreaming)
+	}
+	return betas
+}
+
+// newMessage sends a model call with the configured betas, falling back to a plain call
+// when the API rejects them, and records its usage
+func (a *Agent) newMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	betas := requestBetas(params.Model)
+	opts := filesOptions(params.Messages)
+	for _, beta := range betas {
+		opts = append(opts, option.WithHeaderAdd("anthropic-beta", beta))
+	}
+
+	message, err := a.sendWithRetry(ctx, params, opts...)
+	var apiErr *anthropic.Error
+	if len(betas) > 0 && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(apiErr.Error()), "beta") {
+		fmt.Fprintf(os.Stderr, "%s: %s does not support %s; continuing without\n", paint(roleWarning, "warning"), params.Model, strings.Join(betas, ", "))
+		unsupportedBetas.Store(params.Model, true)
+		betas = nil
+		message, err = a.sendWithRetry(ctx, params, filesOptions(params.Messages)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	runOptions.usage.record(message, betas)
+	return message, nil
+}
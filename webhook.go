@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// =============================================================================
+// FORGE WEBHOOK ADAPTER
+// =============================================================================
+
+// maxWebhookBytes bounds the size of a webhook payload
+const maxWebhookBytes = 5 << 20
+
+// webhookQueueSize is how many tasks may wait while the agent is busy
+const webhookQueueSize = 16
+
+// webhookForge is a forge that can turn its webhook deliveries into tasks
+type webhookForge interface {
+	Forge
+	// verifyWebhook checks that a delivery was signed with the shared secret
+	verifyWebhook(header http.Header, body []byte, secret string) bool
+	// webhookTask maps a delivery to a task, or explains why it is ignored
+	webhookTask(ctx context.Context, header http.Header, body []byte) (*forgeTask, string)
+}
+
+// runWebhookCommand implements `go-agent webhook`
+func runWebhookCommand(args []string) error {
+	flags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	addr := flags.String("addr", ":8080", "address to listen on for webhook deliveries")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	secret := configValue("WEBHOOK_SECRET")
+	if secret == "" {
+		return fmt.Errorf("WEBHOOK_SECRET must be set to the secret configured for the webhook")
+	}
+
+	ctx := context.TODO()
+
+	detected, err := detectForge(ctx)
+	if err != nil {
+		return err
+	}
+	forge, ok := detected.(webhookForge)
+	if !ok {
+		return fmt.Errorf("%s does not support webhooks", detected.Name())
+	}
+
+	client, err := initializeClient()
+	if err != nil {
+		return err
+	}
+
+	// Tasks share the working tree, so they run one at a time
+	queue := make(chan *forgeTask, webhookQueueSize)
+	go func() {
+		for task := range queue {
+			if err := runForgeTask(ctx, client, forge, task); err != nil {
+				fmt.Fprintf(os.Stderr, "webhook: #%d failed: %s\n", task.Ref.Number, err.Error())
+			}
+		}
+	}()
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !forge.verifyWebhook(r.Header, body, secret) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		task, reason := forge.webhookTask(r.Context(), r.Header, body)
+		if task == nil {
+			fmt.Fprintf(w, "ignored: %s\n", reason)
+			return
+		}
+
+		select {
+		case queue <- task:
+			fmt.Fprintf(os.Stderr, "webhook: queued #%d\n", task.Ref.Number)
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintln(w, "queued")
+		default:
+			http.Error(w, "agent is busy", http.StatusServiceUnavailable)
+		}
+	})
+
+	fmt.Fprintf(os.Stderr, "webhook: listening for %s deliveries on %s\n", forge.Name(), *addr)
+	return http.ListenAndServe(*addr, nil)
+}
+
+// validHMAC checks a hex-encoded HMAC-SHA256 signature of body, with an optional sha256= prefix
+func validHMAC(signature string, body []byte, secret string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
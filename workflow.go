@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// =============================================================================
+// WORKFLOW HELPERS
+// =============================================================================
+
+// CommandResult captures the outcome of a shell command run on behalf of a workflow
+type CommandResult struct {
+	Command  string
+	Output   string // Combined stdout and stderr
+	ExitCode int
+}
+
+// Passed reports whether the command exited successfully
+func (r CommandResult) Passed() bool {
+	return r.ExitCode == 0
+}
+
+// runShellCommand runs command through sh and captures its combined output.
+// A non-zero exit is reported in the result; err is only set if the command could not run.
+func runShellCommand(ctx context.Context, command string) (CommandResult, error) {
+	return runCommand(exec.CommandContext(ctx, "sh", "-c", command), command)
+}
+
+// runProgram runs a program with explicit arguments, avoiding shell quoting
+func runProgram(ctx context.Context, name string, args ...string) (CommandResult, error) {
+	return runCommand(exec.CommandContext(ctx, name, args...), strings.Join(append([]string{name}, args...), " "))
+}
+
+// runCommand executes cmd and folds a non-zero exit status into the result
+func runCommand(cmd *exec.Cmd, display string) (CommandResult, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	ownProcessGroup(cmd)
+	err := runOptions.cleanup.runProcess(cmd)
+
+	result := CommandResult{Command: display, Output: output.String()}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to run %q: %w", display, err)
+	}
+
+	return result, nil
+}
+
+// tailToTokens keeps roughly the last tokens worth of text, where failures usually are
+func tailToTokens(text string, tokens int) string {
+	limit := tokens * 4
+	if limit >= len(text) {
+		return text
+	}
+
+	cut := text[len(text)-limit:]
+	if i := strings.IndexByte(cut, '\n'); i >= 0 {
+		cut = cut[i+1:]
+	}
+
+	return fmt.Sprintf("[truncated: showing the last ~%d of ~%d tokens]\n", estimateTokens(cut), estimateTokens(text)) + cut
+}
+
+// prepareEdit returns the path an edit_file call targets, capturing it in the
+// agent's snapshot (if any) before it changes
+func (a *Agent) prepareEdit(input json.RawMessage) string {
+	editFileInput := EditFileInput{}
+	if err := json.Unmarshal(input, &editFileInput); err != nil || editFileInput.Path == "" {
+		return ""
+	}
+	if a.snapshot != nil {
+		a.snapshot.Add(editFileInput.Path)
+	}
+	if runOptions.patch != nil {
+		runOptions.patch.Add(editFileInput.Path)
+	}
+	return editFileInput.Path
+}
+
+// fixUntilPasses drives the agent to make a failing command pass, re-running it after
+// each turn. It returns the last result and the number of iterations used.
+func fixUntilPasses(ctx context.Context, agent *Agent, result CommandResult, maxIterations int, label string) (CommandResult, int, error) {
+	conversation := []anthropic.MessageParam{}
+	prompt := fmt.Sprintf(fixPrompt, result.Command, result.ExitCode, tailToTokens(result.Output, toolResultMaxTokens()))
+
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		fmt.Fprintf(os.Stderr, "%s: iteration %d/%d\n", paint(roleStatus, label), iteration, maxIterations)
+
+		var err error
+		conversation = append(conversation, anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)))
+		conversation, err = agent.runTurn(ctx, conversation)
+		if err != nil {
+			return result, iteration, err
+		}
+
+		result, err = runShellCommand(ctx, result.Command)
+		if err != nil {
+			return result, iteration, err
+		}
+		if result.Passed() {
+			return result, iteration, nil
+		}
+
+		prompt = fmt.Sprintf(fixRetryPrompt, result.Command, result.ExitCode, tailToTokens(result.Output, toolResultMaxTokens()))
+	}
+
+	return result, maxIterations, nil
+}
+
+// printChangedFiles lists the files the agent edited during a workflow
+func (a *Agent) printChangedFiles() {
+	if len(a.editedFiles) == 0 {
+		fmt.Println("No files were changed.")
+		return
+	}
+
+	fmt.Println("Files changed:")
+	for _, path := range a.editedFiles {
+		fmt.Printf("  - %s\n", path)
+	}
+}
+
+// askClaude sends a single prompt without tools and returns the text of the reply
+func askClaude(ctx context.Context, client *anthropic.Client, prompt string, maxTokens int64) (string, error) {
+	message, err := client.Messages.New(ctx, anthropic.MessageNewParams{
+		Model:     anthropic.ModelClaude3_7SonnetLatest,
+		MaxTokens: maxTokens,
+		Messages:  []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt))},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	for _, content := range message.Content {
+		if content.Type == "text" {
+			text.WriteString(content.Text)
+		}
+	}
+	return text.String(), nil
+}
+
+// lastAssistantText returns the text of the final assistant message in a conversation
+func lastAssistantText(conversation []anthropic.MessageParam) string {
+	for i := len(conversation) - 1; i >= 0; i-- {
+		if conversation[i].Role != anthropic.MessageParamRoleAssistant {
+			continue
+		}
+		var text strings.Builder
+		for _, block := range conversation[i].Content {
+			if block.OfText != nil {
+				text.WriteString(block.OfText.Text)
+			}
+		}
+		return text.String()
+	}
+	return ""
+}